@@ -17,37 +17,76 @@ package broker
 
 import (
 	"context"
+	"sync"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 )
 
-// BlockingChannel implements the Queue interface using a channel.
+// BlockingChannel implements the Queue interface using a channel. Once the consumer has stopped, the events pushed
+// are dropped instead of blocking the collectors forever.
 type BlockingChannel struct {
 	channel        chan events.Interface
 	metricsHandler *metrics
+	// stopped is closed when the consumer stops.
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
 // NewBlockingChannel returns a BlockingChannel.
-func NewBlockingChannel(bufferLen int) *BlockingChannel {
+func NewBlockingChannel(bufferLen int, opt ...QueueOption) *BlockingChannel {
 	return &BlockingChannel{
 		channel:        make(chan events.Interface, bufferLen),
-		metricsHandler: newMetrics("blockingChannel"),
+		metricsHandler: newMetrics(newQueueOptions(opt).metrics, "blockingChannel"),
+		stopped:        make(chan struct{}),
 	}
 }
 
-// Push pushes an event to the queue.
-func (bc *BlockingChannel) Push(evt events.Interface) {
+// Push pushes an event to the queue. It returns ErrQueueClosed if the consumer stops before receiving the event.
+func (bc *BlockingChannel) Push(evt events.Interface) error {
 	bc.metricsHandler.send(evt)
-	bc.channel <- evt
+	// The select below picks at random when both cases are ready, the stopped consumer is checked first so that an
+	// event is never left in a buffer nobody reads.
+	select {
+	case <-bc.stopped:
+		bc.metricsHandler.drop(evt)
+		return ErrQueueClosed
+	default:
+	}
+	select {
+	case bc.channel <- evt:
+		return nil
+	case <-bc.stopped:
+		bc.metricsHandler.drop(evt)
+		return ErrQueueClosed
+	}
+}
+
+// Closed returns true once the consumer has stopped, the events pushed are dropped.
+func (bc *BlockingChannel) Closed() bool {
+	if bc == nil {
+		return true
+	}
+	select {
+	case <-bc.stopped:
+		return true
+	default:
+		return false
+	}
 }
 
-// Pop an event from the queue.
+// Pop an event from the queue. Once the context is done, the consumer is considered stopped.
 func (bc *BlockingChannel) Pop(ctx context.Context) events.Interface {
+	// A done context stops the consumer even if events are ready, the pushers blocked on the channel are released.
+	if ctx.Err() != nil {
+		bc.stopOnce.Do(func() { close(bc.stopped) })
+		return nil
+	}
 	select {
 	case evt := <-bc.channel:
 		bc.metricsHandler.receive(evt)
 		return evt
 	case <-ctx.Done():
+		bc.stopOnce.Do(func() { close(bc.stopped) })
 		return nil
 	}
 }
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBlockingChannelMetrics(t *testing.T) {
+	const kind = "QueueTest"
+	bc := NewBlockingChannel(10)
+	newEvent := func() events.Interface {
+		return &events.Event{Event: &metadata.Event{Kind: kind, Reason: events.Create}}
+	}
+	depth := defaultQueueMetrics.depth.WithLabelValues("blockingChannel", kind)
+	pushes := defaultQueueMetrics.pushes.WithLabelValues("blockingChannel", kind)
+	pops := defaultQueueMetrics.pops.WithLabelValues("blockingChannel", kind)
+
+	// The events are pushed without a consumer.
+	first := newEvent()
+	_ = bc.Push(first)
+	_ = bc.Push(newEvent())
+	if got := testutil.ToFloat64(depth); got != 2 {
+		t.Errorf("expected a depth of 2, got %v", got)
+	}
+	if got := testutil.ToFloat64(pushes); got != 2 {
+		t.Errorf("expected 2 pushes, got %v", got)
+	}
+	start := time.Now()
+	if age := bc.metricsHandler.oldestAges(start.Add(time.Minute))[kind]; age < time.Minute.Seconds() {
+		t.Errorf("expected the oldest event to be at least one minute old, got %vs", age)
+	}
+
+	// Popping the head of the queue makes the next event the oldest one.
+	if evt := bc.Pop(context.Background()); evt != first {
+		t.Fatalf("expected the first event to be popped, got %v", evt)
+	}
+	if got := testutil.ToFloat64(depth); got != 1 {
+		t.Errorf("expected a depth of 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(pops); got != 1 {
+		t.Errorf("expected 1 pop, got %v", got)
+	}
+
+	// Once the queue is drained the age of the oldest event drops to zero.
+	bc.Pop(context.Background())
+	if age, ok := bc.metricsHandler.oldestAges(time.Now())[kind]; !ok || age != 0 {
+		t.Errorf("expected a zero age for the drained queue, got %v", age)
+	}
+	if got := testutil.ToFloat64(depth); got != 0 {
+		t.Errorf("expected an empty queue, got a depth of %v", got)
+	}
+	if n := testutil.CollectAndCount(defaultQueueMetrics.oldestAge); n == 0 {
+		t.Error("expected the oldest event age to be collected")
+	}
+}
+
+func TestBlockingChannelTypeDepth(t *testing.T) {
+	bc := NewBlockingChannel(10)
+	depths := map[string]float64{}
+	for _, evtType := range []string{events.Create, events.Update, events.Delete} {
+		depths[evtType] = testutil.ToFloat64(defaultQueueMetrics.typeDepth.WithLabelValues("blockingChannel", evtType))
+	}
+	// assertDepths checks the depth of each event type against the one at the start of the test.
+	assertDepths := func(step string, want map[string]float64) {
+		t.Helper()
+		for evtType, start := range depths {
+			if got := testutil.ToFloat64(defaultQueueMetrics.typeDepth.WithLabelValues("blockingChannel", evtType)) - start; got != want[evtType] {
+				t.Errorf("%s: expected a depth of %v for the %s events, got %v", step, want[evtType], evtType, got)
+			}
+		}
+	}
+	push := func(evtType string) {
+		_ = bc.Push(&events.Event{Event: &metadata.Event{Kind: "TypeDepthTest", Reason: evtType}})
+	}
+
+	push(events.Create)
+	push(events.Delete)
+	push(events.Delete)
+	assertDepths("pushed", map[string]float64{events.Create: 1, events.Delete: 2})
+
+	bc.Pop(context.Background())
+	bc.Pop(context.Background())
+	assertDepths("popped", map[string]float64{events.Delete: 1})
+
+	bc.Pop(context.Background())
+	assertDepths("drained", nil)
+}
+
+func TestBlockingChannelStopped(t *testing.T) {
+	const kind = "StoppedQueueTest"
+	bc := NewBlockingChannel(1)
+	dropped := defaultQueueMetrics.dropped.WithLabelValues("blockingChannel", kind)
+	droppedBefore := testutil.ToFloat64(dropped)
+	newEvent := func() events.Interface {
+		return &events.Event{Event: &metadata.Event{Kind: kind, Reason: events.Create}}
+	}
+
+	if IsClosed(bc) {
+		t.Fatal("expected the queue to be open while the consumer runs")
+	}
+
+	// The push blocks on the full channel until the consumer stops.
+	_ = bc.Push(newEvent())
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- bc.Push(newEvent())
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if evt := bc.Pop(ctx); evt != nil {
+		t.Fatalf("expected the stopped consumer to receive nothing, got %v", evt)
+	}
+	select {
+	case err := <-pushed:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("expected the blocked push to fail with %v, got %v", ErrQueueClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the push to be unblocked once the consumer stopped")
+	}
+	if !IsClosed(bc) {
+		t.Error("expected the queue to be closed once the consumer stopped")
+	}
+
+	// The events pushed once the consumer has stopped are dropped, even when the channel has room for them.
+	<-bc.channel
+	for i := 0; i < 3; i++ {
+		if err := bc.Push(newEvent()); !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("push %d: expected %v, got %v", i, ErrQueueClosed, err)
+		}
+	}
+	if got := testutil.ToFloat64(dropped) - droppedBefore; got != 4 {
+		t.Errorf("expected the blocked event and the 3 pushed ones to be dropped, got %v", got)
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	var nilChannel *BlockingChannel
+	tests := map[string]struct {
+		queue Queue
+		want  bool
+	}{
+		"nil queue":            {queue: nil, want: true},
+		"nil blocking channel": {queue: nilChannel, want: true},
+		"blocking channel":     {queue: NewBlockingChannel(1), want: false},
+		"ring buffer":          {queue: NewRingBuffer(1), want: false},
+	}
+	for name, tt := range tests {
+		if got := IsClosed(tt.queue); got != tt.want {
+			t.Errorf("%s: expected closed %v, got %v", name, tt.want, got)
+		}
+	}
+}
@@ -17,43 +17,92 @@ package broker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/audit"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
+// listenerName is the name of the listener of the broker in the sync status.
+const listenerName = "broker"
+
+// listenerRestartDelay is how long the broker waits before listening again when its listener fails.
+const listenerRestartDelay = time.Second
+
+// healthServices are the services whose status is reported by the grpc health service: the server as a whole and the
+// metadata service.
+var healthServices = []string{"", metadata.Metadata_ServiceDesc.ServiceName}
+
+// shutdownTimeout is how long the broker waits for the connections to close when it stops, before giving up on the
+// collectors that no longer receive the subscriptions.
+const shutdownTimeout = 10 * time.Second
+
 // Broker receives events from the collectors and sends them to the subscribers.
 type Broker struct {
 	queue         Queue
 	subscribers   *sync.Map
 	logger        logr.Logger
 	server        *grpc.Server
+	metaServer    *metadata.Server
 	connectionsWg *sync.WaitGroup
 	opt           options
 	eventMetrics  map[string]dispatchedEventsMetrics
+	metrics       *brokerMetrics
+	// throttles are stored using as key the UID of the subscriber and as value its throttle.
+	throttles *sync.Map
+	// throttleMutex guards the settings of the throttling, updated at runtime, and the creation of the throttles.
+	throttleMutex sync.Mutex
+	// kinds are the resource kinds of the running collectors.
+	kinds []string
+	// health serves the grpc health service, reporting the broker as serving once the readiness barrier is lifted.
+	health *grpchealth.Server
+	// stopped is canceled when the broker stops, to close the in-process subscriptions.
+	stopped context.Context
+	stop    context.CancelFunc
+	// abort is closed when the broker gives up on the collectors during the shutdown.
+	abort chan struct{}
 }
 
 // New returns a new Broker.
 func New(logger logr.Logger, queue Queue, collectors map[string]subscriber.SubsChan, opt ...Option) (*Broker, error) {
-	var grpcServer *grpc.Server
 	subs := &sync.Map{}
 	group := &sync.WaitGroup{}
 
 	// Apply options received from the flags.
-	opts := options{}
+	opts := options{rpcLogVerbosity: defaultRPCLogVerbosity}
 	for _, o := range opt {
 		o(&opts)
 	}
+	if err := opts.validate(queue); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	brokerMetrics, err := registerBrokerMetrics(opts.metricsRegisterer, opts.metricsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to register the metrics: %w", err)
+	}
+
+	// Each RPC is counted, logged and recovered from panics, before running the interceptors of the options.
+	serverOpts := interceptors(logger.WithName("rpc"), brokerMetrics, &opts)
 
-	// They should be both set, but here we prefer to return an error so the user knows that one of the paths
-	// is missing rather than explicitly validating the file paths.
+	// Both the paths are set, the files are validated while loading the credentials.
 	if opts.tlsServerKeyFilePath != "" || opts.tlsServerCertFilePath != "" {
 		creds, err := credentials.NewServerTLSFromFile(opts.tlsServerCertFilePath, opts.tlsServerKeyFilePath)
 		if err != nil {
@@ -62,34 +111,78 @@ func New(logger logr.Logger, queue Queue, collectors map[string]subscriber.SubsC
 			return nil, err
 		}
 
-		grpcServer = grpc.NewServer(grpc.Creds(creds))
-	} else {
-		grpcServer = grpc.NewServer()
+		serverOpts = append(serverOpts, grpc.Creds(creds))
 	}
+	grpcServer := grpc.NewServer(serverOpts...)
+
+	// The broker is not ready until it accepts connections.
+	opts.syncStatus.RegisterListener(listenerName)
 
 	// Register grpc server.
-	metadata.RegisterMetadataServer(grpcServer, metadata.New(logger.WithName("grpc-server"), subs, collectors, group))
+	abort := make(chan struct{})
+	metaServer, err := metadata.New(logger.WithName("grpc-server"), subs, collectors, group,
+		metadata.WithDryRun(opts.dryRun), metadata.WithBarrier(opts.barrier),
+		metadata.WithMetricsRegisterer(opts.metricsRegisterer), metadata.WithMetricsPrefix(opts.metricsPrefix),
+		metadata.WithMaxBackfills(opts.maxBackfills), metadata.WithNodeMetrics(opts.nodeMetrics),
+		metadata.WithNodeTakeover(opts.nodeTakeover),
+		metadata.WithMaxMessageSize(opts.maxMessageSize), metadata.WithAbort(abort),
+		metadata.WithResyncInterval(opts.resyncInterval),
+		// The ResyncStart event precedes in the queue the events of the resources replayed for a subscriber. The queue
+		// is closed once the broker stops, the subscriber is disconnected anyway.
+		metadata.WithResyncStart(func(uid, node string) {
+			_ = queue.Push(events.NewResyncStart(uid, node, opts.clusterName))
+		}),
+		// The SnapshotComplete event follows in the queue the events of the existing resources.
+		metadata.WithSnapshotComplete(func(uid, node string) {
+			_ = queue.Push(events.NewSnapshotComplete(uid, node, opts.clusterName))
+		}))
+	if err != nil {
+		return nil, err
+	}
+	metadata.RegisterMetadataServer(grpcServer, metaServer)
+	// The broker serves the subscriptions once the readiness barrier is lifted.
+	healthServer := grpchealth.NewServer()
+	for _, service := range healthServices {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if opts.reflection {
+		reflection.Register(grpcServer)
+	}
 
 	// Create the metrics for each running collector.
 	// The name of the collector is the resource kind. Same as the kind we find
 	// in the events.
 	eventMetrics := make(map[string]dispatchedEventsMetrics, len(collectors))
+	kinds := make([]string, 0, len(collectors))
 	for collector := range collectors {
-		eventMetrics[collector] = newDispatchedEventsMetrics(collector)
+		eventMetrics[collector] = newDispatchedEventsMetrics(brokerMetrics.dispatchedEvents, collector)
+		kinds = append(kinds, collector)
 	}
+	sort.Strings(kinds)
+	stopped, stop := context.WithCancel(context.Background())
 
 	return &Broker{
 		queue:         queue,
 		subscribers:   subs,
 		logger:        logger,
 		server:        grpcServer,
+		metaServer:    metaServer,
+		health:        healthServer,
 		connectionsWg: group,
 		opt:           opts,
 		eventMetrics:  eventMetrics,
+		metrics:       brokerMetrics,
+		throttles:     &sync.Map{},
+		kinds:         kinds,
+		stopped:       stopped,
+		stop:          stop,
+		abort:         abort,
 	}, nil
 }
 
-// Start starts the grpc server and sends to subscribers the events received from the collectors.
+// Start starts the grpc server and sends to subscribers the events received from the collectors. When the listener
+// fails the broker listens again, without stopping the manager and hence the collectors.
 func (br *Broker) Start(ctx context.Context) error {
 	br.logger.Info("starting grpc server", "addr", br.opt.address)
 	// Start the grpc server.
@@ -98,20 +191,50 @@ func (br *Broker) Start(ctx context.Context) error {
 		return fmt.Errorf("an error occurred whil creating listener for grpc server: %w", err)
 	}
 
-	serverError := make(chan error)
+	return br.serve(ctx, lis, func() (net.Listener, error) {
+		return net.Listen("tcp", br.opt.address)
+	})
+}
+
+// serve serves the grpc server on the given listener and sends to subscribers the events received from the
+// collectors, until the context is canceled. When the listener fails, the broker keeps sending the events to the
+// connected subscribers and serves a new listener returned by listen, retrying every listenerRestartDelay. The
+// subscriptions channels of the collectors are never closed, so the collectors are not affected. Without listen, the
+// error of the listener is returned.
+func (br *Broker) serve(ctx context.Context, lis net.Listener, listen func() (net.Listener, error)) error {
+	// The channel is buffered so that the serving goroutine does not leak when the broker stops first.
+	serverError := make(chan error, 1)
+	// The connections are accepted by the listener, and queued until served.
+	br.opt.syncStatus.SetListening(listenerName, true)
+	defer br.opt.syncStatus.SetListening(listenerName, false)
 	go func() {
 		serverError <- br.server.Serve(lis)
 	}()
+	go br.serveHealth(ctx)
+	popped := make(chan struct{})
 	go func() {
+		defer close(popped)
 		for {
 			evt := br.queue.Pop(ctx)
 
 			if evt == nil {
 				break
 			}
+			// The queue dropped events, their subscribers must resync.
+			if marker, ok := evt.(*ResyncMarker); ok {
+				br.resync(marker.Subscribers())
+				continue
+			}
+			delivered := br.opt.progress.Start()
 
 			br.logger.V(7).Info("received event", "event:", evt.String())
+			observeSince(br.metrics.queueWait, evt.CreatedAt(), evt.ResourceKind(), evt.Type())
 
+			// The delivery span is a child of the reconcile that generated the event.
+			deliverCtx, span := tracing.StartWithParent(ctx, evt.SpanContext(), "deliver", evt.ResourceKind(), "",
+				tracing.ReasonKey.String(evt.Type()), tracing.SubscribersKey.Int(len(evt.Subscribers())))
+			msgs := newEncodings(evt.GRPCMessage())
+			var nodes []string
 			for sub := range evt.Subscribers() {
 				// Get the grpc stream for the subscriber.
 				c, ok := br.subscribers.Load(sub)
@@ -123,27 +246,325 @@ func (br *Broker) Start(ctx context.Context) error {
 					br.logger.Error(fmt.Errorf("failed to cast subscriber connection %T", con), "subscriber", sub)
 					continue
 				}
-				if err := con.Stream.Send(evt.GRPCMessage()); err != nil {
-					con.Close(err)
+				if replaced(evt, con.Selector) {
+					if evt.Type() == events.Delete {
+						br.discard(sub, evt.GRPCMessage().GetUid())
+					}
+					continue
+				}
+				// Each subscriber gets its own span, so that a slow stream stands out in the trace.
+				_, sendSpan := tracing.Start(deliverCtx, "send", evt.ResourceKind(), "",
+					tracing.NodeKey.String(con.Selector.GetNodeName()))
+				br.send(sub, con, msgs.get(formatOf(con.Selector)), evt.CreatedAt())
+				sendSpan.End()
+				if br.opt.audit != nil {
+					nodes = append(nodes, con.Selector.GetNodeName())
 				}
 				br.eventMetricsHandler(evt)
 			}
+			span.End()
+			br.audit(evt, nodes)
+			delivered()
 		}
 	}()
 
+	var restart <-chan time.Time
+	for {
+		select {
+		// Wait for the context to be canceled. In that case we gracefully stop the broker.
+		case <-ctx.Done():
+			br.logger.Info("Shutdown signal received, waiting for grpc connections to close")
+			// The health service reports the broker as not serving from now on.
+			br.health.Shutdown()
+			br.server.Stop()
+			// The in-process subscriptions are not tied to the grpc server.
+			br.stop()
+			<-popped
+			if !waitTimeout(br.connectionsWg, shutdownTimeout) {
+				// The collectors stopped before receiving the unsubscriptions, don't wait for them anymore.
+				br.logger.Info("Connections still open after the shutdown timeout, giving up on the collectors")
+				close(br.abort)
+				br.connectionsWg.Wait()
+			}
+			br.logger.Info("All grpc connections closed")
+			return nil
+		// If the grpc server errors and can't listen again, the error is returned and the manager is stopped causing
+		// the application to exit.
+		case err := <-serverError:
+			if listen == nil || err == nil || errors.Is(err, grpc.ErrServerStopped) {
+				br.logger.Error(err, "grpc server failed to start")
+				return err
+			}
+			// The connections already accepted are still served.
+			br.logger.Error(err, "grpc listener failed, listening again", "delay", listenerRestartDelay)
+			br.opt.syncStatus.SetListening(listenerName, false)
+			br.metrics.listenerRestarts.Inc()
+			restart = time.After(listenerRestartDelay)
+		case <-restart:
+			restart = nil
+			lis, err := listen()
+			if err != nil {
+				br.logger.Error(err, "unable to listen again", "addr", br.opt.address, "delay", listenerRestartDelay)
+				restart = time.After(listenerRestartDelay)
+				continue
+			}
+			br.logger.Info("grpc server listening again", "addr", br.opt.address)
+			br.opt.syncStatus.SetListening(listenerName, true)
+			go func() {
+				serverError <- br.server.Serve(lis)
+			}()
+		}
+	}
+}
+
+// serveHealth reports the services of the broker as serving through the grpc health service once the readiness
+// barrier is lifted, unless the context is canceled first.
+func (br *Broker) serveHealth(ctx context.Context) {
+	if br.opt.barrier != nil {
+		select {
+		case <-br.opt.barrier.Lifted():
+		case <-ctx.Done():
+			return
+		}
+	}
+	for _, service := range healthServices {
+		br.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// waitTimeout waits for the wait group up to the given timeout. It returns false if the timeout expired.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	select {
-	// Wait for the context to be canceled. In that case we gracefully stop the broker.
-	case <-ctx.Done():
-		br.logger.Info("Shutdown signal received, waiting for grpc connections to close")
-		br.server.Stop()
-		br.connectionsWg.Wait()
-		br.logger.Info("All grpc connections closed")
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// resync disconnects the given subscribers, that missed events dropped by the queue. They subscribe again and receive
+// the existing resources.
+func (br *Broker) resync(subs fields.Subscribers) {
+	for sub := range subs {
+		c, ok := br.subscribers.Load(sub)
+		if !ok {
+			continue
+		}
+		con, ok := c.(metadata.Connection)
+		if !ok {
+			continue
+		}
+		br.logger.Info("events dropped by the queue, disconnecting the subscriber to resync", "subscriber", sub,
+			"node", con.Selector.GetNodeName())
+		con.Close(&statusError{
+			status: status.New(codes.DataLoss, "events dropped by the metacollector, subscribe again to resync"),
+			cause:  ErrQueueFull,
+		})
+	}
+}
+
+// send sends the message, generated at the given time, to the subscriber through its throttle if the throttling
+// is enabled.
+func (br *Broker) send(sub string, con metadata.Connection, msg *metadata.Event, created time.Time) {
+	t, ok := br.throttle(sub, con)
+	if !ok {
+		if err := br.deliver(con, msg, created); err != nil {
+			con.Close(err)
+		}
+		return
+	}
+	t.push(msg, created)
+}
+
+// throttle returns the throttle of the subscriber, creating it if needed. It returns false if the throttling is
+// disabled.
+func (br *Broker) throttle(sub string, con metadata.Connection) (*throttle, bool) {
+	br.throttleMutex.Lock()
+	defer br.throttleMutex.Unlock()
+
+	if br.opt.throttleRate <= 0 {
+		return nil, false
+	}
+
+	t, ok := br.throttles.Load(sub)
+	if !ok {
+		t = newThrottle(br.opt.throttleRate, br.opt.throttleBurst, br.opt.maxDeleteDelay, br.metrics.throttledEvents)
+		br.throttles.Store(sub, t)
+		// The throttle lives as long as the stream of the subscriber.
+		go func() {
+			defer br.throttles.Delete(sub)
+			if err := t.(*throttle).run(con.Stream.Context(), func(msg *metadata.Event, created time.Time) error {
+				return br.deliver(con, msg, created)
+			}); err != nil {
+				con.Close(err)
+			}
+		}()
+	}
+	return t.(*throttle), true
+}
+
+// SetThrottle updates the rate and the burst of the events sent to each subscriber, including the connected ones.
+// The throttling can not be enabled or disabled at runtime: it returns an error if the rate is not positive while
+// the throttling is enabled, or the other way around.
+func (br *Broker) SetThrottle(eventsPerSecond float64, burst int) error {
+	br.throttleMutex.Lock()
+	defer br.throttleMutex.Unlock()
+
+	if (eventsPerSecond > 0) != (br.opt.throttleRate > 0) {
+		return fmt.Errorf("the throttling can not be enabled or disabled at runtime, a restart is required")
+	}
+	if eventsPerSecond <= 0 {
 		return nil
-	// If the grpc server errors, the error is returned and the manager is stopped causing the application to exit.
-	case err := <-serverError:
-		br.logger.Error(err, "grpc server failed to start")
-		return err
 	}
+	if burst < 1 {
+		return fmt.Errorf("burst must be at least 1, got %d", burst)
+	}
+
+	br.opt.throttleRate = eventsPerSecond
+	br.opt.throttleBurst = burst
+	br.throttles.Range(func(_, t any) bool {
+		t.(*throttle).setLimit(eventsPerSecond, burst)
+		return true
+	})
+	return nil
+}
+
+// audit records the event sent to the subscribers of the given nodes, if the audit is enabled.
+func (br *Broker) audit(evt events.Interface, nodes []string) {
+	if br.opt.audit == nil || len(nodes) == 0 {
+		return
+	}
+	// Several subscribers can run on the same node.
+	sort.Strings(nodes)
+	nodes = slices.Compact(nodes)
+	origin := evt.Origin()
+	br.opt.audit.Record(audit.Record{
+		Time:      time.Now(),
+		Collector: origin.Collector,
+		Type:      evt.Type(),
+		Kind:      evt.ResourceKind(),
+		Namespace: origin.Name.Namespace,
+		Name:      origin.Name.Name,
+		UID:       evt.GRPCMessage().GetUid(),
+		Nodes:     nodes,
+	})
+}
+
+// deliver delivers the message to the subscriber, and records the time elapsed since its generation. The messages
+// that can not be serialized are dropped instead of closing the stream of the subscriber, that would fail again on
+// them once subscribed again.
+func (br *Broker) deliver(con metadata.Connection, msg *metadata.Event, created time.Time) (err error) {
+	// A panic while sending to a subscriber closes its stream, the other subscribers keep receiving their events.
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(br.logger, "deliver", r)
+		}
+	}()
+	err = deliver(con, patched(msg), br.opt.sendTimeout)
+	if err == nil {
+		observeSince(br.metrics.deliveryLatency, created, msg.Kind, msg.Reason)
+		// The stream of a SNAPSHOT subscription is closed cleanly once the end of its snapshot has been sent.
+		if msg.Reason == events.SnapshotComplete && con.Selector.GetMode() == metadata.Mode_SNAPSHOT {
+			con.Close(nil)
+		}
+	}
+	var serializationErr *events.SerializationError
+	if errors.As(err, &serializationErr) {
+		br.logger.Error(err, "dropping event", "node", con.Selector.GetNodeName())
+		return nil
+	}
+	return err
+}
+
+// deliver writes the message on the stream of the subscriber. If the timeout is positive and the subscriber does not
+// receive the message in time, its stream is closed: that unblocks the send.
+func deliver(con metadata.Connection, msg *metadata.Event, timeout time.Duration) error {
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			con.Close(&statusError{
+				status: status.New(codes.DeadlineExceeded, fmt.Sprintf("event not received within %s", timeout)),
+				cause:  ErrSendTimeout,
+			})
+		})
+		defer timer.Stop()
+	}
+	if err := con.Send(msg); err != nil {
+		return sendError(con, msg, err)
+	}
+	return nil
+}
+
+// SubscriberState is a snapshot of the state of a connected subscriber.
+type SubscriberState struct {
+	UID       string    `json:"uid"`
+	Node      string    `json:"node"`
+	Connected time.Time `json:"connected"`
+	// ResourceKinds and Encoding are the filters negotiated by the subscriber in its selector.
+	ResourceKinds []string `json:"resourceKinds"`
+	Encoding      string   `json:"encoding"`
+	// Sent is the number of events sent to the subscriber, and LastSent the time of the last one.
+	Sent     uint64    `json:"sent"`
+	LastSent time.Time `json:"lastSent"`
+	// Pending is the number of events waiting in the throttle of the subscriber, and LagSeconds the time the oldest
+	// of them has been waiting. Both are zero if the throttling is disabled.
+	Pending    int     `json:"pending"`
+	LagSeconds float64 `json:"lagSeconds"`
+}
+
+// SubscriberStates returns a snapshot of the state of the connected subscribers, sorted by node. It does not block
+// the delivery of the events.
+func (br *Broker) SubscriberStates() []SubscriberState {
+	now := time.Now()
+	var states []SubscriberState
+	br.subscribers.Range(func(key, value any) bool {
+		con, ok := value.(metadata.Connection)
+		if !ok {
+			return true
+		}
+		state := SubscriberState{
+			UID:       key.(string),
+			Node:      con.Selector.GetNodeName(),
+			Connected: con.Connected,
+			Encoding:  con.Selector.GetEncoding().String(),
+		}
+		for kind := range con.Selector.GetResourceKinds() {
+			state.ResourceKinds = append(state.ResourceKinds, kind)
+		}
+		sort.Strings(state.ResourceKinds)
+		state.Sent, state.LastSent = con.Sent()
+		if t, ok := br.throttles.Load(key); ok {
+			var lag time.Duration
+			state.Pending, lag = t.(*throttle).lag(now)
+			state.LagSeconds = lag.Seconds()
+		}
+		states = append(states, state)
+		return true
+	})
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Node != states[j].Node {
+			return states[i].Node < states[j].Node
+		}
+		return states[i].UID < states[j].UID
+	})
+	return states
+}
+
+// NodeSubscribers returns the UIDs of the subscribers connected for the given node.
+func (br *Broker) NodeSubscribers(node string) fields.Subscribers {
+	return br.metaServer.NodeSubscribers(node)
+}
+
+// DisconnectNode closes the connections of all the subscribers for the given node. It returns the UIDs
+// of the disconnected subscribers.
+func (br *Broker) DisconnectNode(node string) fields.Subscribers {
+	return br.metaServer.DisconnectNode(node)
 }
 
 func (br *Broker) eventMetricsHandler(evt events.Interface) {
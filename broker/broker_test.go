@@ -0,0 +1,616 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/audit"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// recordingStream is a stream of a subscriber that records the sent events.
+type recordingStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*metadata.Event
+}
+
+func (s *recordingStream) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+func (s *recordingStream) Send(msg *metadata.Event) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestDeliveryLatency(t *testing.T) {
+	stream := &recordingStream{}
+	con := metadata.Connection{Stream: stream}
+	br := &Broker{metrics: defaultBrokerMetrics}
+	series := func() int { return testutil.CollectAndCount(defaultBrokerMetrics.deliveryLatency) }
+
+	// The events without a generation time are sent but not observed.
+	before := series()
+	if err := br.deliver(con, &metadata.Event{Kind: "LatencyTest", Reason: events.Create}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := series(); got != before {
+		t.Errorf("expected no observation for an event without generation time, got %d new series", got-before)
+	}
+
+	if err := br.deliver(con, &metadata.Event{Kind: "LatencyTest", Reason: events.Create}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := series(); got != before+1 {
+		t.Errorf("expected the delivery to be observed, got %d new series", got-before)
+	}
+	if len(stream.sent) != 2 {
+		t.Errorf("expected 2 events to be sent, got %d", len(stream.sent))
+	}
+}
+
+func TestSubscriberStates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	// The throttle lets through only the first event, so that the following ones stay pending.
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{}, WithThrottle(0.001, 1, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	// An in-process subscriber connects to the broker.
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := metadata.NewMetadataClient(conn).Watch(ctx, &metadata.Selector{
+		NodeName:      "node-1",
+		ResourceKinds: map[string]string{"Pod": "Pod"},
+		Encoding:      metadata.Encoding_PROTOBUF,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No collector runs, so the snapshot of the subscriber is immediately complete.
+	if evt, err := stream.Recv(); err != nil || evt.Reason != events.SnapshotComplete {
+		t.Fatalf("expected a SnapshotComplete event, got %v, %v", evt, err)
+	}
+
+	states := br.SubscriberStates()
+	if len(states) != 1 {
+		t.Fatalf("expected one subscriber, got %+v", states)
+	}
+	// eventually returns the state of the subscriber once it satisfies the condition, or after a timeout.
+	eventually := func(cond func(state SubscriberState) bool) SubscriberState {
+		deadline := time.Now().Add(5 * time.Second)
+		state := br.SubscriberStates()[0]
+		for !cond(state) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			state = br.SubscriberStates()[0]
+		}
+		return state
+	}
+
+	// The event is counted once the send returns, that can happen after the subscriber receives it.
+	state := eventually(func(state SubscriberState) bool { return state.Sent == 1 })
+	if state.Node != "node-1" || !reflect.DeepEqual(state.ResourceKinds, []string{"Pod"}) || state.Encoding != "PROTOBUF" {
+		t.Errorf("unexpected subscriber %+v", state)
+	}
+	if state.Sent != 1 || state.LastSent.IsZero() || state.Connected.After(state.LastSent) {
+		t.Errorf("expected one event sent after the connection, got %+v", state)
+	}
+
+	_ = queue.Push(events.NewSnapshotComplete(state.UID, "node-1", ""))
+	state = eventually(func(state SubscriberState) bool { return state.Pending != 0 })
+	if state.Pending != 1 || state.LagSeconds <= 0 || state.Sent != 1 {
+		t.Errorf("expected one event waiting in the throttle, got %+v", state)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	tests := map[string]struct {
+		queue Queue
+		opts  []Option
+		want  []string
+	}{
+		"no queue":         {want: []string{"queue:"}},
+		"cert without key": {queue: NewBlockingChannel(1), opts: []Option{WithTLS("tls.crt", "")}, want: []string{"WithTLS: the certificate"}},
+		"key without cert": {queue: NewBlockingChannel(1), opts: []Option{WithTLS("", "tls.key")}, want: []string{"WithTLS: the key"}},
+		"no burst":         {queue: NewBlockingChannel(1), opts: []Option{WithThrottle(10, 0, time.Second)}, want: []string{"WithThrottle: the burst"}},
+		"negative delete delay": {queue: NewBlockingChannel(1), opts: []Option{WithThrottle(10, 1, -time.Second)},
+			want: []string{"WithThrottle: the maximum delay"}},
+		"aggregated": {opts: []Option{WithTLS("tls.crt", ""), WithThrottle(10, 0, -time.Second)},
+			want: []string{"queue:", "WithTLS: the certificate", "WithThrottle: the burst", "WithThrottle: the maximum delay"}},
+	}
+	for name, tt := range tests {
+		br, err := New(logr.Discard(), tt.queue, map[string]subscriber.SubsChan{}, tt.opts...)
+		if err == nil || br != nil {
+			t.Fatalf("%s: expected an error and no broker, got %v", name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected the error to name %q, got %v", name, want, err)
+			}
+		}
+	}
+}
+
+func TestSetThrottle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	con := metadata.Connection{Stream: &recordingStream{ctx: ctx}}
+
+	br, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{}, WithThrottle(10, 5, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected, _ := br.throttle("sub-1", con)
+
+	if err := br.SetThrottle(0, 1); err == nil {
+		t.Error("expected an error when disabling the throttling at runtime")
+	}
+	if err := br.SetThrottle(20, 0); err == nil {
+		t.Error("expected an error for a burst lower than 1")
+	}
+	if err := br.SetThrottle(20, 7); err != nil {
+		t.Fatal(err)
+	}
+	// Both the throttles of the connected subscribers and the ones of the new subscribers use the new settings.
+	joined, _ := br.throttle("sub-2", con)
+	for name, th := range map[string]*throttle{"connected": connected, "joined": joined} {
+		if th.limiter.Limit() != 20 || th.limiter.Burst() != 7 {
+			t.Errorf("expected the %s subscriber to be throttled at 20/7, got %v/%d", name, th.limiter.Limit(), th.limiter.Burst())
+		}
+	}
+
+	br, err = New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := br.SetThrottle(20, 7); err == nil {
+		t.Error("expected an error when enabling the throttling at runtime")
+	}
+	if err := br.SetThrottle(0, 7); err != nil {
+		t.Errorf("expected no error when the throttling stays disabled, got %v", err)
+	}
+	if _, ok := br.throttle("sub-1", con); ok {
+		t.Error("expected no throttle when the throttling is disabled")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var out bytes.Buffer
+	sink := audit.NewSink(logr.Discard(), &out, 10)
+	br := &Broker{opt: options{audit: sink}}
+
+	res := events.NewResource("Pod", "uid")
+	res.SetOrigin("pod-collector", types.NamespacedName{Namespace: "default", Name: "pod"})
+	res.GenerateSubscribers(fields.Subscribers{"sub-1": {}, "sub-2": {}, "sub-3": {}})
+	for _, evt := range res.ToEvents() {
+		if evt != nil {
+			// Two of the subscribers run on the same node.
+			br.audit(evt, []string{"node-b", "node-a", "node-b"})
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sink.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var record audit.Record
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single audit record, got %q: %v", out.String(), err)
+	}
+	expected := audit.Record{Time: record.Time, Collector: "pod-collector", Type: events.Create, Kind: "Pod",
+		Namespace: "default", Name: "pod", UID: "uid", Nodes: []string{"node-a", "node-b"}}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("expected %+v, got %+v", expected, record)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	const count = 10
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods},
+		WithSendTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, bufconn.Listen(1<<20), nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	subscribed := make(chan string, 2)
+	unsubscribed := make(chan string, 2)
+	go func() {
+		for msg := range pods {
+			if msg.Reason == subscriber.Unsubscribed {
+				unsubscribed <- msg.UID
+				continue
+			}
+			msg.Dispatched()
+			subscribed <- msg.UID
+		}
+	}()
+
+	// The wedged subscriber never receives its events.
+	wedged, err := br.Subscribe(ctx, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy, err := br.Subscribe(ctx, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := fields.Subscribers{}
+	subs.Add(<-subscribed)
+	subs.Add(<-subscribed)
+	for i := 0; i < count; i++ {
+		evt := podEvent(fmt.Sprintf("pod-%d", i), "")
+		evt.Subs = subs
+		_ = queue.Push(evt)
+	}
+
+	// The stream of the wedged subscriber is closed, the other one receives all the events in the meantime.
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for received < count {
+		select {
+		case evt := <-healthy:
+			if evt.GetReason() == events.Create {
+				received++
+			}
+		case <-timeout:
+			t.Fatalf("expected %d events for the healthy subscriber, got %d", count, received)
+		}
+	}
+	select {
+	case uid := <-unsubscribed:
+		if !subs.Has(uid) {
+			t.Errorf("unexpected unsubscribed subscriber %q", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the wedged subscriber to be disconnected")
+	}
+	for range wedged {
+	}
+}
+
+func TestServeStopLeaks(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(1)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for msg := range pods {
+			msg.Dispatched()
+		}
+	}()
+
+	// A grpc subscriber never reads its events, while the collectors keep pushing events to the queue.
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.NewMetadataClient(conn).Watch(ctx, &metadata.Selector{NodeName: "node-1"}); err != nil {
+		t.Fatal(err)
+	}
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for i := 0; i < 100; i++ {
+			_ = queue.Push(podEvent(fmt.Sprintf("pod-%d", i), ""))
+		}
+	}()
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	<-pushed
+	conn.Close()
+	close(pods)
+	<-collected
+}
+
+func TestListenerRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bufconn.Listen(1 << 20)
+	listeners := make(chan *bufconn.Listener, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- br.serve(ctx, first, func() (net.Listener, error) {
+			lis := bufconn.Listen(1 << 20)
+			listeners <- lis
+			return lis, nil
+		})
+	}()
+
+	// The collector acknowledges the subscriptions, the broker never closes its channel.
+	subscribed := make(chan string, 2)
+	go func() {
+		for msg := range pods {
+			msg.Dispatched()
+			if msg.Reason == subscriber.Subscribed {
+				subscribed <- msg.UID
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+		close(pods)
+	})
+
+	// watch subscribes through the given listener and returns the received Create events.
+	watch := func(lis *bufconn.Listener) <-chan string {
+		t.Helper()
+		conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		stream, err := metadata.NewMetadataClient(conn).Watch(ctx, &metadata.Selector{
+			NodeName:      "node-1",
+			ResourceKinds: map[string]string{"Pod": "Pod"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan string, 10)
+		go func() {
+			for {
+				evt, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				if evt.GetReason() == events.Create {
+					received <- evt.GetUid()
+				}
+			}
+		}()
+		return received
+	}
+	// expect waits for the given events on the given subscriber.
+	expect := func(received <-chan string, uids ...string) {
+		t.Helper()
+		for _, uid := range uids {
+			select {
+			case got := <-received:
+				if got != uid {
+					t.Fatalf("expected event %q, got %q", uid, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected event %q", uid)
+			}
+		}
+	}
+
+	// push pushes the Create event of the pod for the given subscribers. Each event gets its own subscribers, ranged
+	// over by the broker while it is delivered.
+	push := func(uid string, subscribers []string) {
+		evt := podEvent(uid, "")
+		evt.Subs = fields.Subscribers{}
+		for _, sub := range subscribers {
+			evt.Subs.Add(sub)
+		}
+		_ = queue.Push(evt)
+	}
+
+	connected := watch(first)
+	subs := []string{<-subscribed}
+	restarts := testutil.ToFloat64(defaultBrokerMetrics.listenerRestarts)
+
+	// The listener fails while the collectors keep pushing events: the connected subscriber receives all of them.
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"pod-1", "pod-2"} {
+		push(uid, subs)
+	}
+	expect(connected, "pod-1", "pod-2")
+
+	// The broker listens again, and the new subscribers connect to it.
+	var second *bufconn.Listener
+	select {
+	case second = <-listeners:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the broker to listen again")
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("expected the broker to keep serving, got %v", err)
+	default:
+	}
+	reconnected := watch(second)
+	subs = append(subs, <-subscribed)
+	push("pod-3", subs)
+	expect(connected, "pod-3")
+	expect(reconnected, "pod-3")
+	if got := testutil.ToFloat64(defaultBrokerMetrics.listenerRestarts) - restarts; got != 1 {
+		t.Errorf("expected 1 listener restart, got %v", got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	barrier := health.NewBarrier()
+	barrier.Register("pod-collector")
+	// dial serves a new broker with the given options and returns an in-process connection to it.
+	dial := func(opts ...Option) *grpc.ClientConn {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		br, err := New(logr.Discard(), NewBlockingChannel(10), map[string]subscriber.SubsChan{}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis := bufconn.Listen(1 << 20)
+		served := make(chan error, 1)
+		go func() { served <- br.serve(ctx, lis, nil) }()
+		conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			conn.Close()
+			cancel()
+			<-served
+		})
+		return conn
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dial(WithBarrier(barrier), WithReflection(true))
+	client := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "metadata.Metadata"} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("service %q: expected NOT_SERVING before the initial sync, got %v, %v", service, resp, err)
+		}
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown service, got %v", err)
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "metadata.Metadata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING, got %v, %v", resp, err)
+	}
+	barrier.Done("pod-collector")
+	if resp, err := watch.Recv(); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING once the barrier is lifted, got %v, %v", resp, err)
+	}
+	if resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil ||
+		resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected the server to be SERVING after the initial sync, got %v, %v", resp, err)
+	}
+
+	// listServices returns the services listed by the reflection service of the broker.
+	listServices := func(conn *grpc.ClientConn) ([]string, error) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		var services []string
+		for _, service := range resp.GetListServicesResponse().GetService() {
+			services = append(services, service.GetName())
+		}
+		return services, nil
+	}
+	services, err := listServices(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"grpc.health.v1.Health", "metadata.Metadata"} {
+		if !slices.Contains(services, want) {
+			t.Errorf("expected the reflection service to list %q, got %v", want, services)
+		}
+	}
+
+	// Without barrier the broker is serving right away, and the reflection service is disabled by default.
+	conn = dial()
+	if _, err := listServices(conn); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected the reflection service to be disabled, got %v", err)
+	}
+	watch, err = healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetStatus() == healthpb.HealthCheckResponse_SERVING {
+			break
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brokertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+var _ broker.Queue = &Broker{}
+
+// Broker is an in-memory broker. It implements the broker.Queue interface, recording the events pushed by the
+// collectors, and notifies the collectors of the subscribers through the channels returned by SubscribersChan, as the
+// broker does.
+type Broker struct {
+	mutex sync.Mutex
+	// recorded holds the pushed events not yet matched by WaitForEvent.
+	recorded []events.Interface
+	// pending holds the pushed events not yet popped.
+	pending []events.Interface
+	// pushed is closed and replaced each time an event is pushed.
+	pushed chan struct{}
+	// nodes holds the node of the subscribers, by UID.
+	nodes      map[string]string
+	collectors []subscriber.SubsChan
+}
+
+// NewBroker returns a new Broker.
+func NewBroker() *Broker {
+	return &Broker{
+		pushed: make(chan struct{}),
+		nodes:  make(map[string]string),
+	}
+}
+
+// Push records the event.
+func (b *Broker) Push(evt events.Interface) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.recorded = append(b.recorded, evt)
+	b.pending = append(b.pending, evt)
+	close(b.pushed)
+	b.pushed = make(chan struct{})
+	return nil
+}
+
+// Pop returns the oldest event not yet popped, waiting for one to be pushed. It returns nil once the context is
+// canceled.
+func (b *Broker) Pop(ctx context.Context) events.Interface {
+	for {
+		b.mutex.Lock()
+		if len(b.pending) > 0 {
+			evt := b.pending[0]
+			b.pending = b.pending[1:]
+			b.mutex.Unlock()
+			return evt
+		}
+		pushed := b.pushed
+		b.mutex.Unlock()
+
+		select {
+		case <-pushed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Events returns the recorded events not yet matched by WaitForEvent, in the order they have been pushed.
+func (b *Broker) Events() []events.Interface {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]events.Interface(nil), b.recorded...)
+}
+
+// Reset drops the recorded events.
+func (b *Broker) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.recorded = nil
+	b.pending = nil
+}
+
+// WaitForEvent waits for an event of the given resource kind and type, e.g. events.Create, destined to a subscriber
+// of the given node, or to any subscriber if the node is empty. The matched event is dropped from the recorded ones,
+// so that waiting again waits for the next one. An error is returned if no event matches within the timeout.
+func (b *Broker) WaitForEvent(kind, evtType, node string, timeout time.Duration) (events.Interface, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		b.mutex.Lock()
+		for i, evt := range b.recorded {
+			if evt.ResourceKind() == kind && evt.Type() == evtType && b.destinedTo(evt, node) {
+				b.recorded = append(b.recorded[:i:i], b.recorded[i+1:]...)
+				b.mutex.Unlock()
+				return evt, nil
+			}
+		}
+		pushed := b.pushed
+		b.mutex.Unlock()
+
+		select {
+		case <-pushed:
+		case <-deadline.C:
+			return nil, fmt.Errorf("no %s event for %s resources destined to node %q within %s", evtType, kind, node,
+				timeout)
+		}
+	}
+}
+
+// destinedTo returns true if the event is destined to a subscriber of the given node, or if the node is empty. The
+// caller must hold the lock.
+func (b *Broker) destinedTo(evt events.Interface, node string) bool {
+	if node == "" {
+		return true
+	}
+	for uid := range evt.Subscribers() {
+		if b.nodes[uid] == node {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribersChan returns a new channel to be passed to a collector with collectors.WithSubscribersChan. The
+// collector is notified through it of the subscribers arriving and leaving, and must be running to receive them.
+func (b *Broker) SubscribersChan() subscriber.SubsChan {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(subscriber.SubsChan)
+	b.collectors = append(b.collectors, ch)
+	return ch
+}
+
+// Subscribe notifies the collectors of a new subscriber for the given node and returns its UID. It waits until the
+// collectors have pushed the events of the existing resources related to the node, as the broker does before sending
+// the SnapshotComplete event. An error is returned if the context is canceled before.
+func (b *Broker) Subscribe(ctx context.Context, node string) (string, error) {
+	uid := string(uuid.NewUUID())
+	b.mutex.Lock()
+	b.nodes[uid] = node
+	collectors := b.collectors
+	b.mutex.Unlock()
+
+	var dispatched sync.WaitGroup
+	dispatched.Add(len(collectors))
+	msg := subscriber.Message{NodeName: node, UID: uid, Reason: subscriber.Subscribed, Done: dispatched.Done}
+	if err := notify(ctx, collectors, msg); err != nil {
+		return "", err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		dispatched.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return uid, nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for the dispatch of the resources of node %q: %w", node, ctx.Err())
+	}
+}
+
+// Unsubscribe notifies the collectors that the subscriber with the given UID has left. An error is returned if the
+// context is canceled before all the collectors have been notified.
+func (b *Broker) Unsubscribe(ctx context.Context, uid string) error {
+	b.mutex.Lock()
+	node, ok := b.nodes[uid]
+	collectors := b.collectors
+	b.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown subscriber %q", uid)
+	}
+	return notify(ctx, collectors, subscriber.Message{NodeName: node, UID: uid, Reason: subscriber.Unsubscribed})
+}
+
+// notify sends the message to the collectors.
+func notify(ctx context.Context, collectors []subscriber.SubsChan, msg subscriber.Message) error {
+	for _, collector := range collectors {
+		select {
+		case collector <- msg:
+		case <-ctx.Done():
+			return fmt.Errorf("notifying the collectors of subscriber %q: %w", msg.UID, ctx.Err())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package brokertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+)
+
+func newEvent(kind, evtType string, subs ...string) *events.Event {
+	evt := &events.Event{Event: &metadata.Event{Kind: kind, Reason: evtType}, Subs: fields.Subscribers{}}
+	for _, sub := range subs {
+		evt.Subs[sub] = struct{}{}
+	}
+	return evt
+}
+
+func TestWaitForEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewBroker()
+
+	// A collector dispatches the existing resources to the subscribers it is notified of.
+	subs := b.SubscribersChan()
+	go func() {
+		for msg := range subs {
+			if msg.Reason == subscriber.Subscribed {
+				_ = b.Push(newEvent("Pod", events.Create, msg.UID))
+			}
+			msg.Dispatched()
+		}
+	}()
+	defer close(subs)
+
+	uid, err := b.Subscribe(ctx, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The events of the existing resources have been pushed when Subscribe returns.
+	if got := b.Events(); len(got) != 1 {
+		t.Fatalf("expected the dispatched event to be recorded, got %v", got)
+	}
+	if _, err := b.WaitForEvent("Pod", events.Create, "other", 10*time.Millisecond); err == nil {
+		t.Error("expected no event for the subscribers of another node")
+	}
+	if _, err := b.WaitForEvent("Pod", events.Create, "node", time.Second); err != nil {
+		t.Error(err)
+	}
+	// The matched event is dropped, but it is still popped by the consumer of the queue.
+	if got := b.Events(); len(got) != 0 {
+		t.Errorf("expected the matched event to be dropped, got %v", got)
+	}
+	if evt := b.Pop(ctx); evt == nil || evt.Type() != events.Create {
+		t.Errorf("expected the Create event to be popped, got %v", evt)
+	}
+
+	// The events pushed later are waited for.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = b.Push(newEvent("Pod", events.Delete, uid))
+	}()
+	if _, err := b.WaitForEvent("Pod", events.Delete, "node", time.Second); err != nil {
+		t.Error(err)
+	}
+
+	if err := b.Unsubscribe(ctx, uid); err != nil {
+		t.Error(err)
+	}
+	if err := b.Unsubscribe(ctx, "unknown"); err == nil {
+		t.Error("expected an error for an unknown subscriber")
+	}
+}
+
+func TestPopCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if evt := NewBroker().Pop(ctx); evt != nil {
+		t.Errorf("expected no event once the context is canceled, got %v", evt)
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+)
+
+// cascaded is implemented by the events flagging the Delete of a resource deleted with its namespace.
+type cascaded interface {
+	Cascaded() bool
+}
+
+// replaced returns whether the event is not sent to the subscriber with the given selector, since it gets another one
+// in its place: the subscribers that negotiated the bulk deletes get the DeleteNamespace event of a deleted namespace
+// in place of the Delete events of its resources, the other subscribers get only the Delete events.
+func replaced(evt events.Interface, selector *metadata.Selector) bool {
+	if evt.Type() == events.DeleteNamespace {
+		return !selector.GetBulkDelete()
+	}
+	if !selector.GetBulkDelete() || evt.Type() != events.Delete {
+		return false
+	}
+	c, ok := evt.(cascaded)
+	return ok && c.Cascaded()
+}
+
+// discard drops the pending event of the resource with the given UID from the throttle of the subscriber, if any. It
+// is called for the resources whose Delete event is replaced, that would otherwise be sent after it.
+func (br *Broker) discard(sub, uid string) {
+	if t, ok := br.throttles.Load(sub); ok {
+		t.(*throttle).discard(uid)
+	}
+}
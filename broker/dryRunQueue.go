@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DryRunQueue implements the Queue interface without delivering the events to any subscriber.
+// Each pushed event is counted per resource kind and event type and, if a writer has been
+// provided, written to it as a JSON line. Pop never returns an event.
+type DryRunQueue struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	logger  logr.Logger
+	events  *prometheus.CounterVec
+}
+
+// dryRunRecord is the JSON line written for each event pushed to the DryRunQueue.
+type dryRunRecord struct {
+	Time        time.Time       `json:"time"`
+	Kind        string          `json:"kind"`
+	Type        string          `json:"type"`
+	Subscribers []string        `json:"subscribers"`
+	Event       *metadata.Event `json:"event"`
+}
+
+// NewDryRunQueue returns a DryRunQueue. If w is nil the events are only counted.
+func NewDryRunQueue(logger logr.Logger, w io.Writer, opt ...QueueOption) *DryRunQueue {
+	dq := &DryRunQueue{
+		logger: logger,
+		events: newQueueOptions(opt).metrics.dryRunEvents,
+	}
+
+	if w != nil {
+		dq.encoder = json.NewEncoder(w)
+	}
+
+	return dq
+}
+
+// Push counts the event and writes it to the configured writer, if any. It never fails, the errors writing the event
+// are logged.
+func (dq *DryRunQueue) Push(evt events.Interface) error {
+	dq.events.WithLabelValues(evt.ResourceKind(), evt.Type()).Inc()
+
+	if dq.encoder == nil {
+		return nil
+	}
+
+	subs := make([]string, 0, len(evt.Subscribers()))
+	for sub := range evt.Subscribers() {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+
+	dq.mutex.Lock()
+	defer dq.mutex.Unlock()
+	if err := dq.encoder.Encode(dryRunRecord{
+		Time:        time.Now(),
+		Kind:        evt.ResourceKind(),
+		Type:        evt.Type(),
+		Subscribers: subs,
+		Event:       evt.GRPCMessage(),
+	}); err != nil {
+		dq.logger.Error(err, "unable to write dry-run event", "event", evt.String())
+	}
+	return nil
+}
+
+// Pop blocks until the context is canceled. Events pushed to the DryRunQueue are never dispatched.
+func (dq *DryRunQueue) Pop(ctx context.Context) events.Interface {
+	<-ctx.Done()
+	return nil
+}
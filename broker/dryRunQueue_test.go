@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dryRunEvent returns an event of the given kind and type for the given subscribers.
+func dryRunEvent(kind, reason, uid string, subs ...string) events.Interface {
+	evt := &events.Event{Event: &metadata.Event{Kind: kind, Reason: reason, Uid: uid}, Subs: make(fields.Subscribers)}
+	for _, sub := range subs {
+		evt.Subs.Add(sub)
+	}
+	return evt
+}
+
+func TestDryRunQueueCounters(t *testing.T) {
+	qm, err := NewQueueMetrics(prometheus.NewRegistry(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dq := NewDryRunQueue(logr.Discard(), nil, WithQueueMetrics(qm))
+	for _, evt := range []events.Interface{
+		dryRunEvent("Pod", events.Create, "pod-1"),
+		dryRunEvent("Pod", events.Create, "pod-2"),
+		dryRunEvent("Pod", events.Delete, "pod-1"),
+		dryRunEvent("Service", events.Update, "svc"),
+	} {
+		if err := dq.Push(evt); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The events are counted per kind and type.
+	for labels, want := range map[[2]string]float64{
+		{"Pod", events.Create}:     2,
+		{"Pod", events.Delete}:     1,
+		{"Service", events.Update}: 1,
+		{"Service", events.Create}: 0,
+	} {
+		if got := testutil.ToFloat64(qm.dryRunEvents.WithLabelValues(labels[0], labels[1])); got != want {
+			t.Errorf("%v: expected %v events, got %v", labels, want, got)
+		}
+	}
+
+	// The events are never dispatched.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if evt := dq.Pop(ctx); evt != nil {
+		t.Errorf("expected no event to be popped, got %s", evt.String())
+	}
+}
+
+func TestDryRunQueueOutput(t *testing.T) {
+	var out bytes.Buffer
+	dq := NewDryRunQueue(logr.Discard(), &out)
+	_ = dq.Push(dryRunEvent("Pod", events.Create, "pod-uid", "sub-2", "sub-1"))
+	_ = dq.Push(dryRunEvent("Namespace", events.Delete, "ns-uid"))
+
+	// Each event is written as a JSON line, with its subscribers sorted.
+	var got []dryRunRecord
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var record dryRunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("unable to decode line %q: %v", scanner.Text(), err)
+		}
+		if record.Time.IsZero() {
+			t.Errorf("expected the time of the event to be written, got %q", scanner.Text())
+		}
+		got = append(got, record)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(got), out.String())
+	}
+	if got[0].Kind != "Pod" || got[0].Type != events.Create || got[0].Event.GetUid() != "pod-uid" ||
+		!reflect.DeepEqual(got[0].Subscribers, []string{"sub-1", "sub-2"}) {
+		t.Errorf("unexpected record %+v", got[0])
+	}
+	if got[1].Kind != "Namespace" || got[1].Type != events.Delete || got[1].Event.GetUid() != "ns-uid" ||
+		len(got[1].Subscribers) != 0 {
+		t.Errorf("unexpected record %+v", got[1])
+	}
+}
+
+// failingWriter fails all the writes.
+type failingWriter struct{}
+
+func (failingWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestDryRunQueueWriteError(t *testing.T) {
+	qm, err := NewQueueMetrics(prometheus.NewRegistry(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The errors writing the events are only logged, the events are still counted.
+	dq := NewDryRunQueue(logr.Discard(), failingWriter{}, WithQueueMetrics(qm))
+	if err := dq.Push(dryRunEvent("Pod", events.Create, "pod-uid", "sub")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(qm.dryRunEvents.WithLabelValues("Pod", events.Create)); got != 1 {
+		t.Errorf("expected the event to be counted, got %v", got)
+	}
+}
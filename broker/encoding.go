@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// format is the form of the messages negotiated by a subscriber: the encoding of the metadata, and whether the Update
+// events carry the patches of the metadata.
+type format struct {
+	encoding  metadata.Encoding
+	metaPatch bool
+}
+
+// formatOf returns the format negotiated in the given selector. The patches of the metadata are only sent with the JSON
+// encoding.
+func formatOf(selector *metadata.Selector) format {
+	return format{
+		encoding:  selector.GetEncoding(),
+		metaPatch: selector.GetMetaPatch() && selector.GetEncoding() == metadata.Encoding_JSON,
+	}
+}
+
+// encodings holds the message of an event in the formats negotiated by the subscribers. The message generated by
+// the collectors carries the metadata in both the encodings, and each subscriber receives only the one it chose. The
+// messages are derived once per event, and shared by the subscribers that chose the same format.
+type encodings struct {
+	msg     *metadata.Event
+	encoded map[format]*metadata.Event
+}
+
+// newEncodings returns the encodings for the given message.
+func newEncodings(msg *metadata.Event) *encodings {
+	return &encodings{msg: msg}
+}
+
+// get returns the message in the given format. The message carrying the patch of the metadata still carries the full
+// metadata, removed by patched right before the send.
+func (e *encodings) get(f format) *metadata.Event {
+	if msg, ok := e.encoded[f]; ok {
+		return msg
+	}
+	if e.encoded == nil {
+		e.encoded = make(map[format]*metadata.Event, 1)
+	}
+	var msg *metadata.Event
+	if f.metaPatch && e.msg.MetaPatch != nil {
+		msg = proto.Clone(e.msg).(*metadata.Event)
+		msg.ObjectMeta = nil
+	} else {
+		msg = encode(e.msg, f.encoding)
+	}
+	e.encoded[f] = msg
+	return msg
+}
+
+// patched returns the message as sent to the subscriber: when it carries the patch of the metadata, the full metadata
+// are removed. They are kept until the send, so that a throttled message coalesced with a pending one for the same
+// resource, whose patch does not apply anymore, can fall back to them.
+func patched(msg *metadata.Event) *metadata.Event {
+	if msg.MetaPatch == nil || msg.Meta == nil {
+		return msg
+	}
+	sent := proto.Clone(msg).(*metadata.Event)
+	sent.Meta = nil
+	return sent
+}
+
+// unpatched returns the message carrying the full metadata in place of their patch, if any.
+func unpatched(msg *metadata.Event) *metadata.Event {
+	if msg.MetaPatch == nil {
+		return msg
+	}
+	full := proto.Clone(msg).(*metadata.Event)
+	full.MetaPatch = nil
+	return full
+}
+
+// encode returns the message carrying the metadata only in the given encoding, without their patch. The given message
+// is never modified, a copy is returned when the metadata in the other encoding need to be removed. The struct
+// metadata are derived from the JSON ones: if they can not be, the message carries the JSON metadata.
+func encode(msg *metadata.Event, encoding metadata.Encoding) *metadata.Event {
+	switch encoding {
+	case metadata.Encoding_STRUCT:
+		if msg.Meta == nil {
+			return encode(msg, metadata.Encoding_PROTOBUF)
+		}
+		meta := &structpb.Struct{}
+		if err := protojson.Unmarshal([]byte(msg.GetMeta()), meta); err != nil {
+			return encode(msg, metadata.Encoding_JSON)
+		}
+		encoded := proto.Clone(msg).(*metadata.Event)
+		encoded.Meta = nil
+		encoded.ObjectMeta = nil
+		encoded.MetaPatch = nil
+		encoded.MetaStruct = meta
+		return encoded
+	case metadata.Encoding_PROTOBUF:
+		if msg.Meta == nil && msg.MetaPatch == nil {
+			return msg
+		}
+		encoded := proto.Clone(msg).(*metadata.Event)
+		encoded.Meta = nil
+		encoded.MetaPatch = nil
+		return encoded
+	default:
+		if msg.ObjectMeta == nil && msg.MetaPatch == nil {
+			return msg
+		}
+		encoded := proto.Clone(msg).(*metadata.Event)
+		encoded.ObjectMeta = nil
+		encoded.MetaPatch = nil
+		return encoded
+	}
+}
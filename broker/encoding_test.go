@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	meta := metav1.ObjectMeta{
+		Name:        "pod",
+		Namespace:   "default",
+		UID:         "pod-uid",
+		Labels:      map[string]string{"app": "test"},
+		Annotations: map[string]string{"owner": "team"},
+	}
+	metaString, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := events.NewResource(resource.Pod, string(meta.UID))
+	res.SetMeta(string(metaString))
+	res.SetObjectMeta(&meta, "node")
+	res.GenerateSubscribers(fields.Subscribers{"subscriber": {}})
+	msg := res.ToEvents()[0].GRPCMessage()
+	msgs := newEncodings(msg)
+
+	// roundTrip marshals and unmarshals the message as sent over the wire.
+	roundTrip := func(encoding metadata.Encoding) *metadata.Event {
+		t.Helper()
+		data, err := proto.Marshal(msgs.get(format{encoding: encoding}))
+		if err != nil {
+			t.Fatalf("%s: unable to marshal: %v", encoding, err)
+		}
+		received := &metadata.Event{}
+		if err := proto.Unmarshal(data, received); err != nil {
+			t.Fatalf("%s: unable to unmarshal: %v", encoding, err)
+		}
+		if received.GetReason() != events.Create || received.GetKind() != resource.Pod || received.GetUid() != "pod-uid" {
+			t.Errorf("%s: unexpected event %v", encoding, received)
+		}
+		return received
+	}
+
+	received := roundTrip(metadata.Encoding_JSON)
+	if received.ObjectMeta != nil {
+		t.Errorf("expected no structured metadata with the JSON encoding, got %v", received.ObjectMeta)
+	}
+	var decoded metav1.ObjectMeta
+	if err := json.Unmarshal([]byte(received.GetMeta()), &decoded); err != nil {
+		t.Fatalf("unable to decode the JSON metadata: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Labels, meta.Labels) || !reflect.DeepEqual(decoded.Annotations, meta.Annotations) {
+		t.Errorf("expected the JSON metadata to carry labels and annotations, got %v", decoded)
+	}
+
+	received = roundTrip(metadata.Encoding_PROTOBUF)
+	if received.Meta != nil {
+		t.Errorf("expected no JSON metadata with the protobuf encoding, got %q", received.GetMeta())
+	}
+	objectMeta := received.GetObjectMeta()
+	if objectMeta.GetName() != "pod" || objectMeta.GetNamespace() != "default" || objectMeta.GetNode() != "node" ||
+		!reflect.DeepEqual(objectMeta.GetLabels(), meta.Labels) || !reflect.DeepEqual(objectMeta.GetAnnotations(), meta.Annotations) {
+		t.Errorf("unexpected structured metadata %v", objectMeta)
+	}
+
+	received = roundTrip(metadata.Encoding_STRUCT)
+	if received.Meta != nil || received.ObjectMeta != nil {
+		t.Errorf("expected only the struct metadata with the struct encoding, got %v", received)
+	}
+	fields := received.GetMetaStruct().AsMap()
+	if fields["name"] != "pod" || fields["uid"] != "pod-uid" ||
+		!reflect.DeepEqual(fields["labels"], map[string]interface{}{"app": "test"}) {
+		t.Errorf("unexpected struct metadata %v", fields)
+	}
+
+	// The message of the event is shared, hence it is never modified, and each encoding is derived only once.
+	if msg.Meta == nil || msg.ObjectMeta == nil {
+		t.Error("expected the message of the event to carry both the encodings")
+	}
+	if msgs.get(format{encoding: metadata.Encoding_PROTOBUF}) != msgs.get(format{encoding: metadata.Encoding_PROTOBUF}) {
+		t.Error("expected the encoded message to be reused")
+	}
+}
+
+func TestEncodeDelete(t *testing.T) {
+	msg := &metadata.Event{Reason: events.Delete, Uid: "pod-uid", Kind: resource.Pod}
+	for _, encoding := range []metadata.Encoding{metadata.Encoding_JSON, metadata.Encoding_PROTOBUF, metadata.Encoding_STRUCT} {
+		if encode(msg, encoding) != msg {
+			t.Errorf("%s: expected the message without metadata to be sent as is", encoding)
+		}
+	}
+}
+
+func TestEncodeStructInvalid(t *testing.T) {
+	invalid := "{"
+	msg := &metadata.Event{Reason: events.Create, Uid: "pod-uid", Kind: resource.Pod, Meta: &invalid}
+	if encoded := encode(msg, metadata.Encoding_STRUCT); encoded.GetMeta() != invalid || encoded.MetaStruct != nil {
+		t.Errorf("expected the metadata that can not be converted to be sent as JSON, got %v", encoded)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	msg := representativePodEvent(b)
+	for _, encoding := range []metadata.Encoding{metadata.Encoding_JSON, metadata.Encoding_PROTOBUF, metadata.Encoding_STRUCT} {
+		b.Run(encoding.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := proto.Marshal(encode(msg, encoding)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// representativePodEvent returns the message of the Create event of a pod with a representative set of labels and annotations.
+func representativePodEvent(tb testing.TB) *metadata.Event {
+	tb.Helper()
+	meta := metav1.ObjectMeta{
+		Name:            "web-7d4b9c8f6d-x2x9k",
+		Namespace:       "production",
+		UID:             "5f0c9c3e-8a7b-4d6e-9f1a-2b3c4d5e6f70",
+		ResourceVersion: "123456789",
+		GenerateName:    "web-7d4b9c8f6d-",
+		Labels: map[string]string{
+			"app.kubernetes.io/name":      "web",
+			"app.kubernetes.io/instance":  "web-production",
+			"app.kubernetes.io/version":   "1.24.3",
+			"app.kubernetes.io/component": "frontend",
+			"pod-template-hash":           "7d4b9c8f6d",
+		},
+		Annotations: map[string]string{
+			"kubectl.kubernetes.io/restartedAt": "2023-10-01T12:00:00Z",
+			"prometheus.io/scrape":              "true",
+			"prometheus.io/port":                "9090",
+			"cni.projectcalico.org/podIP":       "10.0.12.34/32",
+		},
+	}
+	metaString, err := json.Marshal(meta)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	res := events.NewResource(resource.Pod, string(meta.UID))
+	res.SetMeta(string(metaString))
+	res.SetObjectMeta(&meta, "node")
+	res.GenerateSubscribers(fields.Subscribers{"subscriber": {}})
+	return res.ToEvents()[0].GRPCMessage()
+}
+
+func TestEncodeMetaPatch(t *testing.T) {
+	meta, patch := `{"name":"pod","labels":{"version":"v2"}}`, `[{"op":"replace","path":"/labels/version","value":"v2"}]`
+	msg := &metadata.Event{Reason: events.Update, Uid: "pod-uid", Kind: resource.Pod, Meta: &meta, MetaPatch: &patch,
+		ObjectMeta: &metadata.ObjectMeta{Name: "pod"}}
+	msgs := newEncodings(msg)
+
+	// The subscribers that negotiated the patches receive only them, in place of the metadata.
+	sent := patched(msgs.get(formatOf(&metadata.Selector{MetaPatch: true})))
+	if sent.GetMetaPatch() != patch || sent.Meta != nil || sent.ObjectMeta != nil {
+		t.Errorf("expected only the patch of the metadata, got %v", sent)
+	}
+
+	// The other subscribers, and the ones that did not choose the JSON encoding, never receive the patches.
+	for _, selector := range []*metadata.Selector{
+		{},
+		{Encoding: metadata.Encoding_PROTOBUF, MetaPatch: true},
+		{Encoding: metadata.Encoding_STRUCT, MetaPatch: true},
+	} {
+		if sent := patched(msgs.get(formatOf(selector))); sent.MetaPatch != nil {
+			t.Errorf("%v: expected no patch of the metadata, got %v", selector, sent)
+		}
+	}
+	if sent := patched(msgs.get(format{encoding: metadata.Encoding_JSON})); sent.GetMeta() != meta {
+		t.Errorf("expected the full metadata, got %v", sent)
+	}
+
+	// The events without a patch, e.g. when the previous metadata are not known, carry the full metadata.
+	full := &metadata.Event{Reason: events.Update, Uid: "pod-uid", Kind: resource.Pod, Meta: &meta}
+	if sent := patched(newEncodings(full).get(formatOf(&metadata.Selector{MetaPatch: true}))); sent != full {
+		t.Errorf("expected the event without a patch to be sent as is, got %v", sent)
+	}
+	if msg.GetMeta() != meta || msg.GetMetaPatch() != patch {
+		t.Error("expected the message of the event to be left untouched")
+	}
+}
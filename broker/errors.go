@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrSubscriberClosed is wrapped by the errors of the events not sent because the stream of the subscriber has
+	// been closed, e.g. the subscriber went away.
+	ErrSubscriberClosed = errors.New("subscriber closed")
+	// ErrQueueFull is wrapped by the errors closing the streams of the subscribers whose events have been dropped
+	// because the queue was full.
+	ErrQueueFull = errors.New("queue full")
+	// ErrSendTimeout is wrapped by the errors closing the streams of the subscribers that did not receive an event
+	// within the send timeout.
+	ErrSendTimeout = errors.New("send timeout")
+	// ErrPanic is wrapped by the errors of the RPCs and of the deliveries to the subscribers interrupted by a panic,
+	// recovered by the broker.
+	ErrPanic = errors.New("panic")
+	// ErrQueueClosed is returned when pushing an event to a closed queue, e.g. once its consumer stopped: the event is
+	// dropped.
+	ErrQueueClosed = errors.New("queue closed")
+)
+
+// statusError is a grpc status error wrapping its cause, so that the subscriber receives the status and the embedding
+// code can classify the error with errors.Is.
+type statusError struct {
+	status *status.Status
+	cause  error
+}
+
+// Error returns the error in string format.
+func (e *statusError) Error() string {
+	return e.status.Err().Error()
+}
+
+// GRPCStatus returns the status sent to the subscriber.
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// Unwrap returns the cause of the error.
+func (e *statusError) Unwrap() error {
+	return e.cause
+}
+
+// sendError classifies the error returned while sending the message to the subscriber: an *events.SerializationError
+// if the message could not be split in chunks, ErrSubscriberClosed if the stream of the subscriber has been closed.
+func sendError(con metadata.Connection, msg *metadata.Event, err error) error {
+	switch {
+	case errors.Is(err, metadata.ErrSplit):
+		return &events.SerializationError{Kind: msg.Kind, Key: msg.Uid, Err: err}
+	case errors.Is(err, io.EOF), status.Code(err) == codes.Canceled, con.Stream.Context().Err() != nil:
+		return fmt.Errorf("%w: %w", ErrSubscriberClosed, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingStream is a stream of a subscriber whose sends fail with the given error.
+type failingStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	err error
+}
+
+func (s *failingStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *failingStream) Send(*metadata.Event) error {
+	return s.err
+}
+
+func TestSendErrorClassification(t *testing.T) {
+	meta := strings.Repeat("x", 4096)
+	_, splitErr := metadata.Split(&metadata.Event{Uid: "uid", Meta: &meta}, 512)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		err           error
+		closed        bool
+		serialization bool
+	}{
+		{name: "split", ctx: context.Background(), err: splitErr, serialization: true},
+		{name: "stream context done", ctx: canceled, err: errors.New("transport is closing"), closed: true},
+		{name: "end of stream", ctx: context.Background(), err: io.EOF, closed: true},
+		{name: "canceled", ctx: context.Background(), err: status.Error(codes.Canceled, "canceled"), closed: true},
+		{name: "other", ctx: context.Background(), err: errors.New("unexpected")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			con := metadata.Connection{Stream: &failingStream{ctx: tt.ctx, err: tt.err}}
+			err := sendError(con, &metadata.Event{Kind: "Pod", Uid: "uid"}, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected the error to wrap %v, got %v", tt.err, err)
+			}
+			if got := errors.Is(err, ErrSubscriberClosed); got != tt.closed {
+				t.Errorf("expected ErrSubscriberClosed %v, got %v", tt.closed, err)
+			}
+			var serializationErr *events.SerializationError
+			if got := errors.As(err, &serializationErr); got != tt.serialization {
+				t.Errorf("expected a serialization error %v, got %v", tt.serialization, err)
+			}
+			if tt.serialization && (serializationErr.Kind != "Pod" || serializationErr.Key != "uid") {
+				t.Errorf("unexpected serialization error %+v", serializationErr)
+			}
+		})
+	}
+}
+
+func TestDeliverDropsSerializationErrors(t *testing.T) {
+	br, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &metadata.Event{Kind: "Pod", Uid: "uid", Reason: events.Create}
+
+	// The events that can not be serialized are dropped, the subscriber stays connected.
+	con := metadata.Connection{Stream: &failingStream{ctx: context.Background(), err: metadata.ErrSplit}}
+	if err := br.deliver(con, msg, time.Now()); err != nil {
+		t.Errorf("expected the event to be dropped, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	con = metadata.Connection{Stream: &failingStream{ctx: ctx, err: io.EOF}}
+	if err := br.deliver(con, msg, time.Now()); !errors.Is(err, ErrSubscriberClosed) {
+		t.Errorf("expected ErrSubscriberClosed, got %v", err)
+	}
+}
+
+func TestQueueFullStatus(t *testing.T) {
+	err := error(&statusError{status: status.New(codes.DataLoss, "events dropped"), cause: ErrQueueFull})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected the error to wrap ErrQueueFull, got %v", err)
+	}
+	if st, ok := status.FromError(err); !ok || st.Code() != codes.DataLoss || st.Message() != "events dropped" {
+		t.Errorf("expected the DataLoss status to be sent to the subscriber, got %v", st)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultRPCLogVerbosity is the verbosity of the logs of the RPCs unless configured with WithRPCLogVerbosity.
+	defaultRPCLogVerbosity = 2
+
+	rpcTypeUnary  = "unary"
+	rpcTypeStream = "stream"
+)
+
+// interceptors returns the server options chaining the interceptors of the RPCs served by the broker. Each RPC is
+// counted, logged and recovered from panics, in this order, before running the interceptors of the options, e.g. the
+// authentication of the embedding program, so that the RPCs they refuse are counted and logged as well.
+func interceptors(logger logr.Logger, metrics *brokerMetrics, opts *options) []grpc.ServerOption {
+	unary := []grpc.UnaryServerInterceptor{metricsUnaryInterceptor(metrics)}
+	stream := []grpc.StreamServerInterceptor{metricsStreamInterceptor(metrics)}
+	if opts.rpcLogVerbosity >= 0 {
+		unary = append(unary, loggingUnaryInterceptor(logger.V(opts.rpcLogVerbosity)))
+		stream = append(stream, loggingStreamInterceptor(logger.V(opts.rpcLogVerbosity)))
+	}
+	unary = append(append(unary, recoveryUnaryInterceptor(logger)), opts.unaryInterceptors...)
+	stream = append(append(stream, recoveryStreamInterceptor(logger)), opts.streamInterceptors...)
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+}
+
+// metricsUnaryInterceptor counts the unary RPCs started and completed, by status code.
+func metricsUnaryInterceptor(metrics *brokerMetrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		service, method := splitMethod(info.FullMethod)
+		metrics.rpcStarted.WithLabelValues(rpcTypeUnary, service, method).Inc()
+		resp, err := handler(ctx, req)
+		metrics.rpcHandled.WithLabelValues(rpcTypeUnary, service, method, status.Code(err).String()).Inc()
+		return resp, err
+	}
+}
+
+// metricsStreamInterceptor counts the streaming RPCs started and completed, by status code.
+func metricsStreamInterceptor(metrics *brokerMetrics) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, method := splitMethod(info.FullMethod)
+		metrics.rpcStarted.WithLabelValues(rpcTypeStream, service, method).Inc()
+		err := handler(srv, ss)
+		metrics.rpcHandled.WithLabelValues(rpcTypeStream, service, method, status.Code(err).String()).Inc()
+		return err
+	}
+}
+
+// loggingUnaryInterceptor logs the unary RPCs once completed.
+func loggingUnaryInterceptor(logger logr.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logHandled(ctx, logger, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// loggingStreamInterceptor logs the streaming RPCs when they start, since they are long-lived, and once completed.
+func loggingStreamInterceptor(logger logr.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		logger.Info("rpc started", "method", info.FullMethod, "peer", peerAddr(ss.Context()))
+		err := handler(srv, ss)
+		logHandled(ss.Context(), logger, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// logHandled logs the completion of an RPC started at the given time, with its status code.
+func logHandled(ctx context.Context, logger logr.Logger, method string, start time.Time, err error) {
+	keysAndValues := []interface{}{"method", method, "peer", peerAddr(ctx), "code", status.Code(err).String(),
+		"duration", time.Since(start)}
+	if err != nil {
+		keysAndValues = append(keysAndValues, "error", err.Error())
+	}
+	logger.Info("rpc handled", keysAndValues...)
+}
+
+// recoveryUnaryInterceptor turns the panics of the unary RPCs into Internal errors.
+func recoveryUnaryInterceptor(logger logr.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// recoveryStreamInterceptor turns the panics of the streaming RPCs into Internal errors.
+func recoveryStreamInterceptor(logger logr.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// recovered logs the panic recovered while running the given operation, along with its stack, and returns the
+// Internal error reported to the subscriber in its place. It must be called by the deferred function recovering it.
+func recovered(logger logr.Logger, operation string, r interface{}) error {
+	cause := fmt.Errorf("%w: %v", ErrPanic, r)
+	logger.Error(cause, "recovered from a panic", "operation", operation, "stack", string(debug.Stack()))
+	return &statusError{status: status.New(codes.Internal, "internal error of the metacollector"), cause: cause}
+}
+
+// splitMethod returns the service and the method of the full name of an RPC, e.g. /metadata.Metadata/Watch.
+func splitMethod(fullMethod string) (service, method string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", fullMethod
+	}
+	return service, method
+}
+
+// peerAddr returns the address of the client of the RPC, empty if unknown.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
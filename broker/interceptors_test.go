@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestInterceptors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// The stream interceptor authenticates the subscribers, the unary one panics.
+	authenticate := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if md, _ := grpcmetadata.FromIncomingContext(ss.Context()); len(md.Get("token")) == 0 {
+			return status.Error(codes.Unauthenticated, "missing token")
+		}
+		return handler(srv, ss)
+	}
+	panicking := func(context.Context, interface{}, *grpc.UnaryServerInfo, grpc.UnaryHandler) (interface{}, error) {
+		panic("boom")
+	}
+	br, err := New(logr.Discard(), NewBlockingChannel(10), map[string]subscriber.SubsChan{},
+		WithMetricsRegisterer(prometheus.NewRegistry()), WithStreamInterceptors(authenticate),
+		WithUnaryInterceptors(panicking))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := metadata.NewMetadataClient(conn)
+	selector := &metadata.Selector{NodeName: "node-1", ResourceKinds: map[string]string{"Pod": "Pod"}}
+
+	stream, err := client.Watch(ctx, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected the subscriber without token to be refused, got %v", err)
+	}
+
+	stream, err = client.Watch(grpcmetadata.AppendToOutgoingContext(ctx, "token", "secret"), selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt, err := stream.Recv(); err != nil || evt.Reason != events.SnapshotComplete {
+		t.Fatalf("expected the authenticated subscriber to receive a SnapshotComplete event, got %v, %v", evt, err)
+	}
+
+	// The panic is turned into an Internal error, the broker keeps serving.
+	if _, err := client.Resync(ctx, &metadata.ResyncRequest{Uid: "uid"}); status.Code(err) != codes.Internal {
+		t.Errorf("expected the panic to be returned as Internal, got %v", err)
+	}
+
+	handled := func(typ, method, code string) float64 {
+		return testutil.ToFloat64(br.metrics.rpcHandled.WithLabelValues(typ, "metadata.Metadata", method, code))
+	}
+	if got := handled(rpcTypeStream, "Watch", codes.Unauthenticated.String()); got != 1 {
+		t.Errorf("expected one refused Watch, got %v", got)
+	}
+	if got := handled(rpcTypeUnary, "Resync", codes.Internal.String()); got != 1 {
+		t.Errorf("expected one failed Resync, got %v", got)
+	}
+	if got := testutil.ToFloat64(br.metrics.rpcStarted.WithLabelValues(rpcTypeStream, "metadata.Metadata", "Watch")); got != 2 {
+		t.Errorf("expected two started Watch, got %v", got)
+	}
+}
+
+// panickingStream is a stream of a subscriber that panics on send.
+type panickingStream struct {
+	recordingStream
+}
+
+func (s *panickingStream) Send(*metadata.Event) error {
+	panic("boom")
+}
+
+func TestDeliverPanic(t *testing.T) {
+	br := &Broker{logger: logr.Discard(), metrics: defaultBrokerMetrics}
+	err := br.deliver(metadata.Connection{Stream: &panickingStream{}}, &metadata.Event{Kind: "Pod"}, time.Time{})
+	if !errors.Is(err, ErrPanic) || status.Code(err) != codes.Internal {
+		t.Errorf("expected the panic to be returned as an Internal error, got %v", err)
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	tests := map[string]struct {
+		fullMethod      string
+		service, method string
+	}{
+		"full name": {fullMethod: "/metadata.Metadata/Watch", service: "metadata.Metadata", method: "Watch"},
+		"malformed": {fullMethod: "Watch", service: "unknown", method: "Watch"},
+	}
+	for name, tt := range tests {
+		if service, method := splitMethod(tt.fullMethod); service != tt.service || method != tt.method {
+			t.Errorf("%s: expected %s %s, got %s %s", name, tt.service, tt.method, service, method)
+		}
+	}
+}
@@ -16,11 +16,13 @@
 package broker
 
 import (
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/metricsutil"
 	"github.com/prometheus/client_golang/prometheus"
 	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -30,44 +32,317 @@ const (
 	queueLatencyKey     = "queue_duration_seconds"
 	addsKey             = "queue_adds"
 	dispatchedEventsKey = "dispatched_events"
+	dryRunEventsKey     = "dry_run_events"
+	throttledEventsKey  = "throttled_events"
+	queueDepthKey       = "queue_depth"
+	queueTypeDepthKey   = "queue_depth_by_type"
+	queueOldestAgeKey   = "queue_oldest_event_age_seconds"
+	queuePushesKey      = "queue_pushes"
+	queuePopsKey        = "queue_pops"
+	queueDroppedKey     = "queue_dropped_events"
+	deliveryLatencyKey  = "event_delivery_latency_seconds"
+	queueWaitKey        = "event_queue_wait_seconds"
+	listenerRestartsKey = "listener_restarts"
+	rpcStartedKey       = "rpc_started"
+	rpcHandledKey       = "rpc_handled"
+
+	labelCoalesced = "coalesced"
+	labelDelayed   = "delayed"
 )
 
-var (
-	// latency is a prometheus metric which keeps track of the duration
-	// of sending events from collectors to the message broker.
-	latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: consts.MetricsNamespace,
-		Subsystem: brokerSubsystem,
-		Name:      queueLatencyKey,
-		Help:      "How long in seconds an event stays in the queue before being requested.",
-		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
-	}, []string{"name"})
-
-	adds = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: consts.MetricsNamespace,
-		Subsystem: brokerSubsystem,
-		Name:      addsKey,
-		Help:      "Total number of events handled by the queue",
-	}, []string{"name", "type"})
+// eventLatencyBuckets are the buckets of the latency histograms of the events, from 1ms to 30s.
+var eventLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
 
+// brokerMetrics holds the metrics of the broker. The broker registers them in the controller-runtime registry by
+// default, or in the registry configured with WithMetricsRegisterer.
+type brokerMetrics struct {
 	// dispatchedEvents is a prometheus counter metrics which holds the total
 	// number of events generated per resource kind. It has two labels. kind label refers
 	// to the resource kind and type label refers to the event type, i.e.
 	// added, updated, deleted.
-	dispatchedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: consts.MetricsNamespace,
-		Subsystem: brokerSubsystem,
-		Name:      dispatchedEventsKey,
-		Help: "Total number of events generated per resource kind destined to subscribers. kind label refers to the " +
-			"resource kind and type label refers to the event type, i.e. create, update, delete, generic",
-	}, []string{"kind", "type"})
+	dispatchedEvents *prometheus.CounterVec
+	// throttledEvents is a prometheus counter metrics which holds the total number of events handled by the
+	// per-subscriber throttles. The kind label refers to the resource kind and the result label is either
+	// coalesced, for the events folded in a pending one, or delayed, for the events sent after waiting for the rate.
+	throttledEvents *prometheus.CounterVec
+	// deliveryLatency is a prometheus histogram which keeps track of the time from the generation of an event by
+	// a collector to its write on the stream of a subscriber, per resource kind and event type.
+	deliveryLatency *prometheus.HistogramVec
+	// queueWait is a prometheus histogram which keeps track of the time from the generation of an event by a
+	// collector to its pop from the queue by the broker, per resource kind and event type. Compared with the
+	// deliveryLatency it tells the time spent in the queue from the time spent writing to the subscribers.
+	queueWait *prometheus.HistogramVec
+	// listenerRestarts is a prometheus counter metrics which holds the total number of times the listener of the
+	// broker failed and has been restarted.
+	listenerRestarts prometheus.Counter
+	// rpcStarted is a prometheus counter metrics which holds the total number of RPCs started on the grpc server of
+	// the broker. The type label is either unary or stream, and the service and method labels refer to the RPC.
+	rpcStarted *prometheus.CounterVec
+	// rpcHandled is a prometheus counter metrics which holds the total number of RPCs completed on the grpc server of
+	// the broker, with the same labels as rpcStarted and the code label referring to the grpc status code returned.
+	rpcHandled *prometheus.CounterVec
+}
+
+// newBrokerMetrics returns the metrics of the broker in the given namespace, not registered in any registry.
+func newBrokerMetrics(namespace string) *brokerMetrics {
+	return &brokerMetrics{
+		dispatchedEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      dispatchedEventsKey,
+			Help: "Total number of events generated per resource kind destined to subscribers. kind label refers to the " +
+				"resource kind and type label refers to the event type, i.e. create, update, delete, generic",
+		}, []string{"kind", "type"}),
+		throttledEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      throttledEventsKey,
+			Help: "Total number of events coalesced or delayed by the per-subscriber throttles. kind label refers to " +
+				"the resource kind and result label is either coalesced or delayed",
+		}, []string{"kind", "result"}),
+		deliveryLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      deliveryLatencyKey,
+			Help: "How long in seconds from the generation of an event to its write on a subscriber stream. kind label " +
+				"refers to the resource kind and type label refers to the event type, i.e. create, update, delete",
+			Buckets: eventLatencyBuckets,
+		}, []string{"kind", "type"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queueWaitKey,
+			Help: "How long in seconds from the generation of an event to its pop from the queue. kind label refers to " +
+				"the resource kind and type label refers to the event type, i.e. create, update, delete",
+			Buckets: eventLatencyBuckets,
+		}, []string{"kind", "type"}),
+		listenerRestarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      listenerRestartsKey,
+			Help:      "Total number of times the listener of the broker failed and has been restarted.",
+		}),
+		rpcStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      rpcStartedKey,
+			Help: "Total number of RPCs started on the grpc server. type label is either unary or stream, service and " +
+				"method labels refer to the RPC",
+		}, []string{"type", "service", "method"}),
+		rpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      rpcHandledKey,
+			Help: "Total number of RPCs completed on the grpc server. type label is either unary or stream, service and " +
+				"method labels refer to the RPC and code label refers to the grpc status code returned",
+		}, []string{"type", "service", "method", "code"}),
+	}
+}
+
+// registerBrokerMetrics registers the metrics of the broker in the given registry, with the given prefix prepended to
+// their names, and returns them. If the registry already holds them, the registered ones are returned. A nil
+// registry, or the controller-runtime one, without prefix returns the default metrics.
+func registerBrokerMetrics(reg prometheus.Registerer, prefix string) (*brokerMetrics, error) {
+	if reg == nil {
+		reg = ctrlmetrics.Registry
+	}
+	if reg == ctrlmetrics.Registry && prefix == "" {
+		return defaultBrokerMetrics, nil
+	}
+
+	var errs []error
+	m := newBrokerMetrics(metricsutil.Namespace(prefix))
+	registered := &brokerMetrics{
+		dispatchedEvents: metricsutil.RegisterOrGet(reg, m.dispatchedEvents, &errs),
+		throttledEvents:  metricsutil.RegisterOrGet(reg, m.throttledEvents, &errs),
+		deliveryLatency:  metricsutil.RegisterOrGet(reg, m.deliveryLatency, &errs),
+		queueWait:        metricsutil.RegisterOrGet(reg, m.queueWait, &errs),
+		listenerRestarts: metricsutil.RegisterOrGet(reg, m.listenerRestarts, &errs),
+		rpcStarted:       metricsutil.RegisterOrGet(reg, m.rpcStarted, &errs),
+		rpcHandled:       metricsutil.RegisterOrGet(reg, m.rpcHandled, &errs),
+	}
+	return registered, errors.Join(errs...)
+}
+
+// QueueMetrics holds the metrics of the queues, shared by the queues registering them in the same registry. The
+// queues use the ones registered in the controller-runtime registry unless configured with WithQueueMetrics.
+type QueueMetrics struct {
+	// latency is a prometheus metric which keeps track of the duration
+	// of sending events from collectors to the message broker.
+	latency *prometheus.HistogramVec
+	adds    *prometheus.CounterVec
+	// dryRunEvents is a prometheus counter metrics which holds the total number of events
+	// generated per resource kind when running in dry-run mode. It has the same labels as
+	// dispatchedEvents.
+	dryRunEvents *prometheus.CounterVec
+	// depth is a prometheus gauge metrics which holds the number of events waiting in the queue. The name label
+	// refers to the queue and the kind label to the resource kind of the events, i.e. the collector that generated
+	// them.
+	depth *prometheus.GaugeVec
+	// typeDepth is a prometheus gauge metrics which holds the number of events waiting in the queue per event
+	// type. Paired with depth it tells whether a type of events, e.g. the deletions, is lagging behind.
+	typeDepth *prometheus.GaugeVec
+	// pushes and pops are prometheus counter metrics which hold the total number of events pushed to and
+	// popped from the queue per resource kind.
+	pushes *prometheus.CounterVec
+	pops   *prometheus.CounterVec
+	// dropped is a prometheus counter metrics which holds the total number of events dropped by a queue per
+	// resource kind, because it was full or because the broker stopped.
+	dropped *prometheus.CounterVec
+	// oldestAge collects the age of the oldest event waiting in each queue per resource kind. The age is
+	// computed when the metrics are scraped, so it grows while the head of the queue is stuck.
+	oldestAge *oldestAgeCollector
+}
+
+// newQueueMetrics returns the metrics of the queues in the given namespace, not registered in any registry.
+func newQueueMetrics(namespace string) *QueueMetrics {
+	return &QueueMetrics{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queueLatencyKey,
+			Help:      "How long in seconds an event stays in the queue before being requested.",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+		}, []string{"name"}),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      addsKey,
+			Help:      "Total number of events handled by the queue",
+		}, []string{"name", "type"}),
+		dryRunEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      dryRunEventsKey,
+			Help: "Total number of events generated per resource kind in dry-run mode. kind label refers to the " +
+				"resource kind and type label refers to the event type, i.e. create, update, delete",
+		}, []string{"kind", "type"}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queueDepthKey,
+			Help:      "Number of events waiting in the queue per resource kind.",
+		}, []string{"name", "kind"}),
+		typeDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queueTypeDepthKey,
+			Help: "Number of events waiting in the queue per event type. type label refers to the event type, i.e. " +
+				"create, update, delete",
+		}, []string{"name", "type"}),
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queuePushesKey,
+			Help:      "Total number of events pushed to the queue per resource kind.",
+		}, []string{"name", "kind"}),
+		pops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queuePopsKey,
+			Help:      "Total number of events popped from the queue per resource kind.",
+		}, []string{"name", "kind"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: brokerSubsystem,
+			Name:      queueDroppedKey,
+			Help:      "Total number of events dropped by the queue when full or stopped per resource kind.",
+		}, []string{"name", "kind"}),
+		oldestAge: &oldestAgeCollector{
+			desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, brokerSubsystem, queueOldestAgeKey),
+				"Age in seconds of the oldest event waiting in the queue per resource kind.", []string{"name", "kind"}, nil),
+			queues: &sync.Map{},
+		},
+	}
+}
+
+// NewQueueMetrics registers the metrics of the queues in the given registry, with the given prefix prepended to their
+// names, and returns them, e.g. to tell them apart from the ones of the program embedding the broker. If the registry
+// already holds them, the registered ones are returned. It returns an error if the registry holds different metrics
+// with the same names. A nil registry, or the controller-runtime one, without prefix returns the default metrics.
+func NewQueueMetrics(reg prometheus.Registerer, prefix string) (*QueueMetrics, error) {
+	if reg == nil {
+		reg = ctrlmetrics.Registry
+	}
+	if reg == ctrlmetrics.Registry && prefix == "" {
+		return defaultQueueMetrics, nil
+	}
+
+	var errs []error
+	m := newQueueMetrics(metricsutil.Namespace(prefix))
+	registered := &QueueMetrics{
+		latency:      metricsutil.RegisterOrGet(reg, m.latency, &errs),
+		adds:         metricsutil.RegisterOrGet(reg, m.adds, &errs),
+		dryRunEvents: metricsutil.RegisterOrGet(reg, m.dryRunEvents, &errs),
+		depth:        metricsutil.RegisterOrGet(reg, m.depth, &errs),
+		typeDepth:    metricsutil.RegisterOrGet(reg, m.typeDepth, &errs),
+		pushes:       metricsutil.RegisterOrGet(reg, m.pushes, &errs),
+		pops:         metricsutil.RegisterOrGet(reg, m.pops, &errs),
+		dropped:      metricsutil.RegisterOrGet(reg, m.dropped, &errs),
+		oldestAge:    metricsutil.RegisterOrGet(reg, m.oldestAge, &errs),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+var (
+	// defaultBrokerMetrics are the metrics of the broker registered in the controller-runtime registry.
+	defaultBrokerMetrics = newBrokerMetrics(consts.MetricsNamespace)
+	// defaultQueueMetrics are the metrics of the queues registered in the controller-runtime registry.
+	defaultQueueMetrics = newQueueMetrics(consts.MetricsNamespace)
 )
 
 func init() {
 	// Register custom metrics with the global prometheus registry.
-	ctrlmetrics.Registry.MustRegister(latency)
-	ctrlmetrics.Registry.MustRegister(adds)
-	ctrlmetrics.Registry.MustRegister(dispatchedEvents)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.latency)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.adds)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.dispatchedEvents)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.dryRunEvents)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.throttledEvents)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.depth)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.typeDepth)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.pushes)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.pops)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.dropped)
+	ctrlmetrics.Registry.MustRegister(defaultQueueMetrics.oldestAge)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.deliveryLatency)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.queueWait)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.listenerRestarts)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.rpcStarted)
+	ctrlmetrics.Registry.MustRegister(defaultBrokerMetrics.rpcHandled)
+}
+
+// observeSince records in the histogram the time elapsed since the given time, if set.
+func observeSince(h *prometheus.HistogramVec, created time.Time, kind, reason string) {
+	if created.IsZero() {
+		return
+	}
+	h.WithLabelValues(kind, reason).Observe(time.Since(created).Seconds())
+}
+
+// oldestAgeCollector is a prometheus collector which computes the age of the oldest event waiting in the queues.
+type oldestAgeCollector struct {
+	desc *prometheus.Desc
+	// queues are stored using as key the name of the queue and as value its metrics.
+	queues *sync.Map
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *oldestAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *oldestAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	c.queues.Range(func(key, value any) bool {
+		for kind, age := range value.(*metrics).oldestAges(now) {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age, key.(string), kind)
+		}
+		return true
+	})
 }
 
 // metrics holds the metrics related to queue. It tracks the number of produced events for each type of events.
@@ -79,27 +354,96 @@ type metrics struct {
 	deleteCounter   prometheus.Counter
 	latencyObserver prometheus.Observer
 	sentTimes       map[interface{}]time.Time
+	name            string
+	queueMetrics    *QueueMetrics
+	// kinds holds the metrics of the events per resource kind.
+	kinds map[string]*kindMetrics
+	// types holds the number of events waiting in the queue per event type.
+	types map[string]prometheus.Gauge
+}
+
+// kindMetrics holds the metrics of the queue for a resource kind.
+type kindMetrics struct {
+	depth   prometheus.Gauge
+	pushes  prometheus.Counter
+	pops    prometheus.Counter
+	dropped prometheus.Counter
 }
 
-// newMetrics returns a new ChannelMetrics ready to be used.
-func newMetrics(name string) *metrics {
+// newMetrics returns a new ChannelMetrics ready to be used, recording in the given metrics of the queues.
+func newMetrics(qm *QueueMetrics, name string) *metrics {
 	// Initialize counters.
-	addCounter := adds.WithLabelValues(name, events.Create)
+	addCounter := qm.adds.WithLabelValues(name, events.Create)
 	addCounter.Add(0)
-	updateCounter := adds.WithLabelValues(name, events.Update)
+	updateCounter := qm.adds.WithLabelValues(name, events.Update)
 	updateCounter.Add(0)
-	deleteCounter := adds.WithLabelValues(name, events.Delete)
+	deleteCounter := qm.adds.WithLabelValues(name, events.Delete)
 	deleteCounter.Add(0)
-	latencyObserver := latency.WithLabelValues(name)
+	latencyObserver := qm.latency.WithLabelValues(name)
 
-	return &metrics{
+	m := &metrics{
 		Mutex:           sync.Mutex{},
 		addCounter:      addCounter,
 		updateCounter:   updateCounter,
 		deleteCounter:   deleteCounter,
 		latencyObserver: latencyObserver,
 		sentTimes:       make(map[interface{}]time.Time),
+		name:            name,
+		queueMetrics:    qm,
+		kinds:           make(map[string]*kindMetrics),
+		types:           make(map[string]prometheus.Gauge),
+	}
+	// Initialize the depths of the known event types, so that they are exported even if no event is queued.
+	for _, evtType := range []string{events.Create, events.Update, events.Delete} {
+		m.typeDepth(evtType).Set(0)
 	}
+	qm.oldestAge.queues.Store(name, m)
+
+	return m
+}
+
+// kind returns the metrics for the given resource kind. The caller must hold the lock.
+func (m *metrics) kind(kind string) *kindMetrics {
+	km, ok := m.kinds[kind]
+	if !ok {
+		km = &kindMetrics{
+			depth:   m.queueMetrics.depth.WithLabelValues(m.name, kind),
+			pushes:  m.queueMetrics.pushes.WithLabelValues(m.name, kind),
+			pops:    m.queueMetrics.pops.WithLabelValues(m.name, kind),
+			dropped: m.queueMetrics.dropped.WithLabelValues(m.name, kind),
+		}
+		m.kinds[kind] = km
+	}
+	return km
+}
+
+// typeDepth returns the depth gauge for the given event type. The caller must hold the lock once the metrics are shared.
+func (m *metrics) typeDepth(evtType string) prometheus.Gauge {
+	gauge, ok := m.types[evtType]
+	if !ok {
+		gauge = m.queueMetrics.typeDepth.WithLabelValues(m.name, evtType)
+		m.types[evtType] = gauge
+	}
+	return gauge
+}
+
+// oldestAges returns the age of the oldest event waiting in the queue for each resource kind seen by the queue.
+// The kinds without waiting events have a zero age.
+func (m *metrics) oldestAges(now time.Time) map[string]float64 {
+	m.Lock()
+	defer m.Unlock()
+
+	ages := make(map[string]float64, len(m.kinds))
+	for kind := range m.kinds {
+		ages[kind] = 0
+	}
+	for evt, sentTime := range m.sentTimes {
+		kind := evt.(events.Interface).ResourceKind()
+		if age := now.Sub(sentTime).Seconds(); age > ages[kind] {
+			ages[kind] = age
+		}
+	}
+	return ages
 }
 
 // send to be called before adding the item to the queue.
@@ -119,25 +463,50 @@ func (m *metrics) send(evt events.Interface) {
 		m.deleteCounter.Inc()
 	}
 
+	km := m.kind(evt.ResourceKind())
+	km.pushes.Inc()
+	km.depth.Inc()
+	m.typeDepth(evt.Type()).Inc()
+
 	if _, ok := m.sentTimes[evt]; !ok {
 		m.sentTimes[evt] = time.Now()
 	}
 }
 
 // receive to be called after the item has been pooped from the queue.
-func (m *metrics) receive(evt interface{}) {
+func (m *metrics) receive(evt events.Interface) {
 	if m == nil {
 		return
 	}
 	m.Lock()
 	defer m.Unlock()
 
+	km := m.kind(evt.ResourceKind())
+	km.pops.Inc()
+	km.depth.Dec()
+	m.typeDepth(evt.Type()).Dec()
+
 	if startTime, ok := m.sentTimes[evt]; ok {
 		m.latencyObserver.Observe(time.Since(startTime).Seconds())
 		delete(m.sentTimes, evt)
 	}
 }
 
+// drop to be called after the item has been dropped from the queue without being popped.
+func (m *metrics) drop(evt events.Interface) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	km := m.kind(evt.ResourceKind())
+	km.dropped.Inc()
+	km.depth.Dec()
+	m.typeDepth(evt.Type()).Dec()
+	delete(m.sentTimes, evt)
+}
+
 type dispatchedEventsMetrics struct {
 	createCounter prometheus.Counter
 	updateCounter prometheus.Counter
@@ -155,7 +524,7 @@ func (dem *dispatchedEventsMetrics) inc(evt events.Interface) {
 	}
 }
 
-func newDispatchedEventsMetrics(name string) dispatchedEventsMetrics {
+func newDispatchedEventsMetrics(dispatchedEvents *prometheus.CounterVec, name string) dispatchedEventsMetrics {
 	createCounter := dispatchedEvents.WithLabelValues(name, events.Create)
 	createCounter.Add(0)
 	updateCounter := dispatchedEvents.WithLabelValues(name, events.Update)
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBrokerMetricsRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	br, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{"MetricsTest": nil},
+		WithMetricsRegisterer(reg), WithMetricsPrefix("host"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.metrics == defaultBrokerMetrics {
+		t.Fatal("expected the broker not to use the default metrics")
+	}
+	// The dispatched events are initialized for each collector.
+	if got, err := testutil.GatherAndCount(reg, "host_meta_collector_broker_dispatched_events"); err != nil || got != 3 {
+		t.Errorf("expected the prefixed metrics to be exposed, got %d series (%v)", got, err)
+	}
+
+	// The metrics of the grpc server are registered along with the ones of the broker.
+	if got, err := testutil.GatherAndCount(reg, "host_meta_collector_server_subscribers"); err != nil || got != 1 {
+		t.Errorf("expected the prefixed metrics of the grpc server to be exposed, got %d series (%v)", got, err)
+	}
+
+	// A second broker on the same registry shares the metrics.
+	other, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{},
+		WithMetricsRegisterer(reg), WithMetricsPrefix("host"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.metrics.dispatchedEvents != br.metrics.dispatchedEvents {
+		t.Error("expected the brokers sharing the registry to share the metrics")
+	}
+
+	// The metrics colliding with the ones of the embedding program fail the creation of the broker.
+	conflicting := prometheus.NewRegistry()
+	conflicting.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "meta_collector_broker_listener_restarts"}))
+	if _, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{},
+		WithMetricsRegisterer(conflicting)); err == nil || !strings.Contains(err.Error(), "listener_restarts") {
+		t.Errorf("expected an error naming the conflicting metric, got %v", err)
+	}
+}
+
+func TestQueueMetrics(t *testing.T) {
+	const kind = "QueueMetricsTest"
+	reg := prometheus.NewRegistry()
+	qm, err := NewQueueMetrics(reg, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := NewQueue(QueueRing, 10, WithQueueMetrics(qm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = queue.Push(&events.Event{Event: &metadata.Event{Kind: kind, Reason: events.Create}})
+	queue.Pop(context.Background())
+	_ = NewDryRunQueue(logr.Discard(), nil, WithQueueMetrics(qm)).Push(&events.Event{Event: &metadata.Event{Kind: kind,
+		Reason: events.Create}})
+
+	for name, got := range map[string]float64{
+		"pushes":  testutil.ToFloat64(qm.pushes.WithLabelValues("ringBuffer", kind)),
+		"pops":    testutil.ToFloat64(qm.pops.WithLabelValues("ringBuffer", kind)),
+		"dry-run": testutil.ToFloat64(qm.dryRunEvents.WithLabelValues(kind, events.Create)),
+	} {
+		if got != 1 {
+			t.Errorf("%s: expected the event to be recorded in the configured metrics, got %v", name, got)
+		}
+	}
+	if got := testutil.ToFloat64(defaultQueueMetrics.pushes.WithLabelValues("ringBuffer", kind)); got != 0 {
+		t.Errorf("expected no event recorded in the default metrics, got %v", got)
+	}
+	if got, err := testutil.GatherAndCount(reg, "host_meta_collector_broker_queue_pushes",
+		"host_meta_collector_broker_queue_oldest_event_age_seconds"); err != nil || got != 2 {
+		t.Errorf("expected the prefixed metrics to be exposed, got %d series (%v)", got, err)
+	}
+
+	// The queue metrics are registered once per registry.
+	if again, err := NewQueueMetrics(reg, "host"); err != nil || again.pushes != qm.pushes {
+		t.Errorf("expected the registered metrics to be returned, got %v", err)
+	}
+	if m, err := NewQueueMetrics(nil, ""); err != nil || m != defaultQueueMetrics {
+		t.Errorf("expected the default metrics, got %v", err)
+	}
+
+	conflicting := prometheus.NewRegistry()
+	conflicting.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "meta_collector_broker_queue_pops"}))
+	if _, err := NewQueueMetrics(conflicting, ""); err == nil || !strings.Contains(err.Error(), "queue_pops") {
+		t.Errorf("expected an error naming the conflicting metric, got %v", err)
+	}
+}
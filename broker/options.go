@@ -15,10 +15,42 @@
 
 package broker
 
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/audit"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
 type options struct {
 	address               string
 	tlsServerCertFilePath string
 	tlsServerKeyFilePath  string
+	dryRun                bool
+	barrier               *health.Barrier
+	throttleRate          float64
+	throttleBurst         int
+	maxDeleteDelay        time.Duration
+	maxBackfills          int
+	nodeTakeover          bool
+	reflection            bool
+	resyncInterval        time.Duration
+	nodeMetrics           bool
+	clusterName           string
+	maxMessageSize        int
+	audit                 *audit.Sink
+	syncStatus            *health.SyncStatus
+	progress              *health.Progress
+	sendTimeout           time.Duration
+	metricsRegisterer     prometheus.Registerer
+	metricsPrefix         string
+	rpcLogVerbosity       int
+	unaryInterceptors     []grpc.UnaryServerInterceptor
+	streamInterceptors    []grpc.StreamServerInterceptor
 }
 
 // Option function used to set options when creating a new Broker instance.
@@ -39,3 +71,186 @@ func WithAddress(addr string) Option {
 		opt.address = addr
 	}
 }
+
+// WithDryRun configures the grpc server started by the broker to refuse all the subscriptions.
+func WithDryRun(dryRun bool) Option {
+	return func(opt *options) {
+		opt.dryRun = dryRun
+	}
+}
+
+// WithBarrier configures the grpc server started by the broker to refuse the subscriptions until
+// the barrier is lifted.
+func WithBarrier(barrier *health.Barrier) Option {
+	return func(opt *options) {
+		opt.barrier = barrier
+	}
+}
+
+// WithThrottle configures the broker to pace the events sent to each subscriber to at most eventsPerSecond events,
+// with bursts of up to burst events. The pending events for the same resource are coalesced, and the Delete events
+// are never delayed more than maxDeleteDelay. A non-positive rate disables the throttling.
+func WithThrottle(eventsPerSecond float64, burst int, maxDeleteDelay time.Duration) Option {
+	return func(opt *options) {
+		opt.throttleRate = eventsPerSecond
+		opt.throttleBurst = burst
+		opt.maxDeleteDelay = maxDeleteDelay
+	}
+}
+
+// WithMaxBackfills configures the grpc server started by the broker to run at most the given number of backfills of
+// new subscribers at once. A non-positive value does not limit the backfills.
+func WithMaxBackfills(maxBackfills int) Option {
+	return func(opt *options) {
+		opt.maxBackfills = maxBackfills
+	}
+}
+
+// WithNodeTakeover configures the grpc server started by the broker to keep a single subscription per node: a new
+// subscription for a node closes the streams of the previous ones, whose events are discarded, and the new subscriber
+// receives the existing resources as any other.
+func WithNodeTakeover(enabled bool) Option {
+	return func(opt *options) {
+		opt.nodeTakeover = enabled
+	}
+}
+
+// WithReflection configures the grpc server started by the broker to serve the grpc reflection service, which lets
+// tools such as grpcurl discover the services of the broker.
+func WithReflection(enabled bool) Option {
+	return func(opt *options) {
+		opt.reflection = enabled
+	}
+}
+
+// WithResyncInterval configures the minimum interval between two resyncs asked by a subscriber to the grpc server
+// started by the broker. A non-positive value does not limit the resyncs.
+func WithResyncInterval(interval time.Duration) Option {
+	return func(opt *options) {
+		opt.resyncInterval = interval
+	}
+}
+
+// WithNodeMetrics configures the grpc server started by the broker to label the metrics of the events sent to the
+// subscribers with their node.
+func WithNodeMetrics(enabled bool) Option {
+	return func(opt *options) {
+		opt.nodeMetrics = enabled
+	}
+}
+
+// WithClusterName configures the name of the cluster stamped on the events generated by the broker.
+func WithClusterName(name string) Option {
+	return func(opt *options) {
+		opt.clusterName = name
+	}
+}
+
+// WithMaxMessageSize configures the size above which the events sent to the subscribers are split in chunks.
+func WithMaxMessageSize(size int) Option {
+	return func(opt *options) {
+		opt.maxMessageSize = size
+	}
+}
+
+// WithAudit configures the sink recording the events sent to the subscribers.
+func WithAudit(sink *audit.Sink) Option {
+	return func(opt *options) {
+		opt.audit = sink
+	}
+}
+
+// WithSyncStatus configures the sync status where the broker registers its listener, reported as ready once it
+// accepts connections.
+func WithSyncStatus(syncStatus *health.SyncStatus) Option {
+	return func(opt *options) {
+		opt.syncStatus = syncStatus
+	}
+}
+
+// WithProgress configures the tracker of the progress of the dispatch loop, reported as stuck when the delivery of
+// an event takes too long.
+func WithProgress(progress *health.Progress) Option {
+	return func(opt *options) {
+		opt.progress = progress
+	}
+}
+
+// WithSendTimeout configures the time a subscriber has to receive each event: the stream of a subscriber that does
+// not receive it in time is closed, so that a wedged stream does not block the delivery to the other subscribers. A
+// non-positive value waits for the subscribers forever.
+func WithSendTimeout(timeout time.Duration) Option {
+	return func(opt *options) {
+		opt.sendTimeout = timeout
+	}
+}
+
+// WithMetricsRegisterer configures the registry where the broker registers its metrics, and the ones of its grpc
+// server. If not set, the controller-runtime metrics registry is used. The metrics of the queue are configured on the
+// queue itself.
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(opt *options) {
+		opt.metricsRegisterer = reg
+	}
+}
+
+// WithMetricsPrefix configures the prefix prepended to the names of the metrics of the broker and of its grpc server,
+// e.g. to tell them apart from the ones of the program embedding the broker. If not set, the metrics keep their names.
+func WithMetricsPrefix(prefix string) Option {
+	return func(opt *options) {
+		opt.metricsPrefix = prefix
+	}
+}
+
+// WithRPCLogVerbosity configures the verbosity of the logs of the RPCs served by the broker, logged once completed
+// along with their status code, and when they start for the streaming ones. If not set, they are logged at verbosity
+// 2. A negative value disables them.
+func WithRPCLogVerbosity(verbosity int) Option {
+	return func(opt *options) {
+		opt.rpcLogVerbosity = verbosity
+	}
+}
+
+// WithUnaryInterceptors appends the given interceptors to the chain of the unary RPCs served by the broker, e.g. to
+// authenticate the subscribers. They run in order, after the built-in ones counting and logging the RPCs and
+// recovering their panics.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(opt *options) {
+		opt.unaryInterceptors = append(opt.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors appends the given interceptors to the chain of the streaming RPCs served by the broker,
+// e.g. to authenticate the subscribers. They run in order, after the built-in ones counting and logging the RPCs
+// and recovering their panics.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(opt *options) {
+		opt.streamInterceptors = append(opt.streamInterceptors, interceptors...)
+	}
+}
+
+// validate returns the problems of the options of a broker consuming the given queue, each naming the offending
+// option, joined in a single error. It returns nil if the options are valid.
+func (o *options) validate(queue Queue) error {
+	var errs []error
+	if queue == nil {
+		errs = append(errs, errors.New("queue: the queue of the events must be set"))
+	}
+	if o.tlsServerCertFilePath != "" && o.tlsServerKeyFilePath == "" {
+		errs = append(errs, errors.New("WithTLS: the certificate file path is set without the key file path"))
+	}
+	if o.tlsServerKeyFilePath != "" && o.tlsServerCertFilePath == "" {
+		errs = append(errs, errors.New("WithTLS: the key file path is set without the certificate file path"))
+	}
+	if o.throttleRate > 0 && o.throttleBurst < 1 {
+		errs = append(errs, fmt.Errorf("WithThrottle: the burst must be at least 1, got %d", o.throttleBurst))
+	}
+	if o.resyncInterval < 0 {
+		errs = append(errs, fmt.Errorf("WithResyncInterval: the interval must not be negative, got %s", o.resyncInterval))
+	}
+	if o.maxDeleteDelay < 0 {
+		errs = append(errs, fmt.Errorf("WithThrottle: the maximum delay of the Delete events must not be negative, got %s",
+			o.maxDeleteDelay))
+	}
+	return errors.Join(errs...)
+}
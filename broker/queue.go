@@ -17,12 +17,82 @@ package broker
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 )
 
-// Queue used to dispatch events from the collectors to the broker.
+// Queue used to dispatch events from the collectors to the broker. Push returns ErrQueueClosed when the event is
+// dropped because the queue has been closed, the collectors then roll back the changes made to their caches.
 type Queue interface {
-	Push(evt events.Interface)
+	Push(evt events.Interface) error
 	Pop(ctx context.Context) events.Interface
 }
+
+// Closable is implemented by the queues that drop the pushed events once closed, e.g. when their consumer stops.
+type Closable interface {
+	Closed() bool
+}
+
+// IsClosed returns true if the events pushed to the queue are lost: the queue is nil or it has been closed. The
+// collectors check it before changing their state, the queue can still be closed before their events are pushed.
+func IsClosed(q Queue) bool {
+	if q == nil {
+		return true
+	}
+	if c, ok := q.(Closable); ok {
+		return c.Closed()
+	}
+	return false
+}
+
+const (
+	// QueueBlocking is the queue blocking the collectors when the broker lags behind.
+	QueueBlocking = "blocking"
+	// QueueRing is the bounded queue dropping the oldest events when the broker lags behind, the subscribers of the
+	// dropped events are disconnected to resync.
+	QueueRing = "ring"
+)
+
+// queueOptions are the options of the queues.
+type queueOptions struct {
+	metrics *QueueMetrics
+}
+
+// QueueOption function used to set options when creating a new queue.
+type QueueOption func(opt *queueOptions)
+
+// WithQueueMetrics configures the metrics where the queue records its events, returned by NewQueueMetrics. By
+// default, the queue records them in the metrics registered in the controller-runtime registry.
+func WithQueueMetrics(m *QueueMetrics) QueueOption {
+	return func(opt *queueOptions) {
+		opt.metrics = m
+	}
+}
+
+// newQueueOptions returns the options of a queue set by the given functions.
+func newQueueOptions(opt []QueueOption) queueOptions {
+	opts := queueOptions{metrics: defaultQueueMetrics}
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.metrics == nil {
+		opts.metrics = defaultQueueMetrics
+	}
+	return opts
+}
+
+// NewQueue returns the queue of the given type. The capacity bounds the events waiting in a ring queue.
+func NewQueue(queueType string, capacity int, opt ...QueueOption) (Queue, error) {
+	switch queueType {
+	case QueueBlocking:
+		return NewBlockingChannel(1, opt...), nil
+	case QueueRing:
+		if capacity < 1 {
+			return nil, fmt.Errorf("the capacity of the ring queue must be at least 1, got %d", capacity)
+		}
+		return NewRingBuffer(capacity, opt...), nil
+	default:
+		return nil, fmt.Errorf("unknown queue type %q, must be one of %q, %q", queueType, QueueBlocking, QueueRing)
+	}
+}
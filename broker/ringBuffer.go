@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Resync is the type of the resync markers.
+const Resync = "Resync"
+
+// ResyncMarker is popped from a ring queue once it dropped events. The subscribers of the dropped events missed
+// them, and must resync.
+type ResyncMarker struct {
+	subs      fields.Subscribers
+	createdAt time.Time
+}
+
+// Subscribers returns the subscribers of the dropped events.
+func (rm *ResyncMarker) Subscribers() fields.Subscribers {
+	return rm.subs
+}
+
+// String returns the marker in string format.
+func (rm *ResyncMarker) String() string {
+	return fmt.Sprintf("Resync marker, subscribers %q", rm.subs)
+}
+
+// Type returns the type of the marker.
+func (rm *ResyncMarker) Type() string {
+	return Resync
+}
+
+// ResourceKind returns an empty kind, the marker is not related to a resource.
+func (rm *ResyncMarker) ResourceKind() string {
+	return ""
+}
+
+// GRPCMessage returns nil, the marker is never sent to the subscribers.
+func (rm *ResyncMarker) GRPCMessage() *metadata.Event {
+	return nil
+}
+
+// SpanContext returns an empty span context.
+func (rm *ResyncMarker) SpanContext() trace.SpanContext {
+	return trace.SpanContext{}
+}
+
+// CreatedAt returns the time of the first dropped event.
+func (rm *ResyncMarker) CreatedAt() time.Time {
+	return rm.createdAt
+}
+
+// Origin returns an empty origin, the marker is generated by the queue.
+func (rm *ResyncMarker) Origin() events.Origin {
+	return events.Origin{}
+}
+
+// RingBuffer is a bounded queue: when full, the oldest event is dropped to make room for the pushed one, so that the
+// collectors are never blocked and the memory is bounded when the broker lags behind. Before the next event, a
+// ResyncMarker with the subscribers of the dropped events is popped.
+type RingBuffer struct {
+	mutex  sync.Mutex
+	events []events.Interface
+	head   int
+	size   int
+	// dropped holds the marker of the events dropped since the last one has been popped, nil if none.
+	dropped *ResyncMarker
+	// ready is signaled when events are waiting.
+	ready          chan struct{}
+	metricsHandler *metrics
+}
+
+// NewRingBuffer returns a RingBuffer holding up to capacity events.
+func NewRingBuffer(capacity int, opt ...QueueOption) *RingBuffer {
+	return &RingBuffer{
+		events:         make([]events.Interface, max(capacity, 1)),
+		ready:          make(chan struct{}, 1),
+		metricsHandler: newMetrics(newQueueOptions(opt).metrics, "ringBuffer"),
+	}
+}
+
+// Push adds the event to the queue, dropping the oldest one if the queue is full. It never blocks nor fails.
+func (rb *RingBuffer) Push(evt events.Interface) error {
+	rb.metricsHandler.send(evt)
+
+	rb.mutex.Lock()
+	if rb.size == len(rb.events) {
+		oldest := rb.events[rb.head]
+		rb.events[rb.head] = nil
+		rb.head = (rb.head + 1) % len(rb.events)
+		rb.size--
+		rb.metricsHandler.drop(oldest)
+		if rb.dropped == nil {
+			rb.dropped = &ResyncMarker{subs: make(fields.Subscribers), createdAt: oldest.CreatedAt()}
+		}
+		for sub := range oldest.Subscribers() {
+			rb.dropped.subs.Add(sub)
+		}
+	}
+	rb.events[(rb.head+rb.size)%len(rb.events)] = evt
+	rb.size++
+	rb.mutex.Unlock()
+
+	rb.signal()
+	return nil
+}
+
+// Pop returns the next event, waiting for one to be pushed. It returns nil once the context is canceled.
+func (rb *RingBuffer) Pop(ctx context.Context) events.Interface {
+	for {
+		if evt := rb.next(); evt != nil {
+			return evt
+		}
+		select {
+		case <-rb.ready:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// next returns the pending resync marker or the oldest event, nil if the queue is empty.
+func (rb *RingBuffer) next() events.Interface {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if marker := rb.dropped; marker != nil {
+		rb.dropped = nil
+		return marker
+	}
+	if rb.size == 0 {
+		return nil
+	}
+	evt := rb.events[rb.head]
+	rb.events[rb.head] = nil
+	rb.head = (rb.head + 1) % len(rb.events)
+	rb.size--
+	rb.metricsHandler.receive(evt)
+	// The events left are signaled to the other consumers, if any.
+	if rb.size > 0 {
+		rb.signal()
+	}
+	return evt
+}
+
+// signal wakes up a consumer waiting for events.
+func (rb *RingBuffer) signal() {
+	select {
+	case rb.ready <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of events waiting in the queue.
+func (rb *RingBuffer) len() int {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	return rb.size
+}
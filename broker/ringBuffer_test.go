@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// ringEvent returns an event of the given kind for the given subscriber.
+func ringEvent(kind, sub string) events.Interface {
+	return &events.Event{Event: &metadata.Event{Kind: kind, Reason: events.Create}, Subs: fields.Subscribers{sub: {}}}
+}
+
+func TestRingBufferDropOldest(t *testing.T) {
+	const kind = "RingTest"
+	rb := NewRingBuffer(3)
+	droppedBefore := testutil.ToFloat64(defaultQueueMetrics.dropped.WithLabelValues("ringBuffer", kind))
+	pushed := make([]events.Interface, 5)
+	for i, sub := range []string{"sub-1", "sub-2", "sub-3", "sub-4", "sub-5"} {
+		pushed[i] = ringEvent(kind, sub)
+		_ = rb.Push(pushed[i])
+	}
+	if got := rb.len(); got != 3 {
+		t.Fatalf("expected the queue to hold 3 events, got %d", got)
+	}
+	if got := testutil.ToFloat64(defaultQueueMetrics.dropped.WithLabelValues("ringBuffer", kind)) - droppedBefore; got != 2 {
+		t.Errorf("expected 2 dropped events, got %v", got)
+	}
+
+	// The marker of the dropped events comes first, then the events left in order.
+	marker, ok := rb.Pop(context.Background()).(*ResyncMarker)
+	if !ok {
+		t.Fatal("expected a resync marker")
+	}
+	if subs := marker.Subscribers(); len(subs) != 2 || !subs.Has("sub-1") || !subs.Has("sub-2") {
+		t.Errorf("expected the subscribers of the dropped events, got %v", subs)
+	}
+	for _, want := range pushed[2:] {
+		if evt := rb.Pop(context.Background()); evt != want {
+			t.Errorf("expected event %v, got %v", want, evt)
+		}
+	}
+	if got := testutil.ToFloat64(defaultQueueMetrics.depth.WithLabelValues("ringBuffer", kind)); got != 0 {
+		t.Errorf("expected an empty queue, got a depth of %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if evt := rb.Pop(ctx); evt != nil {
+		t.Errorf("expected no event once the context is done, got %v", evt)
+	}
+}
+
+func TestRingBufferStress(t *testing.T) {
+	const (
+		kind      = "RingStress"
+		capacity  = 1000
+		producers = 4
+		total     = 1000000
+	)
+	heap := func() uint64 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+	rb := NewRingBuffer(capacity)
+	droppedBefore := int(testutil.ToFloat64(defaultQueueMetrics.dropped.WithLabelValues("ringBuffer", kind)))
+	before := heap()
+
+	// A slow consumer lags behind the producers.
+	ctx, cancel := context.WithCancel(context.Background())
+	var popped, markers int
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for {
+			evt := rb.Pop(ctx)
+			if evt == nil {
+				return
+			}
+			if _, ok := evt.(*ResyncMarker); ok {
+				markers++
+				continue
+			}
+			popped++
+			if popped%1000 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < total/producers; i++ {
+				_ = rb.Push(ringEvent(kind, "sub"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := rb.len(); got > capacity {
+		t.Errorf("expected at most %d events waiting, got %d", capacity, got)
+	}
+	// The events are small, an unbounded queue would hold hundreds of megabytes.
+	if after := heap(); after > before && after-before > 32<<20 {
+		t.Errorf("expected the memory to stay bounded, it grew by %d bytes", after-before)
+	}
+
+	// Once drained, each event has either been popped or dropped.
+	for rb.len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-consumed
+	dropped := int(testutil.ToFloat64(defaultQueueMetrics.dropped.WithLabelValues("ringBuffer", kind))) - droppedBefore
+	if popped+dropped != total {
+		t.Errorf("expected %d events popped or dropped, got %d popped and %d dropped", total, popped, dropped)
+	}
+	if dropped > 0 && markers == 0 {
+		t.Error("expected the dropped events to be followed by a resync marker")
+	}
+}
+
+func TestNewQueue(t *testing.T) {
+	if q, err := NewQueue(QueueBlocking, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, ok := q.(*BlockingChannel); !ok {
+		t.Errorf("expected a blocking channel, got %T", q)
+	}
+	if q, err := NewQueue(QueueRing, 10); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if _, ok := q.(*RingBuffer); !ok {
+		t.Errorf("expected a ring buffer, got %T", q)
+	}
+	if _, err := NewQueue(QueueRing, 0); err == nil {
+		t.Error("expected an error for a ring queue without capacity")
+	}
+	if _, err := NewQueue("unbounded", 10); err == nil {
+		t.Error("expected an error for an unknown queue type")
+	}
+}
+
+func TestResyncDisconnectsSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := metadata.NewMetadataClient(conn).Watch(ctx, &metadata.Selector{NodeName: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt, err := stream.Recv(); err != nil || evt.Reason != events.SnapshotComplete {
+		t.Fatalf("expected a SnapshotComplete event, got %v, %v", evt, err)
+	}
+
+	// The subscriber missed the dropped events, it is disconnected to resync.
+	uid := br.SubscriberStates()[0].UID
+	_ = queue.Push(&ResyncMarker{subs: fields.Subscribers{uid: {}}})
+	if _, err := stream.Recv(); status.Code(err) != codes.DataLoss {
+		t.Errorf("expected the stream to be closed with %s, got %v", codes.DataLoss, err)
+	}
+}
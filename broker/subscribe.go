@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// subscribeOptions are the options of an in-process subscription.
+type subscribeOptions struct {
+	kinds      []string
+	encoding   metadata.Encoding
+	mode       metadata.Mode
+	bulkDelete bool
+	bufferSize int
+}
+
+// SubscribeOption function used to set options when subscribing in-process to the broker.
+type SubscribeOption func(opt *subscribeOptions)
+
+// WithResourceKinds sets the resource kinds to subscribe to. By default, all the kinds of the running collectors.
+func WithResourceKinds(kinds ...string) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.kinds = kinds
+	}
+}
+
+// WithEncoding sets the encoding of the metadata in the received events. By default, JSON.
+func WithEncoding(encoding metadata.Encoding) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.encoding = encoding
+	}
+}
+
+// WithMode sets the mode of the subscription. By default, SNAPSHOT_THEN_WATCH. The channel of a SNAPSHOT subscription
+// is closed once the SnapshotComplete event has been delivered.
+func WithMode(mode metadata.Mode) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.mode = mode
+	}
+}
+
+// WithBulkDelete sets whether the subscriber receives the DeleteNamespace event of a deleted namespace in place of the
+// Delete events of its resources. By default, the Delete events.
+func WithBulkDelete(bulkDelete bool) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.bulkDelete = bulkDelete
+	}
+}
+
+// WithBufferSize sets the number of events buffered for the subscriber before the delivery blocks.
+func WithBufferSize(size int) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.bufferSize = size
+	}
+}
+
+// Subscribe subscribes in-process to the events of the resources related to the given node, without going through
+// the grpc server. The subscription goes through the same path as the grpc subscribers: the collectors replay the
+// existing resources followed by a SnapshotComplete event, and the subscription is subject to the same throttling
+// and admission rules. The returned channel is closed once the context is canceled, the node is disconnected, or the
+// broker stops. The events are delivered only while the broker is running.
+//
+// The events are not copied: the grpc message carried by an event is shared with the other subscribers, including
+// the grpc ones that are concurrently marshaling it, and must be treated as read-only. Use proto.Clone to modify it.
+// Only the events exceeding the maximum message size, reassembled from their chunks, are private to the subscriber.
+//
+// As for a grpc stream, the backpressure is applied to the broker: once the buffer is full, the delivery blocks until
+// the subscriber receives the events, unless the throttling is enabled in which case the events wait in the throttle
+// of the subscriber.
+func (br *Broker) Subscribe(ctx context.Context, node string, opts ...SubscribeOption) (<-chan events.Event, error) {
+	opt := subscribeOptions{kinds: br.kinds}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.bufferSize < 0 {
+		return nil, fmt.Errorf("buffer size must not be negative, got %d", opt.bufferSize)
+	}
+
+	selector := &metadata.Selector{
+		NodeName:      node,
+		ResourceKinds: make(map[string]string, len(opt.kinds)),
+		Encoding:      opt.encoding,
+		Mode:          opt.mode,
+		BulkDelete:    opt.bulkDelete,
+	}
+	for _, kind := range opt.kinds {
+		if _, ok := br.eventMetrics[kind]; !ok {
+			return nil, fmt.Errorf("no collector running for resource kind %q", kind)
+		}
+		selector.ResourceKinds[kind] = kind
+	}
+	if err := br.metaServer.Admit(node); err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	// The grpc streams are closed by the grpc server when the broker stops, the in-process ones are closed here.
+	stopAfter := context.AfterFunc(br.stopped, cancel)
+	stream := &localStream{
+		ctx:    streamCtx,
+		events: make(chan events.Event, opt.bufferSize),
+	}
+	go func() {
+		if err := br.metaServer.Watch(selector, stream); err != nil && streamCtx.Err() == nil {
+			br.logger.Error(err, "in-process subscription closed", "node", node)
+		}
+		stopAfter()
+		cancel()
+		stream.close()
+	}()
+	return stream.events, nil
+}
+
+// localStream is the stream of an in-process subscriber. It delivers the events on a channel.
+type localStream struct {
+	// The grpc.ServerStream methods other than Context are never used by the server.
+	grpc.ServerStream
+	ctx context.Context
+	// mutex guards the channel, so that it is never closed while an event is being sent.
+	mutex  sync.Mutex
+	closed bool
+	events chan events.Event
+	chunks metadata.Reassembler
+}
+
+// Context returns the context of the subscription.
+func (s *localStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send delivers the event to the subscriber, reassembling the events split in chunks. It blocks until the event is
+// buffered or the subscription is closed.
+func (s *localStream) Send(msg *metadata.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return status.Error(codes.Canceled, "subscription closed")
+	}
+
+	evt, err := s.chunks.Add(msg)
+	if err != nil || evt == nil {
+		return err
+	}
+	select {
+	case s.events <- events.Event{Event: evt}:
+		return nil
+	case <-s.ctx.Done():
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+// close closes the channel of the subscriber. The context must be canceled before, to unblock a pending send.
+func (s *localStream) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	close(s.events)
+}
@@ -0,0 +1,385 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestSubscribe runs an in-process and a grpc subscriber side by side, and is meant to be run with the race detector.
+func TestSubscribe(t *testing.T) {
+	const count = 200
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods}, WithMaxMessageSize(2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	// The collector replays an existing pod to each new subscriber, and reports the subscribers.
+	subscribed := make(chan subscriber.Message, 2)
+	unsubscribed := make(chan string, 2)
+	go func() {
+		for msg := range pods {
+			if msg.Reason == subscriber.Unsubscribed {
+				unsubscribed <- msg.UID
+				continue
+			}
+			_ = queue.Push(podEvent("existing", msg.UID))
+			msg.Dispatched()
+			subscribed <- msg
+		}
+	}()
+
+	if _, err := br.Subscribe(ctx, "node-1", WithResourceKinds("Deployment")); err == nil {
+		t.Error("expected an error for a kind without collector")
+	}
+	localCtx, unsubscribe := context.WithCancel(ctx)
+	local, err := br.Subscribe(localCtx, "node-1", WithBufferSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	remote, err := metadata.NewMetadataClient(conn).Watch(ctx, &metadata.Selector{
+		NodeName:      "node-1",
+		ResourceKinds: map[string]string{"Pod": "Pod"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := make(fields.Subscribers)
+	for i := 0; i < 2; i++ {
+		msg := <-subscribed
+		if msg.NodeName != "node-1" {
+			t.Errorf("expected a subscriber for node-1, got %q", msg.NodeName)
+		}
+		subs.Add(msg.UID)
+	}
+
+	// Both the subscribers receive the replay, then all the events. The events are read concurrently by both the
+	// subscribers, and the large ones are split in chunks for the grpc subscriber only.
+	var wg sync.WaitGroup
+	received := make([][]string, 2)
+	// snapshots is notified once a subscriber has received its snapshot, after which the events are pushed.
+	snapshots := make(chan struct{}, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for evt := range local {
+			received[0] = append(received[0], evt.GetReason()+"/"+evt.GetUid()+"/"+evt.GetMeta())
+			if evt.GetReason() == events.SnapshotComplete {
+				snapshots <- struct{}{}
+			}
+			if len(received[0]) == count+2 {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var chunks metadata.Reassembler
+		for len(received[1]) < count+2 {
+			msg, err := remote.Recv()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			evt, err := chunks.Add(msg)
+			if err != nil || evt == nil {
+				continue
+			}
+			received[1] = append(received[1], evt.GetReason()+"/"+evt.GetUid()+"/"+evt.GetMeta())
+			if evt.GetReason() == events.SnapshotComplete {
+				snapshots <- struct{}{}
+			}
+		}
+	}()
+	<-snapshots
+	<-snapshots
+	for i := 0; i < count; i++ {
+		evt := podEvent(fmt.Sprintf("pod-%d", i), "")
+		evt.Subs = subs
+		_ = queue.Push(evt)
+	}
+	wg.Wait()
+
+	for i, name := range []string{"in-process", "grpc"} {
+		if len(received[i]) != count+2 {
+			t.Fatalf("expected %d events for the %s subscriber, got %d", count+2, name, len(received[i]))
+		}
+		if received[i][0] != events.Create+"/existing/"+meta("existing") {
+			t.Errorf("expected the %s subscriber to receive the existing pod first, got %s", name, received[i][0])
+		}
+		if received[i][1][:len(events.SnapshotComplete)] != events.SnapshotComplete {
+			t.Errorf("expected the %s subscriber to receive the SnapshotComplete event, got %s", name, received[i][1])
+		}
+		for j := 0; j < count; j++ {
+			uid := fmt.Sprintf("pod-%d", j)
+			if expected := events.Create + "/" + uid + "/" + meta(uid); received[i][j+2] != expected {
+				t.Fatalf("expected event %s for the %s subscriber, got %s", expected, name, received[i][j+2])
+			}
+		}
+	}
+
+	// Canceling the context unsubscribes the in-process subscriber from the collectors and closes the channel.
+	unsubscribe()
+	select {
+	case uid := <-unsubscribed:
+		if !subs.Has(uid) {
+			t.Errorf("unexpected unsubscribed subscriber %q", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the in-process subscriber to unsubscribe")
+	}
+	if _, ok := <-local; ok {
+		t.Error("expected the channel of the in-process subscriber to be closed")
+	}
+	if states := br.SubscriberStates(); len(states) != 1 {
+		t.Errorf("expected only the grpc subscriber to be connected, got %+v", states)
+	}
+}
+
+func TestSubscribeDryRun(t *testing.T) {
+	br, err := New(logr.Discard(), NewBlockingChannel(1), map[string]subscriber.SubsChan{}, WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.Subscribe(context.Background(), "node-1"); err == nil {
+		t.Error("expected the subscription to be refused in dry-run mode")
+	}
+}
+
+// meta returns the large metadata of the pod with the given UID, split in chunks by the grpc subscribers.
+func meta(uid string) string {
+	return fmt.Sprintf(`{"name":%q,"padding":%q}`, uid, strings.Repeat("x", 4000))
+}
+
+func TestSubscribeModes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	// The collector replays an existing pod to each new subscriber, unless watch-only, and reports the subscribers.
+	subscribed := make(chan subscriber.Message, 1)
+	go func() {
+		for msg := range pods {
+			if msg.Reason == subscriber.Unsubscribed {
+				continue
+			}
+			if !msg.WatchOnly {
+				_ = queue.Push(podEvent("existing", msg.UID))
+			}
+			msg.Dispatched()
+			subscribed <- msg
+		}
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := metadata.NewMetadataClient(conn)
+
+	tests := map[metadata.Mode][]string{
+		metadata.Mode_SNAPSHOT_THEN_WATCH: {events.Create + "/existing", events.SnapshotComplete, events.Create + "/changed"},
+		metadata.Mode_SNAPSHOT:            {events.Create + "/existing", events.SnapshotComplete},
+		metadata.Mode_WATCH_ONLY:          {events.SnapshotComplete, events.Create + "/changed"},
+	}
+	for mode, want := range tests {
+		streamCtx, closeStream := context.WithCancel(ctx)
+		stream, err := client.Watch(streamCtx, &metadata.Selector{NodeName: "node-1", Mode: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := <-subscribed
+		if msg.WatchOnly != (mode == metadata.Mode_WATCH_ONLY) {
+			t.Errorf("%s: unexpected watch-only subscriber %v", mode, msg.WatchOnly)
+		}
+		var got []string
+		for len(got) < len(want) {
+			evt, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("%s: unexpected error after %v: %v", mode, got, err)
+			}
+			if evt.GetReason() == events.SnapshotComplete {
+				got = append(got, evt.GetReason())
+				// The changes are pushed once the snapshot has been received.
+				_ = queue.Push(podEvent("changed", msg.UID))
+				continue
+			}
+			got = append(got, evt.GetReason()+"/"+evt.GetUid())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", mode, want, got)
+		}
+		// The stream of a SNAPSHOT subscription is closed cleanly after the snapshot.
+		if mode == metadata.Mode_SNAPSHOT {
+			if evt, err := stream.Recv(); !errors.Is(err, io.EOF) {
+				t.Errorf("expected the stream to be closed after the snapshot, got %v, %v", evt, err)
+			}
+		}
+		closeStream()
+	}
+
+	// The channel of an in-process SNAPSHOT subscription is closed after the snapshot.
+	local, err := br.Subscribe(ctx, "node-1", WithMode(metadata.Mode_SNAPSHOT), WithBufferSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-subscribed
+	var got []string
+	for evt := range local {
+		got = append(got, evt.GetReason())
+	}
+	if want := []string{events.Create, events.SnapshotComplete}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v before the channel is closed, got %v", want, got)
+	}
+}
+
+func TestSubscribeBulkDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewBlockingChannel(10)
+	pods := make(subscriber.SubsChan)
+	br, err := New(logr.Discard(), queue, map[string]subscriber.SubsChan{"Pod": pods})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	served := make(chan error, 1)
+	go func() { served <- br.serve(ctx, lis, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	subscribed := make(chan string, 2)
+	go func() {
+		for msg := range pods {
+			if msg.Reason == subscriber.Unsubscribed {
+				continue
+			}
+			msg.Dispatched()
+			subscribed <- msg.UID
+		}
+	}()
+	bulk, err := br.Subscribe(ctx, "node-1", WithBulkDelete(true), WithBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perResource, err := br.Subscribe(ctx, "node-2", WithBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := fields.Subscribers{<-subscribed: {}, <-subscribed: {}}
+	for _, ch := range []<-chan events.Event{bulk, perResource} {
+		if evt := <-ch; evt.GetReason() != events.SnapshotComplete {
+			t.Fatalf("expected the snapshot to be complete, got %s", evt.String())
+		}
+	}
+
+	// The namespace collector pushes the Delete events of the resources of the namespace, then its DeleteNamespace
+	// event. The Delete events of the resources of the other namespaces are sent to all the subscribers.
+	pod := events.NewResource("Pod", "pod-uid")
+	pod.SetSubscribers(subs)
+	pod.GenerateSubscribers(nil)
+	pod.SetCascaded(true)
+	for _, evt := range pod.ToEvents() {
+		if evt != nil {
+			_ = queue.Push(evt)
+		}
+	}
+	_ = queue.Push(events.NewDeleteNamespace("doomed", "ns-uid", subs, ""))
+	_ = queue.Push(&events.Event{Event: &metadata.Event{Reason: events.Delete, Kind: "Pod", Uid: "other-uid"}, Subs: subs})
+
+	tests := map[string]struct {
+		ch   <-chan events.Event
+		want []string
+	}{
+		"bulk delete":  {ch: bulk, want: []string{events.DeleteNamespace + "/doomed", events.Delete + "/other-uid"}},
+		"per resource": {ch: perResource, want: []string{events.Delete + "/pod-uid", events.Delete + "/other-uid"}},
+	}
+	for name, tt := range tests {
+		var got []string
+		for len(got) < len(tt.want) {
+			select {
+			case evt := <-tt.ch:
+				if evt.GetReason() == events.DeleteNamespace {
+					got = append(got, evt.GetReason()+"/"+evt.GetNamespace())
+					continue
+				}
+				got = append(got, evt.GetReason()+"/"+evt.GetUid())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for the events, got %v", name, got)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, got)
+		}
+	}
+}
+
+// podEvent returns the Create event of the pod with the given UID, for the given subscriber if not empty.
+func podEvent(uid, sub string) *events.Event {
+	m := meta(uid)
+	evt := &events.Event{Event: &metadata.Event{Reason: events.Create, Kind: "Pod", Uid: uid, Meta: &m}}
+	if sub != "" {
+		evt.Subs = fields.Subscribers{sub: struct{}{}}
+	}
+	return evt
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/proto"
+)
+
+// throttledEvent is an event waiting in a throttle to be sent.
+type throttledEvent struct {
+	msg    *metadata.Event
+	queued time.Time
+	// created is the time at which the oldest change folded in the event has been generated.
+	created time.Time
+}
+
+// throttle paces the events sent to a subscriber. The events for the same resource waiting to be sent are
+// coalesced, keeping the latest state: an Update following a pending Create is sent as a Create, and a Delete
+// replaces any pending event for the resource. Delete events are paced as the others, but they are never
+// delayed past maxDeleteDelay: when the oldest pending Delete reaches the bound it is sent regardless of the rate.
+// The DeleteNamespace events are handled as Delete ones, but never coalesced.
+type throttle struct {
+	mutex sync.Mutex
+	// fifo holds the pending events in arrival order.
+	fifo *list.List
+	// latest indexes by resource UID the pending events that can be coalesced, i.e. the Create and Update ones.
+	latest map[string]*list.Element
+	// deletes holds the pending Delete and DeleteNamespace events in arrival order.
+	deletes        []*list.Element
+	limiter        *rate.Limiter
+	maxDeleteDelay time.Duration
+	// wake is signaled when an event is pushed.
+	wake chan struct{}
+	// deleted is signaled when a Delete event is pushed.
+	deleted chan struct{}
+	// throttled counts the coalesced and the delayed events.
+	throttled *prometheus.CounterVec
+}
+
+// newThrottle returns a throttle sending at most eventsPerSecond events, with bursts of up to burst events. The
+// coalesced and the delayed events are counted in throttled.
+func newThrottle(eventsPerSecond float64, burst int, maxDeleteDelay time.Duration, throttled *prometheus.CounterVec) *throttle {
+	return &throttle{
+		fifo:           list.New(),
+		latest:         make(map[string]*list.Element),
+		limiter:        rate.NewLimiter(rate.Limit(eventsPerSecond), burst),
+		maxDeleteDelay: maxDeleteDelay,
+		wake:           make(chan struct{}, 1),
+		deleted:        make(chan struct{}, 1),
+		throttled:      throttled,
+	}
+}
+
+// setLimit updates the rate and the burst of the throttle. The events already waiting for the rate are sent at the
+// previous one.
+func (t *throttle) setLimit(eventsPerSecond float64, burst int) {
+	t.limiter.SetLimit(rate.Limit(eventsPerSecond))
+	t.limiter.SetBurst(burst)
+}
+
+// push adds the event, generated at the given time, to the pending ones, coalescing it with the pending event for the
+// same resource if any.
+func (t *throttle) push(msg *metadata.Event, created time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if elem, ok := t.latest[msg.Uid]; ok && msg.Reason != events.DeleteNamespace {
+		pending := elem.Value.(*throttledEvent)
+		t.throttled.WithLabelValues(msg.Kind, labelCoalesced).Inc()
+		switch msg.Reason {
+		case events.Delete:
+			// The Delete replaces the pending event, and takes its place in the queue of the deletes.
+			t.fifo.Remove(elem)
+			delete(t.latest, msg.Uid)
+		case events.Refresh:
+			// The pending event carries the metadata and the TTL of the resource, it refreshes them already.
+			return
+		default:
+			// The subscriber has not received the pending event yet, so the patch of the metadata, relative to it,
+			// does not apply: the full metadata are sent instead.
+			msg = unpatched(msg)
+			// The subscriber has not received the pending Create yet, so it must stay a Create.
+			if pending.msg.Reason == events.Create {
+				msg = proto.Clone(msg).(*metadata.Event)
+				msg.Reason = events.Create
+			}
+			pending.msg = msg
+			return
+		}
+	}
+
+	elem := t.fifo.PushBack(&throttledEvent{msg: msg, queued: time.Now(), created: created})
+	if isDelete(msg) {
+		t.deletes = append(t.deletes, elem)
+		notify(t.deleted)
+	} else {
+		t.latest[msg.Uid] = elem
+	}
+	notify(t.wake)
+}
+
+// discard drops the pending Create or Update event of the resource with the given UID, if any. It is counted as
+// coalesced, as when a Delete replaces it.
+func (t *throttle) discard(uid string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if elem, ok := t.latest[uid]; ok {
+		t.throttled.WithLabelValues(elem.Value.(*throttledEvent).msg.Kind, labelCoalesced).Inc()
+		t.fifo.Remove(elem)
+		delete(t.latest, uid)
+	}
+}
+
+// isDelete returns whether the event is paced as a Delete one.
+func isDelete(msg *metadata.Event) bool {
+	return msg.Reason == events.Delete || msg.Reason == events.DeleteNamespace
+}
+
+// notify signals the channel without blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes and returns the oldest pending event and the time it has been generated. If onlyDelete is true it
+// returns the oldest pending Delete.
+func (t *throttle) pop(onlyDelete bool) (*metadata.Event, time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var elem *list.Element
+	if onlyDelete {
+		if len(t.deletes) == 0 {
+			return nil, time.Time{}
+		}
+		elem = t.deletes[0]
+	} else if elem = t.fifo.Front(); elem == nil {
+		return nil, time.Time{}
+	}
+
+	evt := t.fifo.Remove(elem).(*throttledEvent)
+	if isDelete(evt.msg) {
+		t.deletes = t.deletes[1:]
+	} else {
+		delete(t.latest, evt.msg.Uid)
+	}
+	return evt.msg, evt.created
+}
+
+// deleteDeadline returns the time by which the oldest pending Delete must be sent, if any.
+func (t *throttle) deleteDeadline() (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.deletes) == 0 {
+		return time.Time{}, false
+	}
+	return t.deletes[0].Value.(*throttledEvent).queued.Add(t.maxDeleteDelay), true
+}
+
+// len returns the number of pending events.
+func (t *throttle) len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.fifo.Len()
+}
+
+// lag returns the number of pending events and the time the oldest of them has been waiting.
+func (t *throttle) lag(now time.Time) (int, time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	front := t.fifo.Front()
+	if front == nil {
+		return 0, 0
+	}
+	return t.fifo.Len(), now.Sub(front.Value.(*throttledEvent).queued)
+}
+
+// run sends the pending events using the given function until the context is canceled or a send fails.
+func (t *throttle) run(ctx context.Context, send func(msg *metadata.Event, created time.Time) error) error {
+	for {
+		if t.len() == 0 {
+			select {
+			case <-t.wake:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		onlyDelete := false
+		reservation := t.limiter.Reserve()
+		delay := reservation.Delay()
+		delayed := delay > 0
+		if deadline, ok := t.deleteDeadline(); ok && delay > 0 && time.Until(deadline) < delay {
+			// Waiting for the rate would delay the oldest Delete past its bound: send it at its deadline.
+			reservation.Cancel()
+			reservation = nil
+			delay = time.Until(deadline)
+			onlyDelete = true
+		}
+
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-t.deleted:
+				// A new Delete could have a deadline earlier than the end of the wait.
+				timer.Stop()
+				if reservation != nil {
+					reservation.Cancel()
+				}
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+
+		msg, created := t.pop(onlyDelete)
+		if msg == nil {
+			continue
+		}
+		if delayed {
+			t.throttled.WithLabelValues(msg.Kind, labelDelayed).Inc()
+		}
+		if err := send(msg, created); err != nil {
+			return err
+		}
+	}
+}
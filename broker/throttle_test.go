@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+)
+
+func TestThrottleCoalescing(t *testing.T) {
+	th := newThrottle(1, 1, time.Second, defaultBrokerMetrics.throttledEvents)
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Create}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Update}, time.Now())
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Update, Meta: ptr("latest")}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Delete}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Create}, time.Now())
+
+	expected := []struct {
+		uid    string
+		reason string
+	}{
+		// The Update is folded in the pending Create, which keeps its reason.
+		{uid: "a", reason: events.Create},
+		// The Delete replaces the pending Update.
+		{uid: "b", reason: events.Delete},
+		// A Create following a Delete is never coalesced with it.
+		{uid: "b", reason: events.Create},
+	}
+	if th.len() != len(expected) {
+		t.Fatalf("expected %d pending events, got %d", len(expected), th.len())
+	}
+	for _, exp := range expected {
+		msg, _ := th.pop(false)
+		if msg.Uid != exp.uid || msg.Reason != exp.reason {
+			t.Fatalf("expected %s event for %q, got %s event for %q", exp.reason, exp.uid, msg.Reason, msg.Uid)
+		}
+		if exp.uid == "a" && msg.GetMeta() != "latest" {
+			t.Fatalf("expected the latest state of %q, got %q", exp.uid, msg.GetMeta())
+		}
+	}
+	if msg, _ := th.pop(false); msg != nil {
+		t.Fatalf("expected no pending events, got %v", msg)
+	}
+}
+
+func TestThrottleCoalescingMetaPatch(t *testing.T) {
+	th := newThrottle(1, 1, time.Second, defaultBrokerMetrics.throttledEvents)
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Update, Meta: ptr("v2"), MetaPatch: ptr("v1-v2")},
+		time.Now())
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Update, Meta: ptr("v3"), MetaPatch: ptr("v2-v3")},
+		time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Update, Meta: ptr("v2"), MetaPatch: ptr("v1-v2")},
+		time.Now())
+
+	// The subscriber never received the pending version, the patch from it does not apply: the full metadata are sent.
+	msg, _ := th.pop(false)
+	if msg.MetaPatch != nil || msg.GetMeta() != "v3" {
+		t.Errorf("expected the full latest metadata of the coalesced event, got %v", msg)
+	}
+	// The events not coalesced keep their patch.
+	msg, _ = th.pop(false)
+	if sent := patched(msg); sent.GetMetaPatch() != "v1-v2" || sent.Meta != nil {
+		t.Errorf("expected only the patch of the metadata to be sent, got %v", sent)
+	}
+}
+
+func TestThrottleDeleteBound(t *testing.T) {
+	const maxDeleteDelay = 100 * time.Millisecond
+	// The rate allows a single event, the following ones wait for a long time.
+	th := newThrottle(0.01, 1, maxDeleteDelay, defaultBrokerMetrics.throttledEvents)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	var sent []*metadata.Event
+	done := make(chan error)
+	go func() {
+		done <- th.run(ctx, func(msg *metadata.Event, _ time.Time) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			sent = append(sent, msg)
+			return nil
+		})
+	}()
+
+	start := time.Now()
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Create}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Create}, time.Now())
+	th.push(&metadata.Event{Uid: "c", Kind: "Pod", Reason: events.Delete}, time.Now())
+
+	deadline := time.Now().Add(10 * maxDeleteDelay)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		n := len(sent)
+		mutex.Unlock()
+		if n >= 2 {
+			break
+		}
+		time.Sleep(maxDeleteDelay / 10)
+	}
+	elapsed := time.Since(start)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 events to be sent, got %d", len(sent))
+	}
+	if sent[0].Uid != "a" || sent[1].Uid != "c" || sent[1].Reason != events.Delete {
+		t.Fatalf("expected the Delete to overtake the paced events, got %s for %q", sent[1].Reason, sent[1].Uid)
+	}
+	if elapsed < maxDeleteDelay {
+		t.Fatalf("expected the Delete to be paced up to its bound, sent after %s", elapsed)
+	}
+	if th.len() != 1 {
+		t.Fatalf("expected 1 pending event, got %d", th.len())
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestThrottleRefresh(t *testing.T) {
+	th := newThrottle(1, 1, time.Second, defaultBrokerMetrics.throttledEvents)
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Create, Meta: ptr("created")}, time.Now())
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Refresh}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Refresh}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Update, Meta: ptr("updated")}, time.Now())
+
+	// A refresh never replaces the metadata of a pending event, and is superseded by the following ones.
+	for _, expected := range []struct{ uid, reason, meta string }{
+		{uid: "a", reason: events.Create, meta: "created"},
+		{uid: "b", reason: events.Update, meta: "updated"},
+	} {
+		msg, _ := th.pop(false)
+		if msg.Uid != expected.uid || msg.Reason != expected.reason || msg.GetMeta() != expected.meta {
+			t.Fatalf("expected %s event for %q, got %v", expected.reason, expected.uid, msg)
+		}
+	}
+	if msg, _ := th.pop(false); msg != nil {
+		t.Fatalf("expected no pending events, got %v", msg)
+	}
+}
+
+func TestThrottleDeleteNamespace(t *testing.T) {
+	th := newThrottle(1, 1, time.Second, defaultBrokerMetrics.throttledEvents)
+	th.push(&metadata.Event{Uid: "ns", Kind: "Namespace", Reason: events.Update}, time.Now())
+	th.push(&metadata.Event{Uid: "a", Kind: "Pod", Reason: events.Update}, time.Now())
+	th.push(&metadata.Event{Uid: "b", Kind: "Pod", Reason: events.Create}, time.Now())
+	// The pending events of the resources of the namespace are discarded, in place of their Delete.
+	th.discard("a")
+	th.push(&metadata.Event{Uid: "ns", Reason: events.DeleteNamespace, Namespace: "doomed"}, time.Now())
+	th.push(&metadata.Event{Uid: "ns", Kind: "Namespace", Reason: events.Delete}, time.Now())
+
+	// The DeleteNamespace event does not replace the pending Update of the namespace, and is not replaced by its
+	// Delete, which does.
+	if msg, _ := th.pop(true); msg.Reason != events.DeleteNamespace || msg.GetNamespace() != "doomed" {
+		t.Fatalf("expected the DeleteNamespace event to be the oldest pending delete, got %v", msg)
+	}
+	for _, expected := range []struct{ uid, reason string }{
+		{uid: "b", reason: events.Create},
+		{uid: "ns", reason: events.Delete},
+	} {
+		msg, _ := th.pop(false)
+		if msg.Uid != expected.uid || msg.Reason != expected.reason {
+			t.Fatalf("expected %s event for %q, got %v", expected.reason, expected.uid, msg)
+		}
+	}
+	if msg, _ := th.pop(false); msg != nil {
+		t.Fatalf("expected no pending events, got %v", msg)
+	}
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package run
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"github.com/go-logr/logr"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// selectable is a collector whose selectors can be replaced at runtime.
+type selectable interface {
+	SetSelectors(labelSelector, annotationSelector labels.Selector)
+}
+
+// excludable is a collector whose excluded names can be replaced at runtime.
+type excludable interface {
+	SetExcludedNames(patterns []string)
+}
+
+// throttler is the broker, whose throttling can be updated at runtime.
+type throttler interface {
+	SetThrottle(eventsPerSecond float64, burst int) error
+}
+
+// reloader applies the changes of the configuration file at runtime: the selectors of the collectors, the
+// throttling of the broker and the log verbosity. The changes to the other settings are logged as requiring a
+// restart.
+type reloader struct {
+	logger logr.Logger
+	// started is the configuration the metacollector has been started with, and current the applied one.
+	started *config.Config
+	current *config.Config
+	// base holds the flags before the settings of the configuration file are applied, and running the effective ones.
+	base    options
+	running options
+	// collectors holds the running collectors by resource kind.
+	collectors map[string]selectable
+	broker     throttler
+	// logLevel is the level of the logger, nil if it can not be changed.
+	logLevel *uberzap.AtomicLevel
+}
+
+// reload applies the given configuration, already validated.
+func (r *reloader) reload(cfg *config.Config) {
+	for _, kind := range reloadKinds(r.started, cfg) {
+		r.reloadCollector(kind, cfg)
+	}
+
+	if !reflect.DeepEqual(r.started.Tracing, cfg.Tracing) {
+		r.restartRequired("tracing")
+	}
+	if !slices.Equal(r.started.BroadcastNamespaces, cfg.BroadcastNamespaces) {
+		r.restartRequired("broadcastNamespaces")
+	}
+
+	r.reloadBroker(cfg)
+
+	if r.logLevel != nil && !r.base.changed("zap-log-level") {
+		if level := verbosityLevel(cfg.LogVerbosity); level != r.logLevel.Level() {
+			r.logLevel.SetLevel(level)
+			r.logger.Info("log verbosity updated", "verbosity", -int(level))
+		}
+	}
+
+	r.current = cfg
+}
+
+// reloadCollector applies the selectors and the excluded names of the collector for the given kind. The annotation
+// selector is applied only if the annotations it references are kept in the cache, i.e. were referenced at startup.
+func (r *reloader) reloadCollector(kind string, cfg *config.Config) {
+	started, col := r.started.Collectors[kind], cfg.Collectors[kind]
+	if r.started.IsEnabled(kind) != cfg.IsEnabled(kind) {
+		r.restartRequired("collectors." + kind + ".enabled")
+	}
+	if !slices.Equal(started.StatusFields, col.StatusFields) {
+		r.restartRequired("collectors." + kind + ".statusFields")
+	}
+	if started.APIVersion != col.APIVersion {
+		r.restartRequired("collectors." + kind + ".apiVersion")
+	}
+	if rateLimiterSettings(started.RateLimiter) != rateLimiterSettings(col.RateLimiter) {
+		r.restartRequired("collectors." + kind + ".rateLimiter")
+	}
+	startedKeys := r.started.AnnotationKeys(kind)
+	for _, key := range cfg.AnnotationKeys(kind) {
+		if !slices.Contains(startedKeys, key) {
+			r.restartRequired("collectors."+kind+".annotationSelector", "annotation", key)
+			col.AnnotationSelector = r.current.Collectors[kind].AnnotationSelector
+			cfg.Collectors[kind] = col
+			break
+		}
+	}
+
+	current := r.current.Collectors[kind]
+	collector, ok := r.collectors[kind]
+	if !ok {
+		return
+	}
+	if !slices.Equal(current.ExcludedNames, col.ExcludedNames) {
+		if excl, ok := collector.(excludable); ok {
+			excl.SetExcludedNames(col.ExcludedNames)
+			r.logger.Info("excluded names updated", "resource kind", kind, "excludedNames", col.ExcludedNames)
+		} else {
+			r.restartRequired("collectors." + kind + ".excludedNames")
+		}
+	}
+	if current.LabelSelector == col.LabelSelector && current.AnnotationSelector == col.AnnotationSelector {
+		return
+	}
+	collector.SetSelectors(cfg.LabelSelector(kind), cfg.AnnotationSelector(kind))
+	r.logger.Info("selectors updated", "resource kind", kind, "labelSelector", col.LabelSelector,
+		"annotationSelector", col.AnnotationSelector)
+}
+
+// reloadBroker applies the throttling of the broker. The flags set on the command line keep overriding the settings
+// of the file.
+func (r *reloader) reloadBroker(cfg *config.Config) {
+	next := r.base
+	next.applyBrokerConfig(&cfg.Broker)
+	if next.brokerAddr != r.running.brokerAddr {
+		r.restartRequired("broker.address")
+	}
+	if next.maxDeleteDelay != r.running.maxDeleteDelay {
+		r.restartRequired("broker.maxDeleteDelay")
+	}
+	if next.maxBackfills != r.running.maxBackfills {
+		r.restartRequired("broker.maxConcurrentBackfills")
+	}
+	if next.maxMessageSize != r.running.maxMessageSize {
+		r.restartRequired("broker.maxMessageSize")
+	}
+
+	if next.nodeRate == r.running.nodeRate && next.nodeBurst == r.running.nodeBurst {
+		return
+	}
+	if err := r.broker.SetThrottle(next.nodeRate, next.nodeBurst); err != nil {
+		r.logger.Error(err, "unable to update the broker throttling", "nodeRate", next.nodeRate, "nodeBurst", next.nodeBurst)
+		return
+	}
+	r.running.nodeRate, r.running.nodeBurst = next.nodeRate, next.nodeBurst
+	r.logger.Info("broker throttling updated", "nodeRate", next.nodeRate, "nodeBurst", next.nodeBurst)
+}
+
+// restartRequired logs that the change of the given setting requires a restart to be applied.
+func (r *reloader) restartRequired(setting string, keysAndValues ...interface{}) {
+	r.logger.Info("WARNING: the setting can not be changed at runtime, a restart is required to apply it",
+		append([]interface{}{"setting", setting}, keysAndValues...)...)
+}
+
+// reloadKinds returns the sorted kinds of the collectors of the given configurations, including the supported ones.
+func reloadKinds(configs ...*config.Config) []string {
+	kinds := config.Kinds()
+	for _, cfg := range configs {
+		for kind := range cfg.Collectors {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return slices.Compact(kinds)
+}
+
+// verbosityLevel returns the level of the logger for the given verbosity.
+func verbosityLevel(verbosity *int) zapcore.Level {
+	if verbosity == nil {
+		return zapcore.InfoLevel
+	}
+	// The levels of zap are stored in an int8.
+	return zapcore.Level(-min(*verbosity, 127))
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package run
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// recordingCollector records the selectors and the excluded names set by the reloader.
+type recordingCollector struct {
+	labels, annotations labels.Selector
+	calls               int
+	excludedNames       []string
+}
+
+func (c *recordingCollector) SetSelectors(labelSelector, annotationSelector labels.Selector) {
+	c.labels, c.annotations = labelSelector, annotationSelector
+	c.calls++
+}
+
+func (c *recordingCollector) SetExcludedNames(patterns []string) {
+	c.excludedNames = patterns
+}
+
+// recordingBroker records the throttling set by the reloader.
+type recordingBroker struct {
+	rate  float64
+	burst int
+	err   error
+}
+
+func (b *recordingBroker) SetThrottle(eventsPerSecond float64, burst int) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.rate, b.burst = eventsPerSecond, burst
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	started := config.Default()
+	started.Collectors[resource.Deployment] = config.CollectorConfig{AnnotationSelector: "team=a"}
+	rate, burst, verbosity := 20.0, 5, 2
+
+	deployments, pods := &recordingCollector{}, &recordingCollector{}
+	br := &recordingBroker{}
+	level := uberzap.NewAtomicLevelAt(zapcore.InfoLevel)
+	base := options{flags: flags{nodeRate: 10, nodeBurst: 100}}
+	rl := &reloader{
+		logger:     logr.Discard(),
+		started:    started,
+		current:    started,
+		base:       base,
+		running:    base,
+		collectors: map[string]selectable{resource.Deployment: deployments, resource.Pod: pods},
+		broker:     br,
+		logLevel:   &level,
+	}
+
+	cfg := config.Default()
+	cfg.Collectors[resource.Deployment] = config.CollectorConfig{LabelSelector: "app=web", AnnotationSelector: "team=b"}
+	cfg.Collectors[resource.Pod] = config.CollectorConfig{ExcludedNames: []string{"*-canary"}}
+	cfg.Broker.NodeRate, cfg.Broker.NodeBurst = &rate, &burst
+	cfg.LogVerbosity = &verbosity
+	rl.reload(cfg)
+
+	if deployments.calls != 1 || deployments.labels.String() != "app=web" || deployments.annotations.String() != "team=b" {
+		t.Errorf("unexpected deployment selectors %v, %v after %d calls", deployments.labels, deployments.annotations,
+			deployments.calls)
+	}
+	if pods.calls != 0 {
+		t.Errorf("expected the unchanged pod selectors not to be set, got %d calls", pods.calls)
+	}
+	if !reflect.DeepEqual(pods.excludedNames, []string{"*-canary"}) || deployments.excludedNames != nil {
+		t.Errorf("unexpected excluded names %v for the pods, %v for the deployments", pods.excludedNames,
+			deployments.excludedNames)
+	}
+	if br.rate != rate || br.burst != burst {
+		t.Errorf("expected the broker throttling to be %v/%d, got %v/%d", rate, burst, br.rate, br.burst)
+	}
+	if level.Level() != zapcore.Level(-verbosity) {
+		t.Errorf("expected the log level %v, got %v", zapcore.Level(-verbosity), level.Level())
+	}
+
+	// The annotations not referenced at startup are not in the cache: the running annotation selector is kept.
+	cfg = config.Default()
+	cfg.Collectors[resource.Deployment] = config.CollectorConfig{LabelSelector: "app=api", AnnotationSelector: "owner=x"}
+	br.err = errors.New("restart required")
+	rl.reload(cfg)
+
+	if deployments.calls != 2 || deployments.labels.String() != "app=api" || deployments.annotations.String() != "team=b" {
+		t.Errorf("unexpected deployment selectors %v, %v after %d calls", deployments.labels, deployments.annotations,
+			deployments.calls)
+	}
+	// The throttling that can not be applied is kept, and the default verbosity is restored.
+	if br.rate != rate || br.burst != burst || rl.running.nodeRate != rate {
+		t.Errorf("expected the broker throttling to stay %v/%d, got %v/%d", rate, burst, br.rate, br.burst)
+	}
+	if level.Level() != zapcore.InfoLevel {
+		t.Errorf("expected the log level %v, got %v", zapcore.InfoLevel, level.Level())
+	}
+}
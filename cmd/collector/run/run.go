@@ -17,49 +17,118 @@ package run
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/broker"
 	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/audit"
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"github.com/falcosecurity/k8s-metacollector/pkg/debug"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/falcosecurity/k8s-metacollector/pkg/kubeclient"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
-	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var (
 	scheme = runtime.NewScheme()
 )
 
+// tracingShutdownTimeout is the time given to the trace exporter to flush the pending spans at shutdown.
+const tracingShutdownTimeout = 5 * time.Second
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 }
 
 type flags struct {
-	metricsAddr  string
-	probeAddr    string
-	brokerAddr   string
-	certFilePath string
-	keyFilePath  string
+	metricsAddr    string
+	probeAddr      string
+	brokerAddr     string
+	certFilePath   string
+	keyFilePath    string
+	dryRun         bool
+	dryRunOutput   string
+	configPath     string
+	resyncPeriod   time.Duration
+	ttl            time.Duration
+	warmup         time.Duration
+	minAge         time.Duration
+	nodeless       time.Duration
+	pausedRequeue  time.Duration
+	jitter         float64
+	namespaces     []string
+	coalesceWindow time.Duration
+	debounceWindow time.Duration
+	terminatedPods bool
+	endpointsNodes bool
+	zoneNodes      bool
+	externalName   string
+	nodeLabels     []string
+	staleness      time.Duration
+	dispatchStuck  time.Duration
+	nodeRate       float64
+	nodeBurst      int
+	maxDeleteDelay time.Duration
+	maxBackfills   int
+	nodeTakeover   bool
+	reflection     bool
+	rpcLogLevel    int
+	resyncInterval time.Duration
+	queueType      string
+	queueCapacity  int
+	sendTimeout    time.Duration
+	nodeMetrics    bool
+	clusterName    string
+	maxMessageSize int
+	maxMetaSize    int
+	truncation     string
+	metaEncoder    string
+	allowedLabels  []string
+	allowedAnnots  []string
+	tracingAddr    string
+	debugEnabled   bool
+	debugAddr      string
+	pprofEnabled   bool
+	pprofAddr      string
+	auditPath      string
+	auditMaxSize   int64
+	auditBuffer    int
+	kubeAPIQPS     float32
+	kubeAPIBurst   int
+	listPageSize   int64
 }
 
 func (fl *flags) add(flags *pflag.FlagSet) {
@@ -68,12 +137,334 @@ func (fl *flags) add(flags *pflag.FlagSet) {
 	flags.StringVar(&fl.brokerAddr, "broker-bind-address", ":45000", "The address the broker endpoint binds to")
 	flags.StringVar(&fl.certFilePath, "broker-server-cert", "", "Cert file path for grpc server")
 	flags.StringVar(&fl.keyFilePath, "broker-server-key", "", "Key file path for grpc server")
+	flags.StringVar(&fl.configPath, "config", "", "Path to the YAML configuration file of the collectors, the broker and the tracing. "+
+		"The flags set on the command line override the settings of the file")
+	flags.DurationVar(&fl.resyncPeriod, "resync-period", 0, "Period of the full resync of the collectors, used to "+
+		"recompute the subscribers of the cached resources and emit the corrective events. Zero disables it")
+	flags.DurationVar(&fl.ttl, "metadata-ttl", 0, "Time after which the subscribers should consider stale the metadata "+
+		"of a resource if no event is received for it. The resources are refreshed twice within it. Zero disables it")
+	flags.DurationVar(&fl.warmup, "warmup-period", 0, "Grace period after the initial sync of the collectors during "+
+		"which the Delete events due to resources no longer related to any node are deferred. Zero disables it")
+	flags.DurationVar(&fl.minAge, "min-resource-age", 0, "Minimum age of the resources sent to the subscribers. The "+
+		"younger ones are deferred until they persist past it, so that the short-lived ones generate no events. Zero disables it")
+	flags.DurationVar(&fl.nodeless, "nodeless-requeue", 0, "Delay after which the resources relating to no node, e.g. "+
+		"the deployments whose pods are not scheduled yet, are reconciled again. Zero disables it")
+	flags.DurationVar(&fl.pausedRequeue, "paused-requeue", 0, "Delay after which the requests received while a "+
+		"collector is paused are reconciled again. Zero drops them, the resources are resynced when the collector resumes")
+	flags.Float64Var(&fl.jitter, "jitter-factor", 0.1, "Maximum fraction of the resync period and of the backoff of "+
+		"the retries added as a random jitter, to spread the resyncs and the retries over time. Zero disables it")
+	flags.DurationVar(&fl.coalesceWindow, "event-coalesce-window", 0, "Window within which the changes to the same "+
+		"resource are coalesced in a single event carrying the latest state. Delete events are never delayed. Zero disables it")
+	flags.DurationVar(&fl.debounceWindow, "external-trigger-debounce", 0, "Window within which the reconciles of "+
+		"the same resource triggered by the changes of related resources, e.g. the pods of a namespace, are collapsed "+
+		"in a single one. The changes to the resource itself are not debounced. Zero disables it")
+	flags.DurationVar(&fl.staleness, "collector-staleness-threshold", 5*time.Minute, "Time after which a collector "+
+		"whose reconciles keep failing, or are stuck, fails the health checks. Zero disables the check")
+	flags.DurationVar(&fl.dispatchStuck, "dispatch-stuck-threshold", 5*time.Minute, "Time after which the broker "+
+		"fails the health checks if the delivery of an event to the subscribers has not completed. Zero disables the check")
+	flags.BoolVar(&fl.terminatedPods, "include-terminated-pods", false, "Relate the nodes of the pods in a terminal "+
+		"phase, e.g. completed or evicted, to their owners, namespace and services")
+	flags.BoolVar(&fl.endpointsNodes, "service-endpoints-nodes", false, "Resolve the nodes of the services from the "+
+		"ready endpoints of their EndpointSlices instead of the pods matching their selector")
+	flags.BoolVar(&fl.zoneNodes, "service-zone-nodes", false, "Send the services to all the nodes in the topology "+
+		"zones of the nodes serving them. The nodes are listed on each reconcile of a service")
+	flags.StringVar(&fl.externalName, "service-external-name-nodes", collectors.ExternalNameNamespace, "Nodes where "+
+		"the ExternalName services are sent: namespace for the nodes running pods in their namespace, cluster for all "+
+		"the nodes, or none")
+	flags.StringSliceVar(&fl.nodeLabels, "pod-node-labels", nil, "Labels of the nodes projected in the metadata of "+
+		"the pods running on them, e.g. topology.kubernetes.io/zone,node.kubernetes.io/instance-type")
+	flags.Float64Var(&fl.nodeRate, "broker-node-rate", 0, "Maximum number of events per second sent to each "+
+		"subscriber. The pending events for the same resource are coalesced. Zero disables the throttling")
+	flags.IntVar(&fl.nodeBurst, "broker-node-burst", 100, "Maximum number of events sent in a burst to each subscriber "+
+		"when the throttling is enabled")
+	flags.DurationVar(&fl.maxDeleteDelay, "broker-max-delete-delay", time.Second, "Maximum delay of the delete events "+
+		"sent to the subscribers when the throttling is enabled")
+	flags.IntVar(&fl.maxBackfills, "broker-max-concurrent-backfills", 0, "Maximum number of new subscribers whose "+
+		"existing resources are dispatched at once, the others wait for their turn. Zero does not limit them")
+	flags.BoolVar(&fl.nodeTakeover, "broker-node-takeover", false, "Keep a single subscription per node: a new "+
+		"subscription for a node closes the streams of the previous ones, e.g. of a subscriber restarted before its "+
+		"previous stream is detected as gone")
+	flags.BoolVar(&fl.reflection, "enable-grpc-reflection", false, "Serve the grpc reflection service on the broker "+
+		"endpoint, for tools such as grpcurl to discover its services")
+	flags.IntVar(&fl.rpcLogLevel, "broker-rpc-log-verbosity", 2, "Verbosity of the logs of the RPCs served by the "+
+		"broker, e.g. the subscriptions. A negative value disables them")
+	flags.DurationVar(&fl.resyncInterval, "broker-resync-interval", time.Minute, "Minimum interval between two resyncs "+
+		"asked by a subscriber, the ones asked earlier are refused. Zero does not limit them")
+	flags.StringVar(&fl.queueType, "broker-queue", broker.QueueBlocking, "Queue of the events between the collectors "+
+		"and the broker, blocking to block the collectors when the broker lags behind, or ring to drop the oldest "+
+		"events and disconnect their subscribers to resync")
+	flags.IntVar(&fl.queueCapacity, "broker-queue-capacity", 100000, "Maximum number of events waiting in the ring "+
+		"queue, the oldest ones are dropped when it is full")
+	flags.DurationVar(&fl.sendTimeout, "broker-send-timeout", 30*time.Second, "Time a subscriber has to receive "+
+		"each event before its stream is closed, so that a wedged subscriber does not block the others. Zero waits forever")
+	flags.BoolVar(&fl.nodeMetrics, "metrics-node-label", true, "Label the metrics of the events sent to the "+
+		"subscribers with their node. Disable it to bound the cardinality of the metrics in large clusters")
+	flags.IntVar(&fl.maxMessageSize, "broker-max-message-size", metadata.DefaultMaxMessageSize, "Size in bytes above "+
+		"which the events are split in chunks, reassembled by the subscribers. A non-positive value never splits them")
+	flags.IntVar(&fl.maxMetaSize, "max-meta-size", 0, "Size in bytes above which the metadata of the resources are "+
+		"truncated, dropping their largest annotations or labels first. Zero does not limit them")
+	flags.StringVar(&fl.truncation, "meta-truncation-policy", string(collectors.TruncateAnnotations), "Entries of the "+
+		"metadata dropped first when they exceed the maximum size, annotations or labels")
+	flags.StringSliceVar(&fl.allowedLabels, "meta-allowed-labels", nil, "Keys of the labels forwarded in the metadata "+
+		"of the resources, e.g. app,team, the other labels are dropped. If not set, all the labels are forwarded")
+	flags.StringSliceVar(&fl.allowedAnnots, "meta-allowed-annotations", nil, "Keys of the annotations forwarded in the "+
+		"metadata of the resources, kept in the cache. If not set, only the annotations kept in the cache for the "+
+		"annotation selectors and the exclusion of the resources are forwarded")
+	flags.StringVar(&fl.metaEncoder, "meta-encoder", collectors.EncoderJSON, "Encoder serializing the metadata of the "+
+		"resources, json or jsoniter, or flat for a flat object of dotted keys, e.g. labels.app")
+	flags.Float32Var(&fl.kubeAPIQPS, "kube-api-qps", rest.DefaultQPS, "Maximum number of queries per second sent "+
+		"to the api-server")
+	flags.IntVar(&fl.kubeAPIBurst, "kube-api-burst", rest.DefaultBurst, "Maximum number of queries sent in a burst "+
+		"to the api-server")
+	flags.Int64Var(&fl.listPageSize, "kube-api-list-page-size", 0, "Maximum number of resources per page of the lists "+
+		"sent by the informers, paged by etcd instead of served at once by the watch cache. Zero keeps the defaults")
+	flags.StringVar(&fl.clusterName, "cluster-name", "", "Name of the cluster stamped on the events and injected in "+
+		"the metadata of the resources, to tell apart the metadata of several clusters")
+	flags.StringVar(&fl.tracingAddr, "tracing-endpoint", "", "Address of the OTLP gRPC receiver of the spans, e.g. "+
+		"otel-collector:4317. When set, it enables the OTLP exporter overriding the configured endpoint")
+	flags.BoolVar(&fl.debugEnabled, "enable-debug-endpoints", false, "Serve the debug endpoints dumping the caches "+
+		"of the collectors and pausing them. They are only served to the clients on the loopback interface")
+	flags.StringVar(&fl.debugAddr, "debug-bind-address", "127.0.0.1:8082", "The address the debug endpoints bind to")
+	flags.BoolVar(&fl.pprofEnabled, "enable-pprof", false, "Serve the pprof profiling endpoints under /debug/pprof/ "+
+		"on the metrics endpoint, or on the pprof-bind-address if set")
+	flags.StringVar(&fl.pprofAddr, "pprof-bind-address", "", "The address the pprof endpoints bind to when enabled, "+
+		"e.g. 127.0.0.1:8083 to serve them only on the loopback interface. If not set, they are served on the metrics endpoint")
+	flags.StringVar(&fl.auditPath, "audit-log", "", "File path where a JSON line is written for each event sent to "+
+		"the subscribers, or - for the standard output. If not set, the audit is disabled")
+	flags.Int64Var(&fl.auditMaxSize, "audit-log-max-size", 100<<20, "Size in bytes above which the audit file is "+
+		"rotated, keeping a single backup. A non-positive value never rotates it")
+	flags.IntVar(&fl.auditBuffer, "audit-log-buffer", 10000, "Maximum number of audit records waiting to be written, "+
+		"the records exceeding it are dropped")
+	flags.StringSliceVar(&fl.namespaces, "namespaces", nil, "Namespaces watched by the collectors. "+
+		"If not set, all the namespaces are watched")
+	flags.BoolVar(&fl.dryRun, "dry-run", false, "Generate the events as if a subscriber was running on each node, "+
+		"without dispatching them. Subscriptions are refused by the broker")
+	flags.StringVar(&fl.dryRunOutput, "dry-run-output", "", "File path where the events generated in dry-run mode "+
+		"are written as JSON lines")
 }
 
 type options struct {
 	flags
 	logger     *logr.Logger
 	loggerOpts *zap.Options
+	// flagSet holds the flags of the command, to tell apart the ones set on the command line.
+	flagSet *pflag.FlagSet
+}
+
+// changed returns true if the flag with the given name has been set on the command line.
+func (opts *options) changed(name string) bool {
+	return opts.flagSet != nil && opts.flagSet.Changed(name)
+}
+
+// overrideTracing sets the tracing endpoint of the configuration, if set on the command line.
+func (opts *options) overrideTracing(cfg *config.Config) {
+	if opts.tracingAddr != "" {
+		cfg.Tracing.Exporter = config.TracingExporterOTLP
+		cfg.Tracing.Endpoint = opts.tracingAddr
+	}
+}
+
+// applyBrokerConfig sets the broker settings of the configuration file, unless the corresponding flag has been set on
+// the command line.
+func (opts *options) applyBrokerConfig(cfg *config.BrokerConfig) {
+	if cfg.Address != "" && !opts.changed("broker-bind-address") {
+		opts.brokerAddr = cfg.Address
+	}
+	if cfg.NodeRate != nil && !opts.changed("broker-node-rate") {
+		opts.nodeRate = *cfg.NodeRate
+	}
+	if cfg.NodeBurst != nil && !opts.changed("broker-node-burst") {
+		opts.nodeBurst = *cfg.NodeBurst
+	}
+	if cfg.MaxDeleteDelay != nil && !opts.changed("broker-max-delete-delay") {
+		opts.maxDeleteDelay = cfg.MaxDeleteDelay.Duration
+	}
+	if cfg.MaxConcurrentBackfills != nil && !opts.changed("broker-max-concurrent-backfills") {
+		opts.maxBackfills = *cfg.MaxConcurrentBackfills
+	}
+	if cfg.MaxMessageSize != nil && !opts.changed("broker-max-message-size") {
+		opts.maxMessageSize = *cfg.MaxMessageSize
+	}
+}
+
+// validate returns the problems of the flags, once the settings of the configuration file are applied, each naming the
+// offending flag, joined in a single error. It returns nil if the flags are valid.
+func (opts *options) validate() error {
+	var errs []error
+	if opts.certFilePath != "" && opts.keyFilePath == "" {
+		errs = append(errs, errors.New("--broker-server-cert: set without --broker-server-key"))
+	}
+	if opts.keyFilePath != "" && opts.certFilePath == "" {
+		errs = append(errs, errors.New("--broker-server-key: set without --broker-server-cert"))
+	}
+	if opts.nodeRate > 0 && opts.nodeBurst < 1 {
+		errs = append(errs, fmt.Errorf("--broker-node-burst: must be at least 1, got %d", opts.nodeBurst))
+	}
+	switch opts.externalName {
+	case "", collectors.ExternalNameNamespace, collectors.ExternalNameCluster, collectors.ExternalNameNone:
+	default:
+		errs = append(errs, fmt.Errorf("--service-external-name-nodes: must be one of %s, %s or %s, got %q",
+			collectors.ExternalNameNamespace, collectors.ExternalNameCluster, collectors.ExternalNameNone, opts.externalName))
+	}
+	if opts.jitter < 0 {
+		errs = append(errs, fmt.Errorf("--jitter-factor: must not be negative, got %v", opts.jitter))
+	}
+	if opts.listPageSize < 0 {
+		errs = append(errs, fmt.Errorf("--kube-api-list-page-size: must not be negative, got %d", opts.listPageSize))
+	}
+	for _, d := range []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"broker-max-delete-delay", opts.maxDeleteDelay},
+		{"broker-resync-interval", opts.resyncInterval},
+		{"resync-period", opts.resyncPeriod},
+		{"metadata-ttl", opts.ttl},
+		{"warmup-period", opts.warmup},
+		{"min-resource-age", opts.minAge},
+		{"nodeless-requeue", opts.nodeless},
+		{"paused-requeue", opts.pausedRequeue},
+		{"event-coalesce-window", opts.coalesceWindow},
+		{"external-trigger-debounce", opts.debounceWindow},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("--%s: must not be negative, got %s", d.flag, d.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pagedCache returns the function creating the cache of the manager, whose informers list the resources in pages of
+// the given size. The other requests of the manager are sent through the client as it is.
+func pagedCache(pageSize int64) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		if opts.HTTPClient == nil {
+			httpClient, err := rest.HTTPClientFor(config)
+			if err != nil {
+				return nil, err
+			}
+			opts.HTTPClient = httpClient
+		}
+		opts.HTTPClient = kubeclient.Paginate(opts.HTTPClient, pageSize)
+		return cache.New(config, opts)
+	}
+}
+
+// cacheOptions returns the options of the cache of the manager: the transforms trimming the objects of each kind
+// before they are cached, and the namespaces watched in namespaced mode.
+func (opts *options) cacheOptions(cfg *config.Config, logger logr.Logger) (cache.Options, error) {
+	// namespaceObj is the key of the ByObject entry for namespaces, kept to refine it in namespaced mode.
+	namespaceObj := &corev1.Namespace{}
+	cacheOpts := cache.Options{
+		DefaultUnsafeDisableDeepCopy: ptr.To(true),
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {
+				Transform: collectors.PodTransformer(logger),
+			},
+			&corev1.Service{}: {
+				Transform: collectors.ServiceTransformer(logger),
+			},
+			namespaceObj: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+			&corev1.ReplicationController{}: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+			&v1.Deployment{}: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+			&v1.ReplicaSet{}: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+			&v1.DaemonSet{}: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+			&discoveryv1.EndpointSlice{}: {
+				Transform: collectors.EndpointsliceTransformer(logger),
+			},
+			&corev1.Node{}: {
+				Transform: collectors.PartialObjectTransformer(logger),
+			},
+		},
+	}
+
+	// The kinds whose status fields are projected in the events are watched as full objects, trimmed in the cache to
+	// their metadata and projected status fields.
+	cached := make(map[schema.GroupVersionKind]bool, len(cacheOpts.ByObject))
+	for obj, byObject := range cacheOpts.ByObject {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("unable to get the group version kind of %T: %w", obj, err)
+		}
+		cached[gvk] = true
+		if statusFields := cfg.StatusFields(gvk.Kind); len(statusFields) > 0 {
+			logger.Info("projecting status fields in the events", "resource kind", gvk.Kind, "fields", statusFields)
+			byObject.Transform = collectors.StatusObjectTransformer(logger, statusFields)
+		}
+		byObject.Transform = collectors.KeepAnnotationsTransformer(byObject.Transform, opts.annotationKeys(cfg, gvk.Kind))
+		cacheOpts.ByObject[obj] = byObject
+	}
+	// The resources of the kinds not supported out of the box, and of the kinds of the collectors registered by
+	// downstream forks, are watched as metadata.
+	extraGVKs := cfg.ExtraResources()
+	for _, reg := range collectors.Registered() {
+		if !cached[reg.GVK] {
+			extraGVKs = append(extraGVKs, reg.GVK)
+		}
+	}
+	for _, gvk := range extraGVKs {
+		obj := &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind}}
+		cacheOpts.ByObject[obj] = cache.ByObject{
+			Transform: collectors.KeepAnnotationsTransformer(collectors.PartialObjectTransformer(logger),
+				opts.annotationKeys(cfg, gvk.Kind)),
+		}
+	}
+
+	// In namespaced mode the namespaced resources are watched only in the given namespaces. Namespaces are cluster
+	// scoped, so we select them by the name label set by the api-server on each namespace.
+	if len(opts.namespaces) > 0 {
+		logger.Info("running in namespaced mode", "namespaces", opts.namespaces)
+		cacheOpts.DefaultNamespaces = make(map[string]cache.Config, len(opts.namespaces))
+		for _, ns := range opts.namespaces {
+			cacheOpts.DefaultNamespaces[ns] = cache.Config{}
+		}
+		req, err := labels.NewRequirement(corev1.LabelMetadataName, selection.In, opts.namespaces)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("invalid namespaces %v: %w", opts.namespaces, err)
+		}
+		nsByObject := cacheOpts.ByObject[namespaceObj]
+		nsByObject.Label = labels.NewSelector().Add(*req)
+		cacheOpts.ByObject[namespaceObj] = nsByObject
+	}
+	return cacheOpts, nil
+}
+
+// annotationKeys returns the keys of the annotations of the resources of the given kind kept in the cache: the ones
+// matched by the annotation selector of the collector, and the ones forwarded in the metadata. The other annotations,
+// but the one excluding the resources, are removed by the transforms.
+func (opts *options) annotationKeys(cfg *config.Config, kind string) []string {
+	return append(cfg.AnnotationKeys(kind), opts.allowedAnnots...)
+}
+
+// rateLimiterSettings returns the settings of the rate limiter of a collector from its configuration.
+func rateLimiterSettings(cfg *config.RateLimiterConfig) collectors.RateLimiterSettings {
+	var settings collectors.RateLimiterSettings
+	if cfg == nil {
+		return settings
+	}
+	if cfg.BaseDelay != nil {
+		settings.BaseDelay = cfg.BaseDelay.Duration
+	}
+	if cfg.MaxDelay != nil {
+		settings.MaxDelay = cfg.MaxDelay.Duration
+	}
+	if cfg.QPS != nil {
+		settings.QPS = *cfg.QPS
+	}
+	if cfg.Burst != nil {
+		settings.Burst = *cfg.Burst
+	}
+	return settings
 }
 
 // New returns a new run command.
@@ -107,239 +498,320 @@ func New(ctx context.Context, logger *logr.Logger) *cobra.Command {
 	// and the logger.
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 	opts.flags.add(cmd.Flags())
+	opts.flagSet = cmd.Flags()
 
 	return cmd
 }
 
 // Run starts the metacollector.
 func (opts *options) Run(ctx context.Context) {
-	// Set the logger.
+	// Set the logger. Its level is kept to apply the log verbosity of the configuration file.
+	var logLevel *uberzap.AtomicLevel
 	if opts.logger != nil {
 		ctrl.SetLogger(*opts.logger)
 	} else {
+		level, ok := opts.loggerOpts.Level.(uberzap.AtomicLevel)
+		if !ok {
+			level = uberzap.NewAtomicLevelAt(zapcore.InfoLevel)
+			opts.loggerOpts.Level = level
+		}
+		logLevel = &level
 		ctrl.SetLogger(zap.New(zap.UseFlagOptions(opts.loggerOpts)))
 	}
 
 	setupLog := ctrl.Log.WithName("setup")
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme: scheme,
-		Metrics: server.Options{
-			BindAddress: opts.metricsAddr,
-		},
-		HealthProbeBindAddress: opts.probeAddr,
-		Cache: cache.Options{
-			DefaultUnsafeDisableDeepCopy: ptr.To(true),
-			ByObject: map[client.Object]cache.ByObject{
-				&corev1.Pod{}: {
-					Transform: collectors.PodTransformer(setupLog),
-				},
-				&corev1.Service{}: {
-					Transform: collectors.ServiceTransformer(setupLog),
-				},
-				&corev1.Namespace{}: {
-					Transform: collectors.PartialObjectTransformer(setupLog),
-				},
-				&corev1.ReplicationController{}: {
-					Transform: collectors.PartialObjectTransformer(setupLog),
-				},
-				&v1.Deployment{}: {
-					Transform: collectors.PartialObjectTransformer(setupLog),
-				},
-				&v1.ReplicaSet{}: {
-					Transform: collectors.PartialObjectTransformer(setupLog),
-				},
-				&v1.DaemonSet{}: {
-					Transform: collectors.PartialObjectTransformer(setupLog),
-				},
-				&discoveryv1.EndpointSlice{}: {
-					Transform: collectors.EndpointsliceTransformer(setupLog),
-				},
-			},
-		},
-	})
-	if err != nil {
-		setupLog.Error(err, "creating manager")
-		os.Exit(1)
+	cfg := config.Default()
+	if opts.configPath != "" {
+		var err error
+		var unknown []string
+		if cfg, unknown, err = config.Load(opts.configPath); err != nil {
+			setupLog.Error(err, "unable to load configuration")
+			os.Exit(1)
+		}
+		for _, key := range unknown {
+			setupLog.Info("WARNING: unknown configuration key, it is ignored", "path", opts.configPath, "key", key)
+		}
 	}
-	if err = collectors.IndexPodByPrefixName(ctx, mgr.GetFieldIndexer()); err != nil {
-		setupLog.Error(err, "unable to add indexer by prefix name for pods")
+	opts.overrideTracing(cfg)
+	if err := cfg.Validate(); err != nil {
+		setupLog.Error(err, "invalid configuration")
 		os.Exit(1)
 	}
+	// The flags before the settings of the file are applied are kept to reload them.
+	baseOpts := *opts
+	opts.applyBrokerConfig(&cfg.Broker)
+	if logLevel != nil && cfg.LogVerbosity != nil && !opts.changed("zap-log-level") {
+		logLevel.SetLevel(verbosityLevel(cfg.LogVerbosity))
+	}
+	for _, gvk := range cfg.ExtraResources() {
+		resource.Register(gvk)
+	}
+	for _, kind := range config.Kinds() {
+		if !cfg.IsEnabled(kind) {
+			setupLog.Info("collector disabled by configuration", "resource kind", kind)
+		}
+	}
 
-	if err = collectors.IndexPodByNode(ctx, mgr.GetFieldIndexer()); err != nil {
-		setupLog.Error(err, "unable to add indexer by node name for pods")
+	if err := opts.validate(); err != nil {
+		setupLog.Error(err, "invalid flags")
 		os.Exit(1)
 	}
-
-	// Create source for deployments.
-	dpl := make(chan event.GenericEvent, 1)
-	deploymentSource := &source.Channel{Source: dpl}
-
-	// Create source for replicasets.
-	rs := make(chan event.GenericEvent, 1)
-	replicasetSource := &source.Channel{Source: rs}
-
-	// Create source for namespaces.
-	ns := make(chan event.GenericEvent, 1)
-	namespaceSource := &source.Channel{Source: ns}
-
-	// Create source for daemonsets.
-	ds := make(chan event.GenericEvent, 1)
-	daemonsetSource := &source.Channel{Source: ds}
-
-	// Create source for replicationcontrollers.
-	rc := make(chan event.GenericEvent, 1)
-	rcSource := &source.Channel{Source: rc}
-
-	externalSrc := make(map[string]chan<- event.GenericEvent)
-	externalSrc[resource.Deployment] = dpl
-	externalSrc[resource.ReplicaSet] = rs
-	externalSrc[resource.Namespace] = ns
-	externalSrc[resource.Daemonset] = rc
-
-	// Create source for pods.
-	pd := make(chan event.GenericEvent, 1)
-	podSource := &source.Channel{Source: pd}
-
-	// Create source for services.
-	svc := make(chan event.GenericEvent, 1)
-	serviceSource := &source.Channel{Source: svc}
-
-	podChanTrig := make(subscriber.SubsChan)
-
-	queue := broker.NewBlockingChannel(1)
-
-	podCollector := collectors.NewPodCollector(mgr.GetClient(), queue, events.NewCache(), "pod-collector",
-		collectors.WithOwnerSources(externalSrc),
-		collectors.WithSubscribersChan(podChanTrig),
-		collectors.WithExternalSource(podSource))
-
-	if err = podCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.Pod)
+	truncation, err := collectors.ParseTruncationPolicy(opts.truncation)
+	if err != nil {
+		setupLog.Error(err, "invalid metadata truncation policy")
 		os.Exit(1)
 	}
-
-	dplChanTrig := make(subscriber.SubsChan)
-	dplCollector := collectors.NewObjectMetaCollector(mgr.GetClient(), queue, events.NewCache(),
-		collectors.NewPartialObjectMetadata(resource.Deployment, nil), "deployment-collector",
-		collectors.WithSubscribersChan(dplChanTrig),
-		collectors.WithExternalSource(deploymentSource),
-		collectors.WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
-			return &client.MatchingFields{
-				"metadata.generateName": meta.Name,
-			}
-		}))
-
-	if err = dplCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.Deployment)
+	metaEncoder, err := collectors.ParseMetaEncoder(opts.metaEncoder)
+	if err != nil {
+		setupLog.Error(err, "invalid metadata encoder")
 		os.Exit(1)
 	}
 
-	rsChanTrig := make(subscriber.SubsChan)
-	rsCollector := collectors.NewObjectMetaCollector(mgr.GetClient(), queue, events.NewCache(),
-		collectors.NewPartialObjectMetadata(resource.ReplicaSet, nil), "replicaset-collector",
-		collectors.WithSubscribersChan(rsChanTrig),
-		collectors.WithExternalSource(replicasetSource),
-		collectors.WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
-			return &client.MatchingFields{
-				"metadata.generateName": meta.Name + "-",
-			}
-		}))
-
-	if err = rsCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.ReplicaSet)
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
 		os.Exit(1)
 	}
+	defer func() {
+		// The context of the command is already canceled, give the exporter some time to flush the spans.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			setupLog.Error(err, "unable to shut down tracing")
+		}
+	}()
+	if cfg.Tracing.Enabled() {
+		setupLog.Info("tracing enabled", "exporter", cfg.Tracing.Exporter, "endpoint", cfg.Tracing.Endpoint)
+	}
 
-	nsChanTrig := make(subscriber.SubsChan)
-	nsCollector := collectors.NewObjectMetaCollector(mgr.GetClient(), queue, events.NewCache(),
-		collectors.NewPartialObjectMetadata(resource.Namespace, nil), "namespace-collector",
-		collectors.WithSubscribersChan(nsChanTrig),
-		collectors.WithExternalSource(namespaceSource))
-
-	if err = nsCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.Namespace)
+	cacheOpts, err := opts.cacheOptions(cfg, setupLog)
+	if err != nil {
+		setupLog.Error(err, "unable to set up the cache")
 		os.Exit(1)
 	}
 
-	dsChanTrig := make(subscriber.SubsChan)
-	dsCollector := collectors.NewObjectMetaCollector(mgr.GetClient(), queue, events.NewCache(),
-		collectors.NewPartialObjectMetadata(resource.Daemonset, nil), "daemonset-collector",
-		collectors.WithSubscribersChan(dsChanTrig),
-		collectors.WithExternalSource(daemonsetSource),
-		collectors.WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
-			return &client.MatchingFields{
-				"metadata.generateName": meta.Name + "-",
-			}
-		}))
+	// The requests sent to the api-server are rate limited on the client side and counted per collector.
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = opts.kubeAPIQPS
+	restConfig.Burst = opts.kubeAPIBurst
+	kubeclient.Instrument(restConfig)
 
-	if err = dsCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.Daemonset)
-		os.Exit(1)
+	// The pprof endpoints are served on the metrics endpoint, unless a dedicated address is given.
+	metricsOpts := server.Options{
+		BindAddress: opts.metricsAddr,
+	}
+	var pprofAddr string
+	if opts.pprofEnabled {
+		if opts.pprofAddr != "" {
+			pprofAddr = opts.pprofAddr
+		} else {
+			metricsOpts.ExtraHandlers = debug.PprofHandlers(true)
+		}
+		setupLog.Info("pprof endpoints enabled", "metrics endpoint", pprofAddr == "", "address", opts.pprofAddr)
 	}
 
-	rcChanTrig := make(subscriber.SubsChan)
-	rcCollector := collectors.NewObjectMetaCollector(mgr.GetClient(), queue, events.NewCache(),
-		collectors.NewPartialObjectMetadata(resource.ReplicationController, nil), "replicationcontroller-collector",
-		collectors.WithSubscribersChan(rcChanTrig),
-		collectors.WithExternalSource(rcSource),
-		collectors.WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
-			return &client.MatchingFields{
-				"metadata.generateName": meta.Name + "-",
-			}
-		}))
-
-	if err = rcCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.ReplicationController)
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsOpts,
+		HealthProbeBindAddress: opts.probeAddr,
+		PprofBindAddress:       pprofAddr,
+		Cache:                  cacheOpts,
+		NewCache:               pagedCache(opts.listPageSize),
+	})
+	if err != nil {
+		setupLog.Error(err, "creating manager")
 		os.Exit(1)
 	}
+	// indexRegistry registers the field indexers declared by the enabled collectors, each of them once.
+	indexRegistry := collectors.NewIndexRegistry(mgr.GetFieldIndexer())
+
+	var queue broker.Queue
+	if opts.dryRun {
+		var out io.Writer
+		if opts.dryRunOutput != "" {
+			f, err := os.Create(opts.dryRunOutput)
+			if err != nil {
+				setupLog.Error(err, "unable to create dry-run output file", "path", opts.dryRunOutput)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		setupLog.Info("running in dry-run mode, events will not be dispatched to subscribers")
+		queue = broker.NewDryRunQueue(ctrl.Log.WithName("dry-run"), out)
+	} else {
+		var err error
+		if queue, err = broker.NewQueue(opts.queueType, opts.queueCapacity); err != nil {
+			setupLog.Error(err, "unable to create the broker queue")
+			os.Exit(1)
+		}
+	}
 
-	svcChanTrig := make(subscriber.SubsChan)
-
-	svcCollector := collectors.NewServiceCollector(mgr.GetClient(), queue, events.NewCache(), "service-collector",
-		collectors.WithExternalSource(serviceSource),
-		collectors.WithSubscribersChan(svcChanTrig))
+	// auditSink records the events sent to the subscribers, if enabled.
+	var auditSink *audit.Sink
+	if opts.auditPath != "" {
+		var out io.Writer = os.Stdout
+		if opts.auditPath != "-" {
+			f, err := audit.OpenRotatingFile(opts.auditPath, opts.auditMaxSize)
+			if err != nil {
+				setupLog.Error(err, "unable to open audit file", "path", opts.auditPath)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		setupLog.Info("audit enabled", "path", opts.auditPath)
+		auditSink = audit.NewSink(ctrl.Log.WithName("audit"), out, opts.auditBuffer)
+	}
 
-	if err = svcCollector.SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create collector for", "resource kind", resource.Service)
-		os.Exit(1)
+	// barrier is lifted when all the enabled collectors have completed their initial sync. Until then
+	// the broker refuses the subscriptions and the readiness probe fails.
+	barrier := health.NewBarrier()
+
+	// healthRegistry tracks the outcome of the reconciles of the collectors, a stuck or failing collector fails the
+	// health checks.
+	healthRegistry := health.NewRegistry(opts.staleness)
+
+	// syncStatus tracks the initial sweep of the collectors and the listener of the broker, the readiness probe
+	// fails until they are all done. dispatchProgress tracks the dispatch loop of the broker, a stuck loop fails the
+	// health checks.
+	syncStatus := health.NewSyncStatus()
+	dispatchProgress := health.NewProgress("dispatch loop", opts.dispatchStuck)
+
+	// caches holds the caches of the enabled collectors, swept by the node cleaner when a node is deleted.
+	var caches []*events.Cache
+	// cascade sweeps the same caches when a namespace is deleted, on behalf of the namespace collector.
+	cascade := collectors.NewNamespaceCascade()
+	// cachesByName holds the same caches by collector name, served by the debug endpoints.
+	cachesByName := make(map[string]*events.Cache)
+	newCache := func(name string) *events.Cache {
+		c := events.NewCache(events.WithName(name))
+		caches = append(caches, c)
+		cascade.Register(c)
+		cachesByName[name] = c
+		return c
 	}
 
-	if err = (&collectors.EndpointsDispatcher{
-		Client:                 mgr.GetClient(),
-		Name:                   "endpoint-dispatcher",
-		ServiceCollectorSource: svc,
-		PodCollectorSource:     pd,
-		Pods:                   make(map[string]map[string]struct{}),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create dispatcher for", "resource kind", resource.EndpointSlice)
-		os.Exit(1)
+	// nodesMemo memoizes the nodes of the pods related to the resources. It is invalidated by the pod collector.
+	nodesMemo := collectors.NewNodesMemo()
+
+	// collectorsChans holds the channels where the enabled collectors get notified of new subscribers.
+	collectorsChans := make(map[string]subscriber.SubsChan)
+	// reloadable holds the enabled collectors, whose selectors are reloaded when the configuration file changes.
+	reloadable := make(map[string]selectable)
+	// pausable holds the enabled collectors by name, paused and resumed through the debug endpoints.
+	pausable := make(map[string]debug.Pausable)
+
+	// The collectors are built from the registry, for the enabled kinds, and for the extra kinds of the configuration.
+	var registrations []collectors.Registration
+	for _, reg := range collectors.Registered() {
+		if cfg.IsEnabled(reg.GVK.Kind) {
+			registrations = append(registrations, reg)
+		}
+	}
+	for _, gvk := range cfg.ExtraResources() {
+		registrations = append(registrations, collectors.MetadataRegistration(gvk))
+		setupLog.Info("collecting the metadata of an extra resource kind", "group version kind", gvk.String())
+	}
+	// Only the enabled collectors are triggered by the others, otherwise nobody would consume the events sent on the
+	// channels.
+	enabled := make([]string, 0, len(registrations))
+	for _, reg := range registrations {
+		enabled = append(enabled, reg.GVK.Kind)
+	}
+	triggers := collectors.NewTriggers(enabled...)
+
+	for _, reg := range registrations {
+		kind := reg.GVK.Kind
+		chanTrig := make(subscriber.SubsChan)
+		collector, err := reg.Build(&collectors.Setup{
+			Manager:  mgr,
+			Queue:    queue,
+			Cache:    newCache(reg.Name),
+			Triggers: triggers,
+			Options: []collectors.CollectorOption{
+				collectors.WithBarrier(barrier),
+				collectors.WithHealthRegistry(healthRegistry),
+				collectors.WithSyncStatus(syncStatus),
+				collectors.WithIndexRegistry(indexRegistry),
+				collectors.WithAPIReader(mgr.GetAPIReader()),
+				collectors.WithClusterName(opts.clusterName),
+				collectors.WithAllowedLabels(opts.allowedLabels...),
+				collectors.WithAllowedAnnotations(opts.allowedAnnots...),
+				collectors.WithMaxMetaSize(opts.maxMetaSize, truncation),
+				collectors.WithMetaEncoder(metaEncoder),
+				collectors.WithWarmup(opts.warmup),
+				collectors.WithMinAge(opts.minAge),
+				collectors.WithNodelessRequeue(opts.nodeless),
+				collectors.WithPausedRequeue(opts.pausedRequeue),
+				collectors.WithLabelSelector(cfg.LabelSelector(kind)),
+				collectors.WithAnnotationSelector(cfg.AnnotationSelector(kind)),
+				collectors.WithExcludedNames(cfg.ExcludedNames(kind)...),
+				collectors.WithStatusFields(cfg.StatusFields(kind)...),
+				collectors.WithResyncPeriod(opts.resyncPeriod),
+				collectors.WithTTL(opts.ttl),
+				collectors.WithJitter(opts.jitter),
+				collectors.WithRateLimiter(rateLimiterSettings(cfg.Collectors[kind].RateLimiter)),
+				collectors.WithNamespaces(opts.namespaces),
+				collectors.WithNodesMemo(nodesMemo),
+				collectors.WithNamespaceCascade(cascade),
+				collectors.WithCoalesceWindow(opts.coalesceWindow),
+				collectors.WithDebounceWindow(opts.debounceWindow),
+				collectors.WithTerminatedPods(opts.terminatedPods),
+				collectors.WithEndpointsNodes(opts.endpointsNodes),
+				collectors.WithZoneNodes(opts.zoneNodes),
+				collectors.WithExternalNameNodes(opts.externalName),
+				collectors.WithNodeLabels(opts.nodeLabels...),
+				collectors.WithBroadcastNamespaces(cfg.BroadcastNamespaces...),
+				collectors.WithSubscribersChan(chanTrig),
+			},
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create collector for", "resource kind", kind)
+			os.Exit(1)
+		}
+		collectorsChans[kind] = chanTrig
+		reloadable[kind] = collector
+		if p, ok := collector.(debug.Pausable); ok {
+			pausable[reg.Name] = p
+		}
 	}
 
-	if err = (&collectors.EndpointslicesDispatcher{
-		Client:                 mgr.GetClient(),
-		Name:                   "endpointslices-dispatcher",
-		ServiceCollectorSource: svc,
-		PodCollectorSource:     pd,
-		Pods:                   make(map[string]map[string]struct{}),
-		ServicesName:           make(map[string]string),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create dispatcher for", "resource kind", resource.EndpointSlice)
-		os.Exit(1)
+	if opts.dryRun {
+		dryRunSubs := &collectors.DryRunSubscribers{
+			Client:     mgr.GetClient(),
+			Collectors: collectorsChans,
+			Name:       "dry-run-subscribers",
+		}
+		if err = dryRunSubs.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create dry-run subscribers for", "resource kind", resource.Node)
+			os.Exit(1)
+		}
+		if err = mgr.Add(dryRunSubs); err != nil {
+			setupLog.Error(err, "unable to add dry-run subscribers to the manager as a runnable")
+			os.Exit(1)
+		}
 	}
 
-	br, err := broker.New(ctrl.Log.WithName("broker"), queue, map[string]subscriber.SubsChan{
-		resource.Pod:                   podChanTrig,
-		resource.Deployment:            dplChanTrig,
-		resource.ReplicaSet:            rsChanTrig,
-		resource.Daemonset:             dsChanTrig,
-		resource.Service:               svcChanTrig,
-		resource.Namespace:             nsChanTrig,
-		resource.ReplicationController: rcChanTrig,
-	},
+	br, err := broker.New(ctrl.Log.WithName("broker"), queue, collectorsChans,
 		broker.WithAddress(opts.brokerAddr),
-		broker.WithTLS(opts.certFilePath, opts.keyFilePath))
+		broker.WithTLS(opts.certFilePath, opts.keyFilePath),
+		broker.WithDryRun(opts.dryRun),
+		broker.WithBarrier(barrier),
+		broker.WithThrottle(opts.nodeRate, opts.nodeBurst, opts.maxDeleteDelay),
+		broker.WithMaxBackfills(opts.maxBackfills),
+		broker.WithNodeTakeover(opts.nodeTakeover),
+		broker.WithReflection(opts.reflection),
+		broker.WithRPCLogVerbosity(opts.rpcLogLevel),
+		broker.WithResyncInterval(opts.resyncInterval),
+		broker.WithNodeMetrics(opts.nodeMetrics),
+		broker.WithClusterName(opts.clusterName),
+		broker.WithMaxMessageSize(opts.maxMessageSize),
+		broker.WithSendTimeout(opts.sendTimeout),
+		broker.WithAudit(auditSink),
+		broker.WithSyncStatus(syncStatus),
+		broker.WithProgress(dispatchProgress))
 
 	if err != nil {
 		setupLog.Error(err, "unable to create the broker")
@@ -351,48 +823,91 @@ func (opts *options) Run(ctx context.Context) {
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(podCollector); err != nil {
-		setupLog.Error(err, "unable to add pod collector to the manager as a runnable")
-		os.Exit(1)
+	// The selectors, the broker throttling and the log verbosity are reloaded when the configuration file changes.
+	if opts.configPath != "" {
+		rl := &reloader{
+			logger:     ctrl.Log.WithName("config"),
+			started:    cfg,
+			current:    cfg,
+			base:       baseOpts,
+			running:    *opts,
+			collectors: reloadable,
+			broker:     br,
+			logLevel:   logLevel,
+		}
+		watcher, err := config.NewWatcher(ctrl.Log.WithName("config-watcher"), opts.configPath, func(cfg *config.Config) {
+			opts.overrideTracing(cfg)
+			rl.reload(cfg)
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to watch the configuration file")
+			os.Exit(1)
+		}
+		if err = mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to add the configuration watcher to the manager")
+			os.Exit(1)
+		}
+	}
+
+	if auditSink != nil {
+		if err = mgr.Add(auditSink); err != nil {
+			setupLog.Error(err, "unable to add the audit sink to the manager")
+			os.Exit(1)
+		}
 	}
 
-	if err = mgr.Add(dsCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", dsCollector.GetName())
+	if err = (&collectors.NodeCleaner{
+		Client:      mgr.GetClient(),
+		Name:        "node-cleaner",
+		Caches:      caches,
+		Subscribers: br,
+		Queue:       queue,
+		ClusterName: opts.clusterName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create node cleaner for", "resource kind", resource.Node)
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(nsCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", nsCollector.GetName())
+	if opts.debugEnabled {
+		if err = mgr.Add(debug.New(ctrl.Log.WithName("debug"), opts.debugAddr, cachesByName,
+			debug.WithSubscribers(br), debug.WithPausable(pausable))); err != nil {
+			setupLog.Error(err, "unable to add the debug server to the manager")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(dplCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", dplCollector.GetName())
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(rsCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", rsCollector.GetName())
+	if err := mgr.AddReadyzCheck("initial-sync", barrier.Checker); err != nil {
+		setupLog.Error(err, "unable to set up initial sync check")
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(rcCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", rcCollector.GetName())
+	if err := mgr.AddHealthzCheck("collectors", healthRegistry.Checker); err != nil {
+		setupLog.Error(err, "unable to set up collectors health check")
 		os.Exit(1)
 	}
 
-	if err = mgr.Add(svcCollector); err != nil {
-		setupLog.Error(err, "unable to add %s collector to the manager as a runnable", svcCollector.GetName())
+	if err := mgr.AddReadyzCheck("collectors", healthRegistry.Checker); err != nil {
+		setupLog.Error(err, "unable to set up collectors ready check")
 		os.Exit(1)
 	}
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
+	if err := mgr.AddReadyzCheck("sync-status", syncStatus.Checker); err != nil {
+		setupLog.Error(err, "unable to set up sync status ready check")
 		os.Exit(1)
 	}
 
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
+	if err := mgr.AddHealthzCheck("dispatch", dispatchProgress.Checker); err != nil {
+		setupLog.Error(err, "unable to set up dispatch health check")
 		os.Exit(1)
 	}
 
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package run
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+func TestValidateFlags(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		want []string
+	}{
+		"defaults":         {},
+		"tls":              {args: []string{"--broker-server-cert=tls.crt", "--broker-server-key=tls.key"}},
+		"cert without key": {args: []string{"--broker-server-cert=tls.crt"}, want: []string{"--broker-server-cert:"}},
+		"key without cert": {args: []string{"--broker-server-key=tls.key"}, want: []string{"--broker-server-key:"}},
+		"no burst":         {args: []string{"--broker-node-rate=10", "--broker-node-burst=0"}, want: []string{"--broker-node-burst:"}},
+		"burst unused":     {args: []string{"--broker-node-burst=0"}},
+		"negative jitter":  {args: []string{"--jitter-factor=-0.1"}, want: []string{"--jitter-factor:"}},
+		"negative page size": {args: []string{"--kube-api-list-page-size=-1"},
+			want: []string{"--kube-api-list-page-size:"}},
+		"external name": {args: []string{"--service-external-name-nodes=cluster"}},
+		"unknown external name nodes": {args: []string{"--service-external-name-nodes=all"},
+			want: []string{"--service-external-name-nodes:"}},
+		"negative durations": {args: []string{"--broker-max-delete-delay=-1s", "--resync-period=-1s", "--metadata-ttl=-1s",
+			"--warmup-period=-1s", "--min-resource-age=-1s", "--nodeless-requeue=-1s", "--event-coalesce-window=-1s",
+			"--external-trigger-debounce=-1s", "--paused-requeue=-1s"}, want: []string{"--broker-max-delete-delay:",
+			"--resync-period:", "--metadata-ttl:", "--warmup-period:", "--min-resource-age:", "--nodeless-requeue:",
+			"--event-coalesce-window:", "--external-trigger-debounce:", "--paused-requeue:"}},
+		"aggregated": {args: []string{"--broker-server-key=tls.key", "--jitter-factor=-1", "--metadata-ttl=-1m"},
+			want: []string{"--broker-server-key:", "--jitter-factor:", "--metadata-ttl:"}},
+	}
+	for name, tt := range tests {
+		opts := options{}
+		fs := pflag.NewFlagSet("run", pflag.ContinueOnError)
+		opts.flags.add(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("%s: unable to parse the flags: %v", name, err)
+		}
+		err := opts.validate()
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if got := len(strings.Split(err.Error(), "\n")); got != len(tt.want) {
+			t.Errorf("%s: expected %d problems, got %d: %v", name, len(tt.want), got, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected the error to name %q, got %v", name, want, err)
+			}
+		}
+	}
+
+	// The settings of the configuration file are validated as well.
+	opts := options{flags: flags{nodeRate: 10}}
+	if err := opts.validate(); err == nil || !strings.Contains(err.Error(), "--broker-node-burst:") {
+		t.Errorf("expected the burst to be invalid, got %v", err)
+	}
+}
+
+func TestPagedCache(t *testing.T) {
+	var mu sync.Mutex
+	var lists []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			<-r.Context().Done()
+			return
+		}
+		mu.Lock()
+		lists = append(lists, r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`))
+	}))
+	defer srv.Close()
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	c, err := pagedCache(100)(&rest.Config{Host: srv.URL}, cache.Options{Scheme: scheme, Mapper: mapper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() { _ = c.Start(ctx) }()
+	if _, err := c.GetInformer(ctx, &corev1.Pod{}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.WaitForCacheSync(ctx) {
+		t.Fatal("cache not synced")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lists) == 0 || lists[0] != "limit=100" {
+		t.Errorf("expected the informer to list the pods in pages of 100 from etcd, got %q", lists)
+	}
+}
+
+func TestCacheAnnotations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[{` +
+			`"metadata":{"name":"pod","namespace":"default","annotations":{"team":"checkout","secret":"token",` +
+			`"example.com/skip":"true"}},"spec":{"nodeName":"node"}}]}`))
+	}))
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.Collectors = map[string]config.CollectorConfig{resource.Pod: {AnnotationSelector: "!example.com/skip"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		allowed []string
+		want    map[string]string
+	}{
+		"selector keys only": {want: map[string]string{"example.com/skip": "true"}},
+		"allowed keys":       {allowed: []string{"team"}, want: map[string]string{"example.com/skip": "true", "team": "checkout"}},
+	}
+	for name, tt := range tests {
+		opts := options{flags: flags{allowedAnnots: tt.allowed}}
+		cacheOpts, err := opts.cacheOptions(cfg, logr.Discard())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the pods are served.
+		for obj := range cacheOpts.ByObject {
+			if _, ok := obj.(*corev1.Pod); !ok {
+				delete(cacheOpts.ByObject, obj)
+			}
+		}
+		mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+		cacheOpts.Scheme = scheme
+		cacheOpts.Mapper = mapper
+		c, err := cache.New(&rest.Config{Host: srv.URL}, cacheOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		go func() { _ = c.Start(ctx) }()
+		if _, err := c.GetInformer(ctx, &corev1.Pod{}); err != nil || !c.WaitForCacheSync(ctx) {
+			cancel()
+			t.Fatalf("%s: cache not synced: %v", name, err)
+		}
+		pod := &corev1.Pod{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "pod"}, pod); err != nil {
+			cancel()
+			t.Fatalf("%s: %v", name, err)
+		}
+		cancel()
+		// The annotations not kept are removed by the transforms before the pod is cached.
+		if !reflect.DeepEqual(pod.Annotations, tt.want) {
+			t.Errorf("%s: expected the cached annotations %v, got %v", name, tt.want, pod.Annotations)
+		}
+	}
+}
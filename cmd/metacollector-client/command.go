@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber/client"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	// outputPretty prints each event as indented JSON, with its metadata decoded.
+	outputPretty = "pretty"
+	// outputRaw prints each event as received from the broker, on a single line.
+	outputRaw = "raw"
+)
+
+// options holds the flags of the command.
+type options struct {
+	address       string
+	caFilePath    string
+	tlsServerName string
+	nodeName      string
+	kinds         []string
+	namespaces    []string
+	output        string
+	snapshotOnly  bool
+	stats         bool
+	verbose       bool
+}
+
+// newCommand returns the command subscribing to the broker and printing the events on the given writer.
+func newCommand(ctx context.Context, out io.Writer) *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "metacollector-client",
+		Short: "Prints the events a node receives from the metacollector",
+		Long: "Subscribes to the broker of the metacollector for a node, as a Falco instance running on it would, and " +
+			"prints the events received. The resources existing at the time of the subscription are received first, " +
+			"followed by the SnapshotComplete event, and then their changes.",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return opts.run(ctx, out)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.address, "address", "localhost:45000", "Address of the broker")
+	flags.StringVar(&opts.caFilePath, "tls-ca", "", "CA file used to verify the certificate of the broker. If not set "+
+		"the connection is not encrypted")
+	flags.StringVar(&opts.tlsServerName, "tls-server-name", "", "Name used to verify the certificate of the broker, "+
+		"if different from the host of the address")
+	flags.StringVar(&opts.nodeName, "node", "", "Name of the node to subscribe for")
+	flags.StringSliceVar(&opts.kinds, "kinds", nil, "Kinds of the resources to subscribe to, e.g. Pod,Service. "+
+		"All the kinds of the built-in collectors by default")
+	flags.StringSliceVar(&opts.namespaces, "namespaces", nil, "Namespaces of the resources to print. All the "+
+		"namespaces by default")
+	flags.StringVarP(&opts.output, "output", "o", outputPretty, "Output format of the events, pretty or raw")
+	flags.BoolVar(&opts.snapshotOnly, "snapshot-only", false, "Exit once the resources existing at the time of the "+
+		"subscription have been received")
+	flags.BoolVar(&opts.stats, "stats", false, "Print the number of events received per kind on exit, instead of "+
+		"the events")
+	flags.BoolVarP(&opts.verbose, "verbose", "v", false, "Log the reconnections to the broker on the standard error")
+	_ = cmd.MarkFlagRequired("node")
+
+	return cmd
+}
+
+// run subscribes to the broker and prints the events until the context is canceled, or the snapshot is complete if
+// requested.
+func (opts *options) run(ctx context.Context, out io.Writer) error {
+	if opts.output != outputPretty && opts.output != outputRaw {
+		return fmt.Errorf("unknown output %q, supported outputs are %s and %s", opts.output, outputPretty, outputRaw)
+	}
+
+	clientOpts := []client.Option{client.WithResourceKinds(opts.kinds...)}
+	if opts.caFilePath != "" {
+		clientOpts = append(clientOpts, client.WithTLS(opts.caFilePath, opts.tlsServerName))
+	}
+	if opts.verbose {
+		clientOpts = append(clientOpts, client.WithLogger(zap.New(zap.WriteTo(os.Stderr))))
+	}
+	cl, err := client.New(opts.address, opts.nodeName, clientOpts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p := newPrinter(out, opts.output, opts.namespaces, opts.stats)
+	if err := p.tail(cl.Events(ctx), opts.snapshotOnly); err != nil {
+		return err
+	}
+	return p.printStats()
+}
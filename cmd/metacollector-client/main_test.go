@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber/client"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// snapshotServer is a broker sending the given events to each subscriber, followed by the SnapshotComplete event.
+type snapshotServer struct {
+	metadata.UnimplementedMetadataServer
+	events []*metadata.Event
+}
+
+func (s *snapshotServer) Watch(_ *metadata.Selector, stream metadata.Metadata_WatchServer) error {
+	for _, evt := range s.events {
+		if err := stream.Send(evt); err != nil {
+			return err
+		}
+	}
+	if err := stream.Send(&metadata.Event{Reason: events.SnapshotComplete}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func meta(name, namespace string) *string {
+	data, _ := json.Marshal(metav1.ObjectMeta{Name: name, Namespace: namespace})
+	m := string(data)
+	return &m
+}
+
+func TestCommandSnapshotOnly(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	metadata.RegisterMetadataServer(server, &snapshotServer{events: []*metadata.Event{
+		{Reason: events.Create, Kind: resource.Pod, Uid: "pod-1", Meta: meta("pod-1", "default")},
+		{Reason: events.Create, Kind: resource.Pod, Uid: "pod-2", Meta: meta("pod-2", "kube-system")},
+		{Reason: events.Create, Kind: resource.Namespace, Uid: "ns", Meta: meta("default", "")},
+	}})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := newCommand(ctx, &out)
+		cmd.SetArgs(append([]string{"--address", lis.Addr().String(), "--node", "node", "--snapshot-only"}, args...))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out.String()
+	}
+
+	// The command exits once the snapshot is complete.
+	stats := run("--stats", "--namespaces", "default")
+	want := "KIND       ADDED  MODIFIED  DELETED  TOTAL\nNamespace  1      0         0        1\nPod        1      0         0        1\n"
+	if stats != want {
+		t.Errorf("expected stats\n%s\ngot\n%s", want, stats)
+	}
+
+	lines := strings.Split(strings.TrimSpace(run("-o", "raw")), "\n")
+	if len(lines) != 4 || lines[3] != `{"type":"SnapshotComplete"}` {
+		t.Fatalf("expected 3 events and the SnapshotComplete marker, got %q", lines)
+	}
+	var raw struct {
+		Type    client.EventType
+		Message metadata.Event
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &raw); err != nil || raw.Type != client.Added || raw.Message.Uid != "pod-1" {
+		t.Errorf("unexpected raw event %s: %v", lines[0], err)
+	}
+
+	for _, args := range [][]string{{}, {"--node", "node", "-o", "yaml"}} {
+		cmd := newCommand(ctx, &bytes.Buffer{})
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err == nil {
+			t.Errorf("expected an error for the arguments %q", args)
+		}
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	evts := make(chan *client.Event, 10)
+	evts <- &client.Event{Type: client.Added, Kind: resource.Pod, UID: "pod-1",
+		Meta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"}, Status: `{"phase":"Running"}`, Spec: "not json"}
+	evts <- &client.Event{Type: client.Added, Kind: resource.Pod, UID: "pod-2",
+		Meta: metav1.ObjectMeta{Name: "pod-2", Namespace: "other"}}
+	evts <- &client.Event{Type: client.SnapshotComplete}
+	evts <- &client.Event{Type: client.Deleted, Kind: resource.Pod, UID: "pod-1",
+		Meta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"}}
+	close(evts)
+
+	var out bytes.Buffer
+	if err := newPrinter(&out, outputPretty, []string{"default"}, false).tail(evts, false); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&out)
+	var printed []prettyEvent
+	for dec.More() {
+		var evt prettyEvent
+		if err := dec.Decode(&evt); err != nil {
+			t.Fatal(err)
+		}
+		printed = append(printed, evt)
+	}
+	// The pod of the other namespace is filtered out.
+	if len(printed) != 3 || printed[0].UID != "pod-1" || printed[1].Type != client.SnapshotComplete ||
+		printed[2].Type != client.Deleted {
+		t.Fatalf("unexpected events %+v", printed)
+	}
+	var status bytes.Buffer
+	if err := json.Compact(&status, printed[0].Status); err != nil || status.String() != `{"phase":"Running"}` ||
+		string(printed[0].Spec) != `"not json"` {
+		t.Errorf("expected the status embedded as JSON and the invalid spec as a string, got %s and %s",
+			printed[0].Status, printed[0].Spec)
+	}
+	if printed[1].Meta != nil {
+		t.Errorf("expected no metadata for the SnapshotComplete event, got %+v", printed[1].Meta)
+	}
+}
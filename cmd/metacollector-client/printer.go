@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber/client"
+	"google.golang.org/protobuf/encoding/protojson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// prettyEvent is the pretty output of an event.
+type prettyEvent struct {
+	Type    client.EventType    `json:"type"`
+	Kind    string              `json:"kind,omitempty"`
+	UID     string              `json:"uid,omitempty"`
+	Cluster string              `json:"cluster,omitempty"`
+	Meta    *metav1.ObjectMeta  `json:"meta,omitempty"`
+	Spec    json.RawMessage     `json:"spec,omitempty"`
+	Status  json.RawMessage     `json:"status,omitempty"`
+	Refs    map[string][]string `json:"refs,omitempty"`
+}
+
+// rawEvent is the raw output of an event: its type, and the last message received from the broker for the resource.
+type rawEvent struct {
+	Type    client.EventType `json:"type"`
+	Message json.RawMessage  `json:"message,omitempty"`
+}
+
+// printer prints the events received for a node, or counts them.
+type printer struct {
+	out    io.Writer
+	output string
+	// namespaces of the resources printed, nil to print all of them.
+	namespaces map[string]struct{}
+	// counts holds the number of events per kind and type, nil if the events are printed.
+	counts map[string]map[client.EventType]int
+}
+
+// newPrinter returns a printer writing the events in the given output format, or their counts if stats is set.
+func newPrinter(out io.Writer, output string, namespaces []string, stats bool) *printer {
+	p := &printer{out: out, output: output}
+	if len(namespaces) > 0 {
+		p.namespaces = make(map[string]struct{}, len(namespaces))
+		for _, ns := range namespaces {
+			p.namespaces[ns] = struct{}{}
+		}
+	}
+	if stats {
+		p.counts = make(map[string]map[client.EventType]int)
+	}
+	return p
+}
+
+// tail handles the events received on the channel until it is closed, or until the snapshot is complete if
+// snapshotOnly is set.
+func (p *printer) tail(evts <-chan *client.Event, snapshotOnly bool) error {
+	for evt := range evts {
+		if evt.Type == client.SnapshotComplete {
+			if p.counts == nil {
+				if err := p.print(evt); err != nil {
+					return err
+				}
+			}
+			if snapshotOnly {
+				return nil
+			}
+			continue
+		}
+		if !p.matches(evt) {
+			continue
+		}
+		if p.counts != nil {
+			p.count(evt)
+			continue
+		}
+		if err := p.print(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns true if the event concerns a resource in one of the namespaces to print.
+func (p *printer) matches(evt *client.Event) bool {
+	if p.namespaces == nil {
+		return true
+	}
+	namespace := evt.Meta.Namespace
+	if evt.Kind == resource.Namespace {
+		namespace = evt.Meta.Name
+	}
+	_, ok := p.namespaces[namespace]
+	return ok
+}
+
+// count counts the event.
+func (p *printer) count(evt *client.Event) {
+	perType, ok := p.counts[evt.Kind]
+	if !ok {
+		perType = make(map[client.EventType]int)
+		p.counts[evt.Kind] = perType
+	}
+	perType[evt.Type]++
+}
+
+// print writes the event in the output format of the printer.
+func (p *printer) print(evt *client.Event) error {
+	var data []byte
+	var err error
+	if p.output == outputRaw {
+		out := rawEvent{Type: evt.Type}
+		if evt.Raw != nil {
+			if out.Message, err = protojson.Marshal(evt.Raw); err != nil {
+				return fmt.Errorf("unable to encode event %s: %w", evt.UID, err)
+			}
+		}
+		data, err = json.Marshal(out)
+	} else {
+		data, err = json.MarshalIndent(pretty(evt), "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to encode event %s: %w", evt.UID, err)
+	}
+	_, err = fmt.Fprintln(p.out, string(data))
+	return err
+}
+
+// pretty returns the pretty output of the event. The spec and the status are embedded as JSON when valid.
+func pretty(evt *client.Event) prettyEvent {
+	out := prettyEvent{Type: evt.Type, Kind: evt.Kind, UID: evt.UID, Cluster: evt.Cluster, Refs: evt.Refs}
+	if evt.Type != client.SnapshotComplete {
+		meta := evt.Meta
+		out.Meta = &meta
+	}
+	out.Spec = embed(evt.Spec)
+	out.Status = embed(evt.Status)
+	return out
+}
+
+// embed returns the field to be embedded in the JSON output: as is if it is valid JSON, as a string otherwise.
+func embed(field string) json.RawMessage {
+	if field == "" {
+		return nil
+	}
+	if json.Valid([]byte(field)) {
+		return json.RawMessage(field)
+	}
+	quoted, _ := json.Marshal(field)
+	return quoted
+}
+
+// printStats writes the number of events per kind and type, sorted by kind, if the printer counts the events.
+func (p *printer) printStats() error {
+	if p.counts == nil {
+		return nil
+	}
+	kinds := make([]string, 0, len(p.counts))
+	for kind := range p.counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tADDED\tMODIFIED\tDELETED\tTOTAL")
+	for _, kind := range kinds {
+		perType := p.counts[kind]
+		added, modified, deleted := perType[client.Added], perType[client.Modified], perType[client.Deleted]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", kind, added, modified, deleted, added+modified+deleted)
+	}
+	return w.Flush()
+}
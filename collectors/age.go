@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// minAge defers the collection of the resources younger than a threshold, so that the short-lived ones, e.g. the
+// jobs completing in seconds, never generate events. A resource is not sent to the subscribers until it persists past
+// the threshold: its reconcile is requeued until then. Once sent, the resource is collected as any other one.
+type minAge struct {
+	threshold time.Duration
+	// now returns the current time, overridden in the tests.
+	now func() time.Time
+}
+
+// newMinAge returns a minAge with the given threshold. A zero threshold disables it.
+func newMinAge(threshold time.Duration) *minAge {
+	return &minAge{threshold: threshold, now: time.Now}
+}
+
+// wait returns the time left before a resource created at the given time reaches the threshold, zero if it is old
+// enough. The creation timestamp must be read from the resource before its metadata are stripped for serialization.
+// The resources without a creation timestamp are never deferred.
+func (a *minAge) wait(created metav1.Time) time.Duration {
+	if a.threshold <= 0 || created.IsZero() {
+		return 0
+	}
+	left := a.threshold - a.now().Sub(created.Time)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMinAgeWait(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := newMinAge(time.Minute)
+	a.now = func() time.Time { return now }
+
+	tests := map[string]struct {
+		created metav1.Time
+		want    time.Duration
+	}{
+		"young":        {created: metav1.NewTime(now.Add(-20 * time.Second)), want: 40 * time.Second},
+		"old enough":   {created: metav1.NewTime(now.Add(-time.Minute)), want: 0},
+		"older":        {created: metav1.NewTime(now.Add(-time.Hour)), want: 0},
+		"no timestamp": {created: metav1.Time{}, want: 0},
+	}
+	for name, tt := range tests {
+		if got := a.wait(tt.created); got != tt.want {
+			t.Errorf("%s: expected to wait %s, got %s", name, tt.want, got)
+		}
+	}
+	if got := newMinAge(0).wait(metav1.NewTime(time.Now())); got != 0 {
+		t.Errorf("expected no wait when disabled, got %s", got)
+	}
+}
+
+func TestMinAgeReconcile(t *testing.T) {
+	ctx := context.Background()
+	// The timestamps are stored with a precision of a second.
+	now := time.Unix(1000, 0)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "min-age", UID: "ns-uid",
+		CreationTimestamp: metav1.NewTime(now.Add(-10 * time.Second))}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "min-age", UID: "pod-uid",
+			CreationTimestamp: metav1.NewTime(now.Add(-5 * time.Second))},
+		Spec: corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(ns, pod).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	nsCollector := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Namespace, nil),
+		"min-age-namespace-collector", WithMinAge(30*time.Second))
+	nsCollector.minAge.now = func() time.Time { return now }
+	nsCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	podCollector := NewPodCollector(cl, queue, cache, "min-age-pod-collector", WithMinAge(30*time.Second))
+	podCollector.minAge.now = func() time.Time { return now }
+	podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	reconcile := func(step string, r interface {
+		Reconcile(context.Context, ctrl.Request) (ctrl.Result, error)
+	}, obj client.Object, wantRequeue time.Duration, want ...string) {
+		t.Helper()
+		res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if res.RequeueAfter != wantRequeue {
+			t.Errorf("%s: expected requeue after %s, got %s", step, wantRequeue, res.RequeueAfter)
+		}
+		if got := queue.pop(); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	// The young resources are deferred until they reach the minimum age, without being cached.
+	reconcile("young namespace", nsCollector, ns, 20*time.Second)
+	reconcile("young pod", podCollector, pod, 25*time.Second)
+	if cache.Has(cache.Key(resource.Namespace, client.ObjectKeyFromObject(ns))) {
+		t.Error("expected the young namespace not to be cached")
+	}
+
+	// The namespace persists past the minimum age and is sent, the pod is gone before and never generates an event.
+	now = now.Add(20 * time.Second)
+	reconcile("namespace old enough", nsCollector, ns, 0, events.Create)
+	if err := cl.Delete(ctx, pod); err != nil {
+		t.Fatalf("unable to delete pod: %v", err)
+	}
+	reconcile("pod gone", podCollector, pod, 0)
+
+	// Once sent, the resource is collected as any other one.
+	pod.ResourceVersion = ""
+	if err := cl.Create(ctx, pod); err != nil {
+		t.Fatalf("unable to create pod: %v", err)
+	}
+	ns.Labels = map[string]string{"updated": "true"}
+	if err := cl.Update(ctx, ns); err != nil {
+		t.Fatalf("unable to update namespace: %v", err)
+	}
+	reconcile("namespace updated", nsCollector, ns, 0, events.Update)
+}
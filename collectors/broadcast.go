@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// broadcastNamespaces holds the namespaces whose resources are sent to all the nodes of the cluster, whatever the
+// pods related to them, e.g. the shared configuration of a shared-services namespace.
+type broadcastNamespaces map[string]struct{}
+
+// newBroadcastNamespaces returns the given broadcast namespaces, nil if none.
+func newBroadcastNamespaces(namespaces []string) broadcastNamespaces {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	b := make(broadcastNamespaces, len(namespaces))
+	for _, namespace := range namespaces {
+		b[namespace] = struct{}{}
+	}
+	return b
+}
+
+// has returns true if the resources of the namespace are broadcast.
+func (b broadcastNamespaces) has(namespace string) bool {
+	_, ok := b[namespace]
+	return ok
+}
+
+// sorted returns the broadcast namespaces in alphabetical order.
+func (b broadcastNamespaces) sorted() []string {
+	namespaces := make([]string, 0, len(b))
+	for namespace := range b {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// nodes returns all the nodes of the cluster, where the resources of the broadcast namespaces are sent.
+func (b broadcastNamespaces) nodes(ctx context.Context, logger logr.Logger, cl client.Reader) ([]string, error) {
+	nodes, err := clusterNodes(ctx, cl)
+	if err != nil {
+		logger.Error(err, "unable to list nodes related to resource")
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// broadcaster triggers the reconcile of the resources of a collector living in the broadcast namespaces.
+type broadcaster func(ctx context.Context, trigger func(obj client.Object)) error
+
+// broadcaster returns the broadcaster of the resources listed by newList, nil if no namespace is broadcast. No pod
+// relates them to the node of a new subscriber, the dispatch triggers them through it. If newList is nil the
+// broadcast namespaces themselves are triggered.
+func (b broadcastNamespaces) broadcaster(cl client.Reader, newList func() client.ObjectList) broadcaster {
+	if len(b) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, trigger func(obj client.Object)) error {
+		for _, namespace := range b.sorted() {
+			if newList == nil {
+				trigger(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+				continue
+			}
+			list := newList()
+			if err := cl.List(ctx, list, client.InNamespace(namespace)); err != nil {
+				return err
+			}
+			if err := apimeta.EachListItem(list, func(obj runtime.Object) error {
+				meta, err := apimeta.Accessor(obj)
+				if err != nil {
+					return err
+				}
+				trigger(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+					Name:      meta.GetName(),
+					Namespace: meta.GetNamespace(),
+				}})
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// broadcastClient returns a client holding three nodes, a pod in the default namespace running on node-a and a
+// service in both the default and the shared namespaces.
+func broadcastClient() client.Client {
+	labels := map[string]string{"app": "web"}
+	return fake.NewClientBuilder().WithObjects(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: corev1.ServiceSpec{Selector: labels}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "shared"},
+			Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "config"}}},
+	).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+}
+
+func TestBroadcastNamespaces(t *testing.T) {
+	ctx := context.Background()
+	cl := broadcastClient()
+	all := []string{"node-a", "node-b", "node-c"}
+
+	nsCollector := NewObjectMetaCollector(cl, &recordingQueue{}, events.NewCache(),
+		NewPartialObjectMetadata(resource.Namespace, nil), "namespace-collector", WithBroadcastNamespaces("shared"))
+	svcCollector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+		WithBroadcastNamespaces("shared"))
+
+	// The resources of the broadcast namespaces are related to all the nodes, the other ones to the nodes of their pods.
+	for namespace, want := range map[string][]string{"shared": all, "default": {"node-a"}} {
+		_, nodes, err := nsCollector.getSubscribers(ctx, nsCollector.logger, &metav1.ObjectMeta{Name: namespace})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", namespace, err)
+		}
+		sort.Strings(nodes)
+		if !reflect.DeepEqual(nodes, want) {
+			t.Errorf("expected the namespace %s to be related to nodes %v, got %v", namespace, want, nodes)
+		}
+	}
+	for _, key := range []client.ObjectKey{{Namespace: "shared", Name: "config"}, {Namespace: "default", Name: "web"}} {
+		svc := &corev1.Service{}
+		if err := cl.Get(ctx, key, svc); err != nil {
+			t.Fatal(err)
+		}
+		_, nodes, err := svcCollector.getSubscribers(ctx, svcCollector.logger, svc)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", key, err)
+		}
+		sort.Strings(nodes)
+		want := []string{"node-a"}
+		if key.Namespace == "shared" {
+			want = all
+		}
+		if !reflect.DeepEqual(nodes, want) {
+			t.Errorf("expected the service %s to be related to nodes %v, got %v", key, want, nodes)
+		}
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	ctx := context.Background()
+	cl := broadcastClient()
+	newServiceList := func() client.ObjectList { return &corev1.ServiceList{} }
+
+	if b := newBroadcastNamespaces(nil).broadcaster(cl, newServiceList); b != nil {
+		t.Error("expected no broadcaster without broadcast namespaces")
+	}
+	broadcast := func(b broadcaster) []string {
+		t.Helper()
+		var triggered []string
+		if err := b(ctx, func(obj client.Object) {
+			triggered = append(triggered, client.ObjectKeyFromObject(obj).String())
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return triggered
+	}
+	namespaces := newBroadcastNamespaces([]string{"shared", "empty"})
+	if got := broadcast(namespaces.broadcaster(cl, newServiceList)); !reflect.DeepEqual(got, []string{"shared/config"}) {
+		t.Errorf("expected the services of the broadcast namespaces to be triggered, got %v", got)
+	}
+	if got := broadcast(namespaces.broadcaster(cl, nil)); !reflect.DeepEqual(got, []string{"/empty", "/shared"}) {
+		t.Errorf("expected the broadcast namespaces to be triggered, got %v", got)
+	}
+
+	// The first subscriber of a node running no pod gets the resources of the broadcast namespaces.
+	ctx, cancel := context.WithCancel(ctx)
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 1)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Service, subChan, dispatcherChan, cl, subscriber.NewSubscribers(),
+			events.NewCache(), newReplays(), defaultMetrics.resyncs.WithLabelValues(resource.Service), nil, 0, 0, nil,
+			namespaces.broadcaster(cl, newServiceList))
+	}()
+	subChan <- subscriber.Message{NodeName: "node-c", UID: "subscriber", Reason: subscriber.Subscribed}
+	select {
+	case evt := <-dispatcherChan:
+		if key := client.ObjectKeyFromObject(evt.Object).String(); key != "shared/config" {
+			t.Errorf("expected the reconcile of the broadcast service, got %v", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the broadcast service to be dispatched")
+	}
+
+	cancel()
+	go func() {
+		for range dispatcherChan {
+		}
+	}()
+	subChan <- subscriber.Message{NodeName: "node-c", UID: "subscriber", Reason: subscriber.Unsubscribed}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(dispatcherChan)
+}
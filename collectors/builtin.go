@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strings"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// ownerKinds are the kinds of the collectors triggered by the pod collector each time a pod is created or deleted.
+var ownerKinds = []string{resource.Deployment, resource.ReplicaSet, resource.Namespace, resource.Daemonset,
+	resource.ReplicationController}
+
+func init() {
+	Register(Registration{
+		Name: "pod-collector",
+		GVK:  corev1.SchemeGroupVersion.WithKind(resource.Pod),
+		New:  newPodCollector,
+	})
+	Register(Registration{
+		Name:         "service-collector",
+		GVK:          corev1.SchemeGroupVersion.WithKind(resource.Service),
+		Dependencies: []string{resource.Pod},
+		New:          newServiceCollector,
+	})
+	Register(ownedRegistration(corev1.SchemeGroupVersion.WithKind(resource.Namespace), nil))
+	// The pods of a deployment are named after the deployment, followed by the hash of the pod template of the
+	// replicaset.
+	Register(ownedRegistration(appsv1.SchemeGroupVersion.WithKind(resource.Deployment), podsByPrefix("")))
+	for _, gvk := range []schema.GroupVersionKind{
+		appsv1.SchemeGroupVersion.WithKind(resource.ReplicaSet),
+		appsv1.SchemeGroupVersion.WithKind(resource.Daemonset),
+		corev1.SchemeGroupVersion.WithKind(resource.ReplicationController),
+	} {
+		Register(ownedRegistration(gvk, podsByPrefix("-")))
+	}
+}
+
+// collectorName returns the name of the collector for the given kind.
+func collectorName(kind string) string {
+	return strings.ToLower(kind) + "-collector"
+}
+
+// newPodCollector builds the pod collector, triggering the collectors of the owners of the pods.
+func newPodCollector(s *Setup) (Collector, error) {
+	owners := make(map[string]chan<- event.GenericEvent)
+	for _, kind := range ownerKinds {
+		if trigger := s.Triggers.Trigger(kind); trigger != nil {
+			owners[kind] = trigger
+		}
+	}
+	return NewPodCollector(s.Manager.GetClient(), s.Queue, s.Cache, s.Name,
+		append(s.Options, WithOwnerSources(owners), WithExternalSource(s.Triggers.Source(resource.Pod)))...), nil
+}
+
+// newServiceCollector builds the service collector, and sets up the dispatchers triggering both the pod and service
+// collectors when the endpoints change. The dispatchers register their metrics as the collector does.
+func newServiceCollector(s *Setup) (Collector, error) {
+	var opts collectorOptions
+	for _, o := range s.Options {
+		o(&opts)
+	}
+	if err := (&EndpointsDispatcher{
+		Client:                 s.Manager.GetClient(),
+		Name:                   "endpoint-dispatcher",
+		ServiceCollectorSource: s.Triggers.Trigger(resource.Service),
+		PodCollectorSource:     s.Triggers.Trigger(resource.Pod),
+		Pods:                   make(map[string]map[string]struct{}),
+		MetricsRegisterer:      opts.metricsRegisterer,
+		MetricsPrefix:          opts.metricsPrefix,
+	}).SetupWithManager(s.Manager); err != nil {
+		return nil, err
+	}
+	if err := (&EndpointslicesDispatcher{
+		Client:                 s.Manager.GetClient(),
+		Name:                   "endpointslices-dispatcher",
+		ServiceCollectorSource: s.Triggers.Trigger(resource.Service),
+		PodCollectorSource:     s.Triggers.Trigger(resource.Pod),
+		Pods:                   make(map[string]map[string]struct{}),
+		ServicesName:           make(map[string]string),
+		MetricsRegisterer:      opts.metricsRegisterer,
+		MetricsPrefix:          opts.metricsPrefix,
+	}).SetupWithManager(s.Manager); err != nil {
+		return nil, err
+	}
+	return NewServiceCollector(s.Manager.GetClient(), s.Queue, s.Cache, s.Name,
+		append(s.Options, WithExternalSource(s.Triggers.Source(resource.Service)))...), nil
+}
+
+// ownedRegistration returns the registration of the metadata collector for the given kind, triggered by the pod
+// collector. If not nil, the given option relates the pods to the resources.
+func ownedRegistration(gvk schema.GroupVersionKind, podMatching CollectorOption) Registration {
+	reg := Registration{
+		Name:         collectorName(gvk.Kind),
+		GVK:          gvk,
+		Dependencies: []string{resource.Pod},
+	}
+	if podMatching != nil {
+		reg.Indexers = []Indexer{PodByPrefixNameIndexer}
+	}
+	reg.New = func(s *Setup) (Collector, error) {
+		opts := append(s.Options, WithExternalSource(s.Triggers.Source(gvk.Kind)))
+		if podMatching != nil {
+			opts = append(opts, podMatching)
+		}
+		obj, err := PartialObjectMetadataForGVK(gvk, nil)
+		if err != nil {
+			return nil, err
+		}
+		return NewObjectMetaCollector(s.Manager.GetClient(), s.Queue, s.Cache, obj, s.Name, opts...), nil
+	}
+	return reg
+}
+
+// MetadataRegistration returns the registration of a collector for the metadata of the resources of the given
+// kind, e.g. an extra kind of the configuration. The collector relates the resources to the nodes running pods in
+// their namespace. It is not triggered by the pods, the periodic resync keeps its subscribers up to date.
+func MetadataRegistration(gvk schema.GroupVersionKind) Registration {
+	return Registration{
+		Name: collectorName(gvk.Kind),
+		GVK:  gvk,
+		New: func(s *Setup) (Collector, error) {
+			obj, err := PartialObjectMetadataForGVK(gvk, nil)
+			if err != nil {
+				return nil, err
+			}
+			return NewObjectMetaCollector(s.Manager.GetClient(), s.Queue, s.Cache, obj, s.Name, s.Options...), nil
+		},
+	}
+}
+
+// podsByPrefix relates the pods to the resources by the prefix of their generated name, the name of the resource
+// followed by the given suffix.
+func podsByPrefix(suffix string) CollectorOption {
+	return WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
+		return &client.MatchingFields{"metadata.generateName": meta.Name + suffix}
+	})
+}
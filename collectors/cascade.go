@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// NamespaceCascade sweeps the caches of the collectors when a namespace is deleted. The api-server deletes the
+// resources of a namespace before the namespace itself, but the collectors reconcile the deletions in any order: the
+// namespace collector sends the Delete events of the resources of the namespace still cached by the other collectors
+// before its own, so that the consumers keying the resources under their namespace never see them outlive it. The
+// subscribers that negotiated the bulk deletes receive a single DeleteNamespace event in place of those Delete events.
+//
+// The collectors enter the cascade while reconciling a resource of a namespace: the sweep of the namespace waits for
+// them, and they skip its resources afterward. Otherwise a collector could cache again a resource swept in the
+// meantime, and send it after the DeleteNamespace event.
+type NamespaceCascade struct {
+	// sweep is held for writing by the sweeps, and for reading by the collectors reconciling a namespaced resource.
+	sweep  sync.RWMutex
+	mutex  sync.Mutex
+	caches []*events.Cache
+	// deleted holds the swept namespaces, whose resources are skipped by the collectors for the ttl.
+	deleted map[string]deletedNamespace
+	ttl     time.Duration
+}
+
+// deletedNamespace is a namespace swept by the cascade.
+type deletedNamespace struct {
+	uid       string
+	deletedAt time.Time
+}
+
+const (
+	// deletedNamespaceTTL is the time during which the resources of a deleted namespace are skipped. The api-server
+	// deletes the namespace once its resources are gone, the collectors only lag behind.
+	deletedNamespaceTTL = time.Minute
+	// deletedNamespaceRequeue is the delay after which the skipped resources still existing are reconciled again, e.g.
+	// when the namespace has been created again.
+	deletedNamespaceRequeue = 5 * time.Second
+)
+
+// NewNamespaceCascade returns a cascade sweeping no cache.
+func NewNamespaceCascade() *NamespaceCascade {
+	return &NamespaceCascade{ttl: deletedNamespaceTTL}
+}
+
+// Register adds the cache of a collector to the caches swept when a namespace is deleted. Its keys must be built by
+// events.KindKey for the Delete events to be sent.
+func (c *NamespaceCascade) Register(cache *events.Cache) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.caches = append(c.caches, cache)
+}
+
+// enter is called by the collectors before changing their cache for a resource of the given namespace. It returns
+// false if the namespace has been deleted: the resource must be skipped, the sweep sent its Delete event. Otherwise
+// the sweeps wait for the returned function to be called, once the events of the resource have been pushed.
+func (c *NamespaceCascade) enter(namespace string) (func(), bool) {
+	if c == nil || namespace == "" {
+		return func() {}, true
+	}
+	c.sweep.RLock()
+	c.mutex.Lock()
+	ns, deleted := c.deleted[namespace]
+	c.mutex.Unlock()
+	if deleted && time.Since(ns.deletedAt) < c.ttl {
+		c.sweep.RUnlock()
+		return nil, false
+	}
+	return c.sweep.RUnlock, true
+}
+
+// created is called by the namespace collector for the existing namespaces: the resources of a namespace created
+// again with the same name, hence another UID, are no longer skipped.
+func (c *NamespaceCascade) created(namespace, uid string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if ns, ok := c.deleted[namespace]; ok && ns.uid != uid {
+		delete(c.deleted, namespace)
+	}
+}
+
+// setDeleted marks the namespace with the given UID as deleted, or unmarks it, and forgets the namespaces deleted for
+// longer than the ttl.
+func (c *NamespaceCascade) setDeleted(namespace, uid string, deleted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for name, ns := range c.deleted {
+		if time.Since(ns.deletedAt) >= c.ttl {
+			delete(c.deleted, name)
+		}
+	}
+	if !deleted {
+		delete(c.deleted, namespace)
+		return
+	}
+	if c.deleted == nil {
+		c.deleted = make(map[string]deletedNamespace)
+	}
+	c.deleted[namespace] = deletedNamespace{uid: uid, deletedAt: time.Now()}
+}
+
+// skipDeletedNamespace returns the result of the reconcile of a resource of a deleted namespace. The sweep sent the
+// Delete event of the resource, the ones still existing are reconciled again in case the namespace has been created
+// again.
+func skipDeletedNamespace(logger logr.Logger, notFound bool) ctrl.Result {
+	logger.V(3).Info("namespace deleted, skipping the reconcile")
+	if notFound {
+		return ctrl.Result{}
+	}
+	return ctrl.Result{RequeueAfter: deletedNamespaceRequeue}
+}
+
+// deleteNamespace pushes to the queue a Delete event for each cached resource of the namespace, for the subscribers
+// that received it, and removes the resources from the caches. The caches are swept in registration order, each one
+// at once, and the resources of a cache in the order of their keys. The Delete events are followed by the
+// DeleteNamespace event of the namespace with the given UID, for all their subscribers: the broker sends each
+// subscriber either the former or the latter. It returns the number of deleted resources. If an event cannot be
+// pushed, the resources of the cache not sent yet are restored and the error returned.
+func (c *NamespaceCascade) deleteNamespace(namespace, uid string, queue broker.Queue, clusterName, origin string) (int, error) {
+	if c == nil || broker.IsClosed(queue) {
+		return 0, nil
+	}
+	c.sweep.Lock()
+	defer c.sweep.Unlock()
+	c.setDeleted(namespace, uid, true)
+	c.mutex.Lock()
+	caches := append([]*events.Cache(nil), c.caches...)
+	c.mutex.Unlock()
+
+	// The keys built by events.NameKey have no kind to send the events with. The cluster scoped resources, e.g. the
+	// namespaces, have an empty namespace and never match.
+	match := func(key string) bool {
+		return events.KindFromKey(key) != "" && events.NameFromKey(key).Namespace == namespace
+	}
+
+	var deletes int
+	subs := make(fields.Subscribers)
+	for _, cache := range caches {
+		deleted := cache.DeleteIf(match)
+		for i, snapshot := range deleted {
+			entry := snapshot.Entry()
+			if len(entry.Subs) == 0 {
+				continue
+			}
+			if err := pushCascadedDelete(queue, snapshot, clusterName, origin); err != nil {
+				for _, s := range deleted[i:] {
+					cache.Restore(s)
+				}
+				c.setDeleted(namespace, uid, false)
+				return deletes, err
+			}
+			deletes++
+			for sub := range entry.Subs {
+				subs.Add(sub)
+			}
+		}
+	}
+	if len(subs) > 0 {
+		if err := queue.Push(events.NewDeleteNamespace(namespace, uid, subs, clusterName)); err != nil {
+			c.setDeleted(namespace, uid, false)
+			return deletes, err
+		}
+	}
+	return deletes, nil
+}
+
+// pushCascadedDelete pushes the Delete events of the swept resource for the subscribers that received it.
+func pushCascadedDelete(queue broker.Queue, snapshot events.CacheSnapshot, clusterName, origin string) error {
+	entry := snapshot.Entry()
+	res := events.NewResource(events.KindFromKey(snapshot.Key()), string(entry.UID))
+	res.SetSubscribers(entry.Subs)
+	res.GenerateSubscribers(nil)
+	// The deletion is a change of the resource.
+	res.SetSequence(entry.Sequence + 1)
+	res.SetCluster(clusterName)
+	res.SetOrigin(origin, events.NameFromKey(snapshot.Key()))
+	res.SetCascaded(true)
+	for _, evt := range res.ToEvents() {
+		if evt == nil {
+			continue
+		}
+		if err := queue.Push(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNamespaceCascade(t *testing.T) {
+	ctx := context.Background()
+	doomed := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "doomed", UID: "ns-uid"}}
+	// The namespace is sent to the nodes of its pods.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "doomed", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(doomed, pod).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	subs := fields.Subscribers{"subscriber": {}}
+	podCache := events.NewCache()
+	deploymentCache := events.NewCache()
+	add := func(cache *events.Cache, kind, namespace, name string, subs fields.Subscribers) string {
+		key := cache.Key(kind, types.NamespacedName{Namespace: namespace, Name: name})
+		cache.Add(key, &events.CacheEntry{UID: types.UID(name + "-uid"), Subs: subs})
+		return key
+	}
+	doomedPod := add(podCache, resource.Pod, "doomed", "pod", subs)
+	unsentPod := add(podCache, resource.Pod, "doomed", "unsent", nil)
+	otherPod := add(podCache, resource.Pod, "other", "pod", subs)
+	doomedDeployment := add(deploymentCache, resource.Deployment, "doomed", "dpl", subs)
+
+	cascade := NewNamespaceCascade()
+	cascade.Register(podCache)
+	cascade.Register(deploymentCache)
+
+	queue := &recordingQueue{}
+	nsCache := events.NewCache()
+	cascade.Register(nsCache)
+	nsCollector := NewObjectMetaCollector(cl, queue, nsCache, NewPartialObjectMetadata(resource.Namespace, nil),
+		"namespace-collector", WithNamespaceCascade(cascade), WithClusterName("prod"))
+	nsCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "doomed"}}
+	if _, err := nsCollector.Reconcile(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := queue.pop(); !reflect.DeepEqual(got, []string{events.Create}) {
+		t.Fatalf("expected the namespace to be created, got %v", got)
+	}
+
+	// The resources of the deleted namespace are deleted before it, the ones never sent are only dropped. Their Delete
+	// events are followed by the DeleteNamespace event, sent by the broker in their place to the subscribers that
+	// negotiated it.
+	if err := cl.Delete(ctx, doomed); err != nil {
+		t.Fatalf("unable to delete namespace: %v", err)
+	}
+	if _, err := nsCollector.Reconcile(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, evt := range queue.evts {
+		msg := evt.GRPCMessage()
+		if msg.GetCluster() != "prod" || !evt.Subscribers().Has("subscriber") {
+			t.Errorf("unexpected event %s", evt.String())
+		}
+		// Only the Delete events of the resources of the namespace are replaced.
+		want := evt.Type() == events.Delete && msg.GetKind() != resource.Namespace
+		if cascaded := evt.(*events.Event).Cascaded(); cascaded != want {
+			t.Errorf("unexpected cascaded flag %t for %s", cascaded, evt.String())
+		}
+		// The DeleteNamespace event carries the namespace in place of the kind.
+		got = append(got, evt.Type()+" "+msg.GetKind()+msg.GetNamespace()+"/"+msg.GetUid())
+	}
+	want := []string{
+		events.Delete + " " + resource.Pod + "/pod-uid",
+		events.Delete + " " + resource.Deployment + "/dpl-uid",
+		events.DeleteNamespace + " doomed/ns-uid",
+		events.Delete + " " + resource.Namespace + "/ns-uid",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the events %v, got %v", want, got)
+	}
+
+	for _, key := range []string{doomedPod, unsentPod} {
+		if podCache.Has(key) {
+			t.Errorf("expected %s to be removed from the cache", key)
+		}
+	}
+	if deploymentCache.Has(doomedDeployment) {
+		t.Errorf("expected %s to be removed from the cache", doomedDeployment)
+	}
+	if !podCache.Has(otherPod) {
+		t.Errorf("expected %s to be left in the cache", otherPod)
+	}
+}
+
+func TestNamespaceCascadeWaitsForCollectors(t *testing.T) {
+	cascade := NewNamespaceCascade()
+	cache := events.NewCache()
+	cascade.Register(cache)
+	key := cache.Key(resource.Pod, types.NamespacedName{Namespace: "doomed", Name: "pod"})
+
+	// A collector reconciling a resource of the namespace holds the sweep until its events are pushed.
+	leave, ok := cascade.enter("doomed")
+	if !ok {
+		t.Fatal("expected the collector to enter the cascade")
+	}
+	swept := make(chan error, 1)
+	go func() {
+		_, err := cascade.deleteNamespace("doomed", "ns-uid", &recordingQueue{}, "", "namespace-collector")
+		swept <- err
+	}()
+	select {
+	case <-swept:
+		t.Fatal("expected the sweep to wait for the collector")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cache.Add(key, &events.CacheEntry{UID: "pod-uid", Subs: fields.Subscribers{"subscriber": {}}})
+	leave()
+	select {
+	case err := <-swept:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the sweep to complete once the collector left")
+	}
+	if cache.Has(key) {
+		t.Errorf("expected the resource cached before the sweep to be deleted")
+	}
+
+	// The resources of the namespace are skipped once it has been swept, for the ttl.
+	if _, ok := cascade.enter("doomed"); ok {
+		t.Error("expected the resources of the deleted namespace to be skipped")
+	}
+	leave, ok = cascade.enter("other")
+	if !ok {
+		t.Fatal("expected the resources of the other namespaces to be reconciled")
+	}
+	leave()
+	cascade.ttl = 0
+	if leave, ok = cascade.enter("doomed"); !ok {
+		t.Fatal("expected the resources of the namespace to be reconciled past the ttl")
+	}
+	leave()
+}
+
+func TestNamespaceCascadeSkipsDeletedNamespace(t *testing.T) {
+	ctx := context.Background()
+	// The namespace is created again once swept.
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "doomed", UID: "new-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "doomed", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(ns, pod).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	cascade := NewNamespaceCascade()
+	podCache := events.NewCache()
+	cascade.Register(podCache)
+	queue := &recordingQueue{}
+	podCollector := NewPodCollector(cl, queue, podCache, "pod-collector", WithNamespaceCascade(cascade))
+	podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	nsCollector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Namespace, nil),
+		"namespace-collector", WithNamespaceCascade(cascade))
+
+	if _, err := cascade.deleteNamespace("doomed", "ns-uid", queue, "", "namespace-collector"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	run := func(r reconcile.Reconciler, name types.NamespacedName) ctrl.Result {
+		res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: name})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return res
+	}
+
+	// The existing resources are reconciled again later, the deleted ones are dropped.
+	podName := types.NamespacedName{Namespace: "doomed", Name: "pod"}
+	if res := run(podCollector, podName); res.RequeueAfter != deletedNamespaceRequeue {
+		t.Errorf("expected the pod to be requeued, got %+v", res)
+	}
+	if res := run(podCollector, types.NamespacedName{Namespace: "doomed", Name: "gone"}); !res.IsZero() {
+		t.Errorf("expected the deleted pod to be dropped, got %+v", res)
+	}
+	if got := queue.pop(); len(got) != 0 || len(podCache.Keys()) != 0 {
+		t.Fatalf("expected the pods of the deleted namespace to be skipped, got %v", got)
+	}
+
+	// Once the namespace collector sees the namespace created again, its resources are reconciled.
+	run(nsCollector, types.NamespacedName{Name: "doomed"})
+	queue.pop()
+	run(podCollector, podName)
+	if got := queue.pop(); !reflect.DeepEqual(got, []string{events.Create}) {
+		t.Errorf("expected the pod to be created, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"github.com/mitchellh/hashstructure/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// relevantFields holds the fields of an object that the collectors send to the subscribers or use to compute the
+// nodes of the object. The metadata fields are the ones kept by the cache transformers.
+type relevantFields struct {
+	Name            string
+	GenerateName    string
+	Namespace       string
+	UID             types.UID
+	Labels          map[string]string
+	Annotations     map[string]string
+	OwnerReferences []metav1.OwnerReference
+	// Spec holds the fields specific to the kind of the object.
+	Spec interface{}
+	// Status holds the fields of the status projected in the events, if any.
+	Status map[string]interface{}
+}
+
+// podFields holds the fields of a pod, other than the metadata, relevant for the collectors.
+type podFields struct {
+	NodeName string
+	PodIP    string
+	Phase    corev1.PodPhase
+}
+
+// serviceFields holds the fields of a service, other than the metadata, relevant for the collectors.
+type serviceFields struct {
+	Selector     map[string]string
+	Type         corev1.ServiceType
+	ExternalName string
+	Headless     bool
+}
+
+// changeHash returns the hash of the fields of the object relevant for the collectors, including the given fields of
+// its status.
+func changeHash(obj client.Object, statusFields []string) (uint64, error) {
+	fields := relevantFields{
+		Name:            obj.GetName(),
+		GenerateName:    obj.GetGenerateName(),
+		Namespace:       obj.GetNamespace(),
+		UID:             obj.GetUID(),
+		Labels:          obj.GetLabels(),
+		Annotations:     obj.GetAnnotations(),
+		OwnerReferences: obj.GetOwnerReferences(),
+	}
+
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		fields.Spec = podFields{NodeName: o.Spec.NodeName, PodIP: o.Status.PodIP, Phase: o.Status.Phase}
+	case *corev1.Service:
+		fields.Spec = serviceFields{Selector: o.Spec.Selector, Type: o.Spec.Type, ExternalName: o.Spec.ExternalName,
+			Headless: isHeadless(o)}
+	}
+
+	if len(statusFields) > 0 {
+		objUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return 0, err
+		}
+		if fields.Status, err = projectStatus(objUn, statusFields); err != nil {
+			return 0, err
+		}
+	}
+
+	return hashstructure.Hash(fields, hashstructure.FormatV2, nil)
+}
+
+// changedFilter returns a predicate that filters out the updates changing none of the fields relevant for the
+// collectors, e.g. the status-only updates of the deployments. Such updates would be reconciled only to find out
+// that the hash of the resource did not change. The updates of the given status fields, projected in the events, are
+// let through. If the hashes can not be computed the update is let through.
+func changedFilter(statusFields ...string) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldHash, err := changeHash(e.ObjectOld, statusFields)
+			if err != nil {
+				return true
+			}
+			newHash, err := changeHash(e.ObjectNew, statusFields)
+			if err != nil {
+				return true
+			}
+			return oldHash != newHash
+		},
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestChangedFilter(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"},
+			ResourceVersion: "1", Generation: 1},
+		Spec:   corev1.PodSpec{NodeName: "node"},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1", Phase: corev1.PodRunning},
+	}
+	deployment := NewPartialObjectMetadata(resource.Deployment, &types.NamespacedName{Namespace: "default", Name: "dpl"})
+	deployment.Labels = map[string]string{"app": "test"}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}, ClusterIP: "10.96.0.1"},
+	}
+
+	tests := []struct {
+		name   string
+		old    client.Object
+		update func(obj client.Object)
+		want   bool
+	}{
+		{name: "unchanged", old: pod, update: func(client.Object) {}},
+		{
+			name: "resource version bumped",
+			old:  deployment,
+			update: func(obj client.Object) {
+				obj.SetResourceVersion("2")
+				obj.SetGeneration(2)
+			},
+		},
+		{
+			name: "container statuses changed",
+			old:  pod,
+			update: func(obj client.Object) {
+				obj.(*corev1.Pod).Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "c"}}
+			},
+		},
+		{
+			name:   "service spec changed",
+			old:    svc,
+			update: func(obj client.Object) { obj.(*corev1.Service).Spec.ClusterIP = "10.96.0.2" },
+		},
+		{
+			name:   "service type changed",
+			old:    svc,
+			update: func(obj client.Object) { obj.(*corev1.Service).Spec.Type = corev1.ServiceTypeExternalName },
+			want:   true,
+		},
+		{
+			name:   "external name changed",
+			old:    svc,
+			update: func(obj client.Object) { obj.(*corev1.Service).Spec.ExternalName = "example.com" },
+			want:   true,
+		},
+		{name: "labels changed", old: pod, update: func(obj client.Object) { obj.SetLabels(map[string]string{"app": "other"}) }, want: true},
+		{
+			name:   "deployment labels changed",
+			old:    deployment,
+			update: func(obj client.Object) { obj.SetLabels(map[string]string{"app": "test", "tier": "web"}) },
+			want:   true,
+		},
+		{
+			name:   "ignore annotation added",
+			old:    deployment,
+			update: func(obj client.Object) { obj.SetAnnotations(map[string]string{consts.IgnoreAnnotation: "true"}) },
+			want:   true,
+		},
+		{
+			name: "owner changed",
+			old:  pod,
+			update: func(obj client.Object) {
+				obj.SetOwnerReferences([]metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs"}})
+			},
+			want: true,
+		},
+		{name: "node assigned", old: pod, update: func(obj client.Object) { obj.(*corev1.Pod).Spec.NodeName = "other" }, want: true},
+		{name: "ip changed", old: pod, update: func(obj client.Object) { obj.(*corev1.Pod).Status.PodIP = "10.0.0.2" }, want: true},
+		{
+			name:   "phase changed",
+			old:    pod,
+			update: func(obj client.Object) { obj.(*corev1.Pod).Status.Phase = corev1.PodSucceeded },
+			want:   true,
+		},
+		{
+			name:   "selector changed",
+			old:    svc,
+			update: func(obj client.Object) { obj.(*corev1.Service).Spec.Selector = map[string]string{"app": "other"} },
+			want:   true,
+		},
+	}
+
+	p := changedFilter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := tt.old.DeepCopyObject().(client.Object)
+			tt.update(updated)
+			if got := p.Update(event.UpdateEvent{ObjectOld: tt.old, ObjectNew: updated}); got != tt.want {
+				t.Errorf("expected the update to pass the filter %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
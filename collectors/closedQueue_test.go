@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileClosedQueue(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid",
+			Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, svc, pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	// The blocking channel is closed once its consumer stops.
+	closed := broker.NewBlockingChannel(1)
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	closed.Pop(stopped)
+
+	for name, queue := range map[string]broker.Queue{"nil queue": nil, "closed queue": closed} {
+		cache := events.NewCache()
+		dplCollector := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Deployment, nil),
+			"deployment-collector")
+		svcCollector := NewServiceCollector(cl, queue, cache, "service-collector")
+		podCollector := NewPodCollector(cl, queue, cache, "pod-collector")
+		dplCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+		svcCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+		podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+		for _, tt := range []struct {
+			r   reconcile.Reconciler
+			obj client.Object
+		}{{dplCollector, dpl}, {svcCollector, svc}, {podCollector, pod}} {
+			if _, err := tt.r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(tt.obj)}); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+		}
+		// The resources whose events could not be pushed are not cached as sent.
+		if keys := cache.Keys(); len(keys) != 0 {
+			t.Errorf("%s: expected the cache to be left untouched, got %v", name, keys)
+		}
+
+		// Neither are the cached resources refreshed.
+		cache.Add("default/dpl", &events.CacheEntry{UID: "dpl-uid", Subs: fields.Subscribers{"subscriber": {}}})
+		if refreshed := newRefresher(resource.Deployment, "", time.Minute, cache, queue, nil).refresh(); refreshed != 0 {
+			t.Errorf("%s: expected no refresh, got %d", name, refreshed)
+		}
+	}
+}
+
+// closingQueue is open when the collectors check it, and closed by the time they push their events.
+type closingQueue struct{}
+
+func (closingQueue) Push(_ events.Interface) error {
+	return broker.ErrQueueClosed
+}
+
+func (closingQueue) Pop(_ context.Context) events.Interface {
+	return nil
+}
+
+func TestReconcileQueueClosedOnPush(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid",
+			Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{NodeName: "node"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(ns, dpl, svc, pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	queue := closingQueue{}
+	cache := events.NewCache()
+	dplCollector := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector")
+	svcCollector := NewServiceCollector(cl, queue, cache, "service-collector")
+	podCollector := NewPodCollector(cl, queue, cache, "pod-collector")
+	dplCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	svcCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	for _, tt := range []struct {
+		r    reconcile.Reconciler
+		kind string
+		obj  client.Object
+	}{{dplCollector, resource.Deployment, dpl}, {svcCollector, resource.Service, svc}, {podCollector, resource.Pod, pod}} {
+		run := func(name types.NamespacedName) {
+			if _, err := tt.r.Reconcile(ctx, ctrl.Request{NamespacedName: name}); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.kind, err)
+			}
+		}
+		name := client.ObjectKeyFromObject(tt.obj)
+		key := cache.Key(tt.kind, name)
+
+		// A resource never sent is not cached.
+		run(name)
+		if cache.Has(key) {
+			t.Errorf("%s: expected the resource not to be cached", tt.kind)
+		}
+
+		// The changes of a resource already sent are rolled back, and so is the deletion of a deleted one.
+		gone := types.NamespacedName{Namespace: "default", Name: "gone"}
+		for _, name := range []types.NamespacedName{name, gone} {
+			key := cache.Key(tt.kind, name)
+			cache.Add(key, &events.CacheEntry{UID: "uid", Subs: fields.Subscribers{"subscriber": {}}, Sequence: 1})
+			cache.AddNodes(key, "node")
+			run(name)
+			entry, ok := cache.Get(key)
+			if !ok || entry.Hash != 0 || entry.Sequence != 1 || len(entry.Subs) != 1 {
+				t.Errorf("%s: expected %s to be left untouched, got %+v", tt.kind, key, entry)
+			}
+			if nodes, _ := cache.NodesOf(key); !reflect.DeepEqual(nodes, []string{"node"}) {
+				t.Errorf("%s: expected %s to stay related to the node, got %v", tt.kind, key, nodes)
+			}
+			cache.Delete(key)
+		}
+	}
+
+	// Neither are the resources of a deleted namespace deleted from the caches.
+	podCache := events.NewCache()
+	nsCache := events.NewCache()
+	cascade := NewNamespaceCascade()
+	cascade.Register(podCache)
+	cascade.Register(nsCache)
+	nsCollector := NewObjectMetaCollector(cl, queue, nsCache, NewPartialObjectMetadata(resource.Namespace, nil),
+		"namespace-collector", WithNamespaceCascade(cascade))
+	podKey := podCache.Key(resource.Pod, types.NamespacedName{Namespace: "doomed", Name: "pod"})
+	nsKey := nsCache.Key(resource.Namespace, types.NamespacedName{Name: "doomed"})
+	podCache.Add(podKey, &events.CacheEntry{UID: "pod-uid", Subs: fields.Subscribers{"subscriber": {}}})
+	nsCache.Add(nsKey, &events.CacheEntry{UID: "ns-uid", Subs: fields.Subscribers{"subscriber": {}}})
+	if _, err := nsCollector.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "doomed"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !podCache.Has(podKey) || !nsCache.Has(nsKey) {
+		t.Errorf("expected the namespace and its resources to be left in the caches")
+	}
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// coalescingHandler wraps an event handler so that the reconciles for create, update and generic events are
+// enqueued after the given window. The workqueue de-duplicates the requests for the same key, hence all the
+// changes to a resource received within the window are coalesced into a single reconcile, which reads the latest
+// state of the resource and generates a single event. The reconciles for delete events are enqueued right away,
+// so that the Delete events are never delayed nor coalesced away. A zero window disables the coalescing.
+func coalescingHandler(h handler.EventHandler, window time.Duration) handler.EventHandler {
+	if window <= 0 {
+		return h
+	}
+
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			h.Create(ctx, e, &delayingQueue{RateLimitingInterface: q, delay: window})
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			h.Update(ctx, e, &delayingQueue{RateLimitingInterface: q, delay: window})
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			h.Delete(ctx, e, q)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			h.Generic(ctx, e, &delayingQueue{RateLimitingInterface: q, delay: window})
+		},
+	}
+}
+
+// delayingQueue adds the items to the underlying queue after a delay. The delaying queue keeps the earliest ready
+// time for the items already waiting, so the reconcile happens at most one window after the first change.
+type delayingQueue struct {
+	workqueue.RateLimitingInterface
+	delay time.Duration
+}
+
+// Add adds the item to the underlying queue after the delay.
+func (q *delayingQueue) Add(item interface{}) {
+	q.AddAfter(item, q.delay)
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestCoalescingHandlerDisabled(t *testing.T) {
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	h := coalescingHandler(&handler.EnqueueRequestForObject{}, 0)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+
+	h.Update(context.Background(), event.UpdateEvent{ObjectOld: pod, ObjectNew: pod}, q)
+	if q.Len() != 1 {
+		t.Fatalf("expected the request to be enqueued right away, got %d requests", q.Len())
+	}
+}
+
+func TestCoalescingHandler(t *testing.T) {
+	const window = 200 * time.Millisecond
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	h := coalescingHandler(&handler.EnqueueRequestForObject{}, window)
+	ctx := context.Background()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+
+	// Rapid changes to the same pod are delayed and coalesced into a single request.
+	h.Create(ctx, event.CreateEvent{Object: pod}, q)
+	h.Update(ctx, event.UpdateEvent{ObjectOld: pod, ObjectNew: pod}, q)
+	h.Generic(ctx, event.GenericEvent{Object: pod}, q)
+	if q.Len() != 0 {
+		t.Fatalf("expected the requests to be delayed, got %d requests", q.Len())
+	}
+
+	// Deletes are never delayed.
+	h.Delete(ctx, event.DeleteEvent{Object: other}, q)
+	if q.Len() != 1 {
+		t.Fatalf("expected the delete request to be enqueued right away, got %d requests", q.Len())
+	}
+	item, _ := q.Get()
+	q.Done(item)
+
+	deadline := time.Now().Add(10 * window)
+	for q.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(window / 10)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("expected the changes to be coalesced in a single request, got %d requests", q.Len())
+	}
+}
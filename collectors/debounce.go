@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// debouncer collapses the reconcile requests for the same key arriving within a window. It is meant for the
+// externally triggered reconciles: a rolling restart of the pods of a namespace triggers the reconcile of the same
+// namespace once per pod, and each reconcile would list the pods and compute the same nodes. The first request for
+// a key is enqueued after the window, and the requests arriving in the meantime are collapsed into it. Since the
+// reconcile reads the latest state when the window expires, no change is lost.
+type debouncer struct {
+	mutex  sync.Mutex
+	window time.Duration
+	// pending holds the time at which the request for a key is released to the workqueue.
+	pending map[interface{}]time.Time
+	// collapsed counts the requests collapsed into a pending one.
+	collapsed prometheus.Counter
+	now       func() time.Time
+}
+
+// debouncingHandler wraps an event handler so that the reconcile requests it enqueues for the same key within the
+// window are collapsed into a single one. The collapsed requests are counted by the given counter. A zero window
+// disables the debouncing.
+func debouncingHandler(h handler.EventHandler, window time.Duration, collapsed prometheus.Counter) handler.EventHandler {
+	if window <= 0 {
+		return h
+	}
+
+	d := &debouncer{
+		window:    window,
+		pending:   make(map[interface{}]time.Time),
+		collapsed: collapsed,
+		now:       time.Now,
+	}
+
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			h.Create(ctx, e, d.queue(q))
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			h.Update(ctx, e, d.queue(q))
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			h.Delete(ctx, e, d.queue(q))
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			h.Generic(ctx, e, d.queue(q))
+		},
+	}
+}
+
+// queue returns the debouncing view of the given queue.
+func (d *debouncer) queue(q workqueue.RateLimitingInterface) *debouncingQueue {
+	return &debouncingQueue{RateLimitingInterface: q, debouncer: d}
+}
+
+// admit returns the delay after which the item has to be added to the queue, and false if the item is collapsed
+// into a request already pending.
+func (d *debouncer) admit(item interface{}, delay time.Duration) (time.Duration, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := d.now()
+	if release, ok := d.pending[item]; ok && now.Before(release) {
+		d.collapsed.Inc()
+		return 0, false
+	}
+
+	// The released requests are forgotten, so that the map only holds the keys seen within the last window.
+	for key, release := range d.pending {
+		if !now.Before(release) {
+			delete(d.pending, key)
+		}
+	}
+
+	if delay < d.window {
+		delay = d.window
+	}
+	d.pending[item] = now.Add(delay)
+	return delay, true
+}
+
+// debouncingQueue adds the items to the underlying queue through the debouncer.
+type debouncingQueue struct {
+	workqueue.RateLimitingInterface
+	debouncer *debouncer
+}
+
+// Add adds the item to the underlying queue after the window, unless a request for the same item is pending.
+func (q *debouncingQueue) Add(item interface{}) {
+	q.AddAfter(item, 0)
+}
+
+// AddAfter adds the item to the underlying queue after the given delay, or the window if longer, unless a request
+// for the same item is pending.
+func (q *debouncingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if delay, ok := q.debouncer.admit(item, duration); ok {
+		q.RateLimitingInterface.AddAfter(item, delay)
+	}
+}
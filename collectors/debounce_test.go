@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// delaysQueue records the delays of the items added to the queue.
+type delaysQueue struct {
+	workqueue.RateLimitingInterface
+	delays []time.Duration
+}
+
+func (q *delaysQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.delays = append(q.delays, duration)
+}
+
+func TestDebouncingHandler(t *testing.T) {
+	const window = time.Second
+	now := time.Now()
+	q := &delaysQueue{}
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "storm"}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	// The debouncer runs on a frozen clock.
+	collapsed := defaultMetrics.collapsedRequests.WithLabelValues("debounce-test", "pods")
+	d := &debouncer{window: window, pending: make(map[interface{}]time.Time), collapsed: collapsed,
+		now: func() time.Time { return now }}
+	h := handler.Funcs{GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+		(&handler.EnqueueRequestForObject{}).Generic(ctx, e, d.queue(q))
+	}}
+
+	// A storm of requests for the same namespace is collapsed into the first one, released after the window.
+	for i := 0; i < 2000; i++ {
+		h.Generic(ctx, event.GenericEvent{Object: ns}, q)
+	}
+	h.Generic(ctx, event.GenericEvent{Object: other}, q)
+	if len(q.delays) != 2 || q.delays[0] != window || q.delays[1] != window {
+		t.Fatalf("expected one request per namespace delayed by the window, got %v", q.delays)
+	}
+	if collapsed := testutil.ToFloat64(collapsed); collapsed != 1999 {
+		t.Fatalf("expected 1999 collapsed requests, got %v", collapsed)
+	}
+
+	// Once the window has expired a new request is enqueued, and the released keys are forgotten.
+	now = now.Add(window)
+	h.Generic(ctx, event.GenericEvent{Object: ns}, q)
+	if len(q.delays) != 3 {
+		t.Fatalf("expected a new request after the window, got %v", q.delays)
+	}
+	if len(d.pending) != 1 {
+		t.Fatalf("expected the released keys to be forgotten, got %d pending keys", len(d.pending))
+	}
+
+	// The longer delays of the wrapped handlers are kept.
+	now = now.Add(window)
+	h = handler.Funcs{GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+		coalescingHandler(&handler.EnqueueRequestForObject{}, 3*window).Generic(ctx, e, d.queue(q))
+	}}
+	h.Generic(ctx, event.GenericEvent{Object: ns}, q)
+	h.Generic(ctx, event.GenericEvent{Object: ns}, q)
+	if len(q.delays) != 4 || q.delays[3] != 3*window {
+		t.Fatalf("expected the coalescing delay to be kept, got %v", q.delays)
+	}
+}
+
+func TestDebouncingHandlerDisabled(t *testing.T) {
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	h := debouncingHandler(&handler.EnqueueRequestForObject{}, 0, nil)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "storm"}}
+
+	h.Generic(context.Background(), event.GenericEvent{Object: ns}, q)
+	h.Generic(context.Background(), event.GenericEvent{Object: ns}, q)
+	if q.Len() != 1 {
+		t.Fatalf("expected the request to be enqueued right away, got %d requests", q.Len())
+	}
+}
@@ -18,148 +18,323 @@ package collectors
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
+// shutdownTimeout is how long the collectors wait for the subscribers to leave when the manager stops.
+const shutdownTimeout = 10 * time.Second
+
+// dispatch listens for subscribers joining or leaving and triggers the reconcile of the resources related to the
+// subscriber's node, so that the existing metadata is sent to new subscribers. If resyncPeriod is greater than zero,
+// at each period it also triggers the reconcile of all the cached resources and of the resources related to the nodes
+// that have subscribers: the reconciler recomputes the subscribers from the live pods and emits the corrective events.
+// Each period is increased by a random jitter, up to the given factor of it, and each resync is counted by the given
+// counter. A resync is also run for each request received on resyncRequests, e.g. when the selectors change. If the
+// refresher is not nil, the cached resources are refreshed at each of its periods. If the broadcaster is not nil, the
+// resources of the broadcast namespaces are triggered along with the ones related to the node of each dispatch.
 func dispatch(ctx context.Context, logger logr.Logger, resourceKind string, subChan subscriber.SubsChan,
-	dispatcherChan chan<- event.GenericEvent, cl client.Client, subscribers *subscriber.Subscribers) error {
+	dispatcherChan chan<- event.GenericEvent, cl client.Client, subscribers *subscriber.Subscribers,
+	cache *events.Cache, replays *replays, resyncs prometheus.Counter, resyncRequests <-chan struct{},
+	resyncPeriod time.Duration, resyncJitter float64, refresh *refresher, broadcast broadcaster) error {
 	wg := sync.WaitGroup{}
 	podList := &corev1.PodList{}
-	serviceList := corev1.ServiceList{}
-	replicaSet := NewPartialObjectMetadata(resource.ReplicaSet, nil)
+	replicaSet, err := PartialObjectMetadataForGVK(appsv1.SchemeGroupVersion.WithKind(resource.ReplicaSet), nil)
+	if err != nil {
+		return err
+	}
+	// send triggers the reconcile of the given object, unless the collector is stopping.
+	send := func(obj client.Object) bool {
+		return trigger(ctx, dispatcherChan, obj)
+	}
+	// dispatchNode triggers the reconcile of the resources related to the pods running on the subscriber's node.
+	dispatchNode := func(ctx context.Context, sub subscriber.Message, trigger func(obj client.Object)) {
+		ctx, span := tracing.Start(ctx, "dispatch", resourceKind, "", tracing.NodeKey.String(sub.NodeName))
+		defer span.End()
+
+		// List all pods related to the given node.
+		// On error the list still holds the pods of the previous dispatch, they must not be dispatched.
+		if err := cl.List(ctx, podList, client.MatchingFields{
+			nodeNameIndex: sub.NodeName,
+		}); err != nil {
+			logger.Error(err, "unable to dispatch pod events", "subscriber", sub, "resourceKind", resourceKind)
+			span.RecordError(err)
+			return
+		}
+
+		for podIndex := range podList.Items {
+			switch resourceKind {
+			case resource.Pod:
+				trigger(&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      podList.Items[podIndex].Name,
+						Namespace: podList.Items[podIndex].Namespace,
+					},
+				})
+			case resource.Namespace:
+				trigger(&corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: podList.Items[podIndex].Namespace,
+					},
+				})
+			case resource.ReplicaSet:
+				owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
+				if owner != nil && owner.Kind == resource.ReplicaSet {
+					trigger(&appsv1.ReplicaSet{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      owner.Name,
+							Namespace: podList.Items[podIndex].Namespace,
+						},
+					})
+				}
+			case resource.ReplicationController:
+				owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
+				if owner != nil && owner.Kind == resource.ReplicationController {
+					trigger(&corev1.ReplicationController{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      owner.Name,
+							Namespace: podList.Items[podIndex].Namespace,
+						},
+					})
+				}
+			case resource.Daemonset:
+				owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
+				if owner != nil && owner.Kind == resource.Daemonset {
+					trigger(&appsv1.DaemonSet{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      owner.Name,
+							Namespace: podList.Items[podIndex].Namespace,
+						},
+					})
+				}
+			case resource.Deployment:
+				owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
+				if owner != nil && owner.Kind == resource.ReplicaSet {
+					// Get the replicaset.
+					if err := cl.Get(ctx, types.NamespacedName{
+						Namespace: podList.Items[podIndex].Namespace,
+						Name:      owner.Name,
+					}, replicaSet); err != nil {
+						// The replicaset has been deleted, the pod is going away as well.
+						if k8sApiErrors.IsNotFound(err) {
+							logger.V(3).Info("replicaset not found, skipping its deployment", "subscriber", sub,
+								"replicaset", owner.Name)
+							continue
+						}
+						logger.Error(err, "unable to dispatch events", "subscriber", sub, "resourceKind", resourceKind)
+						continue
+					}
+					owner = events.ManagingOwner(replicaSet.OwnerReferences)
+					if owner != nil && owner.Kind == resource.Deployment {
+						trigger(&appsv1.ReplicaSet{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:      owner.Name,
+								Namespace: podList.Items[podIndex].Namespace,
+							},
+						})
+					}
+				}
+			case resource.Service:
+				services, err := selectingServices(ctx, cl, &podList.Items[podIndex])
+				if err != nil {
+					logger.Error(err, "unable to get services list", "subscriber", sub, "resourceKind", resourceKind)
+					continue
+				}
+				for svcIndex := range services {
+					trigger(&corev1.Service{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      services[svcIndex].Name,
+							Namespace: podList.Items[podIndex].Namespace,
+						},
+					})
+				}
+			}
+		}
+		// The resources of the broadcast namespaces are related to all the nodes, whatever the pods running on them.
+		if broadcast != nil {
+			if err := broadcast(ctx, trigger); err != nil {
+				logger.Error(err, "unable to dispatch broadcast events", "subscriber", sub, "resourceKind", resourceKind)
+				span.RecordError(err)
+			}
+		}
+		logger.V(2).Info("events correctly dispatched", "subscriber", sub, "resourceKind", resourceKind)
+	}
+
+	// dispatchKeys triggers the reconcile of the cached resources with the given keys.
+	dispatchKeys := func(keys []string, trigger func(obj client.Object)) {
+		for _, key := range keys {
+			name := events.NameFromKey(key)
+			trigger(&metav1.PartialObjectMetadata{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name.Name,
+					Namespace: name.Namespace,
+				},
+			})
+		}
+	}
+
+	// dispatchIndexed triggers the reconcile of the cached resources related to the subscriber's node, found through
+	// the node index of the cache. Only the resources of the node are touched, whatever the size of the cache.
+	dispatchIndexed := func(ctx context.Context, sub subscriber.Message, trigger func(obj client.Object)) {
+		keys := cache.KeysPerNode(sub.NodeName)
+		_, span := tracing.Start(ctx, "dispatch", resourceKind, "", tracing.NodeKey.String(sub.NodeName))
+		defer span.End()
+		dispatchKeys(keys, trigger)
+		logger.V(2).Info("events correctly dispatched from the cache", "subscriber", sub, "resourceKind", resourceKind)
+	}
+
+	// resync triggers the reconcile of the cached resources, to emit Delete events for stale subscribers, and of
+	// the resources related to the nodes with subscribers, to emit Create events for the missing ones.
+	resync := func(ctx context.Context) {
+		logger.V(2).Info("starting periodic resync", "resourceKind", resourceKind)
+		resyncs.Inc()
+		nodes := subscribers.Nodes()
+		ctx, span := tracing.Start(ctx, "resync", resourceKind, "", tracing.NodesKey.Int(len(nodes)))
+		defer span.End()
+		trigger := func(obj client.Object) { send(obj) }
+		dispatchKeys(cache.Keys(), trigger)
+		for _, node := range nodes {
+			dispatchNode(ctx, subscriber.Message{NodeName: node}, trigger)
+		}
+		logger.V(2).Info("periodic resync completed", "resourceKind", resourceKind)
+	}
+
+	// The resync runs in the same goroutine that handles the subscribers, so it never overlaps with itself. The next
+	// resync is scheduled a jittered period after the end of the previous one, so that the resyncs of the collectors
+	// do not hit the api-server at the same time.
+	var resyncTimer *time.Timer
+	var resyncTicks <-chan time.Time
+	if resyncPeriod > 0 {
+		resyncTimer = time.NewTimer(jitter(resyncPeriod, resyncJitter))
+		defer resyncTimer.Stop()
+		resyncTicks = resyncTimer.C
+	}
+
+	// The refreshes run in the same goroutine too, so they never overlap with a resync.
+	var refreshTicks <-chan time.Time
+	if refresh != nil {
+		refreshTicker := time.NewTicker(refresh.period())
+		defer refreshTicker.Stop()
+		refreshTicks = refreshTicker.C
+	}
+
 	// it listens for new getSubscribers and sends the cached events to the
 	// subscriber received on the channel.
 	dispatchEventsOnSubscribe := func(ctx context.Context) {
-		wg.Add(1)
+		defer wg.Done()
 		for {
 			select {
-			case sub := <-subChan:
-				if sub.Reason == subscriber.Unsubscribed {
+			case sub, ok := <-subChan:
+				if !ok {
+					// The channel must never be closed. The collector keeps reconciling and resyncing the
+					// resources of the current subscribers, but can't learn about new ones.
+					logger.Error(errSubsChanClosed, "no longer receiving the subscribers", "resourceKind", resourceKind)
+					subChan = nil
+					continue
+				}
+				// The resources sent to the subscribers of a node are cached and indexed by node. When the node
+				// already has subscribers, or a subscriber leaves or resyncs, the index holds all the resources related
+				// to the node. The first subscriber of a node needs the resources related to the pods running on it.
+				indexed := sub.Reason == subscriber.Unsubscribed || sub.Reason == subscriber.Resynced ||
+					subscribers.HasNode(sub.NodeName)
+				switch sub.Reason {
+				case subscriber.Unsubscribed:
 					// Delete the subscriber for the given node.
 					subscribers.DeleteSubscriberPerNode(sub.NodeName, sub.UID)
-				} else {
+				case subscriber.Resynced:
+					// The subscriber may have left in the meantime, there is nothing to resend.
+					if _, ok := subscribers.GetSubscribersPerNode(sub.NodeName)[sub.UID]; !ok {
+						sub.Dispatched()
+						continue
+					}
+					// Forget that the resources have been sent to the subscriber, so that the reconciles send
+					// them again with Create events.
+					cache.DeleteSubscribers(fields.Subscribers{sub.UID: {}})
+				default:
 					// Add the subscriber for the given node.
 					subscribers.AddSubscriberPerNode(sub.NodeName, sub.UID)
+					// A watch-only subscriber receives the changes of the resources from now on, the existing ones
+					// are not dispatched.
+					if sub.WatchOnly {
+						logger.V(2).Info("watch-only subscriber, skipping the dispatch", "subscriber", sub,
+							"resourceKind", resourceKind)
+						sub.Dispatched()
+						continue
+					}
 				}
 				logger.V(2).Info("Dispatching events", "subscriber", sub, "resourceKind", resourceKind)
-
-				// List all pods related to the given node.
-				if err := cl.List(ctx, podList, client.MatchingFields{
-					nodeNameIndex: sub.NodeName,
-				}); err != nil {
-					logger.Error(err, "unable to dispatch pod events", "subscriber", sub, "resourceKind", resourceKind)
-				}
-
-				for podIndex := range podList.Items {
-					switch resourceKind {
-					case resource.Pod:
-						dispatcherChan <- event.GenericEvent{Object: &corev1.Pod{
-							ObjectMeta: metav1.ObjectMeta{
-								Name:      podList.Items[podIndex].Name,
-								Namespace: podList.Items[podIndex].Namespace,
-							},
-						}}
-					case resource.Namespace:
-						dispatcherChan <- event.GenericEvent{Object: &corev1.Namespace{
-							ObjectMeta: metav1.ObjectMeta{
-								Name: podList.Items[podIndex].Namespace,
-							},
-						}}
-					case resource.ReplicaSet:
-						owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
-						if owner != nil && owner.Kind == resource.ReplicaSet {
-							dispatcherChan <- event.GenericEvent{Object: &appsv1.ReplicaSet{
-								ObjectMeta: metav1.ObjectMeta{
-									Name:      owner.Name,
-									Namespace: podList.Items[podIndex].Namespace,
-								},
-							}}
-						}
-					case resource.ReplicationController:
-						owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
-						if owner != nil && owner.Kind == resource.ReplicationController {
-							dispatcherChan <- event.GenericEvent{Object: &corev1.ReplicationController{
-								ObjectMeta: metav1.ObjectMeta{
-									Name:      owner.Name,
-									Namespace: podList.Items[podIndex].Namespace,
-								},
-							}}
-						}
-					case resource.Daemonset:
-						owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
-						if owner != nil && owner.Kind == resource.Daemonset {
-							dispatcherChan <- event.GenericEvent{Object: &appsv1.DaemonSet{
-								ObjectMeta: metav1.ObjectMeta{
-									Name:      owner.Name,
-									Namespace: podList.Items[podIndex].Namespace,
-								},
-							}}
-						}
-					case resource.Deployment:
-						owner := events.ManagingOwner(podList.Items[podIndex].OwnerReferences)
-						if owner != nil && owner.Kind == resource.ReplicaSet {
-							// Get the replicaset.
-							if err := cl.Get(ctx, types.NamespacedName{
-								Namespace: podList.Items[podIndex].Namespace,
-								Name:      owner.Name,
-							}, replicaSet); err != nil {
-								logger.Error(err, "unable to dispatch events", "subscriber", sub, "resourceKind", resourceKind)
-								continue
-							}
-							owner = events.ManagingOwner(replicaSet.OwnerReferences)
-							if owner != nil && owner.Kind == resource.Deployment {
-								dispatcherChan <- event.GenericEvent{Object: &appsv1.ReplicaSet{
-									ObjectMeta: metav1.ObjectMeta{
-										Name:      owner.Name,
-										Namespace: podList.Items[podIndex].Namespace,
-									},
-								}}
-							}
-						}
-					case resource.Service:
-						err := cl.List(ctx, &serviceList, &client.ListOptions{Namespace: podList.Items[podIndex].Namespace})
-						if err != nil {
-							logger.Error(err, "unable to get services list", "subscriber", sub, "resourceKind", resourceKind)
-							continue
-						}
-						for svcIndex := range serviceList.Items {
-							sel := labels.SelectorFromValidatedSet(serviceList.Items[svcIndex].Spec.Selector)
-							if !sel.Empty() && sel.Matches(labels.Set(podList.Items[podIndex].GetLabels())) {
-								dispatcherChan <- event.GenericEvent{Object: &corev1.Service{
-									ObjectMeta: metav1.ObjectMeta{
-										Name:      serviceList.Items[svcIndex].Name,
-										Namespace: podList.Items[podIndex].Namespace,
-									},
-								}}
-							}
+				// When the subscriber waits for the end of the dispatch, it is notified once the triggered reconciles
+				// have pushed their events to the queue.
+				trigger := func(obj client.Object) { send(obj) }
+				var rp *replay
+				if sub.Done != nil {
+					rp = replays.start(sub.Dispatched)
+					trigger = func(obj client.Object) {
+						key := client.ObjectKeyFromObject(obj)
+						replays.dispatched(rp, key)
+						if !send(obj) {
+							replays.undispatched(rp, key)
 						}
 					}
 				}
-				logger.V(2).Info("events correctly dispatched", "subscriber", sub, "resourceKind", resourceKind)
+				if indexed {
+					dispatchIndexed(ctx, sub, trigger)
+				} else {
+					dispatchNode(ctx, sub, trigger)
+				}
+				if rp != nil {
+					replays.seal(rp)
+				}
+
+			case <-resyncTicks:
+				resync(ctx)
+				resyncTimer.Reset(jitter(resyncPeriod, resyncJitter))
+
+			case <-resyncRequests:
+				resync(ctx)
+
+			case <-refreshTicks:
+				refreshed := refresh.refresh()
+				logger.V(2).Info("refreshed the cached resources", "resourceKind", resourceKind, "resources", refreshed)
 
 			case <-ctx.Done():
 				logger.V(2).Info("stopping dispatcher on new subscribers", "resourceKind", resourceKind)
-				// Before exiting we need to wait for all the clients to close their connections.
-				for subscribers.Len() > 0 {
-					sub := <-subChan
-					if sub.Reason == subscriber.Unsubscribed {
-						// Delete the subscriber for the given node.
-						subscribers.DeleteSubscriberPerNode(sub.NodeName, sub.UID)
-						logger.V(2).Info("connection closed", "subscriberName", sub.NodeName, "subscriberUID", sub.UID)
+				// Before exiting we need to wait for all the clients to close their connections. The ones still
+				// connected after the shutdown timeout are given up, so that a stuck subscriber can't block the exit.
+				timeout := time.NewTimer(shutdownTimeout)
+				defer timeout.Stop()
+				for subscribers.Len() > 0 && subChan != nil {
+					select {
+					case sub, ok := <-subChan:
+						if !ok {
+							return
+						}
+						sub.Dispatched()
+						if sub.Reason == subscriber.Unsubscribed {
+							// Delete the subscriber for the given node.
+							subscribers.DeleteSubscriberPerNode(sub.NodeName, sub.UID)
+							logger.V(2).Info("connection closed", "subscriberName", sub.NodeName, "subscriberUID", sub.UID)
+						}
+					case <-timeout.C:
+						logger.Info("giving up on the connected subscribers", "resourceKind", resourceKind,
+							"subscribers", subscribers.Len())
+						return
 					}
 				}
-				wg.Done()
 				return
 			}
 		}
@@ -167,6 +342,7 @@ func dispatch(ctx context.Context, logger logr.Logger, resourceKind string, subC
 
 	logger.Info("starting event dispatcher for new subscribers", "resourceKind", resourceKind)
 	// Start the dispatcher.
+	wg.Add(1)
 	go dispatchEventsOnSubscribe(ctx)
 
 	// Wait for shutdown signal.
@@ -178,3 +354,24 @@ func dispatch(ctx context.Context, logger logr.Logger, resourceKind string, subC
 
 	return nil
 }
+
+// trigger sends a generic event for the given object to the channel, to trigger its reconcile. It gives up when the
+// context is done, since nobody reads the channel once the controller has stopped. It returns true if the event
+// has been sent.
+func trigger(ctx context.Context, ch chan<- event.GenericEvent, obj client.Object) bool {
+	select {
+	case ch <- event.GenericEvent{Object: obj}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requestResync asks the dispatcher of a collector to run a resync, without blocking. The requests received while
+// a resync is pending are collapsed in it.
+func requestResync(resyncRequests chan<- struct{}) {
+	select {
+	case resyncRequests <- struct{}{}:
+	default:
+	}
+}
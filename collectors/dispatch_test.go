@@ -0,0 +1,411 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestDispatchResync(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().
+		WithObjects(pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		Build()
+
+	// The cache holds a pod that does not exist anymore, the reconciler has to send the Delete events.
+	cache := events.NewCache()
+	cache.Add(types.NamespacedName{Namespace: "default", Name: "stale"}.String(), &events.CacheEntry{})
+
+	subs := subscriber.NewSubscribers()
+	subs.AddSubscriberPerNode("node", "subscriber")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subs, cache, newReplays(),
+			defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 10*time.Millisecond, 0, nil, nil)
+	}()
+
+	triggered := make(map[types.NamespacedName]struct{})
+	timeout := time.After(5 * time.Second)
+	for len(triggered) < 2 {
+		select {
+		case evt := <-dispatcherChan:
+			triggered[client.ObjectKeyFromObject(evt.Object)] = struct{}{}
+		case <-timeout:
+			t.Fatalf("resync triggered only %v", triggered)
+		}
+	}
+
+	for _, name := range []string{"stale", "running"} {
+		if _, ok := triggered[types.NamespacedName{Namespace: "default", Name: name}]; !ok {
+			t.Errorf("expected resync to trigger the reconcile of %q", name)
+		}
+	}
+
+	cancel()
+	// Drain the events of resyncs that might be in progress while the subscriber leaves.
+	go func() {
+		for range dispatcherChan {
+		}
+	}()
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Unsubscribed}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(dispatcherChan)
+}
+
+func TestDispatchIndexed(t *testing.T) {
+	// The client holds no pods, only the node index of the cache can find the resources of the node.
+	cl := fake.NewClientBuilder().WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).Build()
+
+	cache := events.NewCache()
+	for node, name := range map[string]string{"node": "indexed", "other": "unrelated"} {
+		key := types.NamespacedName{Namespace: "default", Name: name}.String()
+		cache.Add(key, &events.CacheEntry{})
+		cache.AddNodes(key, node)
+	}
+
+	subs := subscriber.NewSubscribers()
+	subs.AddSubscriberPerNode("node", "subscriber")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 2)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subs, cache, newReplays(),
+			defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	}()
+
+	// A second subscriber of the node gets the resources of the node from the index.
+	subChan <- subscriber.Message{NodeName: "node", UID: "second", Reason: subscriber.Subscribed}
+	select {
+	case evt := <-dispatcherChan:
+		if key := client.ObjectKeyFromObject(evt.Object); key.Name != "indexed" {
+			t.Errorf("expected the reconcile of the indexed resource, got %v", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the indexed resource to be dispatched")
+	}
+
+	cancel()
+	// The subscribers leaving trigger again the resources of the node, but never the ones of other nodes.
+	dispatched := make(chan []string)
+	go func() {
+		var names []string
+		for evt := range dispatcherChan {
+			names = append(names, evt.Object.GetName())
+		}
+		dispatched <- names
+	}()
+	for _, uid := range []string{"subscriber", "second"} {
+		subChan <- subscriber.Message{NodeName: "node", UID: uid, Reason: subscriber.Unsubscribed}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(dispatcherChan)
+	for _, name := range <-dispatched {
+		if name != "indexed" {
+			t.Errorf("expected only the resources of the node to be dispatched, got %q", name)
+		}
+	}
+}
+
+func TestDispatchResynced(t *testing.T) {
+	cl := fake.NewClientBuilder().WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).Build()
+
+	// The pod has been sent to the subscriber of the node and to the one of another node.
+	cache := events.NewCache()
+	key := types.NamespacedName{Namespace: "default", Name: "indexed"}
+	cache.Add(key.String(), &events.CacheEntry{Subs: fields.Subscribers{"subscriber": {}, "other": {}}})
+	cache.AddNodes(key.String(), "node", "other-node")
+
+	subs := subscriber.NewSubscribers()
+	subs.AddSubscriberPerNode("node", "subscriber")
+	subs.AddSubscriberPerNode("other-node", "other")
+
+	replays := newReplays()
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 1)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subs, cache, replays,
+			defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	}()
+
+	// A subscriber that left in the meantime gets nothing.
+	dispatched := make(chan struct{}, 1)
+	subChan <- subscriber.Message{NodeName: "node", UID: "gone", Reason: subscriber.Resynced,
+		Done: func() { dispatched <- struct{}{} }}
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the resync of an unknown subscriber to be done")
+	}
+
+	// The resources of the node are dispatched again, and are no more known to be sent to the subscriber, so that
+	// their reconciles send them with Create events. The other subscribers are left untouched.
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Resynced,
+		Done: func() { dispatched <- struct{}{} }}
+	select {
+	case evt := <-dispatcherChan:
+		if got := client.ObjectKeyFromObject(evt.Object); got != key {
+			t.Errorf("expected the reconcile of %v, got %v", key, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the resources of the node to be dispatched again")
+	}
+	entry, _ := cache.Get(key.String())
+	if _, ok := entry.Subs["subscriber"]; ok {
+		t.Errorf("expected the resynced subscriber to be removed from the cache, got %v", entry.Subs)
+	}
+	if _, ok := entry.Subs["other"]; !ok {
+		t.Errorf("expected the other subscriber to be kept in the cache, got %v", entry.Subs)
+	}
+	if got := subs.GetSubscribersPerNode("node"); len(got) != 1 {
+		t.Errorf("expected the subscribers of the node to be unchanged, got %v", got)
+	}
+	replays.reconciling(key)()
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the resync to be done once the resources have been reconciled")
+	}
+
+	cancel()
+	go func() {
+		for range dispatcherChan {
+		}
+	}()
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Unsubscribed}
+	subChan <- subscriber.Message{NodeName: "other-node", UID: "other", Reason: subscriber.Unsubscribed}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(dispatcherChan)
+}
+
+func TestDispatchWatchOnly(t *testing.T) {
+	cl := fake.NewClientBuilder().WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).WithObjects(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}).Build()
+	cache := events.NewCache()
+	key := types.NamespacedName{Namespace: "default", Name: "indexed"}.String()
+	cache.Add(key, &events.CacheEntry{})
+	cache.AddNodes(key, "node")
+
+	subs := subscriber.NewSubscribers()
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 2)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subs, cache, newReplays(),
+			defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	}()
+
+	// Neither the pods running on the node nor the indexed resources are dispatched to watch-only subscribers, the
+	// dispatch is done right away.
+	dispatched := make(chan struct{}, 2)
+	for _, uid := range []string{"first", "second"} {
+		subChan <- subscriber.Message{NodeName: "node", UID: uid, Reason: subscriber.Subscribed, WatchOnly: true,
+			Done: func() { dispatched <- struct{}{} }}
+		select {
+		case <-dispatched:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expected the dispatch to be done", uid)
+		}
+	}
+	if len(dispatcherChan) != 0 {
+		t.Errorf("expected no reconcile to be triggered, got %d", len(dispatcherChan))
+	}
+	if got := subs.GetSubscribersPerNode("node"); len(got) != 2 {
+		t.Errorf("expected the watch-only subscribers to be added to the node, got %v", got)
+	}
+
+	cancel()
+	go func() {
+		for range dispatcherChan {
+		}
+	}()
+	for _, uid := range []string{"first", "second"} {
+		subChan <- subscriber.Message{NodeName: "node", UID: uid, Reason: subscriber.Unsubscribed}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(dispatcherChan)
+}
+
+func TestResyncRequest(t *testing.T) {
+	cl := fake.NewClientBuilder().WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).Build()
+	cache := events.NewCache()
+	cache.Add(types.NamespacedName{Namespace: "default", Name: "cached"}.String(), &events.CacheEntry{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcherChan := make(chan event.GenericEvent, 1)
+	resyncRequests := make(chan struct{}, 1)
+	go func() {
+		_ = dispatch(ctx, logr.Discard(), resource.Pod, make(subscriber.SubsChan), dispatcherChan, cl,
+			subscriber.NewSubscribers(), cache, newReplays(), defaultMetrics.resyncs.WithLabelValues(resource.Pod),
+			resyncRequests, 0, 0, nil, nil)
+	}()
+
+	// The periodic resync is disabled, the requested one reconciles the cached resources.
+	requestResync(resyncRequests)
+	select {
+	case evt := <-dispatcherChan:
+		if evt.Object.GetName() != "cached" {
+			t.Errorf("expected the reconcile of the cached resource, got %q", evt.Object.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the cached resource to be resynced")
+	}
+}
+
+func TestDispatchListError(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	lists := 0
+	cl := fake.NewClientBuilder().
+		WithObjects(pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		WithInterceptorFuncs(interceptor.Funcs{List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList,
+			opts ...client.ListOption) error {
+			lists++
+			if lists > 1 {
+				return errors.New("cache unavailable")
+			}
+			return cl.List(ctx, list, opts...)
+		}}).
+		Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 2)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subscriber.NewSubscribers(),
+			events.NewCache(), newReplays(), defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	}()
+
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Subscribed}
+	select {
+	case evt := <-dispatcherChan:
+		if evt.Object.GetName() != "running" {
+			t.Errorf("expected the reconcile of the running pod, got %s", evt.Object.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the running pod to be dispatched")
+	}
+
+	// The pods of the previous dispatch are not dispatched to a node whose pods can not be listed.
+	subChan <- subscriber.Message{NodeName: "other", UID: "other", Reason: subscriber.Subscribed}
+	select {
+	case evt := <-dispatcherChan:
+		t.Errorf("expected no reconcile when the pods can not be listed, got %s", evt.Object.GetName())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	for _, sub := range []subscriber.Message{{NodeName: "node", UID: "subscriber"}, {NodeName: "other", UID: "other"}} {
+		sub.Reason = subscriber.Unsubscribed
+		subChan <- sub
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDispatchSubsChanClosed(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().
+		WithObjects(pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		Build()
+	subs := subscriber.NewSubscribers()
+	subs.AddSubscriberPerNode("node", "subscriber")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent)
+	resyncRequests := make(chan struct{}, 1)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subs, events.NewCache(), newReplays(),
+			defaultMetrics.resyncs.WithLabelValues(resource.Pod), resyncRequests, 0, 0, nil, nil)
+	}()
+
+	// The closed channel is neither read as empty subscriptions, nor stops the collector.
+	close(subChan)
+	requestResync(resyncRequests)
+	select {
+	case evt := <-dispatcherChan:
+		if evt.Object.GetName() != "running" {
+			t.Errorf("expected the resync to trigger the reconcile of the running pod, got %q", evt.Object.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the resync to run after the channel has been closed")
+	}
+	if nodes := subs.Nodes(); len(nodes) != 1 || nodes[0] != "node" {
+		t.Errorf("expected the subscribers to be left untouched, got %v", nodes)
+	}
+
+	// The collector stops without waiting for unsubscriptions that can't arrive anymore.
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(shutdownTimeout / 2):
+		t.Fatal("expected the dispatcher to stop")
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// Dispatcher delivers the metadata of the existing resources to the subscribers of a collector: it tracks the
+// subscribers joining or leaving on the subscribers channel and triggers the reconciles of the resources they need,
+// whose events are pushed to the queue by the collector. It is run by the collector once its initial sync has
+// completed, and returns once the context is canceled and the subscribers have left.
+type Dispatcher interface {
+	Dispatch(ctx context.Context, setup *DispatchSetup) error
+}
+
+// DispatcherFunc is an adapter to use a function as a Dispatcher.
+type DispatcherFunc func(ctx context.Context, setup *DispatchSetup) error
+
+// Dispatch calls f(ctx, setup).
+func (f DispatcherFunc) Dispatch(ctx context.Context, setup *DispatchSetup) error {
+	return f(ctx, setup)
+}
+
+// DispatchSetup holds what a dispatcher runs with.
+type DispatchSetup struct {
+	// Logger of the collector.
+	Logger logr.Logger
+	// Kind of the resources of the collector.
+	Kind string
+	// Subscriptions is the channel of the subscribers joining or leaving.
+	Subscriptions subscriber.SubsChan
+	// Subscribers of the collector, by node. The dispatcher keeps them up to date.
+	Subscribers *subscriber.Subscribers
+	// Client of the collector, reading from the cache of the manager.
+	Client client.Client
+	// Cache of the resources sent by the collector, indexed by node.
+	Cache *events.Cache
+	// Queue where the collector pushes its events.
+	Queue broker.Queue
+
+	reconciles     chan<- event.GenericEvent
+	replays        *replays
+	resyncs        prometheus.Counter
+	resyncRequests <-chan struct{}
+	resyncPeriod   time.Duration
+	jitter         float64
+	refresh        *refresher
+	broadcast      broadcaster
+}
+
+// Trigger triggers the reconcile of the given object by the collector. It returns false if the context is canceled
+// before the reconcile is enqueued.
+func (s *DispatchSetup) Trigger(ctx context.Context, obj client.Object) bool {
+	return trigger(ctx, s.reconciles, obj)
+}
+
+// defaultDispatcher is the dispatcher of the collectors unless configured otherwise. Besides the subscribers, it runs
+// the periodic and the requested resyncs and the refreshes of the cached resources, which the other dispatchers do not
+// get.
+type defaultDispatcher struct{}
+
+// Dispatch runs the dispatch of the collector.
+func (defaultDispatcher) Dispatch(ctx context.Context, s *DispatchSetup) error {
+	return dispatch(ctx, s.Logger, s.Kind, s.Subscriptions, s.reconciles, s.Client, s.Subscribers, s.Cache, s.replays,
+		s.resyncs, s.resyncRequests, s.resyncPeriod, s.jitter, s.refresh, s.broadcast)
+}
+
+// dispatcherOrDefault returns the given dispatcher, or the default one if nil.
+func dispatcherOrDefault(d Dispatcher) Dispatcher {
+	if d == nil {
+		return defaultDispatcher{}
+	}
+	return d
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// mockDispatcher records the setups it is run with and triggers the reconcile of a pod.
+type mockDispatcher struct {
+	setups []*DispatchSetup
+	err    error
+}
+
+func (m *mockDispatcher) Dispatch(ctx context.Context, setup *DispatchSetup) error {
+	m.setups = append(m.setups, setup)
+	setup.Trigger(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}})
+	return m.err
+}
+
+func TestWithDispatcher(t *testing.T) {
+	cl := fake.NewClientBuilder().Build()
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	subChan := make(subscriber.SubsChan)
+	mock := &mockDispatcher{err: errors.New("dispatch failed")}
+
+	pc := NewPodCollector(cl, queue, cache, "pod-collector", WithSubscribersChan(subChan), WithDispatcher(mock))
+	sc := NewServiceCollector(cl, queue, cache, "service-collector", WithSubscribersChan(subChan), WithDispatcher(mock))
+	oc := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector", WithSubscribersChan(subChan), WithDispatcher(mock))
+	tests := []struct {
+		kind      string
+		start     func(ctx context.Context) error
+		triggered chan event.GenericEvent
+	}{
+		{kind: resource.Pod, start: pc.Start, triggered: pc.dispatcherChan},
+		{kind: resource.Service, start: sc.Start, triggered: sc.dispatcherChan},
+		{kind: resource.Deployment, start: oc.Start, triggered: oc.dispatcherChan},
+	}
+
+	for i, tt := range tests {
+		if err := tt.start(context.Background()); !errors.Is(err, mock.err) {
+			t.Errorf("%s: expected the error of the dispatcher, got %v", tt.kind, err)
+		}
+		setup := mock.setups[i]
+		if setup.Kind != tt.kind || setup.Subscriptions != subChan || setup.Cache != cache || setup.Queue != queue {
+			t.Errorf("%s: unexpected setup %+v", tt.kind, setup)
+		}
+		// The reconciles triggered by the dispatcher are enqueued by the collector.
+		select {
+		case evt := <-tt.triggered:
+			if evt.Object.GetName() != "pod" {
+				t.Errorf("%s: expected the reconcile of the pod to be triggered, got %s", tt.kind, evt.Object.GetName())
+			}
+		default:
+			t.Errorf("%s: expected a reconcile to be triggered", tt.kind)
+		}
+	}
+
+	// Without the option, the collectors run the default dispatcher.
+	pc = NewPodCollector(cl, queue, cache, "pod-collector")
+	if _, ok := pc.dispatcher.(defaultDispatcher); !ok {
+		t.Errorf("expected the default dispatcher, got %T", pc.dispatcher)
+	}
+}
+
+func TestDispatcherFunc(t *testing.T) {
+	var got *DispatchSetup
+	d := DispatcherFunc(func(ctx context.Context, setup *DispatchSetup) error {
+		got = setup
+		return nil
+	})
+	setup := &DispatchSetup{Kind: resource.Pod}
+	if err := d.Dispatch(context.Background(), setup); err != nil || got != setup {
+		t.Errorf("expected the function to be called with the setup, got %v and %v", got, err)
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DryRunSubscribers simulates a subscriber for each node of the cluster. It is used in dry-run mode, where
+// real subscribers are refused, so that the collectors generate the same events they would generate if
+// a subscriber was running on each node.
+type DryRunSubscribers struct {
+	client.Client
+	// Collectors are the channels where the collectors get notified of new subscribers.
+	Collectors map[string]subscriber.SubsChan
+	Name       string
+	// MetricsRegisterer is the registry where the metrics of the simulated subscribers are registered, the controller-runtime one if
+	// nil.
+	MetricsRegisterer prometheus.Registerer
+	// MetricsPrefix is prepended to the names of the metrics of the simulated subscribers, empty to keep their names.
+	MetricsPrefix string
+	nodes         map[string]struct{}
+	mutex         sync.Mutex
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// Reconcile subscribes a simulated subscriber when a node is added to the cluster, and unsubscribes it when the
+// node is deleted. The node name is used as subscriber UID.
+func (r *DryRunSubscribers) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	node, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Node), nil)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.Get(ctx, req.NamespacedName, node)
+	if err != nil && !k8sApiErrors.IsNotFound(err) {
+		logger.Error(err, "unable to get resource")
+		return ctrl.Result{}, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.nodes == nil {
+		r.nodes = make(map[string]struct{})
+	}
+	_, known := r.nodes[req.Name]
+
+	switch {
+	case k8sApiErrors.IsNotFound(err) && known:
+		logger.V(3).Info("unsubscribing dry-run subscriber")
+		r.notify(ctx, subscriber.Message{NodeName: req.Name, UID: req.Name, Reason: subscriber.Unsubscribed})
+		delete(r.nodes, req.Name)
+	case err == nil && !known:
+		logger.V(3).Info("subscribing dry-run subscriber")
+		r.notify(ctx, subscriber.Message{NodeName: req.Name, UID: req.Name, Reason: subscriber.Subscribed})
+		r.nodes[req.Name] = struct{}{}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// Start implements the runnable interface. When the manager stops, it unsubscribes all the simulated
+// subscribers, otherwise the collectors would wait for them until the shutdown timeout.
+func (r *DryRunSubscribers) Start(ctx context.Context) error {
+	<-ctx.Done()
+	// The collectors only wait for the subscribers until the shutdown timeout, don't block past it.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for node := range r.nodes {
+		r.notify(ctx, subscriber.Message{NodeName: node, UID: node, Reason: subscriber.Unsubscribed})
+		delete(r.nodes, node)
+	}
+	return nil
+}
+
+// notify sends the message to all the collectors. It gives up when the context is done.
+func (r *DryRunSubscribers) notify(ctx context.Context, msg subscriber.Message) {
+	for _, collector := range r.Collectors {
+		select {
+		case collector <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DryRunSubscribers) SetupWithManager(mgr ctrl.Manager) error {
+	metrics, err := registerCollectorMetrics(r.MetricsRegisterer, r.MetricsPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to register the metrics: %w", err)
+	}
+	lc, err := newLogConstructor(mgr.GetLogger(), r.Name, resource.Node)
+	if err != nil {
+		return err
+	}
+	node, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Node), nil)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(node,
+			builder.OnlyMetadata,
+			builder.WithPredicates(metrics.predicates(r.Name, apiServerSource, nil))).
+		WithOptions(controller.Options{LogConstructor: lc}).
+		Complete(r)
+}
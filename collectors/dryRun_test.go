@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDryRunSubscribers(t *testing.T) {
+	ctx := context.Background()
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+	}
+	cl := fake.NewClientBuilder().WithObjects(nodes[0], nodes[1]).Build()
+	pods := make(subscriber.SubsChan, 10)
+	services := make(subscriber.SubsChan, 10)
+	r := &DryRunSubscribers{
+		Client:     cl,
+		Collectors: map[string]subscriber.SubsChan{"Pod": pods, "Service": services},
+		Name:       "dry-run-subscribers",
+	}
+
+	run := func(node string) {
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: node}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// received returns the messages received by each collector since the last call.
+	received := func() []subscriber.Message {
+		var msgs []subscriber.Message
+		for _, ch := range []subscriber.SubsChan{pods, services} {
+			for len(ch) > 0 {
+				msgs = append(msgs, <-ch)
+			}
+		}
+		return msgs
+	}
+
+	// A subscriber named after the node is simulated for each node, once.
+	run("node-1")
+	run("node-1")
+	subscribed := subscriber.Message{NodeName: "node-1", UID: "node-1", Reason: subscriber.Subscribed}
+	if got := received(); !reflect.DeepEqual(got, []subscriber.Message{subscribed, subscribed}) {
+		t.Errorf("expected each collector to be notified of the subscriber once, got %+v", got)
+	}
+
+	// The subscriber is unsubscribed when its node is deleted, an unknown node is ignored.
+	if err := cl.Delete(ctx, nodes[0]); err != nil {
+		t.Fatal(err)
+	}
+	run("node-1")
+	run("unknown")
+	unsubscribed := subscriber.Message{NodeName: "node-1", UID: "node-1", Reason: subscriber.Unsubscribed}
+	if got := received(); !reflect.DeepEqual(got, []subscriber.Message{unsubscribed, unsubscribed}) {
+		t.Errorf("expected each collector to be notified of the unsubscription, got %+v", got)
+	}
+
+	// The remaining subscribers are unsubscribed when the manager stops.
+	run("node-2")
+	received()
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := r.Start(stopped); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unsubscribed = subscriber.Message{NodeName: "node-2", UID: "node-2", Reason: subscriber.Unsubscribed}
+	if got := received(); !reflect.DeepEqual(got, []subscriber.Message{unsubscribed, unsubscribed}) {
+		t.Errorf("expected the subscribers to be unsubscribed on stop, got %+v", got)
+	}
+}
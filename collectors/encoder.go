@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/mitchellh/hashstructure/v2"
+)
+
+// MetaEncoder serializes the metadata of the resources, in their unstructured form, to the JSON sent in the meta field
+// of the events. The encoders must sort the keys of the maps, so that the same metadata are always serialized the same.
+type MetaEncoder interface {
+	// Name returns the name of the encoder.
+	Name() string
+	// Marshal serializes the metadata.
+	Marshal(meta map[string]interface{}) ([]byte, error)
+}
+
+const (
+	// EncoderJSON is the name of the encoder based on encoding/json, the default one.
+	EncoderJSON = "json"
+	// EncoderJSONIter is the name of the encoder based on jsoniter.
+	EncoderJSONIter = "jsoniter"
+	// EncoderFlat is the name of the encoder based on encoding/json flattening the metadata in dotted keys.
+	EncoderFlat = "flat"
+)
+
+// ParseMetaEncoder returns the encoder with the given name.
+func ParseMetaEncoder(name string) (MetaEncoder, error) {
+	switch name {
+	case EncoderJSON:
+		return JSONEncoder, nil
+	case EncoderJSONIter:
+		return JSONIterEncoder, nil
+	case EncoderFlat:
+		return FlatEncoder, nil
+	default:
+		return nil, fmt.Errorf("unknown metadata encoder %q, expected %q, %q or %q", name, EncoderJSON, EncoderJSONIter,
+			EncoderFlat)
+	}
+}
+
+var (
+	// JSONEncoder serializes the metadata with encoding/json.
+	JSONEncoder MetaEncoder = jsonEncoder{}
+	// JSONIterEncoder serializes the metadata with jsoniter, configured to be compatible with encoding/json.
+	JSONIterEncoder MetaEncoder = jsoniterEncoder{}
+	// FlatEncoder serializes the metadata with encoding/json as a flat object, whose keys are the dotted paths of the
+	// nested fields, e.g. labels.app.
+	FlatEncoder MetaEncoder = flatEncoder{}
+)
+
+type jsonEncoder struct{}
+
+// Name returns the name of the encoder.
+func (jsonEncoder) Name() string {
+	return EncoderJSON
+}
+
+// Marshal serializes the metadata with encoding/json.
+func (jsonEncoder) Marshal(meta map[string]interface{}) ([]byte, error) {
+	return json.Marshal(meta)
+}
+
+type jsoniterEncoder struct{}
+
+// Name returns the name of the encoder.
+func (jsoniterEncoder) Name() string {
+	return EncoderJSONIter
+}
+
+// Marshal serializes the metadata with jsoniter.
+func (jsoniterEncoder) Marshal(meta map[string]interface{}) ([]byte, error) {
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(meta)
+}
+
+type flatEncoder struct{}
+
+// Name returns the name of the encoder.
+func (flatEncoder) Name() string {
+	return EncoderFlat
+}
+
+// Marshal flattens the metadata and serializes them with encoding/json.
+func (flatEncoder) Marshal(meta map[string]interface{}) ([]byte, error) {
+	flat := make(map[string]interface{})
+	flatten("", meta, flat)
+	return json.Marshal(flat)
+}
+
+// flatten adds the leaves of the value to the flat map, keyed by their dotted path below the prefix. The elements of
+// the lists are keyed by their index, e.g. finalizers.0. The empty maps and lists are kept as leaves. The keys are not
+// escaped: the dots in the label keys, e.g. app.kubernetes.io/name, are kept as they are.
+func flatten(prefix string, value interface{}, flat map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) > 0 || prefix == "" {
+			for key, val := range v {
+				flatten(flatKey(prefix, key), val, flat)
+			}
+			return
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			for i, val := range v {
+				flatten(flatKey(prefix, strconv.Itoa(i)), val, flat)
+			}
+			return
+		}
+	}
+	flat[prefix] = value
+}
+
+// flatKey returns the key of a field of a flattened value.
+func flatKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// setMeta sets the serialized metadata of the resource, and the hash of their canonical form, i.e. the unstructured
+// one. The changes of the resources are detected on the hash, so that switching encoder does not generate Update
+// events for unchanged resources.
+func setMeta(res *events.Resource, data []byte, meta map[string]interface{}) error {
+	hash, err := hashstructure.Hash(meta, hashstructure.FormatV2, nil)
+	if err != nil {
+		return err
+	}
+	res.SetMeta(string(data))
+	res.SetMetaHash(hash)
+	return nil
+}
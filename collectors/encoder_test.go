@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// indentEncoder serializes the metadata as indented JSON, differently from the other encoders.
+type indentEncoder struct{}
+
+func (indentEncoder) Name() string {
+	return "indent"
+}
+
+func (indentEncoder) Marshal(meta map[string]interface{}) ([]byte, error) {
+	return json.MarshalIndent(meta, "", "  ")
+}
+
+func TestParseMetaEncoder(t *testing.T) {
+	for _, name := range []string{EncoderJSON, EncoderJSONIter, EncoderFlat} {
+		enc, err := ParseMetaEncoder(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if enc.Name() != name {
+			t.Errorf("expected the %s encoder, got %s", name, enc.Name())
+		}
+	}
+	if _, err := ParseMetaEncoder("xml"); err == nil {
+		t.Error("expected an error for an unknown encoder")
+	}
+}
+
+func TestMetaEncoders(t *testing.T) {
+	meta := podMeta(t)
+	expected, err := JSONEncoder.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := JSONIterEncoder.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected) {
+		t.Errorf("expected jsoniter to serialize the metadata as encoding/json\n%s\ngot\n%s", expected, data)
+	}
+}
+
+func TestMetaEncodersCompact(t *testing.T) {
+	meta := podMeta(t)
+	for _, enc := range []MetaEncoder{JSONEncoder, JSONIterEncoder, FlatEncoder} {
+		data, err := enc.Marshal(meta)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", enc.Name(), err)
+		}
+		// The metadata of the pod hold no whitespace, any would have been added by the encoder.
+		if strings.ContainsAny(string(data), " \t\n") {
+			t.Errorf("%s: expected compact JSON, got %s", enc.Name(), data)
+		}
+	}
+}
+
+func TestFlatEncoder(t *testing.T) {
+	meta := podMeta(t)
+	meta["finalizers"] = []interface{}{"first", "second"}
+	meta["clusterName"] = "prod"
+	meta["empty"] = map[string]interface{}{}
+
+	data, err := FlatEncoder.Marshal(meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	flat := map[string]interface{}{}
+	if err := json.Unmarshal(data, &flat); err != nil {
+		t.Fatalf("unable to decode the flat metadata: %v", err)
+	}
+
+	for key, want := range map[string]interface{}{
+		"name":                           "web-7d4b9c8f6d-x2x9k",
+		"clusterName":                    "prod",
+		"labels.app.kubernetes.io/name":  "web",
+		"annotations.prometheus.io/port": "9090",
+		"finalizers.0":                   "first",
+		"finalizers.1":                   "second",
+		"empty":                          map[string]interface{}{},
+	} {
+		if got, ok := flat[key]; !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %q to be %v, got %v", key, want, got)
+		}
+	}
+	// No value is nested, but the empty ones.
+	for key, value := range flat {
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			t.Errorf("expected %q to be flattened, got %v", key, value)
+		}
+		if _, ok := value.([]interface{}); ok {
+			t.Errorf("expected %q to be flattened, got %v", key, value)
+		}
+	}
+	if _, ok := flat["labels"]; ok {
+		t.Error("expected the labels to be flattened")
+	}
+
+	// The empty metadata are an empty object.
+	if data, err = FlatEncoder.Marshal(map[string]interface{}{}); err != nil || string(data) != "{}" {
+		t.Errorf("expected an empty object, got %s: %v", data, err)
+	}
+}
+
+func TestSwitchMetaEncoder(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"app": "web"}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	subscribers := subscriber.NewSubscribers()
+	subscribers.AddSubscriberPerNode("node", "subscriber")
+	reconcile := func(enc MetaEncoder) {
+		t.Helper()
+		collector := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Deployment, nil),
+			"encoder-deployment-collector", WithMetaEncoder(enc), WithSubscribers(subscribers))
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", enc.Name(), err)
+		}
+	}
+
+	reconcile(JSONEncoder)
+	if got := queue.pop(); len(got) != 1 || got[0] != events.Create {
+		t.Fatalf("expected a Create event, got %v", got)
+	}
+	// The same metadata serialized differently do not change the resource.
+	reconcile(indentEncoder{})
+	if got := queue.pop(); len(got) != 0 {
+		t.Errorf("expected no events when switching encoder, got %v", got)
+	}
+
+	dpl.Labels = map[string]string{"app": "api"}
+	if err := cl.Update(ctx, dpl); err != nil {
+		t.Fatalf("unable to update deployment: %v", err)
+	}
+	reconcile(JSONIterEncoder)
+	if got := queue.pop(); len(got) != 1 || got[0] != events.Update {
+		t.Errorf("expected an Update event for the changed metadata, got %v", got)
+	}
+}
+
+func BenchmarkMetaEncoders(b *testing.B) {
+	meta := podMeta(b)
+	for _, enc := range []MetaEncoder{JSONEncoder, JSONIterEncoder, FlatEncoder} {
+		b.Run(enc.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := enc.Marshal(meta); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// podMeta returns the unstructured metadata of a pod with a representative set of labels and annotations.
+func podMeta(tb testing.TB) map[string]interface{} {
+	tb.Helper()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "web-7d4b9c8f6d-x2x9k",
+		Namespace:       "production",
+		UID:             "5f0c9c3e-8a7b-4d6e-9f1a-2b3c4d5e6f70",
+		ResourceVersion: "123456789",
+		GenerateName:    "web-7d4b9c8f6d-",
+		Labels: map[string]string{
+			"app.kubernetes.io/name":      "web",
+			"app.kubernetes.io/instance":  "web-production",
+			"app.kubernetes.io/version":   "1.24.3",
+			"app.kubernetes.io/component": "frontend",
+			"pod-template-hash":           "7d4b9c8f6d",
+		},
+		Annotations: map[string]string{
+			"kubectl.kubernetes.io/restartedAt": "2023-10-01T12:00:00Z",
+			"prometheus.io/scrape":              "true",
+			"prometheus.io/port":                "9090",
+			"cni.projectcalico.org/podIP":       "10.0.12.34/32",
+		},
+	}}
+	podUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return podUn["metadata"].(map[string]interface{})
+}
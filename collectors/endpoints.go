@@ -17,8 +17,10 @@ package collectors
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
@@ -38,6 +40,11 @@ type EndpointsDispatcher struct {
 	PodCollectorSource     chan<- event.GenericEvent
 	ServiceCollectorSource chan<- event.GenericEvent
 	Name                   string
+	// MetricsRegisterer is the registry where the metrics of the dispatcher are registered, the controller-runtime one if
+	// nil.
+	MetricsRegisterer prometheus.Registerer
+	// MetricsPrefix is prepended to the names of the metrics of the dispatcher, empty to keep their names.
+	MetricsPrefix string
 }
 
 //+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
@@ -61,8 +68,12 @@ func (r *EndpointsDispatcher) Reconcile(ctx context.Context, req ctrl.Request) (
 		pods, ok := r.Pods[req.String()]
 		if ok {
 			logger.V(3).Info("triggering pods and service since the resource has been deleted")
-			r.triggerPods(req.Namespace, pods)
-			r.triggerService(req.NamespacedName)
+			if err := r.triggerPods(ctx, req.Namespace, pods); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.triggerService(ctx, req.NamespacedName); err != nil {
+				return ctrl.Result{}, err
+			}
 		}
 		// When the k8s resource get deleted we need to remove it from the local cache.
 		delete(r.Pods, req.String())
@@ -75,29 +86,45 @@ func (r *EndpointsDispatcher) Reconcile(ctx context.Context, req ctrl.Request) (
 	addedPods, deletedPods := r.getPods(eps, &req)
 
 	// Trigger the pods.
-	r.triggerPods(eps.Namespace, addedPods)
-	r.triggerPods(eps.Namespace, deletedPods)
-	r.triggerService(req.NamespacedName)
+	if err := r.triggerPods(ctx, eps.Namespace, addedPods); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.triggerPods(ctx, eps.Namespace, deletedPods); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.triggerService(ctx, req.NamespacedName); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *EndpointsDispatcher) triggerPods(namespace string, pods map[string]struct{}) {
+func (r *EndpointsDispatcher) triggerPods(ctx context.Context, namespace string, pods map[string]struct{}) error {
 	for p := range pods {
-		obj := NewPartialObjectMetadata(resource.Pod, &types.NamespacedName{
+		obj, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Pod), &types.NamespacedName{
 			Namespace: namespace,
 			Name:      p,
 		})
+		if err != nil {
+			return err
+		}
 
-		r.PodCollectorSource <- event.GenericEvent{Object: obj}
+		if !trigger(ctx, r.PodCollectorSource, obj) {
+			return nil
+		}
 	}
+	return nil
 }
 
-func (r *EndpointsDispatcher) triggerService(meta types.NamespacedName) {
+func (r *EndpointsDispatcher) triggerService(ctx context.Context, meta types.NamespacedName) error {
 	// Endpoints name is the same as the one of the service to which refers.
-	obj := NewPartialObjectMetadata(resource.Service, &meta)
+	obj, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Service), &meta)
+	if err != nil {
+		return err
+	}
 
-	r.ServiceCollectorSource <- event.GenericEvent{Object: obj}
+	trigger(ctx, r.ServiceCollectorSource, obj)
+	return nil
 }
 
 func (r *EndpointsDispatcher) getPods(eps *corev1.Endpoints, req *ctrl.Request) (added, deleted map[string]struct{}) {
@@ -142,6 +169,10 @@ func (r *EndpointsDispatcher) getPods(eps *corev1.Endpoints, req *ctrl.Request)
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *EndpointsDispatcher) SetupWithManager(mgr ctrl.Manager) error {
+	metrics, err := registerCollectorMetrics(r.MetricsRegisterer, r.MetricsPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to register the metrics: %w", err)
+	}
 	lc, err := newLogConstructor(mgr.GetLogger(), r.Name, resource.Endpoints)
 	if err != nil {
 		return err
@@ -149,7 +180,7 @@ func (r *EndpointsDispatcher) SetupWithManager(mgr ctrl.Manager) error {
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Endpoints{},
-			builder.WithPredicates(predicatesWithMetrics(r.Name, apiServerSource, nil))).
+			builder.WithPredicates(metrics.predicates(r.Name, apiServerSource, nil))).
 		WithOptions(controller.Options{LogConstructor: lc}).
 		Complete(r)
 }
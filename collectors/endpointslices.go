@@ -17,9 +17,12 @@ package collectors
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
@@ -40,6 +43,11 @@ type EndpointslicesDispatcher struct {
 	ServiceCollectorSource chan<- event.GenericEvent
 	ServicesName           map[string]string
 	Name                   string
+	// MetricsRegisterer is the registry where the metrics of the dispatcher are registered, the controller-runtime one if
+	// nil.
+	MetricsRegisterer prometheus.Registerer
+	// MetricsPrefix is prepended to the names of the metrics of the dispatcher, empty to keep their names.
+	MetricsPrefix string
 }
 
 //+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
@@ -63,13 +71,17 @@ func (r *EndpointslicesDispatcher) Reconcile(ctx context.Context, req ctrl.Reque
 		pods, ok := r.Pods[req.String()]
 		if ok {
 			logger.V(3).Info("triggering pods and service since the resource has been deleted")
-			r.triggerPods(req.Namespace, pods)
+			if err := r.triggerPods(ctx, req.Namespace, pods); err != nil {
+				return ctrl.Result{}, err
+			}
 			// Get the service name.
 			if svcName, ok := r.ServicesName[req.Name]; ok {
-				r.triggerService(types.NamespacedName{
+				if err := r.triggerService(ctx, types.NamespacedName{
 					Namespace: req.Namespace,
 					Name:      svcName,
-				})
+				}); err != nil {
+					return ctrl.Result{}, err
+				}
 			}
 		}
 		// When the k8s resource get deleted we need to remove it from the local cache.
@@ -86,34 +98,50 @@ func (r *EndpointslicesDispatcher) Reconcile(ctx context.Context, req ctrl.Reque
 	addedPods, deletedPods := r.getPods(eps, &req)
 
 	// Trigger the pods.
-	r.triggerPods(eps.Namespace, addedPods)
-	r.triggerPods(eps.Namespace, deletedPods)
+	if err := r.triggerPods(ctx, eps.Namespace, addedPods); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.triggerPods(ctx, eps.Namespace, deletedPods); err != nil {
+		return ctrl.Result{}, err
+	}
 	if svcName, ok := r.ServicesName[req.Name]; ok {
-		r.triggerService(types.NamespacedName{
+		if err := r.triggerService(ctx, types.NamespacedName{
 			Namespace: req.Namespace,
 			Name:      svcName,
-		})
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *EndpointslicesDispatcher) triggerPods(namespace string, pods map[string]struct{}) {
+func (r *EndpointslicesDispatcher) triggerPods(ctx context.Context, namespace string, pods map[string]struct{}) error {
 	for p := range pods {
-		obj := NewPartialObjectMetadata(resource.Pod, &types.NamespacedName{
+		obj, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Pod), &types.NamespacedName{
 			Namespace: namespace,
 			Name:      p,
 		})
+		if err != nil {
+			return err
+		}
 
-		r.PodCollectorSource <- event.GenericEvent{Object: obj}
+		if !trigger(ctx, r.PodCollectorSource, obj) {
+			return nil
+		}
 	}
+	return nil
 }
 
-func (r *EndpointslicesDispatcher) triggerService(meta types.NamespacedName) {
+func (r *EndpointslicesDispatcher) triggerService(ctx context.Context, meta types.NamespacedName) error {
 	// Endpoints name is the same as the one of the service to which refers.
-	obj := NewPartialObjectMetadata(resource.Service, &meta)
+	obj, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Service), &meta)
+	if err != nil {
+		return err
+	}
 
-	r.ServiceCollectorSource <- event.GenericEvent{Object: obj}
+	trigger(ctx, r.ServiceCollectorSource, obj)
+	return nil
 }
 
 func (r *EndpointslicesDispatcher) getPods(eps *discoveryv1.EndpointSlice, req *ctrl.Request) (added, deleted map[string]struct{}) {
@@ -154,6 +182,10 @@ func (r *EndpointslicesDispatcher) getPods(eps *discoveryv1.EndpointSlice, req *
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *EndpointslicesDispatcher) SetupWithManager(mgr ctrl.Manager) error {
+	metrics, err := registerCollectorMetrics(r.MetricsRegisterer, r.MetricsPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to register the metrics: %w", err)
+	}
 	lc, err := newLogConstructor(mgr.GetLogger(), r.Name, resource.EndpointSlice)
 	if err != nil {
 		return err
@@ -161,7 +193,7 @@ func (r *EndpointslicesDispatcher) SetupWithManager(mgr ctrl.Manager) error {
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&discoveryv1.EndpointSlice{},
-			builder.WithPredicates(predicatesWithMetrics(r.Name, apiServerSource, nil))).
+			builder.WithPredicates(metrics.predicates(r.Name, apiServerSource, nil))).
 		WithOptions(controller.Options{LogConstructor: lc}).
 		Complete(r)
 }
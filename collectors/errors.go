@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"errors"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// errSubsChanClosed is logged when the channel of the subscriptions of a collector has been closed.
+var errSubsChanClosed = errors.New("subscriptions channel closed")
+
+// serializationError wraps the error of the serialization of the resource with the given kind and name. It is a
+// terminal error: the reconcile is not retried, the resource is reconciled again once it changes.
+func serializationError(kind string, name types.NamespacedName, err error) error {
+	return reconcile.TerminalError(&events.SerializationError{Kind: kind, Key: name.String(), Err: err})
+}
+
+// pushFailed rolls the cache back to the snapshot taken before the changes made for the events that could not be
+// pushed, so that it keeps matching what the subscribers received. When the queue has been closed since the collector
+// checked it, the events pushed before are lost too and the reconcile is not retried: the consumer of the queue has
+// stopped, e.g. during the shutdown. Any other error is returned.
+func pushFailed(logger logr.Logger, cache *events.Cache, snapshot events.CacheSnapshot, err error) (ctrl.Result, error) {
+	cache.Restore(snapshot)
+	if errors.Is(err, broker.ErrQueueClosed) {
+		logger.V(2).Info("queue closed, rolled back the cache", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, err
+}
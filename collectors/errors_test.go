@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileSerializationError(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	cause := errors.New("unsupported value")
+	handler := func(_ logr.Logger, _ *events.Resource, _ *metav1.PartialObjectMetadata) error {
+		return cause
+	}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector", WithFieldsHandler(handler))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	_, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)})
+	var serializationErr *events.SerializationError
+	if !errors.As(err, &serializationErr) {
+		t.Fatalf("expected a serialization error, got %v", err)
+	}
+	if serializationErr.Kind != resource.Deployment || serializationErr.Key != "default/dpl" ||
+		!errors.Is(err, cause) {
+		t.Errorf("unexpected serialization error %+v", serializationErr)
+	}
+	// The reconcile is not retried, it would fail again until the resource changes.
+	if !errors.Is(err, reconcile.TerminalError(nil)) {
+		t.Errorf("expected a terminal error, got %v", err)
+	}
+	if len(queue.evts) != 0 {
+		t.Errorf("expected no event, got %v", queue.evts)
+	}
+}
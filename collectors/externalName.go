@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// ExternalNameNamespace sends the ExternalName services to the nodes running pods in their namespace.
+	ExternalNameNamespace = "namespace"
+	// ExternalNameCluster sends the ExternalName services to all the nodes of the cluster.
+	ExternalNameCluster = "cluster"
+	// ExternalNameNone does not send the ExternalName services to any node.
+	ExternalNameNone = "none"
+)
+
+// validExternalNameNodes returns an error if the given nodes of the ExternalName services are unknown. The empty
+// value stands for ExternalNameNamespace.
+func validExternalNameNodes(nodes string) error {
+	switch nodes {
+	case "", ExternalNameNamespace, ExternalNameCluster, ExternalNameNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown nodes %q, expected one of %s, %s or %s", nodes, ExternalNameNamespace,
+			ExternalNameCluster, ExternalNameNone)
+	}
+}
+
+// isExternalName returns true if the service is an alias of an external name, served by no pod.
+func isExternalName(svc *corev1.Service) bool {
+	return svc.Spec.Type == corev1.ServiceTypeExternalName
+}
+
+// isHeadless returns true if the service has no cluster IP, its name resolving to the IPs of its pods.
+func isHeadless(svc *corev1.Service) bool {
+	return svc.Spec.ClusterIP == corev1.ClusterIPNone
+}
+
+// serviceSpec returns the fields of the spec of the service sent in the events: the target of the ExternalName
+// services and the missing cluster IP of the headless ones. It returns an empty string for the other services.
+func serviceSpec(svc *corev1.Service) (string, error) {
+	var spec map[string]interface{}
+	switch {
+	case isExternalName(svc):
+		spec = map[string]interface{}{"type": string(svc.Spec.Type), "externalName": svc.Spec.ExternalName}
+	case isHeadless(svc):
+		spec = map[string]interface{}{"type": string(svc.Spec.Type), "clusterIP": corev1.ClusterIPNone}
+	default:
+		return "", nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// externalNameNodes returns the nodes where the ExternalName service is sent: the nodes running pods in its
+// namespace, all the nodes or none of them, as configured.
+func (r *ServiceCollector) externalNameNodes(ctx context.Context, logger logr.Logger, svc *corev1.Service) ([]string, error) {
+	switch r.externalNameScope {
+	case ExternalNameNone:
+		return nil, nil
+	case ExternalNameCluster:
+		nodes, err := clusterNodes(ctx, r.Client)
+		if err != nil {
+			logger.Error(err, "unable to list nodes related to resource")
+			return nil, err
+		}
+		return nodes, nil
+	default:
+		listOpts := &client.ListOptions{}
+		selection, selected := liveSelection("all", func(*corev1.Pod) bool { return true }, r.includeTerminated)
+		nodes, err := podNodes(ctx, r.Client, r.nodesMemo, r.metrics, svc.Namespace, selectorKey(selection, listOpts), selected, listOpts)
+		if err != nil {
+			logger.Error(err, "unable to list pods related to resource", "in namespace", svc.Namespace)
+			return nil, err
+		}
+		return nodes, nil
+	}
+}
+
+// clusterNodes returns the names of all the nodes in the cache.
+func clusterNodes(ctx context.Context, cl client.Reader) ([]string, error) {
+	gvk, err := resource.GroupVersionKind(resource.Node)
+	if err != nil {
+		return nil, err
+	}
+	list := &metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := cl.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]string, 0, len(list.Items))
+	for i := range list.Items {
+		nodes = append(nodes, list.Items[i].Name)
+	}
+	return nodes, nil
+}
+
+// externalNameServices returns the ExternalName services in the given namespace, all the namespaces if empty.
+func externalNameServices(ctx context.Context, cl client.Reader, namespace string) ([]types.NamespacedName, error) {
+	services := corev1.ServiceList{}
+	if err := cl.List(ctx, &services, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	var names []types.NamespacedName
+	for i := range services.Items {
+		if isExternalName(&services.Items[i]) {
+			names = append(names, client.ObjectKeyFromObject(&services.Items[i]))
+		}
+	}
+	return names, nil
+}
+
+// externalNameDispatcher returns the dispatcher reconciling the ExternalName services when the nodes they are sent to
+// change: it watches the pods for the services sent to the nodes of their namespace, the nodes for the ones sent to
+// all the nodes. It returns nil if the ExternalName services are not sent to any node.
+func (r *ServiceCollector) externalNameDispatcher() *relatedDispatcher {
+	d := &relatedDispatcher{
+		Client:          r.Client,
+		Name:            r.name + "-external-name-dispatcher",
+		Kind:            resource.Service,
+		CollectorSource: r.relatedChan,
+		metrics:         r.metrics,
+	}
+	switch r.externalNameScope {
+	case ExternalNameNone:
+		return nil
+	case ExternalNameCluster:
+		d.ObjectKind = resource.Node
+		// Only the nodes joining or leaving the cluster change the nodes of the services.
+		d.Predicate = predicate.Funcs{UpdateFunc: func(event.UpdateEvent) bool { return false }}
+		d.Related = func(ctx context.Context, cl client.Reader, _ ctrl.Request) ([]types.NamespacedName, error) {
+			return externalNameServices(ctx, cl, "")
+		}
+	default:
+		d.Object, d.ObjectKind = &corev1.Pod{}, resource.Pod
+		d.Predicate = predicate.Funcs{UpdateFunc: func(e event.UpdateEvent) bool { return podNodesChanged(e.ObjectOld, e.ObjectNew) }}
+		d.Related = func(ctx context.Context, cl client.Reader, req ctrl.Request) ([]types.NamespacedName, error) {
+			return externalNameServices(ctx, cl, req.Namespace)
+		}
+	}
+	return d
+}
@@ -0,0 +1,232 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestExternalNameNodes(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		// The selector of the ExternalName services is ignored.
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "db.example.com",
+			Selector: map[string]string{"app": "db"}},
+	}
+	pod := func(namespace, name, node, ip string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": "db"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: ip},
+		}
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c"}},
+		pod("default", "web", "node-a", "10.0.0.1"),
+		// The pods without an IP are related to the ExternalName services as well.
+		pod("default", "job", "node-b", ""),
+		pod("other", "web", "node-c", "10.0.0.2"),
+	).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	tests := []struct {
+		nodes string
+		want  []string
+	}{
+		{nodes: "", want: []string{"node-a", "node-b"}},
+		{nodes: ExternalNameNamespace, want: []string{"node-a", "node-b"}},
+		{nodes: ExternalNameCluster, want: []string{"node-a", "node-b", "node-c"}},
+		{nodes: ExternalNameNone},
+	}
+	for _, tt := range tests {
+		collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+			WithExternalNameNodes(tt.nodes))
+		_, nodes, err := collector.getSubscribers(context.Background(), collector.logger, svc)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.nodes, err)
+		}
+		sort.Strings(nodes)
+		if !reflect.DeepEqual(nodes, tt.want) {
+			t.Errorf("%q: expected the service to be related to nodes %v, got %v", tt.nodes, tt.want, nodes)
+		}
+	}
+
+	// The ExternalName services do not select the pods matching their selector.
+	selecting, err := selectingServices(context.Background(), cl, pod("default", "web", "node-a", "10.0.0.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(selecting) != 0 {
+		t.Errorf("expected the pod to be selected by no service, got %v", selecting)
+	}
+}
+
+func TestServiceTypeTransitions(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Selector: map[string]string{"app": "test"}},
+	}
+	pod := func(name, node string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc,
+		pod("selected", "node-a", map[string]string{"app": "test"}),
+		pod("other", "node-b", map[string]string{"app": "other"}),
+	).Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector")
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b")
+
+	type sent struct {
+		evtType string
+		spec    string
+	}
+	reconcile := func(step string, want map[string]sent) {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		got := make(map[string]sent)
+		for _, evt := range queue.evts {
+			for sub := range evt.Subscribers() {
+				got[sub] = sent{evtType: evt.Type(), spec: evt.GRPCMessage().GetSpec()}
+			}
+		}
+		queue.evts = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+	update := func(mutate func(spec *corev1.ServiceSpec)) {
+		t.Helper()
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(svc), svc); err != nil {
+			t.Fatalf("unable to get service: %v", err)
+		}
+		mutate(&svc.Spec)
+		if err := cl.Update(ctx, svc); err != nil {
+			t.Fatalf("unable to update service: %v", err)
+		}
+	}
+
+	// The headless services are sent to the nodes of the pods they select, flagged by their missing cluster IP.
+	headless := `{"clusterIP":"None","type":""}`
+	reconcile("headless", map[string]sent{"subscriber-a": {evtType: "Create", spec: headless}})
+
+	// Once turned in an ExternalName service, it is sent to the nodes running pods in its namespace with its target.
+	update(func(spec *corev1.ServiceSpec) {
+		*spec = corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "db.example.com"}
+	})
+	externalName := `{"externalName":"db.example.com","type":"ExternalName"}`
+	reconcile("external name", map[string]sent{
+		"subscriber-a": {evtType: "Update", spec: externalName},
+		"subscriber-b": {evtType: "Create", spec: externalName},
+	})
+
+	// A change of the target is propagated to all the nodes.
+	update(func(spec *corev1.ServiceSpec) { spec.ExternalName = "db.example.org" })
+	reconcile("target changed", map[string]sent{
+		"subscriber-a": {evtType: "Update", spec: strings.ReplaceAll(externalName, ".com", ".org")},
+		"subscriber-b": {evtType: "Update", spec: strings.ReplaceAll(externalName, ".com", ".org")},
+	})
+
+	// Back to a headless service, the nodes of the pods it does not select receive a Delete event.
+	update(func(spec *corev1.ServiceSpec) {
+		*spec = corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Selector: map[string]string{"app": "test"}}
+	})
+	reconcile("headless again", map[string]sent{
+		"subscriber-a": {evtType: "Update", spec: headless},
+		"subscriber-b": {evtType: "Delete"},
+	})
+}
+
+func TestExternalNameDispatcher(t *testing.T) {
+	service := func(namespace, name string, serviceType corev1.ServiceType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.ServiceSpec{Type: serviceType},
+		}
+	}
+	cl := fake.NewClientBuilder().WithObjects(
+		service("default", "db", corev1.ServiceTypeExternalName),
+		service("default", "web", corev1.ServiceTypeClusterIP),
+		service("other", "cache", corev1.ServiceTypeExternalName),
+	).Build()
+
+	tests := []struct {
+		nodes string
+		kind  string
+		want  []string
+	}{
+		{nodes: ExternalNameNamespace, kind: resource.Pod, want: []string{"default/db"}},
+		{nodes: ExternalNameCluster, kind: resource.Node, want: []string{"default/db", "other/cache"}},
+		{nodes: ExternalNameNone},
+	}
+	for _, tt := range tests {
+		collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+			WithExternalNameNodes(tt.nodes))
+		d := collector.externalNameDispatcher()
+		if tt.kind == "" {
+			if d != nil {
+				t.Errorf("%q: expected no dispatcher", tt.nodes)
+			}
+			continue
+		}
+		if d.ObjectKind != tt.kind {
+			t.Errorf("%q: expected the dispatcher to watch %s, got %s", tt.nodes, tt.kind, d.ObjectKind)
+		}
+
+		// The dispatcher is reconciled with the request of the changed pod, or node.
+		ch := make(chan event.GenericEvent, 10)
+		d.CollectorSource = ch
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "changed"}}
+		if _, err := d.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.nodes, err)
+		}
+		close(ch)
+		var got []string
+		for evt := range ch {
+			got = append(got, client.ObjectKeyFromObject(evt.Object).String())
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected the services %v to be triggered, got %v", tt.nodes, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// isIgnored returns true if the object has been excluded from the metadata collection using the
+// ignore annotation.
+func isIgnored(obj metav1.Object) bool {
+	return obj.GetAnnotations()[consts.IgnoreAnnotation] == "true"
+}
+
+// selectors holds the label and annotation selectors of a collector, nil when not set, and the patterns of the
+// excluded names.
+type selectors struct {
+	labels      labels.Selector
+	annotations labels.Selector
+	names       namePatterns
+}
+
+// objectFilter excludes from the metadata collection the ignored objects, the objects whose name matches the
+// excluded patterns and, when the selectors are set, the objects whose labels or annotations do not match them. The
+// selectors and the patterns can be replaced at runtime.
+type objectFilter struct {
+	selectors atomic.Pointer[selectors]
+	// mu serializes the replacements of the selectors and of the patterns.
+	mu sync.Mutex
+}
+
+// newObjectFilter returns a filter using the given selectors and excluded name patterns.
+func newObjectFilter(labelSelector, annotationSelector labels.Selector, excludedNames []string) *objectFilter {
+	f := &objectFilter{}
+	f.selectors.Store(&selectors{labels: labelSelector, annotations: annotationSelector, names: excludedNames})
+	return f
+}
+
+// set replaces the selectors of the filter.
+func (f *objectFilter) set(labelSelector, annotationSelector labels.Selector) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sel := *f.selectors.Load()
+	sel.labels, sel.annotations = labelSelector, annotationSelector
+	f.selectors.Store(&sel)
+}
+
+// setExcludedNames replaces the patterns of the excluded names of the filter.
+func (f *objectFilter) setExcludedNames(patterns []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sel := *f.selectors.Load()
+	sel.names = patterns
+	f.selectors.Store(&sel)
+}
+
+// excluded returns true if the object is excluded from the metadata collection.
+func (f *objectFilter) excluded(obj metav1.Object) bool {
+	if isIgnored(obj) {
+		return true
+	}
+	sel := f.selectors.Load()
+	if sel.names.matches(obj.GetNamespace(), obj.GetName()) {
+		return true
+	}
+	if sel.labels != nil && !sel.labels.Matches(labels.Set(obj.GetLabels())) {
+		return true
+	}
+	return sel.annotations != nil && !sel.annotations.Matches(labels.Set(obj.GetAnnotations()))
+}
+
+// ignoreFilter returns a predicate that filters out the events for the excluded objects. Updates are let through
+// when the object was not excluded before or is not excluded anymore, so that the reconciler can send the Delete
+// events when the object gets excluded and the Create events when it gets selected again. Deletes are always let
+// through, the reconciler knows if the object has been sent to any subscriber. The generic events, triggered by the
+// dispatcher and the related resources, only carry the name of the object: the selectors are checked by the
+// reconciler. So are the excluded names, for the resyncs to reach the objects cached before they got excluded.
+func ignoreFilter(filter *objectFilter) predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return !filter.excluded(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !filter.excluded(e.ObjectOld) || !filter.excluded(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return !isIgnored(e.Object)
+		},
+	}
+}
+
+// eventFilters returns the predicates filtering the events reconciled by a collector: the excluded objects, the
+// objects out of the watched namespaces, if set, and the predicates configured with WithPredicates.
+func eventFilters(filter *objectFilter, namespaces []string, clusterScoped bool,
+	predicates []predicate.Predicate) []predicate.Predicate {
+	filters := []predicate.Predicate{ignoreFilter(filter)}
+	if len(namespaces) > 0 {
+		filters = append(filters, namespaceScope(namespaces, clusterScoped))
+	}
+	return append(filters, predicates...)
+}
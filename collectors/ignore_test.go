@@ -0,0 +1,337 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker/brokertest"
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// recordingQueue records the events pushed by the collectors.
+type recordingQueue struct {
+	evts []events.Interface
+}
+
+func (q *recordingQueue) Push(evt events.Interface) error {
+	q.evts = append(q.evts, evt)
+	return nil
+}
+
+func (q *recordingQueue) Pop(_ context.Context) events.Interface {
+	return nil
+}
+
+// pop returns the types of the recorded events and resets the queue.
+func (q *recordingQueue) pop() []string {
+	types := make([]string, 0, len(q.evts))
+	for _, evt := range q.evts {
+		types = append(types, evt.Type())
+	}
+	q.evts = nil
+	return types
+}
+
+func TestIgnoreAnnotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	br := brokertest.NewBroker()
+	cache := events.NewCache()
+	collector := NewServiceCollector(cl, br, cache, "service-collector", WithSubscribersChan(br.SubscribersChan()))
+	started := make(chan error, 1)
+	go func() { started <- collector.Start(ctx) }()
+	// The reconciles are triggered through the dispatcher, as the controller does.
+	go func() {
+		for evt := range collector.dispatcherChan {
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(evt.Object)}
+			if _, err := collector.Reconcile(ctx, req); err != nil && ctx.Err() == nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}()
+
+	uid, err := br.Subscribe(ctx, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(step string, evtType string) {
+		t.Helper()
+		if _, err := br.WaitForEvent(resource.Service, evtType, "node", 5*time.Second); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+	// update updates the service and triggers its reconcile.
+	update := func(step string, annotations map[string]string) {
+		t.Helper()
+		svc.Annotations = annotations
+		if err := cl.Update(ctx, svc); err != nil {
+			t.Fatalf("%s: unable to update service: %v", step, err)
+		}
+		collector.dispatcherChan <- event.GenericEvent{Object: svc}
+	}
+
+	waitFor("subscribed", events.Create)
+
+	// Adding the annotation deletes the resource from the subscribers and the cache.
+	update("annotation added", map[string]string{consts.IgnoreAnnotation: "true"})
+	waitFor("annotation added", events.Delete)
+	if cache.Has(cache.Key(resource.Service, client.ObjectKeyFromObject(svc))) {
+		t.Fatal("expected the ignored resource to be removed from the cache")
+	}
+
+	// Removing the annotation sends the resource again.
+	update("annotation removed", nil)
+	waitFor("annotation removed", events.Create)
+
+	if err := br.Unsubscribe(ctx, uid); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-started; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestIgnoreFilter(t *testing.T) {
+	ignored := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "ignored",
+		Annotations: map[string]string{consts.IgnoreAnnotation: "true"},
+	}}
+	collected := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "collected"}}
+	p := ignoreFilter(newObjectFilter(nil, nil, nil))
+
+	if p.Create(event.CreateEvent{Object: ignored}) {
+		t.Error("expected create events for ignored objects to be filtered out")
+	}
+	if p.Update(event.UpdateEvent{ObjectOld: ignored, ObjectNew: ignored}) {
+		t.Error("expected update events for objects that stay ignored to be filtered out")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: collected, ObjectNew: ignored}) {
+		t.Error("expected update events adding the annotation to be reconciled")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: ignored, ObjectNew: collected}) {
+		t.Error("expected update events removing the annotation to be reconciled")
+	}
+	if !p.Delete(event.DeleteEvent{Object: ignored}) {
+		t.Error("expected delete events to be reconciled")
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid",
+			Labels: map[string]string{"tier": "web"}, Annotations: map[string]string{"team": "a"}},
+		Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector",
+		WithLabelSelector(labels.SelectorFromSet(labels.Set{"tier": "web"})),
+		WithAnnotationSelector(labels.SelectorFromSet(labels.Set{"team": "a"})))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+	update := func(step string, mutate func(), want ...string) {
+		t.Helper()
+		mutate()
+		if err := cl.Update(ctx, svc); err != nil {
+			t.Fatalf("%s: unable to update service: %v", step, err)
+		}
+		if _, err := collector.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := queue.pop(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	update("selected", func() {}, events.Create)
+	update("label not matching", func() { svc.Labels["tier"] = "db" }, events.Delete)
+	update("label matching again", func() { svc.Labels["tier"] = "web" }, events.Create)
+	update("annotation not matching", func() { svc.Annotations = nil }, events.Delete)
+
+	// The generic events carry only the name of the objects, the selectors are checked by the reconciler.
+	p := ignoreFilter(collector.filter)
+	if !p.Generic(event.GenericEvent{Object: &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc"}}}) {
+		t.Error("expected generic events to be reconciled")
+	}
+	if p.Create(event.CreateEvent{Object: svc}) {
+		t.Error("expected create events for objects not matching the selectors to be filtered out")
+	}
+}
+
+func TestSetSelectors(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid",
+			Labels: map[string]string{"tier": "web"}},
+		Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector")
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+
+	// setSelectors replaces the selector and reconciles the service as the resync requested by the collector does.
+	setSelectors := func(step string, selector labels.Selector, want ...string) {
+		t.Helper()
+		collector.SetSelectors(selector, nil)
+		select {
+		case <-collector.resyncRequests:
+		default:
+			t.Fatalf("%s: expected a resync to be requested", step)
+		}
+		if _, err := collector.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := queue.pop(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	setSelectors("no selector", nil, events.Create)
+	setSelectors("not matching", labels.SelectorFromSet(labels.Set{"tier": "db"}), events.Delete)
+	setSelectors("matching", labels.SelectorFromSet(labels.Set{"tier": "web"}), events.Create)
+
+	// The requests received while a resync is pending are collapsed in it.
+	collector.SetSelectors(nil, nil)
+	collector.SetSelectors(nil, nil)
+	if len(collector.resyncRequests) != 1 {
+		t.Errorf("expected a single pending resync, got %d", len(collector.resyncRequests))
+	}
+}
+
+func TestExcludedNames(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	canary := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc-canary", Namespace: "default", UID: "canary-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, canary, pod).Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector", WithExcludedNames("*-canary"))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	// The events of the excluded services are filtered out, the generic ones are checked by the reconciler.
+	p := ignoreFilter(collector.filter)
+	if p.Create(event.CreateEvent{Object: canary}) {
+		t.Error("expected the create events of the excluded services to be filtered out")
+	}
+	if !p.Create(event.CreateEvent{Object: svc}) {
+		t.Error("expected the create events of the other services to be reconciled")
+	}
+	if !p.Generic(event.GenericEvent{Object: canary}) {
+		t.Error("expected generic events to be reconciled")
+	}
+
+	reconcile := func(step string, obj client.Object, want ...string) {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := queue.pop(); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+	reconcile("excluded", canary)
+	reconcile("not excluded", svc, events.Create)
+
+	// The services cached before being excluded are deleted from the subscribers.
+	collector.SetExcludedNames([]string{"default/svc"})
+	select {
+	case <-collector.resyncRequests:
+	default:
+		t.Fatal("expected a resync to be requested")
+	}
+	reconcile("newly excluded", svc, events.Delete)
+	reconcile("no longer excluded", canary, events.Create)
+}
+
+func TestKeepAnnotationsTransformer(t *testing.T) {
+	transform := KeepAnnotationsTransformer(PartialObjectTransformer(logr.Discard()), []string{"team", "missing"})
+	obj, err := transform(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+		Name:        "dpl",
+		Annotations: map[string]string{"team": "a", "other": "value"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := obj.(metav1.Object).GetAnnotations(); !reflect.DeepEqual(got, map[string]string{"team": "a"}) {
+		t.Errorf("expected only the selected annotations to be kept, got %v", got)
+	}
+}
+
+func TestFilterOutMetaFieldsKeepsIgnoreAnnotation(t *testing.T) {
+	meta := metav1.ObjectMeta{Annotations: map[string]string{
+		consts.IgnoreAnnotation: "true",
+		"other":                 "value",
+	}}
+	filterOutMetaFields(&meta)
+	if len(meta.Annotations) != 1 || !isIgnored(&meta) {
+		t.Errorf("expected only the ignore annotation to be kept, got %v", meta.Annotations)
+	}
+}
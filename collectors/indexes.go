@@ -19,19 +19,93 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
-	nodeNameIndex = "spec.nodeName"
-	podPrefixName = "metadata.generateName"
+	nodeNameIndex        = "spec.nodeName"
+	podPrefixName        = "metadata.generateName"
+	serviceSelectorIndex = "spec.selector"
 )
 
+// Indexer is a field indexer registered on the cache of the manager. The collectors declare the indexers they need
+// to run their indexed list queries, and the indexers are registered before the collectors are set up.
+type Indexer struct {
+	// Object is the kind of objects indexed.
+	Object client.Object
+	// Field is the name of the index, used in the field selectors of the list queries.
+	Field string
+	// ExtractValue returns the values of the index for an object.
+	ExtractValue client.IndexerFunc
+}
+
+// key identifies the indexer: two indexers on the same kind of objects and field are the same indexer.
+func (i Indexer) key() string {
+	return fmt.Sprintf("%T/%s", i.Object, i.Field)
+}
+
+var (
+	// PodByNodeIndexer indexes the pods by the node where they have been scheduled. It is needed by all the
+	// collectors to dispatch the resources related to a node to its new subscribers.
+	PodByNodeIndexer = Indexer{Object: &corev1.Pod{}, Field: nodeNameIndex, ExtractValue: podByNode}
+	// PodByPrefixNameIndexer indexes the pods by their generated name prefix. It is needed by the collectors of the
+	// owners of the pods listing them by name.
+	PodByPrefixNameIndexer = Indexer{Object: &corev1.Pod{}, Field: podPrefixName, ExtractValue: podByPrefixName}
+	// ServiceBySelectorIndexer indexes the services by a label of their selector. It is needed by the collectors
+	// looking up the services selecting a pod.
+	ServiceBySelectorIndexer = Indexer{Object: &corev1.Service{}, Field: serviceSelectorIndex, ExtractValue: serviceBySelector}
+)
+
+// IndexRegistry registers the indexers on a field indexer. An indexer already registered is not registered again,
+// so the collectors can declare the indexers they need regardless of the other collectors.
+type IndexRegistry struct {
+	mutex      sync.Mutex
+	fi         client.FieldIndexer
+	registered map[string]struct{}
+}
+
+// NewIndexRegistry returns an IndexRegistry for the given field indexer, usually the one of the manager.
+func NewIndexRegistry(fi client.FieldIndexer) *IndexRegistry {
+	return &IndexRegistry{
+		fi:         fi,
+		registered: make(map[string]struct{}),
+	}
+}
+
+// Register registers the given indexers, skipping the ones already registered.
+func (r *IndexRegistry) Register(ctx context.Context, indexers ...Indexer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, indexer := range indexers {
+		key := indexer.key()
+		if _, ok := r.registered[key]; ok {
+			continue
+		}
+		if err := r.fi.IndexField(ctx, indexer.Object, indexer.Field, indexer.ExtractValue); err != nil {
+			return fmt.Errorf("unable to register indexer %s: %w", key, err)
+		}
+		r.registered[key] = struct{}{}
+	}
+	return nil
+}
+
+// registerIndexers registers the indexers needed by a collector on the manager, through the given registry. Without
+// a registry, the indexers are registered through a registry dedicated to the collector.
+func registerIndexers(mgr ctrl.Manager, registry *IndexRegistry, indexers []Indexer) error {
+	if registry == nil {
+		registry = NewIndexRegistry(mgr.GetFieldIndexer())
+	}
+	return registry.Register(context.Background(), indexers...)
+}
+
 // IndexPodByNode adds an indexer for bots base on the NodeName where it has been scheduled.
 func IndexPodByNode(ctx context.Context, fi client.FieldIndexer) error {
-	return fi.IndexField(ctx, &corev1.Pod{}, nodeNameIndex, podByNode)
+	return fi.IndexField(ctx, PodByNodeIndexer.Object, PodByNodeIndexer.Field, PodByNodeIndexer.ExtractValue)
 }
 
 func podByNode(o client.Object) []string {
@@ -47,7 +121,7 @@ func podByNode(o client.Object) []string {
 
 // IndexPodByPrefixName adds an indexer for pods based on the pods prefix name.
 func IndexPodByPrefixName(ctx context.Context, fi client.FieldIndexer) error {
-	return fi.IndexField(ctx, &corev1.Pod{}, podPrefixName, podByPrefixName)
+	return fi.IndexField(ctx, PodByPrefixNameIndexer.Object, PodByPrefixNameIndexer.Field, PodByPrefixNameIndexer.ExtractValue)
 }
 
 func podByPrefixName(o client.Object) []string {
@@ -65,3 +139,25 @@ func podByPrefixName(o client.Object) []string {
 	}
 	return []string{}
 }
+
+// serviceBySelector indexes the service by the first label of its selector, in key order. All the pods selected by
+// the service have the label, so the services selecting a pod are found by looking up each label of the pod, and
+// each of them is found only once. The services without a selector do not select any pod and are not indexed.
+func serviceBySelector(o client.Object) []string {
+	svc, ok := o.(*corev1.Service)
+	if !ok || len(svc.Spec.Selector) == 0 {
+		return []string{}
+	}
+	var first string
+	for key := range svc.Spec.Selector {
+		if first == "" || key < first {
+			first = key
+		}
+	}
+	return []string{selectorLabel(first, svc.Spec.Selector[first])}
+}
+
+// selectorLabel returns the value of the service selector index for the given label.
+func selectorLabel(key, value string) string {
+	return key + "=" + value
+}
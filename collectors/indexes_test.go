@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// countingFieldIndexer counts the indexers registered for each field.
+type countingFieldIndexer map[string]int
+
+func (c countingFieldIndexer) IndexField(_ context.Context, _ client.Object, field string, _ client.IndexerFunc) error {
+	c[field]++
+	return nil
+}
+
+func TestIndexRegistryDedup(t *testing.T) {
+	fi := countingFieldIndexer{}
+	registry := NewIndexRegistry(fi)
+
+	pc := NewPodCollector(nil, nil, events.NewCache(), "pod-collector", WithIndexRegistry(registry))
+	rc := NewObjectMetaCollector(nil, nil, events.NewCache(), NewPartialObjectMetadata(resource.ReplicaSet, nil),
+		"replicaset-collector", WithIndexRegistry(registry), WithIndexers(PodByPrefixNameIndexer))
+	for _, indexers := range [][]Indexer{pc.Indexers(), rc.Indexers(), rc.Indexers()} {
+		if err := registry.Register(context.Background(), indexers...); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := countingFieldIndexer{nodeNameIndex: 1, podPrefixName: 1, serviceSelectorIndex: 1}
+	if !reflect.DeepEqual(fi, expected) {
+		t.Errorf("expected each indexer to be registered once, got %v", fi)
+	}
+}
+
+func TestIndexersMatchingFields(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	owned := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-7d4f-x2k9p", Namespace: "default", GenerateName: "app-7d4f-"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	}
+	other := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-5c8b-q7w2z", Namespace: "default", GenerateName: "db-5c8b-"},
+		Spec:       corev1.PodSpec{NodeName: "node-b"},
+	}
+
+	collector := NewObjectMetaCollector(nil, nil, events.NewCache(), NewPartialObjectMetadata(resource.ReplicaSet, nil),
+		"replicaset-collector", WithIndexers(PodByPrefixNameIndexer),
+		WithPodMatchingFields(func(meta *metav1.ObjectMeta) client.ListOption {
+			return &client.MatchingFields{podPrefixName: meta.Name + "-"}
+		}))
+
+	// The client indexes the pods the same way the manager does, using only the indexers declared by the collector.
+	builder := fake.NewClientBuilder().WithObjects(ns, owned, other)
+	for _, indexer := range collector.Indexers() {
+		builder = builder.WithIndex(indexer.Object, indexer.Field, indexer.ExtractValue)
+	}
+	collector.Client = builder.Build()
+
+	_, nodes, err := collector.getSubscribers(context.Background(), logr.Discard(),
+		&metav1.ObjectMeta{Name: "app-7d4f", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"node-a"}) {
+		t.Errorf("expected only the node of the owned pod, got %v", nodes)
+	}
+}
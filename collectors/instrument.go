@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// unknownKind is the kind of the objects whose kind can not be resolved.
+const unknownKind = "unknown"
+
+// callCounter counts the Get and List calls issued by a collector, labeled by kind, verb and source.
+type callCounter struct {
+	calls  *prometheus.CounterVec
+	name   string
+	source string
+	scheme *runtime.Scheme
+}
+
+// count counts a call of the given verb for the given object, or list of objects.
+func (c *callCounter) count(obj runtime.Object, verb string) {
+	kind := unknownKind
+	if gvk, err := gvkForObject(obj, c.scheme); err == nil {
+		kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	c.calls.WithLabelValues(c.name, kind, verb, c.source).Inc()
+}
+
+// gvkForObject returns the group version kind of the object. Without a scheme only the kinds of the partial object
+// metadata can be resolved.
+func gvkForObject(obj runtime.Object, scheme *runtime.Scheme) (schema.GroupVersionKind, error) {
+	if scheme == nil {
+		scheme = runtime.NewScheme()
+	}
+	return apiutil.GVKForObject(obj, scheme)
+}
+
+// schemeOf returns the scheme of the client, nil without a client.
+func schemeOf(cl client.Client) *runtime.Scheme {
+	if cl == nil {
+		return nil
+	}
+	return cl.Scheme()
+}
+
+// instrumentedClient is a client counting the Get and List calls issued through it.
+type instrumentedClient struct {
+	client.Client
+	counter callCounter
+}
+
+// instrumentClient returns the client counting the Get and List calls of the given collector, served by the cache of
+// the informers.
+func instrumentClient(cl client.Client, calls *prometheus.CounterVec, name string) client.Client {
+	if cl == nil {
+		return nil
+	}
+	return &instrumentedClient{
+		Client:  cl,
+		counter: callCounter{calls: calls, name: name, source: cacheSource, scheme: schemeOf(cl)},
+	}
+}
+
+// Get counts the call and gets the object.
+func (c *instrumentedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.counter.count(obj, verbGet)
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+// List counts the call and lists the objects.
+func (c *instrumentedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.counter.count(list, verbList)
+	return c.Client.List(ctx, list, opts...)
+}
+
+// instrumentedReader is a reader counting the Get and List calls issued through it.
+type instrumentedReader struct {
+	client.Reader
+	counter callCounter
+}
+
+// instrumentReader returns the reader counting the Get and List calls of the given collector, served by the
+// api-server. The kinds of the typed objects are resolved through the given scheme.
+func instrumentReader(reader client.Reader, calls *prometheus.CounterVec, name string, scheme *runtime.Scheme) client.Reader {
+	if reader == nil {
+		return nil
+	}
+	return &instrumentedReader{
+		Reader:  reader,
+		counter: callCounter{calls: calls, name: name, source: apiServerSource, scheme: scheme},
+	}
+}
+
+// Get counts the call and gets the object.
+func (r *instrumentedReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	r.counter.count(obj, verbGet)
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+// List counts the call and lists the objects.
+func (r *instrumentedReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	r.counter.count(list, verbList)
+	return r.Reader.List(ctx, list, opts...)
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+)
+
+// jitter returns the duration increased by a random fraction of it, up to the given factor. When many collectors or
+// resources are scheduled with the same delay, the jitter spreads their work over time instead of creating
+// synchronized load spikes on the api-server. The duration is returned as is if the factor is not positive.
+func jitter(d time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return d
+	}
+	return wait.Jitter(d, factor)
+}
+
+// jitteredRateLimiter adds a jitter to the backoff of the wrapped rate limiter, so that the resources failing at the
+// same time are not retried all at once.
+type jitteredRateLimiter struct {
+	ratelimiter.RateLimiter
+	factor float64
+}
+
+// When returns the jittered backoff of the item.
+func (r *jitteredRateLimiter) When(item interface{}) time.Duration {
+	return jitter(r.RateLimiter.When(item), r.factor)
+}
+
+// rateLimiter returns the rate limiter of the retries of the reconciles with the given settings, that adds a jitter
+// with the given factor to the backoff. It returns nil, i.e. the default rate limiter, if the factor is not positive
+// and no setting is set.
+func rateLimiter(factor float64, settings RateLimiterSettings) ratelimiter.RateLimiter {
+	if factor <= 0 && settings.IsZero() {
+		return nil
+	}
+	limiter := newRateLimiter(settings)
+	if factor <= 0 {
+		return limiter
+	}
+	return &jitteredRateLimiter{RateLimiter: limiter, factor: factor}
+}
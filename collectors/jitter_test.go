@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// checkJitter verifies that the sampled delays are spread within [d, d*(1+factor)], and cover the whole range.
+func checkJitter(t *testing.T, sample func() time.Duration, d time.Duration, factor float64) {
+	t.Helper()
+	const samples = 10000
+	upper := time.Duration(float64(d) * (1 + factor))
+	var sum float64
+	lowest, highest := upper, d
+	for i := 0; i < samples; i++ {
+		got := sample()
+		if got < d || got > upper {
+			t.Fatalf("expected the delay to be within [%v, %v], got %v", d, upper, got)
+		}
+		sum += float64(got)
+		lowest = min(lowest, got)
+		highest = max(highest, got)
+	}
+
+	// The jitter is uniform: the mean is halfway through the range, and the samples reach both ends of it.
+	span := float64(upper - d)
+	if mean := sum / samples; mean < float64(d)+0.45*span || mean > float64(d)+0.55*span {
+		t.Errorf("expected the mean delay to be close to %v, got %v", d+time.Duration(span/2), time.Duration(mean))
+	}
+	if float64(lowest-d) > 0.05*span || float64(upper-highest) > 0.05*span {
+		t.Errorf("expected the delays to cover [%v, %v], got [%v, %v]", d, upper, lowest, highest)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	checkJitter(t, func() time.Duration { return jitter(time.Minute, 0.2) }, time.Minute, 0.2)
+
+	if got := jitter(time.Minute, 0); got != time.Minute {
+		t.Errorf("expected no jitter with a zero factor, got %v", got)
+	}
+}
+
+func TestJitteredRateLimiter(t *testing.T) {
+	if rateLimiter(0, RateLimiterSettings{}) != nil {
+		t.Error("expected the default rate limiter with a zero factor")
+	}
+	if _, ok := rateLimiter(0.5, RateLimiterSettings{}).(*jitteredRateLimiter); !ok {
+		t.Error("expected a jittered rate limiter with a positive factor")
+	}
+
+	// The overall rate limit of the default rate limiter is left out, to sample the backoff of the retries only.
+	limiter := &jitteredRateLimiter{
+		RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Minute),
+		factor:      0.5,
+	}
+	checkJitter(t, func() time.Duration {
+		defer limiter.Forget("item")
+		return limiter.When("item")
+	}, time.Second, 0.5)
+
+	// The jitter is added to the exponential backoff of the following retries.
+	checkJitter(t, func() time.Duration {
+		defer limiter.Forget("item")
+		limiter.When("item")
+		return limiter.When("item")
+	}, 2*time.Second, 0.5)
+}
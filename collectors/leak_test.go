@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"go.uber.org/goleak"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// TestManagerStopLeaks starts and stops a manager running the broker and the dispatcher of a collector whose
+// controller never reads the triggers, as when it stops first. No goroutine must survive the manager.
+func TestManagerStopLeaks(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// The manager never reaches the api-server: the dispatcher reads the pods from a fake client.
+	mgr, err := ctrl.NewManager(&rest.Config{Host: "http://127.0.0.1:1"}, ctrl.Options{
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := fake.NewClientBuilder().
+		WithObjects(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		}).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		Build()
+
+	queue := broker.NewBlockingChannel(1)
+	subChan := make(subscriber.SubsChan)
+	br, err := broker.New(logr.Discard(), queue, map[string]subscriber.SubsChan{resource.Pod: subChan},
+		broker.WithAddress("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Add(br); err != nil {
+		t.Fatal(err)
+	}
+	// Nobody reads the triggers of the dispatcher.
+	dispatcherChan := make(chan event.GenericEvent)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subscriber.NewSubscribers(),
+			events.NewCache(), newReplays(), defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- mgr.Start(ctx) }()
+
+	// The in-process subscriber never reads its events, and the dispatcher blocks on the trigger of its pod.
+	if _, err := br.Subscribe(ctx, "node", broker.WithBufferSize(0)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the manager to stop")
+	}
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -47,3 +48,19 @@ func newLogConstructor(log logr.Logger, name, resourceKind string) (logConstruct
 		return log
 	}, nil
 }
+
+// namedLogger returns the given logger named after the collector, or the zero logger if it is not set.
+func namedLogger(logger logr.Logger, name string) logr.Logger {
+	if logger.GetSink() == nil {
+		return logr.Logger{}
+	}
+	return logger.WithName(name)
+}
+
+// baseLogger returns the logger configured with WithLogger, or the one of the manager if not set.
+func baseLogger(configured logr.Logger, mgr ctrl.Manager) logr.Logger {
+	if configured.GetSink() == nil {
+		return mgr.GetLogger()
+	}
+	return configured
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// metaKeys restricts the labels and annotations forwarded in the metadata of the resources to the allowed keys. A nil
+// set forwards all the entries of its field.
+type metaKeys struct {
+	labels      map[string]struct{}
+	annotations map[string]struct{}
+}
+
+// allowedKeys returns the set of the given keys, nil if there are none.
+func allowedKeys(keys []string) map[string]struct{} {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+// filter removes the labels and the annotations not allowed from the metadata, in their unstructured form. A field
+// left without entries is removed, as it is when the object has none.
+func (k metaKeys) filter(meta map[string]interface{}) {
+	filterEntries(meta, "labels", k.labels)
+	filterEntries(meta, "annotations", k.annotations)
+}
+
+// filterEntries removes from the given field of the metadata the entries whose key is not allowed.
+func filterEntries(meta map[string]interface{}, field string, allowed map[string]struct{}) {
+	if allowed == nil {
+		return
+	}
+	entries, _ := meta[field].(map[string]interface{})
+	for key := range entries {
+		if _, ok := allowed[key]; !ok {
+			delete(entries, key)
+		}
+	}
+	if len(entries) == 0 {
+		delete(meta, field)
+	}
+}
+
+// objectMeta returns the given metadata without the labels and annotations not allowed. The metadata are returned as
+// they are if all the keys are allowed.
+func (k metaKeys) objectMeta(meta *metav1.ObjectMeta) *metav1.ObjectMeta {
+	if k.labels == nil && k.annotations == nil {
+		return meta
+	}
+	filtered := meta.DeepCopy()
+	filtered.Labels = filterMap(filtered.Labels, k.labels)
+	filtered.Annotations = filterMap(filtered.Annotations, k.annotations)
+	return filtered
+}
+
+// filterMap removes from the map the entries whose key is not allowed, and returns it, nil if left empty.
+func filterMap(entries map[string]string, allowed map[string]struct{}) map[string]string {
+	if allowed == nil {
+		return entries
+	}
+	for key := range entries {
+		if _, ok := allowed[key]; !ok {
+			delete(entries, key)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries
+}
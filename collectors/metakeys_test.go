@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAllowedMetaKeys(t *testing.T) {
+	objectMeta := metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid",
+		CreationTimestamp: metav1.Now(),
+		OwnerReferences:   []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app-abcde"}},
+		Labels:            map[string]string{"app": "web", "team": "a", "version": "v1"},
+		Annotations:       map[string]string{"owner": "team-a", "secret": "token"},
+	}
+
+	tests := map[string]struct {
+		opts        []CollectorOption
+		labels      map[string]string
+		annotations map[string]string
+	}{
+		"all forwarded": {
+			labels:      objectMeta.Labels,
+			annotations: objectMeta.Annotations,
+		},
+		"allowed labels": {
+			opts:        []CollectorOption{WithAllowedLabels("app", "team", "missing")},
+			labels:      map[string]string{"app": "web", "team": "a"},
+			annotations: objectMeta.Annotations,
+		},
+		"allowed annotations": {
+			opts:   []CollectorOption{WithAllowedAnnotations("owner")},
+			labels: objectMeta.Labels,
+			// The keys not allowed are dropped, the sensitive annotations are never forwarded.
+			annotations: map[string]string{"owner": "team-a"},
+		},
+		"none allowed present": {
+			opts: []CollectorOption{WithAllowedLabels("missing"), WithAllowedAnnotations("missing")},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			obj := NewPartialObjectMetadata(resource.Deployment, nil)
+			obj.ObjectMeta = *objectMeta.DeepCopy()
+			collector := NewObjectMetaCollector(nil, nil, events.NewCache(), obj, "deployment-collector", tt.opts...)
+			res := events.NewResource(resource.Deployment, "uid")
+			if err := collector.objFieldsHandler(context.Background(), logr.Discard(), res, obj, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var meta metav1.ObjectMeta
+			if err := json.Unmarshal([]byte(res.GetMetadata()), &meta); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(meta.Labels, tt.labels) || !reflect.DeepEqual(meta.Annotations, tt.annotations) {
+				t.Errorf("expected labels %v and annotations %v, got %v and %v", tt.labels, tt.annotations,
+					meta.Labels, meta.Annotations)
+			}
+			// The unused fields are still stripped.
+			if !meta.CreationTimestamp.IsZero() || meta.OwnerReferences != nil {
+				t.Errorf("expected the unused fields to be stripped, got %s", res.GetMetadata())
+			}
+			// The structured metadata are filtered too, the object is left untouched.
+			if !reflect.DeepEqual(res.Labels, tt.labels) || !reflect.DeepEqual(res.Annotations, tt.annotations) {
+				t.Errorf("expected the structured labels %v and annotations %v, got %v and %v", tt.labels,
+					tt.annotations, res.Labels, res.Annotations)
+			}
+			if !reflect.DeepEqual(obj.Labels, objectMeta.Labels) || !reflect.DeepEqual(obj.Annotations, objectMeta.Annotations) {
+				t.Errorf("expected the object to be left untouched, got %v", obj.ObjectMeta)
+			}
+		})
+	}
+}
+
+func TestAllowedMetaKeysTruncation(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "pod-uid",
+			Labels: map[string]string{"app": "web"},
+			Annotations: map[string]string{
+				"owner":  "team-a",
+				"config": strings.Repeat("c", 1000),
+				"notes":  strings.Repeat("n", 300),
+			}},
+		Spec: corev1.PodSpec{NodeName: "node"},
+	}
+	truncations := defaultMetrics.metaTruncations.WithLabelValues(resource.Pod)
+
+	// The annotations not allowed are dropped before the size is checked, they do not count as a truncation.
+	collector := NewPodCollector(fake.NewClientBuilder().Build(), nil, events.NewCache(), "allowed-pod-collector",
+		WithAllowedAnnotations("owner", "notes"), WithMaxMetaSize(512, TruncateAnnotations))
+	before := testutil.ToFloat64(truncations)
+	res := events.NewResource(resource.Pod, "pod-uid")
+	if err := collector.objFieldsHandler(context.Background(), logr.Discard(), res, pod.DeepCopy()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"owner": "team-a", "notes": strings.Repeat("n", 300)}
+	if !reflect.DeepEqual(res.Annotations, want) || res.GetMetadata() == "" || strings.Contains(res.GetMetadata(), "config") {
+		t.Errorf("expected only the allowed annotations, got %v in %s", res.Annotations, res.GetMetadata())
+	}
+	if got := testutil.ToFloat64(truncations) - before; got != 0 {
+		t.Errorf("expected no truncation, got %v", got)
+	}
+
+	// The allowed annotations still exceeding the maximum size are truncated.
+	collector = NewPodCollector(fake.NewClientBuilder().Build(), nil, events.NewCache(), "truncated-pod-collector",
+		WithAllowedAnnotations("owner", "notes"), WithMaxMetaSize(256, TruncateAnnotations))
+	res = events.NewResource(resource.Pod, "pod-uid")
+	if err := collector.objFieldsHandler(context.Background(), logr.Discard(), res, pod.DeepCopy()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]string{"owner": "team-a"}; !reflect.DeepEqual(res.Annotations, want) {
+		t.Errorf("expected the largest allowed annotation to be truncated, got %v", res.Annotations)
+	}
+	if got := testutil.ToFloat64(truncations) - before; got != 1 {
+		t.Errorf("expected one truncation, got %v", got)
+	}
+}
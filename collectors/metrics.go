@@ -16,7 +16,12 @@
 package collectors
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/metricsutil"
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -27,6 +32,14 @@ import (
 const (
 	collectorSubsystem = "collector"
 	eventReceivedKey   = "event_api_server_received"
+	resyncsKey         = "resyncs"
+	nodesMemoKey       = "nodes_memo_lookups"
+	collapsedKey       = "requests_collapsed"
+	phaseDurationKey   = "reconcile_phase_duration_seconds"
+	reconcilesKey      = "reconciles"
+	metaTruncationsKey = "meta_truncations"
+	clientCallsKey     = "client_calls"
+	pausedKey          = "paused"
 
 	labelCreate  = "create"
 	labelUpdate  = "update"
@@ -34,38 +47,238 @@ const (
 	labelGeneric = "generic"
 
 	apiServerSource = "api-server"
+	// cacheSource is the source of the client calls served by the cache of the informers.
+	cacheSource = "cache"
+
+	verbGet  = "get"
+	verbList = "list"
+
+	// The phases of a reconcile: the get of the resource, the computation of its relations, i.e. its subscribers
+	// and references, the serialization of its fields and the dispatch of its events.
+	phaseGet       = "get"
+	phaseRelation  = "relation"
+	phaseSerialize = "serialize"
+	phaseDispatch  = "dispatch"
+
+	outcomeSuccess    = "success"
+	outcomeError      = "error"
+	outcomeNotFound   = "not-found"
+	outcomeStaleCache = "stale-cache"
 )
 
-var (
+// collectorMetrics holds the metrics of the collectors. The collectors register them in the controller-runtime
+// registry by default, or in the registry configured with WithMetricsRegisterer.
+type collectorMetrics struct {
 	// ingestedEvents is a prometheus counter metrics which holds the total
 	// number of events received from the api server/external sources per collector. It has three labels. Name label refers
 	// to the collector name, source refers to the source from where we are receiving the events,
 	// and type label refers to the event type, i.e. create, update, delete, generic.
-	ingestedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Namespace: consts.MetricsNamespace,
-		Subsystem: collectorSubsystem,
-		Name:      eventReceivedKey,
-		Help: "Total number of events received from the api-server per collector  Name label refers to the collector" +
-			" name, source refers to the source from where we are receiving the events,and type label refers to the" +
-			" event type, i.e. create, update, delete, generic.",
-	}, []string{"name", "source", "type"})
-)
+	ingestedEvents *prometheus.CounterVec
+	// resyncs is a prometheus counter metrics which holds the total number of periodic
+	// resyncs run per resource kind.
+	resyncs *prometheus.CounterVec
+	// collapsedRequests is a prometheus counter metrics which holds the total number of externally triggered
+	// reconcile requests collapsed by the debouncing per collector. Name label refers to the collector name and
+	// source refers to the source that triggered the requests.
+	collapsedRequests *prometheus.CounterVec
+	// phaseDuration is a prometheus histogram which keeps track of the duration of the phases of the reconciles per
+	// collector. Name label refers to the collector name and phase is either get, relation, serialize or dispatch.
+	phaseDuration *prometheus.HistogramVec
+	// reconciles is a prometheus counter metrics which holds the total number of reconciles per collector and
+	// outcome. The outcome label is either success, error, not-found, for the resources not found in the cache
+	// of the informers, e.g. the deleted ones, or stale-cache, for the ones missing from the cache but still existing
+	// on the api-server.
+	reconciles *prometheus.CounterVec
+	// metaTruncations is a prometheus counter metrics which holds the total number of times the metadata of a
+	// resource have been truncated to fit the maximum size, per resource kind.
+	metaTruncations *prometheus.CounterVec
+	// clientCalls is a prometheus counter metrics which holds the total number of Get and List calls issued by the
+	// collectors. Name label refers to the collector name, kind to the kind of the requested resources, verb is
+	// either get or list and source is either cache, for the calls served by the cache of the informers, or
+	// api-server, for the ones sent to the api-server.
+	clientCalls *prometheus.CounterVec
+	// paused is a prometheus gauge which is set to 1 while a collector is paused at runtime, 0 otherwise. Name label
+	// refers to the collector name.
+	paused *prometheus.GaugeVec
+	// nodesMemoLookups is a prometheus counter metrics which holds the total number of lookups in the
+	// memo of the pods' nodes. The result label is either hit or miss.
+	nodesMemoLookups *prometheus.CounterVec
+}
+
+// newCollectorMetrics returns the metrics of the collectors in the given namespace, not registered in any registry.
+func newCollectorMetrics(namespace string) *collectorMetrics {
+	return &collectorMetrics{
+		ingestedEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      eventReceivedKey,
+			Help: "Total number of events received from the api-server per collector  Name label refers to the collector" +
+				" name, source refers to the source from where we are receiving the events,and type label refers to the" +
+				" event type, i.e. create, update, delete, generic.",
+		}, []string{"name", "source", "type"}),
+		resyncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      resyncsKey,
+			Help:      "Total number of periodic resyncs run per resource kind.",
+		}, []string{"kind"}),
+		collapsedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      collapsedKey,
+			Help: "Total number of externally triggered reconcile requests collapsed by the debouncing per collector. Name" +
+				" label refers to the collector name and source refers to the source that triggered the requests.",
+		}, []string{"name", "source"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      phaseDurationKey,
+			Help: "How long in seconds the phases of the reconciles take per collector. Name label refers to the " +
+				"collector name and phase is either get, relation, serialize or dispatch.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"name", "phase"}),
+		reconciles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      reconcilesKey,
+			Help: "Total number of reconciles per collector. Name label refers to the collector name and outcome is " +
+				"either success, error, not-found or stale-cache.",
+		}, []string{"name", "outcome"}),
+		metaTruncations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      metaTruncationsKey,
+			Help:      "Total number of times the metadata of a resource have been truncated to fit the maximum size per resource kind.",
+		}, []string{"kind"}),
+		clientCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      clientCallsKey,
+			Help: "Total number of Get and List calls issued per collector. Name label refers to the collector name, kind" +
+				" to the kind of the requested resources, verb is either get or list and source is either cache or api-server.",
+		}, []string{"name", "kind", "verb", "source"}),
+		paused: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      pausedKey,
+			Help:      "Whether the collector is paused at runtime, 1 if paused, 0 otherwise. Name label refers to the collector name.",
+		}, []string{"name"}),
+		nodesMemoLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: collectorSubsystem,
+			Name:      nodesMemoKey,
+			Help:      "Total number of lookups in the memo of the pods' nodes. The result label is either hit or miss.",
+		}, []string{"result"}),
+	}
+}
+
+// registerCollectorMetrics registers the metrics of the collectors in the given registry, with the given prefix
+// prepended to their names, and returns them. If the registry already holds them, e.g. registered by another
+// collector, the registered ones are returned. It is safe to be called concurrently. A nil registry, or the
+// controller-runtime one, without prefix returns the default metrics. If some metrics can't be registered, e.g.
+// because the registry holds different metrics with the same names, the metrics are returned along with the error.
+func registerCollectorMetrics(reg prometheus.Registerer, prefix string) (*collectorMetrics, error) {
+	if reg == nil {
+		reg = metrics.Registry
+	}
+	if reg == metrics.Registry && prefix == "" {
+		return defaultMetrics, nil
+	}
+
+	var errs []error
+	m := newCollectorMetrics(metricsutil.Namespace(prefix))
+	registered := &collectorMetrics{
+		ingestedEvents:    metricsutil.RegisterOrGet(reg, m.ingestedEvents, &errs),
+		resyncs:           metricsutil.RegisterOrGet(reg, m.resyncs, &errs),
+		collapsedRequests: metricsutil.RegisterOrGet(reg, m.collapsedRequests, &errs),
+		phaseDuration:     metricsutil.RegisterOrGet(reg, m.phaseDuration, &errs),
+		reconciles:        metricsutil.RegisterOrGet(reg, m.reconciles, &errs),
+		metaTruncations:   metricsutil.RegisterOrGet(reg, m.metaTruncations, &errs),
+		clientCalls:       metricsutil.RegisterOrGet(reg, m.clientCalls, &errs),
+		paused:            metricsutil.RegisterOrGet(reg, m.paused, &errs),
+		nodesMemoLookups:  metricsutil.RegisterOrGet(reg, m.nodesMemoLookups, &errs),
+	}
+	if len(errs) > 0 {
+		return registered, fmt.Errorf("WithMetricsRegisterer: %w", errors.Join(errs...))
+	}
+	return registered, nil
+}
+
+// defaultMetrics are the metrics of the collectors registered in the controller-runtime registry.
+var defaultMetrics = newCollectorMetrics(consts.MetricsNamespace)
 
 func init() {
 	// Register custom metrics with the global prometheus registry
 
-	metrics.Registry.MustRegister(ingestedEvents)
+	metrics.Registry.MustRegister(defaultMetrics.ingestedEvents)
+	metrics.Registry.MustRegister(defaultMetrics.resyncs)
+	metrics.Registry.MustRegister(defaultMetrics.nodesMemoLookups)
+	metrics.Registry.MustRegister(defaultMetrics.collapsedRequests)
+	metrics.Registry.MustRegister(defaultMetrics.phaseDuration)
+	metrics.Registry.MustRegister(defaultMetrics.reconciles)
+	metrics.Registry.MustRegister(defaultMetrics.metaTruncations)
+	metrics.Registry.MustRegister(defaultMetrics.clientCalls)
+	metrics.Registry.MustRegister(defaultMetrics.paused)
+}
+
+// memoLookup counts a lookup in the memo of the pods' nodes.
+func (m *collectorMetrics) memoLookup(hit bool) {
+	if hit {
+		m.nodesMemoLookups.WithLabelValues(labelHit).Inc()
+	} else {
+		m.nodesMemoLookups.WithLabelValues(labelMiss).Inc()
+	}
+}
+
+// reconcilePhases times the phases of a reconcile and records its outcome.
+type reconcilePhases struct {
+	metrics *collectorMetrics
+	name    string
+	phase   string
+	start   time.Time
+	// notFound is set when the reconciled resource has not been found.
+	notFound bool
+	// staleCache is set when the reconciled resource has not been found in the cache, but exists on the api-server.
+	staleCache bool
+}
+
+// startReconcilePhases starts timing a reconcile of the given collector, beginning with the get phase.
+func (m *collectorMetrics) startReconcilePhases(name string) *reconcilePhases {
+	return &reconcilePhases{metrics: m, name: name, phase: phaseGet, start: time.Now()}
+}
+
+// next ends the current phase and starts the given one.
+func (p *reconcilePhases) next(phase string) {
+	now := time.Now()
+	p.metrics.phaseDuration.WithLabelValues(p.name, p.phase).Observe(now.Sub(p.start).Seconds())
+	p.phase = phase
+	p.start = now
+}
+
+// done ends the current phase and records the outcome of the reconcile.
+func (p *reconcilePhases) done(err error) {
+	p.metrics.phaseDuration.WithLabelValues(p.name, p.phase).Observe(time.Since(p.start).Seconds())
+	outcome := outcomeSuccess
+	switch {
+	case err != nil:
+		outcome = outcomeError
+	case p.staleCache:
+		outcome = outcomeStaleCache
+	case p.notFound:
+		outcome = outcomeNotFound
+	}
+	p.metrics.reconciles.WithLabelValues(p.name, outcome).Inc()
 }
 
-// predicatesWithMetrics tracks the number of events received from the api-server.
-func predicatesWithMetrics(collectorName, sourceName string, filter func(object client.Object) bool) predicate.Funcs {
-	createCounter := ingestedEvents.WithLabelValues(collectorName, sourceName, labelCreate)
+// predicates tracks the number of events received from the api-server.
+func (m *collectorMetrics) predicates(collectorName, sourceName string, filter func(object client.Object) bool) predicate.Funcs {
+	createCounter := m.ingestedEvents.WithLabelValues(collectorName, sourceName, labelCreate)
 	createCounter.Add(0)
-	updateCounter := ingestedEvents.WithLabelValues(collectorName, sourceName, labelDelete)
+	updateCounter := m.ingestedEvents.WithLabelValues(collectorName, sourceName, labelDelete)
 	updateCounter.Add(0)
-	deleteCounter := ingestedEvents.WithLabelValues(collectorName, sourceName, labelUpdate)
+	deleteCounter := m.ingestedEvents.WithLabelValues(collectorName, sourceName, labelUpdate)
 	deleteCounter.Add(0)
-	genericCounter := ingestedEvents.WithLabelValues(collectorName, sourceName, labelGeneric)
+	genericCounter := m.ingestedEvents.WithLabelValues(collectorName, sourceName, labelGeneric)
 	genericCounter.Add(0)
 
 	return predicate.Funcs{
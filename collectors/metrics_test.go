@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcilePhases(t *testing.T) {
+	const name = "phases-collector"
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+	collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name)
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	series := testutil.CollectAndCount(defaultMetrics.phaseDuration)
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A resource sent to a subscriber goes through all the phases.
+	if got := testutil.CollectAndCount(defaultMetrics.phaseDuration) - series; got != 4 {
+		t.Errorf("expected the reconcile to time 4 phases, got %d", got)
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues(name, outcomeSuccess)); got != 1 {
+		t.Errorf("expected 1 successful reconcile, got %v", got)
+	}
+
+	missing := types.NamespacedName{Namespace: "default", Name: "missing"}
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: missing}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues(name, outcomeNotFound)); got != 1 {
+		t.Errorf("expected 1 not-found reconcile, got %v", got)
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues(name, outcomeError)); got != 0 {
+		t.Errorf("expected no failed reconciles, got %v", got)
+	}
+}
+
+func TestMetricsRegisterer(t *testing.T) {
+	const name = "registerer-collector"
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(svc).Build()
+	first, second := prometheus.NewRegistry(), prometheus.NewRegistry()
+
+	// Collectors with the same name on separate registries, or sharing one, do not collide.
+	collectors := []*ServiceCollector{
+		NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name, WithMetricsRegisterer(first)),
+		NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name, WithMetricsRegisterer(second)),
+		NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name, WithMetricsRegisterer(second)),
+	}
+	for _, collector := range collectors {
+		if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(collectors[0].metrics.reconciles.WithLabelValues(name, outcomeSuccess)); got != 1 {
+		t.Errorf("expected 1 successful reconcile in the first registry, got %v", got)
+	}
+	if got := testutil.ToFloat64(collectors[1].metrics.reconciles.WithLabelValues(name, outcomeSuccess)); got != 2 {
+		t.Errorf("expected 2 successful reconciles in the shared registry, got %v", got)
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues(name, outcomeSuccess)); got != 0 {
+		t.Errorf("expected no reconciles in the default registry, got %v", got)
+	}
+	if got, err := testutil.GatherAndCount(second, "meta_collector_collector_reconciles"); err != nil || got != 1 {
+		t.Errorf("expected the shared registry to expose one series, got %d (%v)", got, err)
+	}
+}
+
+func TestMetricsPrefix(t *testing.T) {
+	const name = "prefixed-collector"
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(svc).Build()
+	reg := prometheus.NewRegistry()
+
+	collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name, WithMetricsRegisterer(reg),
+		WithMetricsPrefix("host"))
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := testutil.GatherAndCount(reg, "host_meta_collector_collector_reconciles"); err != nil || got != 1 {
+		t.Errorf("expected the prefixed metrics to be exposed, got %d (%v)", got, err)
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues(name, outcomeSuccess)); got != 0 {
+		t.Errorf("expected no reconciles in the default metrics, got %v", got)
+	}
+
+	// The metrics colliding with the ones of the embedding program fail the setup of the collector.
+	conflicting := prometheus.NewRegistry()
+	conflicting.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "meta_collector_collector_reconciles"}))
+	collector = NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), name,
+		WithSubscribersChan(make(subscriber.SubsChan)), WithMetricsRegisterer(conflicting))
+	err := collector.SetupWithManager(nil)
+	if err == nil || !strings.Contains(err.Error(), "WithMetricsRegisterer") {
+		t.Fatalf("expected the setup to fail on the conflicting metrics, got %v", err)
+	}
+	// The collector still works with the unregistered metrics.
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDispatcherMetricsRegisterer(t *testing.T) {
+	// The metrics colliding with the ones of the embedding program fail the setup of the dispatchers.
+	conflicting := prometheus.NewRegistry()
+	conflicting.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "host_meta_collector_collector_reconciles"}))
+	for name, setup := range map[string]func() error{
+		"endpoints": func() error {
+			return (&EndpointsDispatcher{MetricsRegisterer: conflicting, MetricsPrefix: "host"}).SetupWithManager(nil)
+		},
+		"endpointslices": func() error {
+			return (&EndpointslicesDispatcher{MetricsRegisterer: conflicting, MetricsPrefix: "host"}).SetupWithManager(nil)
+		},
+		"node cleaner": func() error {
+			return (&NodeCleaner{MetricsRegisterer: conflicting, MetricsPrefix: "host"}).SetupWithManager(nil)
+		},
+		"dry-run subscribers": func() error {
+			return (&DryRunSubscribers{MetricsRegisterer: conflicting, MetricsPrefix: "host"}).SetupWithManager(nil)
+		},
+	} {
+		if err := setup(); err == nil || !strings.Contains(err.Error(), "host_meta_collector_collector_reconciles") {
+			t.Errorf("%s: expected the setup to fail on the conflicting metrics, got %v", name, err)
+		}
+	}
+}
+
+func TestNodesMemoLookups(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(pod).Build()
+	reg := prometheus.NewRegistry()
+	metrics, err := registerCollectorMetrics(reg, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, misses := testutil.ToFloat64(defaultMetrics.nodesMemoLookups.WithLabelValues(labelHit)),
+		testutil.ToFloat64(defaultMetrics.nodesMemoLookups.WithLabelValues(labelMiss))
+
+	// The first lookup misses and memoizes the nodes, the second one hits.
+	memo := NewNodesMemo()
+	for i := 0; i < 2; i++ {
+		nodes, err := podNodes(context.Background(), cl, memo, metrics, "default", "all", func(*corev1.Pod) bool { return true })
+		if err != nil || len(nodes) != 1 {
+			t.Fatalf("expected the node of the pod, got %v (%v)", nodes, err)
+		}
+	}
+	if got, err := testutil.GatherAndCount(reg, "host_meta_collector_collector_nodes_memo_lookups"); err != nil || got != 2 {
+		t.Errorf("expected the lookups to be exposed in the configured registry, got %d series (%v)", got, err)
+	}
+	for result, want := range map[string]float64{labelHit: 1, labelMiss: 1} {
+		if got := testutil.ToFloat64(metrics.nodesMemoLookups.WithLabelValues(result)); got != want {
+			t.Errorf("expected %v lookups with result %s, got %v", want, result, got)
+		}
+	}
+	if testutil.ToFloat64(defaultMetrics.nodesMemoLookups.WithLabelValues(labelHit)) != hits ||
+		testutil.ToFloat64(defaultMetrics.nodesMemoLookups.WithLabelValues(labelMiss)) != misses {
+		t.Error("expected no lookups in the default metrics")
+	}
+}
+
+func TestClientCalls(t *testing.T) {
+	const name = "client-calls-collector"
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cached := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+	apiServer := fake.NewClientBuilder().WithObjects(svc.DeepCopy()).Build()
+	reg := prometheus.NewRegistry()
+	collector := NewServiceCollector(cached, &recordingQueue{}, events.NewCache(), name, WithMetricsRegisterer(reg),
+		WithAPIReader(apiServer))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	calls := func(kind, verb, source string) float64 {
+		return testutil.ToFloat64(collector.metrics.clientCalls.WithLabelValues(name, kind, verb, source))
+	}
+
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := calls("Service", verbGet, cacheSource); got != 1 {
+		t.Errorf("expected 1 get of the service from the cache, got %v", got)
+	}
+	if got := calls("Pod", verbList, cacheSource); got != 1 {
+		t.Errorf("expected 1 list of the pods from the cache, got %v", got)
+	}
+
+	// A resource missing from the cache of the informers is looked up on the api-server.
+	if err := cached.Delete(context.Background(), svc.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := calls("Service", verbGet, cacheSource); got != 2 {
+		t.Errorf("expected 2 gets of the services from the cache, got %v", got)
+	}
+	if got := calls("Service", verbGet, apiServerSource); got != 1 {
+		t.Errorf("expected 1 get of the service from the api-server, got %v", got)
+	}
+}
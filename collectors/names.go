@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"path"
+	"strings"
+)
+
+// namePatterns holds the glob patterns, with the syntax of path.Match, of the names of the objects excluded from the
+// metadata collection, e.g. "*-canary". The patterns holding a slash are matched against the namespace-qualified
+// name of the namespaced objects, e.g. "monitoring/prometheus-*" or "*/prometheus-*", and the other ones against the
+// bare name of any object.
+type namePatterns []string
+
+// matches returns true if the object with the given namespace and name matches any of the patterns. The malformed
+// patterns never match.
+func (p namePatterns) matches(namespace, name string) bool {
+	for _, pattern := range p {
+		subject := name
+		if strings.Contains(pattern, "/") {
+			if namespace == "" {
+				continue
+			}
+			subject = namespace + "/" + name
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import "testing"
+
+func TestNamePatterns(t *testing.T) {
+	patterns := namePatterns{"*-canary", "prometheus-*", "monitoring/grafana-?", "*/debug", "["}
+	for _, tt := range []struct {
+		namespace, name string
+		want            bool
+	}{
+		{"default", "web-canary", true},
+		{"default", "web-canary-1", false},
+		{"", "node-canary", true},
+		{"monitoring", "prometheus-0", true},
+		{"default", "my-prometheus-0", false},
+		{"monitoring", "grafana-1", true},
+		{"monitoring", "grafana-12", false},
+		{"default", "grafana-1", false},
+		{"default", "debug", true},
+		{"kube-system", "debug", true},
+		// The namespace-qualified patterns never match the cluster-scoped objects, nor the bare ones the namespace.
+		{"", "debug", false},
+		{"", "monitoring/grafana-1", false},
+		{"prometheus-ns", "web", false},
+		// The malformed patterns never match.
+		{"default", "[", false},
+	} {
+		if got := patterns.matches(tt.namespace, tt.name); got != tt.want {
+			t.Errorf("%s/%s: expected %v, got %v", tt.namespace, tt.name, tt.want, got)
+		}
+	}
+	if (namePatterns(nil)).matches("default", "web") {
+		t.Error("expected no patterns to match nothing")
+	}
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// defaultDisconnectDelay is the time given to the broker to send the Delete events to the subscribers of a deleted
+// node before they are disconnected, if the NodeCleaner does not configure it.
+const defaultDisconnectDelay = 5 * time.Second
+
+// NodeDisconnector disconnects the subscribers of a node.
+type NodeDisconnector interface {
+	// NodeSubscribers returns the UIDs of the subscribers for the given node.
+	NodeSubscribers(node string) fields.Subscribers
+	// DisconnectNode closes the connections of the subscribers for the given node and returns their UIDs.
+	DisconnectNode(node string) fields.Subscribers
+}
+
+// NodeCleaner cleans up the state related to a node when it is deleted from the cluster. Resources whose pods
+// were force deleted or lost could otherwise keep the subscribers of the dead node forever.
+type NodeCleaner struct {
+	client.Client
+	Name string
+	// Caches are the caches of the collectors, swept when a node is deleted. Their keys must be built by
+	// events.KindKey for the Delete events to be sent.
+	Caches []*events.Cache
+	// Subscribers disconnects the subscribers of the deleted node.
+	Subscribers NodeDisconnector
+	// Queue where the Delete events of the resources related to a deleted node are pushed for its subscribers. If
+	// nil or closed, the subscribers are disconnected right away without sending them any event.
+	Queue broker.Queue
+	// ClusterName stamped on the Delete events, empty if not configured.
+	ClusterName string
+	// DisconnectDelay is the time given to the broker to send the Delete events before the subscribers of the
+	// deleted node are disconnected. Defaults to 5 seconds.
+	DisconnectDelay time.Duration
+	// MetricsRegisterer is the registry where the metrics of the cleaner are registered, the controller-runtime one if
+	// nil.
+	MetricsRegisterer prometheus.Registerer
+	// MetricsPrefix is prepended to the names of the metrics of the cleaner, empty to keep their names.
+	MetricsPrefix string
+
+	mutex sync.Mutex
+	// deleted holds the deleted nodes whose subscribers received the Delete events and wait to be disconnected.
+	deleted map[string]struct{}
+}
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// Reconcile cleans up the state related to a deleted node. If a queue is configured, the subscribers of the node
+// first receive a Delete event for each resource related to it, and are disconnected on the next reconcile, after
+// the disconnect delay. Otherwise they are disconnected right away. In both cases they are removed from the
+// collectors' caches.
+func (r *NodeCleaner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	node, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Node), nil)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.Get(ctx, req.NamespacedName, node)
+	if err == nil {
+		// The node has been recreated in the meantime, its subscribers are left connected.
+		r.setDeleted(req.Name, false)
+		return ctrl.Result{}, nil
+	}
+	if !k8sApiErrors.IsNotFound(err) {
+		logger.Error(err, "unable to get resource")
+		return ctrl.Result{}, err
+	}
+
+	if !broker.IsClosed(r.Queue) && !r.setDeleted(req.Name, true) {
+		subs := r.Subscribers.NodeSubscribers(req.Name)
+		deletes, err := r.sendDeletes(req.Name, subs)
+		if err == nil {
+			logger.Info("node deleted, sending the Delete events to its subscribers", "subscribers", len(subs),
+				"resources", deletes)
+			delay := r.DisconnectDelay
+			if delay <= 0 {
+				delay = defaultDisconnectDelay
+			}
+			return ctrl.Result{RequeueAfter: delay}, nil
+		}
+		// The queue has been closed in the meantime, the subscribers are disconnected right away.
+		logger.V(2).Info("unable to send the Delete events to the subscribers of the node", "reason", err.Error())
+	}
+
+	subs := r.Subscribers.DisconnectNode(req.Name)
+	r.setDeleted(req.Name, false)
+	logger.Info("node deleted, cleaning up its subscribers", "subscribers", len(subs))
+	for _, cache := range r.Caches {
+		cache.DeleteSubscribers(subs)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// setDeleted marks the node as deleted, or unmarks it, and returns whether it was already marked.
+func (r *NodeCleaner) setDeleted(node string, deleted bool) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.deleted[node]
+	if !deleted {
+		delete(r.deleted, node)
+		return ok
+	}
+	if r.deleted == nil {
+		r.deleted = make(map[string]struct{})
+	}
+	r.deleted[node] = struct{}{}
+	return ok
+}
+
+// sendDeletes pushes a Delete event for the given subscribers of the node for each cached resource related to the
+// node, then drops the node from the nodes of the resources and the subscribers from the caches. It returns the
+// number of resources deleted from the subscribers, or the error of the first event that could not be pushed.
+func (r *NodeCleaner) sendDeletes(node string, subs fields.Subscribers) (int, error) {
+	var deletes int
+	for _, cache := range r.Caches {
+		for _, key := range cache.KeysPerNode(node) {
+			entry, ok := cache.Get(key)
+			if !ok {
+				continue
+			}
+			// Only the subscribers that received the resource get the Delete event.
+			if sent := entry.Subs.Intersect(subs); len(sent) > 0 {
+				if kind := events.KindFromKey(key); kind != "" {
+					res := events.NewResource(kind, string(entry.UID))
+					res.SetSubscribers(sent)
+					res.GenerateSubscribers(nil)
+					// The resource did not change, only the dead node stops receiving it.
+					res.SetSequence(entry.Sequence)
+					res.SetCluster(r.ClusterName)
+					res.SetOrigin(r.Name, events.NameFromKey(key))
+					for _, evt := range res.ToEvents() {
+						if evt == nil {
+							continue
+						}
+						if err := r.Queue.Push(evt); err != nil {
+							return deletes, err
+						}
+					}
+					deletes++
+				}
+			}
+			cache.DeleteNodes(key, node)
+		}
+		cache.DeleteSubscribers(subs)
+	}
+	return deletes, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeCleaner) SetupWithManager(mgr ctrl.Manager) error {
+	metrics, err := registerCollectorMetrics(r.MetricsRegisterer, r.MetricsPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to register the metrics: %w", err)
+	}
+	lc, err := newLogConstructor(mgr.GetLogger(), r.Name, resource.Node)
+	if err != nil {
+		return err
+	}
+	node, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Node), nil)
+	if err != nil {
+		return err
+	}
+
+	// Only the deletion of a node is relevant.
+	onlyDelete := predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		UpdateFunc:  func(event.UpdateEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(r.Name).
+		For(node,
+			builder.OnlyMetadata,
+			builder.WithPredicates(onlyDelete, metrics.predicates(r.Name, apiServerSource, nil))).
+		WithOptions(controller.Options{LogConstructor: lc}).
+		Complete(r)
+}
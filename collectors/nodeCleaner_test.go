@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeDisconnector returns the subscribers registered for each node.
+type fakeDisconnector struct {
+	nodes        map[string]fields.Subscribers
+	disconnected []string
+}
+
+func (fd *fakeDisconnector) NodeSubscribers(node string) fields.Subscribers {
+	return fd.nodes[node]
+}
+
+func (fd *fakeDisconnector) DisconnectNode(node string) fields.Subscribers {
+	fd.disconnected = append(fd.disconnected, node)
+	return fd.nodes[node]
+}
+
+func TestNodeCleaner(t *testing.T) {
+	alive := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "alive"}}
+	cl := fake.NewClientBuilder().WithObjects(alive).Build()
+
+	podCache := events.NewCache()
+	podCache.Add("default/pod", &events.CacheEntry{Subs: fields.Subscribers{"dead-sub": {}, "alive-sub": {}}})
+	nsCache := events.NewCache()
+	nsCache.Add("/default", &events.CacheEntry{Subs: fields.Subscribers{"dead-sub": {}}})
+
+	disconnector := &fakeDisconnector{nodes: map[string]fields.Subscribers{
+		"dead":  {"dead-sub": {}},
+		"alive": {"alive-sub": {}},
+	}}
+	cleaner := &NodeCleaner{
+		Client:      cl,
+		Name:        "node-cleaner",
+		Caches:      []*events.Cache{podCache, nsCache},
+		Subscribers: disconnector,
+	}
+
+	// Existing nodes are left untouched.
+	if _, err := cleaner.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "alive"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(disconnector.disconnected) != 0 {
+		t.Fatalf("expected no node to be disconnected, got %v", disconnector.disconnected)
+	}
+
+	if _, err := cleaner.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "dead"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(disconnector.disconnected) != 1 || disconnector.disconnected[0] != "dead" {
+		t.Fatalf("expected the dead node to be disconnected, got %v", disconnector.disconnected)
+	}
+
+	pod, _ := podCache.Get("default/pod")
+	if pod.Subs.Has("dead-sub") || !pod.Subs.Has("alive-sub") {
+		t.Errorf("expected only the subscriber of the dead node to be removed, got %v", pod.Subs)
+	}
+	ns, _ := nsCache.Get("/default")
+	if len(ns.Subs) != 0 {
+		t.Errorf("expected no subscribers left, got %v", ns.Subs)
+	}
+}
+
+func TestNodeCleanerDeleteEvents(t *testing.T) {
+	ctx := context.Background()
+	cl := fake.NewClientBuilder().Build()
+	dead := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dead"}}
+
+	cache := events.NewCache()
+	deployment := cache.Key(resource.Deployment, types.NamespacedName{Namespace: "default", Name: "dpl"})
+	cache.Add(deployment, &events.CacheEntry{UID: "dpl-uid", Subs: fields.Subscribers{"dead-sub": {}, "alive-sub": {}}})
+	cache.AddNodes(deployment, "dead", "alive")
+	namespace := cache.Key(resource.Namespace, types.NamespacedName{Name: "default"})
+	cache.Add(namespace, &events.CacheEntry{UID: "ns-uid", Subs: fields.Subscribers{"alive-sub": {}}})
+	cache.AddNodes(namespace, "alive")
+
+	disconnector := &fakeDisconnector{nodes: map[string]fields.Subscribers{
+		"dead":  {"dead-sub": {}},
+		"alive": {"alive-sub": {}},
+	}}
+	queue := &recordingQueue{}
+	cleaner := &NodeCleaner{
+		Client:          cl,
+		Name:            "node-cleaner",
+		Caches:          []*events.Cache{cache},
+		Subscribers:     disconnector,
+		Queue:           queue,
+		ClusterName:     "prod",
+		DisconnectDelay: time.Minute,
+	}
+
+	// The subscribers of the deleted node receive the Delete events, and are disconnected later.
+	res, err := cleaner.Reconcile(ctx, dead)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.RequeueAfter != time.Minute {
+		t.Errorf("expected a requeue after the disconnect delay, got %v", res.RequeueAfter)
+	}
+	if len(disconnector.disconnected) != 0 {
+		t.Fatalf("expected the subscribers to be disconnected after the delay, got %v", disconnector.disconnected)
+	}
+	if len(queue.evts) != 1 {
+		t.Fatalf("expected a single Delete event, got %v", queue.pop())
+	}
+	evt := queue.evts[0]
+	msg := evt.GRPCMessage()
+	if evt.Type() != events.Delete || msg.GetKind() != resource.Deployment || msg.GetUid() != "dpl-uid" ||
+		msg.GetCluster() != "prod" {
+		t.Errorf("unexpected event %s", evt.String())
+	}
+	if subs := evt.Subscribers(); len(subs) != 1 || !subs.Has("dead-sub") {
+		t.Errorf("expected the Delete event only for the subscriber of the dead node, got %v", subs)
+	}
+
+	// The dead node is dropped from the nodes of the resources, and its subscribers from the caches.
+	if nodes, _ := cache.NodesOf(deployment); !reflect.DeepEqual(nodes, []string{"alive"}) {
+		t.Errorf("expected the deployment to be related only to the alive node, got %v", nodes)
+	}
+	if entry, _ := cache.Get(deployment); entry.Subs.Has("dead-sub") || !entry.Subs.Has("alive-sub") {
+		t.Errorf("expected only the subscriber of the dead node to be removed, got %v", entry.Subs)
+	}
+
+	// The requeued reconcile disconnects the subscribers without sending the events again.
+	queue.pop()
+	if res, err = cleaner.Reconcile(ctx, dead); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.RequeueAfter != 0 || len(queue.evts) != 0 {
+		t.Errorf("expected no requeue and no event, got %v and %v", res.RequeueAfter, queue.pop())
+	}
+	if !reflect.DeepEqual(disconnector.disconnected, []string{"dead"}) {
+		t.Errorf("expected the dead node to be disconnected, got %v", disconnector.disconnected)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"sync"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// nodeLabelsKey is the key of the metadata of the pods where the labels of their node are projected.
+const nodeLabelsKey = "nodeLabels"
+
+// nodeLabels caches the configured labels of the nodes, projected in the metadata of the pods running on them. The
+// labels of a node are read on the first lookup and cached until the node changes. A nil nodeLabels projects nothing.
+type nodeLabels struct {
+	keys   []string
+	mu     sync.Mutex
+	labels map[string]map[string]string
+}
+
+// newNodeLabels returns the cache of the given label keys of the nodes, nil if there are no keys.
+func newNodeLabels(keys []string) *nodeLabels {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &nodeLabels{keys: keys, labels: make(map[string]map[string]string)}
+}
+
+// get returns the configured labels of the node. It returns false if the node is not found, e.g. it has been deleted
+// or is not in the cache yet; the missing nodes are not cached.
+func (n *nodeLabels) get(ctx context.Context, cl client.Reader, node string) (map[string]string, bool, error) {
+	n.mu.Lock()
+	labels, ok := n.labels[node]
+	n.mu.Unlock()
+	if ok {
+		return labels, true, nil
+	}
+
+	gvk, err := resource.GroupVersionKind(resource.Node)
+	if err != nil {
+		return nil, false, err
+	}
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(gvk)
+	if err := cl.Get(ctx, types.NamespacedName{Name: node}, obj); err != nil {
+		if k8sApiErrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	labels = n.project(obj.GetLabels())
+	n.mu.Lock()
+	n.labels[node] = labels
+	n.mu.Unlock()
+	return labels, true, nil
+}
+
+// project returns the configured keys of the given labels. The missing keys are skipped.
+func (n *nodeLabels) project(labels map[string]string) map[string]string {
+	projected := make(map[string]string, len(n.keys))
+	for _, key := range n.keys {
+		if value, ok := labels[key]; ok {
+			projected[key] = value
+		}
+	}
+	return projected
+}
+
+// enrich projects the labels of the node of the pod in its metadata. The pods not scheduled yet, or whose node is
+// not found, are not enriched.
+func (n *nodeLabels) enrich(ctx context.Context, cl client.Reader, meta map[string]interface{}, pod *corev1.Pod) error {
+	if n == nil || pod.Spec.NodeName == "" {
+		return nil
+	}
+	labels, found, err := n.get(ctx, cl, pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.FromContext(ctx).V(3).Info("node not found, the pod is not enriched with its labels", "node", pod.Spec.NodeName)
+		return nil
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	projected := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		projected[key] = value
+	}
+	meta[nodeLabelsKey] = projected
+	return nil
+}
+
+// invalidate removes the labels of the node from the cache.
+func (n *nodeLabels) invalidate(node string) {
+	n.mu.Lock()
+	delete(n.labels, node)
+	n.mu.Unlock()
+}
+
+// podsOnNode invalidates the labels of the node of the request and returns the pods running on it, looked up through
+// the pod node index.
+func (n *nodeLabels) podsOnNode(ctx context.Context, cl client.Reader, req ctrl.Request) ([]types.NamespacedName, error) {
+	n.invalidate(req.Name)
+	pods := corev1.PodList{}
+	if err := cl.List(ctx, &pods, client.MatchingFields{nodeNameIndex: req.Name}); err != nil {
+		return nil, err
+	}
+	names := make([]types.NamespacedName, 0, len(pods.Items))
+	for i := range pods.Items {
+		names = append(names, client.ObjectKeyFromObject(&pods.Items[i]))
+	}
+	return names, nil
+}
+
+// changed returns the predicate letting through the updates of the nodes changing the configured labels.
+func (n *nodeLabels) changed() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldLabels, newLabels := e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()
+			for _, key := range n.keys {
+				oldValue, oldOk := oldLabels[key]
+				newValue, newOk := newLabels[key]
+				if oldOk != newOk || oldValue != newValue {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
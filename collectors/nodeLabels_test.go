@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNodeLabels(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{
+		corev1.LabelTopologyZone:       "zone-a",
+		corev1.LabelInstanceTypeStable: "m5.large",
+		corev1.LabelHostname:           "node-a",
+	}}}
+	pod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "default-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(ns, node, pod("scheduled", "node-a"), pod("orphan", "node-missing")).
+		WithIndex(PodByNodeIndexer.Object, PodByNodeIndexer.Field, PodByNodeIndexer.ExtractValue).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	queue := &recordingQueue{}
+	collector := NewPodCollector(cl, queue, events.NewCache(), "pod-collector",
+		WithNodeLabels(corev1.LabelTopologyZone, corev1.LabelInstanceTypeStable, "missing"))
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-missing", "subscriber-missing")
+
+	// reconcile returns the type of the event sent for the pod and the node labels projected in its metadata.
+	reconcile := func(step, name string) (string, interface{}) {
+		t.Helper()
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+		if _, err := collector.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		defer func() { queue.evts = nil }()
+		if len(queue.evts) == 0 {
+			return "", nil
+		}
+		meta := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(queue.evts[0].GRPCMessage().GetMeta()), &meta); err != nil {
+			t.Fatalf("%s: unable to decode meta: %v", step, err)
+		}
+		return queue.evts[0].Type(), meta[nodeLabelsKey]
+	}
+
+	// Only the configured keys existing on the node are projected.
+	evtType, labels := reconcile("create", "scheduled")
+	want := map[string]interface{}{corev1.LabelTopologyZone: "zone-a", corev1.LabelInstanceTypeStable: "m5.large"}
+	if evtType != events.Create || !reflect.DeepEqual(labels, want) {
+		t.Errorf("expected a Create event with node labels %v, got %q with %v", want, evtType, labels)
+	}
+
+	// The labels of the node are cached until the node changes.
+	node.Labels[corev1.LabelTopologyZone] = "zone-b"
+	if err := cl.Update(ctx, node); err != nil {
+		t.Fatalf("unable to update node: %v", err)
+	}
+	if evtType, _ := reconcile("cached", "scheduled"); evtType != "" {
+		t.Errorf("expected no event while the node labels are cached, got %q", evtType)
+	}
+
+	// The change of the node triggers the pods running on it, which are sent with the new labels.
+	pods, err := collector.nodeLabels.podsOnNode(ctx, cl, ctrl.Request{NamespacedName: types.NamespacedName{Name: "node-a"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []types.NamespacedName{{Namespace: "default", Name: "scheduled"}}; !reflect.DeepEqual(pods, want) {
+		t.Errorf("expected the pods %v to be triggered, got %v", want, pods)
+	}
+	want[corev1.LabelTopologyZone] = "zone-b"
+	if evtType, labels = reconcile("node changed", "scheduled"); evtType != events.Update || !reflect.DeepEqual(labels, want) {
+		t.Errorf("expected an Update event with node labels %v, got %q with %v", want, evtType, labels)
+	}
+
+	// The pods whose node is not found are sent without the node labels.
+	if evtType, labels = reconcile("node not found", "orphan"); evtType != events.Create || labels != nil {
+		t.Errorf("expected a Create event without node labels, got %q with %v", evtType, labels)
+	}
+}
+
+func TestNodeLabelsChanged(t *testing.T) {
+	changed := newNodeLabels([]string{corev1.LabelTopologyZone}).changed()
+	node := func(labels map[string]string) client.Object {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels}}
+	}
+	zoneA := map[string]string{corev1.LabelTopologyZone: "zone-a", "other": "a"}
+
+	tests := map[string]struct {
+		labels map[string]string
+		want   bool
+	}{
+		"unchanged":           {labels: zoneA},
+		"other label changed": {labels: map[string]string{corev1.LabelTopologyZone: "zone-a", "other": "b"}},
+		"label changed":       {labels: map[string]string{corev1.LabelTopologyZone: "zone-b", "other": "a"}, want: true},
+		"label removed":       {labels: map[string]string{"other": "a"}, want: true},
+	}
+	for name, tt := range tests {
+		if got := changed.Update(event.UpdateEvent{ObjectOld: node(zoneA), ObjectNew: node(tt.labels)}); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, got)
+		}
+	}
+}
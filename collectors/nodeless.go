@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import "time"
+
+// nodelessRequeue reconciles again the resources that exist but relate to no node, e.g. a deployment whose pods are
+// not scheduled yet. Such resources are reconciled once their pods land on a node, triggered by the pod collector or
+// the endpoints: the requeue is a safety net for the missed triggers. A non-positive delay disables it.
+type nodelessRequeue time.Duration
+
+// requeueAfter returns the delay after which the resource relating to the given nodes is reconciled again: the
+// shortest between the given one, zero if not requeued, and the delay of the nodeless resources when it relates to no
+// node.
+func (n nodelessRequeue) requeueAfter(nodes []string, requeue time.Duration) time.Duration {
+	if n <= 0 || len(nodes) > 0 {
+		return requeue
+	}
+	if requeue > 0 && requeue < time.Duration(n) {
+		return requeue
+	}
+	return time.Duration(n)
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodelessRequeueAfter(t *testing.T) {
+	tests := map[string]struct {
+		delay   nodelessRequeue
+		nodes   []string
+		requeue time.Duration
+		want    time.Duration
+	}{
+		"disabled":             {delay: 0, want: 0},
+		"disabled with wait":   {delay: 0, requeue: time.Second, want: time.Second},
+		"no node":              {delay: nodelessRequeue(time.Minute), want: time.Minute},
+		"no node shorter wait": {delay: nodelessRequeue(time.Minute), requeue: time.Second, want: time.Second},
+		"no node longer wait":  {delay: nodelessRequeue(time.Minute), requeue: time.Hour, want: time.Minute},
+		"nodes":                {delay: nodelessRequeue(time.Minute), nodes: []string{"node"}, want: 0},
+	}
+	for name, tt := range tests {
+		if got := tt.delay.requeueAfter(tt.nodes, tt.requeue); got != tt.want {
+			t.Errorf("%s: expected requeue after %s, got %s", name, tt.want, got)
+		}
+	}
+}
+
+func TestNodelessRequeueReconcile(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "nodeless", UID: "ns-uid"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "nodeless", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	// The pod is not scheduled yet.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "nodeless", UID: "pod-uid",
+			Labels: map[string]string{"app": "test"}},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(ns, svc, pod).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	newCollectors := func(opt ...CollectorOption) []interface {
+		Reconcile(context.Context, ctrl.Request) (ctrl.Result, error)
+	} {
+		nsCollector := NewObjectMetaCollector(cl, queue, cache, NewPartialObjectMetadata(resource.Namespace, nil),
+			"nodeless-namespace-collector", opt...)
+		nsCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+		svcCollector := NewServiceCollector(cl, queue, cache, "nodeless-service-collector", opt...)
+		svcCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+		return []interface {
+			Reconcile(context.Context, ctrl.Request) (ctrl.Result, error)
+		}{nsCollector, svcCollector}
+	}
+	objs := []client.Object{ns, svc}
+
+	reconcile := func(step string, wantRequeue time.Duration, opt ...CollectorOption) {
+		t.Helper()
+		for i, r := range newCollectors(opt...) {
+			res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(objs[i])})
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", step, err)
+			}
+			if res.RequeueAfter != wantRequeue {
+				t.Errorf("%s: expected %s to be requeued after %s, got %s", step, objs[i].GetName(), wantRequeue,
+					res.RequeueAfter)
+			}
+		}
+		queue.pop()
+	}
+
+	// The resources relating to no node are requeued only when enabled.
+	reconcile("disabled", 0)
+	reconcile("no node", time.Minute, WithNodelessRequeue(time.Minute))
+
+	// Once the pod is scheduled, the resources are no longer requeued.
+	pod.Spec.NodeName = "node"
+	if err := cl.Update(ctx, pod); err != nil {
+		t.Fatalf("unable to update pod: %v", err)
+	}
+	reconcile("scheduled", 0, WithNodelessRequeue(time.Minute))
+
+	// The deleted resources are never requeued.
+	for _, obj := range objs {
+		if err := cl.Delete(ctx, obj); err != nil {
+			t.Fatalf("unable to delete %s: %v", obj.GetName(), err)
+		}
+	}
+	reconcile("deleted", 0, WithNodelessRequeue(time.Minute))
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+const (
+	labelHit  = "hit"
+	labelMiss = "miss"
+)
+
+// NodesMemo memoizes, per namespace, the nodes where the pods matching a selector are running. The collectors
+// of the pods' owners, namespaces and services list the same pods over and over to compute their subscribers;
+// the memo lets them skip the list when no relevant pod changed in the namespace.
+//
+// The memo is invalidated by the event handler of the pod collector, before the reconcile for the pod is
+// enqueued. Hence, the reconciles triggered by a pod change never see the nodes computed before the change.
+// Each namespace has a generation bumped on invalidation: the nodes computed from a list started before an
+// invalidation are discarded instead of being memoized.
+type NodesMemo struct {
+	mutex       sync.Mutex
+	entries     map[string]map[string][]string
+	generations map[string]uint64
+}
+
+// NewNodesMemo returns an empty NodesMemo.
+func NewNodesMemo() *NodesMemo {
+	return &NodesMemo{
+		entries:     make(map[string]map[string][]string),
+		generations: make(map[string]uint64),
+	}
+}
+
+// lookup returns the nodes memoized for the key in the namespace, if any, and the current generation of the
+// namespace to be passed to store.
+func (m *NodesMemo) lookup(namespace, key string) (nodes []string, generation uint64, ok bool) {
+	if m == nil {
+		return nil, 0, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	nodes, ok = m.entries[namespace][key]
+	return nodes, m.generations[namespace], ok
+}
+
+// store memoizes the nodes for the key in the namespace, unless the namespace has been invalidated since the
+// given generation.
+func (m *NodesMemo) store(namespace, key string, generation uint64, nodes []string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.generations[namespace] != generation {
+		return
+	}
+	if _, ok := m.entries[namespace]; !ok {
+		m.entries[namespace] = make(map[string][]string)
+	}
+	m.entries[namespace][key] = nodes
+}
+
+// Invalidate drops the nodes memoized for the namespace.
+func (m *NodesMemo) Invalidate(namespace string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.entries, namespace)
+	m.generations[namespace]++
+}
+
+// selectorKey returns the key identifying in the NodesMemo the pods listed with the given options and
+// accepted by the named selection.
+func selectorKey(selection string, opts *client.ListOptions) string {
+	var labelSelector, fieldSelector string
+	if opts.LabelSelector != nil {
+		labelSelector = opts.LabelSelector.String()
+	}
+	if opts.FieldSelector != nil {
+		fieldSelector = opts.FieldSelector.String()
+	}
+	return selection + ";" + labelSelector + ";" + fieldSelector
+}
+
+// Handler wraps the event handler of the pods, invalidating the memo before delegating the events to it. Updates
+// invalidate the memo only when they change the nodes computed for the pod.
+func (m *NodesMemo) Handler(h handler.EventHandler) handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			m.Invalidate(e.Object.GetNamespace())
+			h.Create(ctx, e, q)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if podNodesChanged(e.ObjectOld, e.ObjectNew) {
+				m.Invalidate(e.ObjectNew.GetNamespace())
+			}
+			h.Update(ctx, e, q)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			m.Invalidate(e.Object.GetNamespace())
+			h.Delete(ctx, e, q)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			h.Generic(ctx, e, q)
+		},
+	}
+}
+
+// podNodesChanged returns true if the update changes any of the fields used to compute the nodes of the pods:
+// the node, the labels matched by the selectors, the IP, the terminal phase and the ignore annotation.
+func podNodesChanged(oldObj, newObj client.Object) bool {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+
+	return oldPod.Spec.NodeName != newPod.Spec.NodeName ||
+		!maps.Equal(oldPod.Labels, newPod.Labels) ||
+		(oldPod.Status.PodIP == "") != (newPod.Status.PodIP == "") ||
+		isTerminated(oldPod) != isTerminated(newPod) ||
+		isIgnored(oldPod) != isIgnored(newPod)
+}
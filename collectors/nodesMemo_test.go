@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestNodesMemo(t *testing.T) {
+	memo := NewNodesMemo()
+
+	if _, _, ok := memo.lookup("default", "key"); ok {
+		t.Fatal("expected a miss on an empty memo")
+	}
+
+	_, generation, _ := memo.lookup("default", "key")
+	memo.store("default", "key", generation, []string{"node-a"})
+	if nodes, _, ok := memo.lookup("default", "key"); !ok || !reflect.DeepEqual(nodes, []string{"node-a"}) {
+		t.Fatalf("expected a hit with nodes [node-a], got %v, %v", nodes, ok)
+	}
+
+	// Invalidating another namespace does not affect the entry.
+	memo.Invalidate("other")
+	if _, _, ok := memo.lookup("default", "key"); !ok {
+		t.Fatal("expected the entry to survive the invalidation of another namespace")
+	}
+
+	memo.Invalidate("default")
+	if _, _, ok := memo.lookup("default", "key"); ok {
+		t.Fatal("expected a miss after the invalidation")
+	}
+
+	// Nodes computed before an invalidation are not memoized.
+	_, generation, _ = memo.lookup("default", "key")
+	memo.Invalidate("default")
+	memo.store("default", "key", generation, []string{"node-a"})
+	if _, _, ok := memo.lookup("default", "key"); ok {
+		t.Fatal("expected stale nodes to be discarded")
+	}
+
+	// A nil memo never memoizes.
+	var nilMemo *NodesMemo
+	nilMemo.store("default", "key", 0, []string{"node-a"})
+	nilMemo.Invalidate("default")
+	if _, _, ok := nilMemo.lookup("default", "key"); ok {
+		t.Fatal("expected a nil memo to always miss")
+	}
+}
+
+func TestNodesMemoHandler(t *testing.T) {
+	memo := NewNodesMemo()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	}
+
+	// The inner handler records whether the entry was still memoized when the event has been delegated to it.
+	var memoized []bool
+	inner := handler.Funcs{
+		CreateFunc: func(context.Context, event.CreateEvent, workqueue.RateLimitingInterface) {
+			_, _, ok := memo.lookup("default", "key")
+			memoized = append(memoized, ok)
+		},
+		UpdateFunc: func(context.Context, event.UpdateEvent, workqueue.RateLimitingInterface) {
+			_, _, ok := memo.lookup("default", "key")
+			memoized = append(memoized, ok)
+		},
+		DeleteFunc: func(context.Context, event.DeleteEvent, workqueue.RateLimitingInterface) {
+			_, _, ok := memo.lookup("default", "key")
+			memoized = append(memoized, ok)
+		},
+	}
+	h := memo.Handler(inner)
+	fill := func() {
+		_, generation, _ := memo.lookup("default", "key")
+		memo.store("default", "key", generation, []string{"node-a"})
+	}
+	ctx := context.Background()
+
+	relabeled := pod.DeepCopy()
+	relabeled.Labels = map[string]string{"app": "other"}
+	ignored := pod.DeepCopy()
+	ignored.Annotations = map[string]string{consts.IgnoreAnnotation: "true"}
+	withIP := pod.DeepCopy()
+	withIP.Status.PodIP = "10.0.0.1"
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	completed := pod.DeepCopy()
+	completed.Status.Phase = corev1.PodSucceeded
+
+	tests := []struct {
+		name      string
+		send      func()
+		wantValid bool
+	}{
+		{name: "create", send: func() { h.Create(ctx, event.CreateEvent{Object: pod}, nil) }},
+		{name: "delete", send: func() { h.Delete(ctx, event.DeleteEvent{Object: pod}, nil) }},
+		{name: "labels changed", send: func() { h.Update(ctx, event.UpdateEvent{ObjectOld: pod, ObjectNew: relabeled}, nil) }},
+		{name: "ignored", send: func() { h.Update(ctx, event.UpdateEvent{ObjectOld: pod, ObjectNew: ignored}, nil) }},
+		{name: "ip assigned", send: func() { h.Update(ctx, event.UpdateEvent{ObjectOld: pod, ObjectNew: withIP}, nil) }},
+		{name: "completed", send: func() { h.Update(ctx, event.UpdateEvent{ObjectOld: running, ObjectNew: completed}, nil) }},
+		{
+			name:      "status changed",
+			send:      func() { h.Update(ctx, event.UpdateEvent{ObjectOld: pod, ObjectNew: running}, nil) },
+			wantValid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memoized = nil
+			fill()
+			tt.send()
+			if len(memoized) != 1 {
+				t.Fatalf("expected the event to be delegated once, got %d", len(memoized))
+			}
+			if memoized[0] != tt.wantValid {
+				t.Errorf("expected the entry to be memoized %v when the event is delegated, got %v", tt.wantValid, memoized[0])
+			}
+		})
+	}
+}
+
+func TestServiceSubscribersMemoized(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	podA := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, podA).Build()
+
+	memo := NewNodesMemo()
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector", WithNodesMemo(memo))
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+	h := memo.Handler(handler.Funcs{})
+
+	reconcile := func(step string, want ...string) {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		entry, ok := collector.cache.Get(collector.cache.Key(resource.Service, req.NamespacedName))
+		if !ok {
+			t.Fatalf("%s: expected the service to be cached", step)
+		}
+		for _, sub := range want {
+			if !entry.Subs.Has(sub) {
+				t.Errorf("%s: expected subscriber %q, got %v", step, sub, entry.Subs)
+			}
+		}
+		if len(entry.Subs) != len(want) {
+			t.Errorf("%s: expected subscribers %v, got %v", step, want, entry.Subs)
+		}
+	}
+
+	reconcile("first reconcile", "subscriber-a")
+
+	// A pod landing on a new node invalidates the memo before the service is reconciled again.
+	podB := podA.DeepCopy()
+	podB.Name = "pod-b"
+	podB.ResourceVersion = ""
+	podB.Spec.NodeName = "node-b"
+	if err := cl.Create(ctx, podB); err != nil {
+		t.Fatalf("unable to create pod: %v", err)
+	}
+	h.Create(ctx, event.CreateEvent{Object: podB}, nil)
+	reconcile("pod created", "subscriber-a", "subscriber-b")
+
+	// Without pod changes the nodes are served by the memo, even if the pods are not listed anymore.
+	if err := cl.Delete(ctx, podB); err != nil {
+		t.Fatalf("unable to delete pod: %v", err)
+	}
+	reconcile("memoized", "subscriber-a", "subscriber-b")
+
+	h.Delete(ctx, event.DeleteEvent{Object: podB}, nil)
+	reconcile("pod deleted", "subscriber-a")
+}
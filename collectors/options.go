@@ -16,23 +16,85 @@
 package collectors
 
 import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type collectorOptions struct {
-	externalSource    source.Source
-	subscriberChan    subscriber.SubsChan
-	podMatchingFields func(metadata *metav1.ObjectMeta) client.ListOption
-	ownerSources      map[string]chan<- event.GenericEvent
+	externalSource     source.Source
+	subscriberChan     subscriber.SubsChan
+	subscribers        *subscriber.Subscribers
+	podMatchingFields  func(metadata *metav1.ObjectMeta) client.ListOption
+	ownerSources       map[string]chan<- event.GenericEvent
+	barrier            *health.Barrier
+	healthRegistry     *health.Registry
+	syncStatus         *health.SyncStatus
+	indexRegistry      *IndexRegistry
+	indexers           []Indexer
+	metaTransforms     []MetaTransform
+	clusterName        string
+	resyncPeriod       time.Duration
+	ttl                time.Duration
+	jitter             float64
+	rateLimiter        RateLimiterSettings
+	namespaces         []string
+	nodesMemo          *NodesMemo
+	namespaceCascade   *NamespaceCascade
+	coalesceWindow     time.Duration
+	debounceWindow     time.Duration
+	includeTerminated  bool
+	endpointsNodes     bool
+	zoneNodes          bool
+	externalNameNodes  string
+	nodeLabels         []string
+	broadcast          []string
+	warmup             time.Duration
+	minAge             time.Duration
+	nodelessRequeue    time.Duration
+	pausedRequeue      time.Duration
+	statusFields       []string
+	metricsRegisterer  prometheus.Registerer
+	metricsPrefix      string
+	labelSelector      labels.Selector
+	annotationSelector labels.Selector
+	excludedNames      []string
+	apiReader          client.Reader
+	logger             logr.Logger
+	predicates         []predicate.Predicate
+	fieldsHandler      FieldsHandler
+	metaKeys           metaKeys
+	metaLimit          metaLimit
+	metaEncoder        MetaEncoder
+	dispatcher         Dispatcher
 }
 
 // CollectorOption function used to set options when creating a new meta collector.
 type CollectorOption func(opt *collectorOptions)
 
+// WithSubscribers configures the subscribers of the collector, by node. By default, the collector tracks them from the
+// messages received on its subscribers channel. It lets the unit tests relate the subscribers to the nodes without
+// running the collector.
+func WithSubscribers(subs *subscriber.Subscribers) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.subscribers = subs
+	}
+}
+
 // WithExternalSource configure external sources that could trigger the reconcile loop of the collector.
 func WithExternalSource(src source.Source) CollectorOption {
 	return func(opt *collectorOptions) {
@@ -60,3 +122,418 @@ func WithOwnerSources(sources map[string]chan<- event.GenericEvent) CollectorOpt
 		opt.ownerSources = sources
 	}
 }
+
+// WithBarrier configures the barrier where the collector registers itself and signals the completion
+// of its initial sync.
+func WithBarrier(barrier *health.Barrier) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.barrier = barrier
+	}
+}
+
+// WithHealthRegistry configures the registry where the collector registers itself and reports the outcome of
+// its reconciles.
+func WithHealthRegistry(registry *health.Registry) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.healthRegistry = registry
+	}
+}
+
+// WithSyncStatus configures the sync status where the collector registers itself and reports the sync of its
+// caches and the progress of its initial reconcile sweep.
+func WithSyncStatus(syncStatus *health.SyncStatus) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.syncStatus = syncStatus
+	}
+}
+
+// WithIndexRegistry configures the registry through which the collector registers the field indexers it needs. The
+// registry should be shared by the collectors of a manager, so that each indexer is registered once.
+func WithIndexRegistry(registry *IndexRegistry) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.indexRegistry = registry
+	}
+}
+
+// WithIndexers configures the field indexers needed by the collector in addition to the default ones, e.g. the
+// indexers of the fields used by its pod matching fields.
+func WithIndexers(indexers ...Indexer) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.indexers = append(opt.indexers, indexers...)
+	}
+}
+
+// WithMetaTransforms configures the transforms applied to the metadata of the resources before they are serialized
+// in the events. The transforms run in the order they are given, after the ones configured by previous options. They
+// apply to the meta field of the events, not to the structured metadata sent with the protobuf encoding.
+func WithMetaTransforms(transforms ...MetaTransform) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metaTransforms = append(opt.metaTransforms, transforms...)
+	}
+}
+
+// WithClusterName configures the name of the cluster stamped on the events of the collector and injected in the
+// metadata of the resources as the clusterName field, so that the consumers of several clusters can tell them apart.
+func WithClusterName(name string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.clusterName = name
+	}
+}
+
+// WithResyncPeriod configures the period of the full resync of the collector. A zero value disables it.
+func WithResyncPeriod(period time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.resyncPeriod = period
+	}
+}
+
+// WithTTL configures the time after which the subscribers should consider stale the metadata sent by the collector, if
+// no other event is received for the resource. The TTL is stamped on the events, and a Refresh event is sent for each
+// resource twice within the TTL, so that the metadata of the resources that did not change stay fresh. A zero value
+// disables it.
+func WithTTL(ttl time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.ttl = ttl
+	}
+}
+
+// WithJitter configures the factor of the jitter added to the period of the resyncs and to the backoff of the
+// retries of the failed reconciles: each delay is increased by a random fraction of it, up to the factor. It spreads
+// the resyncs and the retries of the collectors over time. A zero value disables it.
+func WithJitter(factor float64) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.jitter = factor
+	}
+}
+
+// WithNodesMemo configures the memo of the nodes where the pods are running. The pod collector invalidates it on
+// pod changes, the other collectors use it to compute the subscribers of their resources.
+func WithNodesMemo(memo *NodesMemo) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.nodesMemo = memo
+	}
+}
+
+// WithNamespaceCascade configures the cascade through which the namespace collector, when a namespace is deleted,
+// sends the Delete events of its resources cached by the other collectors before its own.
+func WithNamespaceCascade(cascade *NamespaceCascade) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.namespaceCascade = cascade
+	}
+}
+
+// WithCoalesceWindow configures the window within which the changes to the same resource are coalesced into a
+// single event carrying the latest state. A zero value disables the coalescing.
+func WithCoalesceWindow(window time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.coalesceWindow = window
+	}
+}
+
+// WithDebounceWindow configures the window within which the reconcile requests for the same resource triggered by
+// the external sources are collapsed into a single one. The changes to the resource itself are not debounced. A zero
+// value disables the debouncing.
+func WithDebounceWindow(window time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.debounceWindow = window
+	}
+}
+
+// WithRateLimiter configures the rate limiter of the retries of the failed reconciles of the collector. The unset
+// settings keep the controller-runtime defaults.
+func WithRateLimiter(settings RateLimiterSettings) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.rateLimiter = settings
+	}
+}
+
+// WithTerminatedPods configures whether the pods in a terminal phase, e.g. the completed or evicted ones, relate
+// their node to the resources. By default they do not, and the resources are deleted from the nodes where only
+// terminated pods remain.
+func WithTerminatedPods(include bool) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.includeTerminated = include
+	}
+}
+
+// WithEndpointsNodes configures the service collector to resolve the nodes of the services from the ready endpoints
+// of their EndpointSlices, instead of the pods matching their selector. It takes in account the manually managed
+// endpoints and the readiness of the backends.
+func WithEndpointsNodes(enabled bool) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.endpointsNodes = enabled
+	}
+}
+
+// WithZoneNodes configures the service collector to send the services to all the nodes in the topology zones of the
+// nodes serving them, read from the topology.kubernetes.io/zone label of the nodes. It requires the nodes to be listed
+// on each reconcile of a service.
+func WithZoneNodes(enabled bool) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.zoneNodes = enabled
+	}
+}
+
+// WithExternalNameNodes configures the nodes where the service collector sends the ExternalName services, served by
+// no pod: ExternalNameNamespace, the default, for the nodes running pods in their namespace, ExternalNameCluster for
+// all the nodes, since they affect the DNS resolution cluster-wide, or ExternalNameNone for none of them.
+func WithExternalNameNodes(nodes string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.externalNameNodes = nodes
+	}
+}
+
+// WithNodeLabels configures the pod collector to enrich the metadata of the pods with the given labels of their
+// node, e.g. topology.kubernetes.io/zone, under the nodeLabels key. The labels of the nodes are cached, and the pods
+// reconciled again when they change.
+func WithNodeLabels(keys ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.nodeLabels = keys
+	}
+}
+
+// WithBroadcastNamespaces configures the collectors to send the resources living in the given namespaces to all the
+// nodes of the cluster, instead of the nodes running the pods related to them. The namespaces themselves are sent to
+// all the nodes too. The pods are always sent to the subscribers of their node.
+func WithBroadcastNamespaces(namespaces ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.broadcast = namespaces
+	}
+}
+
+// WithWarmup sets the grace period following the initial sync of the collector, during which the subscribers that
+// received a resource are kept even when the reconcile no longer relates them to it, e.g. because the caches are
+// still being populated. The Delete events are deferred to the end of the period, the deletions of the resources are
+// still propagated. A zero period, the default, disables it.
+func WithWarmup(period time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.warmup = period
+	}
+}
+
+// WithMinAge sets the minimum age of the resources sent to the subscribers. The resources younger than it are
+// deferred until they persist past it, so that the short-lived ones never generate events. A zero age, the default,
+// disables it.
+func WithMinAge(age time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.minAge = age
+	}
+}
+
+// WithNodelessRequeue sets the delay after which the resources relating to no node, e.g. whose pods are not scheduled
+// yet, are reconciled again, as a safety net for the missed triggers of their pods. A zero delay, the default,
+// disables it. Not supported by the PodCollector, reconciled when its pods are scheduled.
+func WithNodelessRequeue(delay time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.nodelessRequeue = delay
+	}
+}
+
+// WithPausedRequeue sets the delay after which the requests received while the collector is paused are reconciled
+// again. A zero delay, the default, drops them: the resources are resynced when the collector resumes anyway.
+func WithPausedRequeue(delay time.Duration) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.pausedRequeue = delay
+	}
+}
+
+// WithNamespaces restricts the collector to the objects living in the given namespaces. If no namespace is
+// given the collector reconciles the objects of all the namespaces.
+func WithNamespaces(namespaces []string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.namespaces = namespaces
+	}
+}
+
+// WithStatusFields configures the fields of the status of the resources projected in the status of the events, e.g.
+// readyReplicas for the deployments. The fields are dot separated paths relative to the status. When set, the
+// collector watches the full objects instead of their metadata only, trading memory and api-server traffic for the
+// richer events.
+func WithStatusFields(statusFields ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.statusFields = append(opt.statusFields, statusFields...)
+	}
+}
+
+// WithMetricsRegisterer configures the registry where the collector registers its metrics. The collectors sharing a
+// registry share its metrics. If not set, the controller-runtime metrics registry is used.
+func WithMetricsRegisterer(reg prometheus.Registerer) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metricsRegisterer = reg
+	}
+}
+
+// WithMetricsPrefix configures the prefix prepended to the names of the metrics of the collector, e.g. to tell them
+// apart from the ones of the program embedding the collectors. The collectors sharing a registry and a prefix share
+// their metrics. If not set, the metrics keep their names.
+func WithMetricsPrefix(prefix string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metricsPrefix = prefix
+	}
+}
+
+// WithLabelSelector configures the collector to collect only the objects whose labels match the selector. The
+// objects that stop matching it are handled as deleted ones.
+func WithLabelSelector(selector labels.Selector) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.labelSelector = selector
+	}
+}
+
+// WithAnnotationSelector configures the collector to collect only the objects whose annotations match the selector.
+// The objects that stop matching it are handled as deleted ones.
+func WithAnnotationSelector(selector labels.Selector) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.annotationSelector = selector
+	}
+}
+
+// WithExcludedNames configures the collector to exclude the objects whose name matches any of the glob patterns, with
+// the syntax of path.Match. The patterns holding a slash, e.g. "monitoring/prometheus-*", are matched against the
+// namespace-qualified name of the namespaced objects, the other ones, e.g. "*-canary", against the bare name.
+func WithExcludedNames(patterns ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.excludedNames = patterns
+	}
+}
+
+// WithAPIReader configures the reader, not backed by the cache of the informers, used to confirm the deletion of the
+// resources missing from the cache before sending the Delete events. The cache could miss them while the informers
+// relist, e.g. after their watch expired. If not set, the cache is trusted.
+func WithAPIReader(reader client.Reader) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.apiReader = reader
+	}
+}
+
+// WithLogger configures the logger of the collector, named after it. If not set, the collector uses the logger of the
+// manager once set up with it.
+func WithLogger(logger logr.Logger) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.logger = logger
+	}
+}
+
+// WithPredicates configures predicates filtering the events reconciled by the collector, on top of the built-in ones.
+// They also receive the generic events triggered by the dispatcher and the related resources, that carry only the
+// name of the objects.
+func WithPredicates(predicates ...predicate.Predicate) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.predicates = append(opt.predicates, predicates...)
+	}
+}
+
+// FieldsHandler populates the resource sent to the subscribers from the metadata of an object.
+type FieldsHandler func(logger logr.Logger, res *events.Resource, obj *metav1.PartialObjectMetadata) error
+
+// WithFieldsHandler configures the handler populating the resources from the metadata of the objects, replacing the
+// built-in one. The projected status fields, if any, are not set. Only the ObjectMetaCollector supports it.
+func WithFieldsHandler(handler FieldsHandler) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.fieldsHandler = handler
+	}
+}
+
+// WithMetaEncoder configures the encoder serializing the metadata of the resources, JSONEncoder by default.
+func WithMetaEncoder(enc MetaEncoder) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metaEncoder = enc
+	}
+}
+
+// WithAllowedLabels configures the keys of the labels forwarded in the metadata of the resources, the other labels
+// are dropped. If no key is given, all the labels are forwarded. The labels are still used by the collectors, e.g. to
+// match the pods selected by the services.
+func WithAllowedLabels(keys ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metaKeys.labels = allowedKeys(keys)
+	}
+}
+
+// WithAllowedAnnotations configures the keys of the annotations forwarded in the metadata of the resources, the other
+// annotations are dropped. If no key is given, all the annotations are forwarded.
+func WithAllowedAnnotations(keys ...string) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metaKeys.annotations = allowedKeys(keys)
+	}
+}
+
+// WithMaxMetaSize configures the maximum size in bytes of the serialized metadata of the resources. The metadata
+// exceeding it are truncated dropping the largest annotations or labels first, depending on the policy, and their
+// events are flagged as truncated. A non-positive size does not limit the metadata.
+func WithMaxMetaSize(maxSize int, policy TruncationPolicy) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.metaLimit = metaLimit{maxSize: maxSize, policy: policy}
+	}
+}
+
+// WithDispatcher configures the dispatcher delivering the metadata of the existing resources to the subscribers of the
+// collector. By default, the collector dispatches them by triggering the reconciles of the resources related to their
+// nodes, and resyncs and refreshes its resources.
+func WithDispatcher(d Dispatcher) CollectorOption {
+	return func(opt *collectorOptions) {
+		opt.dispatcher = d
+	}
+}
+
+// validate returns the problems of the options of a collector pushing its events in the given queue, each naming the
+// offending option, joined in a single error. It returns nil if the options are valid.
+func (o *collectorOptions) validate(queue broker.Queue) error {
+	var errs []error
+	if queue == nil {
+		errs = append(errs, errors.New("queue: the queue of the events must be set"))
+	}
+	if o.subscriberChan == nil {
+		errs = append(errs, errors.New("WithSubscribersChan: the subscribers channel must be set"))
+	}
+	for i, idx := range o.indexers {
+		if idx.Object == nil {
+			errs = append(errs, fmt.Errorf("WithIndexers: the object of indexer %d must be set", i))
+		}
+		if idx.Field == "" {
+			errs = append(errs, fmt.Errorf("WithIndexers: the field of indexer %d must not be empty", i))
+		}
+		if idx.ExtractValue == nil {
+			errs = append(errs, fmt.Errorf("WithIndexers: the extract function of indexer %d must be set", i))
+		}
+	}
+	if err := validExternalNameNodes(o.externalNameNodes); err != nil {
+		errs = append(errs, fmt.Errorf("WithExternalNameNodes: %w", err))
+	}
+	for i, namespace := range o.broadcast {
+		if namespace == "" {
+			errs = append(errs, fmt.Errorf("WithBroadcastNamespaces: the namespace %d must not be empty", i))
+		}
+	}
+	for _, pattern := range o.excludedNames {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			errs = append(errs, fmt.Errorf("WithExcludedNames: invalid name pattern %q", pattern))
+		}
+	}
+	for i, key := range o.nodeLabels {
+		if key == "" {
+			errs = append(errs, fmt.Errorf("WithNodeLabels: the label key %d must not be empty", i))
+		}
+	}
+	if o.jitter < 0 {
+		errs = append(errs, fmt.Errorf("WithJitter: the factor must not be negative, got %v", o.jitter))
+	}
+	for _, d := range []struct {
+		option string
+		value  time.Duration
+	}{
+		{"WithResyncPeriod", o.resyncPeriod},
+		{"WithTTL", o.ttl},
+		{"WithWarmup", o.warmup},
+		{"WithMinAge", o.minAge},
+		{"WithNodelessRequeue", o.nodelessRequeue},
+		{"WithPausedRequeue", o.pausedRequeue},
+		{"WithCoalesceWindow", o.coalesceWindow},
+		{"WithDebounceWindow", o.debounceWindow},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: the duration must not be negative, got %s", d.option, d.value))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+func TestWithLogger(t *testing.T) {
+	var lines []string
+	logger := funcr.New(func(prefix, args string) {
+		lines = append(lines, prefix+" "+args)
+	}, funcr.Options{})
+
+	collector := NewObjectMetaCollector(fake.NewClientBuilder().Build(), &recordingQueue{}, events.NewCache(),
+		NewPartialObjectMetadata(resource.Deployment, nil), "deployment-collector", WithLogger(logger))
+	collector.logger.Info("started")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "deployment-collector ") {
+		t.Errorf("expected the configured logger to be named after the collector, got %v", lines)
+	}
+	if got := baseLogger(collector.baseLogger, nil); got.GetSink() != logger.GetSink() {
+		t.Error("expected the configured logger to be used instead of the one of the manager")
+	}
+
+	// Without the option the logger is set from the manager.
+	collector = NewObjectMetaCollector(fake.NewClientBuilder().Build(), &recordingQueue{}, events.NewCache(),
+		NewPartialObjectMetadata(resource.Deployment, nil), "deployment-collector")
+	if collector.logger.GetSink() != nil || collector.baseLogger.GetSink() != nil {
+		t.Error("expected no logger until the collector is set up with the manager")
+	}
+}
+
+func TestWithPredicates(t *testing.T) {
+	excluded := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() != "excluded"
+	})
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "falco"}}
+	collector := NewPodCollector(fake.NewClientBuilder().Build(), &recordingQueue{}, events.NewCache(), "pod-collector",
+		WithNamespaces([]string{"falco"}), WithPredicates(excluded))
+
+	filters := eventFilters(collector.filter, collector.namespaces, false, collector.predicates)
+	accepted := func(obj client.Object) bool {
+		for _, p := range filters {
+			if !p.Create(event.CreateEvent{Object: obj}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(filters) != 3 {
+		t.Fatalf("expected the built-in filters and the configured predicate, got %d filters", len(filters))
+	}
+	if !accepted(pod) {
+		t.Error("expected the pod to be reconciled")
+	}
+	pod.Name = "excluded"
+	if accepted(pod) {
+		t.Error("expected the pod rejected by the configured predicate to be filtered out")
+	}
+	pod.Name, pod.Namespace = "pod", "default"
+	if accepted(pod) {
+		t.Error("expected the built-in filters to still apply")
+	}
+}
+
+func TestWithFieldsHandler(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+
+	var handled string
+	handler := func(_ logr.Logger, res *events.Resource, obj *metav1.PartialObjectMetadata) error {
+		handled = obj.Name
+		res.SetMeta(`{"name":"custom"}`)
+		return nil
+	}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector", WithFieldsHandler(handler))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handled != "dpl" {
+		t.Errorf("expected the configured handler to receive the deployment, got %q", handled)
+	}
+	if len(queue.evts) != 1 {
+		t.Fatalf("expected one event, got %v", queue.pop())
+	}
+	if meta := queue.evts[0].GRPCMessage().GetMeta(); meta != `{"name":"custom"}` {
+		t.Errorf("expected the metadata set by the configured handler, got %q", meta)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	valid := []CollectorOption{WithSubscribersChan(make(subscriber.SubsChan))}
+	tests := map[string]struct {
+		queue broker.Queue
+		opts  []CollectorOption
+		want  []string
+	}{
+		"valid":               {queue: &recordingQueue{}, opts: valid},
+		"no queue":            {opts: valid, want: []string{"queue:"}},
+		"no subscribers chan": {queue: &recordingQueue{}, want: []string{"WithSubscribersChan:"}},
+		"empty indexer": {queue: &recordingQueue{}, opts: append(valid, WithIndexers(Indexer{})), want: []string{
+			"WithIndexers: the object of indexer 0", "WithIndexers: the field of indexer 0",
+			"WithIndexers: the extract function of indexer 0"}},
+		"negative jitter": {queue: &recordingQueue{}, opts: append(valid, WithJitter(-0.1)), want: []string{"WithJitter:"}},
+		"empty node label": {queue: &recordingQueue{}, opts: append(valid, WithNodeLabels("zone", "")),
+			want: []string{"WithNodeLabels: the label key 1"}},
+		"empty broadcast namespace": {queue: &recordingQueue{}, opts: append(valid, WithBroadcastNamespaces("")),
+			want: []string{"WithBroadcastNamespaces: the namespace 0"}},
+		"invalid excluded names": {queue: &recordingQueue{}, opts: append(valid, WithExcludedNames("*-canary", "", "[a-")),
+			want: []string{`WithExcludedNames: invalid name pattern ""`, `WithExcludedNames: invalid name pattern "[a-"`}},
+		"unknown external name nodes": {queue: &recordingQueue{}, opts: append(valid, WithExternalNameNodes("all")),
+			want: []string{"WithExternalNameNodes:"}},
+		"negative durations": {queue: &recordingQueue{}, opts: append(valid, WithResyncPeriod(-time.Second),
+			WithTTL(-time.Second), WithWarmup(-time.Second), WithMinAge(-time.Second), WithNodelessRequeue(-time.Second),
+			WithCoalesceWindow(-time.Second), WithDebounceWindow(-time.Second)), want: []string{"WithResyncPeriod:",
+			"WithTTL:", "WithWarmup:", "WithMinAge:", "WithNodelessRequeue:", "WithCoalesceWindow:", "WithDebounceWindow:"}},
+		"aggregated": {want: []string{"queue:", "WithSubscribersChan:"}},
+	}
+	for name, tt := range tests {
+		opts := collectorOptions{}
+		for _, o := range tt.opts {
+			o(&opts)
+		}
+		err := opts.validate(tt.queue)
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if got := len(strings.Split(err.Error(), "\n")); got != len(tt.want) {
+			t.Errorf("%s: expected %d problems, got %d: %v", name, len(tt.want), got, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: expected the error to name %q, got %v", name, want, err)
+			}
+		}
+	}
+}
+
+func TestSetupInvalidOptions(t *testing.T) {
+	cl := fake.NewClientBuilder().Build()
+	collectors := map[string]Collector{
+		"pod": NewPodCollector(cl, nil, events.NewCache(), "pod-collector"),
+		"service": NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+			WithJitter(-1)),
+		"deployment": NewObjectMetaCollector(cl, &recordingQueue{}, events.NewCache(),
+			NewPartialObjectMetadata(resource.Deployment, nil), "deployment-collector",
+			WithSubscribersChan(make(subscriber.SubsChan)), WithPodMatchingFields(nil)),
+	}
+	want := map[string][]string{
+		"pod":        {"queue:", "WithSubscribersChan:"},
+		"service":    {"WithSubscribersChan:", "WithJitter:"},
+		"deployment": {"WithPodMatchingFields:"},
+	}
+	// The options are validated before the manager is used.
+	for name, c := range collectors {
+		err := c.SetupWithManager(nil)
+		if err == nil {
+			t.Fatalf("%s: expected the setup to fail", name)
+		}
+		for _, w := range want[name] {
+			if !strings.Contains(err.Error(), w) {
+				t.Errorf("%s: expected the error to name %q, got %v", name, w, err)
+			}
+		}
+	}
+}
@@ -18,27 +18,37 @@ package collectors
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
 	"github.com/mitchellh/hashstructure/v2"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -57,6 +67,9 @@ type ObjectMetaCollector struct {
 	// subscriberChan where the collector gets notified of new subscribers and dispatches the existing events through the queue.
 	subscriberChan subscriber.SubsChan
 	logger         logr.Logger
+	baseLogger     logr.Logger
+	predicates     []predicate.Predicate
+	fieldsHandler  FieldsHandler
 	// The GVK for the resource need to be set.
 	resource *metav1.PartialObjectMetadata
 	// podMatchingFields returns a list options used to list existing pods previously indexed on a field.
@@ -68,12 +81,83 @@ type ObjectMetaCollector struct {
 	// processed by the reconciler.
 	dispatcherChan chan event.GenericEvent
 	subscribers    *subscriber.Subscribers
+	// informers is used to wait for the initial sync of the informers before accepting subscribers.
+	informers cache.Informers
+	// barrier where the collector signals the completion of its initial sync.
+	barrier *health.Barrier
+	// healthRegistry where the collector reports the outcome of its reconciles.
+	healthRegistry *health.Registry
+	// syncStatus where the collector reports the sync of its caches and the progress of its initial sweep.
+	syncStatus *health.SyncStatus
+	// metrics where the collector records its events and reconciles.
+	metrics *collectorMetrics
+	// filter excludes the objects from the collection.
+	filter *objectFilter
+	// resyncRequests asks the dispatcher to resync the resources, e.g. when the selectors change.
+	resyncRequests chan struct{}
+	// apiReader confirms the deletion of the resources missing from the cache, nil to trust the cache.
+	apiReader client.Reader
+	// metaTransforms applied in order to the metadata of the resources before their serialization.
+	metaTransforms []MetaTransform
+	// pause gates the reconciles while the collector is paused at runtime.
+	*pause
+	// metaKeys restricts the labels and annotations forwarded in the metadata of the resources.
+	metaKeys metaKeys
+	// metaLimit caps the size of the serialized metadata of the resources.
+	metaLimit metaLimit
+	// metaEncoder serializes the metadata of the resources, encoding/json if nil.
+	metaEncoder MetaEncoder
+	// clusterName stamped on the events, empty if not configured.
+	clusterName string
+	// replays tracks the reconciles dispatched to the new subscribers, to notify them when their events are queued.
+	replays *replays
+	// indexRegistry through which the collector registers the field indexers it needs.
+	indexRegistry *IndexRegistry
+	// indexers needed by the collector in addition to the default ones.
+	indexers []Indexer
+	// resyncPeriod is the period of the full resync. A zero value disables it.
+	resyncPeriod time.Duration
+	// ttl of the metadata stamped on the events and refreshed by the dispatcher. A zero value disables it.
+	ttl time.Duration
+	// jitter is the factor of the jitter added to the resync period and to the backoff of the retries.
+	jitter float64
+	// rateLimiter are the settings of the rate limiter of the retries of the reconciles.
+	rateLimiter RateLimiterSettings
+	// namespaces where the collector reconciles the objects. If empty, all the namespaces are watched.
+	namespaces []string
+	// nodesMemo memoizes the nodes of the pods related to the resources. If nil, the pods are always listed.
+	nodesMemo *NodesMemo
+	// coalesceWindow within which the changes to the same resource are coalesced. A zero value disables it.
+	coalesceWindow time.Duration
+	// debounceWindow within which the requests for the same resource triggered by the external sources are collapsed.
+	debounceWindow time.Duration
+	// includeTerminated is true if the pods in a terminal phase relate their node to the resources.
+	includeTerminated bool
+	// warmup is the grace period after the start of the collector, during which the Delete events due to empty
+	// sets of nodes are deferred.
+	warmup *warmup
+	// minAge defers the resources younger than a threshold until they persist past it.
+	minAge *minAge
+	// nodelessRequeue reconciles again the resources relating to no node.
+	nodelessRequeue nodelessRequeue
+	// namespaceCascade sends the Delete events of the resources of the deleted namespaces, if the collector collects
+	// the namespaces. Otherwise it is entered while reconciling the namespaced resources.
+	namespaceCascade *NamespaceCascade
+	// broadcast holds the namespaces whose resources are sent to all the nodes.
+	broadcast broadcastNamespaces
+	// statusFields projected in the status of the events. If empty, only the metadata of the resources are watched.
+	statusFields []string
+	// invalidOptions are the problems of the options the collector has been created with, returned by its setup.
+	invalidOptions error
+	// dispatcher delivers the metadata of the existing resources to the subscribers.
+	dispatcher Dispatcher
 }
 
 // NewObjectMetaCollector returns a new meta collector for a given resource kind.
 func NewObjectMetaCollector(cl client.Client, queue broker.Queue, cache *events.Cache,
 	res *metav1.PartialObjectMetadata, name string, opt ...CollectorOption) *ObjectMetaCollector {
 	opts := collectorOptions{
+		subscribers: subscriber.NewSubscribers(),
 		podMatchingFields: func(meta *metav1.ObjectMeta) client.ListOption {
 			return &client.ListOptions{}
 		},
@@ -82,10 +166,27 @@ func NewObjectMetaCollector(cl client.Client, queue broker.Queue, cache *events.
 		o(&opts)
 	}
 
+	// The collector is not ready until its initial sync has completed.
+	if opts.barrier != nil {
+		opts.barrier.Register(name)
+	}
+	// The collector is healthy until its reconciles keep failing, or get stuck, for too long.
+	opts.healthRegistry.Register(name)
+	// The collector is not ready until its initial sweep has completed.
+	opts.syncStatus.RegisterCollector(name)
+
+	invalidOptions := opts.validate(queue)
+	if opts.podMatchingFields == nil {
+		invalidOptions = errors.Join(invalidOptions, errors.New("WithPodMatchingFields: the pod matching fields must be set"))
+	}
+
 	dc := make(chan event.GenericEvent, 1)
+	resyncRequests := make(chan struct{}, 1)
+	metrics, err := registerCollectorMetrics(opts.metricsRegisterer, opts.metricsPrefix)
+	invalidOptions = errors.Join(invalidOptions, err)
 
 	return &ObjectMetaCollector{
-		Client:            cl,
+		Client:            instrumentClient(cl, metrics.clientCalls, name),
 		queue:             queue,
 		cache:             cache,
 		externalSource:    opts.externalSource,
@@ -95,35 +196,131 @@ func NewObjectMetaCollector(cl client.Client, queue broker.Queue, cache *events.
 		podMatchingFields: opts.podMatchingFields,
 		dispatcherSource:  &source.Channel{Source: dc},
 		dispatcherChan:    dc,
-		subscribers:       subscriber.NewSubscribers(),
+		subscribers:       opts.subscribers,
+		barrier:           opts.barrier,
+		healthRegistry:    opts.healthRegistry,
+		syncStatus:        opts.syncStatus,
+		metrics:           metrics,
+		filter:            newObjectFilter(opts.labelSelector, opts.annotationSelector, opts.excludedNames),
+		resyncRequests:    resyncRequests,
+		apiReader:         instrumentReader(opts.apiReader, metrics.clientCalls, name, schemeOf(cl)),
+		logger:            namedLogger(opts.logger, name),
+		baseLogger:        opts.logger,
+		predicates:        opts.predicates,
+		fieldsHandler:     opts.fieldsHandler,
+		indexRegistry:     opts.indexRegistry,
+		replays:           newReplays(),
+		metaTransforms:    metaTransforms(&opts),
+		metaKeys:          opts.metaKeys,
+		pause:             newPause(metrics.paused.WithLabelValues(name), opts.pausedRequeue, resyncRequests),
+		metaLimit:         opts.metaLimit,
+		metaEncoder:       opts.metaEncoder,
+		clusterName:       opts.clusterName,
+		indexers:          opts.indexers,
+		resyncPeriod:      opts.resyncPeriod,
+		ttl:               opts.ttl,
+		jitter:            opts.jitter,
+		rateLimiter:       opts.rateLimiter,
+		namespaces:        opts.namespaces,
+		nodesMemo:         opts.nodesMemo,
+		coalesceWindow:    opts.coalesceWindow,
+		debounceWindow:    opts.debounceWindow,
+		includeTerminated: opts.includeTerminated,
+		warmup:            newWarmup(opts.warmup),
+		minAge:            newMinAge(opts.minAge),
+		nodelessRequeue:   nodelessRequeue(opts.nodelessRequeue),
+		namespaceCascade:  opts.namespaceCascade,
+		broadcast:         newBroadcastNamespaces(opts.broadcast),
+		statusFields:      opts.statusFields,
+		invalidOptions:    invalidOptions,
+		dispatcher:        dispatcherOrDefault(opts.dispatcher),
 	}
 }
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
 
 // Reconcile generates events to be sent to nodes when changes are detected for the watched resources.
-func (r *ObjectMetaCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	var err error
+func (r *ObjectMetaCollector) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "Reconcile", r.resource.Kind, req.Namespace)
+	reconciled := r.healthRegistry.Start(r.name)
+	replayed := r.replays.reconciling(req.NamespacedName)
+	phases := r.metrics.startReconcilePhases(r.name)
+	defer func() {
+		tracing.End(span, err)
+		reconciled(err)
+		replayed()
+		phases.done(err)
+		r.syncStatus.Reconciled(r.name, req.NamespacedName.String())
+	}()
+
 	var res *events.Resource
 	var cEntry *events.CacheEntry
+	// snapshot is the state of the cache entry before the changes made for the events, restored if they are not pushed.
+	var snapshot events.CacheSnapshot
+	var status map[string]interface{}
 	var ok, deleted bool
+	// requeue is the delay after which the resource is reconciled again, when Delete events have been deferred.
+	var requeue time.Duration
 
 	logger := log.FromContext(ctx)
 
-	err = r.Get(ctx, req.NamespacedName, r.resource)
-	if err != nil && !k8sApiErrors.IsNotFound(err) {
+	// Without a queue, or once it is closed during the shutdown, the events would be lost: the cache is left untouched
+	// so that it keeps matching what the subscribers received.
+	if broker.IsClosed(r.queue) {
+		logger.V(2).Info("queue closed, skipping the reconcile")
+		return ctrl.Result{}, nil
+	}
+	// While the collector is paused no events are pushed. The skipped reconcile does not complete the replays waiting for
+	// the request, or their subscribers would drop the resources never sent: they complete with the resync on resume.
+	if result, paused := r.pause.skip(logger); paused {
+		replayed = func() {}
+		return result, nil
+	}
+	key := r.cache.Key(r.resource.Kind, req.NamespacedName)
+
+	status, err = r.getObject(ctx, req.NamespacedName)
+	phases.notFound = k8sApiErrors.IsNotFound(err)
+	if err != nil && !phases.notFound {
 		logger.Error(err, "unable to get resource")
 		return ctrl.Result{}, err
 	}
+	if r.resource.Kind == resource.Namespace && err == nil {
+		r.namespaceCascade.created(req.Name, string(r.resource.UID))
+	}
+	// The resources of a namespace being swept wait for the sweep, and are skipped once it is over.
+	leave, entered := r.namespaceCascade.enter(req.Namespace)
+	if !entered {
+		return skipDeletedNamespace(logger, phases.notFound), nil
+	}
+	defer leave()
+
+	// An ignored resource, or one not matching the selectors, is handled as a deleted one: the subscribers that
+	// received it get a Delete event.
+	ignored := err == nil && r.filter.excluded(r.resource)
+	if ignored {
+		logger.V(3).Info("resource excluded by annotation or selectors", "annotation", consts.IgnoreAnnotation)
+	}
 
-	if k8sApiErrors.IsNotFound(err) {
+	if k8sApiErrors.IsNotFound(err) || ignored {
 		// When the k8s resource gets deleted we need to remove it from the local cache.
-		if r.cache.Has(req.String()) {
-			logger.V(3).Info("marking resource for deletion")
-			deleted = true
-		} else {
+		if cEntry, ok = r.cache.Get(key); !ok {
 			return ctrl.Result{}, nil
 		}
+		// The informers could be relisting, the resource is deleted once the api-server confirms it.
+		var exists bool
+		if !ignored {
+			if exists, err = existsOnAPIServer(ctx, r.apiReader, r.resource.GroupVersionKind(), req.NamespacedName, cEntry.UID); err != nil {
+				logger.Error(err, "unable to confirm the deletion of the resource")
+				return ctrl.Result{}, err
+			}
+		}
+		if exists {
+			logger.V(2).Info("resource missing from the cache but existing on the api-server, waiting for the cache to sync")
+			phases.staleCache = true
+			return ctrl.Result{RequeueAfter: staleCacheRequeue}, nil
+		}
+		logger.V(3).Info("marking resource for deletion")
+		deleted = true
 	}
 
 	logger.V(5).Info("resource found")
@@ -133,23 +330,50 @@ func (r *ObjectMetaCollector) Reconcile(ctx context.Context, req ctrl.Request) (
 		// Get all getSubscribers for the resource based on its node name.
 		// The getSubscribers are used to compute to which getSubscribers we need to send an event
 		// and of which type, Create, Delete or Update
-		subs, err := r.getSubscribers(ctx, logger, &r.resource.ObjectMeta)
+		phases.next(phaseRelation)
+		subs, nodes, err := r.getSubscribers(ctx, logger, &r.resource.ObjectMeta)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		// If no subscribers and not in the cache, return.
+		tracing.SetAttributes(span, tracing.SubscribersKey.Int(len(subs)))
+		// During the warmup the subscribers that received the resource are retained, and the resource reconciled
+		// again once it is over.
+		if cEntry, ok = r.cache.Get(key); ok {
+			if subs, requeue = r.warmup.retain(subs, cEntry.Subs); requeue > 0 {
+				logger.V(2).Info("deferring the Delete events to the end of the warmup", "requeueAfter", requeue)
+			}
+		}
+		// The resources relating to no node are checked again, in case the trigger of their pods is missed.
+		requeue = r.nodelessRequeue.requeueAfter(nodes, requeue)
+		// If no subscribers and not sent to any subscriber, return. Otherwise the subscribers that received the
+		// resource get a Delete event, e.g. when the last pod related to it on their node is gone or terminated.
 		if len(subs) == 0 {
-			// Make sure to remove the cache entry for the resource.
-			// This could happen when a subscriber closes its connection.
-			r.cache.Delete(req.String())
-			return ctrl.Result{}, nil
+			if cEntry, ok = r.cache.Get(key); !ok || len(cEntry.Subs) == 0 {
+				// Make sure to remove the cache entry for the resource.
+				// This could happen when a subscriber closes its connection.
+				r.cache.Delete(key)
+				return ctrl.Result{RequeueAfter: requeue}, nil
+			}
+		}
+		// A resource never sent is deferred until it is old enough.
+		if !r.cache.Has(key) {
+			if wait := r.minAge.wait(r.resource.CreationTimestamp); wait > 0 {
+				logger.V(3).Info("deferring the resource until it reaches the minimum age", "requeueAfter", wait)
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
 		}
 
 		// Create a new events.Resource and fill its fields.
+		phases.next(phaseSerialize)
 		res = events.NewResource(r.resource.Kind, string(r.resource.UID))
-		// Populate resource fields.
-		if err := r.objFieldsHandler(logger, res, r.resource); err != nil {
-			return ctrl.Result{}, err
+		// Populate resource fields, through the handler configured with WithFieldsHandler if any.
+		if r.fieldsHandler != nil {
+			err = r.fieldsHandler(logger, res, r.resource)
+		} else {
+			err = r.objFieldsHandler(ctx, logger, res, r.resource, status)
+		}
+		if err != nil {
+			return ctrl.Result{}, serializationError(r.resource.Kind, req.NamespacedName, err)
 		}
 		// Hash the current resource.
 		hash, err := hashstructure.Hash(res, hashstructure.FormatV2, nil)
@@ -159,37 +383,49 @@ func (r *ObjectMetaCollector) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 
 		// Check if we have cached the resource previously.
-		if cEntry, ok = r.cache.Get(req.String()); ok {
+		snapshot = r.cache.Snapshot(key)
+		if cEntry, ok = r.cache.Get(key); ok {
 			// If an entry exists for the resource then check if the hashes are the same.
 			// If not, it means that the resource fields have changes since the last time.
 			// so mark the resource as updated. The "update" flag is needed to generate "Update"
 			// events for the related getSubscribers.
 			if cEntry.Hash != hash {
 				res.SetUpdate(true)
-				cEntry.Hash = hash
+				r.cache.SetHash(cEntry, hash)
+				// The changed label and annotation keys are attached to the Update events.
+				res.SetPreviousMeta(cEntry.Meta, cEntry.Labels, cEntry.Annotations)
+				r.cache.SetMeta(cEntry, res.Meta, res.Labels, res.Annotations)
+				// Each change of the resource is numbered in its events.
+				r.cache.NextSequence(cEntry)
 			}
 			// Set the previous subscribers in the current resource.
 			res.SetSubscribers(cEntry.Subs)
 		} else {
 			// If we never cached the resource then create an entry and add it to the cache.
 			cEntry = &events.CacheEntry{
-				Hash: hash,
-				UID:  r.resource.UID,
-				Subs: nil,
+				Hash:     hash,
+				UID:      r.resource.UID,
+				Subs:     nil,
+				Sequence: 1,
 			}
-			r.cache.Add(req.String(), cEntry)
+			r.cache.Add(key, cEntry)
+			r.cache.SetMeta(cEntry, res.Meta, res.Labels, res.Annotations)
 		}
+		res.SetSequence(cEntry.Sequence)
 
 		// Add the new subscribers and internally compute the new subscribers to which we need to sent events.
 		// Update the cache entry with the new set of getSubscribers.
-		cEntry.Subs = res.GenerateSubscribers(subs)
+		r.cache.SetSubscribers(cEntry, res.GenerateSubscribers(subs))
+		indexNodes(r.cache, res, key, nodes)
 	} else {
 		// Check if we have cached the resource.
-		if cEntry, ok = r.cache.Get(req.String()); ok {
+		snapshot = r.cache.Snapshot(key)
+		if cEntry, ok = r.cache.Get(key); ok {
 			// Create the resource.
 			res = events.NewResource(r.resource.Kind, string(cEntry.UID))
 			// Set the previous subscribers.
 			res.SetSubscribers(cEntry.Subs)
+			res.SetSequence(r.cache.NextSequence(cEntry))
 			// The resource has been deleted. We need to send a delete event to
 			// the subscribers. By generating the subscribers from an empty set,
 			// is the same as to generate delete events for all the subscribers to which
@@ -197,41 +433,135 @@ func (r *ObjectMetaCollector) Reconcile(ctx context.Context, req ctrl.Request) (
 			res.GenerateSubscribers(nil)
 			// We are ready to remove the entry from the cache. No need to track anymore
 			// the deleted resource.
-			r.cache.Delete(req.String())
+			r.cache.Delete(key)
 		} else {
 			// It means that we received a delete event for a resource that we never sent to any subscriber.
 			// In this case we just return.
 			return ctrl.Result{}, nil
 		}
+		// The resources of a deleted namespace are deleted before it. The ones of a namespace no longer selected are
+		// left to their collectors.
+		if r.resource.Kind == resource.Namespace && !ignored {
+			deletes, err := r.namespaceCascade.deleteNamespace(req.Name, string(cEntry.UID), r.queue, r.clusterName, r.name)
+			if err != nil {
+				return pushFailed(logger, r.cache, snapshot, err)
+			}
+			if deletes > 0 {
+				logger.V(2).Info("deleted the resources of the namespace", "resources", deletes)
+			}
+		}
 	}
 
 	// At this point our resource has all the necessary bits to know for each node which type of events need to be sent.
+	phases.next(phaseDispatch)
+	res.SetSpanContext(span.SpanContext())
+	res.SetCluster(r.clusterName)
+	res.SetTTL(r.ttl)
+	res.SetOrigin(r.name, req.NamespacedName)
 	evts := res.ToEvents()
 
 	// Enqueue events.
 	for _, evt := range evts {
 		if evt != nil {
 			// Push event to the queue
-			r.queue.Push(evt)
+			if err = r.queue.Push(evt); err != nil {
+				return pushFailed(logger, r.cache, snapshot, err)
+			}
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeue}, nil
 }
 
 // Start implements the runnable interface needed in order to handle the start/stop
 // using the manager. It starts go routines needed by the collector to interact with the
 // broker.
 func (r *ObjectMetaCollector) Start(ctx context.Context) error {
-	return dispatch(ctx, r.logger, r.resource.Kind, r.subscriberChan, r.dispatcherChan, r.Client, r.subscribers)
+	// Subscribers are refused until the initial sync has completed.
+	watched, err := r.watched()
+	if err != nil {
+		return err
+	}
+	waitForInitialSync(ctx, r.logger, r.name, r.informers, r.barrier, r.syncStatus, watched, &corev1.Pod{})
+	r.warmup.synced()
+	return r.dispatcher.Dispatch(ctx, &DispatchSetup{
+		Logger:         r.logger,
+		Kind:           r.resource.Kind,
+		Subscriptions:  r.subscriberChan,
+		Subscribers:    r.subscribers,
+		Client:         r.Client,
+		Cache:          r.cache,
+		Queue:          r.queue,
+		reconciles:     r.dispatcherChan,
+		replays:        r.replays,
+		resyncs:        r.metrics.resyncs.WithLabelValues(r.resource.Kind),
+		resyncRequests: r.resyncRequests,
+		resyncPeriod:   r.resyncPeriod,
+		jitter:         r.jitter,
+		refresh:        newRefresher(r.resource.Kind, r.clusterName, r.ttl, r.cache, r.queue, r.pause),
+		broadcast:      r.broadcast.broadcaster(r.Client, r.newBroadcastList()),
+	})
+}
+
+// newBroadcastList returns the function building the lists of the resources of the collector, nil for the namespaces
+// which are broadcast themselves.
+func (r *ObjectMetaCollector) newBroadcastList() func() client.ObjectList {
+	if r.resource.Kind == resource.Namespace {
+		return nil
+	}
+	gvk := r.resource.GroupVersionKind()
+	return func() client.ObjectList {
+		list := &metav1.PartialObjectMetadataList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		return list
+	}
+}
+
+// getObject reads the object with the given name in r.resource. When status fields are configured, the full object
+// is read instead: its metadata are copied in r.resource and its projected status fields returned.
+func (r *ObjectMetaCollector) getObject(ctx context.Context, name types.NamespacedName) (map[string]interface{}, error) {
+	if len(r.statusFields) == 0 {
+		return nil, r.Get(ctx, name, r.resource)
+	}
+
+	obj, err := typedObject(r.Client, r.resource)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Get(ctx, name, obj); err != nil {
+		return nil, err
+	}
+	objUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	meta, _ := objUn["metadata"].(map[string]interface{})
+	r.resource.ObjectMeta = metav1.ObjectMeta{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(meta, &r.resource.ObjectMeta); err != nil {
+		return nil, err
+	}
+	return projectStatus(objUn, r.statusFields)
 }
 
-// objFieldsHandler populates the resource from the object.
-func (r *ObjectMetaCollector) objFieldsHandler(logger logr.Logger, res *events.Resource, obj *metav1.PartialObjectMetadata) error {
+// watched returns the object watched by the collector: the full object when status fields are configured, its
+// metadata otherwise.
+func (r *ObjectMetaCollector) watched() (client.Object, error) {
+	if len(r.statusFields) == 0 {
+		return r.resource, nil
+	}
+	return typedObject(r.Client, r.resource)
+}
+
+// objFieldsHandler populates the resource from the object and the projected fields of its status, if any.
+func (r *ObjectMetaCollector) objFieldsHandler(ctx context.Context, logger logr.Logger, res *events.Resource,
+	obj *metav1.PartialObjectMetadata, status map[string]interface{}) (err error) {
 	if obj == nil {
 		return nil
 	}
 
+	_, span := tracing.Start(ctx, "ObjFieldsHandler", r.resource.Kind, obj.Namespace)
+	defer func() { tracing.End(span, err) }()
+
 	objUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
 	if err != nil {
 		logger.Error(err, "unable to convert to unstructured")
@@ -245,12 +575,34 @@ func (r *ObjectMetaCollector) objFieldsHandler(logger logr.Logger, res *events.R
 	for _, key := range metaUnused {
 		delete(metaMap, key)
 	}
+	// Only the allowed labels and annotations are forwarded, before the metadata are transformed and truncated.
+	r.metaKeys.filter(metaMap)
+	if err = transformMeta(metaMap, r.metaTransforms); err != nil {
+		logger.Error(err, "unable to transform meta")
+		return err
+	}
 
-	metaString, err := json.Marshal(metaMap)
+	metaString, dropped, err := r.metaLimit.marshal(r.metaEncoder, metaMap)
 	if err != nil {
 		return err
 	}
-	res.SetMeta(string(metaString))
+	if dropped != nil {
+		logger.V(2).Info("metadata truncated to fit the maximum size", "dropped", dropped)
+		r.metrics.metaTruncations.WithLabelValues(r.resource.Kind).Inc()
+	}
+	if err = setMeta(res, metaString, metaMap); err != nil {
+		return err
+	}
+	res.SetObjectMeta(dropped.objectMeta(r.metaKeys.objectMeta(&obj.ObjectMeta)), "")
+	res.SetMetaTruncated(dropped != nil)
+
+	if status != nil {
+		statusString, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		res.SetStatus(string(statusString))
+	}
 
 	return nil
 }
@@ -258,8 +610,11 @@ func (r *ObjectMetaCollector) objFieldsHandler(logger logr.Logger, res *events.R
 // getSubscribers returns all the subscribers for the current resource.
 // The subscribers are computed based on the nodes where a pod related to the current resource is running,
 // and subscribers that want to receive events for those nodes.
-func (r *ObjectMetaCollector) getSubscribers(ctx context.Context, logger logr.Logger, meta *metav1.ObjectMeta) (fields.Subscribers, error) {
-	pods := corev1.PodList{}
+func (r *ObjectMetaCollector) getSubscribers(ctx context.Context, logger logr.Logger,
+	meta *metav1.ObjectMeta) (_ fields.Subscribers, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "getSubscribers", r.resource.Kind, meta.Namespace)
+	defer func() { tracing.End(span, err) }()
+
 	var namespace string
 	// Special care for namespace resources.
 	if r.resource.Kind == resource.Namespace {
@@ -267,55 +622,84 @@ func (r *ObjectMetaCollector) getSubscribers(ctx context.Context, logger logr.Lo
 	} else {
 		namespace = meta.Namespace
 	}
-	// List all the pods related to the current resource.
-	err := r.List(ctx, &pods, client.InNamespace(namespace), r.podMatchingFields(meta))
+	// The resources of the broadcast namespaces are related to all the nodes.
+	if r.broadcast.has(namespace) {
+		nodes, err := r.broadcast.nodes(ctx, logger, r.Client)
+		if err != nil {
+			return nil, nil, err
+		}
+		return subscribersForNodes(ctx, r.subscribers, nodes), nodes, nil
+	}
+	// Get the nodes of all the pods related to the current resource.
+	listOpts := &client.ListOptions{}
+	r.podMatchingFields(meta).ApplyToList(listOpts)
+	selection, selected := liveSelection("all", func(*corev1.Pod) bool { return true }, r.includeTerminated)
+	nodes, err := podNodes(ctx, r.Client, r.nodesMemo, r.metrics, namespace, selectorKey(selection, listOpts), selected, listOpts)
 	if err != nil {
 		logger.Error(err, "unable to list pods related to resource", "in namespace", meta.Namespace)
-		return nil, err
+		return nil, nil, err
 	}
 
-	// If no pods found for the resource just return.
-	if len(pods.Items) == 0 {
-		return nil, nil
-	}
-
-	subs := make(fields.Subscribers)
-	for i := range pods.Items {
-		if pods.Items[i].Spec.NodeName != "" {
-			// Check if a subscriber exists for the node.
-			if ok := r.subscribers.HasNode(pods.Items[i].Spec.NodeName); ok {
-				s := r.subscribers.GetSubscribersPerNode(pods.Items[i].Spec.NodeName)
-				for s1 := range s {
-					subs.Add(s1)
-				}
-			}
-		}
-	}
+	// All the pods scheduled on a node are related to the resource.
+	return subscribersForNodes(ctx, r.subscribers, nodes), nodes, nil
+}
 
-	return subs, nil
+// Indexers returns the field indexers needed by the collector. The pods are listed by node when dispatching the
+// resources to the new subscribers, and by the fields configured with WithPodMatchingFields.
+func (r *ObjectMetaCollector) Indexers() []Indexer {
+	return append([]Indexer{PodByNodeIndexer}, r.indexers...)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ObjectMetaCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if r.invalidOptions != nil {
+		return fmt.Errorf("invalid options: %w", r.invalidOptions)
+	}
+	if err := registerIndexers(mgr, r.indexRegistry, r.Indexers()); err != nil {
+		return err
+	}
+
 	// Set the generic logger to be used in other function then the reconcile loop.
-	r.logger = mgr.GetLogger().WithName(r.name)
+	base := baseLogger(r.baseLogger, mgr)
+	r.logger = base.WithName(r.name)
+	r.informers = mgr.GetCache()
 
-	lc, err := newLogConstructor(mgr.GetLogger(), r.name, r.resource.Kind)
+	lc, err := newLogConstructor(base, r.name, r.resource.Kind)
 	if err != nil {
 		return err
 	}
 
+	// Only the metadata are watched, unless status fields are projected in the events.
+	watched, err := r.watched()
+	if err != nil {
+		return err
+	}
+	watchOpts := []builder.WatchesOption{builder.WithPredicates(r.metrics.predicates(r.name, apiServerSource, nil),
+		changedFilter(r.statusFields...))}
+	if len(r.statusFields) == 0 {
+		watchOpts = append(watchOpts, builder.OnlyMetadata)
+	}
+
+	// The resources are watched through the coalescing handler, hence the controller is named as For would do.
 	bld := ctrl.NewControllerManagedBy(mgr).
-		For(r.resource,
-			builder.OnlyMetadata,
-			builder.WithPredicates(predicatesWithMetrics(r.name, apiServerSource, nil))).
-		WatchesRawSource(r.dispatcherSource, &handler.EnqueueRequestForObject{}, builder.WithPredicates(predicatesWithMetrics(r.name, "dispatcher", nil))).
-		WithOptions(controller.Options{LogConstructor: lc})
+		Named(strings.ToLower(r.resource.Kind)).
+		Watches(watched, sweepHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, r.coalesceWindow),
+			r.syncStatus, r.name), watchOpts...).
+		WatchesRawSource(r.dispatcherSource, &handler.EnqueueRequestForObject{}, builder.WithPredicates(r.metrics.predicates(r.name, "dispatcher", nil))).
+		WithOptions(controller.Options{LogConstructor: lc, RateLimiter: rateLimiter(r.jitter, r.rateLimiter)})
+
+	// The resources excluded by annotation, the ones out of the watched namespaces and the ones rejected by the
+	// configured predicates are not reconciled.
+	for _, filter := range eventFilters(r.filter, r.namespaces, r.resource.Kind == resource.Namespace, r.predicates) {
+		bld.WithEventFilter(filter)
+	}
 
+	// The reconciles triggered by the pods are debounced, while the changes to the resources themselves are not.
 	if r.externalSource != nil {
 		bld.WatchesRawSource(r.externalSource,
-			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(predicatesWithMetrics(r.name, resource.Pod, nil)))
+			debouncingHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, r.coalesceWindow), r.debounceWindow,
+				r.metrics.collapsedRequests.WithLabelValues(r.name, resource.Pod)),
+			builder.WithPredicates(r.metrics.predicates(r.name, resource.Pod, nil)))
 	}
 
 	return bld.Complete(r)
@@ -326,19 +710,61 @@ func (r *ObjectMetaCollector) GetName() string {
 	return r.name
 }
 
-// NewPartialObjectMetadata returns a partial object metadata for a limited set of resources. It is used as a helper
-// when triggering reconciles or instantiating a collector for a given resource.
-func NewPartialObjectMetadata(kind string, name *types.NamespacedName) *metav1.PartialObjectMetadata {
-	obj := &metav1.PartialObjectMetadata{}
-	if kind == resource.Namespace || kind == resource.Service || kind == resource.ReplicationController {
-		obj.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
-	} else {
-		obj.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind(kind))
+// SetSelectors replaces the label and annotation selectors of the collector, nil to not filter the resources, and
+// resyncs its resources: the subscribers receive the Delete events of the resources no longer selected and the
+// Create events of the newly selected ones.
+func (r *ObjectMetaCollector) SetSelectors(labelSelector, annotationSelector labels.Selector) {
+	r.filter.set(labelSelector, annotationSelector)
+	requestResync(r.resyncRequests)
+}
+
+// SetExcludedNames replaces the patterns of the names of the excluded resources, and resyncs the resources: the subscribers
+// receive the Delete events of the resources newly excluded and the Create events of the ones no longer excluded.
+func (r *ObjectMetaCollector) SetExcludedNames(patterns []string) {
+	r.filter.setExcludedNames(patterns)
+	requestResync(r.resyncRequests)
+}
+
+// PartialObjectMetadataForGVK returns a partial object metadata for the given group version kind. A group version
+// kind with only the kind set is completed from the resource registry, an *resource.UnknownKindError is returned if
+// the kind is not registered. An error is returned as well if the kind is missing.
+func PartialObjectMetadataForGVK(gvk schema.GroupVersionKind, name *types.NamespacedName) (*metav1.PartialObjectMetadata, error) {
+	if gvk.Kind == "" {
+		return nil, fmt.Errorf("group version kind %s has no kind", gvk)
+	}
+	if gvk.Version == "" {
+		if gvk.Group != "" {
+			return nil, fmt.Errorf("group version kind %s has no version", gvk)
+		}
+		registered, err := resource.GroupVersionKind(gvk.Kind)
+		if err != nil {
+			return nil, err
+		}
+		gvk = registered
 	}
 
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(gvk)
 	if name != nil {
 		obj.Name = name.Name
 		obj.Namespace = name.Namespace
 	}
+	return obj, nil
+}
+
+// PartialObjectMetadataFor returns a partial object metadata for the given kind. The group version of the kind
+// is looked up in the resource registry, an *resource.UnknownKindError is returned for kinds not registered.
+func PartialObjectMetadataFor(kind string, name *types.NamespacedName) (*metav1.PartialObjectMetadata, error) {
+	return PartialObjectMetadataForGVK(schema.GroupVersionKind{Kind: kind}, name)
+}
+
+// NewPartialObjectMetadata returns a partial object metadata for a registered resource kind. It is used as a helper
+// when triggering reconciles or instantiating a collector for a given resource. It panics if the kind is not
+// registered, use PartialObjectMetadataFor when the kind is not known in advance.
+func NewPartialObjectMetadata(kind string, name *types.NamespacedName) *metav1.PartialObjectMetadata {
+	obj, err := PartialObjectMetadataFor(kind, name)
+	if err != nil {
+		panic(err)
+	}
 	return obj
 }
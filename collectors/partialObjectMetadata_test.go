@@ -0,0 +1,422 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	"google.golang.org/protobuf/proto"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestPartialObjectMetadataFor(t *testing.T) {
+	name := &types.NamespacedName{Namespace: "default", Name: "test"}
+	obj, err := PartialObjectMetadataFor(resource.Deployment, name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gvk := obj.GroupVersionKind(); gvk.Group != "apps" || gvk.Kind != resource.Deployment {
+		t.Errorf("unexpected group version kind %s", gvk)
+	}
+	if obj.Name != name.Name || obj.Namespace != name.Namespace {
+		t.Errorf("expected object %s, got %s/%s", name, obj.Namespace, obj.Name)
+	}
+
+	var unknownKind *resource.UnknownKindError
+	if _, err := PartialObjectMetadataFor("Job", nil); !errors.As(err, &unknownKind) {
+		t.Errorf("expected an UnknownKindError, got %v", err)
+	}
+}
+
+func TestPartialObjectMetadataForGVK(t *testing.T) {
+	name := &types.NamespacedName{Namespace: "default", Name: "test"}
+	ingress := schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	obj, err := PartialObjectMetadataForGVK(ingress, name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gvk := obj.GroupVersionKind(); gvk != ingress {
+		t.Errorf("expected group version kind %s, got %s", ingress, gvk)
+	}
+	if obj.Name != name.Name || obj.Namespace != name.Namespace {
+		t.Errorf("expected object %s, got %s/%s", name, obj.Namespace, obj.Name)
+	}
+
+	obj, err = PartialObjectMetadataForGVK(schema.GroupVersionKind{Kind: resource.Daemonset}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gvk := obj.GroupVersionKind(); gvk != appsv1.SchemeGroupVersion.WithKind(resource.Daemonset) {
+		t.Errorf("expected the registered group version kind of %s, got %s", resource.Daemonset, gvk)
+	}
+
+	var unknownKind *resource.UnknownKindError
+	if _, err := PartialObjectMetadataForGVK(schema.GroupVersionKind{Kind: "Job"}, nil); !errors.As(err, &unknownKind) {
+		t.Errorf("expected an UnknownKindError, got %v", err)
+	}
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "batch", Version: "v1"},
+		{Group: "batch", Kind: "Job"},
+	} {
+		if _, err := PartialObjectMetadataForGVK(gvk, nil); err == nil {
+			t.Errorf("expected an error for group version kind %s", gvk)
+		}
+	}
+}
+
+func TestNewPartialObjectMetadataPanicsOnUnknownKind(t *testing.T) {
+	defer func() {
+		err, ok := recover().(error)
+		var unknownKind *resource.UnknownKindError
+		if !ok || !errors.As(err, &unknownKind) {
+			t.Errorf("expected a panic with an UnknownKindError, got %v", err)
+		}
+	}()
+	NewPartialObjectMetadata("Job", nil)
+}
+
+func TestMetaDiffOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"app": "web", "version": "v1"}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector")
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	reconcile := func(step string) *metadata.MetaDiff {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if len(queue.evts) != 1 {
+			t.Fatalf("%s: expected one event, got %v", step, queue.pop())
+		}
+		diff := queue.evts[0].GRPCMessage().GetMetaDiff()
+		queue.pop()
+		return diff
+	}
+
+	if diff := reconcile("created"); diff != nil {
+		t.Errorf("expected no diff in the Create event, got %v", diff)
+	}
+
+	dpl.Labels = map[string]string{"app": "web", "version": "v2", "team": "a"}
+	if err := cl.Update(ctx, dpl); err != nil {
+		t.Fatalf("unable to update deployment: %v", err)
+	}
+	want := &metadata.MetaDiff{Labels: &metadata.KeysDiff{Added: []string{"team"}, Changed: []string{"version"}}}
+	if diff := reconcile("labels changed"); !proto.Equal(diff, want) {
+		t.Errorf("expected diff %v, got %v", want, diff)
+	}
+
+	// The diff is computed against the labels sent in the last event.
+	dpl.Labels = map[string]string{"app": "web", "version": "v2"}
+	if err := cl.Update(ctx, dpl); err != nil {
+		t.Fatalf("unable to update deployment: %v", err)
+	}
+	want = &metadata.MetaDiff{Labels: &metadata.KeysDiff{Removed: []string{"team"}}}
+	if diff := reconcile("label removed"); !proto.Equal(diff, want) {
+		t.Errorf("expected diff %v, got %v", want, diff)
+	}
+}
+
+func TestAnnotationDiffThroughCache(t *testing.T) {
+	ctx := context.Background()
+	// The deployments go through the transform of the cache, keeping only the allowed annotations.
+	transform := KeepAnnotationsTransformer(PartialObjectTransformer(logr.Discard()), []string{"owner"})
+	cached := func(annotations map[string]string) *appsv1.Deployment {
+		t.Helper()
+		obj, err := transform(&metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default",
+			UID: "dpl-uid", Labels: map[string]string{"app": "web"}, Annotations: annotations}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &appsv1.Deployment{ObjectMeta: obj.(*metav1.PartialObjectMetadata).ObjectMeta}
+	}
+	dpl := cached(map[string]string{"owner": "team-a", "noise": "1"})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"annotations-deployment-collector", WithAllowedAnnotations("owner"))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	filter := changedFilter()
+
+	update := func(step string, annotations map[string]string) []events.Interface {
+		t.Helper()
+		old := dpl
+		dpl = cached(annotations)
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(old), old); err != nil {
+			t.Fatal(err)
+		}
+		dpl.ResourceVersion = old.ResourceVersion
+		if err := cl.Update(ctx, dpl); err != nil {
+			t.Fatalf("%s: unable to update deployment: %v", step, err)
+		}
+		// The updates are filtered as the ones of the cached objects.
+		if !filter.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: dpl}) {
+			return nil
+		}
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		evts := queue.evts
+		queue.pop()
+		return evts
+	}
+
+	if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	queue.pop()
+
+	// The changes of the annotations not kept in the cache are not seen.
+	if evts := update("other annotation changed", map[string]string{"owner": "team-a", "noise": "2"}); len(evts) != 0 {
+		t.Errorf("expected no event, got %v", evts)
+	}
+	evts := update("allowed annotation changed", map[string]string{"owner": "team-b", "noise": "2"})
+	if len(evts) != 1 || evts[0].Type() != events.Update {
+		t.Fatalf("expected an Update event, got %v", evts)
+	}
+	want := &metadata.MetaDiff{Annotations: &metadata.KeysDiff{Changed: []string{"owner"}}}
+	if diff := evts[0].GRPCMessage().GetMetaDiff(); !proto.Equal(diff, want) {
+		t.Errorf("expected diff %v, got %v", want, diff)
+	}
+}
+
+func TestMetaPatchOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"app": "web", "version": "v1"}, Annotations: map[string]string{"owner": "team-a"}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"patch-deployment-collector")
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	reconcile := func(step string) *metadata.Event {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if len(queue.evts) != 1 {
+			t.Fatalf("%s: expected one event, got %v", step, queue.pop())
+		}
+		msg := queue.evts[0].GRPCMessage()
+		queue.pop()
+		return msg
+	}
+
+	previous := reconcile("created")
+	if previous.MetaPatch != nil {
+		t.Errorf("expected no patch in the Create event, got %s", previous.GetMetaPatch())
+	}
+	// Each patch turns the metadata sent in the last event into the current ones.
+	for _, version := range []string{"v2", "v3"} {
+		dpl.Labels["version"] = version
+		if err := cl.Update(ctx, dpl); err != nil {
+			t.Fatalf("unable to update deployment: %v", err)
+		}
+		msg := reconcile("updated to " + version)
+		patch, err := jsonpatch.DecodePatch([]byte(msg.GetMetaPatch()))
+		if err != nil {
+			t.Fatalf("%s: unable to decode the patch %q: %v", version, msg.GetMetaPatch(), err)
+		}
+		patched, err := patch.Apply([]byte(previous.GetMeta()))
+		if err != nil {
+			t.Fatalf("%s: unable to apply the patch: %v", version, err)
+		}
+		if !jsonpatch.Equal(patched, []byte(msg.GetMeta())) {
+			t.Errorf("%s: expected the patched metadata %s, got %s", version, msg.GetMeta(), patched)
+		}
+		previous = msg
+	}
+}
+
+func TestNodeDeltaOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"version": "v1"}}}
+	podA := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-a-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	}
+	podB := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-fghij", Namespace: "default", UID: "pod-b-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node-b"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, podA).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"node-delta-deployment-collector")
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b")
+
+	// reconcile returns the Update event generated after the labels of the deployment changed to the given version.
+	reconcile := func(step, version string) *metadata.Event {
+		t.Helper()
+		if version != "" {
+			dpl.Labels = map[string]string{"version": version}
+			if err := cl.Update(ctx, dpl); err != nil {
+				t.Fatalf("%s: unable to update deployment: %v", step, err)
+			}
+		}
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		var update *metadata.Event
+		for _, evt := range queue.evts {
+			if evt.Type() == events.Update {
+				update = evt.GRPCMessage()
+			}
+		}
+		queue.pop()
+		return update
+	}
+
+	reconcile("created", "")
+
+	// The pod scheduled on node-b adds the node, along with the Create event for its subscriber.
+	if err := cl.Create(ctx, podB); err != nil {
+		t.Fatalf("unable to create pod: %v", err)
+	}
+	update := reconcile("node added", "v2")
+	if update == nil {
+		t.Fatal("expected an Update event")
+	}
+	if added, removed := update.GetAddedNodes(), update.GetRemovedNodes(); !reflect.DeepEqual(added, []string{"node-b"}) ||
+		len(removed) != 0 {
+		t.Errorf("expected node-b to be added, got added %v and removed %v", added, removed)
+	}
+
+	// The pod gone from node-a removes the node.
+	if err := cl.Delete(ctx, podA); err != nil {
+		t.Fatalf("unable to delete pod: %v", err)
+	}
+	update = reconcile("node removed", "v3")
+	if update == nil {
+		t.Fatal("expected an Update event")
+	}
+	if added, removed := update.GetAddedNodes(), update.GetRemovedNodes(); len(added) != 0 ||
+		!reflect.DeepEqual(removed, []string{"node-a"}) {
+		t.Errorf("expected node-a to be removed, got added %v and removed %v", added, removed)
+	}
+
+	// The Update events without node changes carry no delta.
+	update = reconcile("labels changed", "v4")
+	if update == nil {
+		t.Fatal("expected an Update event")
+	}
+	if added, removed := update.GetAddedNodes(), update.GetRemovedNodes(); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no node changes, got added %v and removed %v", added, removed)
+	}
+}
+
+func TestEventSequence(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"version": "v1"}}}
+	cl := fake.NewClientBuilder().WithObjects(dpl,
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-a-uid"},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "dpl-fghij", Namespace: "default", UID: "pod-b-uid"},
+			Spec:       corev1.PodSpec{NodeName: "node-b"},
+		}).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"sequence-deployment-collector")
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+
+	// reconcile returns the sequences of the generated events, by type and subscriber.
+	reconcile := func(step string) map[string]uint64 {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		sequences := make(map[string]uint64)
+		for _, evt := range queue.evts {
+			for sub := range evt.Subscribers() {
+				sequences[evt.Type()+"/"+sub] = evt.GRPCMessage().GetSequence()
+			}
+		}
+		queue.pop()
+		return sequences
+	}
+	update := func(version string) {
+		t.Helper()
+		dpl.Labels = map[string]string{"version": version}
+		if err := cl.Update(ctx, dpl); err != nil {
+			t.Fatalf("unable to update deployment: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		mutate func()
+		want   map[string]uint64
+	}{
+		{"created", func() {}, map[string]uint64{"Create/subscriber-a": 1}},
+		{"updated", func() { update("v2") }, map[string]uint64{"Update/subscriber-a": 2}},
+		{"unchanged", func() {}, map[string]uint64{}},
+		// The new subscriber receives the current version, the sequence does not change.
+		{"subscribed", func() { collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b") },
+			map[string]uint64{"Create/subscriber-b": 2}},
+		{"updated again", func() { update("v3") }, map[string]uint64{"Update/subscriber-a": 3, "Update/subscriber-b": 3}},
+		{"deleted", func() {
+			if err := cl.Delete(ctx, dpl); err != nil {
+				t.Fatalf("unable to delete deployment: %v", err)
+			}
+		}, map[string]uint64{"Delete/subscriber-a": 4, "Delete/subscriber-b": 4}},
+	}
+	for _, step := range steps {
+		step.mutate()
+		if got := reconcile(step.name); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: expected sequences %v, got %v", step.name, step.want, got)
+		}
+	}
+}
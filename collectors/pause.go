@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// pause gates the reconciles of a collector paused at runtime, e.g. for maintenance. While the collector is paused
+// its reconciles push no events and leave its cache untouched, and the refreshes of its resources are skipped. When it
+// resumes its resources are resynced, so that the subscribers receive the changes missed in the meantime. It is embedded
+// in the collectors, which are paused and resumed through its SetPaused and Paused methods.
+type pause struct {
+	paused atomic.Bool
+	// requeue is the delay after which the requests received while paused are reconciled again. A zero delay drops
+	// them, the resync run on resume catches up with their resources.
+	requeue time.Duration
+	// gauge is set to 1 while the collector is paused.
+	gauge prometheus.Gauge
+	// resyncRequests asks the dispatcher of the collector to resync the resources when it resumes.
+	resyncRequests chan<- struct{}
+}
+
+// newPause returns the pause of a running collector, reporting its state in the given gauge. The resync of the
+// resources is requested on the given channel when the collector resumes.
+func newPause(gauge prometheus.Gauge, requeue time.Duration, resyncRequests chan<- struct{}) *pause {
+	gauge.Set(0)
+	return &pause{requeue: requeue, gauge: gauge, resyncRequests: resyncRequests}
+}
+
+// SetPaused pauses or resumes the collector at runtime. While paused its reconciles push no events, and when it resumes
+// the resources are resynced: the subscribers receive the changes missed in the meantime.
+func (p *pause) SetPaused(paused bool) {
+	if p.set(paused) && !paused {
+		requestResync(p.resyncRequests)
+	}
+}
+
+// Paused returns true if the collector is paused.
+func (p *pause) Paused() bool {
+	return p.active()
+}
+
+// skip returns true if the reconcile must be skipped because the collector is paused, along with its result: the
+// request is dropped, or requeued, and the resources are resynced when the collector resumes. The skipped reconcile
+// must not count for the replays waiting for the request, they complete once it is reconciled after the resume.
+func (p *pause) skip(logger logr.Logger) (ctrl.Result, bool) {
+	if !p.active() {
+		return ctrl.Result{}, false
+	}
+	logger.V(3).Info("collector paused, skipping the reconcile")
+	return ctrl.Result{RequeueAfter: p.requeue}, true
+}
+
+// set pauses or resumes the collector. It returns true if the state changed.
+func (p *pause) set(paused bool) bool {
+	if p.paused.Swap(paused) == paused {
+		return false
+	}
+	if paused {
+		p.gauge.Set(1)
+	} else {
+		p.gauge.Set(0)
+	}
+	return true
+}
+
+// active returns true if the collector is paused. A nil pause is never active.
+func (p *pause) active() bool {
+	return p != nil && p.paused.Load()
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPauseCollector(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid",
+			Labels: map[string]string{"tier": "web"}},
+		Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	collector := NewServiceCollector(cl, queue, cache, "paused-service-collector", WithPausedRequeue(time.Minute))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}
+	gauge := defaultMetrics.paused.WithLabelValues("paused-service-collector")
+
+	// reconcile reconciles the service and checks the events pushed to the queue and the requeue delay.
+	reconcile := func(step string, requeue time.Duration, want []string) {
+		t.Helper()
+		res, err := collector.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if res.RequeueAfter != requeue {
+			t.Errorf("%s: expected a requeue after %s, got %s", step, requeue, res.RequeueAfter)
+		}
+		if got := queue.pop(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	reconcile("running", 0, []string{events.Create})
+
+	collector.SetPaused(true)
+	if !collector.Paused() || testutil.ToFloat64(gauge) != 1 {
+		t.Fatalf("expected the collector to be paused, gauge %v", testutil.ToFloat64(gauge))
+	}
+	if len(collector.resyncRequests) != 0 {
+		t.Error("expected no resync to be requested when pausing")
+	}
+	svc.Labels["tier"] = "db"
+	if err := cl.Update(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	reconcile("paused", time.Minute, []string{})
+	if refreshed := newRefresher(resource.Service, "", time.Minute, cache, queue, collector.pause).refresh(); refreshed != 0 {
+		t.Errorf("expected no refresh while paused, got %d", refreshed)
+	}
+
+	// Resuming resyncs the resources, whose reconciles send the changes missed while paused.
+	collector.SetPaused(false)
+	if collector.Paused() || testutil.ToFloat64(gauge) != 0 {
+		t.Fatalf("expected the collector to be resumed, gauge %v", testutil.ToFloat64(gauge))
+	}
+	select {
+	case <-collector.resyncRequests:
+	default:
+		t.Fatal("expected a resync to be requested when resuming")
+	}
+	reconcile("resumed", 0, []string{events.Update})
+
+	// Resuming a running collector is a no-op.
+	collector.SetPaused(false)
+	if len(collector.resyncRequests) != 0 {
+		t.Error("expected no resync to be requested when resuming a running collector")
+	}
+}
+
+func TestPauseHoldsReplays(t *testing.T) {
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	cl := fake.NewClientBuilder().WithObjects(pod, ns).Build()
+
+	queue := &recordingQueue{}
+	collector := NewPodCollector(cl, queue, events.NewCache(), "replay-paused-pod-collector")
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	// The subscriber connects while the collector is paused, the pod is dispatched for its replay.
+	collector.SetPaused(true)
+	replayed := false
+	rp := collector.replays.start(func() { replayed = true })
+	collector.replays.dispatched(rp, req.NamespacedName)
+	collector.replays.seal(rp)
+
+	if _, err := collector.Reconcile(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replayed {
+		t.Fatal("expected the replay to wait while the collector is paused")
+	}
+	if got := queue.pop(); len(got) != 0 {
+		t.Fatalf("expected no events while paused, got %v", got)
+	}
+
+	// The reconcile run after the resume sends the pod and completes the replay.
+	collector.SetPaused(false)
+	if _, err := collector.Reconcile(ctx, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := queue.pop(); !reflect.DeepEqual(got, []string{events.Create}) {
+		t.Errorf("expected the pod to be sent once resumed, got %v", got)
+	}
+	if !replayed {
+		t.Error("expected the replay to complete once the pod has been reconciled")
+	}
+}
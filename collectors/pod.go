@@ -18,15 +18,22 @@ package collectors
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"sort"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
 	"github.com/mitchellh/hashstructure/v2"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
@@ -35,11 +42,13 @@ import (
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -56,69 +65,239 @@ type PodCollector struct {
 	// subscriberChan where new subscribers notify their presence.
 	subscriberChan subscriber.SubsChan
 	logger         logr.Logger
+	baseLogger     logr.Logger
+	predicates     []predicate.Predicate
 	// dispatcherSource is used to get events enqueued by the dispatcher based
 	// on subscribers' arrival.
 	dispatcherSource source.Source
 	// dispatcherChan is the channel where the dispatcher pushes the new requests to be enqueued and
 	// processed by the reconciler.
 	dispatcherChan chan event.GenericEvent
+	// relatedSource is used to get the requests of the pods related to the changes of other resources, e.g. the pods
+	// running on a node whose labels changed.
+	relatedSource source.Source
+	// relatedChan is the channel where the related dispatchers push the requests of the pods.
+	relatedChan chan event.GenericEvent
+	// informers is used to wait for the initial sync of the informers before accepting subscribers.
+	informers cache.Informers
+	// barrier where the collector signals the completion of its initial sync.
+	barrier *health.Barrier
+	// healthRegistry where the collector reports the outcome of its reconciles.
+	healthRegistry *health.Registry
+	// syncStatus where the collector reports the sync of its caches and the progress of its initial sweep.
+	syncStatus *health.SyncStatus
+	// metrics where the collector records its events and reconciles.
+	metrics *collectorMetrics
+	// filter excludes the objects from the collection.
+	filter *objectFilter
+	// resyncRequests asks the dispatcher to resync the resources, e.g. when the selectors change.
+	resyncRequests chan struct{}
+	// apiReader confirms the deletion of the resources missing from the cache, nil to trust the cache.
+	apiReader client.Reader
+	// metaTransforms applied in order to the metadata of the resources before their serialization.
+	metaTransforms []MetaTransform
+	// pause gates the reconciles while the collector is paused at runtime.
+	*pause
+	// metaKeys restricts the labels and annotations forwarded in the metadata of the resources.
+	metaKeys metaKeys
+	// metaLimit caps the size of the serialized metadata of the resources.
+	metaLimit metaLimit
+	// metaEncoder serializes the metadata of the resources, encoding/json if nil.
+	metaEncoder MetaEncoder
+	// clusterName stamped on the events, empty if not configured.
+	clusterName string
+	// replays tracks the reconciles dispatched to the new subscribers, to notify them when their events are queued.
+	replays *replays
+	// indexRegistry through which the collector registers the field indexers it needs.
+	indexRegistry *IndexRegistry
+	// resyncPeriod is the period of the full resync. A zero value disables it.
+	resyncPeriod time.Duration
+	// ttl of the metadata stamped on the events and refreshed by the dispatcher. A zero value disables it.
+	ttl time.Duration
+	// jitter is the factor of the jitter added to the resync period and to the backoff of the retries.
+	jitter float64
+	// rateLimiter are the settings of the rate limiter of the retries of the reconciles.
+	rateLimiter RateLimiterSettings
+	// namespaces where the collector reconciles the objects. If empty, all the namespaces are watched.
+	namespaces []string
+	// nodesMemo is invalidated when the pods change, before their reconcile is enqueued.
+	nodesMemo *NodesMemo
+	// namespaceCascade is entered while reconciling the pods, whose namespaces could be swept concurrently.
+	namespaceCascade *NamespaceCascade
+	// coalesceWindow within which the changes to the same pod are coalesced. A zero value disables it.
+	coalesceWindow time.Duration
+	// debounceWindow within which the requests for the same pod triggered by the external sources are collapsed.
+	debounceWindow time.Duration
+	// includeTerminated is true if the pods in a terminal phase relate their node to the resources.
+	includeTerminated bool
+	// minAge defers the resources younger than a threshold until they persist past it.
+	minAge *minAge
+	// nodeLabels projects the configured labels of the nodes in the metadata of their pods. If nil, the pods are not
+	// enriched.
+	nodeLabels *nodeLabels
 	// subscribers current subscribers that are interested for pod resources.
 	subscribers *subscriber.Subscribers
+	// invalidOptions are the problems of the options the collector has been created with, returned by its setup.
+	invalidOptions error
+	// dispatcher delivers the metadata of the existing resources to the subscribers.
+	dispatcher Dispatcher
 }
 
 // NewPodCollector returns a new pod collector.
 func NewPodCollector(cl client.Client, queue broker.Queue, cache *events.Cache, name string, opt ...CollectorOption) *PodCollector {
-	opts := collectorOptions{}
+	opts := collectorOptions{subscribers: subscriber.NewSubscribers()}
 	for _, o := range opt {
 		o(&opts)
 	}
 
+	// The collector is not ready until its initial sync has completed.
+	if opts.barrier != nil {
+		opts.barrier.Register(name)
+	}
+	// The collector is healthy until its reconciles keep failing, or get stuck, for too long.
+	opts.healthRegistry.Register(name)
+	// The collector is not ready until its initial sweep has completed.
+	opts.syncStatus.RegisterCollector(name)
+
 	dc := make(chan event.GenericEvent, 1)
+	rc := make(chan event.GenericEvent, 1)
+	resyncRequests := make(chan struct{}, 1)
+	metrics, err := registerCollectorMetrics(opts.metricsRegisterer, opts.metricsPrefix)
+	invalidOptions := errors.Join(opts.validate(queue), err)
 
 	return &PodCollector{
-		Client:           cl,
-		queue:            queue,
-		cache:            cache,
-		ownersSources:    opts.ownerSources,
-		endpointsSource:  opts.externalSource,
-		name:             name,
-		subscriberChan:   opts.subscriberChan,
-		dispatcherSource: &source.Channel{Source: dc},
-		dispatcherChan:   dc,
-		subscribers:      subscriber.NewSubscribers(),
+		Client:            instrumentClient(cl, metrics.clientCalls, name),
+		queue:             queue,
+		cache:             cache,
+		ownersSources:     opts.ownerSources,
+		endpointsSource:   opts.externalSource,
+		name:              name,
+		subscriberChan:    opts.subscriberChan,
+		dispatcherSource:  &source.Channel{Source: dc},
+		dispatcherChan:    dc,
+		relatedSource:     &source.Channel{Source: rc},
+		relatedChan:       rc,
+		subscribers:       opts.subscribers,
+		barrier:           opts.barrier,
+		healthRegistry:    opts.healthRegistry,
+		syncStatus:        opts.syncStatus,
+		metrics:           metrics,
+		filter:            newObjectFilter(opts.labelSelector, opts.annotationSelector, opts.excludedNames),
+		resyncRequests:    resyncRequests,
+		apiReader:         instrumentReader(opts.apiReader, metrics.clientCalls, name, schemeOf(cl)),
+		logger:            namedLogger(opts.logger, name),
+		baseLogger:        opts.logger,
+		predicates:        opts.predicates,
+		indexRegistry:     opts.indexRegistry,
+		replays:           newReplays(),
+		metaTransforms:    metaTransforms(&opts),
+		metaKeys:          opts.metaKeys,
+		pause:             newPause(metrics.paused.WithLabelValues(name), opts.pausedRequeue, resyncRequests),
+		metaLimit:         opts.metaLimit,
+		metaEncoder:       opts.metaEncoder,
+		clusterName:       opts.clusterName,
+		resyncPeriod:      opts.resyncPeriod,
+		ttl:               opts.ttl,
+		jitter:            opts.jitter,
+		rateLimiter:       opts.rateLimiter,
+		namespaces:        opts.namespaces,
+		nodesMemo:         opts.nodesMemo,
+		namespaceCascade:  opts.namespaceCascade,
+		coalesceWindow:    opts.coalesceWindow,
+		debounceWindow:    opts.debounceWindow,
+		includeTerminated: opts.includeTerminated,
+		minAge:            newMinAge(opts.minAge),
+		nodeLabels:        newNodeLabels(opts.nodeLabels),
+		invalidOptions:    invalidOptions,
+		dispatcher:        dispatcherOrDefault(opts.dispatcher),
 	}
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 
 // Reconcile generates events to be sent to nodes when changes are detected for the watched resources.
-func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "Reconcile", resource.Pod, req.Namespace)
+	reconciled := pc.healthRegistry.Start(pc.name)
+	replayed := pc.replays.reconciling(req.NamespacedName)
+	phases := pc.metrics.startReconcilePhases(pc.name)
+	defer func() {
+		tracing.End(span, err)
+		reconciled(err)
+		replayed()
+		phases.done(err)
+		pc.syncStatus.Reconciled(pc.name, req.NamespacedName.String())
+	}()
+
 	var pod corev1.Pod
 	var pRes *events.Resource
 	var cEntry *events.CacheEntry
+	// snapshot is the state of the cache entry before the changes made for the events, restored if they are not pushed.
+	var snapshot events.CacheSnapshot
 
-	var err error
-	var ok, podDeleted bool
+	var ok, podDeleted, podTerminated bool
 	logReq := log.FromContext(ctx)
 
+	// Without a queue, or once it is closed during the shutdown, the events would be lost: the cache is left untouched
+	// so that it keeps matching what the subscribers received.
+	if broker.IsClosed(pc.queue) {
+		logReq.V(2).Info("queue closed, skipping the reconcile")
+		return ctrl.Result{}, nil
+	}
+	// While the collector is paused no events are pushed. The skipped reconcile does not complete the replays waiting for
+	// the request, or their subscribers would drop the resources never sent: they complete with the resync on resume.
+	if result, paused := pc.pause.skip(logReq); paused {
+		replayed = func() {}
+		return result, nil
+	}
+	key := pc.cache.Key(resource.Pod, req.NamespacedName)
+
 	err = pc.Get(ctx, req.NamespacedName, &pod)
-	if err != nil && !k8sApiErrors.IsNotFound(err) {
+	phases.notFound = k8sApiErrors.IsNotFound(err)
+	if err != nil && !phases.notFound {
 		logReq.Error(err, "unable to get resource")
 		return ctrl.Result{}, err
 	}
+	// The resources of a namespace being swept wait for the sweep, and are skipped once it is over.
+	leave, entered := pc.namespaceCascade.enter(req.Namespace)
+	if !entered {
+		return skipDeletedNamespace(logReq, phases.notFound), nil
+	}
+	defer leave()
 
 	logReq = logReq.WithValues("node", pod.Spec.NodeName)
 
-	if k8sApiErrors.IsNotFound(err) {
+	// An ignored resource, or one not matching the selectors, is handled as a deleted one: the subscribers that
+	// received it get a Delete event.
+	ignored := err == nil && pc.filter.excluded(&pod)
+	if ignored {
+		logReq.V(3).Info("resource excluded by annotation or selectors", "annotation", consts.IgnoreAnnotation)
+	}
+
+	if k8sApiErrors.IsNotFound(err) || ignored {
 		// When the k8s resource get deleted we need to remove it from the local cache.
-		if _, ok = pc.cache.Get(req.String()); ok {
-			logReq.V(3).Info("marking resource for deletion")
-			podDeleted = true
-		} else {
+		if cEntry, ok = pc.cache.Get(key); !ok {
 			return ctrl.Result{}, nil
 		}
+		// The informers could be relisting, the resource is deleted once the api-server confirms it.
+		var exists bool
+		if !ignored {
+			gvk := corev1.SchemeGroupVersion.WithKind(resource.Pod)
+			if exists, err = existsOnAPIServer(ctx, pc.apiReader, gvk, req.NamespacedName, cEntry.UID); err != nil {
+				logReq.Error(err, "unable to confirm the deletion of the resource")
+				return ctrl.Result{}, err
+			}
+		}
+		if exists {
+			logReq.V(2).Info("resource missing from the cache but existing on the api-server, waiting for the cache to sync")
+			phases.staleCache = true
+			return ctrl.Result{RequeueAfter: staleCacheRequeue}, nil
+		}
+		logReq.V(3).Info("marking resource for deletion")
+		podDeleted = true
 	}
 
 	logReq.V(5).Info("pod found")
@@ -128,14 +307,23 @@ func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		// Get all subscribers for the resource based on its node name.
 		// The subscribers are used to compute to which subscribers we need to send an event
 		// and of which type, Create, Delete or Update.
+		phases.next(phaseRelation)
 		subs := pc.subscribers.GetSubscribersPerNode(pod.Spec.NodeName)
+		tracing.SetAttributes(span, tracing.NodeKey.String(pod.Spec.NodeName), tracing.SubscribersKey.Int(len(subs)))
 		// If no subscribers, just exit.
 		if subs == nil {
 			// Make sure to remove the cache entry for the resource.
 			// This could happen when a subscriber closes its connection.
-			pc.cache.Delete(req.String())
+			pc.cache.Delete(key)
 			return ctrl.Result{}, nil
 		}
+		// A pod never sent is deferred until it is old enough.
+		if !pc.cache.Has(key) {
+			if wait := pc.minAge.wait(pod.CreationTimestamp); wait > 0 {
+				logReq.V(3).Info("deferring the resource until it reaches the minimum age", "requeueAfter", wait)
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+		}
 
 		// Create a new events.Resource and fill its fields.
 		pRes = events.NewResource(resource.Pod, string(pod.UID))
@@ -153,8 +341,9 @@ func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			return ctrl.Result{}, err
 		}
 		// Fill resource fields.
-		if err = pc.objFieldsHandler(logReq, pRes, &pod); err != nil {
-			return ctrl.Result{}, err
+		phases.next(phaseSerialize)
+		if err = pc.objFieldsHandler(ctx, logReq, pRes, &pod); err != nil {
+			return ctrl.Result{}, serializationError(resource.Pod, req.NamespacedName, err)
 		}
 
 		// Hash the current resource.
@@ -165,39 +354,54 @@ func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		}
 
 		// Check if we have cached the resource previously.
-		if cEntry, ok = pc.cache.Get(req.String()); ok {
+		snapshot = pc.cache.Snapshot(key)
+		if cEntry, ok = pc.cache.Get(key); ok {
 			// If an entry exists for the resource then check if the hashes are the same.
 			// If not, it means that the resource fields have changes since the last time.
 			// so mark the resource as updated. The "update" flag is needed to generate "Update"
 			// events for the related subscribers.
 			if cEntry.Hash != hash {
 				pRes.SetUpdate(true)
-				cEntry.Hash = hash
+				pc.cache.SetHash(cEntry, hash)
+				// The changed label and annotation keys are attached to the Update events.
+				pRes.SetPreviousMeta(cEntry.Meta, cEntry.Labels, cEntry.Annotations)
+				pc.cache.SetMeta(cEntry, pRes.Meta, pRes.Labels, pRes.Annotations)
+				// The phase is part of the hashed status, a terminated pod changes its hash only when entering
+				// the terminal phase or when its metadata changes.
+				podTerminated = !pc.includeTerminated && isTerminated(&pod)
+				// Each change of the resource is numbered in its events.
+				pc.cache.NextSequence(cEntry)
 			}
 			// Set the previous subscribers in the current resource.
 			pRes.SetSubscribers(cEntry.Subs)
 		} else {
 			// If we never cached the resource then create an entry and add it to the cache.
 			cEntry = &events.CacheEntry{
-				Hash: hash,
-				UID:  pod.UID,
-				Subs: nil,
+				Hash:     hash,
+				UID:      pod.UID,
+				Subs:     nil,
+				Sequence: 1,
 			}
-			pc.cache.Add(req.String(), cEntry)
+			pc.cache.Add(key, cEntry)
+			pc.cache.SetMeta(cEntry, pRes.Meta, pRes.Labels, pRes.Annotations)
 		}
+		pRes.SetSequence(cEntry.Sequence)
 
 		// Generate the subscribers, and save them in the entry cache.
-		cEntry.Subs = pRes.GenerateSubscribers(subs)
+		pc.cache.SetSubscribers(cEntry, pRes.GenerateSubscribers(subs))
+		indexNodes(pc.cache, pRes, key, []string{pod.Spec.NodeName})
 		// Save the references. Needed when the resource is deleted.
-		cEntry.Refs = pRes.GetResourceReferences()
+		pc.cache.SetReferences(cEntry, pRes.GetResourceReferences())
 	} else {
 		// Check if we have cached the resource.
-		if cEntry, ok = pc.cache.Get(req.String()); ok {
+		snapshot = pc.cache.Snapshot(key)
+		if cEntry, ok = pc.cache.Get(key); ok {
 			// Create the resource.
 			pRes = events.NewResource(resource.Pod, string(cEntry.UID))
 			// Set the previous subscribers and references.
 			pRes.SetSubscribers(cEntry.Subs)
 			pRes.ResourceReferences = cEntry.Refs
+			pRes.SetSequence(pc.cache.NextSequence(cEntry))
 			// The resource has been deleted. We need to send a delete event to
 			// the subscribers. By generating the subscribers from an empty set,
 			// is the same as to generate delete events for all the subscribers to which
@@ -205,7 +409,7 @@ func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			pRes.GenerateSubscribers(nil)
 			// We are ready to remove the entry from the cache. No need to track anymore
 			// the deleted resource.
-			pc.cache.Delete(req.String())
+			pc.cache.Delete(key)
 		} else {
 			// It means that we received a delete event for a resource that we never sent to any subscriber.
 			// In this case we just return.
@@ -214,22 +418,31 @@ func (pc *PodCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	}
 
 	// At this point our resource has all the necessary bits to know for each node which type of events need to be sent.
+	phases.next(phaseDispatch)
+	pRes.SetSpanContext(span.SpanContext())
+	pRes.SetCluster(pc.clusterName)
+	pRes.SetTTL(pc.ttl)
+	pRes.SetOrigin(pc.name, req.NamespacedName)
 	evts := pRes.ToEvents()
 
 	// Enqueue events.
+	// The owners recompute their nodes when the pod lands on a node or leaves it. A terminated pod does not relate its
+	// node to its owners anymore, they need to recompute their subscribers too.
+	triggerOwners := podTerminated
 	for _, evt := range evts {
 		if evt == nil {
 			continue
 		}
-		switch evt.Type() {
-		case "Create":
-			// Perform actions for "Create" events.
-			pc.triggerOwnersOnCreateEvent(pRes)
-		case "Delete":
-			pc.triggerOwnersOnDeleteEvent(pRes)
+		if evt.Type() == events.Create || evt.Type() == events.Delete {
+			triggerOwners = true
 		}
 		// Push event to the queue.
-		pc.queue.Push(evt)
+		if err = pc.queue.Push(evt); err != nil {
+			return pushFailed(logReq, pc.cache, snapshot, err)
+		}
+	}
+	if triggerOwners {
+		pc.triggerOwners(ctx, pRes)
 	}
 
 	return ctrl.Result{}, nil
@@ -254,8 +467,11 @@ func (pc *PodCollector) ownerRefsHandler(ctx context.Context, logger logr.Logger
 		}})
 		// If we are handling a replicaset, then fetch it and check if it has an owner.
 		if owner.Kind == resource.ReplicaSet {
-			replicaset := NewPartialObjectMetadata(resource.ReplicaSet, nil)
-			err := pc.Get(ctx, types.NamespacedName{
+			replicaset, err := PartialObjectMetadataForGVK(appsv1.SchemeGroupVersion.WithKind(resource.ReplicaSet), nil)
+			if err != nil {
+				return err
+			}
+			err = pc.Get(ctx, types.NamespacedName{
 				Namespace: pod.Namespace,
 				Name:      owner.Name,
 			}, replicaset)
@@ -285,26 +501,22 @@ func (pc *PodCollector) serviceRefsHandler(ctx context.Context, logger logr.Logg
 		return nil
 	}
 
-	// List all the services in the pod's namespace.
-	services := corev1.ServiceList{}
-	err := pc.List(ctx, &services, &client.ListOptions{Namespace: pod.Namespace})
+	// List the services selecting the pod.
+	services, err := selectingServices(ctx, pc.Client, pod)
 	if err != nil {
 		logger.Error(err, "unable to get services list", "in namespace", pod.Namespace)
 		return err
 	}
 	var svcRefs []fields.Reference
-	for i := range services.Items {
-		sel := labels.SelectorFromValidatedSet(services.Items[i].Spec.Selector)
-		if !sel.Empty() && sel.Matches(labels.Set(pod.GetLabels())) {
-			logger.V(3).Info("found service related to resource", "Service", klog.KRef(services.Items[i].Namespace, services.Items[i].Name))
-			svcRefs = append(svcRefs, fields.Reference{
-				Name: types.NamespacedName{
-					Namespace: services.Items[i].Namespace,
-					Name:      services.Items[i].Name,
-				},
-				UID: services.Items[i].UID,
-			})
-		}
+	for i := range services {
+		logger.V(3).Info("found service related to resource", "Service", klog.KRef(services[i].Namespace, services[i].Name))
+		svcRefs = append(svcRefs, fields.Reference{
+			Name: types.NamespacedName{
+				Namespace: services[i].Namespace,
+				Name:      services[i].Name,
+			},
+			UID: services[i].UID,
+		})
 	}
 
 	// Need to sort for the hashing function.
@@ -317,11 +529,14 @@ func (pc *PodCollector) serviceRefsHandler(ctx context.Context, logger logr.Logg
 }
 
 // objFieldsHandler populates the resource from the object.
-func (pc *PodCollector) objFieldsHandler(logger logr.Logger, res *events.Resource, pod *corev1.Pod) error {
+func (pc *PodCollector) objFieldsHandler(ctx context.Context, logger logr.Logger, res *events.Resource, pod *corev1.Pod) (err error) {
 	if pod == nil {
 		return nil
 	}
 
+	_, span := tracing.Start(ctx, "ObjFieldsHandler", resource.Pod, pod.Namespace)
+	defer func() { tracing.End(span, err) }()
+
 	podUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
 	if err != nil {
 		logger.Error(err, "unable to convert to unstructured")
@@ -335,12 +550,30 @@ func (pc *PodCollector) objFieldsHandler(logger logr.Logger, res *events.Resourc
 	for _, key := range metaUnused {
 		delete(metaMap, key)
 	}
+	// Only the allowed labels and annotations are forwarded, before the metadata are transformed and truncated.
+	pc.metaKeys.filter(metaMap)
+	if err = pc.nodeLabels.enrich(ctx, pc.Client, metaMap, pod); err != nil {
+		logger.Error(err, "unable to enrich meta with the node labels")
+		return err
+	}
+	if err = transformMeta(metaMap, pc.metaTransforms); err != nil {
+		logger.Error(err, "unable to transform meta")
+		return err
+	}
 
-	metaString, err := json.Marshal(metaMap)
+	metaString, dropped, err := pc.metaLimit.marshal(pc.metaEncoder, metaMap)
 	if err != nil {
 		return err
 	}
-	res.SetMeta(string(metaString))
+	if dropped != nil {
+		logger.V(2).Info("metadata truncated to fit the maximum size", "dropped", dropped)
+		pc.metrics.metaTruncations.WithLabelValues(resource.Pod).Inc()
+	}
+	if err = setMeta(res, metaString, metaMap); err != nil {
+		return err
+	}
+	res.SetObjectMeta(dropped.objectMeta(pc.metaKeys.objectMeta(&pod.ObjectMeta)), pod.Spec.NodeName)
+	res.SetMetaTruncated(dropped != nil)
 
 	// Marshal status to json.
 	statusString, err := json.Marshal(podUn["status"])
@@ -359,12 +592,15 @@ func (pc *PodCollector) namespaceRefsHandler(ctx context.Context, logger logr.Lo
 	}
 
 	// Get the pod's namespace.
-	namespace := NewPartialObjectMetadata(resource.Namespace, nil)
+	namespace, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Namespace), nil)
+	if err != nil {
+		return err
+	}
 	nsKey := types.NamespacedName{
 		Namespace: "",
 		Name:      pod.Namespace,
 	}
-	err := pc.Get(ctx, nsKey, namespace)
+	err = pc.Get(ctx, nsKey, namespace)
 	if err != nil {
 		logger.Error(err, "unable to get", "namespace", pod.Namespace)
 		return err
@@ -378,47 +614,23 @@ func (pc *PodCollector) namespaceRefsHandler(ctx context.Context, logger logr.Lo
 	return nil
 }
 
-func (pc *PodCollector) triggerOwnersOnDeleteEvent(res *events.Resource) {
-	refs := res.GetResourceReferences()
-	for kind, refs := range refs {
-		ch, ok := pc.ownersSources[kind]
-		if !ok {
-			continue
-		}
-
-		for _, ref := range refs {
-			go func(name types.NamespacedName, kind string) {
-				var obj client.Object
-				switch kind {
-				case resource.Deployment, resource.ReplicaSet, resource.Namespace:
-					obj = NewPartialObjectMetadata(kind, &name)
-				}
-				if obj != nil {
-					ch <- event.GenericEvent{Object: obj}
-				}
-			}(ref.Name, kind)
-		}
-	}
-}
-
-func (pc *PodCollector) triggerOwnersOnCreateEvent(res *events.Resource) {
-	refs := res.GetResourceReferences()
-	for kind, refs := range refs {
+// triggerOwners triggers the reconcile of the owners of the pod, and of its namespace, so that they recompute their
+// nodes when the pod is created on a node or deleted from it, e.g. when it is rescheduled on another node. The
+// triggers are sent asynchronously and given up when the manager stops.
+func (pc *PodCollector) triggerOwners(ctx context.Context, res *events.Resource) {
+	for kind, refs := range res.GetResourceReferences() {
 		ch, ok := pc.ownersSources[kind]
 		if !ok {
 			continue
 		}
 
 		for _, ref := range refs {
-			go func(name types.NamespacedName, kind string) {
-				var obj client.Object
-				if kind == resource.Namespace {
-					obj = NewPartialObjectMetadata(kind, &name)
-				}
-				if obj != nil {
-					ch <- event.GenericEvent{Object: obj}
-				}
-			}(ref.Name, kind)
+			obj, err := PartialObjectMetadataFor(kind, &ref.Name)
+			if err != nil {
+				pc.logger.Error(err, "unable to trigger the owner of the pod", "kind", kind, "owner", ref.Name)
+				continue
+			}
+			go trigger(ctx, ch, obj)
 		}
 	}
 }
@@ -427,11 +639,70 @@ func (pc *PodCollector) triggerOwnersOnCreateEvent(res *events.Resource) {
 // using the manager. It starts go routines needed by the collector to interact with the
 // broker.
 func (pc *PodCollector) Start(ctx context.Context) error {
-	return dispatch(ctx, pc.logger, resource.Pod, pc.subscriberChan, pc.dispatcherChan, pc.Client, pc.subscribers)
+	replicaSets, err := PartialObjectMetadataForGVK(appsv1.SchemeGroupVersion.WithKind(resource.ReplicaSet), nil)
+	if err != nil {
+		return err
+	}
+	namespaces, err := PartialObjectMetadataForGVK(corev1.SchemeGroupVersion.WithKind(resource.Namespace), nil)
+	if err != nil {
+		return err
+	}
+	// Subscribers are refused until the initial sync has completed.
+	waitForInitialSync(ctx, pc.logger, pc.name, pc.informers, pc.barrier, pc.syncStatus, &corev1.Pod{},
+		&corev1.Service{}, replicaSets, namespaces)
+	return pc.dispatcher.Dispatch(ctx, &DispatchSetup{
+		Logger:         pc.logger,
+		Kind:           resource.Pod,
+		Subscriptions:  pc.subscriberChan,
+		Subscribers:    pc.subscribers,
+		Client:         pc.Client,
+		Cache:          pc.cache,
+		Queue:          pc.queue,
+		reconciles:     pc.dispatcherChan,
+		replays:        pc.replays,
+		resyncs:        pc.metrics.resyncs.WithLabelValues(resource.Pod),
+		resyncRequests: pc.resyncRequests,
+		resyncPeriod:   pc.resyncPeriod,
+		jitter:         pc.jitter,
+		refresh:        newRefresher(resource.Pod, pc.clusterName, pc.ttl, pc.cache, pc.queue, pc.pause),
+	})
+}
+
+// GetName returns the name of the collector.
+func (pc *PodCollector) GetName() string {
+	return pc.name
+}
+
+// SetSelectors replaces the label and annotation selectors of the collector, nil to not filter the pods, and resyncs
+// the pods: the subscribers receive the Delete events of the pods no longer selected and the Create events of the
+// newly selected ones.
+func (pc *PodCollector) SetSelectors(labelSelector, annotationSelector labels.Selector) {
+	pc.filter.set(labelSelector, annotationSelector)
+	requestResync(pc.resyncRequests)
+}
+
+// SetExcludedNames replaces the patterns of the names of the excluded pods, and resyncs the pods: the subscribers
+// receive the Delete events of the pods newly excluded and the Create events of the ones no longer excluded.
+func (pc *PodCollector) SetExcludedNames(patterns []string) {
+	pc.filter.setExcludedNames(patterns)
+	requestResync(pc.resyncRequests)
+}
+
+// Indexers returns the field indexers needed by the collector. The pods are listed by node when dispatching the
+// resources to the new subscribers, and the services selecting them are looked up by selector.
+func (pc *PodCollector) Indexers() []Indexer {
+	return []Indexer{PodByNodeIndexer, ServiceBySelectorIndexer}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (pc *PodCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if pc.invalidOptions != nil {
+		return fmt.Errorf("invalid options: %w", pc.invalidOptions)
+	}
+	if err := registerIndexers(mgr, pc.indexRegistry, pc.Indexers()); err != nil {
+		return err
+	}
+
 	nodeNameFilter := func(obj client.Object) bool {
 		// Check if the object is a pod.
 		p, ok := obj.(*corev1.Pod)
@@ -446,22 +717,57 @@ func (pc *PodCollector) SetupWithManager(mgr ctrl.Manager) error {
 		return false
 	}
 	// Set the generic logger to be used in other function then the reconcile loop.
-	pc.logger = mgr.GetLogger().WithName(pc.name)
+	base := baseLogger(pc.baseLogger, mgr)
+	pc.logger = base.WithName(pc.name)
+	pc.informers = mgr.GetCache()
 
-	lc, err := newLogConstructor(mgr.GetLogger(), pc.name, resource.Pod)
+	lc, err := newLogConstructor(base, pc.name, resource.Pod)
 	if err != nil {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Pod{},
-			builder.WithPredicates(predicatesWithMetrics(pc.name, apiServerSource, nodeNameFilter))).
+	// The pods are reconciled when the projected labels of their node change.
+	if pc.nodeLabels != nil {
+		if err := (&relatedDispatcher{
+			Client:          pc.Client,
+			Name:            pc.name + "-node-labels-dispatcher",
+			ObjectKind:      resource.Node,
+			Predicate:       pc.nodeLabels.changed(),
+			Kind:            resource.Pod,
+			Related:         pc.nodeLabels.podsOnNode,
+			CollectorSource: pc.relatedChan,
+			metrics:         pc.metrics,
+		}).SetupWithManager(mgr); err != nil {
+			return err
+		}
+	}
+
+	// The pods are watched through the handler of the nodes memo, so that the memo is invalidated before the
+	// reconciles triggered by a pod change are enqueued, even when they are delayed by the coalescing.
+	bld := ctrl.NewControllerManagedBy(mgr).
+		Named("pod").
+		Watches(&corev1.Pod{},
+			sweepHandler(coalescingHandler(pc.nodesMemo.Handler(&handler.EnqueueRequestForObject{}), pc.coalesceWindow),
+				pc.syncStatus, pc.name),
+			builder.WithPredicates(pc.metrics.predicates(pc.name, apiServerSource, nodeNameFilter), changedFilter())).
 		WatchesRawSource(pc.endpointsSource,
-			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(predicatesWithMetrics(pc.name, resource.EndpointSlice, nil))).
+			debouncingHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, pc.coalesceWindow), pc.debounceWindow,
+				pc.metrics.collapsedRequests.WithLabelValues(pc.name, resource.EndpointSlice)),
+			builder.WithPredicates(pc.metrics.predicates(pc.name, resource.EndpointSlice, nil))).
 		WatchesRawSource(pc.dispatcherSource,
 			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(predicatesWithMetrics(pc.name, "dispatcher", nil))).
-		WithOptions(controller.Options{LogConstructor: lc}).
-		Complete(pc)
+			builder.WithPredicates(pc.metrics.predicates(pc.name, "dispatcher", nil))).
+		WatchesRawSource(pc.relatedSource,
+			debouncingHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, pc.coalesceWindow), pc.debounceWindow,
+				pc.metrics.collapsedRequests.WithLabelValues(pc.name, "related")),
+			builder.WithPredicates(pc.metrics.predicates(pc.name, "related", nil))).
+		WithOptions(controller.Options{LogConstructor: lc, RateLimiter: rateLimiter(pc.jitter, pc.rateLimiter)})
+
+	// The resources excluded by annotation, the ones out of the watched namespaces and the ones rejected by the
+	// configured predicates are not reconciled.
+	for _, filter := range eventFilters(pc.filter, pc.namespaces, false, pc.predicates) {
+		bld.WithEventFilter(filter)
+	}
+
+	return bld.Complete(pc)
 }
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+)
+
+// The defaults of the rate limiter of the retries, the same as the controller-runtime ones.
+const (
+	defaultRetryBaseDelay = 5 * time.Millisecond
+	defaultRetryMaxDelay  = 1000 * time.Second
+	defaultRetryQPS       = 10
+	defaultRetryBurst     = 100
+)
+
+// RateLimiterSettings are the settings of the rate limiter of the workqueue of a collector, that delays the retries of
+// the failed reconciles. Each failing resource is retried with an exponential backoff, from the base delay up to the
+// maximum delay, and all the retries are limited by a token bucket. The zero values keep the controller-runtime
+// defaults: 5ms, 1000s, 10 retries per second and a burst of 100.
+type RateLimiterSettings struct {
+	// BaseDelay is the backoff of the first retry of a resource.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum backoff of the retries of a resource.
+	MaxDelay time.Duration
+	// QPS is the rate of the token bucket shared by the retries of all the resources.
+	QPS float64
+	// Burst is the size of the token bucket.
+	Burst int
+}
+
+// IsZero returns true if none of the settings is set.
+func (s RateLimiterSettings) IsZero() bool {
+	return s == RateLimiterSettings{}
+}
+
+// withDefaults returns the settings where the unset ones have their default value.
+func (s RateLimiterSettings) withDefaults() RateLimiterSettings {
+	if s.BaseDelay == 0 {
+		s.BaseDelay = defaultRetryBaseDelay
+	}
+	if s.MaxDelay == 0 {
+		s.MaxDelay = max(defaultRetryMaxDelay, s.BaseDelay)
+	}
+	if s.QPS == 0 {
+		s.QPS = defaultRetryQPS
+	}
+	if s.Burst == 0 {
+		s.Burst = defaultRetryBurst
+	}
+	return s
+}
+
+// newRateLimiter returns the rate limiter with the given settings, built as the controller-runtime default one.
+func newRateLimiter(settings RateLimiterSettings) ratelimiter.RateLimiter {
+	settings = settings.withDefaults()
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(settings.BaseDelay, settings.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(settings.QPS), settings.Burst)},
+	)
+}
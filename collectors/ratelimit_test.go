@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRateLimiter(t *testing.T) {
+	settings := RateLimiterSettings{BaseDelay: time.Second, MaxDelay: 3 * time.Second, QPS: 0.1, Burst: 5}
+	collector := NewObjectMetaCollector(fake.NewClientBuilder().Build(), &recordingQueue{}, events.NewCache(),
+		NewPartialObjectMetadata(resource.Deployment, nil), "rate-limited-collector", WithRateLimiter(settings))
+	if collector.rateLimiter != settings {
+		t.Fatalf("expected the collector to be configured with %+v, got %+v", settings, collector.rateLimiter)
+	}
+
+	// The backoff of an item starts from the base delay and is capped by the max delay.
+	limiter := rateLimiter(0, collector.rateLimiter)
+	if limiter == nil {
+		t.Fatal("expected a custom rate limiter")
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if got := limiter.When("item"); got != want {
+			t.Errorf("retry %d: expected a backoff of %s, got %s", i, want, got)
+		}
+	}
+	if got := limiter.NumRequeues("item"); got != 4 {
+		t.Errorf("expected 4 requeues, got %d", got)
+	}
+	limiter.Forget("item")
+
+	// The token bucket, whose tokens have been taken, delays the retries of all the items.
+	limiter.When("other")
+	if got := limiter.When("other"); got < 9*time.Second {
+		t.Errorf("expected the retry to be delayed by the token bucket, got %s", got)
+	}
+}
+
+func TestRateLimiterDefaults(t *testing.T) {
+	defaults := RateLimiterSettings{}.withDefaults()
+	want := RateLimiterSettings{BaseDelay: defaultRetryBaseDelay, MaxDelay: defaultRetryMaxDelay, QPS: defaultRetryQPS,
+		Burst: defaultRetryBurst}
+	if defaults != want {
+		t.Errorf("expected the controller-runtime defaults %+v, got %+v", want, defaults)
+	}
+	// The max delay is never lower than the base delay.
+	if got := (RateLimiterSettings{BaseDelay: time.Hour}).withDefaults().MaxDelay; got != time.Hour {
+		t.Errorf("expected the max delay to be raised to the base delay, got %s", got)
+	}
+	if _, ok := rateLimiter(0.5, RateLimiterSettings{QPS: 1}).(*jitteredRateLimiter); !ok {
+		t.Error("expected a jittered custom rate limiter with a positive factor")
+	}
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Collector is implemented by the collectors: once set up with the manager, they are run by it.
+type Collector interface {
+	manager.Runnable
+	// SetupWithManager sets up the collector with the manager.
+	SetupWithManager(mgr ctrl.Manager) error
+	// GetName returns the name of the collector.
+	GetName() string
+	// SetSelectors replaces the label and annotation selectors of the collector.
+	SetSelectors(labelSelector, annotationSelector labels.Selector)
+}
+
+var (
+	_ Collector = &PodCollector{}
+	_ Collector = &ObjectMetaCollector{}
+	_ Collector = &ServiceCollector{}
+)
+
+// Registration describes a collector to the registry.
+type Registration struct {
+	// Name of the collector, e.g. pod-collector.
+	Name string
+	// GVK of the resources watched by the collector. Its kind enables the collector in the configuration.
+	GVK schema.GroupVersionKind
+	// Dependencies are the kinds of the collectors the collector relies on, that must be enabled along with it.
+	Dependencies []string
+	// Indexers are the field indexers required by the collector, registered before it is set up.
+	Indexers []Indexer
+	// New returns the collector built from the setup. It can also set up with the manager the components the
+	// collector relies on, e.g. the dispatchers of the endpoints.
+	New func(setup *Setup) (Collector, error)
+}
+
+// Setup holds what the collectors are built from.
+type Setup struct {
+	// Manager the collector is set up with.
+	Manager ctrl.Manager
+	// Queue where the collector pushes its events.
+	Queue broker.Queue
+	// Cache of the resources sent by the collector.
+	Cache *events.Cache
+	// Name of the collector, set from the registration.
+	Name string
+	// Triggers through which the collectors trigger the reconciles of each other.
+	Triggers *Triggers
+	// Options of the collector, set from the configuration.
+	Options []CollectorOption
+}
+
+var (
+	registryMutex sync.RWMutex
+	// registry holds the registered collectors by kind.
+	registry = map[string]Registration{}
+)
+
+// Register adds the collector to the registry, by the kind of its group version kind. The built-in collectors are
+// registered from an init function, and so can the ones of downstream forks, e.g. for their CRDs. The group version
+// kind is registered in the resource package, and the kind is supported by the configuration. It panics if a
+// collector is already registered for the kind.
+func Register(reg Registration) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[reg.GVK.Kind]; ok {
+		panic(fmt.Sprintf("a collector is already registered for kind %q", reg.GVK.Kind))
+	}
+	registry[reg.GVK.Kind] = reg
+	resource.Register(reg.GVK)
+	config.RegisterKind(reg.GVK.Kind, reg.Dependencies...)
+}
+
+// Lookup returns the registered collector for the given kind.
+func Lookup(kind string) (Registration, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	reg, ok := registry[kind]
+	return reg, ok
+}
+
+// Registered returns the registered collectors, sorted by kind.
+func Registered() []Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	regs := make([]Registration, 0, len(registry))
+	for _, reg := range registry {
+		regs = append(regs, reg)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].GVK.Kind < regs[j].GVK.Kind })
+	return regs
+}
+
+// Build builds the collector from the setup, sets it up with the manager and adds it to the manager as a runnable.
+// The indexers of the registration are registered through the index registry passed in the options, if any.
+func (reg Registration) Build(setup *Setup) (Collector, error) {
+	s := *setup
+	s.Name = reg.Name
+	s.Options = append(append([]CollectorOption(nil), setup.Options...), WithIndexers(reg.Indexers...))
+	c, err := reg.New(&s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create collector %q: %w", reg.Name, err)
+	}
+	if err = c.SetupWithManager(s.Manager); err != nil {
+		return nil, fmt.Errorf("unable to set up collector %q: %w", reg.Name, err)
+	}
+	if err = s.Manager.Add(c); err != nil {
+		return nil, fmt.Errorf("unable to add collector %q to the manager as a runnable: %w", reg.Name, err)
+	}
+	return c, nil
+}
+
+// Triggers holds the channels through which the collectors trigger the reconciles of the enabled collectors, e.g.
+// the pod collector triggers the collectors of the owners of the pods.
+type Triggers struct {
+	enabled map[string]struct{}
+	chans   map[string]chan event.GenericEvent
+}
+
+// NewTriggers returns the triggers of the collectors for the given enabled kinds.
+func NewTriggers(enabled ...string) *Triggers {
+	t := &Triggers{enabled: make(map[string]struct{}, len(enabled)), chans: make(map[string]chan event.GenericEvent)}
+	for _, kind := range enabled {
+		t.enabled[kind] = struct{}{}
+	}
+	return t
+}
+
+// Source returns the source through which the collector for the given kind is triggered, to be passed to it with
+// WithExternalSource.
+func (t *Triggers) Source(kind string) source.Source {
+	return &source.Channel{Source: t.channel(kind)}
+}
+
+// Trigger returns the channel triggering the collector for the given kind, nil if the collector is not enabled:
+// nobody would consume the events sent on it.
+func (t *Triggers) Trigger(kind string) chan<- event.GenericEvent {
+	if _, ok := t.enabled[kind]; !ok {
+		return nil
+	}
+	return t.channel(kind)
+}
+
+// channel returns the channel of the collector for the given kind, created on first use.
+func (t *Triggers) channel(kind string) chan event.GenericEvent {
+	ch, ok := t.chans[kind]
+	if !ok {
+		ch = make(chan event.GenericEvent, 1)
+		t.chans[kind] = ch
+	}
+	return ch
+}
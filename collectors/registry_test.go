@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+func TestBuiltinRegistrations(t *testing.T) {
+	want := map[string]string{
+		resource.Pod:                   "pod-collector",
+		resource.Service:               "service-collector",
+		resource.Namespace:             "namespace-collector",
+		resource.Deployment:            "deployment-collector",
+		resource.ReplicaSet:            "replicaset-collector",
+		resource.Daemonset:             "daemonset-collector",
+		resource.ReplicationController: "replicationcontroller-collector",
+	}
+	for kind, name := range want {
+		reg, ok := Lookup(kind)
+		if !ok {
+			t.Errorf("expected a collector registered for kind %q", kind)
+			continue
+		}
+		if reg.Name != name {
+			t.Errorf("expected collector %q for kind %q, got %q", name, kind, reg.Name)
+		}
+	}
+
+	regs := Registered()
+	for i := 1; i < len(regs); i++ {
+		if regs[i-1].GVK.Kind >= regs[i].GVK.Kind {
+			t.Fatalf("expected the registrations sorted by kind, got %s before %s", regs[i-1].GVK, regs[i].GVK)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	reg := MetadataRegistration(gvk)
+	reg.Dependencies = []string{resource.Pod}
+	Register(reg)
+	t.Cleanup(func() {
+		registryMutex.Lock()
+		defer registryMutex.Unlock()
+		delete(registry, gvk.Kind)
+	})
+
+	got, ok := Lookup(gvk.Kind)
+	if !ok || got.Name != "widget-collector" || got.GVK != gvk {
+		t.Fatalf("expected the registered collector, got %+v", got)
+	}
+	// The group version kind of a registered collector is known to the resource package.
+	if registered, err := resource.GroupVersionKind(gvk.Kind); err != nil || registered != gvk {
+		t.Errorf("expected %s to be registered, got %s: %v", gvk, registered, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a collector twice for the same kind to panic")
+		}
+	}()
+	Register(reg)
+}
+
+func TestTriggers(t *testing.T) {
+	triggers := NewTriggers(resource.Pod, resource.Deployment)
+	if trigger := triggers.Trigger(resource.Namespace); trigger != nil {
+		t.Error("expected no trigger for a collector not enabled")
+	}
+
+	trigger := triggers.Trigger(resource.Deployment)
+	if trigger == nil {
+		t.Fatal("expected a trigger for an enabled collector")
+	}
+	src, ok := triggers.Source(resource.Deployment).(*source.Channel)
+	if !ok {
+		t.Fatalf("expected a channel source, got %T", triggers.Source(resource.Deployment))
+	}
+	trigger <- event.GenericEvent{}
+	select {
+	case <-src.Source:
+	default:
+		t.Error("expected the source of the collector to receive its triggers")
+	}
+}
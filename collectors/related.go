@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// relatedDispatcher triggers the reconcile of the resources of a collector related to the changed objects of another
+// kind, e.g. the ExternalName services of the namespace of a pod. It runs as a controller of its own: the events of
+// the related objects are not filtered by the selectors and the namespaces of the collector, as the watches of the
+// collector would be.
+type relatedDispatcher struct {
+	client.Client
+	// Name of the controller.
+	Name string
+	// Object is the kind of the watched objects. If nil, the partial object metadata of ObjectKind is watched.
+	Object client.Object
+	// ObjectKind is the name of the kind of the watched objects.
+	ObjectKind string
+	// Predicate filters the events of the watched objects.
+	Predicate predicate.Predicate
+	// Handler maps the events of the watched objects to the requests reconciled by the dispatcher, e.g. to look at
+	// both the old and the new object of the updates. If nil, the watched objects are reconciled.
+	Handler handler.EventHandler
+	// Kind of the resources of the collector.
+	Kind string
+	// Related returns the resources of the collector related to the watched object, which could have been deleted.
+	Related func(ctx context.Context, cl client.Reader, req ctrl.Request) ([]types.NamespacedName, error)
+	// CollectorSource where the reconciles of the related resources are triggered.
+	CollectorSource chan<- event.GenericEvent
+	// metrics of the collector, where the events of the watched objects are counted.
+	metrics *collectorMetrics
+}
+
+// Reconcile triggers the reconcile of the resources related to the object.
+func (r *relatedDispatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	related, err := r.Related(ctx, r.Client, req)
+	if err != nil {
+		logger.Error(err, "unable to get the related resources", "kind", r.Kind)
+		return ctrl.Result{}, err
+	}
+	for i := range related {
+		obj, err := PartialObjectMetadataFor(r.Kind, &related[i])
+		if err != nil {
+			logger.Error(err, "unable to trigger the related resource", "kind", r.Kind)
+			return ctrl.Result{}, err
+		}
+		if !trigger(ctx, r.CollectorSource, obj) {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *relatedDispatcher) SetupWithManager(mgr ctrl.Manager) error {
+	// The kind of the related resources is checked once, rather than failing each reconcile.
+	if _, err := PartialObjectMetadataFor(r.Kind, nil); err != nil {
+		return err
+	}
+	if r.Object == nil {
+		obj, err := PartialObjectMetadataFor(r.ObjectKind, nil)
+		if err != nil {
+			return err
+		}
+		r.Object = obj
+	}
+	lc, err := newLogConstructor(mgr.GetLogger(), r.Name, r.ObjectKind)
+	if err != nil {
+		return err
+	}
+
+	predicates := []predicate.Predicate{r.metrics.predicates(r.Name, apiServerSource, nil)}
+	if r.Predicate != nil {
+		predicates = append(predicates, r.Predicate)
+	}
+	h := r.Handler
+	if h == nil {
+		h = &handler.EnqueueRequestForObject{}
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named(r.Name).
+		Watches(r.Object, h, builder.WithPredicates(predicates...)).
+		WithOptions(controller.Options{LogConstructor: lc}).
+		Complete(r)
+}
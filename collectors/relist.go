@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"time"
+
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// staleCacheRequeue is the delay after which the reconcile of a resource missing from the cache of the informers,
+// but still existing on the api-server, is retried.
+const staleCacheRequeue = time.Second
+
+// existsOnAPIServer returns true if the resource with the given group version kind, name and UID, missing from the cache of the
+// informers, still exists on the api-server. It happens while the informers relist after their watch expired with a
+// 410 Gone: the cache is stale until the relist completes, and the resource must not be deleted from the subscribers.
+// A resource recreated with the same name is a different one. Without a reader the cache is trusted.
+func existsOnAPIServer(ctx context.Context, reader client.Reader, gvk schema.GroupVersionKind, name types.NamespacedName,
+	uid types.UID) (bool, error) {
+	if reader == nil {
+		return false, nil
+	}
+	obj, err := PartialObjectMetadataForGVK(gvk, &name)
+	if err != nil {
+		return false, err
+	}
+	if err := reader.Get(ctx, name, obj); err != nil {
+		if k8sApiErrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return obj.UID == uid, nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRelist(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	// The cache of the informers and the api-server start in sync.
+	cached := fake.NewClientBuilder().WithObjects(ns, pod.DeepCopy()).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+	apiServer := fake.NewClientBuilder().WithObjects(ns, pod.DeepCopy()).Build()
+
+	queue := &recordingQueue{}
+	cache := events.NewCache()
+	collector := NewPodCollector(cached, queue, cache, "relist-pod-collector", WithAPIReader(apiServer))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}
+
+	reconcile := func(step string, want ...string) ctrl.Result {
+		t.Helper()
+		res, err := collector.Reconcile(ctx, req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := queue.pop(); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+		return res
+	}
+
+	reconcile("created", events.Create)
+
+	// The informers relist after their watch expired: the pod is missing from their cache for a while.
+	if err := cached.Delete(ctx, pod.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	stale := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues("relist-pod-collector", outcomeStaleCache))
+	if res := reconcile("relisting"); res.RequeueAfter != staleCacheRequeue {
+		t.Errorf("expected the reconcile to be retried after %s, got %+v", staleCacheRequeue, res)
+	}
+	if !cache.Has(cache.Key(resource.Pod, req.NamespacedName)) {
+		t.Error("expected the pod to stay cached")
+	}
+	if got := testutil.ToFloat64(defaultMetrics.reconciles.WithLabelValues("relist-pod-collector", outcomeStaleCache)); got != stale+1 {
+		t.Errorf("expected the reconcile to be counted as stale-cache, got %v", got-stale)
+	}
+
+	// The pod has been recreated with the same name: the one sent to the subscribers has been deleted.
+	if err := apiServer.Delete(ctx, pod.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	recreated := pod.DeepCopy()
+	recreated.UID = "recreated-uid"
+	recreated.ResourceVersion = ""
+	if err := apiServer.Create(ctx, recreated); err != nil {
+		t.Fatal(err)
+	}
+	reconcile("recreated", events.Delete)
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// replays tracks the reconciles triggered by the dispatch of the existing resources to the new subscribers. A replay
+// completes once all the reconciles dispatched for it have completed, i.e. once all the events of the replay have
+// been pushed to the queue.
+type replays struct {
+	mutex   sync.Mutex
+	pending map[*replay]struct{}
+}
+
+// replay holds the requests dispatched for a subscriber and not reconciled yet.
+type replay struct {
+	// requests holds for each dispatched request the time it has been dispatched.
+	requests map[types.NamespacedName]time.Time
+	// sealed is true once all the requests of the replay have been dispatched.
+	sealed bool
+	done   func()
+}
+
+// newReplays returns an empty replays.
+func newReplays() *replays {
+	return &replays{pending: make(map[*replay]struct{})}
+}
+
+// start starts tracking a replay. The done function is called once all the requests of the replay, dispatched
+// before the replay is sealed, have been reconciled.
+func (r *replays) start(done func()) *replay {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rp := &replay{requests: make(map[types.NamespacedName]time.Time), done: done}
+	r.pending[rp] = struct{}{}
+	return rp
+}
+
+// dispatched records a request of the replay. It must be called before the request is enqueued, so that its
+// reconcile cannot complete before being tracked.
+func (r *replays) dispatched(rp *replay, name types.NamespacedName) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rp.requests[name] = time.Now()
+}
+
+// undispatched forgets a request of the replay that has not been enqueued after all, e.g. because the collector is
+// stopping, so that the replay does not wait for its reconcile.
+func (r *replays) undispatched(rp *replay, name types.NamespacedName) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(rp.requests, name)
+}
+
+// seal marks the end of the dispatch of the requests of the replay, completing it if they have been reconciled
+// already.
+func (r *replays) seal(rp *replay) {
+	r.mutex.Lock()
+	rp.sealed = true
+	completed := r.complete(rp)
+	r.mutex.Unlock()
+	if completed {
+		rp.done()
+	}
+}
+
+// reconciling returns the function to be called when the reconcile of the given request, starting now, completes.
+// A reconcile started before a request has been dispatched may miss the new subscriber, so it does not count for
+// the replay: the workqueue reconciles the request again.
+func (r *replays) reconciling(name types.NamespacedName) func() {
+	started := time.Now()
+	return func() {
+		var completed []*replay
+		r.mutex.Lock()
+		for rp := range r.pending {
+			if dispatched, ok := rp.requests[name]; ok && !started.Before(dispatched) {
+				delete(rp.requests, name)
+				if r.complete(rp) {
+					completed = append(completed, rp)
+				}
+			}
+		}
+		r.mutex.Unlock()
+		for _, rp := range completed {
+			rp.done()
+		}
+	}
+}
+
+// complete stops tracking the replay if it is sealed and has no pending requests, the caller must hold the lock.
+func (r *replays) complete(rp *replay) bool {
+	if !rp.sealed || len(rp.requests) != 0 {
+		return false
+	}
+	delete(r.pending, rp)
+	return true
+}
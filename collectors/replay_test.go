@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestReplays(t *testing.T) {
+	r := newReplays()
+	a := types.NamespacedName{Namespace: "default", Name: "a"}
+	b := types.NamespacedName{Namespace: "default", Name: "b"}
+
+	completed := 0
+	rp := r.start(func() { completed++ })
+	r.dispatched(rp, a)
+	// A reconcile started before the dispatch of the request does not count.
+	early := r.reconciling(b)
+	r.dispatched(rp, b)
+	r.reconciling(a)()
+	early()
+	r.seal(rp)
+	if completed != 0 {
+		t.Fatal("expected the replay to wait for the reconcile of all its requests")
+	}
+
+	r.reconciling(b)()
+	if completed != 1 {
+		t.Errorf("expected the replay to complete once, got %d", completed)
+	}
+	if len(r.pending) != 0 {
+		t.Errorf("expected no pending replays, got %d", len(r.pending))
+	}
+
+	// A replay without requests completes when sealed.
+	r.seal(r.start(func() { completed++ }))
+	if completed != 2 {
+		t.Errorf("expected the empty replay to complete, got %d completions", completed)
+	}
+}
+
+func TestDispatchReplayDone(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().
+		WithObjects(pod).
+		WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).
+		Build()
+
+	replays := newReplays()
+	ctx, cancel := context.WithCancel(context.Background())
+	subChan := make(subscriber.SubsChan)
+	dispatcherChan := make(chan event.GenericEvent, 1)
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, subChan, dispatcherChan, cl, subscriber.NewSubscribers(),
+			events.NewCache(), replays, defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0, nil, nil)
+	}()
+
+	dispatched := make(chan struct{})
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Subscribed,
+		Done: func() { close(dispatched) }}
+	var key types.NamespacedName
+	select {
+	case evt := <-dispatcherChan:
+		key = client.ObjectKeyFromObject(evt.Object)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pod to be dispatched")
+	}
+
+	// The subscriber is notified only once the dispatched pod has been reconciled.
+	select {
+	case <-dispatched:
+		t.Fatal("expected the dispatch to wait for the reconcile of the pod")
+	case <-time.After(50 * time.Millisecond):
+	}
+	replays.reconciling(key)()
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the dispatch to be done once the pod has been reconciled")
+	}
+
+	cancel()
+	subChan <- subscriber.Message{NodeName: "node", UID: "subscriber", Reason: subscriber.Unsubscribed}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// rescheduleEnv runs the pod collector and the collectors of the owners of the pods, triggered by the pod collector
+// as they are through the external pod watch.
+type rescheduleEnv struct {
+	t        *testing.T
+	client   client.Client
+	queue    *recordingQueue
+	pods     *PodCollector
+	owners   map[string]*ObjectMetaCollector
+	triggers chan event.GenericEvent
+}
+
+func newRescheduleEnv(t *testing.T, objs ...client.Object) *rescheduleEnv {
+	cl := fake.NewClientBuilder().WithObjects(objs...).
+		WithIndex(PodByPrefixNameIndexer.Object, PodByPrefixNameIndexer.Field, PodByPrefixNameIndexer.ExtractValue).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+	env := &rescheduleEnv{
+		t:        t,
+		client:   cl,
+		queue:    &recordingQueue{},
+		owners:   make(map[string]*ObjectMetaCollector),
+		triggers: make(chan event.GenericEvent, 10),
+	}
+	cache := events.NewCache()
+	env.pods = NewPodCollector(cl, env.queue, cache, "reschedule-pod-collector",
+		WithOwnerSources(map[string]chan<- event.GenericEvent{resource.ReplicaSet: env.triggers, resource.Deployment: env.triggers}))
+	env.owners[resource.ReplicaSet] = NewObjectMetaCollector(cl, env.queue, cache,
+		NewPartialObjectMetadata(resource.ReplicaSet, nil), "reschedule-replicaset-collector", podsByPrefix("-"))
+	env.owners[resource.Deployment] = NewObjectMetaCollector(cl, env.queue, cache,
+		NewPartialObjectMetadata(resource.Deployment, nil), "reschedule-deployment-collector", podsByPrefix(""))
+	for node, sub := range map[string]string{"node-a": "sub-a", "node-b": "sub-b"} {
+		env.pods.subscribers.AddSubscriberPerNode(node, sub)
+		for _, owner := range env.owners {
+			owner.subscribers.AddSubscriberPerNode(node, sub)
+		}
+	}
+	return env
+}
+
+// reconcilePod reconciles the pod, then the owners triggered by the pod collector. It returns the events sent, as
+// kind/type/subscriber sorted strings.
+func (env *rescheduleEnv) reconcilePod(pod *corev1.Pod) []string {
+	env.t.Helper()
+	ctx := context.Background()
+	if _, err := env.pods.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pod)}); err != nil {
+		env.t.Fatalf("unable to reconcile pod %s: %v", pod.Name, err)
+	}
+	// The owners are triggered asynchronously.
+	for {
+		select {
+		case evt := <-env.triggers:
+			kind := evt.Object.GetObjectKind().GroupVersionKind().Kind
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(evt.Object)}
+			if _, err := env.owners[kind].Reconcile(ctx, req); err != nil {
+				env.t.Fatalf("unable to reconcile %s %s: %v", kind, req, err)
+			}
+		case <-time.After(200 * time.Millisecond):
+			var sent []string
+			for _, evt := range env.queue.evts {
+				for sub := range evt.Subscribers() {
+					sent = append(sent, strings.Join([]string{evt.ResourceKind(), evt.Type(), sub}, "/"))
+				}
+			}
+			env.queue.evts = nil
+			sort.Strings(sent)
+			return sent
+		}
+	}
+}
+
+func (env *rescheduleEnv) expect(step string, got []string, want ...string) {
+	env.t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		env.t.Errorf("%s: expected events %v, got %v", step, want, got)
+	}
+}
+
+// deploymentPod returns a pod of the replicaset of the web deployment, running on the given node.
+func deploymentPod(name, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:         name,
+			Namespace:    "apps",
+			UID:          types.UID("uid-" + name),
+			GenerateName: "web-5d8f7c9b6-",
+			Labels:       map[string]string{"pod-template-hash": "5d8f7c9b6"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: resource.ReplicaSet,
+				Name: "web-5d8f7c9b6", UID: "rs-uid", Controller: ptr.To(true)}},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestPodReschedule(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", UID: "ns-uid"}}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps", UID: "deploy-uid"}}
+	replicaset := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-5d8f7c9b6", Namespace: "apps",
+		UID: "rs-uid", OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: resource.Deployment,
+			Name: "web", UID: "deploy-uid", Controller: ptr.To(true)}}}}
+
+	t.Run("rescheduled", func(t *testing.T) {
+		podA := deploymentPod("web-5d8f7c9b6-aaaaa", "node-a")
+		env := newRescheduleEnv(t, ns, deployment, replicaset, podA)
+		env.expect("pod created on node-a", env.reconcilePod(podA),
+			"Deployment/Create/sub-a", "Pod/Create/sub-a", "ReplicaSet/Create/sub-a")
+
+		// The pod is evicted from node-a and replaced by a new pod on node-b.
+		if err := env.client.Delete(context.Background(), podA); err != nil {
+			t.Fatal(err)
+		}
+		env.expect("pod deleted from node-a", env.reconcilePod(podA),
+			"Deployment/Delete/sub-a", "Pod/Delete/sub-a", "ReplicaSet/Delete/sub-a")
+		podB := deploymentPod("web-5d8f7c9b6-bbbbb", "node-b")
+		if err := env.client.Create(context.Background(), podB); err != nil {
+			t.Fatal(err)
+		}
+		env.expect("pod created on node-b", env.reconcilePod(podB),
+			"Deployment/Create/sub-b", "Pod/Create/sub-b", "ReplicaSet/Create/sub-b")
+	})
+
+	t.Run("moved", func(t *testing.T) {
+		pod := deploymentPod("web-5d8f7c9b6-ccccc", "node-a")
+		env := newRescheduleEnv(t, ns, deployment, replicaset, pod)
+		env.expect("pod created on node-a", env.reconcilePod(pod),
+			"Deployment/Create/sub-a", "Pod/Create/sub-a", "ReplicaSet/Create/sub-a")
+
+		// The same pod moves to node-b, e.g. after a live migration.
+		pod.Spec.NodeName = "node-b"
+		if err := env.client.Update(context.Background(), pod); err != nil {
+			t.Fatal(err)
+		}
+		env.expect("pod moved to node-b", env.reconcilePod(pod),
+			"Deployment/Create/sub-b", "Deployment/Delete/sub-a", "Pod/Create/sub-b", "Pod/Delete/sub-a",
+			"ReplicaSet/Create/sub-b", "ReplicaSet/Delete/sub-a")
+	})
+}
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// namespaceScope returns a predicate that filters out the objects that do not belong to one of the given
+// namespaces. Namespace resources are cluster scoped, so when namespaceKind is true the name of the object is
+// matched instead of its namespace.
+func namespaceScope(namespaces []string, namespaceKind bool) predicate.Predicate {
+	scope := make(map[string]struct{}, len(namespaces))
+	for _, ns := range namespaces {
+		scope[ns] = struct{}{}
+	}
+
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		ns := obj.GetNamespace()
+		if namespaceKind {
+			ns = obj.GetName()
+		}
+		_, ok := scope[ns]
+		return ok
+	})
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNamespaceScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		obj           client.Object
+		namespaceKind bool
+		want          bool
+	}{
+		{
+			name: "pod in scope",
+			obj:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "falco"}},
+			want: true,
+		},
+		{
+			name: "pod out of scope",
+			obj:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}},
+			want: false,
+		},
+		{
+			name:          "namespace in scope",
+			obj:           &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "falco"}},
+			namespaceKind: true,
+			want:          true,
+		},
+		{
+			name:          "namespace out of scope",
+			obj:           &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			namespaceKind: true,
+			want:          false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := namespaceScope([]string{"falco", "monitoring"}, tt.namespaceKind)
+			got := map[string]bool{
+				"create":  p.Create(event.CreateEvent{Object: tt.obj}),
+				"update":  p.Update(event.UpdateEvent{ObjectOld: tt.obj, ObjectNew: tt.obj}),
+				"delete":  p.Delete(event.DeleteEvent{Object: tt.obj}),
+				"generic": p.Generic(event.GenericEvent{Object: tt.obj}),
+			}
+			for evtType, reconciled := range got {
+				if reconciled != tt.want {
+					t.Errorf("%s event: expected %t, got %t", evtType, tt.want, reconciled)
+				}
+			}
+		})
+	}
+}
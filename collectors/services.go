@@ -17,26 +17,40 @@ package collectors
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
 	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
 	"github.com/go-logr/logr"
 	"github.com/mitchellh/hashstructure/v2"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -50,63 +64,250 @@ type ServiceCollector struct {
 	name            string
 	subscriberChan  subscriber.SubsChan
 	logger          logr.Logger
+	baseLogger      logr.Logger
+	predicates      []predicate.Predicate
 	// dispatcherSource is used to get events enqueued by the dispatcher based
 	// on subscribers' arrival.
 	dispatcherSource source.Source
 	// dispatcherChan is the channel where the dispatcher pushes the new requests to be enqueued and
 	// processed by the reconciler.
 	dispatcherChan chan event.GenericEvent
-	subscribers    *subscriber.Subscribers
+	// relatedSource is used to get the requests of the services related to the changes of other resources, e.g. the
+	// ExternalName services of the namespace of a pod.
+	relatedSource source.Source
+	// relatedChan is the channel where the related dispatchers push the requests of the services.
+	relatedChan chan event.GenericEvent
+	subscribers *subscriber.Subscribers
+	// informers is used to wait for the initial sync of the informers before accepting subscribers.
+	informers cache.Informers
+	// barrier where the collector signals the completion of its initial sync.
+	barrier *health.Barrier
+	// healthRegistry where the collector reports the outcome of its reconciles.
+	healthRegistry *health.Registry
+	// syncStatus where the collector reports the sync of its caches and the progress of its initial sweep.
+	syncStatus *health.SyncStatus
+	// metrics where the collector records its events and reconciles.
+	metrics *collectorMetrics
+	// filter excludes the objects from the collection.
+	filter *objectFilter
+	// resyncRequests asks the dispatcher to resync the resources, e.g. when the selectors change.
+	resyncRequests chan struct{}
+	// apiReader confirms the deletion of the resources missing from the cache, nil to trust the cache.
+	apiReader client.Reader
+	// metaTransforms applied in order to the metadata of the resources before their serialization.
+	metaTransforms []MetaTransform
+	// pause gates the reconciles while the collector is paused at runtime.
+	*pause
+	// metaKeys restricts the labels and annotations forwarded in the metadata of the resources.
+	metaKeys metaKeys
+	// metaLimit caps the size of the serialized metadata of the resources.
+	metaLimit metaLimit
+	// metaEncoder serializes the metadata of the resources, encoding/json if nil.
+	metaEncoder MetaEncoder
+	// clusterName stamped on the events, empty if not configured.
+	clusterName string
+	// replays tracks the reconciles dispatched to the new subscribers, to notify them when their events are queued.
+	replays *replays
+	// indexRegistry through which the collector registers the field indexers it needs.
+	indexRegistry *IndexRegistry
+	// resyncPeriod is the period of the full resync. A zero value disables it.
+	resyncPeriod time.Duration
+	// ttl of the metadata stamped on the events and refreshed by the dispatcher. A zero value disables it.
+	ttl time.Duration
+	// jitter is the factor of the jitter added to the resync period and to the backoff of the retries.
+	jitter float64
+	// rateLimiter are the settings of the rate limiter of the retries of the reconciles.
+	rateLimiter RateLimiterSettings
+	// namespaces where the collector reconciles the objects. If empty, all the namespaces are watched.
+	namespaces []string
+	// nodesMemo memoizes the nodes of the pods serving the services. If nil, the pods are always listed.
+	nodesMemo *NodesMemo
+	// namespaceCascade is entered while reconciling the services, whose namespaces could be swept concurrently.
+	namespaceCascade *NamespaceCascade
+	// coalesceWindow within which the changes to the same service are coalesced. A zero value disables it.
+	coalesceWindow time.Duration
+	// debounceWindow within which the requests for the same service triggered by the external sources are collapsed.
+	debounceWindow time.Duration
+	// includeTerminated is true if the pods in a terminal phase relate their node to the resources.
+	includeTerminated bool
+	// warmup is the grace period after the start of the collector, during which the Delete events due to empty
+	// sets of nodes are deferred.
+	warmup *warmup
+	// minAge defers the resources younger than a threshold until they persist past it.
+	minAge *minAge
+	// nodelessRequeue reconciles again the resources relating to no node.
+	nodelessRequeue nodelessRequeue
+	// endpointsNodes is true if the nodes of the services are resolved from the endpoints of their EndpointSlices.
+	endpointsNodes bool
+	// zoneNodes is true if the nodes of the services are expanded to all the nodes in the zones of their backends.
+	zoneNodes bool
+	// externalNameScope are the nodes where the ExternalName services are sent, see WithExternalNameNodes.
+	externalNameScope string
+	// broadcast holds the namespaces whose services are sent to all the nodes.
+	broadcast broadcastNamespaces
+	// invalidOptions are the problems of the options the collector has been created with, returned by its setup.
+	invalidOptions error
+	// dispatcher delivers the metadata of the existing resources to the subscribers.
+	dispatcher Dispatcher
 }
 
 // NewServiceCollector returns a new service collector.
 func NewServiceCollector(cl client.Client, queue broker.Queue, cache *events.Cache, name string, opt ...CollectorOption) *ServiceCollector {
-	opts := collectorOptions{}
+	opts := collectorOptions{subscribers: subscriber.NewSubscribers()}
 	for _, o := range opt {
 		o(&opts)
 	}
 
+	// The collector is not ready until its initial sync has completed.
+	if opts.barrier != nil {
+		opts.barrier.Register(name)
+	}
+	// The collector is healthy until its reconciles keep failing, or get stuck, for too long.
+	opts.healthRegistry.Register(name)
+	// The collector is not ready until its initial sweep has completed.
+	opts.syncStatus.RegisterCollector(name)
+
 	dc := make(chan event.GenericEvent, 1)
+	rc := make(chan event.GenericEvent, 1)
+	resyncRequests := make(chan struct{}, 1)
+	metrics, err := registerCollectorMetrics(opts.metricsRegisterer, opts.metricsPrefix)
+	invalidOptions := errors.Join(opts.validate(queue), err)
 
 	return &ServiceCollector{
-		Client:           cl,
-		queue:            queue,
-		cache:            cache,
-		endpointsSource:  opts.externalSource,
-		name:             name,
-		subscriberChan:   opts.subscriberChan,
-		dispatcherSource: &source.Channel{Source: dc},
-		dispatcherChan:   dc,
-		subscribers:      subscriber.NewSubscribers(),
+		Client:            instrumentClient(cl, metrics.clientCalls, name),
+		queue:             queue,
+		cache:             cache,
+		endpointsSource:   opts.externalSource,
+		name:              name,
+		subscriberChan:    opts.subscriberChan,
+		dispatcherSource:  &source.Channel{Source: dc},
+		dispatcherChan:    dc,
+		relatedSource:     &source.Channel{Source: rc},
+		relatedChan:       rc,
+		subscribers:       opts.subscribers,
+		barrier:           opts.barrier,
+		healthRegistry:    opts.healthRegistry,
+		syncStatus:        opts.syncStatus,
+		metrics:           metrics,
+		filter:            newObjectFilter(opts.labelSelector, opts.annotationSelector, opts.excludedNames),
+		resyncRequests:    resyncRequests,
+		apiReader:         instrumentReader(opts.apiReader, metrics.clientCalls, name, schemeOf(cl)),
+		logger:            namedLogger(opts.logger, name),
+		baseLogger:        opts.logger,
+		predicates:        opts.predicates,
+		indexRegistry:     opts.indexRegistry,
+		replays:           newReplays(),
+		metaTransforms:    metaTransforms(&opts),
+		metaKeys:          opts.metaKeys,
+		pause:             newPause(metrics.paused.WithLabelValues(name), opts.pausedRequeue, resyncRequests),
+		metaLimit:         opts.metaLimit,
+		metaEncoder:       opts.metaEncoder,
+		clusterName:       opts.clusterName,
+		resyncPeriod:      opts.resyncPeriod,
+		ttl:               opts.ttl,
+		jitter:            opts.jitter,
+		rateLimiter:       opts.rateLimiter,
+		namespaces:        opts.namespaces,
+		nodesMemo:         opts.nodesMemo,
+		namespaceCascade:  opts.namespaceCascade,
+		coalesceWindow:    opts.coalesceWindow,
+		debounceWindow:    opts.debounceWindow,
+		includeTerminated: opts.includeTerminated,
+		warmup:            newWarmup(opts.warmup),
+		minAge:            newMinAge(opts.minAge),
+		nodelessRequeue:   nodelessRequeue(opts.nodelessRequeue),
+		endpointsNodes:    opts.endpointsNodes,
+		zoneNodes:         opts.zoneNodes,
+		externalNameScope: opts.externalNameNodes,
+		broadcast:         newBroadcastNamespaces(opts.broadcast),
+		invalidOptions:    invalidOptions,
+		dispatcher:        dispatcherOrDefault(opts.dispatcher),
 	}
 }
 
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 
 // Reconcile generates events to be sent to nodes when changes are detected for the watched resources.
-func (r *ServiceCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	var err error
+func (r *ServiceCollector) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "Reconcile", resource.Service, req.Namespace)
+	reconciled := r.healthRegistry.Start(r.name)
+	replayed := r.replays.reconciling(req.NamespacedName)
+	phases := r.metrics.startReconcilePhases(r.name)
+	defer func() {
+		tracing.End(span, err)
+		reconciled(err)
+		replayed()
+		phases.done(err)
+		r.syncStatus.Reconciled(r.name, req.NamespacedName.String())
+	}()
+
 	var svc = &corev1.Service{}
 	var sRes *events.Resource
 	var cEntry *events.CacheEntry
+	// snapshot is the state of the cache entry before the changes made for the events, restored if they are not pushed.
+	var snapshot events.CacheSnapshot
 	var ok, serviceDeleted bool
+	// requeue is the delay after which the resource is reconciled again, when Delete events have been deferred.
+	var requeue time.Duration
 
 	logger := log.FromContext(ctx)
 
+	// Without a queue, or once it is closed during the shutdown, the events would be lost: the cache is left untouched
+	// so that it keeps matching what the subscribers received.
+	if broker.IsClosed(r.queue) {
+		logger.V(2).Info("queue closed, skipping the reconcile")
+		return ctrl.Result{}, nil
+	}
+	// While the collector is paused no events are pushed. The skipped reconcile does not complete the replays waiting for
+	// the request, or their subscribers would drop the resources never sent: they complete with the resync on resume.
+	if result, paused := r.pause.skip(logger); paused {
+		replayed = func() {}
+		return result, nil
+	}
+	key := r.cache.Key(resource.Service, req.NamespacedName)
+
 	err = r.Get(ctx, req.NamespacedName, svc)
-	if err != nil && !k8sApiErrors.IsNotFound(err) {
+	phases.notFound = k8sApiErrors.IsNotFound(err)
+	if err != nil && !phases.notFound {
 		logger.Error(err, "unable to get resource")
 		return ctrl.Result{}, err
 	}
+	// The resources of a namespace being swept wait for the sweep, and are skipped once it is over.
+	leave, entered := r.namespaceCascade.enter(req.Namespace)
+	if !entered {
+		return skipDeletedNamespace(logger, phases.notFound), nil
+	}
+	defer leave()
 
-	if k8sApiErrors.IsNotFound(err) {
+	// An ignored resource, or one not matching the selectors, is handled as a deleted one: the subscribers that
+	// received it get a Delete event.
+	ignored := err == nil && r.filter.excluded(svc)
+	if ignored {
+		logger.V(3).Info("resource excluded by annotation or selectors", "annotation", consts.IgnoreAnnotation)
+	}
+
+	if k8sApiErrors.IsNotFound(err) || ignored {
 		// When the k8s resource get deleted we need to remove it from the local cache.
-		if _, ok = r.cache.Get(req.String()); ok {
-			logger.Info("marking resource for deletion")
-			serviceDeleted = true
-		} else {
+		if cEntry, ok = r.cache.Get(key); !ok {
 			return ctrl.Result{}, nil
 		}
+		// The informers could be relisting, the resource is deleted once the api-server confirms it.
+		var exists bool
+		if !ignored {
+			gvk := corev1.SchemeGroupVersion.WithKind(resource.Service)
+			if exists, err = existsOnAPIServer(ctx, r.apiReader, gvk, req.NamespacedName, cEntry.UID); err != nil {
+				logger.Error(err, "unable to confirm the deletion of the resource")
+				return ctrl.Result{}, err
+			}
+		}
+		if exists {
+			logger.V(2).Info("resource missing from the cache but existing on the api-server, waiting for the cache to sync")
+			phases.staleCache = true
+			return ctrl.Result{RequeueAfter: staleCacheRequeue}, nil
+		}
+		logger.Info("marking resource for deletion")
+		serviceDeleted = true
 	}
 
 	logger.V(5).Info("resource found")
@@ -117,23 +318,45 @@ func (r *ServiceCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		// Get all subscribers for the resource based on its node name.
 		// The subscribers are used to compute to which subscribers we need to send an event
 		// and of which type, Create, Delete or Update.
-		subs, err := r.getSubscribers(ctx, logger, svc)
+		phases.next(phaseRelation)
+		subs, nodes, err := r.getSubscribers(ctx, logger, svc)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		tracing.SetAttributes(span, tracing.SubscribersKey.Int(len(subs)))
+		// During the warmup the subscribers that received the resource are retained, and the resource reconciled
+		// again once it is over.
+		if cEntry, ok = r.cache.Get(key); ok {
+			if subs, requeue = r.warmup.retain(subs, cEntry.Subs); requeue > 0 {
+				logger.V(2).Info("deferring the Delete events to the end of the warmup", "requeueAfter", requeue)
+			}
+		}
+		// The resources relating to no node are checked again, in case the trigger of their pods is missed.
+		requeue = r.nodelessRequeue.requeueAfter(nodes, requeue)
 
-		// If no subscribers/nodes for the current resource just return.
+		// If no subscribers/nodes for the current resource and not sent to any subscriber just return. Otherwise
+		// the subscribers that received the resource get a Delete event.
 		if len(subs) == 0 {
-			// Make sure to remove the cache entry for the resource.
-			// This could happen when a subscriber closes its connection.
-			r.cache.Delete(req.String())
-			return ctrl.Result{}, nil
+			if cEntry, ok = r.cache.Get(key); !ok || len(cEntry.Subs) == 0 {
+				// Make sure to remove the cache entry for the resource.
+				// This could happen when a subscriber closes its connection.
+				r.cache.Delete(key)
+				return ctrl.Result{RequeueAfter: requeue}, nil
+			}
+		}
+		// A resource never sent is deferred until it is old enough.
+		if !r.cache.Has(key) {
+			if wait := r.minAge.wait(svc.CreationTimestamp); wait > 0 {
+				logger.V(3).Info("deferring the resource until it reaches the minimum age", "requeueAfter", wait)
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
 		}
 		// Create the resource.
+		phases.next(phaseSerialize)
 		sRes = events.NewResource(resource.Service, string(svc.UID))
 		// Populate resource fields.
-		if err := r.ObjFieldsHandler(logger, sRes, svc); err != nil {
-			return ctrl.Result{}, err
+		if err := r.ObjFieldsHandler(ctx, logger, sRes, svc); err != nil {
+			return ctrl.Result{}, serializationError(resource.Service, req.NamespacedName, err)
 		}
 		// Hash the resource.
 		hash, err := hashstructure.Hash(sRes, hashstructure.FormatV2, nil)
@@ -143,35 +366,47 @@ func (r *ServiceCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		}
 
 		// Check if we have cached the resource previously.
-		if cEntry, ok = r.cache.Get(req.String()); ok {
+		snapshot = r.cache.Snapshot(key)
+		if cEntry, ok = r.cache.Get(key); ok {
 			if cEntry.Hash != hash {
 				sRes.SetUpdate(true)
-				cEntry.Hash = hash
+				r.cache.SetHash(cEntry, hash)
+				// The changed label and annotation keys are attached to the Update events.
+				sRes.SetPreviousMeta(cEntry.Meta, cEntry.Labels, cEntry.Annotations)
+				r.cache.SetMeta(cEntry, sRes.Meta, sRes.Labels, sRes.Annotations)
+				// Each change of the resource is numbered in its events.
+				r.cache.NextSequence(cEntry)
 			}
 			sRes.SetSubscribers(cEntry.Subs)
 		} else {
 			cEntry = &events.CacheEntry{
-				Hash: hash,
-				UID:  svc.UID,
-				Subs: nil,
+				Hash:     hash,
+				UID:      svc.UID,
+				Subs:     nil,
+				Sequence: 1,
 			}
-			r.cache.Add(req.String(), cEntry)
+			r.cache.Add(key, cEntry)
+			r.cache.SetMeta(cEntry, sRes.Meta, sRes.Labels, sRes.Annotations)
 		}
+		sRes.SetSequence(cEntry.Sequence)
 
 		// Add the new subscribers and internally compute the new subscribers to which we need to sent events.
 		// Update the cache entry with the new set of getSubscribers.
-		cEntry.Subs = sRes.GenerateSubscribers(subs)
+		r.cache.SetSubscribers(cEntry, sRes.GenerateSubscribers(subs))
+		indexNodes(r.cache, sRes, key, nodes)
 	} else {
 		// If the resource has been deleted from the api-server, then we send a "Delete" event to all nodes.
 		// Only if we have sent previously the resource.
-		if cEntry, ok = r.cache.Get(req.String()); ok {
+		snapshot = r.cache.Snapshot(key)
+		if cEntry, ok = r.cache.Get(key); ok {
 			// Check if we have cached the resource.
 			sRes = events.NewResource(resource.Service, string(cEntry.UID))
 			sRes.SetSubscribers(cEntry.Subs)
+			sRes.SetSequence(r.cache.NextSequence(cEntry))
 			sRes.GenerateSubscribers(nil)
 			// We are ready to remove the entry from the cache. No need to track anymore
 			// the deleted resource.
-			r.cache.Delete(req.String())
+			r.cache.Delete(key)
 		} else {
 			// It means that we received a delete event for a resource that we never sent to any subscriber.
 			// In this case we just return.
@@ -180,32 +415,63 @@ func (r *ServiceCollector) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 
 	// At this point our resource has all the necessary bits to know for each node which type of events need to be sent.
+	phases.next(phaseDispatch)
+	sRes.SetSpanContext(span.SpanContext())
+	sRes.SetCluster(r.clusterName)
+	sRes.SetTTL(r.ttl)
+	sRes.SetOrigin(r.name, req.NamespacedName)
 	evts := sRes.ToEvents()
 
 	// Enqueue events.
 	for _, evt := range evts {
 		if evt != nil {
 			// Add event to the queue.
-			r.queue.Push(evt)
+			if err = r.queue.Push(evt); err != nil {
+				return pushFailed(logger, r.cache, snapshot, err)
+			}
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeue}, nil
 }
 
 // Start implements the runnable interface needed in order to handle the start/stop
 // using the manager. It starts go routines needed by the collector to interact with the
 // broker.
 func (r *ServiceCollector) Start(ctx context.Context) error {
-	return dispatch(ctx, r.logger, resource.Service, r.subscriberChan, r.dispatcherChan, r.Client, r.subscribers)
+	// Subscribers are refused until the initial sync has completed.
+	waitForInitialSync(ctx, r.logger, r.name, r.informers, r.barrier, r.syncStatus, &corev1.Service{}, &corev1.Pod{})
+	r.warmup.synced()
+	return r.dispatcher.Dispatch(ctx, &DispatchSetup{
+		Logger:         r.logger,
+		Kind:           resource.Service,
+		Subscriptions:  r.subscriberChan,
+		Subscribers:    r.subscribers,
+		Client:         r.Client,
+		Cache:          r.cache,
+		Queue:          r.queue,
+		reconciles:     r.dispatcherChan,
+		replays:        r.replays,
+		resyncs:        r.metrics.resyncs.WithLabelValues(resource.Service),
+		resyncRequests: r.resyncRequests,
+		resyncPeriod:   r.resyncPeriod,
+		jitter:         r.jitter,
+		refresh:        newRefresher(resource.Service, r.clusterName, r.ttl, r.cache, r.queue, r.pause),
+		broadcast: r.broadcast.broadcaster(r.Client, func() client.ObjectList {
+			return &corev1.ServiceList{}
+		}),
+	})
 }
 
 // ObjFieldsHandler populates the evt from the object.
-func (r *ServiceCollector) ObjFieldsHandler(logger logr.Logger, evt *events.Resource, svc *corev1.Service) error {
+func (r *ServiceCollector) ObjFieldsHandler(ctx context.Context, logger logr.Logger, evt *events.Resource, svc *corev1.Service) (err error) {
 	if svc == nil {
 		return nil
 	}
 
+	_, span := tracing.Start(ctx, "ObjFieldsHandler", resource.Service, svc.Namespace)
+	defer func() { tracing.End(span, err) }()
+
 	svcUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(svc)
 	if err != nil {
 		logger.Error(err, "unable to convert to unstructured")
@@ -220,41 +486,242 @@ func (r *ServiceCollector) ObjFieldsHandler(logger logr.Logger, evt *events.Reso
 	for _, key := range metaUnused {
 		delete(metaMap, key)
 	}
+	// Only the allowed labels and annotations are forwarded, before the metadata are transformed and truncated.
+	r.metaKeys.filter(metaMap)
+	if err = transformMeta(metaMap, r.metaTransforms); err != nil {
+		logger.Error(err, "unable to transform meta")
+		return err
+	}
+
+	metaString, dropped, err := r.metaLimit.marshal(r.metaEncoder, metaMap)
+	if err != nil {
+		return err
+	}
+	if dropped != nil {
+		logger.V(2).Info("metadata truncated to fit the maximum size", "dropped", dropped)
+		r.metrics.metaTruncations.WithLabelValues(resource.Service).Inc()
+	}
+	if err = setMeta(evt, metaString, metaMap); err != nil {
+		return err
+	}
+	evt.SetObjectMeta(dropped.objectMeta(r.metaKeys.objectMeta(&svc.ObjectMeta)), "")
+	evt.SetMetaTruncated(dropped != nil)
 
-	metaString, err := json.Marshal(metaMap)
+	spec, err := serviceSpec(svc)
 	if err != nil {
 		return err
 	}
-	evt.SetMeta(string(metaString))
+	evt.SetSpec(spec)
 
 	return nil
 }
 
-// getSubscribers returns all the nodes where pods related to the current deployment are running.
-func (r *ServiceCollector) getSubscribers(ctx context.Context, logger logr.Logger, svc *corev1.Service) (fields.Subscribers, error) {
-	pods := corev1.PodList{}
-	if err := r.List(ctx, &pods, client.InNamespace(svc.Namespace), client.MatchingLabels(svc.Spec.Selector)); err != nil {
-		logger.Error(err, "unable to list pods related to resource", "in namespace", svc.Namespace)
-		return nil, err
+// getSubscribers returns the subscribers of the nodes where the current service is served, and the nodes themselves.
+func (r *ServiceCollector) getSubscribers(ctx context.Context, logger logr.Logger,
+	svc *corev1.Service) (_ fields.Subscribers, _ []string, err error) {
+	ctx, span := tracing.Start(ctx, "getSubscribers", resource.Service, svc.Namespace)
+	defer func() { tracing.End(span, err) }()
+
+	nodes, err := r.servingNodes(ctx, logger, svc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.zoneNodes && len(nodes) > 0 {
+		if nodes, err = zoneNodes(ctx, r.Client, nodes); err != nil {
+			logger.Error(err, "unable to list nodes related to resource")
+			return nil, nil, err
+		}
 	}
 
-	if len(pods.Items) == 0 {
+	return subscribersForNodes(ctx, r.subscribers, nodes), nodes, nil
+}
+
+// servingNodes returns the nodes where the current service is served, from its EndpointSlices or its selector. The
+// headless services are served as the others, the ExternalName ones by no pod. The services of the broadcast namespaces
+// are sent to all the nodes.
+func (r *ServiceCollector) servingNodes(ctx context.Context, logger logr.Logger, svc *corev1.Service) ([]string, error) {
+	if r.broadcast.has(svc.Namespace) {
+		return r.broadcast.nodes(ctx, logger, r.Client)
+	}
+	if isExternalName(svc) {
+		return r.externalNameNodes(ctx, logger, svc)
+	}
+
+	if r.endpointsNodes {
+		nodes, err := endpointsNodes(ctx, r.Client, svc)
+		if err != nil {
+			logger.Error(err, "unable to list endpointslices related to resource", "in namespace", svc.Namespace)
+			return nil, err
+		}
+		return nodes, nil
+	}
+
+	// A service without a selector does not select any pod, an empty label selector would select all of them.
+	if len(svc.Spec.Selector) == 0 {
 		return nil, nil
 	}
 
-	subs := make(fields.Subscribers)
-	for i := range pods.Items {
-		if pods.Items[i].Spec.NodeName != "" && pods.Items[i].Status.PodIP != "" {
-			if ok := r.subscribers.HasNode(pods.Items[i].Spec.NodeName); ok {
-				s := r.subscribers.GetSubscribersPerNode(pods.Items[i].Spec.NodeName)
-				for s1 := range s {
-					subs.Add(s1)
+	listOpts := &client.ListOptions{}
+	client.MatchingLabels(svc.Spec.Selector).ApplyToList(listOpts)
+	// Only the pods with an IP are serving traffic for the service.
+	selection, selected := liveSelection("serving", func(pod *corev1.Pod) bool { return pod.Status.PodIP != "" },
+		r.includeTerminated)
+	nodes, err := podNodes(ctx, r.Client, r.nodesMemo, r.metrics, svc.Namespace, selectorKey(selection, listOpts), selected, listOpts)
+	if err != nil {
+		logger.Error(err, "unable to list pods related to resource", "in namespace", svc.Namespace)
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// podLabelsDispatcher returns the dispatcher reconciling the services selecting a pod before or after a change of its
+// labels, so that they recompute their nodes: a service no longer selecting the only pod it had on a node is deleted
+// from it. The old labels are only known by the update events, hence the services are looked up by its handler.
+func (r *ServiceCollector) podLabelsDispatcher() *relatedDispatcher {
+	return &relatedDispatcher{
+		Client:     r.Client,
+		Name:       r.name + "-pod-labels-dispatcher",
+		Object:     &corev1.Pod{},
+		ObjectKind: resource.Pod,
+		metrics:    r.metrics,
+		Predicate: predicate.Funcs{
+			CreateFunc:  func(event.CreateEvent) bool { return false },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return !maps.Equal(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+			},
+		},
+		Handler: handler.Funcs{
+			UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+				for _, obj := range []client.Object{e.ObjectOld, e.ObjectNew} {
+					pod, ok := obj.(*corev1.Pod)
+					if !ok {
+						continue
+					}
+					services, err := selectingServices(ctx, r.Client, pod)
+					if err != nil {
+						log.FromContext(ctx).Error(err, "unable to get services list", "in namespace", pod.Namespace)
+						continue
+					}
+					for i := range services {
+						q.Add(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&services[i])})
+					}
 				}
+			},
+		},
+		Kind: resource.Service,
+		Related: func(_ context.Context, _ client.Reader, req ctrl.Request) ([]types.NamespacedName, error) {
+			return []types.NamespacedName{req.NamespacedName}, nil
+		},
+		CollectorSource: r.relatedChan,
+	}
+}
+
+// selectingServices returns the services selecting the given pod, looked up through the service selector index. A pod
+// can be selected by several services, headless or not. The selector of the ExternalName services is ignored.
+func selectingServices(ctx context.Context, cl client.Reader, pod *corev1.Pod) ([]corev1.Service, error) {
+	var selecting []corev1.Service
+	services := corev1.ServiceList{}
+	podLabels := labels.Set(pod.GetLabels())
+	for key, value := range podLabels {
+		if err := cl.List(ctx, &services, client.InNamespace(pod.Namespace),
+			client.MatchingFields{serviceSelectorIndex: selectorLabel(key, value)}); err != nil {
+			return nil, err
+		}
+		for i := range services.Items {
+			if !isExternalName(&services.Items[i]) &&
+				labels.SelectorFromValidatedSet(services.Items[i].Spec.Selector).Matches(podLabels) {
+				selecting = append(selecting, services.Items[i])
+			}
+		}
+	}
+	return selecting, nil
+}
+
+// endpointsNodes returns the nodes of the ready endpoints in the EndpointSlices of the service, each node only once.
+// The EndpointSlices are related to the service by the service name label, set also on the manually managed ones.
+func endpointsNodes(ctx context.Context, cl client.Reader, svc *corev1.Service) ([]string, error) {
+	slices := discoveryv1.EndpointSliceList{}
+	if err := cl.List(ctx, &slices, client.InNamespace(svc.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name}); err != nil {
+		return nil, err
+	}
+
+	set := nodeSetPool.Get().(map[string]struct{})
+	defer func() {
+		clear(set)
+		nodeSetPool.Put(set)
+	}()
+
+	var nodes []string
+	for i := range slices.Items {
+		for j := range slices.Items[i].Endpoints {
+			ep := &slices.Items[i].Endpoints[j]
+			// An unknown readiness is interpreted as ready.
+			if ep.NodeName == nil || *ep.NodeName == "" || (ep.Conditions.Ready != nil && !*ep.Conditions.Ready) {
+				continue
+			}
+			if _, ok := set[*ep.NodeName]; ok {
+				continue
 			}
+			set[*ep.NodeName] = struct{}{}
+			nodes = append(nodes, *ep.NodeName)
+		}
+	}
+
+	return nodes, nil
+}
+
+// zoneNodes expands the given nodes to all the nodes in their zones, read from the topology zone label of the nodes.
+// The given nodes are always part of the result, even when they are not labeled with a zone or are not in the cache.
+func zoneNodes(ctx context.Context, cl client.Reader, nodes []string) ([]string, error) {
+	gvk, err := resource.GroupVersionKind(resource.Node)
+	if err != nil {
+		return nil, err
+	}
+	list := &metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := cl.List(ctx, list); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		set[node] = struct{}{}
+	}
+	zones := make(map[string]struct{})
+	for i := range list.Items {
+		if _, ok := set[list.Items[i].Name]; !ok {
+			continue
+		}
+		if zone := list.Items[i].Labels[corev1.LabelTopologyZone]; zone != "" {
+			zones[zone] = struct{}{}
+		}
+	}
+
+	expanded := append([]string(nil), nodes...)
+	for i := range list.Items {
+		if _, ok := set[list.Items[i].Name]; ok {
+			continue
+		}
+		if _, ok := zones[list.Items[i].Labels[corev1.LabelTopologyZone]]; ok {
+			expanded = append(expanded, list.Items[i].Name)
 		}
 	}
 
-	return subs, nil
+	return expanded, nil
+}
+
+// endpointSliceService maps an EndpointSlice to the service it belongs to, through the service name label.
+func endpointSliceService(_ context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if !ok || name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
 }
 
 // GetName returns the name of the collector.
@@ -262,27 +729,97 @@ func (r *ServiceCollector) GetName() string {
 	return r.name
 }
 
+// SetSelectors replaces the label and annotation selectors of the collector, nil to not filter the resources, and
+// resyncs its resources: the subscribers receive the Delete events of the resources no longer selected and the
+// Create events of the newly selected ones.
+func (r *ServiceCollector) SetSelectors(labelSelector, annotationSelector labels.Selector) {
+	r.filter.set(labelSelector, annotationSelector)
+	requestResync(r.resyncRequests)
+}
+
+// SetExcludedNames replaces the patterns of the names of the excluded services, and resyncs the services: the
+// subscribers receive the Delete events of the services newly excluded and the Create events of the ones no longer
+// excluded.
+func (r *ServiceCollector) SetExcludedNames(patterns []string) {
+	r.filter.setExcludedNames(patterns)
+	requestResync(r.resyncRequests)
+}
+
+// Indexers returns the field indexers needed by the collector. The pods are listed by node when dispatching the
+// resources to the new subscribers, and the services selecting them are looked up by selector.
+func (r *ServiceCollector) Indexers() []Indexer {
+	return []Indexer{PodByNodeIndexer, ServiceBySelectorIndexer}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServiceCollector) SetupWithManager(mgr ctrl.Manager) error {
+	if r.invalidOptions != nil {
+		return fmt.Errorf("invalid options: %w", r.invalidOptions)
+	}
+	if err := registerIndexers(mgr, r.indexRegistry, r.Indexers()); err != nil {
+		return err
+	}
+
 	// Set the generic logger to be used in other function then the reconcile loop.
-	r.logger = mgr.GetLogger().WithName(r.name)
+	base := baseLogger(r.baseLogger, mgr)
+	r.logger = base.WithName(r.name)
+	r.informers = mgr.GetCache()
 
-	lc, err := newLogConstructor(mgr.GetLogger(), r.name, resource.Service)
+	lc, err := newLogConstructor(base, r.name, resource.Service)
 	if err != nil {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{},
-			builder.WithPredicates(predicatesWithMetrics(r.name, apiServerSource, nil))).
-		WithOptions(controller.Options{LogConstructor: lc}).
+	// The ExternalName services are reconciled when the nodes they are sent to change, and the services resolving
+	// their nodes from their selector when the pods they select change labels.
+	dispatchers := []*relatedDispatcher{r.externalNameDispatcher()}
+	if !r.endpointsNodes {
+		dispatchers = append(dispatchers, r.podLabelsDispatcher())
+	}
+	for _, d := range dispatchers {
+		if d == nil {
+			continue
+		}
+		if err := d.SetupWithManager(mgr); err != nil {
+			return err
+		}
+	}
+
+	// The EndpointSlices trigger the reconcile of the service controlling them. When the nodes are resolved from the
+	// endpoints, they trigger the service named by their label, so that the manually managed ones are watched too.
+	endpointSlicesHandler := handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), &corev1.Service{},
+		handler.OnlyControllerOwner())
+	if r.endpointsNodes {
+		endpointSlicesHandler = handler.EnqueueRequestsFromMapFunc(endpointSliceService)
+	}
+
+	// The services are watched through the coalescing handler, hence the controller is named as For would do.
+	bld := ctrl.NewControllerManagedBy(mgr).
+		Named(strings.ToLower(resource.Service)).
+		Watches(&corev1.Service{},
+			sweepHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, r.coalesceWindow), r.syncStatus, r.name),
+			builder.WithPredicates(r.metrics.predicates(r.name, apiServerSource, nil), changedFilter())).
+		WithOptions(controller.Options{LogConstructor: lc, RateLimiter: rateLimiter(r.jitter, r.rateLimiter)}).
 		WatchesRawSource(r.endpointsSource,
-			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(predicatesWithMetrics(r.name, resource.Endpoints, nil))).
+			debouncingHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, r.coalesceWindow), r.debounceWindow,
+				r.metrics.collapsedRequests.WithLabelValues(r.name, resource.Endpoints)),
+			builder.WithPredicates(r.metrics.predicates(r.name, resource.Endpoints, nil))).
 		WatchesRawSource(r.dispatcherSource,
 			&handler.EnqueueRequestForObject{},
-			builder.WithPredicates(predicatesWithMetrics(r.name, "dispatcher", nil))).
-		Owns(&discoveryv1.EndpointSlice{},
-			builder.WithPredicates(predicatesWithMetrics(r.name, resource.EndpointSlice, nil))).
-		Complete(r)
+			builder.WithPredicates(r.metrics.predicates(r.name, "dispatcher", nil))).
+		WatchesRawSource(r.relatedSource,
+			debouncingHandler(coalescingHandler(&handler.EnqueueRequestForObject{}, r.coalesceWindow), r.debounceWindow,
+				r.metrics.collapsedRequests.WithLabelValues(r.name, "related")),
+			builder.WithPredicates(r.metrics.predicates(r.name, "related", nil))).
+		Watches(&discoveryv1.EndpointSlice{},
+			coalescingHandler(endpointSlicesHandler, r.coalesceWindow),
+			builder.WithPredicates(r.metrics.predicates(r.name, resource.EndpointSlice, nil)))
+
+	// The resources excluded by annotation, the ones out of the watched namespaces and the ones rejected by the
+	// configured predicates are not reconciled.
+	for _, filter := range eventFilters(r.filter, r.namespaces, false, r.predicates) {
+		bld.WithEventFilter(filter)
+	}
+
+	return bld.Complete(r)
 }
@@ -0,0 +1,424 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestServiceNodesResolution(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "test"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	endpoint := func(node string, ready *bool) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{
+			Addresses:  []string{"10.0.0.1"},
+			NodeName:   ptr.To(node),
+			Conditions: discoveryv1.EndpointConditions{Ready: ready},
+		}
+	}
+	slice := func(name string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+		return &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+				Labels: map[string]string{discoveryv1.LabelServiceName: "svc"}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   endpoints,
+		}
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc,
+		pod("ready", "node-a"),
+		// The pod matches the selector but fails its readiness probe.
+		pod("not-ready", "node-b"),
+		slice("svc-abcde", endpoint("node-a", ptr.To(true)), endpoint("node-b", ptr.To(false))),
+		// A manually managed endpoint, not backed by a pod matching the selector.
+		slice("svc-manual", endpoint("node-c", nil)),
+		// An EndpointSlice of another service.
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-abcde", Namespace: "default",
+				Labels: map[string]string{discoveryv1.LabelServiceName: "other"}},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints:   []discoveryv1.Endpoint{endpoint("node-d", nil)},
+		},
+	).Build()
+
+	tests := []struct {
+		name      string
+		endpoints bool
+		want      []string
+	}{
+		{name: "selector", want: []string{"subscriber-a", "subscriber-b"}},
+		{name: "endpoints", endpoints: true, want: []string{"subscriber-a", "subscriber-c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+				WithEndpointsNodes(tt.endpoints))
+			for _, node := range []string{"a", "b", "c", "d"} {
+				collector.subscribers.AddSubscriberPerNode("node-"+node, "subscriber-"+node)
+			}
+
+			subs, _, err := collector.getSubscribers(context.Background(), collector.logger, svc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make([]string, 0, len(subs))
+			for sub := range subs {
+				got = append(got, sub)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected subscribers %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServiceZoneNodes(t *testing.T) {
+	svc := func(name, app string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": app}},
+		}
+	}
+	pod := func(name, node, app string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	node := func(name, zone string) *corev1.Node {
+		n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if zone != "" {
+			n.Labels = map[string]string{corev1.LabelTopologyZone: zone}
+		}
+		return n
+	}
+	services := []*corev1.Service{svc("zoned", "zoned"), svc("multi-zone", "multi"), svc("unzoned", "unzoned"),
+		svc("idle", "idle")}
+	cl := fake.NewClientBuilder().WithObjects(
+		node("node-a1", "zone-a"), node("node-a2", "zone-a"),
+		node("node-b1", "zone-b"), node("node-b2", "zone-b"),
+		node("node-c1", "zone-c"),
+		// The nodes without a zone are never added to the ones serving the services.
+		node("node-x", ""), node("node-y", ""),
+		services[0], services[1], services[2], services[3],
+		pod("zoned", "node-a1", "zoned"),
+		pod("multi-a", "node-a2", "multi"),
+		pod("multi-b", "node-b1", "multi"),
+		pod("unzoned", "node-x", "unzoned"),
+	).Build()
+
+	tests := []struct {
+		name  string
+		zones bool
+		want  map[string][]string
+	}{
+		{name: "disabled", want: map[string][]string{
+			"zoned":      {"node-a1"},
+			"multi-zone": {"node-a2", "node-b1"},
+			"unzoned":    {"node-x"},
+			"idle":       nil,
+		}},
+		{name: "enabled", zones: true, want: map[string][]string{
+			"zoned":      {"node-a1", "node-a2"},
+			"multi-zone": {"node-a1", "node-a2", "node-b1", "node-b2"},
+			"unzoned":    {"node-x"},
+			"idle":       nil,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector",
+				WithZoneNodes(tt.zones))
+			collector.subscribers.AddSubscriberPerNode("node-b2", "subscriber-b2")
+
+			for _, s := range services {
+				subs, nodes, err := collector.getSubscribers(context.Background(), collector.logger, s)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				sort.Strings(nodes)
+				if !reflect.DeepEqual(nodes, tt.want[s.Name]) {
+					t.Errorf("expected service %q to be related to nodes %v, got %v", s.Name, tt.want[s.Name], nodes)
+				}
+				// The subscriber of a node in the zone without backends receives the service only if enabled.
+				_, ok := subs["subscriber-b2"]
+				if want := tt.zones && s.Name == "multi-zone"; ok != want {
+					t.Errorf("expected subscriber of node-b2 for service %q: %v, got %v", s.Name, want, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipleSelectingServices(t *testing.T) {
+	service := func(name, clusterIP string, selector map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+			Spec:       corev1.ServiceSpec{ClusterIP: clusterIP, Selector: selector},
+		}
+	}
+	pod := func(namespace, name, node string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	services := []*corev1.Service{
+		service("web", "10.96.0.1", map[string]string{"app": "web"}),
+		// The headless service has no cluster IP, but still selects the pods.
+		service("web-headless", corev1.ClusterIPNone, map[string]string{"app": "web"}),
+		// The selector overlaps with the one of the web services.
+		service("web-canary", "10.96.0.2", map[string]string{"app": "web", "track": "canary"}),
+		service("api", "10.96.0.3", map[string]string{"app": "api"}),
+		// The service without a selector has its endpoints managed manually.
+		service("external", "", nil),
+	}
+	pods := []*corev1.Pod{
+		pod("default", "web-stable", "node-a", map[string]string{"app": "web", "track": "stable"}),
+		pod("default", "web-canary", "node-b", map[string]string{"app": "web", "track": "canary"}),
+		pod("default", "api", "node-c", map[string]string{"app": "api"}),
+		pod("default", "unlabeled", "node-d", nil),
+		// The pod of another namespace is not selected by the services.
+		pod("other", "web", "node-e", map[string]string{"app": "web"}),
+	}
+	builder := fake.NewClientBuilder().
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue)
+	for _, svc := range services {
+		builder = builder.WithObjects(svc)
+	}
+	for _, p := range pods {
+		builder = builder.WithObjects(p)
+	}
+	cl := builder.Build()
+
+	// Each pod is related to all the services selecting it, each of them once.
+	wantServices := map[string][]string{
+		"web-stable": {"web", "web-headless"},
+		"web-canary": {"web", "web-canary", "web-headless"},
+		"api":        {"api"},
+		"unlabeled":  nil,
+	}
+	for _, p := range pods[:4] {
+		selecting, err := selectingServices(context.Background(), cl, p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for i := range selecting {
+			got = append(got, selecting[i].Name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, wantServices[p.Name]) {
+			t.Errorf("expected pod %q to be selected by %v, got %v", p.Name, wantServices[p.Name], got)
+		}
+	}
+
+	// Each service is related to the nodes of the pods it selects.
+	wantNodes := map[string][]string{
+		"web":          {"node-a", "node-b"},
+		"web-headless": {"node-a", "node-b"},
+		"web-canary":   {"node-b"},
+		"api":          {"node-c"},
+		"external":     nil,
+	}
+	collector := NewServiceCollector(cl, &recordingQueue{}, events.NewCache(), "service-collector")
+	for _, svc := range services {
+		_, nodes, err := collector.getSubscribers(context.Background(), collector.logger, svc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Strings(nodes)
+		if !reflect.DeepEqual(nodes, wantNodes[svc.Name]) {
+			t.Errorf("expected service %q to be related to nodes %v, got %v", svc.Name, wantNodes[svc.Name], nodes)
+		}
+	}
+}
+
+func TestEndpointSliceService(t *testing.T) {
+	labeled := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "svc-manual", Namespace: "default",
+		Labels: map[string]string{discoveryv1.LabelServiceName: "svc"}}}
+	want := []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "svc"}}}
+	if got := endpointSliceService(context.Background(), labeled); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected requests %v, got %v", want, got)
+	}
+
+	unlabeled := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"}}
+	if got := endpointSliceService(context.Background(), unlabeled); got != nil {
+		t.Errorf("expected no requests for an EndpointSlice without service, got %v", got)
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "pod-uid",
+			Labels: map[string]string{"app": "test"}},
+		Spec:   corev1.PodSpec{NodeName: "node"},
+		Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(ns, svc, pod).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	// The service and the pod have the same name and are stored in the same cache.
+	cache := events.NewCache()
+	podQueue := &recordingQueue{}
+	podCollector := NewPodCollector(cl, podQueue, cache, "pod-collector")
+	podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	svcQueue := &recordingQueue{}
+	svcCollector := NewServiceCollector(cl, svcQueue, cache, "service-collector")
+	svcCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	check := func(step string, r reconcile.Reconciler, q *recordingQueue, want ...string) {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := q.pop(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	check("pod", podCollector, podQueue, events.Create)
+	check("service", svcCollector, svcQueue, events.Create)
+
+	if err := cl.Delete(ctx, svc); err != nil {
+		t.Fatalf("unable to delete service: %v", err)
+	}
+	check("service deleted", svcCollector, svcQueue, events.Delete)
+	entry, ok := cache.Get(cache.Key(resource.Pod, req.NamespacedName))
+	if !ok || entry.UID != pod.UID {
+		t.Fatalf("expected the pod to stay cached, got %v", entry)
+	}
+}
+
+func TestServicePodRelabel(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+	pod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "web"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod("web-a", "node-a"), pod("web-b", "node-b")).
+		WithIndex(ServiceBySelectorIndexer.Object, ServiceBySelectorIndexer.Field, ServiceBySelectorIndexer.ExtractValue).
+		Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector")
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b")
+	reconcile := func(step string) map[string]string {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		got := make(map[string]string)
+		for _, evt := range queue.evts {
+			for sub := range evt.Subscribers() {
+				got[sub] = evt.Type()
+			}
+		}
+		queue.evts = nil
+		return got
+	}
+	if got, want := reconcile("create"), map[string]string{"subscriber-a": events.Create,
+		"subscriber-b": events.Create}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+
+	// The pod of node-b is relabeled, it is no longer selected by the service.
+	oldPod := &corev1.Pod{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web-b"}, oldPod); err != nil {
+		t.Fatalf("unable to get pod: %v", err)
+	}
+	newPod := oldPod.DeepCopy()
+	newPod.Labels = map[string]string{"app": "other"}
+	if err := cl.Update(ctx, newPod); err != nil {
+		t.Fatalf("unable to update pod: %v", err)
+	}
+
+	// The dispatcher maps the update to the service selecting the old labels of the pod.
+	d := collector.podLabelsDispatcher()
+	update := event.UpdateEvent{ObjectOld: oldPod, ObjectNew: newPod}
+	if !d.Predicate.Update(update) {
+		t.Fatal("expected the relabeling of the pod to be dispatched")
+	}
+	if d.Predicate.Update(event.UpdateEvent{ObjectOld: oldPod, ObjectNew: oldPod}) {
+		t.Error("expected the updates not changing the labels to be filtered out")
+	}
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	d.Handler.Update(ctx, update, q)
+	if q.Len() != 1 {
+		t.Fatalf("expected the service to be enqueued once, got %d requests", q.Len())
+	}
+	item, _ := q.Get()
+	ch := make(chan event.GenericEvent, 1)
+	d.CollectorSource = ch
+	if _, err := d.Reconcile(ctx, item.(ctrl.Request)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := client.ObjectKeyFromObject((<-ch).Object); got != client.ObjectKeyFromObject(svc) {
+		t.Errorf("expected the service to be triggered, got %v", got)
+	}
+
+	// The service is deleted from node-b, where it no longer selects any pod.
+	if got, want := reconcile("relabel"), map[string]string{"subscriber-b": events.Delete}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// projectStatus returns the given fields of the status of the unstructured object, keeping their nesting. The fields
+// are dot separated paths relative to the status, e.g. readyReplicas or conditions. The missing fields are skipped.
+func projectStatus(obj map[string]interface{}, statusFields []string) (map[string]interface{}, error) {
+	status := make(map[string]interface{}, len(statusFields))
+	for _, field := range statusFields {
+		path := strings.Split(field, ".")
+		val, found, err := unstructured.NestedFieldNoCopy(obj, append([]string{"status"}, path...)...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get status field %q: %w", field, err)
+		}
+		if !found {
+			continue
+		}
+		if err := unstructured.SetNestedField(status, val, path...); err != nil {
+			return nil, fmt.Errorf("unable to project status field %q: %w", field, err)
+		}
+	}
+	return status, nil
+}
+
+// typedObject returns a new typed object for the given object, as registered in the scheme of the client. It is read
+// in place of the metadata by the collectors projecting status fields of their resources.
+func typedObject(cl client.Client, obj client.Object) (client.Object, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	typed, err := cl.Scheme().New(gvk)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a typed object for %s: %w", gvk, err)
+	}
+	cObj, ok := typed.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("typed object %T for %s is not a client.Object", typed, gvk)
+	}
+	return cObj, nil
+}
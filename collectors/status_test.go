@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestProjectStatus(t *testing.T) {
+	obj := map[string]interface{}{
+		"status": map[string]interface{}{
+			"readyReplicas": int64(2),
+			"replicas":      int64(3),
+			"conditions":    []interface{}{map[string]interface{}{"type": "Available"}},
+			"nested":        map[string]interface{}{"a": "a", "b": "b"},
+		},
+	}
+
+	got, err := projectStatus(obj, []string{"readyReplicas", "nested.a", "missing"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{"readyReplicas": int64(2), "nested": map[string]interface{}{"a": "a"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := projectStatus(obj, []string{"readyReplicas.value"}); err == nil {
+		t.Error("expected an error for a path through a non map field")
+	}
+}
+
+func TestStatusObjectTransformer(t *testing.T) {
+	transform := StatusObjectTransformer(logr.Discard(), []string{"readyReplicas"})
+	dpl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", ResourceVersion: "1",
+			Labels: map[string]string{"app": "test"}},
+		Spec:   appsv1.DeploymentSpec{Replicas: ptr.To[int32](3)},
+		Status: appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 2},
+	}
+
+	got, err := transform(dpl)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// The metadata objects of the same kind are transformed as the partial objects.
+	meta := NewPartialObjectMetadata(resource.Deployment, nil)
+	meta.ResourceVersion = "1"
+	got, err = transform(meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.(*metav1.PartialObjectMetadata).ResourceVersion != "" {
+		t.Error("expected the metadata object to be filtered")
+	}
+}
+
+func TestDeploymentStatusFields(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid"},
+		Status:     appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 1},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).WithStatusSubresource(dpl).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"deployment-collector", WithStatusFields("readyReplicas"))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	check := func(step string, wantStatus string, want ...string) {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if len(queue.evts) > 0 {
+			if got := queue.evts[0].GRPCMessage().GetStatus(); got != wantStatus {
+				t.Errorf("%s: expected status %q, got %q", step, wantStatus, got)
+			}
+		}
+		if got := queue.pop(); len(got)+len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+	updateStatus := func(status appsv1.DeploymentStatus) {
+		t.Helper()
+		dpl.Status = status
+		if err := cl.Status().Update(ctx, dpl); err != nil {
+			t.Fatalf("unable to update deployment: %v", err)
+		}
+	}
+
+	check("created", `{"readyReplicas":1}`, events.Create)
+	if collector.resource.Name != dpl.Name {
+		t.Errorf("expected the metadata of the deployment to be read, got %+v", collector.resource.ObjectMeta)
+	}
+
+	updateStatus(appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 3})
+	check("ready replicas changed", `{"readyReplicas":3}`, events.Update)
+
+	check("unchanged", "")
+}
+
+func TestStatusFieldsChangedFilter(t *testing.T) {
+	oldDpl := &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 1}}
+	replicas := oldDpl.DeepCopy()
+	replicas.Status.Replicas = 4
+	ready := oldDpl.DeepCopy()
+	ready.Status.ReadyReplicas = 2
+
+	p := changedFilter("readyReplicas")
+	if p.Update(event.UpdateEvent{ObjectOld: oldDpl, ObjectNew: replicas}) {
+		t.Error("expected the update of a status field not projected to be filtered")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: oldDpl, ObjectNew: ready}) {
+		t.Error("expected the update of a projected status field to pass")
+	}
+	if changedFilter().Update(event.UpdateEvent{ObjectOld: oldDpl, ObjectNew: ready}) {
+		t.Error("expected the status updates to be filtered when no status field is projected")
+	}
+}
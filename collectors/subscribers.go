@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"sync"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// nodeSetPool holds the sets used to de-duplicate the nodes of the pods related to a resource. Many pods
+// usually share the same node, and the sets are reused across reconciles to reduce the allocations.
+var nodeSetPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]struct{})
+	},
+}
+
+// isTerminated returns true if the pod is in a terminal phase: its containers have completed, as for the pods of
+// the Jobs, or have been stopped for good, as for the evicted pods. Such pods keep their node until they are deleted.
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// liveSelection returns the named selection of pods restricted to the ones not in a terminal phase, unless
+// includeTerminated is true. The returned name identifies the selection in the NodesMemo.
+func liveSelection(name string, selected func(pod *corev1.Pod) bool,
+	includeTerminated bool) (string, func(pod *corev1.Pod) bool) {
+	if includeTerminated {
+		return name, selected
+	}
+	return name + "-live", func(pod *corev1.Pod) bool {
+		return !isTerminated(pod) && selected(pod)
+	}
+}
+
+// nodesForPods returns the nodes where the selected pods are running, each node only once. The pods excluded
+// from the collection by annotation are not taken in account.
+func nodesForPods(pods []corev1.Pod, selected func(pod *corev1.Pod) bool) []string {
+	set := nodeSetPool.Get().(map[string]struct{})
+	defer func() {
+		clear(set)
+		nodeSetPool.Put(set)
+	}()
+
+	var nodes []string
+	for i := range pods {
+		node := pods[i].Spec.NodeName
+		if node == "" || isIgnored(&pods[i]) || !selected(&pods[i]) {
+			continue
+		}
+		if _, ok := set[node]; ok {
+			continue
+		}
+		set[node] = struct{}{}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// subscribersForNodes returns the subscribers of the given nodes. It returns nil if no subscribers are found.
+// The number of nodes is recorded in the span carried by the context.
+func subscribersForNodes(ctx context.Context, subscribers *subscriber.Subscribers, nodes []string) fields.Subscribers {
+	tracing.SetAttributes(trace.SpanFromContext(ctx), tracing.NodesKey.Int(len(nodes)))
+
+	var subs fields.Subscribers
+	for _, node := range nodes {
+		subs = subscribers.AddSubscribersPerNodeTo(node, subs)
+	}
+
+	return subs
+}
+
+// indexNodes replaces the nodes related to the cached resource in the node index of the cache and attaches the
+// changes of the nodes to the Update events of the resource.
+func indexNodes(cache *events.Cache, res *events.Resource, key string, nodes []string) {
+	res.SetNodeDelta(cache.SetNodes(key, nodes...))
+}
+
+// podNodes returns the nodes where the selected pods of the namespace matching the list options are running.
+// The result is memoized under the given key, which must identify both the list options and the selection, and the
+// lookups in the memo are counted in the given metrics. A nil memo disables the memoization.
+func podNodes(ctx context.Context, cl client.Reader, memo *NodesMemo, metrics *collectorMetrics, namespace, key string,
+	selected func(pod *corev1.Pod) bool, opts ...client.ListOption) ([]string, error) {
+	nodes, generation, ok := memo.lookup(namespace, key)
+	if memo != nil {
+		metrics.memoLookup(ok)
+	}
+	if ok {
+		return nodes, nil
+	}
+
+	pods := corev1.PodList{}
+	if err := cl.List(ctx, &pods, append([]client.ListOption{client.InNamespace(namespace)}, opts...)...); err != nil {
+		return nil, err
+	}
+
+	nodes = nodesForPods(pods.Items, selected)
+	memo.store(namespace, key, generation, nodes)
+
+	return nodes, nil
+}
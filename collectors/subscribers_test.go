@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newPods returns the given number of pods spread across the given number of nodes.
+func newPods(pods, nodes int) []corev1.Pod {
+	items := make([]corev1.Pod, pods)
+	for i := range items {
+		items[i].Spec.NodeName = fmt.Sprintf("node-%d", i%nodes)
+		items[i].Status.PodIP = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+	return items
+}
+
+// newSubscribers returns the subscribers for the given number of nodes, one for each even node.
+func newSubscribers(nodes int) *subscriber.Subscribers {
+	subs := subscriber.NewSubscribers()
+	for i := 0; i < nodes; i += 2 {
+		subs.AddSubscriberPerNode(fmt.Sprintf("node-%d", i), fmt.Sprintf("sub-%d", i))
+	}
+	return subs
+}
+
+// subscribersForPodsPerPod is the previous implementation, copying the subscribers of the node for each pod.
+func subscribersForPodsPerPod(subscribers *subscriber.Subscribers, pods []corev1.Pod) fields.Subscribers {
+	subs := make(fields.Subscribers)
+	for i := range pods {
+		if pods[i].Spec.NodeName != "" {
+			if ok := subscribers.HasNode(pods[i].Spec.NodeName); ok {
+				for s := range subscribers.GetSubscribersPerNode(pods[i].Spec.NodeName) {
+					subs.Add(s)
+				}
+			}
+		}
+	}
+	return subs
+}
+
+func TestSubscribersForNodes(t *testing.T) {
+	pods := newPods(100, 10)
+	// A pod not yet scheduled.
+	pods = append(pods, corev1.Pod{})
+	subs := newSubscribers(10)
+	all := func(*corev1.Pod) bool { return true }
+
+	got := subscribersForNodes(context.Background(), subs, nodesForPods(pods, all))
+	if want := subscribersForPodsPerPod(subs, pods); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected subscribers %v, got %v", want, got)
+	}
+
+	// Calling it again reuses the pooled node set, the result must not change.
+	if again := subscribersForNodes(context.Background(), subs, nodesForPods(pods, all)); !reflect.DeepEqual(again, got) {
+		t.Errorf("expected subscribers %v, got %v", got, again)
+	}
+
+	// Ignored pods do not contribute.
+	ignored := newPods(1, 1)
+	ignored[0].Annotations = map[string]string{consts.IgnoreAnnotation: "true"}
+	if nodes := nodesForPods(ignored, all); nodes != nil {
+		t.Errorf("expected no nodes for ignored pods, got %v", nodes)
+	}
+
+	// Filtered out pods do not contribute.
+	if none := subscribersForNodes(context.Background(), subs, nodesForPods(pods, func(*corev1.Pod) bool { return false })); none != nil {
+		t.Errorf("expected no subscribers, got %v", none)
+	}
+}
+
+func BenchmarkSubscribersForNodes(b *testing.B) {
+	pods := newPods(10000, 100)
+	subs := newSubscribers(100)
+
+	b.Run("per-pod", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			subscribersForPodsPerPod(subs, pods)
+		}
+	})
+
+	b.Run("per-node", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			subscribersForNodes(context.Background(), subs, nodesForPods(pods, func(*corev1.Pod) bool { return true }))
+		}
+	})
+}
@@ -14,19 +14,18 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package collectors
+package collectors_test
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/testutil"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -34,9 +33,7 @@ import (
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
-var cfg *rest.Config
-var k8sClient client.Client
-var testEnv *envtest.Environment
+var harness *testutil.Harness
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -45,33 +42,42 @@ func TestAPIs(t *testing.T) {
 }
 
 var _ = BeforeSuite(func() {
+	if !testutil.AssetsAvailable() {
+		Skip("KUBEBUILDER_ASSETS is not set")
+	}
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
 	By("bootstrapping test environment")
-	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
-		ErrorIfCRDPathMissing: false,
-	}
-
 	var err error
-	// cfg is defined in this file globally.
-	cfg, err = testEnv.Start()
+	harness, err = testutil.Start(context.Background(),
+		testutil.WithCRDDirectoryPaths(filepath.Join("..", "config", "crd", "bases")))
 	Expect(err).NotTo(HaveOccurred())
-	Expect(cfg).NotTo(BeNil())
-
-	err = corev1.AddToScheme(scheme.Scheme)
-	Expect(err).NotTo(HaveOccurred())
-
-	//+kubebuilder:scaffold:scheme
-
-	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
-	Expect(err).NotTo(HaveOccurred())
-	Expect(k8sClient).NotTo(BeNil())
-
+	Expect(harness).NotTo(BeNil())
 })
 
 var _ = AfterSuite(func() {
+	if harness == nil {
+		return
+	}
 	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).NotTo(HaveOccurred())
+	Expect(harness.Stop()).To(Succeed())
+})
+
+var _ = Describe("Collectors", func() {
+	It("sends the resources related to the pods of a node to its subscribers", func(ctx SpecContext) {
+		_, err := harness.CreateNamespace(ctx, "suite")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = harness.CreateDeployment(ctx, "suite", "web", "node-suite", 2)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = harness.CreatePod(ctx, "suite", "other", "node-other", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = harness.Subscribe(ctx, "node-suite")
+		Expect(err).NotTo(HaveOccurred())
+		for _, kind := range []string{resource.Pod, resource.Pod, resource.ReplicaSet, resource.Deployment,
+			resource.Namespace} {
+			_, err = harness.WaitForEvent(kind, events.Create, "node-suite")
+			Expect(err).NotTo(HaveOccurred(), kind)
+		}
+	})
 })
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// waitForInitialSync blocks until the informers backing the given objects, and all the other informers
+// known to the cache, have synced. Once done, the collector is marked as ready in the barrier and its caches as
+// synced in the sync status. If neither has been configured it returns immediately.
+func waitForInitialSync(ctx context.Context, logger logr.Logger, name string, informers cache.Informers,
+	barrier *health.Barrier, syncStatus *health.SyncStatus, objs ...client.Object) {
+	if barrier == nil && syncStatus == nil {
+		return
+	}
+
+	logger.Info("waiting for informers to sync before accepting subscribers")
+	for _, obj := range objs {
+		// GetInformer blocks until the informer for the object has synced.
+		if _, err := informers.GetInformer(ctx, obj); err != nil {
+			if ctx.Err() == nil {
+				logger.Error(err, "unable to sync informer", "object", obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+			return
+		}
+	}
+
+	if !informers.WaitForCacheSync(ctx) {
+		return
+	}
+
+	if barrier != nil {
+		barrier.Done(name)
+	}
+	syncStatus.CachesSynced(name)
+	logger.Info("initial sync completed")
+}
+
+// sweepHandler wraps an event handler so that the objects received through the create events are recorded in the
+// sync status of the collector. The ones received before the caches synced make up the initial sweep.
+func sweepHandler(h handler.EventHandler, syncStatus *health.SyncStatus, name string) handler.EventHandler {
+	if syncStatus == nil {
+		return h
+	}
+
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			syncStatus.Seen(name, client.ObjectKeyFromObject(e.Object).String())
+			h.Create(ctx, e, q)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			h.Update(ctx, e, q)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			h.Delete(ctx, e, q)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			h.Generic(ctx, e, q)
+		},
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestInitialSweep(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	cl := fake.NewClientBuilder().WithObjects(ns).Build()
+	syncStatus := health.NewSyncStatus()
+	collector := NewObjectMetaCollector(cl, &recordingQueue{}, events.NewCache(),
+		NewPartialObjectMetadata(resource.Namespace, nil), "namespace-collector", WithSyncStatus(syncStatus))
+
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	h := sweepHandler(&handler.EnqueueRequestForObject{}, syncStatus, collector.name)
+	h.Create(ctx, event.CreateEvent{Object: ns}, q)
+	if q.Len() != 1 {
+		t.Fatalf("expected the request to be enqueued, got %d requests", q.Len())
+	}
+
+	// The namespace received before the caches synced is part of the initial sweep.
+	syncStatus.CachesSynced(collector.name)
+	if err := syncStatus.Checker(nil); err == nil {
+		t.Fatal("expected the collector not to be ready before the namespace is reconciled")
+	}
+	if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ns)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := syncStatus.Checker(nil); err != nil {
+		t.Errorf("expected the collector to be ready once the initial sweep completed, got %v", err)
+	}
+}
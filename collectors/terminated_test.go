@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestLiveSelection(t *testing.T) {
+	all := func(*corev1.Pod) bool { return true }
+	tests := map[corev1.PodPhase]bool{
+		corev1.PodPending:   true,
+		corev1.PodRunning:   true,
+		corev1.PodSucceeded: false,
+		corev1.PodFailed:    false,
+	}
+	name, selected := liveSelection("all", all, false)
+	if name == "all" {
+		t.Error("expected the live selection to have its own name")
+	}
+	for phase, want := range tests {
+		pod := &corev1.Pod{Status: corev1.PodStatus{Phase: phase}}
+		if got := selected(pod); got != want {
+			t.Errorf("expected pod in phase %s to be selected %v, got %v", phase, want, got)
+		}
+	}
+
+	name, selected = liveSelection("all", all, true)
+	if name != "all" || !selected(&corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}) {
+		t.Error("expected the terminated pods to be selected when included")
+	}
+}
+
+func TestCompletedJobPods(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch", UID: "ns-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-abcde", Namespace: "batch", UID: "pod-uid",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1", Kind: "Job", Name: "job", UID: "job-uid", Controller: ptr.To(true),
+			}},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node"},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	cl := fake.NewClientBuilder().WithObjects(ns, pod).Build()
+
+	nsTrigger := make(chan event.GenericEvent, 1)
+	memo := NewNodesMemo()
+	podQueue := &recordingQueue{}
+	podCollector := NewPodCollector(cl, podQueue, events.NewCache(), "pod-collector", WithNodesMemo(memo),
+		WithOwnerSources(map[string]chan<- event.GenericEvent{resource.Namespace: nsTrigger}))
+	podCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	nsQueue := &recordingQueue{}
+	nsCollector := NewObjectMetaCollector(cl, nsQueue, events.NewCache(),
+		NewPartialObjectMetadata(resource.Namespace, nil), "namespace-collector", WithNodesMemo(memo))
+	nsCollector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	h := memo.Handler(handler.Funcs{})
+
+	check := func(step string, r reconcile.Reconciler, q *recordingQueue, obj client.Object, want ...string) {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if got := q.pop(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	check("pod running", podCollector, podQueue, pod, events.Create)
+	<-nsTrigger
+	check("namespace of running pod", nsCollector, nsQueue, ns, events.Create)
+
+	// The pod of the Job completes: the namespace is re-evaluated and deleted from the node.
+	old := pod.DeepCopy()
+	pod.Status.Phase = corev1.PodSucceeded
+	if err := cl.Status().Update(ctx, pod); err != nil {
+		t.Fatalf("unable to update pod: %v", err)
+	}
+	h.Update(ctx, event.UpdateEvent{ObjectOld: old, ObjectNew: pod}, nil)
+	check("pod completed", podCollector, podQueue, pod, events.Update)
+	select {
+	case <-nsTrigger:
+	case <-time.After(time.Second):
+		t.Fatal("expected the namespace to be triggered when the pod completes")
+	}
+	check("namespace of completed pod", nsCollector, nsQueue, ns, events.Delete)
+}
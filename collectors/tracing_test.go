@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// spanAttribute returns the value of the attribute with the given key.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestReconcileTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	objs := []client.Object{svc}
+	for i, node := range []string{"node-a", "node-a", "node-b"} {
+		objs = append(objs, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "default", Labels: map[string]string{"app": "test"}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{PodIP: fmt.Sprintf("10.0.0.%d", i)},
+		})
+	}
+	cl := fake.NewClientBuilder().WithObjects(objs...).Build()
+
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector")
+	collector.subscribers.AddSubscriberPerNode("node-a", "subscriber-a")
+	collector.subscribers.AddSubscriberPerNode("node-b", "subscriber-b")
+
+	if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	reconcile, ok := spans["Reconcile"]
+	if !ok {
+		t.Fatalf("expected a Reconcile span, got %v", spans)
+	}
+	if v, _ := spanAttribute(reconcile, tracing.KindKey); v.AsString() != "Service" {
+		t.Errorf("expected the Reconcile span to have kind Service, got %q", v.AsString())
+	}
+	if v, _ := spanAttribute(reconcile, tracing.NamespaceKey); v.AsString() != "default" {
+		t.Errorf("expected the Reconcile span to have namespace default, got %q", v.AsString())
+	}
+	if v, _ := spanAttribute(reconcile, tracing.SubscribersKey); v.AsInt64() != 2 {
+		t.Errorf("expected the Reconcile span to have 2 subscribers, got %d", v.AsInt64())
+	}
+
+	for _, name := range []string{"getSubscribers", "ObjFieldsHandler"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %s span, got %v", name, spans)
+		}
+		if span.Parent().SpanID() != reconcile.SpanContext().SpanID() {
+			t.Errorf("expected the %s span to be a child of the Reconcile span", name)
+		}
+	}
+	if v, _ := spanAttribute(spans["getSubscribers"], tracing.NodesKey); v.AsInt64() != 2 {
+		t.Errorf("expected the getSubscribers span to have 2 nodes, got %d", v.AsInt64())
+	}
+
+	// The events carry the span context of the reconcile, to be propagated up to the delivery.
+	if len(queue.evts) != 1 {
+		t.Fatalf("expected one event, got %d", len(queue.evts))
+	}
+	if !queue.evts[0].SpanContext().Equal(reconcile.SpanContext()) {
+		t.Error("expected the event to carry the span context of the Reconcile span")
+	}
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import "fmt"
+
+// MetaTransform transforms the metadata of a resource, in its unstructured form, before it is serialized in the
+// events. It can modify the map in place, e.g. to normalize the label keys or to inject the name of the cluster.
+type MetaTransform func(meta map[string]interface{}) error
+
+// transformMeta applies the transforms to the metadata in order. The first failing transform stops the pipeline.
+func transformMeta(meta map[string]interface{}, transforms []MetaTransform) error {
+	for i, transform := range transforms {
+		if err := transform(meta); err != nil {
+			return fmt.Errorf("meta transform %d failed: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// clusterNameTransform returns the transform injecting the name of the cluster in the metadata.
+func clusterNameTransform(name string) MetaTransform {
+	return func(meta map[string]interface{}) error {
+		meta["clusterName"] = name
+		return nil
+	}
+}
+
+// metaTransforms returns the transforms of the collector: the injection of the cluster name, if configured, followed
+// by the configured transforms.
+func metaTransforms(opts *collectorOptions) []MetaTransform {
+	if opts.clusterName == "" {
+		return opts.metaTransforms
+	}
+	return append([]MetaTransform{clusterNameTransform(opts.clusterName)}, opts.metaTransforms...)
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// lowerLabelKeys is a transform normalizing the keys of the labels to lowercase.
+func lowerLabelKeys(meta map[string]interface{}) error {
+	labels, ok := meta["labels"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	normalized := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		normalized[strings.ToLower(key)] = value
+	}
+	meta["labels"] = normalized
+	return nil
+}
+
+// clusterLabel returns a transform injecting the name of the cluster in the labels.
+func clusterLabel(cluster string) MetaTransform {
+	return func(meta map[string]interface{}) error {
+		labels, ok := meta["labels"].(map[string]interface{})
+		if !ok {
+			labels = make(map[string]interface{})
+			meta["labels"] = labels
+		}
+		labels["Cluster"] = cluster
+		return nil
+	}
+}
+
+func TestMetaTransforms(t *testing.T) {
+	obj := NewPartialObjectMetadata(resource.Deployment, nil)
+	obj.ObjectMeta = metav1.ObjectMeta{Name: "app", Namespace: "default", Labels: map[string]string{"App": "web"}}
+
+	tests := map[string]struct {
+		transforms []MetaTransform
+		labels     map[string]interface{}
+	}{
+		"normalize then inject": {
+			transforms: []MetaTransform{lowerLabelKeys, clusterLabel("prod")},
+			labels:     map[string]interface{}{"app": "web", "Cluster": "prod"},
+		},
+		"inject then normalize": {
+			transforms: []MetaTransform{clusterLabel("prod"), lowerLabelKeys},
+			labels:     map[string]interface{}{"app": "web", "cluster": "prod"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The transforms are registered through two options, and run in registration order.
+			collector := NewObjectMetaCollector(nil, nil, events.NewCache(), obj, "deployment-collector",
+				WithMetaTransforms(tt.transforms[0]), WithMetaTransforms(tt.transforms[1:]...))
+			res := events.NewResource(resource.Deployment, "uid")
+			if err := collector.objFieldsHandler(context.Background(), logr.Discard(), res, obj.DeepCopy(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var meta map[string]interface{}
+			if err := json.Unmarshal([]byte(res.GetMetadata()), &meta); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(meta["labels"], tt.labels) {
+				t.Errorf("expected labels %v, got %v", tt.labels, meta["labels"])
+			}
+		})
+	}
+}
+
+func TestMetaTransformsError(t *testing.T) {
+	failure := errors.New("failure")
+	called := false
+	err := transformMeta(map[string]interface{}{}, []MetaTransform{
+		func(map[string]interface{}) error { return failure },
+		func(map[string]interface{}) error { called = true; return nil },
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the error of the transform, got %v", err)
+	}
+	if called {
+		t.Error("expected the pipeline to stop at the failing transform")
+	}
+}
+
+func TestClusterName(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "svc-uid"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "test"}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "test"}},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(svc, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewServiceCollector(cl, queue, events.NewCache(), "service-collector", WithClusterName("prod"))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	reconcile := func() {
+		t.Helper()
+		if _, err := collector.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(svc)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	reconcile()
+	if err := cl.Delete(context.Background(), svc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reconcile()
+
+	if len(queue.evts) != 2 {
+		t.Fatalf("expected a Create and a Delete event, got %d events", len(queue.evts))
+	}
+	for _, evt := range queue.evts {
+		if got := evt.GRPCMessage().GetCluster(); got != "prod" {
+			t.Errorf("expected the %s event to be stamped with the cluster, got %q", evt.Type(), got)
+		}
+	}
+
+	var meta map[string]interface{}
+	if err := json.Unmarshal([]byte(queue.evts[0].GRPCMessage().GetMeta()), &meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta["clusterName"] != "prod" {
+		t.Errorf("expected the cluster name in the metadata, got %v", meta)
+	}
+}
@@ -17,13 +17,17 @@ package collectors
 
 import (
 	"fmt"
+	"reflect"
 
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // PodTransformer transforms the pod objects received from the api-server
@@ -37,8 +41,7 @@ var PodTransformer = func(logger logr.Logger) toolscache.TransformFunc {
 			return nil, err
 		}
 
-		podIP := pod.Status.PodIP
-		pod.Status = corev1.PodStatus{PodIP: podIP}
+		pod.Status = corev1.PodStatus{PodIP: pod.Status.PodIP, Phase: pod.Status.Phase}
 		nodeName := pod.Spec.NodeName
 		pod.Spec = corev1.PodSpec{NodeName: nodeName}
 		filterOutMetaFields(&pod.ObjectMeta)
@@ -62,6 +65,48 @@ var PartialObjectTransformer = func(logger logr.Logger) toolscache.TransformFunc
 	}
 }
 
+// StatusObjectTransformer transforms the objects of the kinds whose status fields are projected in the events,
+// before adding them to the cache. The typed objects keep only their metadata and the given status fields, the
+// metadata objects are transformed as by PartialObjectTransformer.
+var StatusObjectTransformer = func(logger logr.Logger, statusFields []string) toolscache.TransformFunc {
+	partial := PartialObjectTransformer(logger)
+	return func(i interface{}) (interface{}, error) {
+		if _, ok := i.(*metav1.PartialObjectMetadata); ok {
+			return partial(i)
+		}
+		obj, ok := i.(client.Object)
+		if !ok {
+			err := fmt.Errorf("unable to convert %T to client.Object", i)
+			logger.Error(err, "transformer", "kind", "StatusObject")
+			return nil, err
+		}
+
+		objUn, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+		status, err := projectStatus(objUn, statusFields)
+		if err != nil {
+			logger.Error(err, "transformer", "kind", obj.GetObjectKind().GroupVersionKind().Kind)
+			return nil, err
+		}
+		// The object is rebuilt from its metadata and the projected status only.
+		trimmed := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(client.Object)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(map[string]interface{}{
+			"metadata": objUn["metadata"],
+			"status":   status,
+		}, trimmed); err != nil {
+			return nil, err
+		}
+		if accessor, ok := trimmed.(metav1.ObjectMetaAccessor); ok {
+			if meta, ok := accessor.GetObjectMeta().(*metav1.ObjectMeta); ok {
+				filterOutMetaFields(meta)
+			}
+		}
+		return trimmed, nil
+	}
+}
+
 // ServiceTransformer transforms the service objects received from the api-server
 // before adding them to the cache.
 var ServiceTransformer = func(logger logr.Logger) toolscache.TransformFunc {
@@ -73,8 +118,12 @@ var ServiceTransformer = func(logger logr.Logger) toolscache.TransformFunc {
 			return nil, err
 		}
 
-		selector := svc.Spec.Selector
-		svc.Spec = corev1.ServiceSpec{Selector: selector}
+		// The cluster IP is kept only to flag the headless services.
+		spec := corev1.ServiceSpec{Selector: svc.Spec.Selector, Type: svc.Spec.Type, ExternalName: svc.Spec.ExternalName}
+		if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			spec.ClusterIP = corev1.ClusterIPNone
+		}
+		svc.Spec = spec
 		svc.Status = corev1.ServiceStatus{}
 		filterOutMetaFields(&svc.ObjectMeta)
 		return svc, nil
@@ -98,13 +147,53 @@ var EndpointsliceTransformer = func(logger logr.Logger) toolscache.TransformFunc
 	}
 }
 
+// KeepAnnotationsTransformer wraps the transform so that the objects added to the cache keep the annotations with the
+// given keys, e.g. the ones matched by the annotation selectors of the collectors.
+var KeepAnnotationsTransformer = func(transform toolscache.TransformFunc, keys []string) toolscache.TransformFunc {
+	if len(keys) == 0 {
+		return transform
+	}
+	return func(i interface{}) (interface{}, error) {
+		obj, ok := i.(metav1.Object)
+		if !ok {
+			return transform(i)
+		}
+		kept := make(map[string]string, len(keys))
+		for _, key := range keys {
+			if value, ok := obj.GetAnnotations()[key]; ok {
+				kept[key] = value
+			}
+		}
+
+		transformed, err := transform(i)
+		if err != nil || len(kept) == 0 {
+			return transformed, err
+		}
+		if obj, ok := transformed.(metav1.Object); ok {
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string, len(kept))
+			}
+			for key, value := range kept {
+				annotations[key] = value
+			}
+			obj.SetAnnotations(annotations)
+		}
+		return transformed, nil
+	}
+}
+
 // filterOutMetaFields removes unused metadata fields.
 func filterOutMetaFields(meta *metav1.ObjectMeta) {
 	// Current fields that are not filtered out:
 	// Name, GenerateName, Namespace, UID, CreationTimestamp, Labels, OwnerReferences.
-	meta.Annotations = nil
+	// The only annotation kept is the one used to exclude the resource from the collection.
+	if ignore, ok := meta.Annotations[consts.IgnoreAnnotation]; ok {
+		meta.Annotations = map[string]string{consts.IgnoreAnnotation: ignore}
+	} else {
+		meta.Annotations = nil
+	}
 	meta.ManagedFields = nil
-	meta.Annotations = nil
 	meta.Finalizers = nil
 	meta.ResourceVersion = ""
 	meta.DeletionTimestamp = nil
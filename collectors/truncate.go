@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TruncationPolicy selects the entries of the metadata dropped first when the serialized metadata of a resource exceed
+// the maximum size.
+type TruncationPolicy string
+
+const (
+	// TruncateAnnotations drops the annotations first, the largest first, then the labels.
+	TruncateAnnotations TruncationPolicy = "annotations"
+	// TruncateLabels drops the labels first, the largest first, then the annotations.
+	TruncateLabels TruncationPolicy = "labels"
+)
+
+// ParseTruncationPolicy returns the truncation policy with the given name.
+func ParseTruncationPolicy(name string) (TruncationPolicy, error) {
+	switch policy := TruncationPolicy(name); policy {
+	case TruncateAnnotations, TruncateLabels:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown truncation policy %q, expected %q or %q", name, TruncateAnnotations, TruncateLabels)
+	}
+}
+
+// metaLimit caps the size of the serialized metadata of the resources.
+type metaLimit struct {
+	// maxSize is the maximum size in bytes of the serialized metadata, not enforced if not positive.
+	maxSize int
+	policy  TruncationPolicy
+}
+
+// droppedKeys holds the keys of the labels and annotations dropped from the metadata.
+type droppedKeys map[string][]string
+
+// marshal serializes the metadata, in their unstructured form, with the given encoder, encoding/json if nil. If they
+// exceed the maximum size, the largest entries of the maps selected by the policy are dropped until they fit: the
+// size of the entries is computed once, and the entries are dropped in a single pass until the bytes they take in the
+// JSON form cover the excess, then the metadata are serialized again. Another pass is done only if the encoder saves
+// less than expected. The metadata are sent exceeding the maximum size when they still do once both the annotations
+// and the labels have been dropped. It returns the dropped keys per field, nil if the metadata have not been
+// truncated.
+func (l metaLimit) marshal(enc MetaEncoder, meta map[string]interface{}) ([]byte, droppedKeys, error) {
+	if enc == nil {
+		enc = JSONEncoder
+	}
+	data, err := enc.Marshal(meta)
+	if err != nil || l.maxSize <= 0 || len(data) <= l.maxSize {
+		return data, nil, err
+	}
+
+	fields := []string{"annotations", "labels"}
+	if l.policy == TruncateLabels {
+		fields = []string{"labels", "annotations"}
+	}
+	sorted := make(map[string][]metaEntry, len(fields))
+	for _, field := range fields {
+		entries, _ := meta[field].(map[string]interface{})
+		sorted[field] = largestFirst(entries)
+	}
+	dropped := make(droppedKeys)
+	for len(data) > l.maxSize {
+		excess := len(data) - l.maxSize
+		for _, field := range fields {
+			entries, _ := meta[field].(map[string]interface{})
+			for excess > 0 && len(sorted[field]) > 0 {
+				entry := sorted[field][0]
+				sorted[field] = sorted[field][1:]
+				delete(entries, entry.key)
+				dropped[field] = append(dropped[field], entry.key)
+				excess -= entry.size
+			}
+		}
+		if data, err = enc.Marshal(meta); err != nil {
+			return nil, nil, err
+		}
+		if excess > 0 {
+			// All the entries have been dropped.
+			break
+		}
+	}
+	if len(dropped) == 0 {
+		return data, nil, nil
+	}
+	return data, dropped, nil
+}
+
+// objectMeta returns the given metadata without the dropped labels and annotations. The metadata are returned as
+// they are if no key has been dropped.
+func (d droppedKeys) objectMeta(meta *metav1.ObjectMeta) *metav1.ObjectMeta {
+	if len(d) == 0 {
+		return meta
+	}
+	truncated := meta.DeepCopy()
+	for _, key := range d["labels"] {
+		delete(truncated.Labels, key)
+	}
+	for _, key := range d["annotations"] {
+		delete(truncated.Annotations, key)
+	}
+	return truncated
+}
+
+// metaEntry is an entry of the labels or of the annotations, with the bytes it takes in the metadata in JSON form.
+type metaEntry struct {
+	key  string
+	size int
+}
+
+// largestFirst returns the entries sorted by size, the largest first, and by key. The size of an entry is the one of
+// its key and value in JSON form, without the separator from the next entry: the bytes saved dropping it are at least
+// as many.
+func largestFirst(entries map[string]interface{}) []metaEntry {
+	sorted := make([]metaEntry, 0, len(entries))
+	for key, value := range entries {
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		sorted = append(sorted, metaEntry{key: key, size: len(k) + 1 + len(v)})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].size != sorted[j].size {
+			return sorted[i].size > sorted[j].size
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	return sorted
+}
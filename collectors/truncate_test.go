@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMetaLimit(t *testing.T) {
+	newMeta := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":        "dpl",
+			"labels":      map[string]interface{}{"app": "web", "description": strings.Repeat("l", 100)},
+			"annotations": map[string]interface{}{"team": "a", "config": strings.Repeat("a", 200)},
+		}
+	}
+	full, err := json.Marshal(newMeta())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		limit   metaLimit
+		dropped droppedKeys
+	}{
+		{"no limit", metaLimit{}, nil},
+		{"under the limit", metaLimit{maxSize: len(full)}, nil},
+		{"largest annotation", metaLimit{maxSize: len(full) - 100, policy: TruncateAnnotations},
+			droppedKeys{"annotations": {"config"}}},
+		{"largest label", metaLimit{maxSize: len(full) - 100, policy: TruncateLabels},
+			droppedKeys{"labels": {"description"}}},
+		{"annotations then labels", metaLimit{maxSize: len(full) - 250, policy: TruncateAnnotations},
+			droppedKeys{"annotations": {"config", "team"}, "labels": {"description"}}},
+		{"still exceeding", metaLimit{maxSize: 1, policy: TruncateLabels},
+			droppedKeys{"labels": {"description", "app"}, "annotations": {"config", "team"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, dropped, err := tt.limit.marshal(nil, newMeta())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(dropped, tt.dropped) {
+				t.Errorf("expected dropped keys %v, got %v", tt.dropped, dropped)
+			}
+			if tt.dropped == nil && string(data) != string(full) {
+				t.Errorf("expected the metadata to be unchanged, got %s", data)
+			}
+			if tt.dropped != nil && tt.limit.maxSize > 1 && len(data) > tt.limit.maxSize {
+				t.Errorf("expected at most %d bytes, got %d", tt.limit.maxSize, len(data))
+			}
+		})
+	}
+
+	if _, err := ParseTruncationPolicy("largest"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+// countingEncoder counts the serializations of the metadata.
+type countingEncoder struct {
+	MetaEncoder
+	marshals int
+}
+
+func (e *countingEncoder) Marshal(meta map[string]interface{}) ([]byte, error) {
+	e.marshals++
+	return e.MetaEncoder.Marshal(meta)
+}
+
+func TestMetaLimitMarshals(t *testing.T) {
+	for _, enc := range []MetaEncoder{JSONEncoder, JSONIterEncoder, FlatEncoder} {
+		labels := make(map[string]interface{}, 1000)
+		for i := 0; i < 1000; i++ {
+			labels[fmt.Sprintf("label-%04d", i)] = strings.Repeat("v", i%50)
+		}
+		counting := &countingEncoder{MetaEncoder: enc}
+		data, dropped, err := metaLimit{maxSize: 4096}.marshal(counting, map[string]interface{}{"name": "dpl", "labels": labels})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", enc.Name(), err)
+		}
+		if len(data) > 4096 || len(dropped["labels"]) == 0 {
+			t.Errorf("%s: expected the labels to be truncated to 4096 bytes, got %d bytes", enc.Name(), len(data))
+		}
+		// The entries are not dropped one serialization at a time.
+		if counting.marshals > 3 {
+			t.Errorf("%s: expected at most 3 serializations, got %d", enc.Name(), counting.marshals)
+		}
+	}
+}
+
+func TestMaxMetaSize(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"app": "web", "description": strings.Repeat("l", 1000)}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"truncating-deployment-collector", WithMaxMetaSize(512, TruncateAnnotations))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+	truncations := defaultMetrics.metaTruncations.WithLabelValues(resource.Deployment)
+
+	reconcile := func(step string) {
+		t.Helper()
+		if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if len(queue.evts) != 1 {
+			t.Fatalf("%s: expected one event, got %v", step, queue.pop())
+		}
+	}
+
+	// The metadata exceeding the maximum size are sent without the largest label.
+	before := testutil.ToFloat64(truncations)
+	reconcile("over the limit")
+	msg := queue.evts[0].GRPCMessage()
+	queue.pop()
+	if !msg.GetMetaTruncated() || len(msg.GetMeta()) > 512 {
+		t.Errorf("expected the metadata to be truncated, got %d bytes, truncated %v", len(msg.GetMeta()),
+			msg.GetMetaTruncated())
+	}
+	if labels := msg.GetObjectMeta().GetLabels(); !reflect.DeepEqual(labels, map[string]string{"app": "web"}) {
+		t.Errorf("expected the structured metadata to be truncated too, got %v", labels)
+	}
+	if got := testutil.ToFloat64(truncations) - before; got != 1 {
+		t.Errorf("expected one truncation to be counted, got %v", got)
+	}
+
+	// The metadata under the limit are sent as they are.
+	dpl.Labels = map[string]string{"app": "web"}
+	if err := cl.Update(ctx, dpl); err != nil {
+		t.Fatalf("unable to update deployment: %v", err)
+	}
+	reconcile("under the limit")
+	if msg := queue.evts[0].GRPCMessage(); msg.GetMetaTruncated() {
+		t.Error("expected the metadata under the limit not to be flagged as truncated")
+	}
+}
+
+func TestMaxMetaSizeCachedAnnotations(t *testing.T) {
+	ctx := context.Background()
+	meta := metav1.ObjectMeta{Name: "dpl", Namespace: "default", UID: "dpl-uid",
+		Labels:      map[string]string{"app": "web", "description": strings.Repeat("l", 300)},
+		Annotations: map[string]string{"config": strings.Repeat("a", 1000), "team": "checkout"}}
+	// The deployment goes through the transform of the cache, keeping the allowed annotations.
+	transform := KeepAnnotationsTransformer(PartialObjectTransformer(logr.Discard()), []string{"config", "team"})
+	cached, err := transform(&metav1.PartialObjectMetadata{ObjectMeta: meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpl := &appsv1.Deployment{ObjectMeta: cached.(*metav1.PartialObjectMetadata).ObjectMeta}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(dpl, pod).Build()
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Deployment, nil),
+		"cached-annotations-deployment-collector", WithMaxMetaSize(512, TruncateAnnotations),
+		WithAllowedAnnotations("config", "team"))
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	if _, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dpl)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(queue.evts) != 1 {
+		t.Fatalf("expected one event, got %v", queue.pop())
+	}
+	// The largest annotation is dropped, the labels are left.
+	msg := queue.evts[0].GRPCMessage()
+	if !msg.GetMetaTruncated() || len(msg.GetMeta()) > 512 {
+		t.Errorf("expected the metadata to be truncated, got %d bytes, truncated %v", len(msg.GetMeta()),
+			msg.GetMetaTruncated())
+	}
+	if annotations := msg.GetObjectMeta().GetAnnotations(); !reflect.DeepEqual(annotations, map[string]string{"team": "checkout"}) {
+		t.Errorf("expected only the largest annotation to be dropped, got %v", annotations)
+	}
+	if labels := msg.GetObjectMeta().GetLabels(); len(labels) != 2 {
+		t.Errorf("expected the labels to be left, got %v", labels)
+	}
+}
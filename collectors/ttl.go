@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+)
+
+// refresher sends a Refresh event for each cached resource before the TTL of its metadata expires, so that the
+// subscribers keep the metadata of the resources that did not change and drop the ones whose events went missing.
+type refresher struct {
+	kind    string
+	cluster string
+	ttl     time.Duration
+	cache   *events.Cache
+	queue   broker.Queue
+	// pause skips the refreshes while the collector is paused, nil if it can't be.
+	pause *pause
+}
+
+// newRefresher returns the refresher of the resources of the given kind held in the cache, nil if the TTL is disabled.
+// The refreshes are skipped while the given pause is active.
+func newRefresher(kind, cluster string, ttl time.Duration, cache *events.Cache, queue broker.Queue, pause *pause) *refresher {
+	if ttl <= 0 {
+		return nil
+	}
+	return &refresher{kind: kind, cluster: cluster, ttl: ttl, cache: cache, queue: queue, pause: pause}
+}
+
+// period returns the period of the refreshes. The resources are refreshed twice within their TTL, so that a refresh
+// delayed by a busy queue does not make their metadata stale.
+func (r *refresher) period() time.Duration {
+	return r.ttl / 2
+}
+
+// refresh pushes to the queue the Refresh events of the cached resources, for the subscribers they have been sent
+// to. It returns the number of events pushed.
+func (r *refresher) refresh() int {
+	if broker.IsClosed(r.queue) || r.pause.active() {
+		return 0
+	}
+	items, _ := r.cache.List("", "", 0, nil)
+	refreshed := 0
+	for i := range items {
+		if len(items[i].Subscribers) == 0 {
+			continue
+		}
+		subs := make(fields.Subscribers, len(items[i].Subscribers))
+		for _, sub := range items[i].Subscribers {
+			subs.Add(sub)
+		}
+		// The queue has been closed in the meantime, the remaining events would be dropped too.
+		if err := r.queue.Push(events.NewRefresh(r.kind, string(items[i].UID), subs, r.ttl, r.cluster)); err != nil {
+			break
+		}
+		refreshed++
+	}
+	return refreshed
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCollectorsTTL(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "dpl-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "ns-uid"}}
+	f := testutil.NewFixture(ns, dpl, pod)
+	f.Subscribe("node-1")
+	opts := append(f.CollectorOptions(), collectors.WithTTL(time.Minute))
+
+	for _, tt := range []struct {
+		collector reconcile.Reconciler
+		obj       client.Object
+	}{
+		{
+			collector: collectors.NewObjectMetaCollector(f.Client, f.Broker, events.NewCache(),
+				collectors.NewPartialObjectMetadata(resource.Deployment, nil), "ttl-deployment-collector", opts...),
+			obj: dpl,
+		},
+		{collector: collectors.NewPodCollector(f.Client, f.Broker, events.NewCache(), "ttl-pod-collector", opts...), obj: pod},
+	} {
+		evts, _, err := f.Reconcile(ctx, tt.collector, tt.obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.ExpectEvents(evts, testutil.Expected{Type: events.Create, UID: string(tt.obj.GetUID())}); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := evts[0].GRPCMessage().GetTtlSeconds(); ttl != 60 {
+			t.Errorf("expected the TTL to be stamped on the event of %s, got %ds", tt.obj.GetName(), ttl)
+		}
+	}
+}
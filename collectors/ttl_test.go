@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker/brokertest"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNewRefresher(t *testing.T) {
+	if r := newRefresher(resource.Pod, "", 0, events.NewCache(), brokertest.NewBroker(), nil); r != nil {
+		t.Errorf("expected no refresher without TTL, got %+v", r)
+	}
+	if r := newRefresher(resource.Pod, "", time.Minute, events.NewCache(), brokertest.NewBroker(), nil); r.period() != 30*time.Second {
+		t.Errorf("expected the resources to be refreshed twice within the TTL, got a period of %s", r.period())
+	}
+}
+
+func TestDispatchRefresh(t *testing.T) {
+	// Only the resources sent to at least a subscriber are refreshed.
+	cache := events.NewCache()
+	cache.Add("default/sent", &events.CacheEntry{UID: "sent-uid", Subs: fields.Subscribers{"sub": struct{}{}}})
+	cache.Add("default/unsent", &events.CacheEntry{UID: "unsent-uid"})
+	queue := brokertest.NewBroker()
+	cl := fake.NewClientBuilder().WithIndex(&corev1.Pod{}, nodeNameIndex, podByNode).Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- dispatch(ctx, logr.Discard(), resource.Pod, make(subscriber.SubsChan), make(chan event.GenericEvent), cl,
+			subscriber.NewSubscribers(), cache, newReplays(), defaultMetrics.resyncs.WithLabelValues(resource.Pod), nil, 0, 0,
+			newRefresher(resource.Pod, "cluster", 20*time.Millisecond, cache, queue, nil), nil)
+	}()
+
+	evt, err := queue.WaitForEvent(resource.Pod, events.Refresh, "", 5*time.Second)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	msg := evt.GRPCMessage()
+	if msg.GetUid() != "sent-uid" || msg.GetTtlSeconds() != 1 || msg.GetCluster() != "cluster" || !evt.Subscribers().Has("sub") {
+		t.Errorf("unexpected refresh event %v for %v", msg, evt.Subscribers())
+	}
+	for _, evt := range queue.Events() {
+		if evt.GRPCMessage().GetUid() == "unsent-uid" {
+			t.Errorf("expected no refresh for the resource not sent to any subscriber")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+)
+
+// warmup is the grace period following the start of a collector, during which the subscribers that received a
+// resource are kept even when the reconcile no longer relates them to it. Right after the start, the caches could be
+// partially populated and a reconcile could compute an empty set of nodes: the Delete events that would follow are
+// deferred to the end of the grace period, when the resource is reconciled again. The deletions of the resources
+// are not affected.
+type warmup struct {
+	period time.Duration
+	// deadline is the end of the grace period, in Unix nanoseconds. It is zero until the caches have synced.
+	deadline atomic.Int64
+	// now returns the current time, overridden in the tests.
+	now func() time.Time
+}
+
+// newWarmup returns a warmup with the given grace period. A zero period disables it.
+func newWarmup(period time.Duration) *warmup {
+	return &warmup{period: period, now: time.Now}
+}
+
+// synced starts the grace period, once the caches of the collector have synced.
+func (w *warmup) synced() {
+	if w.period > 0 {
+		w.deadline.Store(w.now().Add(w.period).UnixNano())
+	}
+}
+
+// remaining returns the time left before the end of the grace period, and false if it is over or disabled. Until the
+// caches have synced, the whole period is left.
+func (w *warmup) remaining() (time.Duration, bool) {
+	if w.period <= 0 {
+		return 0, false
+	}
+	deadline := w.deadline.Load()
+	if deadline == 0 {
+		return w.period, true
+	}
+	left := time.Duration(deadline - w.now().UnixNano())
+	return left, left > 0
+}
+
+// retain returns the given subscribers extended with the previous ones missing from them, during the grace period,
+// and the delay after which the resource must be reconciled again. Otherwise it returns the subscribers as they are,
+// and a zero delay.
+func (w *warmup) retain(subs, previous fields.Subscribers) (fields.Subscribers, time.Duration) {
+	left, warming := w.remaining()
+	if !warming {
+		return subs, 0
+	}
+
+	var retained fields.Subscribers
+	for sub := range previous {
+		if _, ok := subs[sub]; ok {
+			continue
+		}
+		if retained == nil {
+			retained = make(fields.Subscribers, len(subs)+len(previous))
+			for s := range subs {
+				retained[s] = struct{}{}
+			}
+		}
+		retained[sub] = struct{}{}
+	}
+	if retained == nil {
+		return subs, 0
+	}
+	return retained, left
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWarmupRetain(t *testing.T) {
+	now := time.Unix(1000, 0)
+	w := newWarmup(time.Minute)
+	w.now = func() time.Time { return now }
+	previous := fields.Subscribers{"a": {}, "b": {}}
+
+	// Until the caches have synced the whole period is left.
+	if left, warming := w.remaining(); !warming || left != time.Minute {
+		t.Errorf("expected the whole period left before the sync, got %s %v", left, warming)
+	}
+	w.synced()
+	now = now.Add(20 * time.Second)
+	subs, requeue := w.retain(fields.Subscribers{"b": {}, "c": {}}, previous)
+	if want := (fields.Subscribers{"a": {}, "b": {}, "c": {}}); !reflect.DeepEqual(subs, want) {
+		t.Errorf("expected subscribers %v, got %v", want, subs)
+	}
+	if requeue != 40*time.Second {
+		t.Errorf("expected a requeue after the end of the warmup, got %s", requeue)
+	}
+	// Nothing to retain, nothing to requeue.
+	if subs, requeue = w.retain(previous, previous); !reflect.DeepEqual(subs, previous) || requeue != 0 {
+		t.Errorf("expected the subscribers as they are, got %v after %s", subs, requeue)
+	}
+
+	now = now.Add(time.Minute)
+	if subs, requeue = w.retain(nil, previous); subs != nil || requeue != 0 {
+		t.Errorf("expected no retained subscribers after the warmup, got %v after %s", subs, requeue)
+	}
+	if subs, requeue = newWarmup(0).retain(nil, previous); subs != nil || requeue != 0 {
+		t.Errorf("expected no retained subscribers when disabled, got %v after %s", subs, requeue)
+	}
+}
+
+func TestWarmupReconcile(t *testing.T) {
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "warmup", UID: "ns-uid"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "warmup", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(ns, pod).Build()
+
+	now := time.Unix(1000, 0)
+	queue := &recordingQueue{}
+	collector := NewObjectMetaCollector(cl, queue, events.NewCache(), NewPartialObjectMetadata(resource.Namespace, nil),
+		"namespace-collector", WithWarmup(time.Minute))
+	collector.warmup.now = func() time.Time { return now }
+	collector.warmup.synced()
+	collector.subscribers.AddSubscriberPerNode("node", "subscriber")
+
+	reconcile := func(step string, wantRequeue time.Duration, want ...string) {
+		t.Helper()
+		res, err := collector.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ns)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if res.RequeueAfter != wantRequeue {
+			t.Errorf("%s: expected requeue after %s, got %s", step, wantRequeue, res.RequeueAfter)
+		}
+		if got := queue.pop(); len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("%s: expected events %v, got %v", step, want, got)
+		}
+	}
+
+	reconcile("pod running", 0, events.Create)
+
+	// The pod is missing from the cache, still being populated: the namespace relates to no node anymore, but the
+	// subscriber keeps it until the end of the warmup.
+	if err := cl.Delete(ctx, pod); err != nil {
+		t.Fatalf("unable to delete pod: %v", err)
+	}
+	now = now.Add(10 * time.Second)
+	reconcile("during warmup", 50*time.Second)
+
+	now = now.Add(time.Minute)
+	reconcile("after warmup", 0, events.Delete)
+
+	// The actual deletion of the resource is propagated during the warmup.
+	pod.ResourceVersion = ""
+	if err := cl.Create(ctx, pod); err != nil {
+		t.Fatalf("unable to create pod: %v", err)
+	}
+	collector.warmup.synced()
+	reconcile("pod running again", 0, events.Create)
+	if err := cl.Delete(ctx, ns); err != nil {
+		t.Fatalf("unable to delete namespace: %v", err)
+	}
+	reconcile("namespace deleted", 0, events.Delete)
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMaxMessageSize is the default maximum size of the messages sent to the subscribers. It matches the default
+// maximum size of the messages received by the gRPC clients.
+const DefaultMaxMessageSize = 4 << 20
+
+// chunkOverhead is the room left in each chunk for the fields of the event other than the data of the chunk, and for
+// the framing of the message.
+const chunkOverhead = 1 << 10
+
+// ErrSplit is wrapped by the errors returned by Split.
+var ErrSplit = errors.New("unable to split the event")
+
+// Split returns the chunks of the event if its size exceeds maxSize, each of them not exceeding it. Events that fit
+// in a single message are returned as they are, as are all the events if maxSize is not positive.
+func Split(msg *Event, maxSize int) ([]*Event, error) {
+	if maxSize <= 0 || proto.Size(msg) <= maxSize {
+		return []*Event{msg}, nil
+	}
+	if maxSize <= chunkOverhead {
+		return nil, fmt.Errorf("%w: maximum message size %d is too small", ErrSplit, maxSize)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrSplit, msg.Uid, err)
+	}
+	chunkSize := maxSize - chunkOverhead
+	count := (len(data) + chunkSize - 1) / chunkSize
+	chunks := make([]*Event, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, &Event{
+			Reason: msg.Reason,
+			Uid:    msg.Uid,
+			Kind:   msg.Kind,
+			Chunk:  &Chunk{Index: uint32(i), Count: uint32(count), Data: data[i*chunkSize : end]},
+		})
+	}
+	return chunks, nil
+}
+
+// Reassembler reassembles on the subscriber side the events split in chunks. The chunks of an event are sent one
+// after the other on the stream, so a Reassembler is used for each stream.
+type Reassembler struct {
+	uid  string
+	next uint32
+	data []byte
+}
+
+// Add adds the received event. It returns the complete event once its last chunk is added, or the event itself if it
+// is not chunked. It returns nil while the chunks of an event are being received.
+func (r *Reassembler) Add(msg *Event) (*Event, error) {
+	chunk := msg.GetChunk()
+	if chunk == nil {
+		if r.data != nil {
+			return nil, fmt.Errorf("event %s received while reassembling the chunks of event %s", msg.Uid, r.uid)
+		}
+		return msg, nil
+	}
+
+	if chunk.Index == 0 && r.data == nil {
+		r.uid = msg.Uid
+	}
+	if msg.Uid != r.uid || chunk.Index != r.next || chunk.Index >= chunk.Count {
+		r.reset()
+		return nil, fmt.Errorf("unexpected chunk %d/%d of event %s", chunk.Index, chunk.Count, msg.Uid)
+	}
+	r.data = append(r.data, chunk.Data...)
+	r.next++
+	if r.next < chunk.Count {
+		return nil, nil
+	}
+
+	evt := &Event{}
+	err := proto.Unmarshal(r.data, evt)
+	r.reset()
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal the chunks of event %s: %w", msg.Uid, err)
+	}
+	return evt, nil
+}
+
+// reset drops the chunks received so far.
+func (r *Reassembler) reset() {
+	r.uid = ""
+	r.next = 0
+	r.data = nil
+}
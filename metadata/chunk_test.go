@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// chunkStream is a stream of a subscriber that records the sent messages.
+type chunkStream struct {
+	grpc.ServerStream
+	sent []*Event
+}
+
+func (s *chunkStream) Send(msg *Event) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestSendOversizedEvent(t *testing.T) {
+	// The labels of the resource alone exceed the default maximum message size.
+	meta := `{"labels":{"huge":"` + strings.Repeat("x", DefaultMaxMessageSize+DefaultMaxMessageSize/2) + `"}}`
+	msg := &Event{Reason: "Create", Uid: "uid", Kind: "ChunkTest", Meta: &meta, Cluster: "cluster"}
+	stream := &chunkStream{}
+	con := Connection{Stream: stream, maxMessageSize: DefaultMaxMessageSize}
+	// The events sent without node metrics are counted in the series without node.
+	t.Cleanup(func() { defaultServerMetrics.sentEvents.DeleteLabelValues("") })
+
+	if err := con.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) != 2 {
+		t.Fatalf("expected the event to be sent in 2 chunks, got %d", len(stream.sent))
+	}
+	for _, chunk := range stream.sent {
+		if size := proto.Size(chunk); size > DefaultMaxMessageSize {
+			t.Errorf("expected the chunks not to exceed the maximum message size, got %d bytes", size)
+		}
+	}
+	if got := testutil.ToFloat64(defaultServerMetrics.chunkedEvents.WithLabelValues("ChunkTest")); got != 1 {
+		t.Errorf("expected one chunked event, got %v", got)
+	}
+
+	var reassembler Reassembler
+	var reassembled *Event
+	for i, chunk := range stream.sent {
+		evt, err := reassembler.Add(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := i == len(stream.sent)-1; (evt != nil) != last {
+			t.Fatalf("expected the event to be returned only with the last chunk, got %v at chunk %d", evt != nil, i)
+		}
+		reassembled = evt
+	}
+	if !proto.Equal(reassembled, msg) {
+		t.Error("expected the reassembled event to match the sent one")
+	}
+
+	// The events fitting in a message are sent as they are.
+	small := &Event{Reason: "Update", Uid: "other", Kind: "ChunkTest"}
+	if err := con.Send(small); err != nil {
+		t.Fatal(err)
+	}
+	if evt, err := reassembler.Add(stream.sent[len(stream.sent)-1]); err != nil || evt != small {
+		t.Errorf("expected the event not to be chunked, got %v, %v", evt, err)
+	}
+}
+
+func TestReassemblerOutOfOrder(t *testing.T) {
+	meta := strings.Repeat("x", 3*chunkOverhead)
+	chunks, err := Split(&Event{Uid: "uid", Meta: &meta}, 2*chunkOverhead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("expected at least 3 chunks, got %d", len(chunks))
+	}
+
+	var reassembler Reassembler
+	if _, err := reassembler.Add(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reassembler.Add(chunks[2]); err == nil {
+		t.Error("expected an error for a missing chunk")
+	}
+	// After the error the reassembler starts over.
+	for _, chunk := range chunks {
+		if _, err := reassembler.Add(chunk); err != nil {
+			t.Fatalf("unexpected error after reset: %v", err)
+		}
+	}
+}
+
+func TestSplitError(t *testing.T) {
+	meta := strings.Repeat("x", 4*chunkOverhead)
+	if _, err := Split(&Event{Uid: "uid", Meta: &meta}, chunkOverhead); !errors.Is(err, ErrSplit) {
+		t.Errorf("expected ErrSplit for a maximum size too small, got %v", err)
+	}
+}
+
+func TestSendTenMegabyteAnnotation(t *testing.T) {
+	// The annotation is carried by both the JSON and the structured metadata, the event exceeds 20MB.
+	annotation := strings.Repeat("a", 10<<20)
+	meta := `{"annotations":{"huge":"` + annotation + `"}}`
+	msg := &Event{Reason: "Create", Uid: "uid", Kind: "ChunkTest", Meta: &meta,
+		ObjectMeta: &ObjectMeta{Annotations: map[string]string{"huge": annotation}}}
+	stream := &chunkStream{}
+	con := Connection{Stream: stream, maxMessageSize: DefaultMaxMessageSize}
+	t.Cleanup(func() { defaultServerMetrics.sentEvents.DeleteLabelValues("") })
+
+	if err := con.Send(msg); err != nil {
+		t.Fatalf("expected the event to be delivered in chunks, got %v", err)
+	}
+	if len(stream.sent) < 5 {
+		t.Fatalf("expected the event to be sent in at least 5 chunks, got %d", len(stream.sent))
+	}
+
+	var reassembler Reassembler
+	var reassembled *Event
+	for _, chunk := range stream.sent {
+		if size := proto.Size(chunk); size > DefaultMaxMessageSize {
+			t.Fatalf("expected the chunks not to exceed the maximum message size, got %d bytes", size)
+		}
+		evt, err := reassembler.Add(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reassembled = evt
+	}
+	if got := reassembled.GetObjectMeta().GetAnnotations()["huge"]; got != annotation {
+		t.Errorf("expected the annotation to be delivered whole, got %d bytes", len(got))
+	}
+	if !proto.Equal(reassembled, msg) {
+		t.Error("expected the reassembled event to match the sent one")
+	}
+}
@@ -20,7 +20,8 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Metadata_Watch_FullMethodName = "/metadata.Metadata/Watch"
+	Metadata_Watch_FullMethodName  = "/metadata.Metadata/Watch"
+	Metadata_Resync_FullMethodName = "/metadata.Metadata/Resync"
 )
 
 // MetadataClient is the client API for Metadata service.
@@ -29,6 +30,10 @@ const (
 type MetadataClient interface {
 	// Returns a stream of events for the resources that match the selector.
 	Watch(ctx context.Context, in *Selector, opts ...grpc.CallOption) (Metadata_WatchClient, error)
+	// Replays on its stream to the subscriber with the given UID the resources
+	// of its node, after a ResyncStart event and followed by a SnapshotComplete
+	// one. The UID is carried by the SnapshotComplete events.
+	Resync(ctx context.Context, in *ResyncRequest, opts ...grpc.CallOption) (*ResyncResponse, error)
 }
 
 type metadataClient struct {
@@ -71,12 +76,25 @@ func (x *metadataWatchClient) Recv() (*Event, error) {
 	return m, nil
 }
 
+func (c *metadataClient) Resync(ctx context.Context, in *ResyncRequest, opts ...grpc.CallOption) (*ResyncResponse, error) {
+	out := new(ResyncResponse)
+	err := c.cc.Invoke(ctx, Metadata_Resync_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetadataServer is the server API for Metadata service.
 // All implementations must embed UnimplementedMetadataServer
 // for forward compatibility
 type MetadataServer interface {
 	// Returns a stream of events for the resources that match the selector.
 	Watch(*Selector, Metadata_WatchServer) error
+	// Replays on its stream to the subscriber with the given UID the resources
+	// of its node, after a ResyncStart event and followed by a SnapshotComplete
+	// one. The UID is carried by the SnapshotComplete events.
+	Resync(context.Context, *ResyncRequest) (*ResyncResponse, error)
 	mustEmbedUnimplementedMetadataServer()
 }
 
@@ -87,6 +105,9 @@ type UnimplementedMetadataServer struct {
 func (UnimplementedMetadataServer) Watch(*Selector, Metadata_WatchServer) error {
 	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
 }
+func (UnimplementedMetadataServer) Resync(context.Context, *ResyncRequest) (*ResyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resync not implemented")
+}
 func (UnimplementedMetadataServer) mustEmbedUnimplementedMetadataServer() {}
 
 // UnsafeMetadataServer may be embedded to opt out of forward compatibility for this service.
@@ -121,13 +142,36 @@ func (x *metadataWatchServer) Send(m *Event) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Metadata_Resync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServer).Resync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metadata_Resync_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServer).Resync(ctx, req.(*ResyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metadata_ServiceDesc is the grpc.ServiceDesc for Metadata service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var Metadata_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "metadata.Metadata",
 	HandlerType: (*MetadataServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Resync",
+			Handler:    _Metadata_Resync_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Watch",
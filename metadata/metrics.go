@@ -16,7 +16,11 @@
 package metadata
 
 import (
+	"errors"
+
 	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/metricsutil"
+	"github.com/falcosecurity/k8s-metacollector/pkg/series"
 	"github.com/prometheus/client_golang/prometheus"
 	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -24,17 +28,172 @@ import (
 const (
 	serverSubsystem = "server"
 	subscribersKey  = "subscribers"
+	nodeSubsKey     = "node_subscribers"
+	backfillsKey    = "backfills"
+	sentEventsKey   = "subscriber_sent_events"
+	sendErrorsKey   = "subscriber_send_errors"
+	connectionsKey  = "connections"
+	disconnectsKey  = "disconnections"
+	chunkedKey      = "chunked_events"
+	takeoversKey    = "takeovers"
+	resyncsKey      = "resyncs"
 )
 
-var (
-	subscribers = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: consts.MetricsNamespace,
-		Subsystem: serverSubsystem,
-		Name:      subscribersKey,
-		Help:      "Number of subscribers.",
-	})
-)
+// serverMetrics holds the metrics of the grpc server. The server registers them in the controller-runtime registry by
+// default, or in the registry configured with WithMetricsRegisterer.
+type serverMetrics struct {
+	// subscribers is a prometheus gauge which holds the number of subscribers.
+	subscribers prometheus.Gauge
+	// nodeSubscribers is a prometheus gauge which holds the number of subscribers per node. The
+	// series of a node is deleted when its last subscriber leaves or the node is deleted.
+	nodeSubscribers *prometheus.GaugeVec
+	// backfills is a prometheus gauge which holds the number of backfills of new subscribers. The state label is
+	// either running or waiting, for the backfills waiting for a free slot.
+	backfills *prometheus.GaugeVec
+	// sentEvents and sendErrors are prometheus counter metrics which hold the total number of events sent to the
+	// subscribers, and of the failed sends, per node. The series of a node are deleted together with the ones of
+	// nodeSubscribers. The node label is empty when the per-node metrics are disabled.
+	sentEvents *prometheus.CounterVec
+	sendErrors *prometheus.CounterVec
+	// connections is a prometheus counter metrics which holds the total number of accepted subscriptions.
+	connections prometheus.Counter
+	// disconnections is a prometheus counter metrics which holds the total number of closed subscriptions. The reason
+	// label is either canceled, for the subscriptions closed by the subscribers, error, for the ones closed by the
+	// server, e.g. when a send fails or the node is deleted, or snapshot, for the SNAPSHOT subscriptions closed once
+	// their snapshot has been sent.
+	disconnections *prometheus.CounterVec
+	// takeovers is a prometheus counter metrics which holds the total number of subscriptions closed since a newer
+	// subscription for their node took over, when a single subscription per node is kept.
+	takeovers prometheus.Counter
+	// resyncs is a prometheus counter metrics which holds the total number of resyncs asked by the subscribers. The
+	// result label is either accepted or refused, for the ones asked too early or while a snapshot is in progress.
+	resyncs *prometheus.CounterVec
+	// chunkedEvents is a prometheus counter metrics which holds the total number of events split in chunks per
+	// resource kind, since they exceed the maximum size of the messages.
+	chunkedEvents *prometheus.CounterVec
+	// series deletes the series of the nodes gone, and of the subscribers, across the vectors above.
+	series *series.Registry
+}
+
+// newServerMetrics returns the metrics of the grpc server in the given namespace, not registered in any registry.
+func newServerMetrics(namespace string) *serverMetrics {
+	return &serverMetrics{
+		subscribers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      subscribersKey,
+			Help:      "Number of subscribers.",
+		}),
+		nodeSubscribers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      nodeSubsKey,
+			Help:      "Number of subscribers per node.",
+		}, []string{series.NodeLabel}),
+		backfills: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      backfillsKey,
+			Help:      "Number of backfills of new subscribers. The state label is either running or waiting.",
+		}, []string{"state"}),
+		sentEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      sentEventsKey,
+			Help:      "Total number of events sent to the subscribers per node.",
+		}, []string{series.NodeLabel}),
+		sendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      sendErrorsKey,
+			Help:      "Total number of events failed to be sent to the subscribers per node.",
+		}, []string{series.NodeLabel}),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      connectionsKey,
+			Help:      "Total number of accepted subscriptions.",
+		}),
+		disconnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      disconnectsKey,
+			Help:      "Total number of closed subscriptions. The reason label is either canceled, error or snapshot.",
+		}, []string{"reason"}),
+		takeovers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      takeoversKey,
+			Help:      "Total number of subscriptions closed since a newer subscription for their node took over.",
+		}),
+		resyncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      resyncsKey,
+			Help:      "Total number of resyncs asked by the subscribers. The result label is either accepted or refused.",
+		}, []string{"result"}),
+		chunkedEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      chunkedKey,
+			Help:      "Total number of events split in chunks since they exceed the maximum message size.",
+		}, []string{"kind"}),
+	}
+}
+
+// registerSeries registers the vectors labeled by node in the given series registry, and returns the metrics.
+func (m *serverMetrics) registerSeries(reg *series.Registry) *serverMetrics {
+	m.series = reg
+	reg.Register(series.NodeLabel, m.nodeSubscribers, m.sentEvents, m.sendErrors)
+	return m
+}
+
+// defaultServerMetrics are the metrics of the grpc server registered in the controller-runtime registry.
+var defaultServerMetrics = newServerMetrics(consts.MetricsNamespace).registerSeries(series.Default)
 
 func init() {
-	ctrlmetrics.Registry.MustRegister(subscribers)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.subscribers)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.nodeSubscribers)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.backfills)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.sentEvents)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.sendErrors)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.connections)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.disconnections)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.chunkedEvents)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.takeovers)
+	ctrlmetrics.Registry.MustRegister(defaultServerMetrics.resyncs)
+}
+
+// registerServerMetrics registers the metrics of the grpc server in the given registry, with the given prefix
+// prepended to their names, and returns them. If the registry already holds them, the registered ones are returned. A
+// nil registry, or the controller-runtime one, without prefix returns the default metrics.
+func registerServerMetrics(reg prometheus.Registerer, prefix string) (*serverMetrics, error) {
+	if reg == nil {
+		reg = ctrlmetrics.Registry
+	}
+	if reg == ctrlmetrics.Registry && prefix == "" {
+		return defaultServerMetrics, nil
+	}
+
+	var errs []error
+	m := newServerMetrics(metricsutil.Namespace(prefix))
+	registered := &serverMetrics{
+		subscribers:     metricsutil.RegisterOrGet(reg, m.subscribers, &errs),
+		nodeSubscribers: metricsutil.RegisterOrGet(reg, m.nodeSubscribers, &errs),
+		backfills:       metricsutil.RegisterOrGet(reg, m.backfills, &errs),
+		sentEvents:      metricsutil.RegisterOrGet(reg, m.sentEvents, &errs),
+		sendErrors:      metricsutil.RegisterOrGet(reg, m.sendErrors, &errs),
+		connections:     metricsutil.RegisterOrGet(reg, m.connections, &errs),
+		disconnections:  metricsutil.RegisterOrGet(reg, m.disconnections, &errs),
+		takeovers:       metricsutil.RegisterOrGet(reg, m.takeovers, &errs),
+		resyncs:         metricsutil.RegisterOrGet(reg, m.resyncs, &errs),
+		chunkedEvents:   metricsutil.RegisterOrGet(reg, m.chunkedEvents, &errs),
+	}
+	return registered.registerSeries(series.NewRegistry()), errors.Join(errs...)
+}
+
+// deleteNodeMetrics deletes the series of the node, across all the registered vectors, once it has no subscribers
+// left.
+func (m *serverMetrics) deleteNodeMetrics(node string) {
+	m.series.DeleteNode(node)
 }
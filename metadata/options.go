@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type serverOptions struct {
+	dryRun       bool
+	barrier      *health.Barrier
+	maxBackfills int
+	nodeMetrics  bool
+	// nodeTakeover keeps a single subscription per node, the newest one.
+	nodeTakeover bool
+	// maxMessageSize is the size above which the events are split in chunks.
+	maxMessageSize int
+	// snapshotComplete is called once the existing resources have been queued for a new subscriber.
+	snapshotComplete func(uid, node string)
+	// resyncStart is called before the existing resources are queued again for a subscriber asking for a resync.
+	resyncStart func(uid, node string)
+	// resyncInterval is the minimum interval between two resyncs of a subscriber.
+	resyncInterval time.Duration
+	// abort is closed when the server must stop waiting for the collectors.
+	abort <-chan struct{}
+	// metricsRegisterer is the registry of the metrics of the server, the controller-runtime one if nil.
+	metricsRegisterer prometheus.Registerer
+	// metricsPrefix is prepended to the names of the metrics of the server.
+	metricsPrefix string
+}
+
+// ServerOption function used to set options when creating a new Server instance.
+type ServerOption func(opt *serverOptions)
+
+// WithDryRun configures the Server to refuse all the subscriptions.
+func WithDryRun(dryRun bool) ServerOption {
+	return func(opt *serverOptions) {
+		opt.dryRun = dryRun
+	}
+}
+
+// WithBarrier configures the Server to refuse the subscriptions until the barrier is lifted.
+func WithBarrier(barrier *health.Barrier) ServerOption {
+	return func(opt *serverOptions) {
+		opt.barrier = barrier
+	}
+}
+
+// WithMaxBackfills configures the Server to run at most the given number of backfills at once. A backfill is the
+// dispatch of the existing resources to a new subscriber, and the subscribers arriving while all the backfills are
+// running wait for their turn. A non-positive value does not limit the backfills.
+func WithMaxBackfills(maxBackfills int) ServerOption {
+	return func(opt *serverOptions) {
+		opt.maxBackfills = maxBackfills
+	}
+}
+
+// WithNodeTakeover configures the Server to keep a single subscription per node. A new subscription for a node takes
+// over the stream of the previous ones, closed with the AlreadyExists code: when a subscriber restarts quickly, its
+// previous stream may not be detected as gone yet, and would keep receiving the events of the node.
+func WithNodeTakeover(enabled bool) ServerOption {
+	return func(opt *serverOptions) {
+		opt.nodeTakeover = enabled
+	}
+}
+
+// WithNodeMetrics configures the Server to label the metrics of the events sent to the subscribers with their node.
+// When disabled, the events sent to all the subscribers are counted together, bounding the cardinality of the metrics
+// in large clusters.
+func WithNodeMetrics(enabled bool) ServerOption {
+	return func(opt *serverOptions) {
+		opt.nodeMetrics = enabled
+	}
+}
+
+// WithSnapshotComplete configures the function called once the collectors have queued the existing resources for a
+// new subscriber, with the UID and the node of the subscriber. It is used to notify the subscriber that the snapshot
+// is complete and the following events are incremental.
+func WithSnapshotComplete(notify func(uid, node string)) ServerOption {
+	return func(opt *serverOptions) {
+		opt.snapshotComplete = notify
+	}
+}
+
+// WithResyncStart configures the function called before the collectors queue again the existing resources for a
+// subscriber asking for a resync, with the UID and the node of the subscriber. It is used to notify the subscriber that
+// the following events, up to the SnapshotComplete one, are the replayed resources.
+func WithResyncStart(notify func(uid, node string)) ServerOption {
+	return func(opt *serverOptions) {
+		opt.resyncStart = notify
+	}
+}
+
+// WithResyncInterval configures the minimum interval between two resyncs of a subscriber, the ones asked earlier are
+// refused. A non-positive value does not limit the resyncs.
+func WithResyncInterval(interval time.Duration) ServerOption {
+	return func(opt *serverOptions) {
+		opt.resyncInterval = interval
+	}
+}
+
+// WithMaxMessageSize configures the size above which the events sent to the subscribers are split in chunks, to stay
+// within the maximum size of the messages received by the subscribers. A non-positive value never splits the events.
+func WithMaxMessageSize(size int) ServerOption {
+	return func(opt *serverOptions) {
+		opt.maxMessageSize = size
+	}
+}
+
+// WithAbort configures the channel closed when the Server must stop waiting for the collectors to receive the
+// subscriptions, e.g. when they have stopped during the shutdown. Without it the Server waits for them forever.
+func WithAbort(abort <-chan struct{}) ServerOption {
+	return func(opt *serverOptions) {
+		opt.abort = abort
+	}
+}
+
+// WithMetricsRegisterer configures the registry where the Server registers its metrics. If not set, the
+// controller-runtime metrics registry is used.
+func WithMetricsRegisterer(reg prometheus.Registerer) ServerOption {
+	return func(opt *serverOptions) {
+		opt.metricsRegisterer = reg
+	}
+}
+
+// WithMetricsPrefix configures the prefix prepended to the names of the metrics of the Server. If not set, the metrics
+// keep their names.
+func WithMetricsPrefix(prefix string) ServerOption {
+	return func(opt *serverOptions) {
+		opt.metricsPrefix = prefix
+	}
+}
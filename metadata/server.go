@@ -16,13 +16,28 @@
 package metadata
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
 	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
 	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"k8s.io/apimachinery/pkg/util/uuid"
 )
 
+// notReadyRetryDelay is the delay suggested to the subscribers before retrying a subscription
+// refused because the initial sync has not completed yet.
+const notReadyRetryDelay = 5 * time.Second
+
 // Connection used to track a subscriber connection. Each time a subscriber arrives a
 // Connection is created and stored for later use by the Broker.
 type Connection struct {
@@ -30,14 +45,98 @@ type Connection struct {
 	once     *sync.Once
 	Stream   Metadata_WatchServer
 	Selector *Selector
+	// metricsNode is the node label of the metrics of the connection, empty if the per-node metrics are disabled.
+	metricsNode string
+	// metrics are the metrics of the server of the connection, the default ones if nil.
+	metrics *serverMetrics
+	// maxMessageSize is the size above which the events are split in chunks, not positive to never split them.
+	maxMessageSize int
+	// Connected is the time at which the subscriber connected.
+	Connected time.Time
+	// stats holds the statistics of the connection, shared by the copies of the connection.
+	stats *connectionStats
+	// snapshots tracks the snapshots queued for the subscriber, shared by the copies of the connection.
+	snapshots *snapshots
+}
+
+// snapshots tracks the snapshots of a connection: the initial one and the ones asked through the resyncs.
+type snapshots struct {
+	// inProgress is set while the existing resources are being queued for the subscriber.
+	inProgress atomic.Bool
+	// resyncs limits the rate of the resyncs of the subscriber.
+	resyncs *rate.Limiter
+}
+
+// connectionStats holds the statistics of a connection. They are updated by the broker while sending the events and
+// can be read concurrently.
+type connectionStats struct {
+	sent atomic.Uint64
+	// lastSent is the time, in nanoseconds since the epoch, of the last event sent.
+	lastSent atomic.Int64
+}
+
+// Send sends the message on the stream of the subscriber, counting the sent events and the failed sends. A message
+// exceeding the maximum message size is sent in chunks.
+func (c *Connection) Send(msg *Event) error {
+	metrics := c.serverMetrics()
+	chunks, err := Split(msg, c.maxMessageSize)
+	if err != nil {
+		metrics.sendErrors.WithLabelValues(c.metricsNode).Inc()
+		return err
+	}
+	if len(chunks) > 1 {
+		metrics.chunkedEvents.WithLabelValues(msg.Kind).Inc()
+	}
+	for _, chunk := range chunks {
+		if err := c.Stream.Send(chunk); err != nil {
+			metrics.sendErrors.WithLabelValues(c.metricsNode).Inc()
+			return err
+		}
+	}
+	metrics.sentEvents.WithLabelValues(c.metricsNode).Inc()
+	if c.stats != nil {
+		c.stats.sent.Add(1)
+		c.stats.lastSent.Store(time.Now().UnixNano())
+	}
+	return nil
+}
+
+// serverMetrics returns the metrics of the server of the connection, the default ones for the connections created
+// outside of a server.
+func (c *Connection) serverMetrics() *serverMetrics {
+	if c.metrics == nil {
+		return defaultServerMetrics
+	}
+	return c.metrics
+}
+
+// Sent returns the number of events sent to the subscriber and the time of the last one, zero if no event has been
+// sent yet.
+func (c *Connection) Sent() (uint64, time.Time) {
+	if c.stats == nil {
+		return 0, time.Time{}
+	}
+	var last time.Time
+	if nanos := c.stats.lastSent.Load(); nanos != 0 {
+		last = time.Unix(0, nanos)
+	}
+	return c.stats.sent.Load(), last
 }
 
 // Close closes the connection. It makes sure that the close is done only once to avoid
 // deadlocks.
 func (c *Connection) Close(err error) {
+	c.close(err)
+}
+
+// close closes the connection, returning false if it was already closed.
+func (c *Connection) close(err error) bool {
+	closed := false
 	c.once.Do(func() {
 		c.error <- err
+		closed = true
 	})
+	return closed
 }
 
 // Server grpc server started by the broker that listens for new connections from subscribers.
@@ -48,16 +147,46 @@ type Server struct {
 	logger        logr.Logger
 	collectors    map[string]subscriber.SubsChan
 	connectionsWg *sync.WaitGroup
+	opt           serverOptions
+	// nodes tracks the subscribers per node.
+	nodes      map[string]fields.Subscribers
+	nodesMutex sync.Mutex
+	// backfillSlots limits the number of concurrent backfills, nil if they are not limited.
+	backfillSlots chan struct{}
+	// takeoverMutex serializes the subscriptions taking over the streams of their node, so that two subscriptions
+	// racing for the same node never both survive.
+	takeoverMutex sync.Mutex
+	// metrics are the metrics of the server, registered in the registry of the options.
+	metrics *serverMetrics
 }
 
-// New returns a new Server.
-func New(logger logr.Logger, subs *sync.Map, collectors map[string]subscriber.SubsChan, group *sync.WaitGroup) *Server {
+// New returns a new Server. An error is returned if its metrics cannot be registered in the configured registry.
+func New(logger logr.Logger, subs *sync.Map, collectors map[string]subscriber.SubsChan, group *sync.WaitGroup,
+	opt ...ServerOption) (*Server, error) {
+	opts := serverOptions{}
+	for _, o := range opt {
+		o(&opts)
+	}
+	metrics, err := registerServerMetrics(opts.metricsRegisterer, opts.metricsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to register the metrics: %w", err)
+	}
+
+	var backfillSlots chan struct{}
+	if opts.maxBackfills > 0 {
+		backfillSlots = make(chan struct{}, opts.maxBackfills)
+	}
+
 	return &Server{
 		subscribers:   subs,
 		logger:        logger,
 		collectors:    collectors,
 		connectionsWg: group,
-	}
+		opt:           opts,
+		nodes:         make(map[string]fields.Subscribers),
+		backfillSlots: backfillSlots,
+		metrics:       metrics,
+	}, nil
 }
 
 // Watch accepts a Selector and returns a stream of metadata to the client. On each watch it creates a Connection
@@ -66,32 +195,71 @@ func New(logger logr.Logger, subs *sync.Map, collectors map[string]subscriber.Su
 func (s *Server) Watch(selector *Selector, stream Metadata_WatchServer) error {
 	var err error
 	var connection Connection
+
+	if err = s.Admit(selector.NodeName); err != nil {
+		return err
+	}
+
+	// A subscriber that does not filter the resource kinds receives the events of all the collectors. Only the
+	// collectors of the selected kinds are notified of the subscriber, the events of the other kinds never list it.
+	if len(selector.ResourceKinds) == 0 {
+		selector.ResourceKinds = make(map[string]string, len(s.collectors))
+		for kind := range s.collectors {
+			selector.ResourceKinds[kind] = kind
+		}
+	}
+
 	// For each new subscriber we generate an UID.
 	UID := string(uuid.NewUUID())
 	s.logger.Info("received watch request", "node", selector.NodeName, "subscriber UID", UID)
+
+	// When many subscribers arrive at once, e.g. after a rollout of the subscribers, only a limited number of
+	// backfills run at once and the others wait for their turn.
+	release, err := s.startBackfill(stream)
+	if err != nil {
+		s.logger.Info("watch request canceled while waiting for backfill", "node", selector.NodeName, "subscriber UID", UID)
+		return err
+	}
 	errorChan := make(chan error, 1)
 
 	connection = Connection{
-		error:    errorChan,
-		Stream:   stream,
-		Selector: selector,
-		once:     &sync.Once{},
+		error:          errorChan,
+		Stream:         stream,
+		Selector:       selector,
+		once:           &sync.Once{},
+		Connected:      time.Now(),
+		stats:          &connectionStats{},
+		maxMessageSize: s.opt.maxMessageSize,
+		snapshots:      newSnapshots(s.opt.resyncInterval),
+		metrics:        s.metrics,
+	}
+	if s.opt.nodeMetrics {
+		connection.metricsNode = selector.NodeName
 	}
 
 	msg := subscriber.Message{
 		NodeName: selector.NodeName,
 		UID:      UID,
 		Reason:   subscriber.Subscribed,
+		// The collectors replay the existing resources, unless the subscriber only wants their changes.
+		WatchOnly: selector.GetMode() == Mode_WATCH_ONLY,
 	}
 
-	s.subscribers.Store(UID, connection)
-	subscribers.Inc()
+	s.store(UID, connection)
+	s.metrics.subscribers.Inc()
+	s.metrics.connections.Inc()
+	s.nodeSubscribed(selector.NodeName, UID)
 	s.logger.Info("starting initial event sync", "node", selector.NodeName, "subscriber UID", UID)
-	for resource := range selector.ResourceKinds {
-		if collector, ok := s.collectors[resource]; ok {
-			collector <- msg
-		}
-	}
+	collectors := s.selected(selector)
+	// Once all the collectors have queued the existing resources, the backfill ends and the subscriber is notified
+	// that the following events are incremental.
+	connection.snapshots.inProgress.Store(true)
+	msg.Done = whenDispatched(len(collectors), func() {
+		release()
+		s.completeSnapshot(UID, connection)
+	})
+	s.notify(collectors, msg)
+	msg.Done = nil
 
 	// Add the connection to waiting group.
 	s.connectionsWg.Add(1)
@@ -101,19 +269,298 @@ func (s *Server) Watch(selector *Selector, stream Metadata_WatchServer) error {
 	select {
 	case <-stream.Context().Done():
 		s.logger.Info("context canceled, closing connection", "subscriber", selector.NodeName)
+		s.metrics.disconnections.WithLabelValues("canceled").Inc()
 	case err = <-errorChan:
+		if err == nil {
+			// The snapshot of a SNAPSHOT subscription has been sent, the stream is closed cleanly.
+			s.logger.Info("snapshot sent, closing connection", "subscriber", selector.NodeName)
+			s.metrics.disconnections.WithLabelValues("snapshot").Inc()
+			break
+		}
 		s.logger.Error(err, "closing connection", "subscriber", selector.NodeName)
+		s.metrics.disconnections.WithLabelValues("error").Inc()
 	}
 
 	// Unsubscribe from all the collectors.
 	s.subscribers.Delete(UID)
 	msg.Reason = subscriber.Unsubscribed
+	s.notify(collectors, msg)
+	s.logger.Info("stream deleted", "subscriber", selector.NodeName)
+	s.metrics.subscribers.Dec()
+	s.nodeUnsubscribed(selector.NodeName, UID)
+	// The UID of a subscriber is never reused, so its series are gone for good.
+	s.metrics.series.DeleteSubscriber(UID)
+	return err
+}
+
+// Resync queues again the resources related to the node of the subscriber with the given UID, after a ResyncStart
+// event and followed by a SnapshotComplete one, as for a new subscriber. The other subscribers are not affected. The
+// caller must name the node of the subscriber: the subscribers of the other nodes are reported as not found. The
+// resyncs asked while a snapshot is in progress for the subscriber, or earlier than the minimum interval since the
+// previous one, are refused with a ResourceExhausted error. The interval is enforced per connection, the resyncs of a
+// subscriber do not delay the ones of the others.
+func (s *Server) Resync(_ context.Context, req *ResyncRequest) (*ResyncResponse, error) {
+	uid, node := req.GetUid(), req.GetNodeName()
+	if node == "" {
+		return nil, status.Error(codes.InvalidArgument, "the node name of the subscriber is required")
+	}
+	// The subscriber must be one of the node, so that a caller cannot resync the subscribers of the other nodes.
+	s.nodesMutex.Lock()
+	subscribed := s.nodes[node].Has(uid)
+	s.nodesMutex.Unlock()
+	c, ok := s.subscribers.Load(uid)
+	if !subscribed || !ok {
+		return nil, status.Errorf(codes.NotFound, "no subscriber with UID %q for node %q", uid, node)
+	}
+	con, ok := c.(Connection)
+	if !ok || con.snapshots == nil || con.Selector.GetNodeName() != node {
+		return nil, status.Errorf(codes.NotFound, "no subscriber with UID %q for node %q", uid, node)
+	}
+
+	// The snapshots of a subscriber never overlap, so that the end of one is not taken for the end of the other.
+	if !con.snapshots.inProgress.CompareAndSwap(false, true) {
+		s.metrics.resyncs.WithLabelValues("refused").Inc()
+		return nil, status.Error(codes.ResourceExhausted, "a snapshot is already in progress for the subscriber")
+	}
+	if reservation := con.snapshots.resyncs.Reserve(); reservation.Delay() > 0 {
+		delay := reservation.Delay()
+		reservation.Cancel()
+		con.snapshots.inProgress.Store(false)
+		s.metrics.resyncs.WithLabelValues("refused").Inc()
+		return nil, retryError(codes.ResourceExhausted, fmt.Sprintf("too many resyncs, retry in %s", delay), delay)
+	}
+	s.metrics.resyncs.WithLabelValues("accepted").Inc()
+
+	s.logger.Info("resyncing subscriber", "node", node, "subscriber UID", uid)
+	if s.opt.resyncStart != nil {
+		s.opt.resyncStart(uid, node)
+	}
+	collectors := s.selected(con.Selector)
+	s.notify(collectors, subscriber.Message{
+		NodeName: node,
+		UID:      uid,
+		Reason:   subscriber.Resynced,
+		Done:     whenDispatched(len(collectors), func() { s.completeSnapshot(uid, con) }),
+	})
+	return &ResyncResponse{}, nil
+}
+
+// selected returns the channels of the collectors of the resource kinds selected by the subscriber.
+func (s *Server) selected(selector *Selector) []subscriber.SubsChan {
+	var collectors []subscriber.SubsChan
 	for resource := range selector.ResourceKinds {
 		if collector, ok := s.collectors[resource]; ok {
-			collector <- msg
+			collectors = append(collectors, collector)
 		}
 	}
-	s.logger.Info("stream deleted", "subscriber", selector.NodeName)
-	subscribers.Dec()
-	return err
+	return collectors
+}
+
+// completeSnapshot notifies the subscriber with the given UID that the snapshot in progress is complete.
+func (s *Server) completeSnapshot(uid string, con Connection) {
+	if s.opt.snapshotComplete != nil {
+		s.opt.snapshotComplete(uid, con.Selector.GetNodeName())
+	}
+	con.snapshots.inProgress.Store(false)
+}
+
+// newSnapshots returns the snapshots of a new connection, allowing a resync every given interval, or always if it is
+// not positive.
+func newSnapshots(resyncInterval time.Duration) *snapshots {
+	limit := rate.Inf
+	if resyncInterval > 0 {
+		limit = rate.Every(resyncInterval)
+	}
+	return &snapshots{resyncs: rate.NewLimiter(limit, 1)}
+}
+
+// store stores the connection of the subscriber with the given UID. When a single subscription per node is kept, the
+// connections of the previous subscribers for its node are closed first: their Watch unsubscribes them from the
+// collectors and their pending events, e.g. in their throttles, are discarded.
+func (s *Server) store(uid string, connection Connection) {
+	if !s.opt.nodeTakeover {
+		s.subscribers.Store(uid, connection)
+		return
+	}
+
+	node := connection.Selector.GetNodeName()
+	s.takeoverMutex.Lock()
+	defer s.takeoverMutex.Unlock()
+	s.subscribers.Range(func(key, value any) bool {
+		con, ok := value.(Connection)
+		if !ok || con.Selector.GetNodeName() != node {
+			return true
+		}
+		if con.close(status.Errorf(codes.AlreadyExists, "a newer subscription for node %q took over the stream", node)) {
+			s.logger.Info("subscription taken over by a newer one", "node", node, "subscriber UID", key,
+				"new subscriber UID", uid)
+			s.metrics.takeovers.Inc()
+		}
+		return true
+	})
+	s.subscribers.Store(uid, connection)
+}
+
+// notify sends the message to the given collectors. It gives up once the server is aborted, since the collectors may
+// have stopped reading the messages: the collectors not notified are marked as dispatched.
+func (s *Server) notify(collectors []subscriber.SubsChan, msg subscriber.Message) {
+	for i, collector := range collectors {
+		select {
+		case collector <- msg:
+		case <-s.opt.abort:
+			s.logger.Info("server aborted, giving up on notifying the collectors", "node", msg.NodeName,
+				"subscriber UID", msg.UID)
+			for range collectors[i:] {
+				msg.Dispatched()
+			}
+			return
+		}
+	}
+}
+
+// Admit returns an error if a subscription for the given node would be refused, because the metacollector runs in
+// dry-run mode or has not completed its initial sync.
+func (s *Server) Admit(node string) error {
+	// In dry-run mode the events are never dispatched, so we refuse the subscription.
+	if s.opt.dryRun {
+		s.logger.Info("refusing watch request, running in dry-run mode", "node", node)
+		return status.Error(codes.FailedPrecondition, "the metacollector is running in dry-run mode, subscriptions are disabled")
+	}
+
+	// Until the collectors have completed their initial sync we refuse the subscription, otherwise the
+	// subscriber would receive a partial view of the cluster.
+	if s.opt.barrier != nil && !s.opt.barrier.Ready() {
+		pending := s.opt.barrier.Pending()
+		s.logger.Info("refusing watch request, initial sync not completed", "node", node, "pending", pending)
+		return notReadyError(pending)
+	}
+	return nil
+}
+
+// startBackfill waits for a free backfill slot, if the backfills are limited. It returns the function releasing the
+// slot. An error is returned if the stream is closed while waiting.
+func (s *Server) startBackfill(stream Metadata_WatchServer) (func(), error) {
+	if s.backfillSlots == nil {
+		return func() {}, nil
+	}
+
+	s.metrics.backfills.WithLabelValues("waiting").Inc()
+	select {
+	case s.backfillSlots <- struct{}{}:
+		s.metrics.backfills.WithLabelValues("waiting").Dec()
+	case <-stream.Context().Done():
+		s.metrics.backfills.WithLabelValues("waiting").Dec()
+		return nil, status.FromContextError(stream.Context().Err()).Err()
+	}
+	s.metrics.backfills.WithLabelValues("running").Inc()
+
+	return func() {
+		s.metrics.backfills.WithLabelValues("running").Dec()
+		<-s.backfillSlots
+	}, nil
+}
+
+// whenDispatched returns the callback to be invoked by each of the given number of collectors once they have
+// dispatched the existing resources to a subscriber. The done function is called when all of them are done.
+func whenDispatched(collectors int, done func()) func() {
+	if collectors == 0 {
+		done()
+		return nil
+	}
+	var pending sync.WaitGroup
+	pending.Add(collectors)
+	go func() {
+		pending.Wait()
+		done()
+	}()
+	return pending.Done
+}
+
+// NodeSubscribers returns the UIDs of the subscribers connected for the given node.
+func (s *Server) NodeSubscribers(node string) fields.Subscribers {
+	subs := make(fields.Subscribers)
+	s.subscribers.Range(func(key, value any) bool {
+		if con, ok := value.(Connection); ok && con.Selector.GetNodeName() == node {
+			subs.Add(key.(string))
+		}
+		return true
+	})
+	return subs
+}
+
+// DisconnectNode closes the connections of all the subscribers for the given node and deletes the metrics
+// related to the node. It returns the UIDs of the disconnected subscribers.
+func (s *Server) DisconnectNode(node string) fields.Subscribers {
+	var subs fields.Subscribers
+	s.subscribers.Range(func(key, value any) bool {
+		con, ok := value.(Connection)
+		if !ok || con.Selector.GetNodeName() != node {
+			return true
+		}
+		if subs == nil {
+			subs = make(fields.Subscribers)
+		}
+		subs.Add(key.(string))
+		con.Close(status.Errorf(codes.Aborted, "node %q has been deleted", node))
+		return true
+	})
+
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	delete(s.nodes, node)
+	s.metrics.deleteNodeMetrics(node)
+
+	return subs
+}
+
+// nodeSubscribed tracks a new subscriber for the given node.
+func (s *Server) nodeSubscribed(node, uid string) {
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	subs, ok := s.nodes[node]
+	if !ok {
+		subs = make(fields.Subscribers)
+		s.nodes[node] = subs
+	}
+	subs.Add(uid)
+	s.metrics.nodeSubscribers.WithLabelValues(node).Set(float64(len(subs)))
+}
+
+// nodeUnsubscribed tracks a subscriber leaving the given node. The metric for the node is deleted when
+// the last subscriber leaves. Subscribers already disconnected by DisconnectNode are ignored, but the metrics of
+// the node are deleted again if it has no subscribers, since the events sent in the meantime may have recreated them.
+func (s *Server) nodeUnsubscribed(node, uid string) {
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	subs, ok := s.nodes[node]
+	if !ok {
+		s.metrics.deleteNodeMetrics(node)
+		return
+	}
+	if !subs.Has(uid) {
+		return
+	}
+	subs.Delete(uid)
+	if len(subs) == 0 {
+		delete(s.nodes, node)
+		s.metrics.deleteNodeMetrics(node)
+		return
+	}
+	s.metrics.nodeSubscribers.WithLabelValues(node).Set(float64(len(subs)))
+}
+
+// notReadyError returns an Unavailable error carrying a hint on when the subscriber should retry.
+func notReadyError(pending []string) error {
+	return retryError(codes.Unavailable, fmt.Sprintf("the metacollector is not ready, waiting for initial sync of: %s",
+		strings.Join(pending, ", ")), notReadyRetryDelay)
+}
+
+// retryError returns an error with the given code and message, carrying a hint on when the subscriber should retry.
+func retryError(code codes.Code, msg string, delay time.Duration) error {
+	st := status.New(code, msg)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
 }
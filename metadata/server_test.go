@@ -0,0 +1,695 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/falcosecurity/k8s-metacollector/pkg/series"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func TestWatchRefusedUntilInitialSync(t *testing.T) {
+	barrier := health.NewBarrier()
+	barrier.Register("pod-collector")
+
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{}, &sync.WaitGroup{}, WithBarrier(barrier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = srv.Watch(&Selector{NodeName: "node"}, nil)
+
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("expected a grpc status error, got %v", err)
+	}
+	if st.Code() != codes.Unavailable {
+		t.Errorf("expected code %s, got %s", codes.Unavailable, st.Code())
+	}
+
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if ri, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = ri
+		}
+	}
+	if retryInfo == nil {
+		t.Fatal("expected the error to carry retry info")
+	}
+	if got := retryInfo.GetRetryDelay().AsDuration(); got != notReadyRetryDelay {
+		t.Errorf("expected retry delay %s, got %s", notReadyRetryDelay, got)
+	}
+}
+
+func TestWatchRefusedInDryRun(t *testing.T) {
+	subs := &sync.Map{}
+	pods := make(subscriber.SubsChan, 1)
+	srv, err := New(logr.Discard(), subs, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{}, WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Admit("node"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the subscription to be refused with %s, got %v", codes.FailedPrecondition, err)
+	}
+	if err := srv.Watch(&Selector{NodeName: "node"}, nil); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the watch to be refused with %s, got %v", codes.FailedPrecondition, err)
+	}
+	// Neither the subscribers nor the collectors know about the refused subscriber.
+	subs.Range(func(key, _ any) bool {
+		t.Errorf("unexpected subscriber %v", key)
+		return true
+	})
+	select {
+	case msg := <-pods:
+		t.Errorf("unexpected message sent to the collectors: %+v", msg)
+	default:
+	}
+}
+
+func TestDisconnectNode(t *testing.T) {
+	subs := &sync.Map{}
+	srv, err := New(logr.Discard(), subs, map[string]subscriber.SubsChan{}, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connections := map[string]Connection{}
+	for uid, node := range map[string]string{"dead-1": "dead", "dead-2": "dead", "alive-1": "alive"} {
+		con := Connection{
+			error:    make(chan error, 1),
+			once:     &sync.Once{},
+			Selector: &Selector{NodeName: node},
+		}
+		connections[uid] = con
+		subs.Store(uid, con)
+		srv.nodeSubscribed(node, uid)
+	}
+	if got := testutil.CollectAndCount(defaultServerMetrics.nodeSubscribers); got != 2 {
+		t.Fatalf("expected metrics for 2 nodes, got %d", got)
+	}
+	defaultServerMetrics.sentEvents.WithLabelValues("dead").Inc()
+	defaultServerMetrics.sendErrors.WithLabelValues("dead").Inc()
+
+	if got := srv.NodeSubscribers("dead"); len(got) != 2 || !got.Has("dead-1") || !got.Has("dead-2") {
+		t.Errorf("expected the subscribers of the dead node, got %v", got)
+	}
+
+	disconnected := srv.DisconnectNode("dead")
+	if len(disconnected) != 2 || !disconnected.Has("dead-1") || !disconnected.Has("dead-2") {
+		t.Errorf("expected the subscribers of the dead node to be disconnected, got %v", disconnected)
+	}
+	if metrics := gatheredWithNode(t, "dead"); len(metrics) != 0 {
+		t.Errorf("expected no series for the dead node, got %v", metrics)
+	}
+	for uid, con := range connections {
+		select {
+		case err := <-con.error:
+			if status.Code(err) != codes.Aborted || uid == "alive-1" {
+				t.Errorf("unexpected close of subscriber %s: %v", uid, err)
+			}
+		default:
+			if uid != "alive-1" {
+				t.Errorf("expected subscriber %s to be closed", uid)
+			}
+		}
+	}
+
+	// The connections leaving afterwards must not recreate the metrics of the dead node.
+	srv.nodeUnsubscribed("dead", "dead-1")
+	if got := testutil.ToFloat64(defaultServerMetrics.nodeSubscribers.WithLabelValues("alive")); got != 1 {
+		t.Errorf("expected 1 subscriber for the alive node, got %v", got)
+	}
+	if got := testutil.CollectAndCount(defaultServerMetrics.nodeSubscribers); got != 1 {
+		t.Errorf("expected metrics only for the alive node, got %d series", got)
+	}
+}
+
+// watchStream is a stream of a subscriber that discards the events.
+type watchStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(*Event) error {
+	return nil
+}
+
+func TestMaxBackfills(t *testing.T) {
+	pods := make(subscriber.SubsChan, 10)
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+		WithMaxBackfills(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := func(node string) *Selector {
+		return &Selector{NodeName: node, ResourceKinds: map[string]string{"Pod": ""}}
+	}
+	watch := func(node string) (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- srv.Watch(selector(node), &watchStream{ctx: ctx}) }()
+		return cancel, done
+	}
+	receive := func(step string) subscriber.Message {
+		t.Helper()
+		select {
+		case msg := <-pods:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected a message for the collector", step)
+		}
+		return subscriber.Message{}
+	}
+
+	cancelFirst, firstDone := watch("first")
+	first := receive("first backfill")
+
+	// The second subscriber waits for the backfill of the first one.
+	cancelSecond, secondDone := watch("second")
+	select {
+	case msg := <-pods:
+		t.Fatalf("expected the second backfill to wait, got %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := testutil.ToFloat64(defaultServerMetrics.backfills.WithLabelValues("waiting")); got != 1 {
+		t.Errorf("expected 1 waiting backfill, got %v", got)
+	}
+
+	// A subscriber leaving while waiting does not take a slot.
+	cancelThird, thirdDone := watch("third")
+	time.Sleep(50 * time.Millisecond)
+	cancelThird()
+	if err := <-thirdDone; status.Code(err) != codes.Canceled {
+		t.Errorf("expected the waiting subscriber to be canceled, got %v", err)
+	}
+
+	first.Dispatched()
+	if second := receive("second backfill"); second.NodeName != "second" {
+		t.Errorf("expected the backfill of the second subscriber, got %v", second)
+	}
+
+	cancelFirst()
+	<-firstDone
+	cancelSecond()
+	<-secondDone
+}
+
+func TestSubscriberMetrics(t *testing.T) {
+	for _, nodeMetrics := range []bool{true, false} {
+		pods := make(subscriber.SubsChan, 1)
+		subs := &sync.Map{}
+		srv, err := New(logr.Discard(), subs, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+			WithNodeMetrics(nodeMetrics))
+		if err != nil {
+			t.Fatal(err)
+		}
+		label := ""
+		if nodeMetrics {
+			label = "node"
+		}
+		accepted := testutil.ToFloat64(defaultServerMetrics.connections)
+		canceled := testutil.ToFloat64(defaultServerMetrics.disconnections.WithLabelValues("canceled"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- srv.Watch(&Selector{NodeName: "node", ResourceKinds: map[string]string{"Pod": ""}}, &watchStream{ctx: ctx})
+		}()
+		msg := <-pods
+		c, _ := subs.Load(msg.UID)
+		con := c.(Connection)
+		for i := 0; i < 3; i++ {
+			if err := con.Send(&Event{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if got := testutil.ToFloat64(defaultServerMetrics.sentEvents.WithLabelValues(label)); got != 3 {
+			t.Errorf("expected 3 events sent with node label %q, got %v", label, got)
+		}
+		if got := testutil.ToFloat64(defaultServerMetrics.connections) - accepted; got != 1 {
+			t.Errorf("expected 1 connection, got %v", got)
+		}
+
+		// The series of the node are deleted when its last subscriber leaves.
+		cancel()
+		<-done
+		if got := testutil.ToFloat64(defaultServerMetrics.disconnections.WithLabelValues("canceled")) - canceled; got != 1 {
+			t.Errorf("expected 1 canceled disconnection, got %v", got)
+		}
+		if got := testutil.CollectAndCount(defaultServerMetrics.sentEvents); nodeMetrics && got != 0 {
+			t.Errorf("expected the series of the node to be deleted, got %d series", got)
+		}
+		defaultServerMetrics.sentEvents.Reset()
+	}
+}
+
+func TestSnapshotComplete(t *testing.T) {
+	pods := make(subscriber.SubsChan, 1)
+	services := make(subscriber.SubsChan, 1)
+	completed := make(chan string, 1)
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{"Pod": pods, "Service": services},
+		&sync.WaitGroup{}, WithSnapshotComplete(func(_, node string) { completed <- node }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Watch(&Selector{NodeName: "node", ResourceKinds: map[string]string{"Pod": "", "Service": ""}},
+			&watchStream{ctx: ctx})
+	}()
+
+	// The snapshot is complete once all the collectors have dispatched the existing resources.
+	pod, svc := <-pods, <-services
+	pod.Dispatched()
+	select {
+	case <-completed:
+		t.Fatal("expected the snapshot to wait for all the collectors")
+	case <-time.After(50 * time.Millisecond):
+	}
+	svc.Dispatched()
+	select {
+	case node := <-completed:
+		if node != "node" {
+			t.Errorf("expected the snapshot of node %q, got %q", "node", node)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the snapshot to complete")
+	}
+
+	cancel()
+	<-pods
+	<-services
+	<-done
+}
+
+func TestResync(t *testing.T) {
+	pods := make(subscriber.SubsChan, 1)
+	started := make(chan string, 1)
+	completed := make(chan string, 1)
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+		WithResyncInterval(time.Hour),
+		WithResyncStart(func(uid, _ string) { started <- uid }),
+		WithSnapshotComplete(func(uid, _ string) { completed <- uid }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, refused := testutil.ToFloat64(defaultServerMetrics.resyncs.WithLabelValues("accepted")),
+		testutil.ToFloat64(defaultServerMetrics.resyncs.WithLabelValues("refused"))
+
+	if _, err := srv.Resync(context.Background(), &ResyncRequest{Uid: "unknown", NodeName: "node"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected code %s for an unknown subscriber, got %v", codes.NotFound, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Watch(&Selector{NodeName: "node", ResourceKinds: map[string]string{"Pod": ""}}, &watchStream{ctx: ctx})
+	}()
+	sub := <-pods
+	uid := sub.UID
+
+	// The caller must name the node of the subscriber.
+	if _, err := srv.Resync(context.Background(), &ResyncRequest{Uid: uid}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected code %s without the node name, got %v", codes.InvalidArgument, err)
+	}
+	if _, err := srv.Resync(context.Background(), &ResyncRequest{Uid: uid, NodeName: "other"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected code %s for the subscriber of another node, got %v", codes.NotFound, err)
+	}
+
+	// The resyncs asked during the snapshot are refused.
+	if _, err := srv.Resync(context.Background(), &ResyncRequest{Uid: uid, NodeName: "node"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected code %s during the snapshot, got %v", codes.ResourceExhausted, err)
+	}
+	sub.Dispatched()
+	<-completed
+
+	// The resources are dispatched again between the ResyncStart and the SnapshotComplete events.
+	if _, err := srv.Resync(context.Background(), &ResyncRequest{Uid: uid, NodeName: "node"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := <-started; got != uid {
+		t.Errorf("expected the resync of subscriber %q, got %q", uid, got)
+	}
+	msg := <-pods
+	if msg.Reason != subscriber.Resynced || msg.UID != uid || msg.NodeName != "node" {
+		t.Errorf("unexpected message for the collector: %v", msg)
+	}
+	select {
+	case <-completed:
+		t.Fatal("expected the resync to wait for the collectors")
+	case <-time.After(50 * time.Millisecond):
+	}
+	msg.Dispatched()
+	if got := <-completed; got != uid {
+		t.Errorf("expected the snapshot of subscriber %q, got %q", uid, got)
+	}
+
+	// The resyncs asked earlier than the interval are refused with a hint on when to retry.
+	_, err = srv.Resync(context.Background(), &ResyncRequest{Uid: uid, NodeName: "node"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected code %s, got %v", codes.ResourceExhausted, err)
+	}
+	var retry *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = info
+		}
+	}
+	if retry == nil || retry.GetRetryDelay().AsDuration() <= 0 {
+		t.Errorf("expected a retry hint, got %v", status.Convert(err).Details())
+	}
+	select {
+	case msg := <-pods:
+		t.Errorf("expected no message for a refused resync, got %v", msg)
+	default:
+	}
+
+	if got := testutil.ToFloat64(defaultServerMetrics.resyncs.WithLabelValues("accepted")) - accepted; got != 1 {
+		t.Errorf("expected 1 accepted resync, got %v", got)
+	}
+	if got := testutil.ToFloat64(defaultServerMetrics.resyncs.WithLabelValues("refused")) - refused; got != 2 {
+		t.Errorf("expected 2 refused resyncs, got %v", got)
+	}
+
+	cancel()
+	<-pods
+	<-done
+}
+
+func TestResyncPerConnection(t *testing.T) {
+	pods := make(subscriber.SubsChan, 1)
+	completed := make(chan string, 1)
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+		WithResyncInterval(time.Hour), WithSnapshotComplete(func(uid, _ string) { completed <- uid }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	uids := make(map[string]string)
+	for _, node := range []string{"node-1", "node-2"} {
+		go func(node string) {
+			done <- srv.Watch(&Selector{NodeName: node, ResourceKinds: map[string]string{"Pod": ""}}, &watchStream{ctx: ctx})
+		}(node)
+		sub := <-pods
+		uids[node] = sub.UID
+		sub.Dispatched()
+		<-completed
+	}
+
+	resync := func(uid, node string) error {
+		_, err := srv.Resync(context.Background(), &ResyncRequest{Uid: uid, NodeName: node})
+		if err == nil {
+			msg := <-pods
+			msg.Dispatched()
+			<-completed
+		}
+		return err
+	}
+	// The subscribers of a node cannot be resynced in the name of another one.
+	if err := resync(uids["node-1"], "node-2"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected code %s for the subscriber of another node, got %v", codes.NotFound, err)
+	}
+	// Each connection has its own interval between the resyncs.
+	if err := resync(uids["node-1"], "node-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := resync(uids["node-2"], "node-2"); err != nil {
+		t.Errorf("expected the resync of the other connection to be accepted, got %v", err)
+	}
+	if err := resync(uids["node-1"], "node-1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected code %s for the second resync of the connection, got %v", codes.ResourceExhausted, err)
+	}
+
+	cancel()
+	<-pods
+	<-pods
+	<-done
+	<-done
+}
+
+func TestNodeTakeover(t *testing.T) {
+	pods := make(subscriber.SubsChan, 10)
+	subs := &sync.Map{}
+	completed := make(chan string, 10)
+	srv, err := New(logr.Discard(), subs, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+		WithNodeTakeover(true), WithSnapshotComplete(func(uid, _ string) { completed <- uid }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := func(node string) (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- srv.Watch(&Selector{NodeName: node, ResourceKinds: map[string]string{"Pod": ""}}, &watchStream{ctx: ctx})
+		}()
+		return cancel, done
+	}
+	// receive returns the next message for the collector, of a subscriber joining if subscribed or else leaving.
+	receive := func(step string, subscribed bool) subscriber.Message {
+		t.Helper()
+		select {
+		case msg := <-pods:
+			if (msg.Reason == subscriber.Subscribed) != subscribed {
+				t.Fatalf("%s: unexpected message %v", step, msg)
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: expected a message for the collector", step)
+		}
+		return subscriber.Message{}
+	}
+	nodeSubscribers := func(node string) []string {
+		var uids []string
+		subs.Range(func(key, value any) bool {
+			if value.(Connection).Selector.GetNodeName() == node {
+				uids = append(uids, key.(string))
+			}
+			return true
+		})
+		return uids
+	}
+	taken := testutil.ToFloat64(defaultServerMetrics.takeovers)
+
+	// The stream of the previous instance of the subscriber is still considered alive.
+	cancelOld, oldDone := watch("node")
+	defer cancelOld()
+	old := receive("old subscription", true)
+	old.Dispatched()
+	<-completed
+	cancelOther, otherDone := watch("other")
+	receive("other subscription", true).Dispatched()
+	<-completed
+
+	// The new subscription closes the old stream, which is unsubscribed from the collectors, and gets the snapshot.
+	cancelNew, newDone := watch("node")
+	renewed := receive("new subscription", true)
+	select {
+	case err := <-oldDone:
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected the old stream to be closed with code %s, got %v", codes.AlreadyExists, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the old stream to be closed")
+	}
+	if left := receive("old stream closed", false); left.UID != old.UID {
+		t.Errorf("expected the old subscriber to leave, got %v", left)
+	}
+	renewed.Dispatched()
+	if uid := <-completed; uid != renewed.UID {
+		t.Errorf("expected the snapshot of the new subscriber, got %q", uid)
+	}
+	if uids := nodeSubscribers("node"); len(uids) != 1 || uids[0] != renewed.UID {
+		t.Errorf("expected only the new subscriber for the node, got %v", uids)
+	}
+	if uids := nodeSubscribers("other"); len(uids) != 1 {
+		t.Errorf("expected the subscriber of the other node to be left untouched, got %v", uids)
+	}
+	if got := testutil.ToFloat64(defaultServerMetrics.takeovers) - taken; got != 1 {
+		t.Errorf("expected 1 takeover, got %v", got)
+	}
+	cancelNew()
+	<-newDone
+	receive("new stream closed", false)
+
+	// Among the subscriptions racing for a node, only the last one survives.
+	const racing = 5
+	go func() {
+		for msg := range pods {
+			msg.Dispatched()
+		}
+	}()
+	taken = testutil.ToFloat64(defaultServerMetrics.takeovers)
+	dones := make(chan error, racing)
+	var cancels []context.CancelFunc
+	for i := 0; i < racing; i++ {
+		cancel, done := watch("node")
+		cancels = append(cancels, cancel)
+		go func() { dones <- <-done }()
+	}
+	for i := 0; i < racing-1; i++ {
+		select {
+		case err := <-dones:
+			if status.Code(err) != codes.AlreadyExists {
+				t.Errorf("expected the racing stream to be taken over, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the racing streams to be taken over")
+		}
+	}
+	if uids := nodeSubscribers("node"); len(uids) != 1 {
+		t.Errorf("expected a single subscriber for the node, got %v", uids)
+	}
+	if got := testutil.ToFloat64(defaultServerMetrics.takeovers) - taken; got != racing-1 {
+		t.Errorf("expected %d takeovers, got %v", racing-1, got)
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	<-dones
+	cancelOther()
+	<-otherDone
+}
+
+func TestWatchAbort(t *testing.T) {
+	// The collectors have stopped, nobody receives the subscriptions.
+	pods := make(subscriber.SubsChan)
+	abort := make(chan struct{})
+	completed := make(chan struct{})
+	srv, err := New(logr.Discard(), &sync.Map{}, map[string]subscriber.SubsChan{"Pod": pods}, &sync.WaitGroup{},
+		WithAbort(abort), WithSnapshotComplete(func(_, _ string) { close(completed) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Watch(&Selector{NodeName: "node"}, &watchStream{ctx: ctx})
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the watch to wait for the collectors until aborted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once aborted, the collectors not notified count as dispatched and the watch returns.
+	close(abort)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watch to return once aborted")
+	}
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the snapshot to complete once aborted")
+	}
+}
+
+func TestWatchResourceKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		kinds    map[string]string
+		expected []string
+	}{
+		{name: "subset", kinds: map[string]string{"Pod": "Pod"}, expected: []string{"Pod"}},
+		{name: "no filter", expected: []string{"Pod", "Service"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collectors := map[string]subscriber.SubsChan{"Pod": make(subscriber.SubsChan, 1),
+				"Service": make(subscriber.SubsChan, 1)}
+			srv, err := New(logr.Discard(), &sync.Map{}, collectors, &sync.WaitGroup{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			selector := &Selector{NodeName: "node", ResourceKinds: tt.kinds}
+			done := make(chan error, 1)
+			go func() { done <- srv.Watch(selector, &watchStream{ctx: ctx}) }()
+
+			// Only the collectors of the requested kinds are notified of the subscriber.
+			for _, kind := range tt.expected {
+				select {
+				case msg := <-collectors[kind]:
+					msg.Dispatched()
+				case <-time.After(time.Second):
+					t.Fatalf("expected the %s collector to be notified of the subscriber", kind)
+				}
+			}
+			for kind, collector := range collectors {
+				select {
+				case <-collector:
+					t.Errorf("expected the %s collector not to be notified of the subscriber", kind)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			if len(selector.ResourceKinds) != len(tt.expected) {
+				t.Errorf("expected the selector to watch %v, got %v", tt.expected, selector.ResourceKinds)
+			}
+
+			cancel()
+			for _, kind := range tt.expected {
+				<-collectors[kind]
+			}
+			<-done
+		})
+	}
+}
+
+// gatheredWithNode returns the names of the metrics gathered from the controller-runtime registry with a series for
+// the given node.
+func gatheredWithNode(t *testing.T, node string) []string {
+	t.Helper()
+	families, err := ctrlmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == series.NodeLabel && label.GetValue() == node {
+					names = append(names, family.GetName())
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"os"
+)
+
+// backupSuffix is appended to the path of the file to name its backup after a rotation.
+const backupSuffix = ".1"
+
+// RotatingFile is a file rotated once it reaches a maximum size: the file is renamed with the ".1" suffix, replacing
+// the previous backup, and a new file is created. The rotation happens between two writes, so each JSON line written
+// by the sink is entirely in one of the files. It is not safe for concurrent use.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens for appending the file at the given path, creating it if needed. The file is rotated once
+// it exceeds maxSize bytes, never if maxSize is not positive.
+func OpenRotatingFile(path string, maxSize int64) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize}
+	if err := f.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file with the given additional flag and reads its current size.
+func (f *RotatingFile) open(flag int) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|flag, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file %q: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to stat audit file %q: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would make it exceed the maximum size.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file to its backup and creates a new one.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("unable to close audit file %q: %w", f.path, err)
+	}
+	if err := os.Rename(f.path, f.path+backupSuffix); err != nil {
+		// The file is reopened as it is, the rotation is tried again at the next write.
+		if openErr := f.open(os.O_APPEND); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("unable to rotate audit file %q: %w", f.path, err)
+	}
+	return f.open(os.O_TRUNC)
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	auditSubsystem = "audit"
+	recordsKey     = "records"
+	droppedKey     = "dropped_records"
+	// labelBufferFull is the reason of the records dropped since the buffer of the sink is full.
+	labelBufferFull = "buffer_full"
+	// labelWriteError is the reason of the records dropped since they could not be written.
+	labelWriteError = "write_error"
+)
+
+var (
+	// records is a prometheus counter metrics which holds the total number of audit records written.
+	records = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: consts.MetricsNamespace,
+		Subsystem: auditSubsystem,
+		Name:      recordsKey,
+		Help:      "Total number of audit records written.",
+	})
+
+	// dropped is a prometheus counter metrics which holds the total number of audit records dropped. The reason label
+	// is either buffer_full, when the writer does not keep up with the events, or write_error.
+	dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: consts.MetricsNamespace,
+		Subsystem: auditSubsystem,
+		Name:      droppedKey,
+		Help:      "Total number of audit records dropped. The reason label is either buffer_full or write_error.",
+	}, []string{"reason"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(records)
+	ctrlmetrics.Registry.MustRegister(dropped)
+}
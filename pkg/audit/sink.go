@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Record is the audit record of an event sent to the subscribers, written as a JSON line.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Collector string    `json:"collector,omitempty"`
+	Type      string    `json:"type"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	UID       string    `json:"uid"`
+	// Nodes are the nodes of the subscribers the event has been sent to.
+	Nodes []string `json:"nodes"`
+}
+
+// Sink writes the audit records asynchronously. The records are buffered, and dropped when the buffer is full, so
+// that recording an event never blocks the caller.
+type Sink struct {
+	logger  logr.Logger
+	w       io.Writer
+	records chan Record
+}
+
+// NewSink returns a Sink writing the records to w, buffering at most bufferSize records.
+func NewSink(logger logr.Logger, w io.Writer, bufferSize int) *Sink {
+	return &Sink{
+		logger:  logger,
+		w:       w,
+		records: make(chan Record, bufferSize),
+	}
+}
+
+// Record queues the record to be written. The record is dropped if the buffer is full.
+func (s *Sink) Record(r Record) {
+	select {
+	case s.records <- r:
+	default:
+		dropped.WithLabelValues(labelBufferFull).Inc()
+	}
+}
+
+// Start writes the queued records until the context is canceled, then writes the records left in the buffer. It
+// implements the manager.Runnable interface.
+func (s *Sink) Start(ctx context.Context) error {
+	encoder := json.NewEncoder(s.w)
+	write := func(r *Record) {
+		if err := encoder.Encode(r); err != nil {
+			dropped.WithLabelValues(labelWriteError).Inc()
+			s.logger.Error(err, "unable to write audit record", "kind", r.Kind, "uid", r.UID)
+			return
+		}
+		records.Inc()
+	}
+
+	for {
+		select {
+		case r := <-s.records:
+			write(&r)
+		case <-ctx.Done():
+			for {
+				select {
+				case r := <-s.records:
+					write(&r)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false, each replica records the events it sends.
+func (s *Sink) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSinkBoundedBuffer(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewSink(logr.Discard(), &out, 1)
+	droppedBefore := testutil.ToFloat64(dropped.WithLabelValues(labelBufferFull))
+
+	// The sink is not writing, so only the first record fits in the buffer and the others are dropped.
+	for _, uid := range []string{"uid-1", "uid-2", "uid-3"} {
+		sink.Record(Record{Type: "Create", Kind: "Pod", UID: uid, Nodes: []string{"node-1"}})
+	}
+	if got := testutil.ToFloat64(dropped.WithLabelValues(labelBufferFull)) - droppedBefore; got != 2 {
+		t.Errorf("expected 2 dropped records, got %v", got)
+	}
+
+	// The records left in the buffer are written when the sink stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sink.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var record Record
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", out.String(), err)
+	}
+	if record.UID != "uid-1" || record.Nodes[0] != "node-1" {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenRotatingFile(path, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The third line would exceed the maximum size, so the file is rotated before writing it.
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read(path + backupSuffix); got != "first line\nsecond line\n" {
+		t.Errorf("unexpected backup %q", got)
+	}
+	if got := read(path); got != "third line\n" {
+		t.Errorf("unexpected file %q", got)
+	}
+
+	// A reopened file is appended to, and keeps counting its size.
+	f, err = OpenRotatingFile(path, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("fourth line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("fifth line\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := read(path); !strings.HasPrefix(got, "fifth") || read(path+backupSuffix) != "third line\nfourth line\n" {
+		t.Errorf("unexpected files after reopening: %q and %q", got, read(path+backupSuffix))
+	}
+}
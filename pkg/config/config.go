@@ -0,0 +1,410 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// dependencies maps each collector to the collectors it relies on. The collectors for the pods' owners and
+// namespaces are triggered by the pod collector each time a pod is created or deleted, and the service collector
+// shares the endpoints dispatchers with the pod collector.
+var dependencies = map[string][]string{
+	resource.Pod:                   nil,
+	resource.Deployment:            {resource.Pod},
+	resource.ReplicaSet:            {resource.Pod},
+	resource.Daemonset:             {resource.Pod},
+	resource.ReplicationController: {resource.Pod},
+	resource.Namespace:             {resource.Pod},
+	resource.Service:               {resource.Pod},
+}
+
+// Config is the configuration of the meta collector.
+type Config struct {
+	// Collectors holds the configuration for each collector. The key is the kind of the resource handled by the
+	// collector, e.g. Pod, Deployment, Service.
+	Collectors map[string]CollectorConfig `json:"collectors,omitempty"`
+	// Tracing holds the configuration of the OpenTelemetry tracing. Tracing is disabled by default.
+	Tracing TracingConfig `json:"tracing,omitempty"`
+	// Broker holds the settings of the broker. The flags set on the command line override them.
+	Broker BrokerConfig `json:"broker,omitempty"`
+	// LogVerbosity is the verbosity of the logs, e.g. 2 to log the events dispatched to the new subscribers. The
+	// --zap-log-level flag overrides it. Defaults to 0, only the info logs.
+	LogVerbosity *int `json:"logVerbosity,omitempty"`
+	// BroadcastNamespaces are the namespaces whose resources are sent to all the nodes of the cluster, instead of the
+	// nodes running the pods related to them, e.g. a shared-services namespace holding global configuration objects.
+	// The pods keep being sent to the subscribers of their node only.
+	BroadcastNamespaces []string `json:"broadcastNamespaces,omitempty"`
+}
+
+// CollectorConfig is the configuration of a single collector.
+type CollectorConfig struct {
+	// Enabled when set to false the collector is not instantiated and no watches are registered for its resource.
+	// Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// StatusFields are the fields of the status of the resources projected in the status of the events, e.g.
+	// readyReplicas for the deployments. They are dot separated paths relative to the status. When set, the full
+	// objects are watched instead of their metadata only. Not supported by the Pod and Service collectors.
+	StatusFields []string `json:"statusFields,omitempty"`
+	// LabelSelector restricts the collection to the resources whose labels match it, e.g. "app in (web, api)". The
+	// resources that stop matching it are deleted from the subscribers.
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// AnnotationSelector restricts the collection to the resources whose annotations match it, using the syntax of
+	// the label selectors. The annotations it references are kept in the cache and sent in the metadata.
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+	// ExcludedNames are the glob patterns of the names of the resources excluded from the collection, with the syntax
+	// of path.Match, e.g. "*-canary". The patterns holding a slash are matched against the namespace-qualified name,
+	// e.g. "monitoring/prometheus-*". The resources newly excluded are deleted from the subscribers.
+	ExcludedNames []string `json:"excludedNames,omitempty"`
+	// APIVersion is the group version of the resources of a kind not supported out of the box, e.g.
+	// "cert-manager.io/v1" for the Certificate collector. Their metadata are sent to the subscribers of the nodes
+	// running pods in their namespace. It must not be set for the supported collectors.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// RateLimiter tunes the retries of the failed reconciles of the collector. The unset settings keep the
+	// controller-runtime defaults.
+	RateLimiter *RateLimiterConfig `json:"rateLimiter,omitempty"`
+}
+
+// RateLimiterConfig is the configuration of the rate limiter of the retries of a collector. Each failing resource is
+// retried with an exponential backoff, and all the retries are limited by a token bucket.
+type RateLimiterConfig struct {
+	// BaseDelay is the backoff of the first retry of a resource, e.g. "5ms".
+	BaseDelay *metav1.Duration `json:"baseDelay,omitempty"`
+	// MaxDelay is the maximum backoff of the retries of a resource, e.g. "1000s".
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+	// QPS is the number of retries per second allowed by the token bucket.
+	QPS *float64 `json:"qps,omitempty"`
+	// Burst is the size of the token bucket.
+	Burst *int `json:"burst,omitempty"`
+}
+
+// BrokerConfig is the configuration of the broker. The unset settings keep the defaults of the flags.
+type BrokerConfig struct {
+	// Address the broker endpoint binds to, e.g. ":45000".
+	Address string `json:"address,omitempty"`
+	// NodeRate is the maximum number of events per second sent to each subscriber. Zero disables the throttling.
+	NodeRate *float64 `json:"nodeRate,omitempty"`
+	// NodeBurst is the maximum number of events sent in a burst to each subscriber when the throttling is enabled.
+	NodeBurst *int `json:"nodeBurst,omitempty"`
+	// MaxDeleteDelay is the maximum delay of the delete events when the throttling is enabled, e.g. "1s".
+	MaxDeleteDelay *metav1.Duration `json:"maxDeleteDelay,omitempty"`
+	// MaxConcurrentBackfills is the maximum number of new subscribers whose existing resources are dispatched at
+	// once. Zero does not limit them.
+	MaxConcurrentBackfills *int `json:"maxConcurrentBackfills,omitempty"`
+	// MaxMessageSize is the size in bytes above which the events are split in chunks.
+	MaxMessageSize *int `json:"maxMessageSize,omitempty"`
+}
+
+const (
+	// TracingExporterNone disables the tracing.
+	TracingExporterNone = "none"
+	// TracingExporterOTLP exports the spans to an OpenTelemetry collector using the OTLP protocol over gRPC.
+	TracingExporterOTLP = "otlp"
+)
+
+// TracingConfig is the configuration of the OpenTelemetry tracing.
+type TracingConfig struct {
+	// Exporter used to export the spans, one of "none" and "otlp". Defaults to "none".
+	Exporter string `json:"exporter,omitempty"`
+	// Endpoint is the address of the OTLP receiver, e.g. "otel-collector:4317". If empty, the OpenTelemetry
+	// defaults and environment variables apply.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Insecure disables the transport security of the connection to the OTLP receiver.
+	Insecure bool `json:"insecure,omitempty"`
+	// SamplingRatio is the fraction of the traces that are sampled, between 0 and 1. Defaults to 1.
+	SamplingRatio *float64 `json:"samplingRatio,omitempty"`
+}
+
+// Enabled returns true if an exporter has been configured for the spans.
+func (t *TracingConfig) Enabled() bool {
+	return t.Exporter != "" && t.Exporter != TracingExporterNone
+}
+
+// Ratio returns the sampling ratio of the traces.
+func (t *TracingConfig) Ratio() float64 {
+	if t.SamplingRatio == nil {
+		return 1
+	}
+	return *t.SamplingRatio
+}
+
+// Default returns the default configuration, where all the collectors are enabled.
+func Default() *Config {
+	return &Config{
+		Collectors: make(map[string]CollectorConfig),
+	}
+}
+
+// Load reads the configuration from the given YAML file. The settings not present in the file keep their default.
+// It also returns the paths of the keys of the file that are not known settings, e.g. "collectors.Deployment.enabeld",
+// to warn about them since the misspelled settings are otherwise silently ignored.
+func Load(path string) (*Config, []string, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path) //nolint:gosec //Path is provided by the user.
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read configuration file %q: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse configuration file %q: %w", path, err)
+	}
+
+	if cfg.Collectors == nil {
+		cfg.Collectors = make(map[string]CollectorConfig)
+	}
+
+	unknown, err := unknownKeys(data, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse configuration file %q: %w", path, err)
+	}
+
+	return cfg, unknown, nil
+}
+
+// IsEnabled returns true if the collector for the given resource kind is enabled.
+func (c *Config) IsEnabled(kind string) bool {
+	col, ok := c.Collectors[kind]
+	if !ok || col.Enabled == nil {
+		return true
+	}
+	return *col.Enabled
+}
+
+// StatusFields returns the status fields projected in the events of the collector for the given resource kind.
+func (c *Config) StatusFields(kind string) []string {
+	return c.Collectors[kind].StatusFields
+}
+
+// LabelSelector returns the label selector of the collector for the given resource kind, nil if not set. The
+// configuration must have been validated.
+func (c *Config) LabelSelector(kind string) labels.Selector {
+	selector, _ := parseSelector(c.Collectors[kind].LabelSelector)
+	return selector
+}
+
+// AnnotationSelector returns the annotation selector of the collector for the given resource kind, nil if not set.
+// The configuration must have been validated.
+func (c *Config) AnnotationSelector(kind string) labels.Selector {
+	selector, _ := parseSelector(c.Collectors[kind].AnnotationSelector)
+	return selector
+}
+
+// ExcludedNames returns the patterns of the names of the resources excluded by the collector for the given resource
+// kind.
+func (c *Config) ExcludedNames(kind string) []string {
+	return c.Collectors[kind].ExcludedNames
+}
+
+// AnnotationKeys returns the sorted keys of the annotations referenced by the annotation selector of the collector
+// for the given resource kind. They have to be kept in the cache for the selector to match them.
+func (c *Config) AnnotationKeys(kind string) []string {
+	selector := c.AnnotationSelector(kind)
+	if selector == nil {
+		return nil
+	}
+	requirements, _ := selector.Requirements()
+	keys := make([]string, 0, len(requirements))
+	for _, req := range requirements {
+		keys = append(keys, req.Key())
+	}
+	sort.Strings(keys)
+	return slices.Compact(keys)
+}
+
+// ExtraResources returns the group version kinds of the enabled collectors for the kinds not supported out of the
+// box, sorted by kind. The configuration must have been validated.
+func (c *Config) ExtraResources() []schema.GroupVersionKind {
+	var gvks []schema.GroupVersionKind
+	for kind, col := range c.Collectors {
+		if _, ok := dependencies[kind]; ok || !c.IsEnabled(kind) {
+			continue
+		}
+		gv, _ := schema.ParseGroupVersion(col.APIVersion)
+		gvks = append(gvks, gv.WithKind(kind))
+	}
+	sort.Slice(gvks, func(i, j int) bool { return gvks[i].Kind < gvks[j].Kind })
+	return gvks
+}
+
+// parseSelector parses the selector, returning nil for an empty one.
+func parseSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	return labels.Parse(selector)
+}
+
+// Validate checks that the configured collectors exist and that the collectors they depend on are enabled. It also
+// checks the tracing, broker and log settings, and the broadcast namespaces.
+func (c *Config) Validate() error {
+	for kind, col := range c.Collectors {
+		if _, ok := dependencies[kind]; !ok {
+			if err := validateExtraKind(kind, col); err != nil {
+				return err
+			}
+		} else if col.APIVersion != "" {
+			return fmt.Errorf("collector %q is supported out of the box, its apiVersion must not be set", kind)
+		}
+		if _, err := parseSelector(col.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label selector for collector %q: %w", kind, err)
+		}
+		if _, err := parseSelector(col.AnnotationSelector); err != nil {
+			return fmt.Errorf("invalid annotation selector for collector %q: %w", kind, err)
+		}
+		for _, pattern := range col.ExcludedNames {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return fmt.Errorf("invalid excluded name pattern %q for collector %q", pattern, kind)
+			}
+		}
+		if err := col.RateLimiter.validate(); err != nil {
+			return fmt.Errorf("invalid rate limiter for collector %q: %w", kind, err)
+		}
+		if len(col.StatusFields) == 0 {
+			continue
+		}
+		// The pods are sent with their whole status, the services with none.
+		if kind == resource.Pod || kind == resource.Service {
+			return fmt.Errorf("collector %q does not support status fields", kind)
+		}
+		for _, field := range col.StatusFields {
+			if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+				return fmt.Errorf("invalid status field %q for collector %q", field, kind)
+			}
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "", TracingExporterNone, TracingExporterOTLP:
+	default:
+		return fmt.Errorf("unknown tracing exporter %q, supported exporters are %v",
+			c.Tracing.Exporter, []string{TracingExporterNone, TracingExporterOTLP})
+	}
+	if ratio := c.Tracing.Ratio(); ratio < 0 || ratio > 1 {
+		return fmt.Errorf("tracing sampling ratio %v is not between 0 and 1", ratio)
+	}
+	if err := c.Broker.validate(); err != nil {
+		return err
+	}
+	if c.LogVerbosity != nil && *c.LogVerbosity < 0 {
+		return fmt.Errorf("log verbosity must not be negative, got %d", *c.LogVerbosity)
+	}
+	for _, namespace := range c.BroadcastNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid broadcast namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+	}
+
+	for _, kind := range Kinds() {
+		if !c.IsEnabled(kind) {
+			continue
+		}
+		for _, dep := range dependencies[kind] {
+			if !c.IsEnabled(dep) {
+				return fmt.Errorf("collector %q requires collector %q to be enabled", kind, dep)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateExtraKind checks the configuration of the collector for a kind not supported out of the box.
+func validateExtraKind(kind string, col CollectorConfig) error {
+	if col.APIVersion == "" {
+		return fmt.Errorf("unknown collector %q, supported collectors are %v, set its apiVersion to collect "+
+			"another kind", kind, Kinds())
+	}
+	if _, err := schema.ParseGroupVersion(col.APIVersion); err != nil {
+		return fmt.Errorf("invalid apiVersion for collector %q: %w", kind, err)
+	}
+	if kind == "" || kind[0] < 'A' || kind[0] > 'Z' {
+		return fmt.Errorf("invalid collector %q, the kind must start with an upper case letter", kind)
+	}
+	// Only the metadata of the resources of the extra kinds are watched.
+	if len(col.StatusFields) > 0 {
+		return fmt.Errorf("collector %q does not support status fields", kind)
+	}
+	return nil
+}
+
+// validate checks the settings of the broker.
+func (b *BrokerConfig) validate() error {
+	if b.NodeRate != nil && *b.NodeRate < 0 {
+		return fmt.Errorf("broker node rate %v must not be negative", *b.NodeRate)
+	}
+	if b.NodeBurst != nil && *b.NodeBurst < 1 {
+		return fmt.Errorf("broker node burst must be at least 1, got %d", *b.NodeBurst)
+	}
+	if b.MaxDeleteDelay != nil && b.MaxDeleteDelay.Duration < 0 {
+		return fmt.Errorf("broker max delete delay %s must not be negative", b.MaxDeleteDelay.Duration)
+	}
+	if b.MaxConcurrentBackfills != nil && *b.MaxConcurrentBackfills < 0 {
+		return fmt.Errorf("broker max concurrent backfills must not be negative, got %d", *b.MaxConcurrentBackfills)
+	}
+	return nil
+}
+
+// validate checks the settings of the rate limiter, if set.
+func (r *RateLimiterConfig) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.BaseDelay != nil && r.BaseDelay.Duration < 0 {
+		return fmt.Errorf("base delay %s must not be negative", r.BaseDelay.Duration)
+	}
+	if r.MaxDelay != nil && r.MaxDelay.Duration < 0 {
+		return fmt.Errorf("max delay %s must not be negative", r.MaxDelay.Duration)
+	}
+	if r.BaseDelay != nil && r.MaxDelay != nil && r.BaseDelay.Duration > r.MaxDelay.Duration {
+		return fmt.Errorf("base delay %s is greater than max delay %s", r.BaseDelay.Duration, r.MaxDelay.Duration)
+	}
+	if r.QPS != nil && *r.QPS <= 0 {
+		return fmt.Errorf("qps %v must be positive", *r.QPS)
+	}
+	if r.Burst != nil && *r.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1, got %d", *r.Burst)
+	}
+	return nil
+}
+
+// RegisterKind adds the kind to the supported collectors, along with the kinds of the collectors it relies on. It is
+// called when a collector is registered, from the init functions, and is not safe for concurrent use.
+func RegisterKind(kind string, deps ...string) {
+	dependencies[kind] = deps
+}
+
+// Kinds returns the sorted resource kinds of the supported collectors.
+func Kinds() []string {
+	kinds := make([]string, 0, len(dependencies))
+	for kind := range dependencies {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unable to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cfg, unknown, err := Load(writeConfig(t, `
+collectors:
+  Service:
+    enabled: false
+  Deployment:
+    enabled: true
+    rateLimiter:
+      baseDelay: 10ms
+      burst: 5
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(unknown) != 0 {
+		t.Errorf("expected no unknown keys, got %v", unknown)
+	}
+	if limiter := cfg.Collectors[resource.Deployment].RateLimiter; limiter == nil || limiter.BaseDelay.Duration != 10*time.Millisecond ||
+		*limiter.Burst != 5 || limiter.MaxDelay != nil || limiter.QPS != nil {
+		t.Errorf("unexpected rate limiter %+v", limiter)
+	}
+
+	if cfg.IsEnabled(resource.Service) {
+		t.Errorf("expected collector %q to be disabled", resource.Service)
+	}
+	for _, kind := range []string{resource.Deployment, resource.Pod, resource.Namespace} {
+		if !cfg.IsEnabled(kind) {
+			t.Errorf("expected collector %q to be enabled", kind)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestLoadTracing(t *testing.T) {
+	cfg, _, err := Load(writeConfig(t, `
+tracing:
+  exporter: otlp
+  endpoint: otel-collector:4317
+  insecure: true
+  samplingRatio: 0.25
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cfg.Tracing.Enabled() {
+		t.Errorf("expected tracing to be enabled")
+	}
+	if cfg.Tracing.Endpoint != "otel-collector:4317" || !cfg.Tracing.Insecure {
+		t.Errorf("unexpected tracing configuration %+v", cfg.Tracing)
+	}
+	if ratio := cfg.Tracing.Ratio(); ratio != 0.25 {
+		t.Errorf("expected sampling ratio 0.25, got %v", ratio)
+	}
+	if Default().Tracing.Enabled() {
+		t.Errorf("expected tracing to be disabled by default")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	disabled := false
+	ratio := 1.5
+	zero := 0
+	negative := -1
+	tests := []struct {
+		name    string
+		cfg     *Config
+		wantErr bool
+	}{
+		{
+			name: "default",
+			cfg:  Default(),
+		},
+		{
+			name: "unknown collector",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				"Ingress": {Enabled: &disabled},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "missing dependency",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Pod: {Enabled: &disabled},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "rate limiter with negative burst",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {RateLimiter: &RateLimiterConfig{BaseDelay: &metav1.Duration{Duration: time.Second},
+					MaxDelay: &metav1.Duration{Duration: time.Minute}, Burst: &negative}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "rate limiter with base delay greater than max delay",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {RateLimiter: &RateLimiterConfig{BaseDelay: &metav1.Duration{Duration: time.Minute},
+					MaxDelay: &metav1.Duration{Duration: time.Second}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "excluded names",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {ExcludedNames: []string{"*-canary", "monitoring/prometheus-*"}},
+			}},
+		},
+		{
+			name: "malformed excluded name",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {ExcludedNames: []string{"web-["}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "valid rate limiter",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {RateLimiter: &RateLimiterConfig{BaseDelay: &metav1.Duration{Duration: time.Second},
+					MaxDelay: &metav1.Duration{Duration: time.Minute}}},
+			}},
+		},
+		{
+			name: "status fields",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {StatusFields: []string{"readyReplicas", "conditions"}},
+			}},
+		},
+		{
+			name: "status fields of pods",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Pod: {StatusFields: []string{"phase"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid status field",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {StatusFields: []string{"conditions..type"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "selectors",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {LabelSelector: "app in (web, api)", AnnotationSelector: "team=a"},
+			}},
+		},
+		{
+			name: "invalid label selector",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {LabelSelector: "app in web"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "extra kind",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				"Certificate": {APIVersion: "cert-manager.io/v1"},
+			}},
+		},
+		{
+			name: "extra kind with status fields",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				"Certificate": {APIVersion: "cert-manager.io/v1", StatusFields: []string{"notAfter"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "apiVersion of a supported collector",
+			cfg: &Config{Collectors: map[string]CollectorConfig{
+				resource.Deployment: {APIVersion: "apps/v1"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid broker burst",
+			cfg:     &Config{Broker: BrokerConfig{NodeBurst: &zero}},
+			wantErr: true,
+		},
+		{
+			name:    "negative log verbosity",
+			cfg:     &Config{LogVerbosity: &negative},
+			wantErr: true,
+		},
+		{
+			name: "broadcast namespaces",
+			cfg:  &Config{BroadcastNamespaces: []string{"shared-services", "kube-public"}},
+		},
+		{
+			name:    "invalid broadcast namespace",
+			cfg:     &Config{BroadcastNamespaces: []string{"Shared_Services"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown tracing exporter",
+			cfg:     &Config{Tracing: TracingConfig{Exporter: "jaeger"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid sampling ratio",
+			cfg:     &Config{Tracing: TracingConfig{Exporter: TracingExporterOTLP, SamplingRatio: &ratio}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	cfg, unknown, err := Load(writeConfig(t, `
+collectors:
+  Deployment:
+    enabeld: false
+    labelSelector: app=web
+  Certificate:
+    apiVersion: cert-manager.io/v1
+    annotationSelector: "!example.com/skip"
+broker:
+  nodeRate: 50
+  maxDeleteDelay: 2s
+  maxMesageSize: 1024
+trace: {}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"broker.maxMesageSize", "collectors.Deployment.enabeld", "trace"}
+	if !reflect.DeepEqual(unknown, want) {
+		t.Errorf("expected unknown keys %v, got %v", want, unknown)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	// The misspelled key does not disable the collector.
+	if !cfg.IsEnabled(resource.Deployment) {
+		t.Errorf("expected collector %q to be enabled", resource.Deployment)
+	}
+	if sel := cfg.LabelSelector(resource.Deployment); sel == nil || sel.String() != "app=web" {
+		t.Errorf("unexpected label selector %v", sel)
+	}
+	if keys := cfg.AnnotationKeys("Certificate"); !reflect.DeepEqual(keys, []string{"example.com/skip"}) {
+		t.Errorf("unexpected annotation keys %v", keys)
+	}
+	if gvks := cfg.ExtraResources(); len(gvks) != 1 || gvks[0].String() != "cert-manager.io/v1, Kind=Certificate" {
+		t.Errorf("unexpected extra resources %v", gvks)
+	}
+	if cfg.Broker.NodeRate == nil || *cfg.Broker.NodeRate != 50 || cfg.Broker.MaxDeleteDelay.Duration != 2*time.Second {
+		t.Errorf("unexpected broker configuration %+v", cfg.Broker)
+	}
+}
+
+func TestRegisterKind(t *testing.T) {
+	RegisterKind("Widget", resource.Pod)
+	t.Cleanup(func() { delete(dependencies, "Widget") })
+
+	disabled := false
+	// The registered kind is supported out of the box, it needs no apiVersion and is not an extra resource.
+	cfg := &Config{Collectors: map[string]CollectorConfig{"Widget": {LabelSelector: "app=web"}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if gvks := cfg.ExtraResources(); len(gvks) != 0 {
+		t.Errorf("expected no extra resources, got %v", gvks)
+	}
+	if kinds := Kinds(); !slices.Contains(kinds, "Widget") {
+		t.Errorf("expected the registered kind among %v", kinds)
+	}
+
+	cfg.Collectors[resource.Pod] = CollectorConfig{Enabled: &disabled}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an error when the collector the registered kind relies on is disabled")
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// Watcher watches the configuration file and notifies the valid configurations it holds each time its content
+// changes. The invalid configurations are logged and ignored, the running one is kept.
+type Watcher struct {
+	logger   logr.Logger
+	path     string
+	onChange func(cfg *Config)
+	// digest is the digest of the last content read from the file.
+	digest []byte
+}
+
+// NewWatcher returns a watcher of the configuration file at the given path, calling onChange with the new
+// configuration each time the file changes. The current content of the file is the one already loaded.
+func NewWatcher(logger logr.Logger, path string, onChange func(cfg *Config)) (*Watcher, error) {
+	w := &Watcher{
+		logger:   logger,
+		path:     path,
+		onChange: onChange,
+	}
+	data, err := os.ReadFile(path) //nolint:gosec //Path is provided by the user.
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file %q: %w", path, err)
+	}
+	w.digest = digest(data)
+	return w, nil
+}
+
+// Start implements the runnable interface needed in order to handle the start/stop using the manager. It watches
+// the configuration file until the context is canceled.
+func (w *Watcher) Start(ctx context.Context) error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch configuration file %q: %w", w.path, err)
+	}
+	defer fsWatcher.Close()
+
+	// The ConfigMap volumes update the files by swapping the symlink of the directory holding them, so the directory
+	// is watched instead of the file.
+	if err := fsWatcher.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("unable to watch configuration file %q: %w", w.path, err)
+	}
+	w.logger.Info("watching configuration file", "path", w.path)
+	// The file could have changed since it has been loaded.
+	w.reload()
+
+	for {
+		select {
+		case <-fsWatcher.Events:
+			// The events for the other files in the directory, or the ones not changing the content, are discarded by
+			// comparing the digest of the content.
+			w.reload()
+		case err := <-fsWatcher.Errors:
+			w.logger.Error(err, "error while watching configuration file", "path", w.path)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reload reads the configuration file and notifies the configuration it holds if its content has changed and it
+// is valid.
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		// The file is missing while the symlinks are swapped.
+		w.logger.V(2).Info("unable to read configuration file", "path", w.path, "error", err.Error())
+		return
+	}
+	d := digest(data)
+	if bytes.Equal(d, w.digest) {
+		return
+	}
+	w.digest = d
+
+	cfg, unknown, err := Load(w.path)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		w.logger.Error(err, "invalid configuration, the running one is kept", "path", w.path)
+		return
+	}
+	for _, key := range unknown {
+		w.logger.Info("WARNING: unknown configuration key, it is ignored", "path", w.path, "key", key)
+	}
+	w.logger.Info("configuration file changed, applying it", "path", w.path)
+	w.onChange(cfg)
+}
+
+// digest returns the digest of the given content.
+func digest(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+)
+
+func TestWatcher(t *testing.T) {
+	path := writeConfig(t, "collectors: {}\n")
+	changes := make(chan *Config, 10)
+	w, err := NewWatcher(logr.Discard(), path, func(cfg *Config) { changes <- cfg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// update replaces the file as the ConfigMap volumes do, renaming a new file over it.
+	update := func(content string) {
+		t.Helper()
+		tmp := filepath.Join(filepath.Dir(path), ".config.yaml.tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectNoChange := func() {
+		t.Helper()
+		select {
+		case cfg := <-changes:
+			t.Fatalf("unexpected configuration change %+v", cfg)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// The content loaded at startup is not notified again.
+	expectNoChange()
+
+	update("collectors:\n  Pod:\n    labelSelector: app=web\n")
+	select {
+	case cfg := <-changes:
+		if sel := cfg.LabelSelector(resource.Pod); sel == nil || sel.String() != "app=web" {
+			t.Errorf("unexpected label selector %v", sel)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the configuration change to be notified")
+	}
+
+	// The invalid configurations and the unchanged content are not notified.
+	update("collectors:\n  Pod:\n    labelSelector: app=web\n")
+	expectNoChange()
+	update("collectors:\n  Pod:\n    labelSelector: \"app in (\"\n")
+	expectNoChange()
+}
@@ -18,4 +18,6 @@ package consts
 const (
 	// MetricsNamespace namespace all the metrics exposed by the meta-collector.
 	MetricsNamespace = "meta_collector"
+	// IgnoreAnnotation when set to "true" on a resource, excludes it from the metadata collection.
+	IgnoreAnnotation = "metacollector.falco.org/ignore"
 )
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprofHandlers(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		mux := http.NewServeMux()
+		for path, handler := range PprofHandlers(enabled) {
+			mux.Handle(path, handler)
+		}
+		srv := httptest.NewServer(mux)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+"/debug/pprof/heap", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+
+		expected := http.StatusNotFound
+		if enabled {
+			expected = http.StatusOK
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("expected status %d with pprof enabled %v, got %d", expected, enabled, resp.StatusCode)
+		}
+	}
+}
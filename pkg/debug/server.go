@@ -0,0 +1,330 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// cachePath is the path of the endpoint dumping the caches of the collectors.
+	cachePath = "/debug/cache/"
+	// nodesPath is the path of the endpoint returning the nodes a resource is related to.
+	nodesPath = "/debug/nodes"
+	// subscribersPath is the path of the endpoint listing the connected subscribers.
+	subscribersPath = "/debug/subscribers"
+	// pausePath is the path of the endpoint pausing and resuming the collectors.
+	pausePath = "/debug/pause/"
+	// defaultLimit is the number of items returned in a page when no limit is requested.
+	defaultLimit = 500
+	// maxLimit is the maximum number of items returned in a page.
+	maxLimit = 5000
+	// shutdownTimeout is the time given to the pending requests to complete when the server is stopped.
+	shutdownTimeout = 5 * time.Second
+)
+
+// CachePage is a page of the items of the cache of a collector.
+type CachePage struct {
+	Collector string             `json:"collector"`
+	Items     []events.CacheItem `json:"items"`
+	// Continue is the key to be passed in the continue parameter to get the next page, empty for the last page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ResourceNodes holds the nodes a resource is related to in the cache of a collector.
+type ResourceNodes struct {
+	Collector string   `json:"collector"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Nodes     []string `json:"nodes"`
+}
+
+// SubscriberLister returns a snapshot of the state of the connected subscribers.
+type SubscriberLister interface {
+	SubscriberStates() []broker.SubscriberState
+}
+
+// PauseState is the pause state of a collector.
+type PauseState struct {
+	Collector string `json:"collector"`
+	Paused    bool   `json:"paused"`
+}
+
+// Pausable is a collector that can be paused and resumed at runtime.
+type Pausable interface {
+	SetPaused(paused bool)
+	Paused() bool
+}
+
+// Option function used to set options when creating a new Server.
+type Option func(s *Server)
+
+// WithSubscribers configures the source of the state of the subscribers served by the subscribers endpoint. The
+// endpoint is not served if no source is configured.
+func WithSubscribers(subscribers SubscriberLister) Option {
+	return func(s *Server) {
+		s.subscribers = subscribers
+	}
+}
+
+// WithPausable configures the collectors, by name, paused and resumed through the pause endpoint. The endpoint is not
+// served if no collector is configured.
+func WithPausable(collectors map[string]Pausable) Option {
+	return func(s *Server) {
+		s.pausable = collectors
+	}
+}
+
+// Server serves the debug endpoints. The endpoints expose the internal state of the collectors and pause them, so
+// they are only served to the clients connecting from the loopback interface, e.g. through a port-forward.
+type Server struct {
+	logger logr.Logger
+	addr   string
+	caches map[string]*events.Cache
+	// subscribers is the source of the state of the subscribers, nil if not configured.
+	subscribers SubscriberLister
+	// pausable holds the collectors that can be paused by name, nil if not configured.
+	pausable map[string]Pausable
+}
+
+// New returns a new Server listening on the given address, that serves the given caches by collector name.
+func New(logger logr.Logger, addr string, caches map[string]*events.Cache, opts ...Option) *Server {
+	s := &Server{
+		logger: logger,
+		addr:   addr,
+		caches: caches,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Start starts the server and stops it when the context is canceled. It implements the manager.Runnable interface.
+func (s *Server) Start(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+
+	serverError := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting debug server", "addr", s.addr)
+		serverError <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-serverError:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// NeedLeaderElection returns false, the debug endpoints are served by all the replicas.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Handler returns the handler of the debug endpoints:
+//
+//	GET /debug/cache/                returns the names of the collectors;
+//	GET /debug/cache/{collector}     returns a page of the items cached by the collector;
+//	GET /debug/nodes                 returns the nodes a resource is related to;
+//	GET /debug/subscribers           returns the state of the connected subscribers, if configured;
+//	GET /debug/pause/                returns the pause state of the collectors, if configured;
+//	GET /debug/pause/{collector}     returns the pause state of the collector;
+//	PUT /debug/pause/{collector}     pauses the collector;
+//	DELETE /debug/pause/{collector}  resumes the collector.
+//
+// The items can be filtered by the node they are related to and by namespace using the node and namespace query
+// parameters. The size of the pages is set by the limit parameter, and the next page is requested by passing in the
+// continue parameter the value returned in the previous page. The resource whose nodes are returned is identified by
+// the kind, namespace and name query parameters.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(cachePath, s.serveCache)
+	mux.HandleFunc(nodesPath, s.serveNodes)
+	if s.subscribers != nil {
+		mux.HandleFunc(subscribersPath, s.serveSubscribers)
+	}
+	if len(s.pausable) > 0 {
+		mux.HandleFunc(pausePath, s.servePause)
+	}
+	return localOnly(mux)
+}
+
+// serveCache serves the items of the cache of a collector.
+func (s *Server) serveCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collector := strings.TrimPrefix(r.URL.Path, cachePath)
+	if collector == "" {
+		names := make([]string, 0, len(s.caches))
+		for name := range s.caches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s.writeJSON(w, names)
+		return
+	}
+	cache, ok := s.caches[collector]
+	if !ok {
+		http.Error(w, "unknown collector "+strconv.Quote(collector), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 || limit > maxLimit {
+			http.Error(w, "limit must be a number between 1 and "+strconv.Itoa(maxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	var match func(key string) bool
+	if namespace := query.Get("namespace"); namespace != "" {
+		match = func(key string) bool {
+			return events.NameFromKey(key).Namespace == namespace
+		}
+	}
+
+	items, next := cache.List(query.Get("node"), query.Get("continue"), limit, match)
+	s.writeJSON(w, CachePage{Collector: collector, Items: items, Continue: next})
+}
+
+// serveNodes serves the nodes a resource is related to. The collectors cache the resources by kind, so the resource
+// is looked up in all the caches.
+func (s *Server) serveNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	res := ResourceNodes{Kind: query.Get("kind"), Namespace: query.Get("namespace"), Name: query.Get("name")}
+	if res.Kind == "" || res.Name == "" {
+		http.Error(w, "the kind and name parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	name := types.NamespacedName{Namespace: res.Namespace, Name: res.Name}
+	for collector, cache := range s.caches {
+		nodes, ok := cache.NodesOf(cache.Key(res.Kind, name))
+		if !ok {
+			continue
+		}
+		res.Collector = collector
+		res.Nodes = nodes
+		s.writeJSON(w, res)
+		return
+	}
+	http.Error(w, res.Kind+" "+name.String()+" is not cached by any collector", http.StatusNotFound)
+}
+
+// serveSubscribers serves the state of the connected subscribers.
+func (s *Server) serveSubscribers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	states := s.subscribers.SubscriberStates()
+	if states == nil {
+		states = []broker.SubscriberState{}
+	}
+	s.writeJSON(w, states)
+}
+
+// servePause serves the pause state of the collectors, and pauses or resumes them.
+func (s *Server) servePause(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, pausePath)
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		states := make([]PauseState, 0, len(s.pausable))
+		for name, collector := range s.pausable {
+			states = append(states, PauseState{Collector: name, Paused: collector.Paused()})
+		}
+		sort.Slice(states, func(i, j int) bool { return states[i].Collector < states[j].Collector })
+		s.writeJSON(w, states)
+		return
+	}
+	collector, ok := s.pausable[name]
+	if !ok {
+		http.Error(w, "unknown collector "+strconv.Quote(name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		paused := r.Method == http.MethodPut
+		if paused != collector.Paused() {
+			collector.SetPaused(paused)
+			s.logger.Info("collector pause state changed", "collector", name, "paused", paused)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, PauseState{Collector: name, Paused: collector.Paused()})
+}
+
+// writeJSON writes the value as the JSON body of the response.
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error(err, "unable to write debug response")
+	}
+}
+
+// localOnly refuses the requests not coming from the loopback interface.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "the debug endpoints are only served on the loopback interface", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestServeCache(t *testing.T) {
+	cache := events.NewCache()
+	cache.Add("Pod/default/a", &events.CacheEntry{UID: "uid-a"})
+	cache.AddNodes("Pod/default/a", "node-1")
+	cache.Add("Pod/kube-system/b", &events.CacheEntry{UID: "uid-b"})
+	cache.AddNodes("Pod/kube-system/b", "node-1")
+	handler := New(logr.Discard(), "", map[string]*events.Cache{"pod-collector": cache}).Handler()
+
+	get := func(target, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/debug/cache/pod-collector", "10.0.0.1:4000"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the remote requests to be refused, got %d", rec.Code)
+	}
+	if rec := get("/debug/cache/unknown", "127.0.0.1:4000"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown collector to be not found, got %d", rec.Code)
+	}
+	if rec := get("/debug/cache/pod-collector?limit=0", "[::1]:4000"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid limit to be refused, got %d", rec.Code)
+	}
+
+	rec := get("/debug/cache/pod-collector?node=node-1&namespace=kube-system", "127.0.0.1:4000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the cache to be served, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var page CachePage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].UID != "uid-b" || page.Continue != "" {
+		t.Errorf("expected only the item in kube-system, got %+v", page)
+	}
+
+	rec = get("/debug/cache/pod-collector?limit=1", "127.0.0.1:4000")
+	page = CachePage{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Continue != "Pod/default/a" {
+		t.Errorf("expected a first page continuing after Pod/default/a, got %+v", page)
+	}
+}
+
+func TestServeNodes(t *testing.T) {
+	cache := events.NewCache()
+	key := cache.Key("Deployment", types.NamespacedName{Namespace: "default", Name: "web"})
+	cache.Add(key, &events.CacheEntry{UID: "uid-web"})
+	cache.AddNodes(key, "node-2", "node-1")
+	handler := New(logr.Discard(), "", map[string]*events.Cache{
+		"deployment-collector": cache,
+		"pod-collector":        events.NewCache(),
+	}).Handler()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = "127.0.0.1:4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/debug/nodes?kind=Deployment&namespace=default&name=web")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the nodes to be served, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res ResourceNodes
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Collector != "deployment-collector" || !reflect.DeepEqual(res.Nodes, []string{"node-1", "node-2"}) {
+		t.Errorf("unexpected nodes %+v", res)
+	}
+
+	if rec := get("/debug/nodes?kind=Pod&namespace=default&name=web"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected a resource not cached to be not found, got %d", rec.Code)
+	}
+	if rec := get("/debug/nodes?namespace=default"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the kind and the name to be required, got %d", rec.Code)
+	}
+}
+
+// fakeSubscribers returns a fixed state of the subscribers.
+type fakeSubscribers []broker.SubscriberState
+
+func (f fakeSubscribers) SubscriberStates() []broker.SubscriberState {
+	return f
+}
+
+func TestServeSubscribers(t *testing.T) {
+	get := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/subscribers", http.NoBody)
+		req.RemoteAddr = "127.0.0.1:4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(New(logr.Discard(), "", nil).Handler()); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the endpoint not to be served without subscribers, got %d", rec.Code)
+	}
+
+	subs := fakeSubscribers{{UID: "uid-1", Node: "node-1", Sent: 3, Pending: 2, LagSeconds: 1.5}}
+	rec := get(New(logr.Discard(), "", nil, WithSubscribers(subs)).Handler())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the subscribers to be served, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var states []broker.SubscriberState
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(states, []broker.SubscriberState(subs)) {
+		t.Errorf("expected %+v, got %+v", subs, states)
+	}
+}
+
+// fakePausable records the pause state set by the server.
+type fakePausable struct {
+	paused bool
+	calls  int
+}
+
+func (f *fakePausable) SetPaused(paused bool) {
+	f.paused = paused
+	f.calls++
+}
+
+func (f *fakePausable) Paused() bool {
+	return f.paused
+}
+
+func TestServePause(t *testing.T) {
+	collector := &fakePausable{}
+	handler := New(logr.Discard(), "", nil, WithPausable(map[string]Pausable{"pod-collector": collector})).Handler()
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, http.NoBody)
+		req.RemoteAddr = "127.0.0.1:4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	state := func(rec *httptest.ResponseRecorder) PauseState {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the pause state to be served, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var s PauseState
+		if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if rec := do(http.MethodPut, "/debug/pause/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown collector to be not found, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/debug/pause/pod-collector"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be refused, got %d", rec.Code)
+	}
+
+	if s := state(do(http.MethodPut, "/debug/pause/pod-collector")); !s.Paused || !collector.paused {
+		t.Errorf("expected the collector to be paused, got %+v", s)
+	}
+	// Pausing a paused collector leaves it untouched.
+	if s := state(do(http.MethodPut, "/debug/pause/pod-collector")); !s.Paused || collector.calls != 1 {
+		t.Errorf("expected the collector to be paused once, got %+v after %d calls", s, collector.calls)
+	}
+
+	rec := do(http.MethodGet, "/debug/pause/")
+	var states []PauseState
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatal(err)
+	}
+	if want := []PauseState{{Collector: "pod-collector", Paused: true}}; !reflect.DeepEqual(states, want) {
+		t.Errorf("expected %+v, got %+v", want, states)
+	}
+
+	if s := state(do(http.MethodDelete, "/debug/pause/pod-collector")); s.Paused || collector.paused {
+		t.Errorf("expected the collector to be resumed, got %+v", s)
+	}
+	if s := state(do(http.MethodGet, "/debug/pause/pod-collector")); s.Paused {
+		t.Errorf("expected the collector to be running, got %+v", s)
+	}
+}
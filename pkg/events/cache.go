@@ -16,9 +16,12 @@
 package events
 
 import (
+	"maps"
+	"sort"
 	"sync"
 
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -30,9 +33,43 @@ import (
 //  2. When a resource is deleted we need to know the subscribers that need a Delete event.
 //     The cache provides the sayed subscribers.
 //  3. When a resource is updated the cache knows the subscribers that need an Update event.
+//
+// The items are stored by the key returned by the KeyFunc of the cache, which defaults to KindKey.
+//
+// The cache also indexes the items by the nodes they are related to, so that the items to be sent to a new
+// subscriber of a node are found without going through the whole cache.
 type Cache struct {
-	items  map[string]*CacheEntry
-	rwLock sync.RWMutex
+	items map[string]*CacheEntry
+	// nodes holds for each node the keys of the related items.
+	nodes map[string]map[string]struct{}
+	// itemNodes holds for each item the nodes it is related to.
+	itemNodes map[string]map[string]struct{}
+	// memberships is the number of relations between the items and the nodes.
+	memberships int
+	keyFunc     KeyFunc
+	// entriesGauge and membershipsGauge track the size of the cache, nil if the cache has no name.
+	entriesGauge     prometheus.Gauge
+	membershipsGauge prometheus.Gauge
+	rwLock           sync.RWMutex
+}
+
+// CacheOption function used to set options when creating a new cache.
+type CacheOption func(cache *Cache)
+
+// WithKeyFunc configures the function used to compute the keys of the items.
+func WithKeyFunc(keyFunc KeyFunc) CacheOption {
+	return func(cache *Cache) {
+		cache.keyFunc = keyFunc
+	}
+}
+
+// WithName configures the name of the collector owning the cache, used to label the metrics tracking the number of
+// items in the cache and of their relations with the nodes. The metrics are not tracked for caches without a name.
+func WithName(name string) CacheOption {
+	return func(cache *Cache) {
+		cache.entriesGauge = cacheEntries.WithLabelValues(name)
+		cache.membershipsGauge = cacheMemberships.WithLabelValues(name)
+	}
 }
 
 // CacheEntry items that can be saved in the cache.
@@ -41,14 +78,33 @@ type CacheEntry struct {
 	UID  types.UID
 	Refs fields.References
 	Subs fields.Subscribers
+	// Meta, Labels and Annotations sent in the last event, to compute the changes attached to the next Update event.
+	Meta        string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Sequence of the last change of the resource, carried by its events.
+	Sequence uint64
 }
 
 // NewCache creates a new Cache.
-func NewCache() *Cache {
-	return &Cache{
-		items:  make(map[string]*CacheEntry),
-		rwLock: sync.RWMutex{},
+func NewCache(opts ...CacheOption) *Cache {
+	cache := &Cache{
+		items:     make(map[string]*CacheEntry),
+		nodes:     make(map[string]map[string]struct{}),
+		itemNodes: make(map[string]map[string]struct{}),
+		keyFunc:   KindKey,
+		rwLock:    sync.RWMutex{},
+	}
+	for _, o := range opts {
+		o(cache)
 	}
+	cache.updateGauges()
+	return cache
+}
+
+// Key returns the key of the resource of the given kind and name in the cache.
+func (gc *Cache) Key(kind string, name types.NamespacedName) string {
+	return gc.keyFunc(kind, name)
 }
 
 // Add adds a new item to the cache if it does not exist.
@@ -58,6 +114,7 @@ func (gc *Cache) Add(key string, value *CacheEntry) {
 	if _, ok := gc.items[key]; !ok {
 		gc.items[key] = value
 	}
+	gc.updateGauges()
 	gc.rwLock.Unlock()
 }
 
@@ -65,16 +122,239 @@ func (gc *Cache) Add(key string, value *CacheEntry) {
 func (gc *Cache) Update(key string, value *CacheEntry) {
 	gc.rwLock.Lock()
 	gc.items[key] = value
+	gc.updateGauges()
 	gc.rwLock.Unlock()
 }
 
-// Delete deletes an item from the cache.
+// Delete deletes an item from the cache and from the node index.
 func (gc *Cache) Delete(key string) {
 	gc.rwLock.Lock()
 	delete(gc.items, key)
+	gc.deleteNodes(key, nil)
+	gc.updateGauges()
 	gc.rwLock.Unlock()
 }
 
+// AddNodes relates the item with the given key to the nodes in the node index. It returns the nodes the item was not
+// related to before.
+func (gc *Cache) AddNodes(key string, nodes ...string) []string {
+	if len(nodes) == 0 {
+		return nil
+	}
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	added := gc.addNodes(key, nodes)
+	gc.updateGauges()
+	return added
+}
+
+// DeleteNodes removes the relation between the item with the given key and the nodes from the node index. If no
+// node is given, the item is related to no node anymore. It returns the nodes the item was related to among the given
+// ones.
+func (gc *Cache) DeleteNodes(key string, nodes ...string) []string {
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	removed := gc.deleteNodes(key, nodes)
+	gc.updateGauges()
+	return removed
+}
+
+// SetNodes replaces the nodes related to the item with the given key in the node index. It returns the nodes the item
+// was not related to before and the ones it is not related to anymore, both sorted.
+func (gc *Cache) SetNodes(key string, nodes ...string) (added, removed []string) {
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	keep := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		keep[node] = struct{}{}
+	}
+	var stale []string
+	for node := range gc.itemNodes[key] {
+		if _, ok := keep[node]; !ok {
+			stale = append(stale, node)
+		}
+	}
+	if len(stale) != 0 {
+		removed = gc.deleteNodes(key, stale)
+	}
+	if len(nodes) != 0 {
+		added = gc.addNodes(key, nodes)
+	}
+	gc.updateGauges()
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// addNodes implements AddNodes, the caller must hold the write lock.
+func (gc *Cache) addNodes(key string, nodes []string) []string {
+	itemNodes, ok := gc.itemNodes[key]
+	if !ok {
+		itemNodes = make(map[string]struct{}, len(nodes))
+		gc.itemNodes[key] = itemNodes
+	}
+	var added []string
+	for _, node := range nodes {
+		if _, ok := itemNodes[node]; !ok {
+			gc.memberships++
+			added = append(added, node)
+		}
+		itemNodes[node] = struct{}{}
+		keys, ok := gc.nodes[node]
+		if !ok {
+			keys = make(map[string]struct{})
+			gc.nodes[node] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	return added
+}
+
+// deleteNodes implements DeleteNodes, the caller must hold the write lock.
+func (gc *Cache) deleteNodes(key string, nodes []string) []string {
+	itemNodes, ok := gc.itemNodes[key]
+	if !ok {
+		return nil
+	}
+	if len(nodes) == 0 {
+		for node := range itemNodes {
+			nodes = append(nodes, node)
+		}
+	}
+	var removed []string
+	for _, node := range nodes {
+		if _, ok := itemNodes[node]; ok {
+			gc.memberships--
+			removed = append(removed, node)
+		}
+		delete(itemNodes, node)
+		if keys, ok := gc.nodes[node]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(gc.nodes, node)
+			}
+		}
+	}
+	if len(itemNodes) == 0 {
+		delete(gc.itemNodes, key)
+	}
+	return removed
+}
+
+// updateGauges updates the metrics tracking the size of the cache, the caller must hold the write lock.
+func (gc *Cache) updateGauges() {
+	if gc.entriesGauge == nil {
+		return
+	}
+	gc.entriesGauge.Set(float64(len(gc.items)))
+	gc.membershipsGauge.Set(float64(gc.memberships))
+}
+
+// KeysPerNode returns the keys of the items related to the given node.
+func (gc *Cache) KeysPerNode(node string) []string {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	keys := make([]string, 0, len(gc.nodes[node]))
+	for key := range gc.nodes[node] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// NodesOf returns the sorted nodes the item with the given key is related to. It returns false if the item is not
+// in the cache.
+func (gc *Cache) NodesOf(key string) ([]string, bool) {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	if _, ok := gc.items[key]; !ok {
+		return nil, false
+	}
+	nodes := make([]string, 0, len(gc.itemNodes[key]))
+	for node := range gc.itemNodes[key] {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes, true
+}
+
+// CacheSnapshot is the state of an item of the cache, returned by Cache.Snapshot.
+type CacheSnapshot struct {
+	key   string
+	entry *CacheEntry
+	nodes []string
+}
+
+// Snapshot returns the state of the item with the given key, missing or not, so that the changes made to it afterward
+// can be rolled back with Restore.
+func (gc *Cache) Snapshot(key string) CacheSnapshot {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	return gc.snapshot(key)
+}
+
+// snapshot implements Snapshot, the caller must hold the lock.
+func (gc *Cache) snapshot(key string) CacheSnapshot {
+	snapshot := CacheSnapshot{key: key}
+	if entry, ok := gc.items[key]; ok {
+		e := *entry
+		snapshot.entry = &e
+		for node := range gc.itemNodes[key] {
+			snapshot.nodes = append(snapshot.nodes, node)
+		}
+	}
+	return snapshot
+}
+
+// Key returns the key of the item.
+func (s CacheSnapshot) Key() string {
+	return s.key
+}
+
+// Entry returns a copy of the entry of the item, nil if the item was missing. It must not be modified.
+func (s CacheSnapshot) Entry() *CacheEntry {
+	return s.entry
+}
+
+// Restore rolls the item back to the state of the snapshot, together with the nodes it is related to. The item is
+// replaced with a copy of the snapshot, so the entries already handed out by the cache are never modified.
+func (gc *Cache) Restore(snapshot CacheSnapshot) {
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	gc.deleteNodes(snapshot.key, nil)
+	if snapshot.entry == nil {
+		delete(gc.items, snapshot.key)
+	} else {
+		e := *snapshot.entry
+		gc.items[snapshot.key] = &e
+		if len(snapshot.nodes) != 0 {
+			gc.addNodes(snapshot.key, snapshot.nodes)
+		}
+	}
+	gc.updateGauges()
+}
+
+// DeleteIf deletes the items whose keys are accepted by the match function, in a single critical section: the items
+// cannot be changed in the meantime. It returns the snapshots of the deleted items sorted by key, so that they can be
+// restored. The match function is called while holding the write lock, it must not use the cache.
+func (gc *Cache) DeleteIf(match func(key string) bool) []CacheSnapshot {
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	var deleted []CacheSnapshot
+	for key := range gc.items {
+		if !match(key) {
+			continue
+		}
+		deleted = append(deleted, gc.snapshot(key))
+		delete(gc.items, key)
+		gc.deleteNodes(key, nil)
+	}
+	gc.updateGauges()
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].key < deleted[j].key
+	})
+	return deleted
+}
+
 // Get returns an item from the cache using the provided key.
 func (gc *Cache) Get(key string) (*CacheEntry, bool) {
 	gc.rwLock.RLock()
@@ -90,3 +370,135 @@ func (gc *Cache) Has(key string) bool {
 	_, ok := gc.items[key]
 	return ok
 }
+
+// Keys returns the keys of all the items in the cache.
+func (gc *Cache) Keys() []string {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	keys := make([]string, 0, len(gc.items))
+	for key := range gc.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// SetHash sets the hash of the entry while holding the write lock, so that the entry can be read concurrently
+// through List.
+func (gc *Cache) SetHash(entry *CacheEntry, hash uint64) {
+	gc.rwLock.Lock()
+	entry.Hash = hash
+	gc.rwLock.Unlock()
+}
+
+// NextSequence increments the sequence of the entry while holding the write lock, and returns it.
+func (gc *Cache) NextSequence(entry *CacheEntry) uint64 {
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	entry.Sequence++
+	return entry.Sequence
+}
+
+// SetSubscribers sets the subscribers of the entry while holding the write lock.
+func (gc *Cache) SetSubscribers(entry *CacheEntry, subs fields.Subscribers) {
+	gc.rwLock.Lock()
+	entry.Subs = subs
+	gc.rwLock.Unlock()
+}
+
+// SetReferences sets the references of the entry while holding the write lock.
+func (gc *Cache) SetReferences(entry *CacheEntry, refs fields.References) {
+	gc.rwLock.Lock()
+	entry.Refs = refs
+	gc.rwLock.Unlock()
+}
+
+// SetMeta sets the serialized metadata and a copy of the labels and annotations of the entry while holding the write
+// lock.
+func (gc *Cache) SetMeta(entry *CacheEntry, meta string, labels, annotations map[string]string) {
+	gc.rwLock.Lock()
+	entry.Meta = meta
+	entry.Labels = maps.Clone(labels)
+	entry.Annotations = maps.Clone(annotations)
+	gc.rwLock.Unlock()
+}
+
+// CacheItem is a copy of an entry of the cache, together with its key and the nodes it is related to.
+type CacheItem struct {
+	Key         string              `json:"key"`
+	UID         types.UID           `json:"uid"`
+	Hash        uint64              `json:"hash"`
+	Nodes       []string            `json:"nodes"`
+	Subscribers []string            `json:"subscribers"`
+	References  map[string][]string `json:"references,omitempty"`
+}
+
+// List returns the copies of the items related to the given node, or of all the items if node is empty, whose keys
+// are accepted by the match function. The items are sorted by key and start after the given key, at most limit items
+// are returned if limit is greater than zero. The returned key is the one of the last item if more items follow,
+// and can be used to list the next page.
+func (gc *Cache) List(node, after string, limit int, match func(key string) bool) ([]CacheItem, string) {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+
+	var keys []string
+	add := func(key string) {
+		if key > after && (match == nil || match(key)) {
+			keys = append(keys, key)
+		}
+	}
+	if node != "" {
+		for key := range gc.nodes[node] {
+			add(key)
+		}
+	} else {
+		for key := range gc.items {
+			add(key)
+		}
+	}
+	sort.Strings(keys)
+
+	var next string
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+
+	items := make([]CacheItem, 0, len(keys))
+	for _, key := range keys {
+		item := CacheItem{Key: key, Nodes: make([]string, 0, len(gc.itemNodes[key]))}
+		if entry, ok := gc.items[key]; ok {
+			item.UID = entry.UID
+			item.Hash = entry.Hash
+			item.Subscribers = make([]string, 0, len(entry.Subs))
+			for sub := range entry.Subs {
+				item.Subscribers = append(item.Subscribers, sub)
+			}
+			sort.Strings(item.Subscribers)
+			if len(entry.Refs) != 0 {
+				item.References = entry.Refs.ToFlatMap()
+			}
+		}
+		for n := range gc.itemNodes[key] {
+			item.Nodes = append(item.Nodes, n)
+		}
+		sort.Strings(item.Nodes)
+		items = append(items, item)
+	}
+
+	return items, next
+}
+
+// DeleteSubscribers removes the given subscribers from all the items in the cache. The subscribers of an
+// item are replaced with a new set, so the sets already handed out by the cache are never modified.
+func (gc *Cache) DeleteSubscribers(subs fields.Subscribers) {
+	if len(subs) == 0 {
+		return
+	}
+	gc.rwLock.Lock()
+	defer gc.rwLock.Unlock()
+	for _, entry := range gc.items {
+		if len(entry.Subs.Intersect(subs)) != 0 {
+			entry.Subs = entry.Subs.Difference(subs)
+		}
+	}
+}
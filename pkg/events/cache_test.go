@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCacheNodeIndex(t *testing.T) {
+	cache := NewCache()
+	keysPerNode := func(node string) []string {
+		keys := cache.KeysPerNode(node)
+		sort.Strings(keys)
+		return keys
+	}
+
+	cache.Add("default/a", &CacheEntry{})
+	cache.AddNodes("default/a", "node-1", "node-2")
+	cache.Add("default/b", &CacheEntry{})
+	cache.AddNodes("default/b", "node-2")
+
+	if got := keysPerNode("node-2"); !reflect.DeepEqual(got, []string{"default/a", "default/b"}) {
+		t.Errorf("expected both items on node-2, got %v", got)
+	}
+
+	cache.DeleteNodes("default/a", "node-2")
+	if got := keysPerNode("node-2"); !reflect.DeepEqual(got, []string{"default/b"}) {
+		t.Errorf("expected only default/b on node-2, got %v", got)
+	}
+	if got := keysPerNode("node-1"); !reflect.DeepEqual(got, []string{"default/a"}) {
+		t.Errorf("expected default/a to stay on node-1, got %v", got)
+	}
+
+	// Deleting an item removes it from the index.
+	cache.Delete("default/a")
+	if got := keysPerNode("node-1"); len(got) != 0 {
+		t.Errorf("expected no items on node-1, got %v", got)
+	}
+
+	// Without nodes, the item is removed from all the nodes.
+	cache.DeleteNodes("default/b")
+	if got := keysPerNode("node-2"); len(got) != 0 {
+		t.Errorf("expected no items on node-2, got %v", got)
+	}
+	if len(cache.nodes) != 0 || len(cache.itemNodes) != 0 {
+		t.Errorf("expected an empty index, got %v and %v", cache.nodes, cache.itemNodes)
+	}
+}
+
+func TestCacheNodeDelta(t *testing.T) {
+	cache := NewCache(WithName("node-delta-test"))
+	memberships := cacheMemberships.WithLabelValues("node-delta-test")
+
+	if added := cache.AddNodes("a", "node-1", "node-2"); !reflect.DeepEqual(added, []string{"node-1", "node-2"}) {
+		t.Errorf("expected both nodes to be added, got %v", added)
+	}
+	if added := cache.AddNodes("a", "node-2", "node-3"); !reflect.DeepEqual(added, []string{"node-3"}) {
+		t.Errorf("expected only node-3 to be added, got %v", added)
+	}
+	if removed := cache.DeleteNodes("a", "node-3", "node-4"); !reflect.DeepEqual(removed, []string{"node-3"}) {
+		t.Errorf("expected only node-3 to be removed, got %v", removed)
+	}
+
+	added, removed := cache.SetNodes("a", "node-4", "node-2", "node-5")
+	if !reflect.DeepEqual(added, []string{"node-4", "node-5"}) || !reflect.DeepEqual(removed, []string{"node-1"}) {
+		t.Errorf("expected node-4 and node-5 to be added and node-1 removed, got added %v and removed %v", added, removed)
+	}
+	if keys := cache.KeysPerNode("node-1"); len(keys) != 0 {
+		t.Errorf("expected no items on node-1, got %v", keys)
+	}
+	if got := testutil.ToFloat64(memberships); got != 3 {
+		t.Errorf("expected 3 node memberships, got %v", got)
+	}
+
+	if added, removed := cache.SetNodes("a", "node-2", "node-4", "node-5"); added != nil || removed != nil {
+		t.Errorf("expected no changes for the same nodes, got added %v and removed %v", added, removed)
+	}
+	added, removed = cache.SetNodes("a")
+	if added != nil || !reflect.DeepEqual(removed, []string{"node-2", "node-4", "node-5"}) {
+		t.Errorf("expected all the nodes to be removed, got added %v and removed %v", added, removed)
+	}
+	if len(cache.itemNodes) != 0 || len(cache.nodes) != 0 {
+		t.Errorf("expected an empty index, got %v and %v", cache.nodes, cache.itemNodes)
+	}
+}
+
+func TestCacheRestore(t *testing.T) {
+	cache := NewCache()
+	entry := &CacheEntry{UID: "uid", Hash: 1, Subs: fields.Subscribers{"sub-1": {}}, Sequence: 1}
+	cache.Add("default/a", entry)
+	cache.AddNodes("default/a", "node-1")
+	snapshot := cache.Snapshot("default/a")
+	missing := cache.Snapshot("default/b")
+
+	cache.SetHash(entry, 2)
+	cache.SetSubscribers(entry, fields.Subscribers{"sub-2": {}})
+	cache.NextSequence(entry)
+	cache.SetNodes("default/a", "node-2")
+	cache.Add("default/b", &CacheEntry{UID: "uid-b"})
+	cache.AddNodes("default/b", "node-2")
+
+	cache.Restore(snapshot)
+	cache.Restore(missing)
+	restored, ok := cache.Get("default/a")
+	if !ok || restored.Hash != 1 || restored.Sequence != 1 || !reflect.DeepEqual(restored.Subs, fields.Subscribers{"sub-1": {}}) {
+		t.Errorf("expected the entry to be restored, got %+v", restored)
+	}
+	if cache.Has("default/b") {
+		t.Errorf("expected the entry added after the snapshot to be deleted")
+	}
+	if keys := cache.KeysPerNode("node-1"); !reflect.DeepEqual(keys, []string{"default/a"}) {
+		t.Errorf("expected the item to be related to node-1 again, got %v", keys)
+	}
+	if keys := cache.KeysPerNode("node-2"); len(keys) != 0 {
+		t.Errorf("expected no items on node-2, got %v", keys)
+	}
+
+	// A deleted entry is added back.
+	cache.Delete("default/a")
+	cache.Restore(snapshot)
+	if _, ok := cache.Get("default/a"); !ok {
+		t.Errorf("expected the deleted entry to be restored")
+	}
+}
+
+func TestCacheDeleteIf(t *testing.T) {
+	cache := NewCache()
+	for _, key := range []string{"doomed/b", "doomed/a", "other/a"} {
+		cache.Add(key, &CacheEntry{UID: types.UID(key)})
+		cache.AddNodes(key, "node")
+	}
+
+	deleted := cache.DeleteIf(func(key string) bool {
+		return NameFromKey(key).Namespace == "doomed"
+	})
+	var keys []string
+	for _, snapshot := range deleted {
+		keys = append(keys, snapshot.Key())
+		if snapshot.Entry().UID != types.UID(snapshot.Key()) {
+			t.Errorf("unexpected entry %+v for %s", snapshot.Entry(), snapshot.Key())
+		}
+	}
+	if !reflect.DeepEqual(keys, []string{"doomed/a", "doomed/b"}) {
+		t.Errorf("expected the items of the namespace to be deleted in the order of their keys, got %v", keys)
+	}
+	if got := cache.KeysPerNode("node"); !reflect.DeepEqual(got, []string{"other/a"}) {
+		t.Errorf("expected only other/a to be left, got %v", got)
+	}
+
+	// The deleted items can be restored.
+	cache.Restore(deleted[0])
+	if nodes, ok := cache.NodesOf("doomed/a"); !ok || !reflect.DeepEqual(nodes, []string{"node"}) {
+		t.Errorf("expected doomed/a to be restored, got %v", nodes)
+	}
+}
+
+func TestCacheGauges(t *testing.T) {
+	cache := NewCache(WithName("gauges-test"))
+	entries := cacheEntries.WithLabelValues("gauges-test")
+	memberships := cacheMemberships.WithLabelValues("gauges-test")
+
+	steps := []struct {
+		name        string
+		mutate      func()
+		entries     float64
+		memberships float64
+	}{
+		{"add", func() { cache.Add("a", &CacheEntry{}) }, 1, 0},
+		{"add existing", func() { cache.Add("a", &CacheEntry{}) }, 1, 0},
+		{"update new", func() { cache.Update("b", &CacheEntry{}) }, 2, 0},
+		{"update existing", func() { cache.Update("b", &CacheEntry{}) }, 2, 0},
+		{"add nodes", func() { cache.AddNodes("a", "node-1", "node-2") }, 2, 2},
+		{"add related node", func() { cache.AddNodes("a", "node-1") }, 2, 2},
+		{"add nodes other item", func() { cache.AddNodes("b", "node-2") }, 2, 3},
+		{"delete node", func() { cache.DeleteNodes("a", "node-2") }, 2, 2},
+		{"delete unrelated node", func() { cache.DeleteNodes("a", "node-3") }, 2, 2},
+		{"delete item", func() { cache.Delete("a") }, 1, 1},
+		{"delete missing item", func() { cache.Delete("a") }, 1, 1},
+		{"delete all nodes", func() { cache.DeleteNodes("b") }, 1, 0},
+		{"delete last item", func() { cache.Delete("b") }, 0, 0},
+	}
+	for _, step := range steps {
+		step.mutate()
+		if got := testutil.ToFloat64(entries); got != step.entries {
+			t.Errorf("%s: expected %v entries, got %v", step.name, step.entries, got)
+		}
+		if got := testutil.ToFloat64(memberships); got != step.memberships {
+			t.Errorf("%s: expected %v node memberships, got %v", step.name, step.memberships, got)
+		}
+	}
+}
+
+// BenchmarkCacheReplay compares the cost of finding the items to replay to a new subscriber of a node through the node
+// index, against going through the whole cache.
+func BenchmarkCacheReplay(b *testing.B) {
+	const items, nodes = 50000, 500
+	cache := NewCache()
+	itemNodes := make(map[string]string, items)
+	for i := 0; i < items; i++ {
+		key := fmt.Sprintf("default/item-%d", i)
+		node := fmt.Sprintf("node-%d", i%nodes)
+		cache.Add(key, &CacheEntry{})
+		cache.AddNodes(key, node)
+		itemNodes[key] = node
+	}
+
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if keys := cache.KeysPerNode(fmt.Sprintf("node-%d", i%nodes)); len(keys) != items/nodes {
+				b.Fatalf("expected %d items, got %d", items/nodes, len(keys))
+			}
+		}
+	})
+
+	b.Run("whole-cache", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			node := fmt.Sprintf("node-%d", i%nodes)
+			var keys []string
+			for _, key := range cache.Keys() {
+				if itemNodes[key] == node {
+					keys = append(keys, key)
+				}
+			}
+			if len(keys) != items/nodes {
+				b.Fatalf("expected %d items, got %d", items/nodes, len(keys))
+			}
+		}
+	})
+}
+
+func TestCacheList(t *testing.T) {
+	cache := NewCache()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("Pod/default/pod-%d", i)
+		entry := &CacheEntry{UID: types.UID(fmt.Sprintf("uid-%d", i))}
+		cache.Add(key, entry)
+		cache.SetSubscribers(entry, fields.Subscribers{"sub-b": {}, "sub-a": {}})
+		cache.AddNodes(key, fmt.Sprintf("node-%d", i%2))
+	}
+	cache.Add("Pod/other/pod", &CacheEntry{})
+	keys := func(items []CacheItem) []string {
+		var keys []string
+		for i := range items {
+			keys = append(keys, items[i].Key)
+		}
+		return keys
+	}
+
+	// The pages follow each other until the last one.
+	var listed []string
+	var next string
+	for page := 0; page < 3; page++ {
+		var items []CacheItem
+		items, next = cache.List("", next, 2, nil)
+		listed = append(listed, keys(items)...)
+		if next == "" {
+			break
+		}
+	}
+	expected := []string{"Pod/default/pod-0", "Pod/default/pod-1", "Pod/default/pod-2", "Pod/default/pod-3",
+		"Pod/default/pod-4", "Pod/other/pod"}
+	if next != "" || !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected the pages to list %v, got %v with continue %q", expected, listed, next)
+	}
+
+	items, _ := cache.List("node-1", "", 0, func(key string) bool { return NameFromKey(key).Namespace == "default" })
+	if got := keys(items); !reflect.DeepEqual(got, []string{"Pod/default/pod-1", "Pod/default/pod-3"}) {
+		t.Errorf("expected the items on node-1, got %v", got)
+	}
+	if items[0].UID != "uid-1" || !reflect.DeepEqual(items[0].Nodes, []string{"node-1"}) ||
+		!reflect.DeepEqual(items[0].Subscribers, []string{"sub-a", "sub-b"}) {
+		t.Errorf("unexpected item %+v", items[0])
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"gomodules.xyz/jsonpatch/v2"
+)
+
+// diffMeta returns the keys of the labels and of the annotations changed between the old and the new metadata, or
+// nil if none changed.
+func diffMeta(oldLabels, oldAnnotations, newLabels, newAnnotations map[string]string) *metadata.MetaDiff {
+	labels := diffKeys(oldLabels, newLabels)
+	annotations := diffKeys(oldAnnotations, newAnnotations)
+	if labels == nil && annotations == nil {
+		return nil
+	}
+	return &metadata.MetaDiff{Labels: labels, Annotations: annotations}
+}
+
+// diffKeys returns the keys added, removed and changed between the old and the new map, or nil if none changed.
+func diffKeys(old, updated map[string]string) *metadata.KeysDiff {
+	var diff metadata.KeysDiff
+	for key, value := range updated {
+		oldValue, ok := old[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case oldValue != value:
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := updated[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		return nil
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return &diff
+}
+
+// patchMeta returns the RFC 6902 JSON patch turning the old metadata into the new ones, both serialized in JSON. It
+// returns nil if the old metadata are not known, the patch can not be computed or it is not smaller than the new
+// metadata, since the subscribers are better off with the full ones.
+func patchMeta(old, updated string) *string {
+	if old == "" || updated == "" {
+		return nil
+	}
+	ops, err := jsonpatch.CreatePatch([]byte(old), []byte(updated))
+	if err != nil {
+		return nil
+	}
+	if ops == nil {
+		ops = []jsonpatch.Operation{}
+	}
+	data, err := json.Marshal(ops)
+	if err != nil || len(data) >= len(updated) {
+		return nil
+	}
+	patch := string(data)
+	return &patch
+}
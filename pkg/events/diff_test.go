@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMetaDiff(t *testing.T) {
+	previous := map[string]string{"app": "web", "tier": "frontend", "version": "v1"}
+	testCases := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expected    *metadata.MetaDiff
+	}{
+		{
+			name:     "unchanged",
+			labels:   map[string]string{"app": "web", "tier": "frontend", "version": "v1"},
+			expected: nil,
+		},
+		{
+			name:   "label added",
+			labels: map[string]string{"app": "web", "tier": "frontend", "version": "v1", "team": "a"},
+			expected: &metadata.MetaDiff{
+				Labels: &metadata.KeysDiff{Added: []string{"team"}},
+			},
+		},
+		{
+			name:   "labels removed",
+			labels: map[string]string{"app": "web"},
+			expected: &metadata.MetaDiff{
+				Labels: &metadata.KeysDiff{Removed: []string{"tier", "version"}},
+			},
+		},
+		{
+			name:   "label changed",
+			labels: map[string]string{"app": "web", "tier": "frontend", "version": "v2"},
+			expected: &metadata.MetaDiff{
+				Labels: &metadata.KeysDiff{Changed: []string{"version"}},
+			},
+		},
+		{
+			name:        "labels and annotations",
+			labels:      map[string]string{"app": "api", "version": "v1", "team": "a"},
+			annotations: map[string]string{"owner": "team-a"},
+			expected: &metadata.MetaDiff{
+				Labels:      &metadata.KeysDiff{Added: []string{"team"}, Removed: []string{"tier"}, Changed: []string{"app"}},
+				Annotations: &metadata.KeysDiff{Added: []string{"owner"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := NewResource(resource.Deployment, "uid")
+			res.SetObjectMeta(&metav1.ObjectMeta{Name: "web", Labels: tc.labels, Annotations: tc.annotations}, "")
+			res.SetSubscribers(fields.Subscribers{"kept": {}})
+			res.SetUpdate(true)
+			res.SetPreviousMeta("", previous, nil)
+			res.GenerateSubscribers(fields.Subscribers{"kept": {}, "added": {}})
+
+			evts := res.ToEvents()
+			if evts[0] == nil || evts[1] == nil {
+				t.Fatalf("expected a %s and an %s event", Create, Update)
+			}
+			if diff := evts[0].GRPCMessage().GetMetaDiff(); diff != nil {
+				t.Errorf("expected no diff in the %s event, got %v", Create, diff)
+			}
+			if diff := evts[1].GRPCMessage().GetMetaDiff(); !proto.Equal(diff, tc.expected) {
+				t.Errorf("expected diff %v, got %v", tc.expected, diff)
+			}
+		})
+	}
+}
+
+func TestPatchMeta(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// randomMap returns a map with up to the given number of random entries.
+	randomMap := func(n int) map[string]string {
+		m := make(map[string]string)
+		for i := rnd.Intn(n + 1); i > 0; i-- {
+			m[fmt.Sprintf("key-%d", rnd.Intn(2*n))] = strings.Repeat("v", rnd.Intn(64))
+		}
+		return m
+	}
+	// mutate returns a copy of the map with random entries added, removed and changed.
+	mutate := func(m map[string]string) map[string]string {
+		mutated := maps.Clone(m)
+		for key := range mutated {
+			switch rnd.Intn(4) {
+			case 0:
+				delete(mutated, key)
+			case 1:
+				mutated[key] += "-changed"
+			}
+		}
+		for i := rnd.Intn(3); i > 0; i-- {
+			mutated[fmt.Sprintf("added-%d", rnd.Intn(100))] = "new"
+		}
+		return mutated
+	}
+	marshal := func(meta *metav1.ObjectMeta) string {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	patched := 0
+	for i := 0; i < 500; i++ {
+		old := &metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid", Labels: randomMap(10),
+			Annotations: randomMap(5)}
+		updated := old.DeepCopy()
+		updated.Labels = mutate(old.Labels)
+		updated.Annotations = mutate(old.Annotations)
+		if rnd.Intn(4) == 0 {
+			updated.Finalizers = []string{"finalizer"}
+		}
+		oldMeta, updatedMeta := marshal(old), marshal(updated)
+
+		patch := patchMeta(oldMeta, updatedMeta)
+		if patch == nil {
+			continue
+		}
+		patched++
+		if len(*patch) >= len(updatedMeta) {
+			t.Errorf("expected the patch %s to be smaller than the metadata %s", *patch, updatedMeta)
+		}
+		decoded, err := jsonpatch.DecodePatch([]byte(*patch))
+		if err != nil {
+			t.Fatalf("unable to decode the patch %s: %v", *patch, err)
+		}
+		applied, err := decoded.Apply([]byte(oldMeta))
+		if err != nil {
+			t.Fatalf("unable to apply the patch %s to %s: %v", *patch, oldMeta, err)
+		}
+		if !jsonpatch.Equal(applied, []byte(updatedMeta)) {
+			t.Fatalf("expected the patch %s to turn %s into %s, got %s", *patch, oldMeta, updatedMeta, applied)
+		}
+	}
+	if patched == 0 {
+		t.Error("expected some metadata to be patched")
+	}
+
+	// The full metadata are sent when the previous ones are not known or the patch would not be smaller.
+	if patch := patchMeta("", `{"name":"web"}`); patch != nil {
+		t.Errorf("expected no patch without the previous metadata, got %s", *patch)
+	}
+	if patch := patchMeta(`{"name":"web"}`, `{"name":"api"}`); patch != nil {
+		t.Errorf("expected no patch larger than the metadata, got %s", *patch)
+	}
+}
+
+func TestMetaPatchInUpdate(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"web","labels":{"app":"web","version":"v2"},"annotations":{"owner":"team-a"}}`)
+	res.SetSubscribers(fields.Subscribers{"kept": {}})
+	res.SetUpdate(true)
+	res.SetPreviousMeta(`{"name":"web","labels":{"app":"web","version":"v1"},"annotations":{"owner":"team-a"}}`, nil, nil)
+	res.GenerateSubscribers(fields.Subscribers{"kept": {}, "added": {}})
+
+	evts := res.ToEvents()
+	if patch := evts[0].GRPCMessage().MetaPatch; patch != nil {
+		t.Errorf("expected no patch in the %s event, got %s", Create, *patch)
+	}
+	want := `[{"op":"replace","path":"/labels/version","value":"v2"}]`
+	if patch := evts[1].GRPCMessage().GetMetaPatch(); patch != want {
+		t.Errorf("expected patch %s in the %s event, got %s", want, Update, patch)
+	}
+	if evts[1].GRPCMessage().GetMeta() == "" {
+		t.Errorf("expected the %s event to carry the full metadata too", Update)
+	}
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import "fmt"
+
+// SerializationError is returned when the fields of a resource, or the event carrying them, can not be serialized.
+// Retrying does not help until the resource changes.
+type SerializationError struct {
+	// Kind is the kind of the resource.
+	Kind string
+	// Key identifies the resource, e.g. its namespaced name or its UID.
+	Key string
+	Err error
+}
+
+// Error returns the error in string format.
+func (e *SerializationError) Error() string {
+	return fmt.Sprintf("unable to serialize %s %q: %v", e.Kind, e.Key, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *SerializationError) Unwrap() error {
+	return e.Err
+}
@@ -16,10 +16,14 @@
 package events
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/falcosecurity/k8s-metacollector/metadata"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
@@ -29,14 +33,119 @@ const (
 	Update = "Update"
 	// Delete possible type for an event.
 	Delete = "Delete"
+	// SnapshotComplete type of the event sent to a new subscriber once it has received all the existing resources.
+	// The following events are incremental.
+	SnapshotComplete = "SnapshotComplete"
+	// ResyncStart type of the event sent to a subscriber that asked for a resync, before the existing resources. They
+	// are followed by a SnapshotComplete event, the resources not received in between are gone.
+	ResyncStart = "ResyncStart"
+	// Refresh type of the keepalive event sent for a resource before the TTL of its metadata expires. It carries no
+	// metadata, it extends the TTL of the metadata received with the previous events.
+	Refresh = "Refresh"
+	// DeleteNamespace type of the event sent when a namespace is deleted, in place of the Delete events of its
+	// resources, to the subscribers that negotiated it. The subscriber drops all the resources of the namespace.
+	DeleteNamespace = "DeleteNamespace"
 )
 
+// MetaSchemaVersion is the version of the schema followed by the meta field of the events. It must be bumped
+// each time the metadata fields stripped or projected by the collectors change, so that subscribers can
+// branch on it. History:
+//
+//	1: object metadata without the creationTimestamp and ownerReferences fields.
+//	2: the clusterName field is set when the collector has been configured with a cluster name.
+//	3: the metadata of the pods carry the nodeLabels field when the collector has been configured with node label
+//	   keys, the spec of the ExternalName and headless services their type, externalName and clusterIP fields, and
+//	   the flat encoder serializes the metadata as a single object of dotted keys.
+const MetaSchemaVersion uint32 = 3
+
 var _ Interface = &Event{}
 
 // Event generated for watched kubernetes resources.
 type Event struct {
 	*metadata.Event
 	Subs fields.Subscribers
+	// spanContext of the reconcile that generated the event.
+	spanContext trace.SpanContext
+	// createdAt is the time at which the event has been generated.
+	createdAt time.Time
+	// origin of the event, empty for the events not generated by the collectors.
+	origin Origin
+	// cascaded is set on the Delete events of the resources deleted with their namespace.
+	cascaded bool
+}
+
+// Origin identifies the collector and the resource that generated an event.
+type Origin struct {
+	Collector string
+	Name      types.NamespacedName
+}
+
+// NewSnapshotComplete returns the SnapshotComplete event for the subscriber with the given UID and node, sent by the
+// collector of the given cluster. The event carries the UID of the subscriber and, in its metadata, the node.
+func NewSnapshotComplete(uid, node, cluster string) *Event {
+	meta, _ := json.Marshal(map[string]string{"node": node})
+	m := string(meta)
+	return &Event{
+		Event: &metadata.Event{
+			Reason:            SnapshotComplete,
+			Uid:               uid,
+			Meta:              &m,
+			MetaSchemaVersion: MetaSchemaVersion,
+			ObjectMeta:        &metadata.ObjectMeta{Node: node},
+			Cluster:           cluster,
+		},
+		Subs:      fields.Subscribers{uid: struct{}{}},
+		createdAt: time.Now(),
+	}
+}
+
+// NewResyncStart returns the ResyncStart event for the subscriber with the given UID and node, sent by the collector
+// of the given cluster. As the SnapshotComplete event, it carries the UID of the subscriber and, in its metadata, the
+// node.
+func NewResyncStart(uid, node, cluster string) *Event {
+	evt := NewSnapshotComplete(uid, node, cluster)
+	evt.Reason = ResyncStart
+	return evt
+}
+
+// NewRefresh returns the Refresh event of the resource with the given kind and UID for the given subscribers, sent by
+// the collector of the given cluster. It extends the TTL of the metadata of the resource by the given duration.
+func NewRefresh(kind, uid string, subs fields.Subscribers, ttl time.Duration, cluster string) *Event {
+	return &Event{
+		Event: &metadata.Event{
+			Reason:     Refresh,
+			Uid:        uid,
+			Kind:       kind,
+			Cluster:    cluster,
+			TtlSeconds: ttlSeconds(ttl),
+		},
+		Subs:      subs,
+		createdAt: time.Now(),
+	}
+}
+
+// NewDeleteNamespace returns the DeleteNamespace event of the namespace with the given name and UID for the given
+// subscribers, sent by the collector of the given cluster. It carries no kind, so that it is sent to the subscribers
+// whatever the kinds they filtered.
+func NewDeleteNamespace(namespace, uid string, subs fields.Subscribers, cluster string) *Event {
+	return &Event{
+		Event: &metadata.Event{
+			Reason:    DeleteNamespace,
+			Uid:       uid,
+			Cluster:   cluster,
+			Namespace: namespace,
+		},
+		Subs:      subs,
+		createdAt: time.Now(),
+	}
+}
+
+// ttlSeconds returns the TTL in seconds, rounded up so that a TTL shorter than a second is not sent as no TTL.
+func ttlSeconds(ttl time.Duration) uint32 {
+	if ttl <= 0 {
+		return 0
+	}
+	return uint32((ttl + time.Second - 1) / time.Second)
 }
 
 // Subscribers returns the destination nodes.
@@ -64,3 +173,25 @@ func (ge *Event) ResourceKind() string {
 func (ge *Event) GRPCMessage() *metadata.Event {
 	return ge.Event
 }
+
+// CreatedAt returns the time at which the event has been generated. It is zero for the events not generated by
+// the collectors.
+func (ge *Event) CreatedAt() time.Time {
+	return ge.createdAt
+}
+
+// SpanContext returns the span context of the reconcile that generated the event.
+func (ge *Event) SpanContext() trace.SpanContext {
+	return ge.spanContext
+}
+
+// Origin returns the collector and the name of the resource that generated the event.
+func (ge *Event) Origin() Origin {
+	return ge.origin
+}
+
+// Cascaded returns whether the event is the Delete of a resource deleted with its namespace, replaced by the
+// DeleteNamespace event for the subscribers that negotiated it.
+func (ge *Event) Cascaded() bool {
+	return ge.cascaded
+}
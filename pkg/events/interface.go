@@ -16,8 +16,11 @@
 package events
 
 import (
+	"time"
+
 	"github.com/falcosecurity/k8s-metacollector/metadata"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Interface must be satisfied by events generated for each supported k8s resource.
@@ -29,4 +32,7 @@ type Interface interface {
 	Type() string
 	ResourceKind() string
 	GRPCMessage() *metadata.Event
+	SpanContext() trace.SpanContext
+	CreatedAt() time.Time
+	Origin() Origin
 }
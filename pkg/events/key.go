@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// KeyFunc returns the key used to store in the cache the resource of the given kind and name.
+type KeyFunc func(kind string, name types.NamespacedName) string
+
+// KindKey returns a key in the form kind/namespace/name. It is the default key of the cache, and allows a single
+// cache to hold resources of different kinds without collisions.
+func KindKey(kind string, name types.NamespacedName) string {
+	return kind + "/" + name.String()
+}
+
+// NameKey returns a key in the form namespace/name. It can be used when the cache holds a single kind of resources.
+func NameKey(_ string, name types.NamespacedName) string {
+	return name.String()
+}
+
+// NameFromKey returns the namespaced name of the resource stored with the given key. It supports the keys returned
+// by both KindKey and NameKey: the name and the namespace are the last two segments of the key.
+func NameFromKey(key string) types.NamespacedName {
+	rest, name, found := cutLast(key)
+	if !found {
+		return types.NamespacedName{Name: key}
+	}
+	_, namespace, _ := cutLast(rest)
+	return types.NamespacedName{Namespace: namespace, Name: name}
+}
+
+// KindFromKey returns the kind of the resource stored with the given key, if the key has been returned by KindKey.
+// It returns an empty string for the keys returned by NameKey.
+func KindFromKey(key string) string {
+	rest, _, found := cutLast(key)
+	if !found {
+		return ""
+	}
+	kind, _, found := cutLast(rest)
+	if !found {
+		return ""
+	}
+	return kind
+}
+
+// cutLast slices s around the last separator, returning the text before and after it.
+func cutLast(s string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return "", s, false
+}
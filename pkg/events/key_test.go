@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCacheKindKey(t *testing.T) {
+	name := types.NamespacedName{Namespace: "default", Name: "app"}
+	cache := NewCache()
+	cache.Add(cache.Key("Deployment", name), &CacheEntry{UID: "deployment-uid"})
+	cache.Add(cache.Key("ReplicaSet", name), &CacheEntry{UID: "replicaset-uid"})
+
+	// Resources of different kinds with the same name do not collide.
+	for kind, uid := range map[string]types.UID{"Deployment": "deployment-uid", "ReplicaSet": "replicaset-uid"} {
+		entry, ok := cache.Get(cache.Key(kind, name))
+		if !ok || entry.UID != uid {
+			t.Errorf("expected the %s to be cached with uid %q, got %v", kind, uid, entry)
+		}
+	}
+	cache.Delete(cache.Key("Deployment", name))
+	if !cache.Has(cache.Key("ReplicaSet", name)) {
+		t.Error("expected the replicaset to survive the deletion of the deployment")
+	}
+
+	// Keyed by name only, the resources collide.
+	cache = NewCache(WithKeyFunc(NameKey))
+	if cache.Key("Deployment", name) != cache.Key("ReplicaSet", name) {
+		t.Error("expected the name keys to ignore the kind")
+	}
+}
+
+func TestNameFromKey(t *testing.T) {
+	tests := map[string]types.NamespacedName{
+		"Pod/default/app": {Namespace: "default", Name: "app"},
+		"Namespace//app":  {Name: "app"},
+		"default/app":     {Namespace: "default", Name: "app"},
+		"/app":            {Name: "app"},
+		"app":             {Name: "app"},
+	}
+	for key, want := range tests {
+		if got := NameFromKey(key); got != want {
+			t.Errorf("key %q: expected %v, got %v", key, want, got)
+		}
+	}
+	name := types.NamespacedName{Namespace: "default", Name: "app"}
+	for _, keyFunc := range []KeyFunc{KindKey, NameKey} {
+		if got := NameFromKey(keyFunc("Pod", name)); got != name {
+			t.Errorf("expected %v, got %v", name, got)
+		}
+	}
+}
+
+func TestKindFromKey(t *testing.T) {
+	tests := map[string]string{
+		"Pod/default/app": "Pod",
+		"Namespace//app":  "Namespace",
+		"default/app":     "",
+		"app":             "",
+	}
+	for key, want := range tests {
+		if got := KindFromKey(key); got != want {
+			t.Errorf("key %q: expected %q, got %q", key, want, got)
+		}
+	}
+}
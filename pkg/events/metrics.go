@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	cacheSubsystem     = "cache"
+	cacheEntriesKey    = "entries"
+	cacheMembershipKey = "node_memberships"
+)
+
+var (
+	// cacheEntries is a prometheus gauge which holds the number of items in the cache of each collector.
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: consts.MetricsNamespace,
+		Subsystem: cacheSubsystem,
+		Name:      cacheEntriesKey,
+		Help:      "Number of items in the cache per collector.",
+	}, []string{"collector"})
+
+	// cacheMemberships is a prometheus gauge which holds the number of relations between the items in the cache of
+	// each collector and the nodes, i.e. the size of the node index of the cache.
+	cacheMemberships = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: consts.MetricsNamespace,
+		Subsystem: cacheSubsystem,
+		Name:      cacheMembershipKey,
+		Help:      "Number of relations between the items in the cache and the nodes per collector.",
+	}, []string{"collector"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(cacheEntries)
+	ctrlmetrics.Registry.MustRegister(cacheMemberships)
+}
@@ -16,9 +16,15 @@
 package events
 
 import (
+	"hash/fnv"
+	"time"
+
 	"github.com/falcosecurity/k8s-metacollector/metadata"
 	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // Resource event that holds metadata fields for k8s resources.
@@ -27,9 +33,18 @@ import (
 type Resource struct {
 	Kind   string
 	UID    string
-	Meta   string
+	Meta   string `hash:"ignore"`
 	Spec   string
 	Status string
+	// Hash of the metadata on which their changes are detected in place of Meta, since the encoders can serialize
+	// the same metadata differently.
+	MetaHash uint64
+	// Structured metadata, sent to the subscribers that chose the protobuf encoding.
+	Name        string
+	Namespace   string
+	Node        string
+	Labels      map[string]string
+	Annotations map[string]string
 	// Only used when storing metadata for pods.
 	ResourceReferences fields.References
 	// Tracks the nodes to which we have already sent the resource.
@@ -40,6 +55,27 @@ type Resource struct {
 	updatedFor fields.Subscribers `hash:"ignore"`
 	deletedFor fields.Subscribers `hash:"ignore"`
 	updated    bool               `hash:"ignore"`
+	// Span context of the reconcile that generated the resource, propagated to the events.
+	spanContext trace.SpanContext `hash:"ignore"`
+	// Name of the cluster the resource belongs to, stamped on the events.
+	cluster string `hash:"ignore"`
+	// Collector and name of the resource that generated the events.
+	origin Origin `hash:"ignore"`
+	// Keys of the labels and annotations changed since the previous version, attached to the Update events.
+	metaDiff *metadata.MetaDiff `hash:"ignore"`
+	// Metadata of the previous version, from which the patch attached to the Update events is computed.
+	previousMeta string `hash:"ignore"`
+	// Nodes the resource started and stopped relating to since the previous version, attached to the Update events.
+	addedNodes   []string `hash:"ignore"`
+	removedNodes []string `hash:"ignore"`
+	// Sequence of the change of the resource, stamped on the Create, Update and Delete events.
+	sequence uint64 `hash:"ignore"`
+	// Set when labels or annotations have been dropped from the metadata to fit the maximum size.
+	metaTruncated bool `hash:"ignore"`
+	// TTL of the metadata stamped on the Create and Update events, zero if the metadata never expire.
+	ttl time.Duration `hash:"ignore"`
+	// Set when the resource is deleted with its namespace, flagged in its Delete events.
+	cascaded bool `hash:"ignore"`
 }
 
 // NewResource returns a new Resource.
@@ -67,9 +103,28 @@ func (g *Resource) GetStatus() string {
 }
 
 // SetMeta sets the Meta field if different from the existing one.
-// It also sets to true the "updated" internal variable.
+// It also sets to true the "updated" internal variable. The hash of the metadata is the one of the serialized form,
+// until SetMetaHash sets the one of their canonical form.
 func (g *Resource) SetMeta(meta string) {
 	g.Meta = meta
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(meta))
+	g.MetaHash = h.Sum64()
+}
+
+// SetMetaHash sets the hash of the canonical form of the metadata, on which their changes are detected.
+func (g *Resource) SetMetaHash(hash uint64) {
+	g.MetaHash = hash
+}
+
+// SetObjectMeta sets the structured metadata fields from the metadata of the object. The node is set only for the
+// resources bound to a node, i.e. pods.
+func (g *Resource) SetObjectMeta(meta *metav1.ObjectMeta, node string) {
+	g.Name = meta.Name
+	g.Namespace = meta.Namespace
+	g.Node = node
+	g.Labels = meta.Labels
+	g.Annotations = meta.Annotations
 }
 
 // SetSpec sets the Spec field if different from the existing one.
@@ -84,6 +139,53 @@ func (g *Resource) SetStatus(status string) {
 	g.Status = status
 }
 
+// SetCluster sets the name of the cluster stamped on the events of the resource.
+func (g *Resource) SetCluster(cluster string) {
+	g.cluster = cluster
+}
+
+// SetOrigin sets the collector and the name of the resource that generated the events of the resource.
+func (g *Resource) SetOrigin(collector string, name types.NamespacedName) {
+	g.origin = Origin{Collector: collector, Name: name}
+}
+
+// SetPreviousMeta computes the changes of the labels and annotations of the resource with respect to the given ones,
+// i.e. the ones of the previous version, and attaches them to the Update events. The serialized metadata of the
+// previous version are kept to attach to the Update events the patch turning them into the current ones.
+func (g *Resource) SetPreviousMeta(meta string, labels, annotations map[string]string) {
+	g.metaDiff = diffMeta(labels, annotations, g.Labels, g.Annotations)
+	g.previousMeta = meta
+}
+
+// SetNodeDelta sets the nodes the resource started and stopped relating to since the previous version, attached to
+// the Update events. The changes of the nodes alone do not make the resource updated.
+func (g *Resource) SetNodeDelta(added, removed []string) {
+	g.addedNodes = added
+	g.removedNodes = removed
+}
+
+// SetSequence sets the sequence numbering the change of the resource, stamped on its Create, Update and Delete events.
+func (g *Resource) SetSequence(sequence uint64) {
+	g.sequence = sequence
+}
+
+// SetMetaTruncated records whether labels or annotations have been dropped from the metadata of the resource, flagged
+// in its Create and Update events.
+func (g *Resource) SetMetaTruncated(truncated bool) {
+	g.metaTruncated = truncated
+}
+
+// SetTTL sets the time after which the subscribers should consider stale the metadata sent in the Create and Update
+// events of the resource, if no other event is received for it. A zero value means that the metadata never expire.
+func (g *Resource) SetTTL(ttl time.Duration) {
+	g.ttl = ttl
+}
+
+// SetCascaded records whether the resource is deleted with its namespace, flagged in its Delete events.
+func (g *Resource) SetCascaded(cascaded bool) {
+	g.cascaded = cascaded
+}
+
 // SetUpdate sets the update flag for the resource.
 func (g *Resource) SetUpdate(update bool) {
 	g.updated = update
@@ -120,6 +222,11 @@ func (g *Resource) SetSubscribers(subs fields.Subscribers) {
 	g.subs = subs
 }
 
+// SetSpanContext sets the span context propagated to the events generated for the resource.
+func (g *Resource) SetSpanContext(sc trace.SpanContext) {
+	g.spanContext = sc
+}
+
 // GetResourceReferences returns refs.
 func (g *Resource) GetResourceReferences() fields.References {
 	return g.ResourceReferences
@@ -136,6 +243,7 @@ func (g *Resource) AddReferencesForKind(kind string, refs []fields.Reference) {
 // ToEvents returns a slice containing Interface based on the internal state of the Resource.
 func (g *Resource) ToEvents() []Interface {
 	evts := make([]Interface, 3)
+	now := time.Now()
 	var meta, spec, status *string
 	if g.Meta != "" {
 		m := g.Meta
@@ -153,15 +261,24 @@ func (g *Resource) ToEvents() []Interface {
 	if len(g.createdFor) != 0 {
 		evts[0] = &Event{
 			Event: &metadata.Event{
-				Reason: Create,
-				Uid:    g.UID,
-				Kind:   g.Kind,
-				Meta:   meta,
-				Spec:   spec,
-				Status: status,
-				Refs:   g.grpcRefs(),
+				Reason:            Create,
+				Uid:               g.UID,
+				Kind:              g.Kind,
+				Meta:              meta,
+				Spec:              spec,
+				Status:            status,
+				Refs:              g.grpcRefs(),
+				MetaSchemaVersion: MetaSchemaVersion,
+				ObjectMeta:        g.grpcObjectMeta(),
+				Cluster:           g.cluster,
+				MetaTruncated:     g.metaTruncated,
+				TtlSeconds:        ttlSeconds(g.ttl),
+				Sequence:          g.sequence,
 			},
-			Subs: g.createdFor,
+			Subs:        g.createdFor,
+			spanContext: g.spanContext,
+			createdAt:   now,
+			origin:      g.origin,
 		}
 		g.createdFor = nil
 	}
@@ -169,15 +286,28 @@ func (g *Resource) ToEvents() []Interface {
 	if len(g.updatedFor) != 0 {
 		evts[1] = &Event{
 			Event: &metadata.Event{
-				Reason: Update,
-				Uid:    g.UID,
-				Kind:   g.Kind,
-				Meta:   meta,
-				Spec:   spec,
-				Status: status,
-				Refs:   g.grpcRefs(),
+				Reason:            Update,
+				Uid:               g.UID,
+				Kind:              g.Kind,
+				Meta:              meta,
+				Spec:              spec,
+				Status:            status,
+				Refs:              g.grpcRefs(),
+				MetaSchemaVersion: MetaSchemaVersion,
+				ObjectMeta:        g.grpcObjectMeta(),
+				Cluster:           g.cluster,
+				MetaDiff:          g.metaDiff,
+				MetaPatch:         patchMeta(g.previousMeta, g.Meta),
+				AddedNodes:        g.addedNodes,
+				RemovedNodes:      g.removedNodes,
+				MetaTruncated:     g.metaTruncated,
+				TtlSeconds:        ttlSeconds(g.ttl),
+				Sequence:          g.sequence,
 			},
-			Subs: g.updatedFor,
+			Subs:        g.updatedFor,
+			spanContext: g.spanContext,
+			createdAt:   now,
+			origin:      g.origin,
 		}
 		g.updatedFor = nil
 	}
@@ -185,11 +315,17 @@ func (g *Resource) ToEvents() []Interface {
 	if len(g.deletedFor) != 0 {
 		evts[2] = &Event{
 			Event: &metadata.Event{
-				Reason: Delete,
-				Uid:    g.UID,
-				Kind:   g.Kind,
+				Reason:   Delete,
+				Uid:      g.UID,
+				Kind:     g.Kind,
+				Cluster:  g.cluster,
+				Sequence: g.sequence,
 			},
-			Subs: g.deletedFor,
+			Subs:        g.deletedFor,
+			spanContext: g.spanContext,
+			createdAt:   now,
+			origin:      g.origin,
+			cascaded:    g.cascaded,
 		}
 		g.deletedFor = nil
 	}
@@ -197,6 +333,20 @@ func (g *Resource) ToEvents() []Interface {
 	return evts
 }
 
+func (g *Resource) grpcObjectMeta() *metadata.ObjectMeta {
+	if g.Name == "" {
+		return nil
+	}
+
+	return &metadata.ObjectMeta{
+		Name:        g.Name,
+		Namespace:   g.Namespace,
+		Node:        g.Node,
+		Labels:      g.Labels,
+		Annotations: g.Annotations,
+	}
+}
+
 func (g *Resource) grpcRefs() *metadata.References {
 	if g.ResourceReferences != nil && g.Kind == resource.Pod {
 		// Converting the references to grpc message format.
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/fields"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestToEventsMetaSchemaVersion(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"test"}`)
+	res.GenerateSubscribers(fields.Subscribers{"sub": struct{}{}})
+
+	evts := res.ToEvents()
+	if evts[0] == nil {
+		t.Fatalf("expected a %s event", Create)
+	}
+
+	data, err := proto.Marshal(evts[0].GRPCMessage())
+	if err != nil {
+		t.Fatalf("unable to marshal event: %v", err)
+	}
+
+	var got metadata.Event
+	if err := proto.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unable to unmarshal event: %v", err)
+	}
+
+	if got.GetMetaSchemaVersion() != MetaSchemaVersion {
+		t.Errorf("expected meta schema version %d, got %d", MetaSchemaVersion, got.GetMetaSchemaVersion())
+	}
+}
+
+func TestGenerateSubscribers(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"test"}`)
+	res.SetSubscribers(fields.Subscribers{"kept": {}, "removed": {}})
+	res.SetUpdate(true)
+
+	subs := res.GenerateSubscribers(fields.Subscribers{"kept": {}, "added": {}})
+	if len(subs) != 2 {
+		t.Fatalf("expected 2 subscribers, got %v", subs)
+	}
+
+	expected := map[string]fields.Subscribers{
+		Create: {"added": {}},
+		Update: {"kept": {}},
+		Delete: {"removed": {}},
+	}
+	for _, evt := range res.ToEvents() {
+		if evt == nil {
+			t.Fatal("expected an event for each type")
+		}
+		if want := expected[evt.Type()]; !reflect.DeepEqual(evt.Subscribers(), want) {
+			t.Errorf("expected %s event for %v, got %v", evt.Type(), want, evt.Subscribers())
+		}
+	}
+}
+
+func TestGenerateSubscribersNoChanges(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetSubscribers(fields.Subscribers{"kept": {}})
+
+	res.GenerateSubscribers(fields.Subscribers{"kept": {}})
+	for _, evt := range res.ToEvents() {
+		if evt != nil {
+			t.Errorf("expected no events, got %s", evt.String())
+		}
+	}
+}
+
+func TestToEventsCreatedAt(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"test"}`)
+	res.GenerateSubscribers(fields.Subscribers{"sub": struct{}{}})
+
+	before := time.Now()
+	evts := res.ToEvents()
+	if evts[0] == nil {
+		t.Fatalf("expected a %s event", Create)
+	}
+	if created := evts[0].CreatedAt(); created.Before(before) || created.After(time.Now()) {
+		t.Errorf("expected the event to be stamped at generation time, got %s", created)
+	}
+}
+
+func TestToEventsTTL(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"test"}`)
+	res.SetTTL(1500 * time.Millisecond)
+	res.SetSubscribers(fields.Subscribers{"updated": struct{}{}, "deleted": struct{}{}})
+	res.SetUpdate(true)
+	res.GenerateSubscribers(fields.Subscribers{"created": struct{}{}, "updated": struct{}{}})
+
+	// The TTL is rounded up to the second on the events carrying the metadata.
+	evts := res.ToEvents()
+	for i, expected := range []uint32{2, 2, 0} {
+		if evts[i] == nil {
+			t.Fatalf("expected event %d to be generated", i)
+		}
+		if got := evts[i].GRPCMessage().GetTtlSeconds(); got != expected {
+			t.Errorf("expected a TTL of %ds for the %s event, got %ds", expected, evts[i].Type(), got)
+		}
+	}
+
+	refresh := NewRefresh(resource.Deployment, "uid", fields.Subscribers{"created": struct{}{}}, time.Minute, "cluster")
+	if msg := refresh.GRPCMessage(); msg.GetReason() != Refresh || msg.GetTtlSeconds() != 60 || msg.GetMeta() != "" ||
+		msg.GetCluster() != "cluster" {
+		t.Errorf("unexpected refresh event %v", msg)
+	}
+}
+
+func TestToEventsSequence(t *testing.T) {
+	res := NewResource(resource.Deployment, "uid")
+	res.SetMeta(`{"name":"test"}`)
+	res.SetSequence(3)
+	res.SetSubscribers(fields.Subscribers{"updated": struct{}{}, "deleted": struct{}{}})
+	res.SetUpdate(true)
+	res.GenerateSubscribers(fields.Subscribers{"created": struct{}{}, "updated": struct{}{}})
+
+	for _, evt := range res.ToEvents() {
+		if evt == nil {
+			t.Fatal("expected the Create, Update and Delete events to be generated")
+		}
+		if got := evt.GRPCMessage().GetSequence(); got != 3 {
+			t.Errorf("expected the %s event to carry sequence 3, got %d", evt.Type(), got)
+		}
+	}
+}
@@ -65,15 +65,19 @@ func (s Subscribers) Has(sub string) bool {
 	return ok
 }
 
-// Intersect returns the intersection with the given set.
+// Intersect returns the intersection with the given set. The set is allocated only if the
+// intersection is not empty, otherwise nil is returned.
 func (s Subscribers) Intersect(subs Subscribers) Subscribers {
-	intersection := make(Subscribers)
+	var intersection Subscribers
 	s1, s2 := s, subs
 	if len(s1) > len(s2) {
 		s1, s2 = s2, s1
 	}
 	for sub := range s1 {
 		if _, ok := s2[sub]; ok {
+			if intersection == nil {
+				intersection = make(Subscribers, len(s1))
+			}
 			intersection[sub] = struct{}{}
 		}
 	}
@@ -81,10 +85,14 @@ func (s Subscribers) Intersect(subs Subscribers) Subscribers {
 }
 
 // Difference returns the difference ( all the members of the initial set that are not members of the given set).
+// The set is allocated only if the difference is not empty, otherwise nil is returned.
 func (s Subscribers) Difference(subs Subscribers) Subscribers {
-	setDifference := make(Subscribers)
+	var setDifference Subscribers
 	for sub := range s {
 		if _, ok := subs[sub]; !ok {
+			if setDifference == nil {
+				setDifference = make(Subscribers, len(s))
+			}
 			setDifference[sub] = struct{}{}
 		}
 	}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fields
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscribersSetOperations(t *testing.T) {
+	tests := []struct {
+		name         string
+		s1, s2       Subscribers
+		intersection Subscribers
+		difference   Subscribers
+	}{
+		{
+			name:         "overlapping sets",
+			s1:           Subscribers{"a": {}, "b": {}},
+			s2:           Subscribers{"b": {}, "c": {}},
+			intersection: Subscribers{"b": {}},
+			difference:   Subscribers{"a": {}},
+		},
+		{
+			name:       "disjoint sets",
+			s1:         Subscribers{"a": {}},
+			s2:         Subscribers{"b": {}},
+			difference: Subscribers{"a": {}},
+		},
+		{
+			name:         "equal sets",
+			s1:           Subscribers{"a": {}},
+			s2:           Subscribers{"a": {}},
+			intersection: Subscribers{"a": {}},
+		},
+		{
+			name: "nil sets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s1.Intersect(tt.s2); len(got) != len(tt.intersection) ||
+				(len(got) != 0 && !reflect.DeepEqual(got, tt.intersection)) {
+				t.Errorf("expected intersection %v, got %v", tt.intersection, got)
+			}
+			if got := tt.s1.Difference(tt.s2); len(got) != len(tt.difference) ||
+				(len(got) != 0 && !reflect.DeepEqual(got, tt.difference)) {
+				t.Errorf("expected difference %v, got %v", tt.difference, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Barrier tracks the components that need to complete their initial sync before the meta collector
+// is ready to serve subscribers. Components are registered at creation time and marked as done
+// once their initial sync has completed. The barrier is lifted when no registered component is pending.
+type Barrier struct {
+	mutex   sync.RWMutex
+	pending map[string]struct{}
+	// lifted is closed the first time the barrier is lifted, created on demand by Lifted.
+	lifted chan struct{}
+}
+
+// NewBarrier returns a new Barrier with no pending components.
+func NewBarrier() *Barrier {
+	return &Barrier{
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Register adds a component to the barrier. The barrier is not lifted until Done is called for it.
+func (b *Barrier) Register(name string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.pending[name] = struct{}{}
+}
+
+// Done marks the component as synced.
+func (b *Barrier) Done(name string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.pending, name)
+	b.notifyLifted()
+}
+
+// Ready returns true if all the registered components are synced.
+func (b *Barrier) Ready() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.pending) == 0
+}
+
+// Pending returns the sorted names of the components that are not synced yet.
+func (b *Barrier) Pending() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	names := make([]string, 0, len(b.pending))
+	for name := range b.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lifted returns a channel closed once the barrier is lifted. The channel stays closed if components are registered
+// afterwards.
+func (b *Barrier) Lifted() <-chan struct{} {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.lifted == nil {
+		b.lifted = make(chan struct{})
+		b.notifyLifted()
+	}
+	return b.lifted
+}
+
+// notifyLifted closes the lifted channel if the barrier is lifted, the caller must hold the write lock.
+func (b *Barrier) notifyLifted() {
+	if b.lifted == nil || len(b.pending) != 0 {
+		return
+	}
+	select {
+	case <-b.lifted:
+	default:
+		close(b.lifted)
+	}
+}
+
+// Checker implements the healthz.Checker signature. It returns an error until the barrier is lifted.
+func (b *Barrier) Checker(_ *http.Request) error {
+	if pending := b.Pending(); len(pending) != 0 {
+		return fmt.Errorf("waiting for initial sync of: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBarrier(t *testing.T) {
+	b := NewBarrier()
+	if !b.Ready() {
+		t.Fatal("expected an empty barrier to be ready")
+	}
+
+	b.Register("pod-collector")
+	b.Register("service-collector")
+	if b.Ready() {
+		t.Fatal("expected the barrier not to be ready")
+	}
+	if err := b.Checker(nil); err == nil {
+		t.Fatal("expected the checker to fail while components are pending")
+	}
+	if got, want := b.Pending(), []string{"pod-collector", "service-collector"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected pending components %v, got %v", want, got)
+	}
+
+	b.Done("pod-collector")
+	b.Done("service-collector")
+	if !b.Ready() {
+		t.Fatal("expected the barrier to be ready")
+	}
+	if err := b.Checker(nil); err != nil {
+		t.Errorf("expected the checker to succeed, got %v", err)
+	}
+}
+
+func TestBarrierLifted(t *testing.T) {
+	isClosed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !isClosed(NewBarrier().Lifted()) {
+		t.Error("expected an empty barrier to be lifted")
+	}
+
+	b := NewBarrier()
+	b.Register("pod-collector")
+	b.Register("service-collector")
+	lifted := b.Lifted()
+	b.Done("pod-collector")
+	if isClosed(lifted) {
+		t.Fatal("expected the barrier not to be lifted while components are pending")
+	}
+	b.Done("service-collector")
+	if !isClosed(lifted) {
+		t.Fatal("expected the barrier to be lifted")
+	}
+
+	// The barrier is lifted once.
+	b.Register("node-collector")
+	if !isClosed(b.Lifted()) {
+		t.Error("expected the barrier to stay lifted")
+	}
+	b.Done("node-collector")
+}
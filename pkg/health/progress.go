@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress tracks a loop processing one item at a time, e.g. the dispatch loop of the broker. The loop is stuck when
+// an item has been in progress for longer than the threshold, i.e. the loop made no progress while it had work to do.
+// An idle loop is never stuck.
+//
+// A nil Progress is valid and tracks nothing.
+type Progress struct {
+	mutex     sync.Mutex
+	name      string
+	threshold time.Duration
+	// since is the start time of the item in progress. It is zero if there is none.
+	since time.Time
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// NewProgress returns a new Progress for the loop with the given name, using the given threshold. A non-positive
+// threshold makes the loop never stuck.
+func NewProgress(name string, threshold time.Duration) *Progress {
+	return &Progress{
+		name:      name,
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+// Start records the start of the processing of an item. It returns the function to be called once done.
+func (p *Progress) Start() func() {
+	if p == nil {
+		return func() {}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.since = p.now()
+
+	return func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.since = time.Time{}
+	}
+}
+
+// Checker implements the healthz.Checker signature. It returns an error if the loop is stuck.
+func (p *Progress) Checker(_ *http.Request) error {
+	if p == nil || p.threshold <= 0 {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.since.IsZero() && p.now().Sub(p.since) > p.threshold {
+		return fmt.Errorf("%s stuck since %s", p.name, p.since.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	now := time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC)
+	p := NewProgress("dispatch loop", time.Minute)
+	p.now = func() time.Time { return now }
+
+	// An idle loop is never stuck.
+	now = now.Add(time.Hour)
+	if err := p.Checker(nil); err != nil {
+		t.Fatalf("expected an idle loop not to be stuck, got %v", err)
+	}
+
+	done := p.Start()
+	now = now.Add(30 * time.Second)
+	if err := p.Checker(nil); err != nil {
+		t.Errorf("expected the loop not to be stuck within the threshold, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := p.Checker(nil); err == nil || !strings.Contains(err.Error(), "dispatch loop stuck") {
+		t.Errorf("expected the loop to be stuck, got %v", err)
+	}
+	done()
+	if err := p.Checker(nil); err != nil {
+		t.Errorf("expected the loop not to be stuck once done, got %v", err)
+	}
+
+	var nilProgress *Progress
+	nilProgress.Start()()
+	if err := nilProgress.Checker(nil); err != nil {
+		t.Errorf("expected a nil progress not to be stuck, got %v", err)
+	}
+}
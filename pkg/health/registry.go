@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry tracks the health of the components that reconcile resources, e.g. the collectors. For each component it
+// records the time of the last successful reconcile, the last error and the reconcile in progress, if any. A component
+// is unhealthy when a reconcile has been in progress for longer than the staleness threshold, or when its reconciles
+// have kept failing for longer than the threshold. An idle component is healthy.
+//
+// A nil Registry is valid and tracks nothing.
+type Registry struct {
+	mutex      sync.RWMutex
+	components map[string]*componentHealth
+	staleness  time.Duration
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// componentHealth holds the health of a component.
+type componentHealth struct {
+	lastSuccess   time.Time
+	lastError     error
+	lastErrorTime time.Time
+	// inFlightSince is the start time of the reconcile in progress. It is zero if there is none.
+	inFlightSince time.Time
+}
+
+// NewRegistry returns a new Registry using the given staleness threshold. A non-positive threshold makes all the
+// components always healthy.
+func NewRegistry(staleness time.Duration) *Registry {
+	return &Registry{
+		components: make(map[string]*componentHealth),
+		staleness:  staleness,
+		now:        time.Now,
+	}
+}
+
+// Register adds a component to the registry. The component is considered successful at registration time.
+func (r *Registry) Register(name string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.components[name] = &componentHealth{lastSuccess: r.now()}
+}
+
+// Start records the start of a reconcile of the component. It returns the function to be called with the outcome
+// of the reconcile once done.
+func (r *Registry) Start(name string) func(err error) {
+	if r == nil {
+		return func(error) {}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c := r.component(name)
+	c.inFlightSince = r.now()
+
+	return func(err error) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		c.inFlightSince = time.Time{}
+		if err != nil {
+			c.lastError = err
+			c.lastErrorTime = r.now()
+			return
+		}
+		c.lastSuccess = r.now()
+	}
+}
+
+// component returns the health of the component, registering it if needed. The caller must hold the write lock.
+func (r *Registry) component(name string) *componentHealth {
+	c, ok := r.components[name]
+	if !ok {
+		c = &componentHealth{lastSuccess: r.now()}
+		r.components[name] = c
+	}
+	return c
+}
+
+// Unhealthy returns the sorted descriptions of the unhealthy components.
+func (r *Registry) Unhealthy() []string {
+	if r == nil || r.staleness <= 0 {
+		return nil
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	now := r.now()
+	var unhealthy []string
+	for name, c := range r.components {
+		switch {
+		case !c.inFlightSince.IsZero() && now.Sub(c.inFlightSince) > r.staleness:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (reconcile stuck since %s)", name,
+				c.inFlightSince.Format(time.RFC3339)))
+		case c.lastErrorTime.After(c.lastSuccess) && now.Sub(c.lastSuccess) > r.staleness:
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (no successful reconcile since %s, last error: %v)", name,
+				c.lastSuccess.Format(time.RFC3339), c.lastError))
+		}
+	}
+	sort.Strings(unhealthy)
+	return unhealthy
+}
+
+// Checker implements the healthz.Checker signature. It returns an error if any component is unhealthy.
+func (r *Registry) Checker(_ *http.Request) error {
+	if unhealthy := r.Unhealthy(); len(unhealthy) != 0 {
+		return fmt.Errorf("unhealthy components: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
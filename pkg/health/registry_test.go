@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	now := time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRegistry(time.Minute)
+	r.now = func() time.Time { return now }
+
+	r.Register("pod-collector")
+	r.Register("service-collector")
+	if err := r.Checker(nil); err != nil {
+		t.Fatalf("expected registered components to be healthy, got %v", err)
+	}
+
+	// An idle component stays healthy.
+	now = now.Add(time.Hour)
+	if err := r.Checker(nil); err != nil {
+		t.Fatalf("expected idle components to be healthy, got %v", err)
+	}
+
+	// A failure is tolerated until the last success is older than the threshold.
+	r.Start("pod-collector")(errors.New("boom"))
+	if got := r.Unhealthy(); len(got) != 1 || !strings.Contains(got[0], "pod-collector") || !strings.Contains(got[0], "boom") {
+		t.Errorf("expected the failing pod-collector to be unhealthy, got %v", got)
+	}
+	r.Start("pod-collector")(nil)
+	now = now.Add(time.Second)
+	r.Start("pod-collector")(errors.New("boom"))
+	if err := r.Checker(nil); err != nil {
+		t.Errorf("expected a recent success to keep the component healthy, got %v", err)
+	}
+
+	// A reconcile in progress for longer than the threshold is stuck.
+	reconciled := r.Start("service-collector")
+	now = now.Add(2 * time.Minute)
+	got := r.Unhealthy()
+	if len(got) != 2 || !strings.Contains(got[1], "service-collector (reconcile stuck") {
+		t.Errorf("expected the service-collector to be stuck, got %v", got)
+	}
+	if err := r.Checker(nil); err == nil {
+		t.Error("expected the checker to fail with unhealthy components")
+	}
+
+	reconciled(nil)
+	r.Start("pod-collector")(nil)
+	if err := r.Checker(nil); err != nil {
+		t.Errorf("expected all the components to be healthy, got %v", err)
+	}
+}
+
+func TestRegistryDisabled(t *testing.T) {
+	var nilRegistry *Registry
+	nilRegistry.Register("pod-collector")
+	nilRegistry.Start("pod-collector")(errors.New("boom"))
+	if err := nilRegistry.Checker(nil); err != nil {
+		t.Errorf("expected a nil registry to be healthy, got %v", err)
+	}
+
+	r := NewRegistry(0)
+	r.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	r.Start("pod-collector")(errors.New("boom"))
+	r.now = time.Now
+	if err := r.Checker(nil); err != nil {
+		t.Errorf("expected a zero threshold to disable the check, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SyncStatus tracks the state of the components the readiness of the meta collector depends on. The collectors are
+// ready once their informer caches have synced and they have completed their initial reconcile sweep, i.e. each
+// object received before the caches synced has been reconciled at least once. The listeners, e.g. the broker, are
+// ready once they accept connections.
+//
+// A nil SyncStatus is valid and tracks nothing.
+type SyncStatus struct {
+	mutex      sync.RWMutex
+	collectors map[string]*collectorSync
+	listeners  map[string]bool
+}
+
+// collectorSync holds the sync state of a collector.
+type collectorSync struct {
+	cachesSynced bool
+	// pending holds the keys of the objects of the initial sweep not reconciled yet.
+	pending map[string]struct{}
+}
+
+// NewSyncStatus returns a new SyncStatus with no registered component.
+func NewSyncStatus() *SyncStatus {
+	return &SyncStatus{
+		collectors: make(map[string]*collectorSync),
+		listeners:  make(map[string]bool),
+	}
+}
+
+// RegisterCollector adds a collector to the sync status. It is not ready until its caches have synced and its
+// initial sweep has completed.
+func (s *SyncStatus) RegisterCollector(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.collectors[name] = &collectorSync{pending: make(map[string]struct{})}
+}
+
+// Seen records an object received by the collector. Until the caches of the collector have synced, the object is
+// part of the initial sweep and the collector is not ready until the object has been reconciled.
+func (s *SyncStatus) Seen(name, key string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.collectors[name]; ok && !c.cachesSynced {
+		c.pending[key] = struct{}{}
+	}
+}
+
+// Reconciled records the completion of a reconcile of the object by the collector, whatever its outcome.
+func (s *SyncStatus) Reconciled(name, key string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.collectors[name]; ok {
+		delete(c.pending, key)
+	}
+}
+
+// CachesSynced records that the informer caches of the collector have synced. The objects received afterwards are
+// not part of the initial sweep.
+func (s *SyncStatus) CachesSynced(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c, ok := s.collectors[name]; ok {
+		c.cachesSynced = true
+	}
+}
+
+// RegisterListener adds a listener to the sync status. It is not ready until it is marked as listening.
+func (s *SyncStatus) RegisterListener(name string) {
+	s.SetListening(name, false)
+}
+
+// SetListening records whether the listener accepts connections.
+func (s *SyncStatus) SetListening(name string, listening bool) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners[name] = listening
+}
+
+// NotReady returns the sorted descriptions of the components that are not ready.
+func (s *SyncStatus) NotReady() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var notReady []string
+	for name, c := range s.collectors {
+		switch {
+		case !c.cachesSynced:
+			notReady = append(notReady, fmt.Sprintf("%s (caches not synced)", name))
+		case len(c.pending) != 0:
+			notReady = append(notReady, fmt.Sprintf("%s (%d objects of the initial sweep not reconciled)", name,
+				len(c.pending)))
+		}
+	}
+	for name, listening := range s.listeners {
+		if !listening {
+			notReady = append(notReady, fmt.Sprintf("%s (not listening)", name))
+		}
+	}
+	sort.Strings(notReady)
+	return notReady
+}
+
+// Checker implements the healthz.Checker signature. It returns an error if any component is not ready.
+func (s *SyncStatus) Checker(_ *http.Request) error {
+	if notReady := s.NotReady(); len(notReady) != 0 {
+		return fmt.Errorf("components not ready: %s", strings.Join(notReady, ", "))
+	}
+	return nil
+}
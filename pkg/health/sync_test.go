@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSyncStatus(t *testing.T) {
+	s := NewSyncStatus()
+	s.RegisterCollector("pod-collector")
+	s.RegisterListener("broker")
+	if got := s.NotReady(); len(got) != 2 || !strings.Contains(got[0], "broker (not listening)") ||
+		!strings.Contains(got[1], "pod-collector (caches not synced)") {
+		t.Fatalf("expected the registered components not to be ready, got %v", got)
+	}
+
+	// The objects received before the caches synced are part of the initial sweep.
+	s.Seen("pod-collector", "default/pod-1")
+	s.Seen("pod-collector", "default/pod-2")
+	s.Reconciled("pod-collector", "default/pod-1")
+	s.CachesSynced("pod-collector")
+	s.Seen("pod-collector", "default/pod-3")
+	s.SetListening("broker", true)
+	if got := s.NotReady(); len(got) != 1 || !strings.Contains(got[0], "pod-collector (1 objects") {
+		t.Fatalf("expected the sweep of the pod-collector to be pending, got %v", got)
+	}
+	if err := s.Checker(nil); err == nil {
+		t.Error("expected the checker to fail with pending components")
+	}
+
+	s.Reconciled("pod-collector", "default/pod-2")
+	if err := s.Checker(nil); err != nil {
+		t.Errorf("expected all the components to be ready, got %v", err)
+	}
+
+	s.SetListening("broker", false)
+	if err := s.Checker(nil); err == nil {
+		t.Error("expected the checker to fail when the broker stops listening")
+	}
+
+	var nilStatus *SyncStatus
+	nilStatus.RegisterCollector("pod-collector")
+	nilStatus.Seen("pod-collector", "default/pod-1")
+	if err := nilStatus.Checker(nil); err != nil {
+		t.Errorf("expected a nil sync status to be ready, got %v", err)
+	}
+}
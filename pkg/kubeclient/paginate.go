@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Paginate returns a copy of the given client whose list requests sent in pages, i.e. with a limit, ask for pages of
+// at most the given number of items. The client is returned as it is if the page size is not positive.
+//
+// The informers list their resources in pages of 500 items at resource version 0, which the api-server serves from its
+// watch cache at once, ignoring the limit. Those lists are sent without resource version, so that the api-server pages
+// them from etcd: the memory spike of the initial sync is traded for more requests and more load on etcd. The lists
+// without a limit, e.g. the ones of the clients not following the continue tokens, are left untouched.
+func Paginate(client *http.Client, pageSize int64) *http.Client {
+	if pageSize <= 0 {
+		return client
+	}
+	paged := *client
+	next := paged.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	paged.Transport = &paginatedTransport{next: next, pageSize: pageSize}
+	return &paged
+}
+
+// paginatedTransport sets the page size of the list requests before handing them to the next round tripper.
+type paginatedTransport struct {
+	next     http.RoundTripper
+	pageSize int64
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *paginatedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	if req.Method != http.MethodGet || query.Get("limit") == "" || query.Get("watch") == "true" {
+		return t.next.RoundTrip(req)
+	}
+
+	query.Set("limit", strconv.FormatInt(t.pageSize, 10))
+	if query.Get("continue") == "" && query.Get("resourceVersion") == "0" {
+		query.Del("resourceVersion")
+		query.Del("resourceVersionMatch")
+	}
+	// The request must not be modified by the round trippers.
+	req = req.Clone(req.Context())
+	req.URL.RawQuery = query.Encode()
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	if client := Paginate(srv.Client(), 0); client != srv.Client() {
+		t.Error("expected the client to be returned as it is without page size")
+	}
+	client := Paginate(srv.Client(), 100)
+
+	tests := []struct {
+		name string
+		path string
+		want url.Values
+	}{
+		{"initial list", "/api/v1/pods?limit=500&resourceVersion=0", url.Values{"limit": {"100"}}},
+		{"continued list", "/api/v1/pods?limit=500&continue=token",
+			url.Values{"limit": {"100"}, "continue": {"token"}}},
+		{"list at resource version", "/api/v1/pods?limit=500&resourceVersion=42",
+			url.Values{"limit": {"100"}, "resourceVersion": {"42"}}},
+		{"list without limit", "/api/v1/pods?resourceVersion=0", url.Values{"resourceVersion": {"0"}}},
+		{"watch", "/api/v1/pods?limit=500&watch=true", url.Values{"limit": {"500"}, "watch": {"true"}}},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if query.Encode() != tt.want.Encode() {
+			t.Errorf("%s: expected query %q, got %q", tt.name, tt.want.Encode(), query.Encode())
+		}
+	}
+}
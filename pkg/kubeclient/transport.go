@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	kubeClientSubsystem = "kube_client"
+	requestsKey         = "api_requests"
+
+	// otherCollector is the collector label of the requests for resources not handled by the collectors.
+	otherCollector = "other"
+)
+
+// apiRequests is a prometheus counter metrics which holds the total number of requests sent to the api server.
+// The collector label refers to the kind of the requested resource, which is the name of the collector watching it,
+// and the verb label to the kind of request, i.e. list, watch, get, etc.
+var apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: consts.MetricsNamespace,
+	Subsystem: kubeClientSubsystem,
+	Name:      requestsKey,
+	Help: "Total number of requests sent to the api-server. The collector label refers to the kind of the requested" +
+		" resource, which is the name of the collector watching it, and the verb label to the kind of request.",
+}, []string{"collector", "verb"})
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(apiRequests)
+}
+
+// Instrument wraps the transport of the given config so that the requests sent to the api server are counted per
+// collector.
+func Instrument(cfg *rest.Config) {
+	cfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &instrumentedTransport{next: rt, kinds: kindsByResource()}
+	})
+}
+
+// instrumentedTransport counts the requests before handing them to the next round tripper.
+type instrumentedTransport struct {
+	next http.RoundTripper
+	// kinds maps the resources in the request paths, e.g. pods, to their kind.
+	kinds map[string]string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	collector, verb := t.attribute(req)
+	apiRequests.WithLabelValues(collector, verb).Inc()
+	return t.next.RoundTrip(req)
+}
+
+// attribute returns the collector and the verb of the request. The paths of the requests have the form
+// /api/{version}/[namespaces/{namespace}/]{resource}[/{name}] for the core group, and start with
+// /apis/{group}/{version} for the other groups.
+func (t *instrumentedTransport) attribute(req *http.Request) (collector, verb string) {
+	verb = strings.ToLower(req.Method)
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return otherCollector, verb
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+
+	collector, ok := t.kinds[parts[0]]
+	if !ok {
+		collector = otherCollector
+	}
+	if req.Method == http.MethodGet && len(parts) == 1 {
+		verb = "list"
+		if req.URL.Query().Get("watch") == "true" {
+			verb = "watch"
+		}
+	}
+	return collector, verb
+}
+
+// kindsByResource returns the kinds handled by the meta collector indexed by their resource.
+func kindsByResource() map[string]string {
+	kinds := make(map[string]string)
+	for _, kind := range []string{resource.Namespace, resource.Service, resource.ReplicationController, resource.Node,
+		resource.Pod, resource.Endpoints, resource.Deployment, resource.ReplicaSet, resource.Daemonset,
+		resource.EndpointSlice} {
+		gvk, err := resource.GroupVersionKind(kind)
+		if err != nil {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		kinds[plural.Resource] = kind
+	}
+	return kinds
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/rest"
+)
+
+func TestAttribute(t *testing.T) {
+	tr := &instrumentedTransport{kinds: kindsByResource()}
+	tests := []struct {
+		method    string
+		url       string
+		collector string
+		verb      string
+	}{
+		{http.MethodGet, "/api/v1/pods?limit=500", resource.Pod, "list"},
+		{http.MethodGet, "/api/v1/pods?watch=true", resource.Pod, "watch"},
+		{http.MethodGet, "/api/v1/namespaces/default/pods/pod", resource.Pod, "get"},
+		{http.MethodGet, "/api/v1/namespaces", resource.Namespace, "list"},
+		{http.MethodGet, "/api/v1/namespaces/default", resource.Namespace, "get"},
+		{http.MethodGet, "/api/v1/endpoints", resource.Endpoints, "list"},
+		{http.MethodGet, "/apis/apps/v1/namespaces/default/replicasets?watch=true", resource.ReplicaSet, "watch"},
+		{http.MethodGet, "/apis/discovery.k8s.io/v1/endpointslices", resource.EndpointSlice, "list"},
+		{http.MethodPost, "/apis/coordination.k8s.io/v1/namespaces/default/leases", otherCollector, "post"},
+		{http.MethodGet, "/apis", otherCollector, "get"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, http.NoBody)
+		collector, verb := tr.attribute(req)
+		if collector != tt.collector || verb != tt.verb {
+			t.Errorf("%s %s: expected %s/%s, got %s/%s", tt.method, tt.url, tt.collector, tt.verb, collector, verb)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &rest.Config{Host: srv.URL}
+	Instrument(cfg)
+	client, err := rest.HTTPClientFor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := testutil.ToFloat64(apiRequests.WithLabelValues(resource.Daemonset, "list"))
+	resp, err := client.Get(srv.URL + "/apis/apps/v1/daemonsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if after := testutil.ToFloat64(apiRequests.WithLabelValues(resource.Daemonset, "list")); after != before+1 {
+		t.Fatalf("expected the request to be counted, got %v requests", after-before)
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsutil
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/consts"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace returns the namespace of the metrics, with the given prefix prepended if not empty.
+func Namespace(prefix string) string {
+	if prefix == "" {
+		return consts.MetricsNamespace
+	}
+	return prefix + "_" + consts.MetricsNamespace
+}
+
+// RegisterOrGet registers the metric in the registry, or returns the equal one already registered, e.g. by another
+// component sharing the registry. On the other registration errors, e.g. a metric with the same name but different
+// labels registered by the embedding program, the error is appended to errs and the unregistered metric is returned,
+// so that the component keeps working.
+func RegisterOrGet[T prometheus.Collector](reg prometheus.Registerer, c T, errs *[]error) T {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	*errs = append(*errs, fmt.Errorf("unable to register the metric: %w", err))
+	return c
+}
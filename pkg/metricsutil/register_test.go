@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNamespace(t *testing.T) {
+	if got := Namespace(""); got != "meta_collector" {
+		t.Errorf("expected the default namespace, got %q", got)
+	}
+	if got := Namespace("host"); got != "host_meta_collector" {
+		t.Errorf("expected the prefixed namespace, got %q", got)
+	}
+}
+
+func TestRegisterOrGet(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	newCounter := func() *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "events", Help: "Events."}, []string{"kind"})
+	}
+	var errs []error
+
+	first := RegisterOrGet(reg, newCounter(), &errs)
+	if again := RegisterOrGet(reg, newCounter(), &errs); again != first {
+		t.Error("expected the registered metric to be returned")
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// A metric with the same name but different labels is returned unregistered, along with the error.
+	conflicting := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "events", Help: "Events."}, []string{"node"})
+	if got := RegisterOrGet(reg, conflicting, &errs); got != conflicting {
+		t.Error("expected the unregistered metric to be returned")
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unable to register the metric") {
+		t.Errorf("expected a registration error, got %v", errs)
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"fmt"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// UnknownKindError is returned when a kind has not been registered.
+type UnknownKindError struct {
+	Kind string
+}
+
+// Error implements the error interface.
+func (e *UnknownKindError) Error() string {
+	return fmt.Sprintf("unknown resource kind %q: register its group version before using it", e.Kind)
+}
+
+var (
+	gvksMutex sync.RWMutex
+	// gvks maps the kinds handled by the meta collector to their group version kind.
+	gvks = map[string]schema.GroupVersionKind{}
+)
+
+func init() {
+	for _, kind := range []string{Namespace, Service, ReplicationController, Node, Pod, Endpoints} {
+		Register(corev1.SchemeGroupVersion.WithKind(kind))
+	}
+	for _, kind := range []string{Deployment, ReplicaSet, Daemonset} {
+		Register(appsv1.SchemeGroupVersion.WithKind(kind))
+	}
+	Register(discoveryv1.SchemeGroupVersion.WithKind(EndpointSlice))
+}
+
+// Register adds the group version kind to the registry. An already registered kind is overwritten.
+func Register(gvk schema.GroupVersionKind) {
+	gvksMutex.Lock()
+	defer gvksMutex.Unlock()
+	gvks[gvk.Kind] = gvk
+}
+
+// GroupVersionKind returns the group version kind registered for the given kind. If the kind is unknown
+// an *UnknownKindError is returned.
+func GroupVersionKind(kind string) (schema.GroupVersionKind, error) {
+	gvksMutex.RLock()
+	defer gvksMutex.RUnlock()
+	gvk, ok := gvks[kind]
+	if !ok {
+		return schema.GroupVersionKind{}, &UnknownKindError{Kind: kind}
+	}
+	return gvk, nil
+}
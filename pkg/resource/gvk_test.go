@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestGroupVersionKind(t *testing.T) {
+	tests := []struct {
+		kind string
+		want schema.GroupVersionKind
+	}{
+		{kind: Namespace, want: schema.GroupVersionKind{Version: "v1", Kind: Namespace}},
+		{kind: Service, want: schema.GroupVersionKind{Version: "v1", Kind: Service}},
+		{kind: ReplicationController, want: schema.GroupVersionKind{Version: "v1", Kind: ReplicationController}},
+		{kind: Node, want: schema.GroupVersionKind{Version: "v1", Kind: Node}},
+		{kind: Pod, want: schema.GroupVersionKind{Version: "v1", Kind: Pod}},
+		{kind: Endpoints, want: schema.GroupVersionKind{Version: "v1", Kind: Endpoints}},
+		{kind: Deployment, want: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: Deployment}},
+		{kind: ReplicaSet, want: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: ReplicaSet}},
+		{kind: Daemonset, want: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: Daemonset}},
+		{kind: EndpointSlice, want: schema.GroupVersionKind{Group: "discovery.k8s.io", Version: "v1", Kind: EndpointSlice}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			got, err := GroupVersionKind(tt.kind)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGroupVersionKindUnknown(t *testing.T) {
+	_, err := GroupVersionKind("Job")
+	var unknownKind *UnknownKindError
+	if !errors.As(err, &unknownKind) {
+		t.Fatalf("expected an UnknownKindError, got %v", err)
+	}
+	if unknownKind.Kind != "Job" {
+		t.Errorf("expected the error to refer to kind Job, got %q", unknownKind.Kind)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}
+	Register(gvk)
+	t.Cleanup(func() {
+		gvksMutex.Lock()
+		defer gvksMutex.Unlock()
+		delete(gvks, gvk.Kind)
+	})
+
+	got, err := GroupVersionKind(gvk.Kind)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != gvk {
+		t.Errorf("expected %s, got %s", gvk, got)
+	}
+}
@@ -34,4 +34,6 @@ const (
 	Endpoints = "Endpoints"
 	// EndpointSlice kind as used by the k8s resources.
 	EndpointSlice = "EndpointSlice"
+	// Node kind as used by k8s resources.
+	Node = "Node"
 )
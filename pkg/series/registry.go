@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package series
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// NodeLabel is the label of the series that reference a node.
+	NodeLabel = "node"
+	// SubscriberLabel is the label of the series that reference a subscriber.
+	SubscriberLabel = "subscriber"
+)
+
+// Vec is a metric vector whose series can be deleted by partial match of their labels, e.g. a *prometheus.CounterVec.
+type Vec interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// Registry maps the labels referencing nodes and subscribers to the metric vectors that use them.
+type Registry struct {
+	mutex sync.RWMutex
+	vecs  map[string][]Vec
+}
+
+// Default is the registry of the vectors registered in the controller-runtime metrics registry.
+var Default = NewRegistry()
+
+// NewRegistry returns a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{vecs: make(map[string][]Vec)}
+}
+
+// Register adds the vectors whose series reference the given label.
+func (r *Registry) Register(label string, vecs ...Vec) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.vecs[label] = append(r.vecs[label], vecs...)
+}
+
+// Delete deletes the series, across all the vectors registered for the label, where the label has the given value.
+// It returns the number of deleted series.
+func (r *Registry) Delete(label, value string) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	deleted := 0
+	for _, vec := range r.vecs[label] {
+		deleted += vec.DeletePartialMatch(prometheus.Labels{label: value})
+	}
+	return deleted
+}
+
+// DeleteNode deletes the series of the given node.
+func (r *Registry) DeleteNode(node string) int {
+	return r.Delete(NodeLabel, node)
+}
+
+// DeleteSubscriber deletes the series of the subscriber with the given UID.
+func (r *Registry) DeleteSubscriber(uid string) int {
+	return r.Delete(SubscriberLabel, uid)
+}
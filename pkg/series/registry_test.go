@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package series
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegistry(t *testing.T) {
+	gatherer := prometheus.NewRegistry()
+	sent := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "sent", Help: "Sent events."}, []string{NodeLabel, "kind"})
+	pending := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "pending", Help: "Pending events."}, []string{SubscriberLabel, NodeLabel})
+	gatherer.MustRegister(sent, pending)
+
+	r := NewRegistry()
+	r.Register(NodeLabel, sent, pending)
+	r.Register(SubscriberLabel, pending)
+
+	sent.WithLabelValues("gone", "Pod").Inc()
+	sent.WithLabelValues("gone", "Service").Inc()
+	sent.WithLabelValues("alive", "Pod").Inc()
+	pending.WithLabelValues("sub-1", "gone").Set(1)
+	pending.WithLabelValues("sub-2", "alive").Set(1)
+	pending.WithLabelValues("sub-3", "alive").Set(1)
+
+	if deleted := r.DeleteNode("gone"); deleted != 3 {
+		t.Errorf("expected 3 series of the node to be deleted, got %d", deleted)
+	}
+	if deleted := r.DeleteSubscriber("sub-2"); deleted != 1 {
+		t.Errorf("expected 1 series of the subscriber to be deleted, got %d", deleted)
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var series int
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			series++
+			for _, label := range metric.GetLabel() {
+				if label.GetValue() == "gone" || label.GetValue() == "sub-2" {
+					t.Errorf("expected the series of %q to be deleted from %s", label.GetValue(), family.GetName())
+				}
+			}
+		}
+	}
+	if series != 2 {
+		t.Errorf("expected 2 series left, got %d", series)
+	}
+	if err := testutil.GatherAndCompare(gatherer, strings.NewReader(`
+# HELP sent Sent events.
+# TYPE sent counter
+sent{kind="Pod",node="alive"} 1
+`), "sent"); err != nil {
+		t.Error(err)
+	}
+}
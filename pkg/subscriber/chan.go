@@ -22,6 +22,8 @@ const (
 	Subscribed reason = "Subscribed"
 	// Unsubscribed set by a subscriber when it leaves.
 	Unsubscribed reason = "Unsubscribed"
+	// Resynced set by a subscriber asking to receive again the resources of its node.
+	Resynced reason = "Resynced"
 )
 
 // Message sent by a subscriber to communicate its presence/absence.
@@ -34,7 +36,22 @@ type Message struct {
 	UID string
 	// Reason of the message. If it is subscribing or unsubscribing.
 	Reason reason
+	// WatchOnly is set for the subscribers that skip the replay of the existing resources: the collectors add them to
+	// the subscribers of their node without dispatching its resources, and call Done right away.
+	WatchOnly bool
+	// Done, if set, is called by each collector receiving the message once it has dispatched the existing
+	// resources to the subscriber, and the events of the resources have been queued.
+	Done func()
 }
 
-// SubsChan a channel used to communicate when new subscribers arrive or existing ones leave.
+// Dispatched signals that the existing resources have been dispatched to the subscriber.
+func (m Message) Dispatched() {
+	if m.Done != nil {
+		m.Done()
+	}
+}
+
+// SubsChan a channel used to communicate when new subscribers arrive or existing ones leave. It belongs to the
+// collector receiving on it and lives as long as the collector: the broker only sends on it and never closes it, so
+// that the broker can restart its listener while the collectors keep running.
 type SubsChan chan Message
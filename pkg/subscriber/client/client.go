@@ -0,0 +1,470 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/go-logr/logr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrNotSubscribed is returned by Resync when the Client has not completed a subscription.
+var ErrNotSubscribed = errors.New("the client has not completed a subscription")
+
+// EventType is the type of the changes of the resources delivered by the Client.
+type EventType string
+
+const (
+	// Added is the type of the events of the resources received for the first time.
+	Added EventType = "Added"
+	// Modified is the type of the events of the resources already received.
+	Modified EventType = "Modified"
+	// Deleted is the type of the events of the resources no more related to the node.
+	Deleted EventType = "Deleted"
+	// SnapshotComplete is the type of the event delivered on the channel once the resources existing at the time of
+	// the subscription have been received.
+	SnapshotComplete EventType = "SnapshotComplete"
+)
+
+// Event is a change of a resource related to the node of the Client, with its metadata decoded.
+type Event struct {
+	Type EventType
+	Kind string
+	UID  string
+	// Cluster is the name of the cluster of the resource, if the metacollector has been configured with it.
+	Cluster string
+	// Meta holds the metadata of the resource, decoded whatever the encoding chosen by the client.
+	Meta metav1.ObjectMeta
+	// Spec and Status hold the fields of the spec and of the status of the resource, encoded in JSON, if sent.
+	Spec   string
+	Status string
+	// Refs holds the UIDs of the resources referenced by the resource per kind, e.g. the services of a pod.
+	Refs map[string][]string
+	// TTL is the time after which the metadata should be considered stale if no other event is received for the
+	// resource, zero if the metacollector has not been configured with it.
+	TTL time.Duration
+	// Raw is the last event received for the resource, carrying the full metadata when they have been received as a
+	// patch. The Deleted events carry the fields of the last version of the resource received.
+	Raw *metadata.Event
+}
+
+// Handler is notified of the events received by a Client. The methods are called one at a time, in the order of the
+// events.
+type Handler interface {
+	OnAdded(evt *Event)
+	OnModified(evt *Event)
+	OnDeleted(evt *Event)
+	// OnSnapshotComplete is called once the resources existing at the time of the subscription have been received,
+	// after each connection and each resync. The following events are incremental. In WATCH_ONLY mode, it is called
+	// right after each connection.
+	OnSnapshotComplete()
+}
+
+// Client subscribes to the metacollector for a node. When the stream fails, the client subscribes again: the
+// resources are received again as Modified events, and the ones not received again by the end of the snapshot are
+// delivered as Deleted events, so that the handler follows the resources without handling the reconnections.
+type Client struct {
+	address  string
+	nodeName string
+	opts     options
+	dialOpts []grpc.DialOption
+	logger   logr.Logger
+	// resources holds the last version of the resources received, by UID.
+	resources map[string]Event
+
+	// mu protects the fields of the current subscription, used by Resync.
+	mu sync.Mutex
+	// metaClient is the client of the current connection, nil until Run is called.
+	metaClient metadata.MetadataClient
+	// uid is the UID assigned by the broker to the current subscription, empty until its snapshot is complete.
+	uid string
+}
+
+// New returns a Client subscribing for the given node to the broker at the given address.
+func New(address, nodeName string, opt ...Option) (*Client, error) {
+	opts := options{
+		logger:     logr.Discard(),
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, o := range opt {
+		o(&opts)
+	}
+	if len(opts.resourceKinds) == 0 {
+		opts.resourceKinds = DefaultResourceKinds
+	}
+	if opts.minBackoff <= 0 || opts.maxBackoff < opts.minBackoff {
+		return nil, fmt.Errorf("invalid backoff, min %s and max %s", opts.minBackoff, opts.maxBackoff)
+	}
+
+	creds := insecure.NewCredentials()
+	if opts.caFilePath != "" {
+		var err error
+		if creds, err = credentials.NewClientTLSFromFile(opts.caFilePath, opts.tlsServerName); err != nil {
+			return nil, fmt.Errorf("unable to create credentials from CA file %q: %w", opts.caFilePath, err)
+		}
+	}
+
+	return &Client{
+		address:   address,
+		nodeName:  nodeName,
+		opts:      opts,
+		dialOpts:  append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts.dialOptions...),
+		logger:    opts.logger,
+		resources: make(map[string]Event),
+	}, nil
+}
+
+// Run subscribes for the node and delivers the events to the handler until the context is canceled, subscribing
+// again with exponential backoff when the stream fails. It returns nil once the context is canceled, or in SNAPSHOT
+// mode once the snapshot has been delivered and the broker closed the stream. Run must not be called concurrently.
+func (c *Client) Run(ctx context.Context, handler Handler) error {
+	conn, err := grpc.DialContext(ctx, c.address, c.dialOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to dial the broker at %q: %w", c.address, err)
+	}
+	defer conn.Close()
+	metaClient := metadata.NewMetadataClient(conn)
+	c.mu.Lock()
+	c.metaClient = metaClient
+	c.mu.Unlock()
+
+	backoff := c.opts.minBackoff
+	for {
+		snapshot, err := c.watch(ctx, metaClient, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// The broker closes the stream of a SNAPSHOT subscription once the snapshot has been sent.
+		if snapshot && c.opts.mode == metadata.Mode_SNAPSHOT && errors.Is(err, io.EOF) {
+			return nil
+		}
+		// A subscription that went as far as the end of the snapshot was healthy.
+		if snapshot {
+			backoff = c.opts.minBackoff
+		}
+		delay := retryDelay(err, backoff)
+		c.logger.Info("stream closed, subscribing again", "node", c.nodeName, "error", err, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, c.opts.maxBackoff)
+	}
+}
+
+// Resync asks the broker to send again the resources related to the node of the current subscription, e.g. after the
+// handler lost track of them. The resources are delivered as Modified events, followed by the Deleted events of the
+// ones not received again and by a SnapshotComplete event. The broker refuses the resyncs asked too often with the
+// ResourceExhausted code. ErrNotSubscribed is returned if the snapshot of the current subscription is not complete.
+func (c *Client) Resync(ctx context.Context) error {
+	c.mu.Lock()
+	metaClient, uid := c.metaClient, c.uid
+	c.mu.Unlock()
+	if metaClient == nil || uid == "" {
+		return ErrNotSubscribed
+	}
+	_, err := metaClient.Resync(ctx, &metadata.ResyncRequest{Uid: uid, NodeName: c.nodeName})
+	return err
+}
+
+// setUID sets the UID of the current subscription.
+func (c *Client) setUID(uid string) {
+	c.mu.Lock()
+	c.uid = uid
+	c.mu.Unlock()
+}
+
+// Events runs the Client and delivers the events on the returned channel, including the SnapshotComplete ones. The
+// channel is closed once the context is canceled.
+func (c *Client) Events(ctx context.Context) <-chan *Event {
+	ch := make(chan *Event)
+	go func() {
+		defer close(ch)
+		if err := c.Run(ctx, &channelHandler{ctx: ctx, ch: ch}); err != nil {
+			c.logger.Error(err, "unable to run the client", "node", c.nodeName)
+		}
+	}()
+	return ch
+}
+
+// watch subscribes for the node and delivers the received events until the stream fails. It returns whether the
+// snapshot has been received.
+func (c *Client) watch(ctx context.Context, metaClient metadata.MetadataClient, handler Handler) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	kinds := make(map[string]string, len(c.opts.resourceKinds))
+	for _, kind := range c.opts.resourceKinds {
+		kinds[kind] = ""
+	}
+	stream, err := metaClient.Watch(ctx, &metadata.Selector{
+		NodeName:      c.nodeName,
+		ResourceKinds: kinds,
+		Encoding:      c.opts.encoding,
+		// The patches of the metadata are applied to the ones of the previous event of the resource.
+		MetaPatch: true,
+		Mode:      c.opts.mode,
+		// The resources of a deleted namespace are dropped on its DeleteNamespace event.
+		BulkDelete: true,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// The subscription ends with the stream, a resync can't be asked for it anymore.
+	defer c.setUID("")
+
+	// The events exceeding the maximum message size are received in chunks.
+	var chunks metadata.Reassembler
+	// received holds the UIDs of the resources received during a snapshot, nil once the snapshot is complete. The
+	// WATCH_ONLY subscriptions start without a snapshot, the resources already received are kept.
+	var received map[string]struct{}
+	if c.opts.mode != metadata.Mode_WATCH_ONLY {
+		received = make(map[string]struct{})
+	}
+	snapshot := false
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return snapshot, err
+		}
+		if msg, err = chunks.Add(msg); err != nil {
+			return snapshot, err
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.Reason {
+		case events.SnapshotComplete:
+			c.setUID(msg.Uid)
+			c.completeSnapshot(received, handler)
+			received = nil
+			snapshot = true
+			continue
+		case events.ResyncStart:
+			// The resources are sent again, as in the snapshot of a new subscription.
+			received = make(map[string]struct{})
+			continue
+		}
+		if received != nil {
+			received[msg.Uid] = struct{}{}
+		}
+		c.deliver(ctx, msg, handler)
+	}
+}
+
+// deliver delivers to the handler the change carried by the received event.
+func (c *Client) deliver(ctx context.Context, msg *metadata.Event, handler Handler) {
+	prev, known := c.resources[msg.Uid]
+	switch msg.Reason {
+	case events.Create, events.Update:
+		if msg.MetaPatch != nil && msg.Meta == nil {
+			var err error
+			if msg, err = applyMetaPatch(prev.Raw, msg); err != nil {
+				// The resource is out of sync, it is received again with a resync.
+				c.logger.Error(err, "dropping event, resyncing", "node", c.nodeName)
+				go c.resyncAfterError(ctx)
+				return
+			}
+		}
+		evt, err := decode(msg)
+		if err != nil {
+			c.logger.Error(err, "dropping event", "node", c.nodeName)
+			return
+		}
+		c.resources[msg.Uid] = evt
+		if known {
+			evt.Type = Modified
+			handler.OnModified(&evt)
+		} else {
+			evt.Type = Added
+			handler.OnAdded(&evt)
+		}
+	case events.Delete:
+		evt := prev
+		if !known {
+			evt = Event{Kind: msg.Kind, UID: msg.Uid, Cluster: msg.Cluster, Raw: msg}
+		}
+		delete(c.resources, msg.Uid)
+		evt.Type = Deleted
+		handler.OnDeleted(&evt)
+	case events.DeleteNamespace:
+		c.deleteNamespace(msg.GetNamespace(), handler)
+	case events.Refresh:
+		// The refreshes only extend the TTL of the metadata, the resources held by the client never expire.
+	default:
+		c.logger.V(1).Info("ignoring event of unknown type", "node", c.nodeName, "type", msg.Reason, "uid", msg.Uid)
+	}
+}
+
+// resyncAfterError asks for a resync after an event that could not be applied.
+func (c *Client) resyncAfterError(ctx context.Context) {
+	if err := c.Resync(ctx); err != nil && ctx.Err() == nil {
+		c.logger.Error(err, "unable to resync", "node", c.nodeName)
+	}
+}
+
+// applyMetaPatch returns a copy of the received event carrying the metadata obtained by applying its patch to the
+// metadata of the previous event of the resource.
+func applyMetaPatch(prev, msg *metadata.Event) (*metadata.Event, error) {
+	if prev.GetMeta() == "" {
+		return nil, fmt.Errorf("unable to patch the metadata of %s %s: previous metadata not received", msg.Kind, msg.Uid)
+	}
+	patch, err := jsonpatch.DecodePatch([]byte(msg.GetMetaPatch()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the patch of the metadata of %s %s: %w", msg.Kind, msg.Uid, err)
+	}
+	meta, err := patch.Apply([]byte(prev.GetMeta()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to patch the metadata of %s %s: %w", msg.Kind, msg.Uid, err)
+	}
+	patched := proto.Clone(msg).(*metadata.Event)
+	m := string(meta)
+	patched.Meta = &m
+	return patched, nil
+}
+
+// completeSnapshot delivers as Deleted the resources not received during the snapshot, i.e. the ones deleted while
+// the client was not subscribed, and notifies the handler that the snapshot is complete. Without a snapshot, i.e. if
+// received is nil, no resource is deleted.
+func (c *Client) completeSnapshot(received map[string]struct{}, handler Handler) {
+	for uid, evt := range c.resources {
+		if _, ok := received[uid]; ok || received == nil {
+			continue
+		}
+		delete(c.resources, uid)
+		evt.Type = Deleted
+		handler.OnDeleted(&evt)
+	}
+	handler.OnSnapshotComplete()
+}
+
+// deleteNamespace delivers as Deleted the resources of the given namespace, whose Delete events are not sent.
+func (c *Client) deleteNamespace(namespace string, handler Handler) {
+	for uid, evt := range c.resources {
+		if evt.Meta.Namespace != namespace {
+			continue
+		}
+		delete(c.resources, uid)
+		evt.Type = Deleted
+		handler.OnDeleted(&evt)
+	}
+}
+
+// decode returns the event carrying the fields of the received one, with its metadata decoded.
+func decode(msg *metadata.Event) (Event, error) {
+	evt := Event{
+		Kind:    msg.Kind,
+		UID:     msg.Uid,
+		Cluster: msg.Cluster,
+		Spec:    msg.GetSpec(),
+		Status:  msg.GetStatus(),
+		TTL:     time.Duration(msg.GetTtlSeconds()) * time.Second,
+		Raw:     msg,
+	}
+	switch {
+	case msg.Meta != nil:
+		if err := json.Unmarshal([]byte(msg.GetMeta()), &evt.Meta); err != nil {
+			return evt, fmt.Errorf("unable to decode the metadata of %s %s: %w", msg.Kind, msg.Uid, err)
+		}
+	case msg.MetaStruct != nil:
+		data, err := msg.GetMetaStruct().MarshalJSON()
+		if err == nil {
+			err = json.Unmarshal(data, &evt.Meta)
+		}
+		if err != nil {
+			return evt, fmt.Errorf("unable to decode the metadata of %s %s: %w", msg.Kind, msg.Uid, err)
+		}
+	case msg.ObjectMeta != nil:
+		meta := msg.GetObjectMeta()
+		evt.Meta = metav1.ObjectMeta{
+			Name:        meta.Name,
+			Namespace:   meta.Namespace,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		}
+	}
+	if refs := msg.GetRefs().GetResources(); len(refs) > 0 {
+		evt.Refs = make(map[string][]string, len(refs))
+		for kind, uids := range refs {
+			evt.Refs[kind] = uids.GetList()
+		}
+	}
+	return evt, nil
+}
+
+// retryDelay returns the delay before subscribing again after the given error: the backoff, or the delay suggested
+// by the broker if longer, e.g. while the collectors complete their initial sync.
+func retryDelay(err error, backoff time.Duration) time.Duration {
+	st, ok := status.FromError(err)
+	if !ok {
+		return backoff
+	}
+	for _, detail := range st.Details() {
+		var retry *errdetails.RetryInfo
+		if retry, ok = detail.(*errdetails.RetryInfo); ok && retry.GetRetryDelay().AsDuration() > backoff {
+			return retry.GetRetryDelay().AsDuration()
+		}
+	}
+	return backoff
+}
+
+// channelHandler is the Handler delivering the events on a channel.
+type channelHandler struct {
+	ctx context.Context
+	ch  chan<- *Event
+}
+
+func (h *channelHandler) send(evt *Event) {
+	select {
+	case h.ch <- evt:
+	case <-h.ctx.Done():
+	}
+}
+
+// OnAdded implements the Handler interface.
+func (h *channelHandler) OnAdded(evt *Event) { h.send(evt) }
+
+// OnModified implements the Handler interface.
+func (h *channelHandler) OnModified(evt *Event) { h.send(evt) }
+
+// OnDeleted implements the Handler interface.
+func (h *channelHandler) OnDeleted(evt *Event) { h.send(evt) }
+
+// OnSnapshotComplete implements the Handler interface.
+func (h *channelHandler) OnSnapshotComplete() { h.send(&Event{Type: SnapshotComplete}) }
@@ -0,0 +1,492 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// scriptedServer sends to each subscription the events of the next script, then fails the stream. The last script is
+// followed by a stream kept open until the subscriber leaves.
+type scriptedServer struct {
+	metadata.UnimplementedMetadataServer
+	scripts   [][]*metadata.Event
+	selectors chan *metadata.Selector
+}
+
+func (s *scriptedServer) Watch(selector *metadata.Selector, stream metadata.Metadata_WatchServer) error {
+	s.selectors <- selector
+	if len(s.scripts) == 0 {
+		<-stream.Context().Done()
+		return nil
+	}
+	script := s.scripts[0]
+	s.scripts = s.scripts[1:]
+	for _, evt := range script {
+		if err := stream.Send(evt); err != nil {
+			return err
+		}
+	}
+	if len(s.scripts) == 0 {
+		<-stream.Context().Done()
+		return nil
+	}
+	return status.Error(codes.Unavailable, "stream reset")
+}
+
+// recordingHandler records the events delivered by the client.
+type recordingHandler struct {
+	evts chan string
+}
+
+func (h *recordingHandler) OnAdded(evt *Event)    { h.evts <- "Added " + evt.Meta.Name }
+func (h *recordingHandler) OnModified(evt *Event) { h.evts <- "Modified " + evt.Meta.Name }
+func (h *recordingHandler) OnDeleted(evt *Event)  { h.evts <- "Deleted " + evt.Meta.Name }
+func (h *recordingHandler) OnSnapshotComplete()   { h.evts <- "SnapshotComplete" }
+
+// serve serves the given server in-process and returns the options dialing it.
+func serve(t *testing.T, srv metadata.MetadataServer) Option {
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	metadata.RegisterMetadataServer(grpcServer, srv)
+	go func() { _ = grpcServer.Serve(lis) }()
+	t.Cleanup(grpcServer.Stop)
+	return WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+}
+
+func event(reason, uid, name string) *metadata.Event {
+	meta := `{"name":"` + name + `","namespace":"default"}`
+	return &metadata.Event{Reason: reason, Uid: uid, Kind: "Pod", Meta: &meta}
+}
+
+func TestClientReconnect(t *testing.T) {
+	srv := &scriptedServer{
+		scripts: [][]*metadata.Event{
+			{
+				event(events.Create, "uid-a", "a"),
+				event(events.Create, "uid-b", "b"),
+				{Reason: events.SnapshotComplete},
+				event(events.Update, "uid-a", "a"),
+			},
+			// The pod b has been deleted while the client was not subscribed.
+			{
+				event(events.Create, "uid-a", "a"),
+				{Reason: events.SnapshotComplete},
+				{Reason: events.Delete, Uid: "uid-a", Kind: "Pod"},
+			},
+		},
+		selectors: make(chan *metadata.Selector, 3),
+	}
+	cl, err := New("bufnet", "node", serve(t, srv), WithBackoff(time.Millisecond, 10*time.Millisecond),
+		WithResourceKinds("Pod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := &recordingHandler{evts: make(chan string, 16)}
+	done := make(chan error, 1)
+	go func() { done <- cl.Run(ctx, handler) }()
+
+	want := []string{
+		"Added a", "Added b", "SnapshotComplete", "Modified a",
+		"Modified a", "Deleted b", "SnapshotComplete", "Deleted a",
+	}
+	var got []string
+	for len(got) < len(want) {
+		select {
+		case evt := <-handler.evts:
+			got = append(got, evt)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the events, got %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+	if selector := <-srv.selectors; selector.NodeName != "node" || !reflect.DeepEqual(selector.ResourceKinds,
+		map[string]string{"Pod": ""}) {
+		t.Errorf("unexpected selector %v", selector)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the client to stop without error, got %v", err)
+	}
+}
+
+// snapshotServer sends a snapshot to the subscription, then closes the stream cleanly.
+type snapshotServer struct {
+	metadata.UnimplementedMetadataServer
+	selectors chan *metadata.Selector
+}
+
+func (s *snapshotServer) Watch(selector *metadata.Selector, stream metadata.Metadata_WatchServer) error {
+	s.selectors <- selector
+	for _, evt := range []*metadata.Event{event(events.Create, "uid-a", "a"), {Reason: events.SnapshotComplete}} {
+		if err := stream.Send(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestClientSnapshotMode(t *testing.T) {
+	srv := &snapshotServer{selectors: make(chan *metadata.Selector, 1)}
+	cl, err := New("bufnet", "node", serve(t, srv), WithMode(metadata.Mode_SNAPSHOT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &recordingHandler{evts: make(chan string, 4)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Run returns once the snapshot has been delivered, without subscribing again.
+	if err := cl.Run(ctx, handler); err != nil || ctx.Err() != nil {
+		t.Fatalf("expected the client to return after the snapshot, got %v, %v", err, ctx.Err())
+	}
+	close(handler.evts)
+	var got []string
+	for evt := range handler.evts {
+		got = append(got, evt)
+	}
+	if want := []string{"Added a", "SnapshotComplete"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+	if selector := <-srv.selectors; selector.Mode != metadata.Mode_SNAPSHOT {
+		t.Errorf("expected a SNAPSHOT subscription, got %v", selector.Mode)
+	}
+}
+
+func TestClientWatchOnlyMode(t *testing.T) {
+	srv := &scriptedServer{
+		scripts: [][]*metadata.Event{
+			{{Reason: events.SnapshotComplete}, event(events.Create, "uid-a", "a")},
+			// The resources are not sent again once subscribed again, and are kept.
+			{{Reason: events.SnapshotComplete}, event(events.Update, "uid-a", "a")},
+		},
+		selectors: make(chan *metadata.Selector, 2),
+	}
+	cl, err := New("bufnet", "node", serve(t, srv), WithBackoff(time.Millisecond, 10*time.Millisecond),
+		WithMode(metadata.Mode_WATCH_ONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := &recordingHandler{evts: make(chan string, 8)}
+	done := make(chan error, 1)
+	go func() { done <- cl.Run(ctx, handler) }()
+
+	want := []string{"SnapshotComplete", "Added a", "SnapshotComplete", "Modified a"}
+	var got []string
+	for len(got) < len(want) {
+		select {
+		case evt := <-handler.evts:
+			got = append(got, evt)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the events, got %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+	if selector := <-srv.selectors; selector.Mode != metadata.Mode_WATCH_ONLY {
+		t.Errorf("expected a WATCH_ONLY subscription, got %v", selector.Mode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the client to stop without error, got %v", err)
+	}
+}
+
+func TestClientDeleteNamespace(t *testing.T) {
+	doomed := event(events.Create, "uid-a", "a")
+	meta := `{"name":"a","namespace":"doomed"}`
+	doomed.Meta = &meta
+	srv := &scriptedServer{
+		scripts: [][]*metadata.Event{{
+			doomed,
+			event(events.Create, "uid-b", "b"),
+			{Reason: events.SnapshotComplete},
+			// The Delete events of the resources of the namespace are not sent.
+			{Reason: events.DeleteNamespace, Uid: "uid-ns", Namespace: "doomed"},
+			event(events.Update, "uid-b", "b"),
+		}},
+		selectors: make(chan *metadata.Selector, 1),
+	}
+	cl, err := New("bufnet", "node", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := &recordingHandler{evts: make(chan string, 8)}
+	done := make(chan error, 1)
+	go func() { done <- cl.Run(ctx, handler) }()
+
+	want := []string{"Added a", "Added b", "SnapshotComplete", "Deleted a", "Modified b"}
+	var got []string
+	for len(got) < len(want) {
+		select {
+		case evt := <-handler.evts:
+			got = append(got, evt)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the events, got %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+	if selector := <-srv.selectors; !selector.BulkDelete {
+		t.Error("expected the client to negotiate the bulk deletes")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the client to stop without error, got %v", err)
+	}
+}
+
+// resyncServer sends a snapshot to the subscription, then the replayed resources for each resync asked.
+type resyncServer struct {
+	metadata.UnimplementedMetadataServer
+	resyncs chan string
+}
+
+func (s *resyncServer) Watch(_ *metadata.Selector, stream metadata.Metadata_WatchServer) error {
+	snapshot := []*metadata.Event{
+		event(events.Create, "uid-a", "a"),
+		event(events.Create, "uid-b", "b"),
+		{Reason: events.SnapshotComplete, Uid: "subscriber"},
+	}
+	for _, evt := range snapshot {
+		if err := stream.Send(evt); err != nil {
+			return err
+		}
+	}
+	// The pod b has been deleted, and its Delete event lost by the subscriber.
+	for {
+		select {
+		case <-s.resyncs:
+			for _, evt := range []*metadata.Event{
+				{Reason: events.ResyncStart, Uid: "subscriber"},
+				event(events.Create, "uid-a", "a"),
+				{Reason: events.SnapshotComplete, Uid: "subscriber"},
+			} {
+				if err := stream.Send(evt); err != nil {
+					return err
+				}
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *resyncServer) Resync(_ context.Context, req *metadata.ResyncRequest) (*metadata.ResyncResponse, error) {
+	if req.GetUid() != "subscriber" || req.GetNodeName() != "node" {
+		return nil, status.Errorf(codes.NotFound, "no subscriber with UID %q for node %q", req.GetUid(), req.GetNodeName())
+	}
+	s.resyncs <- req.GetUid()
+	return &metadata.ResyncResponse{}, nil
+}
+
+func TestClientResync(t *testing.T) {
+	srv := &resyncServer{resyncs: make(chan string, 1)}
+	cl, err := New("bufnet", "node", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Resync(context.Background()); !errors.Is(err, ErrNotSubscribed) {
+		t.Errorf("expected %v before the subscription, got %v", ErrNotSubscribed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := &recordingHandler{evts: make(chan string, 16)}
+	done := make(chan error, 1)
+	go func() { done <- cl.Run(ctx, handler) }()
+
+	// receive returns the given number of events delivered to the handler.
+	receive := func(n int) []string {
+		t.Helper()
+		var got []string
+		for len(got) < n {
+			select {
+			case evt := <-handler.evts:
+				got = append(got, evt)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the events, got %v", got)
+			}
+		}
+		return got
+	}
+	if got, want := receive(3), []string{"Added a", "Added b", "SnapshotComplete"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+
+	// The resources not received again during the resync are gone.
+	if err := cl.Resync(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := receive(3), []string{"Modified a", "Deleted b", "SnapshotComplete"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the client to stop without error, got %v", err)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	srv := &scriptedServer{
+		scripts:   [][]*metadata.Event{{event(events.Create, "uid-a", "a"), {Reason: events.SnapshotComplete}}},
+		selectors: make(chan *metadata.Selector, 1),
+	}
+	cl, err := New("bufnet", "node", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cl.Events(ctx)
+
+	if evt := <-ch; evt.Type != Added || evt.UID != "uid-a" || evt.Meta.Namespace != "default" {
+		t.Errorf("expected the pod to be added, got %+v", evt)
+	}
+	if evt := <-ch; evt.Type != SnapshotComplete {
+		t.Errorf("expected the end of the snapshot, got %+v", evt)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestClientMetaPatch(t *testing.T) {
+	meta := `{"name":"a","namespace":"default","labels":{"version":"v1"}}`
+	patch := `[{"op":"replace","path":"/labels/version","value":"v2"}]`
+	srv := &scriptedServer{
+		scripts: [][]*metadata.Event{{
+			{Reason: events.Create, Uid: "uid-a", Kind: "Pod", Meta: &meta},
+			{Reason: events.SnapshotComplete},
+			{Reason: events.Update, Uid: "uid-a", Kind: "Pod", MetaPatch: &patch},
+			// The previous metadata of the resource are not known, the patch can not be applied.
+			{Reason: events.Update, Uid: "uid-b", Kind: "Pod", MetaPatch: &patch},
+			event(events.Create, "uid-c", "c"),
+		}},
+		selectors: make(chan *metadata.Selector, 1),
+	}
+	cl, err := New("bufnet", "node", serve(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cl.Events(ctx)
+
+	<-ch
+	<-ch
+	evt := <-ch
+	if evt.Type != Modified || evt.UID != "uid-a" || evt.Meta.Labels["version"] != "v2" || evt.Meta.Name != "a" {
+		t.Errorf("expected the patched metadata of the pod, got %+v", evt)
+	}
+	// The next patch applies to the patched metadata.
+	if evt.Raw.GetMeta() == "" {
+		t.Errorf("expected the patched metadata in the raw event, got %v", evt.Raw)
+	}
+	if evt := <-ch; evt.UID != "uid-c" {
+		t.Errorf("expected the event that can not be patched to be dropped, got %+v", evt)
+	}
+	if selector := <-srv.selectors; !selector.GetMetaPatch() {
+		t.Errorf("expected the client to negotiate the patches of the metadata, got %v", selector)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestDecode(t *testing.T) {
+	msg := &metadata.Event{
+		Reason: events.Create,
+		Uid:    "uid",
+		Kind:   "Pod",
+		ObjectMeta: &metadata.ObjectMeta{Name: "pod", Namespace: "default", Node: "node",
+			Labels: map[string]string{"app": "web"}},
+		Refs: &metadata.References{Resources: map[string]*metadata.ListOfStrings{
+			"Service": {List: []string{"svc-uid"}},
+		}},
+	}
+	evt, err := decode(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.Meta.Name != "pod" || evt.Meta.Namespace != "default" || evt.Meta.Labels["app"] != "web" {
+		t.Errorf("expected the structured metadata to be decoded, got %+v", evt.Meta)
+	}
+	if !reflect.DeepEqual(evt.Refs, map[string][]string{"Service": {"svc-uid"}}) {
+		t.Errorf("unexpected references %v", evt.Refs)
+	}
+
+	meta, err := structpb.NewStruct(map[string]interface{}{"name": "pod", "labels": map[string]interface{}{"app": "web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt, err = decode(&metadata.Event{Reason: events.Create, MetaStruct: meta}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evt.Meta.Name != "pod" || evt.Meta.Labels["app"] != "web" {
+		t.Errorf("expected the struct metadata to be decoded, got %+v", evt.Meta)
+	}
+
+	invalid := "{"
+	if _, err := decode(&metadata.Event{Reason: events.Create, Meta: &invalid}); err == nil {
+		t.Error("expected an error for invalid metadata")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	st, err := status.New(codes.Unavailable, "initial sync not completed").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(5 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay := retryDelay(st.Err(), time.Second); delay != 5*time.Second {
+		t.Errorf("expected the delay suggested by the broker, got %s", delay)
+	}
+	if delay := retryDelay(st.Err(), time.Minute); delay != time.Minute {
+		t.Errorf("expected the longer backoff, got %s", delay)
+	}
+	if delay := retryDelay(status.Error(codes.Unavailable, "reset"), time.Second); delay != time.Second {
+		t.Errorf("expected the backoff, got %s", delay)
+	}
+}
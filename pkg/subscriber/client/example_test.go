@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber/client"
+	"github.com/falcosecurity/k8s-metacollector/pkg/testutil"
+	"github.com/go-logr/logr"
+)
+
+// printer prints the changes of the resources related to the node.
+type printer struct{}
+
+func (printer) OnAdded(evt *client.Event) {
+	fmt.Println("added", evt.Kind, evt.Meta.Namespace, evt.Meta.Name)
+}
+func (printer) OnModified(evt *client.Event) {
+	fmt.Println("modified", evt.Kind, evt.Meta.Namespace, evt.Meta.Name)
+}
+func (printer) OnDeleted(evt *client.Event) {
+	fmt.Println("deleted", evt.Kind, evt.Meta.Namespace, evt.Meta.Name)
+}
+func (printer) OnSnapshotComplete() { fmt.Println("snapshot complete") }
+
+func ExampleClient_Run() {
+	cl, err := client.New("metacollector.metacollector.svc:45000", os.Getenv("NODE_NAME"),
+		client.WithTLS("/etc/metacollector/ca.crt", ""))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// Run returns once the context is canceled, subscribing again each time the stream fails.
+	if err := cl.Run(context.Background(), printer{}); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// TestClientAgainstBroker subscribes to an in-process broker, whose pod collector watches the api-server started by
+// the test harness. It runs only when the envtest binaries are available.
+func TestClientAgainstBroker(t *testing.T) {
+	if !testutil.AssetsAvailable() {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h, err := testutil.Start(ctx, testutil.WithCollectors(resource.Pod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Stop() })
+
+	// The broker serves the events pushed by the collectors to the in-memory broker of the harness.
+	addr := freeAddress(t)
+	br, err := broker.New(logr.Discard(), h.Broker, h.SubscribersChans, broker.WithAddress(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Manager.Add(br); err != nil {
+		t.Fatal(err)
+	}
+
+	pod, err := h.CreatePod(ctx, "default", "web", "node-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, err := client.New(addr, "node-1", client.WithResourceKinds(resource.Pod),
+		client.WithBackoff(100*time.Millisecond, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evts := cl.Events(ctx)
+	timeout := time.After(testutil.DefaultTimeout)
+	for {
+		select {
+		case evt := <-evts:
+			if evt.Type == client.Added && evt.Kind == resource.Pod && evt.Meta.Name == pod.Name {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the pod")
+		}
+	}
+}
+
+// freeAddress returns a local address with a free port.
+func freeAddress(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultMinBackoff is the default delay before the first reconnection after the stream fails.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the default maximum delay between two reconnections.
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// DefaultResourceKinds are the kinds of the resources the client subscribes to by default.
+var DefaultResourceKinds = []string{
+	resource.Pod,
+	resource.Namespace,
+	resource.Deployment,
+	resource.ReplicaSet,
+	resource.ReplicationController,
+	resource.Daemonset,
+	resource.Service,
+}
+
+type options struct {
+	logger        logr.Logger
+	caFilePath    string
+	tlsServerName string
+	resourceKinds []string
+	encoding      metadata.Encoding
+	mode          metadata.Mode
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	dialOptions   []grpc.DialOption
+}
+
+// Option function used to set options when creating a new Client instance.
+type Option func(opt *options)
+
+// WithLogger configures the logger of the client. The client does not log by default.
+func WithLogger(logger logr.Logger) Option {
+	return func(opt *options) {
+		opt.logger = logger
+	}
+}
+
+// WithTLS configures the client to connect to the broker over TLS, verifying its certificate with the CA in the given
+// file. The serverName, if not empty, overrides the name expected in the certificate of the broker.
+func WithTLS(caFilePath, serverName string) Option {
+	return func(opt *options) {
+		opt.caFilePath = caFilePath
+		opt.tlsServerName = serverName
+	}
+}
+
+// WithResourceKinds configures the kinds of the resources the client subscribes to, DefaultResourceKinds if not set.
+func WithResourceKinds(kinds ...string) Option {
+	return func(opt *options) {
+		opt.resourceKinds = kinds
+	}
+}
+
+// WithEncoding configures the encoding of the metadata sent by the broker. The client decodes the metadata whatever
+// the encoding, JSON by default.
+func WithEncoding(encoding metadata.Encoding) Option {
+	return func(opt *options) {
+		opt.encoding = encoding
+	}
+}
+
+// WithMode configures the mode of the subscription, SNAPSHOT_THEN_WATCH by default. In SNAPSHOT mode, Run returns once
+// the snapshot has been delivered. In WATCH_ONLY mode, the resources existing at the time of the subscription are not
+// received: the handler is notified of the changes from then on, and the resources are not delivered as Deleted when
+// the client subscribes again.
+func WithMode(mode metadata.Mode) Option {
+	return func(opt *options) {
+		opt.mode = mode
+	}
+}
+
+// WithBackoff configures the delays between the reconnections: the delay starts at minBackoff and doubles after each
+// failed attempt, up to maxBackoff. It is reset once a snapshot has been received.
+func WithBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(opt *options) {
+		opt.minBackoff = minBackoff
+		opt.maxBackoff = maxBackoff
+	}
+}
+
+// WithDialOptions configures additional options used to dial the broker, e.g. the maximum size of the messages.
+func WithDialOptions(dialOptions ...grpc.DialOption) Option {
+	return func(opt *options) {
+		opt.dialOptions = append(opt.dialOptions, dialOptions...)
+	}
+}
@@ -65,6 +65,25 @@ func (gc *Subscribers) GetSubscribersPerNode(node string) fields.Subscribers {
 	return nil
 }
 
+// AddSubscribersPerNodeTo adds the subscribers for a given node to the given set, without copying
+// them in an intermediate set. If the set is nil and the node has subscribers, a new set is allocated.
+// It returns the updated set.
+func (gc *Subscribers) AddSubscribersPerNodeTo(node string, dst fields.Subscribers) fields.Subscribers {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	s, ok := gc.items[node]
+	if !ok {
+		return dst
+	}
+	if dst == nil {
+		dst = make(fields.Subscribers, len(s))
+	}
+	for sub := range s {
+		dst[sub] = struct{}{}
+	}
+	return dst
+}
+
 // DeleteSubscriberPerNode given the node and the subscribers UID it removes it.
 func (gc *Subscribers) DeleteSubscriberPerNode(node, sub string) {
 	gc.rwLock.Lock()
@@ -89,6 +108,17 @@ func (gc *Subscribers) HasNode(node string) bool {
 	return ok
 }
 
+// Nodes returns the nodes that have at least a subscriber.
+func (gc *Subscribers) Nodes() []string {
+	gc.rwLock.RLock()
+	defer gc.rwLock.RUnlock()
+	nodes := make([]string, 0, len(gc.items))
+	for node := range gc.items {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
 // Len returns the number of subscribers.
 func (gc *Subscribers) Len() int {
 	return len(gc.items)
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+)
+
+// validateKinds returns an error if the collectors of the given kinds can not be run by the harness.
+func validateKinds(kinds []string) error {
+	for _, kind := range kinds {
+		reg, ok := collectors.Lookup(kind)
+		if !ok {
+			return fmt.Errorf("no collector registered for kind %q", kind)
+		}
+		for _, dep := range reg.Dependencies {
+			if !slices.Contains(kinds, dep) {
+				return fmt.Errorf("the %s collector requires the %s collector", kind, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// setupCollectors adds to the manager the collectors of the given kinds, built from the registry as the
+// metacollector does. The kinds are expected to be validated by validateKinds.
+func (h *Harness) setupCollectors(kinds []string, collectorOpts []collectors.CollectorOption, barrier *health.Barrier) error {
+	indexRegistry := collectors.NewIndexRegistry(h.Manager.GetFieldIndexer())
+	nodesMemo := collectors.NewNodesMemo()
+	cascade := collectors.NewNamespaceCascade()
+	triggers := collectors.NewTriggers(kinds...)
+
+	for _, kind := range kinds {
+		reg, _ := collectors.Lookup(kind)
+		subsChan := h.Broker.SubscribersChan()
+		cache := events.NewCache(events.WithName(reg.Name))
+		cascade.Register(cache)
+		if _, err := reg.Build(&collectors.Setup{
+			Manager:  h.Manager,
+			Queue:    h.Broker,
+			Cache:    cache,
+			Triggers: triggers,
+			Options: append([]collectors.CollectorOption{
+				collectors.WithBarrier(barrier),
+				collectors.WithIndexRegistry(indexRegistry),
+				collectors.WithAPIReader(h.Manager.GetAPIReader()),
+				collectors.WithNodesMemo(nodesMemo),
+				collectors.WithNamespaceCascade(cascade),
+				collectors.WithSubscribersChan(subsChan),
+			}, collectorOpts...),
+		}); err != nil {
+			return err
+		}
+		h.SubscribersChans[kind] = subsChan
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testutil provides a harness to run the collectors in integration tests: it starts an api-server with
+// envtest, runs a manager with the chosen collectors pushing their events to an in-memory broker, and offers helpers
+// to create the pods and deployments and to wait for the events destined to the subscribers of a node.
+//
+// The unit tests of a collector use a Fixture instead: the collector reads the objects from a fake client and its
+// reconciles are driven by the test, which checks the events pushed to the in-memory broker.
+package testutil
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/falcosecurity/k8s-metacollector/broker/brokertest"
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Fixture holds the fakes to unit test a collector without an api-server: the collector reads the objects from a fake
+// client, pushes its events to an in-memory broker and caches the resources in an in-memory cache. The reconciles
+// are driven by the test, and the subscribers are related to their node without running the collector.
+type Fixture struct {
+	// Client is a fake client with the field indexes the collectors rely on.
+	Client client.WithWatch
+	// Broker records the events pushed by the collector.
+	Broker *brokertest.Broker
+	// Cache is the cache of the collector.
+	Cache *events.Cache
+	// Subscribers relates the subscribers to their node.
+	Subscribers *subscriber.Subscribers
+}
+
+// NewFixture returns a fixture whose fake client holds the given objects.
+func NewFixture(objs ...client.Object) *Fixture {
+	return &Fixture{
+		Client:      NewFakeClient(objs...),
+		Broker:      brokertest.NewBroker(),
+		Cache:       events.NewCache(),
+		Subscribers: subscriber.NewSubscribers(),
+	}
+}
+
+// NewFakeClient returns a fake client holding the given objects, with the field indexes of the built-in collectors.
+func NewFakeClient(objs ...client.Object) client.WithWatch {
+	builder := fake.NewClientBuilder().WithObjects(objs...)
+	for _, indexer := range []collectors.Indexer{collectors.PodByNodeIndexer, collectors.PodByPrefixNameIndexer,
+		collectors.ServiceBySelectorIndexer} {
+		builder = builder.WithIndex(indexer.Object, indexer.Field, indexer.ExtractValue)
+	}
+	return builder.Build()
+}
+
+// CollectorOptions returns the options relating the collector to the subscribers of the fixture. They are passed to
+// the constructor of the collector along with the client, the broker and the cache of the fixture.
+func (f *Fixture) CollectorOptions() []collectors.CollectorOption {
+	return []collectors.CollectorOption{collectors.WithSubscribers(f.Subscribers)}
+}
+
+// Subscribe relates a new subscriber to the given node and returns its UID. The resources related to the node are
+// sent to it by the following reconciles.
+func (f *Fixture) Subscribe(node string) string {
+	uid := string(uuid.NewUUID())
+	f.Subscribers.AddSubscriberPerNode(node, uid)
+	return uid
+}
+
+// Reconcile reconciles the given object with the reconciler, e.g. a collector, and returns the events it pushed.
+// The events recorded by the broker before the reconcile are dropped.
+func (f *Fixture) Reconcile(ctx context.Context, r reconcile.Reconciler, obj client.Object) ([]events.Interface,
+	ctrl.Result, error) {
+	f.Broker.Reset()
+	res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+	return f.Broker.Events(), res, err
+}
+
+// Expected describes an event expected to be pushed by a collector. The empty fields match any event.
+type Expected struct {
+	// Kind is the resource kind of the event.
+	Kind string
+	// Type is the type of the event, e.g. events.Create.
+	Type string
+	// UID is the UID of the resource.
+	UID string
+	// Node is the node of a subscriber the event is destined to.
+	Node string
+}
+
+// String returns the expected event in string format.
+func (e Expected) String() string {
+	return fmt.Sprintf("%s %s event for resource %q destined to node %q", e.Kind, e.Type, e.UID, e.Node)
+}
+
+// ExpectEvents returns an error unless the events match the expected ones, in the same order.
+func (f *Fixture) ExpectEvents(evts []events.Interface, expected ...Expected) error {
+	if len(evts) != len(expected) {
+		return fmt.Errorf("expected %d events, got %d: %v", len(expected), len(evts), evts)
+	}
+	for i, want := range expected {
+		if !f.matches(evts[i], want) {
+			return fmt.Errorf("expected %s, got %s", want, evts[i])
+		}
+	}
+	return nil
+}
+
+// matches returns true if the event matches the expected one.
+func (f *Fixture) matches(evt events.Interface, want Expected) bool {
+	switch {
+	case want.Kind != "" && evt.ResourceKind() != want.Kind,
+		want.Type != "" && evt.Type() != want.Type,
+		want.UID != "" && evt.GRPCMessage().GetUid() != want.UID:
+		return false
+	case want.Node == "":
+		return true
+	}
+	return len(f.Subscribers.GetSubscribersPerNode(want.Node).Intersect(evt.Subscribers())) != 0
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+	"github.com/falcosecurity/k8s-metacollector/pkg/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFixtureObjectMetaCollector(t *testing.T) {
+	ctx := context.Background()
+	dpl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "dpl-uid",
+		Labels: map[string]string{"app": "web"}}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-abcde", Namespace: "default", UID: "pod-uid"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	f := testutil.NewFixture(dpl, pod)
+	collector := collectors.NewObjectMetaCollector(f.Client, f.Broker, f.Cache,
+		collectors.NewPartialObjectMetadata(resource.Deployment, nil), "fixture-deployment-collector", f.CollectorOptions()...)
+
+	// Without subscribers no event is pushed.
+	evts, _, err := f.Reconcile(ctx, collector, dpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ExpectEvents(evts); err != nil {
+		t.Error(err)
+	}
+
+	// The deployment is sent to the subscriber of the node running its pods.
+	f.Subscribe("node-1")
+	if evts, _, err = f.Reconcile(ctx, collector, dpl); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ExpectEvents(evts, testutil.Expected{Kind: resource.Deployment, Type: events.Create, UID: "dpl-uid",
+		Node: "node-1"}); err != nil {
+		t.Error(err)
+	}
+	if err := f.ExpectEvents(evts, testutil.Expected{Node: "node-2"}); err == nil {
+		t.Error("expected no event destined to another node")
+	}
+
+	// The changes of the deployment are sent to the subscriber.
+	dpl.Labels["version"] = "v2"
+	if err := f.Client.Update(ctx, dpl); err != nil {
+		t.Fatal(err)
+	}
+	if evts, _, err = f.Reconcile(ctx, collector, dpl); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ExpectEvents(evts, testutil.Expected{Type: events.Update, UID: "dpl-uid"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := f.Client.Delete(ctx, dpl); err != nil {
+		t.Fatal(err)
+	}
+	if evts, _, err = f.Reconcile(ctx, collector, dpl); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.ExpectEvents(evts, testutil.Expected{Type: events.Delete, UID: "dpl-uid", Node: "node-1"}); err != nil {
+		t.Error(err)
+	}
+	if f.Cache.Has(f.Cache.Key(resource.Deployment, client.ObjectKeyFromObject(dpl))) {
+		t.Error("expected the deleted deployment to be dropped from the cache")
+	}
+}
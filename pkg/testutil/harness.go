@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/broker/brokertest"
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/health"
+	"github.com/falcosecurity/k8s-metacollector/pkg/subscriber"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// Harness runs the collectors against the api-server started by envtest. The collectors push their events to an
+// in-memory broker, through which the tests subscribe the nodes and wait for the events destined to them.
+type Harness struct {
+	// Env is the envtest environment running the api-server.
+	Env *envtest.Environment
+	// Config is the configuration to connect to the api-server.
+	Config *rest.Config
+	// Client reads and writes the objects from and to the api-server, without going through the cache.
+	Client client.Client
+	// Manager runs the collectors.
+	Manager ctrl.Manager
+	// Broker records the events pushed by the collectors and notifies them of the subscribers.
+	Broker *brokertest.Broker
+	// SubscribersChans holds the channels where the collectors get notified of the subscribers, by resource kind. A
+	// real broker can be added to the manager with them, alongside the in-memory one.
+	SubscribersChans map[string]subscriber.SubsChan
+
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stopped chan error
+	// lastIP is the last octets of the IP assigned to the pods created by the harness.
+	lastIP atomic.Uint32
+}
+
+// AssetsAvailable returns true if the envtest binaries are available, as pointed to by the KUBEBUILDER_ASSETS
+// environment variable. The tests using the harness are expected to be skipped otherwise.
+func AssetsAvailable() bool {
+	return os.Getenv("KUBEBUILDER_ASSETS") != ""
+}
+
+// Start starts the api-server and the manager running the collectors, and returns once the collectors have completed
+// their initial sync. The harness is torn down by Stop, or when the given context is canceled.
+func Start(ctx context.Context, opt ...Option) (_ *Harness, err error) {
+	opts := options{kinds: DefaultCollectors, timeout: DefaultTimeout}
+	for _, o := range opt {
+		o(&opts)
+	}
+	if err := validateKinds(opts.kinds); err != nil {
+		return nil, err
+	}
+
+	h := &Harness{
+		Env:              &envtest.Environment{CRDDirectoryPaths: opts.crdDirectories},
+		Broker:           brokertest.NewBroker(),
+		SubscribersChans: make(map[string]subscriber.SubsChan),
+		timeout:          opts.timeout,
+		stopped:          make(chan error, 1),
+	}
+	if h.Config, err = h.Env.Start(); err != nil {
+		return nil, fmt.Errorf("starting the api-server: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = h.Env.Stop()
+		}
+	}()
+
+	scheme := runtime.NewScheme()
+	if err = clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if h.Client, err = client.New(h.Config, client.Options{Scheme: scheme}); err != nil {
+		return nil, fmt.Errorf("creating the client: %w", err)
+	}
+	if h.Manager, err = ctrl.NewManager(h.Config, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		Cache:   cacheOptions(),
+	}); err != nil {
+		return nil, fmt.Errorf("creating the manager: %w", err)
+	}
+
+	barrier := health.NewBarrier()
+	if err = h.setupCollectors(opts.kinds, opts.collectorOpts, barrier); err != nil {
+		return nil, err
+	}
+
+	ctx, h.cancel = context.WithCancel(ctx)
+	go func() { h.stopped <- h.Manager.Start(ctx) }()
+	if err = h.waitForSync(ctx, barrier); err != nil {
+		h.cancel()
+		<-h.stopped
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// waitForSync waits until the collectors have completed their initial sync.
+func (h *Harness) waitForSync(ctx context.Context, barrier *health.Barrier) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.NewTimer(h.timeout)
+	defer deadline.Stop()
+	for !barrier.Ready() {
+		select {
+		case err := <-h.stopped:
+			h.stopped <- err
+			return fmt.Errorf("manager stopped before the initial sync of the collectors: %w", err)
+		case <-deadline.C:
+			return fmt.Errorf("initial sync of collectors %v not completed within %s", barrier.Pending(), h.timeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Stop stops the manager and the api-server.
+func (h *Harness) Stop() error {
+	h.cancel()
+	var err error
+	select {
+	case err = <-h.stopped:
+		h.stopped <- err
+	case <-time.After(h.timeout):
+		err = fmt.Errorf("manager not stopped within %s", h.timeout)
+	}
+	return errors.Join(err, h.Env.Stop())
+}
+
+// WaitForEvent waits for an event of the given resource kind and type, e.g. events.Create, destined to a subscriber
+// of the given node, or to any subscriber if the node is empty. See brokertest.Broker.WaitForEvent.
+func (h *Harness) WaitForEvent(kind, evtType, node string) (events.Interface, error) {
+	return h.Broker.WaitForEvent(kind, evtType, node, h.timeout)
+}
+
+// Subscribe subscribes a new subscriber for the given node and returns its UID, once the collectors have pushed the
+// events of the existing resources related to the node.
+func (h *Harness) Subscribe(ctx context.Context, node string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	return h.Broker.Subscribe(ctx, node)
+}
+
+// cacheOptions returns the options of the cache of the manager, transforming the objects as the metacollector does.
+func cacheOptions() cache.Options {
+	logger := logr.Discard()
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}:                   {Transform: collectors.PodTransformer(logger)},
+			&corev1.Service{}:               {Transform: collectors.ServiceTransformer(logger)},
+			&corev1.Namespace{}:             {Transform: collectors.PartialObjectTransformer(logger)},
+			&corev1.ReplicationController{}: {Transform: collectors.PartialObjectTransformer(logger)},
+			&corev1.Node{}:                  {Transform: collectors.PartialObjectTransformer(logger)},
+			&appsv1.Deployment{}:            {Transform: collectors.PartialObjectTransformer(logger)},
+			&appsv1.ReplicaSet{}:            {Transform: collectors.PartialObjectTransformer(logger)},
+			&appsv1.DaemonSet{}:             {Transform: collectors.PartialObjectTransformer(logger)},
+			&discoveryv1.EndpointSlice{}:    {Transform: collectors.EndpointsliceTransformer(logger)},
+		},
+	}
+}
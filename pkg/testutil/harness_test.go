@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+)
+
+func TestValidateKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		kinds   []string
+		wantErr bool
+	}{
+		{name: "default", kinds: DefaultCollectors},
+		{name: "pods only", kinds: []string{resource.Pod}},
+		{name: "services with pods", kinds: []string{resource.Pod, resource.Service}},
+		{name: "services without pods", kinds: []string{resource.Service}, wantErr: true},
+		{name: "namespaces without pods", kinds: []string{resource.Namespace}, wantErr: true},
+		{name: "unsupported", kinds: []string{resource.Pod, "CronJob"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateKinds(tt.kinds); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestHarness(t *testing.T) {
+	if !AssetsAvailable() {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	ctx := context.Background()
+	h, err := Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := h.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if _, err = h.CreateDeployment(ctx, "default", "web", "node-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	uid, err := h.Subscribe(ctx, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{resource.Pod, resource.ReplicaSet, resource.Deployment, resource.Namespace} {
+		if _, err = h.WaitForEvent(kind, events.Create, "node-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pods created afterwards are sent to the subscriber of their node only.
+	if _, err = h.CreatePod(ctx, "default", "other", "node-2", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.CreatePod(ctx, "default", "api", "node-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	evt, err := h.WaitForEvent(resource.Pod, events.Create, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := evt.Subscribers()[uid]; !ok {
+		t.Errorf("expected the event to be destined to subscriber %q, got %v", uid, evt.Subscribers())
+	}
+	if meta := evt.GRPCMessage().GetMeta(); meta == "" {
+		t.Error("expected the metadata of the pod in the event")
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// templateHash is the pod-template-hash of the replicasets created for the deployments.
+const templateHash = "5d8f7c9b6"
+
+// CreateNamespace creates a namespace with the given name.
+func (h *Harness) CreateNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := h.Client.Create(ctx, ns); err != nil {
+		return nil, fmt.Errorf("creating namespace %q: %w", name, err)
+	}
+	return ns, nil
+}
+
+// CreatePod creates a pod scheduled on the given node. There is no kubelet running, so the harness sets the pod as
+// running and assigns it an IP, as the kubelet would.
+func (h *Harness) CreatePod(ctx context.Context, namespace, name, node string, labels map[string]string) (*corev1.Pod,
+	error) {
+	pod := newPod(namespace, name, node, labels)
+	if err := h.createPod(ctx, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// CreateDeployment creates a deployment with the given number of replicas scheduled on the given node. There is no
+// controller manager running, so the harness creates the replicaset and the pods owned by the deployment, as the
+// deployment and replicaset controllers would. The pods are labeled with app set to the name of the deployment.
+func (h *Harness) CreateDeployment(ctx context.Context, namespace, name, node string, replicas int32) (*appsv1.Deployment,
+	error) {
+	labels := map[string]string{"app": name}
+	dpl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: podTemplate(labels),
+		},
+	}
+	if err := h.Client.Create(ctx, dpl); err != nil {
+		return nil, fmt.Errorf("creating deployment %q: %w", name, err)
+	}
+
+	rsLabels := map[string]string{"app": name, appsv1.DefaultDeploymentUniqueLabelKey: templateHash}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-" + templateHash,
+			Namespace:       namespace,
+			Labels:          rsLabels,
+			OwnerReferences: []metav1.OwnerReference{ownerReference(dpl, "Deployment")},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: rsLabels},
+			Template: podTemplate(rsLabels),
+		},
+	}
+	if err := h.Client.Create(ctx, rs); err != nil {
+		return nil, fmt.Errorf("creating replicaset %q: %w", rs.Name, err)
+	}
+
+	for i := int32(0); i < replicas; i++ {
+		pod := newPod(namespace, fmt.Sprintf("%s-%05d", rs.Name, i), node, rsLabels)
+		pod.GenerateName = rs.Name + "-"
+		pod.OwnerReferences = []metav1.OwnerReference{ownerReference(rs, "ReplicaSet")}
+		if err := h.createPod(ctx, pod); err != nil {
+			return nil, err
+		}
+	}
+	return dpl, nil
+}
+
+// createPod creates the pod and sets it as running with an IP.
+func (h *Harness) createPod(ctx context.Context, pod *corev1.Pod) error {
+	if err := h.Client.Create(ctx, pod); err != nil {
+		return fmt.Errorf("creating pod %q: %w", pod.Name, err)
+	}
+	ip := h.lastIP.Add(1)
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.PodIP = fmt.Sprintf("10.%d.%d.%d", ip>>16&0xff, ip>>8&0xff, ip&0xff)
+	if err := h.Client.Status().Update(ctx, pod); err != nil {
+		return fmt.Errorf("updating the status of pod %q: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// newPod returns a pod scheduled on the given node.
+func newPod(namespace, name, node string, labels map[string]string) *corev1.Pod {
+	template := podTemplate(labels)
+	template.Spec.NodeName = node
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       template.Spec,
+	}
+}
+
+// podTemplate returns a pod template with the given labels.
+func podTemplate(labels map[string]string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+}
+
+// ownerReference returns the controller reference to the given owner.
+func ownerReference(owner metav1.Object, kind string) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+		Controller: ptr.To(true),
+	}
+}
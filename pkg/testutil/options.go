@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"time"
+
+	"github.com/falcosecurity/k8s-metacollector/collectors"
+	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
+)
+
+const (
+	// DefaultTimeout is the default timeout of the waits of the harness.
+	DefaultTimeout = 30 * time.Second
+)
+
+// DefaultCollectors are the resource kinds whose collectors are run by default, the built-in ones.
+var DefaultCollectors = []string{resource.Pod, resource.Namespace, resource.Deployment, resource.ReplicaSet,
+	resource.Daemonset, resource.ReplicationController, resource.Service}
+
+// Option is a functional option for the harness.
+type Option func(opts *options)
+
+type options struct {
+	kinds          []string
+	collectorOpts  []collectors.CollectorOption
+	crdDirectories []string
+	timeout        time.Duration
+}
+
+// WithCollectors sets the resource kinds whose collectors are run, among the registered ones, e.g. the collector of
+// a CRD registered by a downstream fork. The collectors they depend on must be included, e.g. the pod collector.
+func WithCollectors(kinds ...string) Option {
+	return func(opts *options) {
+		opts.kinds = kinds
+	}
+}
+
+// WithCollectorOptions sets the options passed to all the collectors, after the ones set by the harness.
+func WithCollectorOptions(collectorOpts ...collectors.CollectorOption) Option {
+	return func(opts *options) {
+		opts.collectorOpts = append(opts.collectorOpts, collectorOpts...)
+	}
+}
+
+// WithCRDDirectoryPaths sets the directories of the CRDs installed in the api-server.
+func WithCRDDirectoryPaths(paths ...string) Option {
+	return func(opts *options) {
+		opts.crdDirectories = append(opts.crdDirectories, paths...)
+	}
+}
+
+// WithTimeout sets the timeout of the waits of the harness: the initial sync of the collectors, the events and the
+// shutdown. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = timeout
+	}
+}
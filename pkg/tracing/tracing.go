@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// instrumentationName is the name of the tracer used by the meta collector.
+	instrumentationName = "github.com/falcosecurity/k8s-metacollector"
+	// serviceName is the name of the service reported in the exported spans.
+	serviceName = "k8s-metacollector"
+)
+
+const (
+	// KindKey is the attribute holding the kind of the resource handled in the span.
+	KindKey = attribute.Key("metacollector.resource.kind")
+	// NamespaceKey is the attribute holding the namespace of the resource handled in the span.
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	// NodeKey is the attribute holding the name of the node handled in the span.
+	NodeKey = attribute.Key("k8s.node.name")
+	// NodesKey is the attribute holding the number of nodes related to the resource.
+	NodesKey = attribute.Key("metacollector.nodes")
+	// SubscribersKey is the attribute holding the number of subscribers of the event or resource.
+	SubscribersKey = attribute.Key("metacollector.subscribers")
+	// ReasonKey is the attribute holding the type of the event, i.e. Create, Update or Delete.
+	ReasonKey = attribute.Key("metacollector.event.reason")
+)
+
+// defaultProvider is the global tracer provider in place before any provider is configured.
+var defaultProvider = otel.GetTracerProvider()
+
+// Tracer returns the tracer used to instrument the meta collector. Until Setup configures an exporter, the
+// returned tracer creates no-op spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Enabled returns true if a tracer provider has been configured. When it returns false the helpers of this package
+// return without allocating, so that the instrumentation costs nothing when the tracing is disabled.
+func Enabled() bool {
+	return otel.GetTracerProvider() != defaultProvider
+}
+
+// Start starts a span for the given operation on a resource of the given kind and namespace.
+func Start(ctx context.Context, operation, kind, namespace string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !Enabled() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	// The attributes are copied, so that the variadic slice of the callers does not escape to the heap.
+	all := make([]attribute.KeyValue, 0, len(attrs)+2)
+	all = append(all, attrs...)
+	all = append(all, KindKey.String(kind))
+	if namespace != "" {
+		all = append(all, NamespaceKey.String(namespace))
+	}
+	return Tracer().Start(ctx, operation, trace.WithAttributes(all...))
+}
+
+// StartWithParent starts a span as Start does, using as parent the given span context, e.g. the one carried by an
+// event.
+func StartWithParent(ctx context.Context, parent trace.SpanContext, operation, kind, namespace string,
+	attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !Enabled() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return Start(trace.ContextWithSpanContext(ctx, parent), operation, kind, namespace, attrs...)
+}
+
+// SetAttributes sets the attributes on the span if it is recording.
+func SetAttributes(span trace.Span, attrs ...attribute.KeyValue) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(append([]attribute.KeyValue(nil), attrs...)...)
+}
+
+// End records the error, if any, in the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Setup configures the global tracer provider based on the given configuration. It returns a function that
+// flushes the pending spans and shuts down the exporter. If the tracing is disabled, it does nothing.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var opts []otlptracegrpc.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the tracing resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Ratio()))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright 2023 The Falco Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/falcosecurity/k8s-metacollector/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupDisabled(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: config.TracingExporterNone})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if otel.GetTracerProvider() != previous {
+		t.Error("expected the tracer provider to be left untouched when tracing is disabled")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+}
+
+func TestStartAndEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, span := Start(context.Background(), "Reconcile", "Pod", "", NodesKey.Int(3))
+	End(span, errors.New("failure"))
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected one span, got %d", len(ended))
+	}
+	attrs := make(map[string]string)
+	for _, attr := range ended[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs[string(KindKey)] != "Pod" || attrs[string(NodesKey)] != "3" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	if _, ok := attrs[string(NamespaceKey)]; ok {
+		t.Error("expected no namespace attribute for an empty namespace")
+	}
+	if ended[0].Status().Code != codes.Error {
+		t.Errorf("expected the span status to be an error, got %v", ended[0].Status())
+	}
+}
+
+func TestDisabledAllocations(t *testing.T) {
+	if Enabled() {
+		t.Fatal("expected the tracing to be disabled until a tracer provider is configured")
+	}
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}})
+	ctx := context.Background()
+	allocs := testing.AllocsPerRun(100, func() {
+		spanCtx, span := StartWithParent(ctx, parent, "deliver", "Pod", "default", ReasonKey.String("Create"))
+		_, child := Start(spanCtx, "send", "Pod", "default", NodeKey.String("node"), SubscribersKey.Int(3))
+		SetAttributes(child, NodesKey.Int(2))
+		End(child, nil)
+		End(span, nil)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations with the tracing disabled, got %v", allocs)
+	}
+}
+
+func TestStartWithParent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	if !Enabled() {
+		t.Fatal("expected the tracing to be enabled once a tracer provider is configured")
+	}
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx, span := StartWithParent(context.Background(), parent, "deliver", "Pod", "")
+	_, child := Start(ctx, "send", "Pod", "", NodeKey.String("node-a"))
+	End(child, nil)
+	End(span, nil)
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("expected two spans, got %d", len(ended))
+	}
+	send, deliver := ended[0], ended[1]
+	if deliver.Parent().SpanID() != parent.SpanID() || deliver.SpanContext().TraceID() != parent.TraceID() {
+		t.Errorf("expected the span to be a child of %v, got parent %v", parent.SpanID(), deliver.Parent().SpanID())
+	}
+	if send.Parent().SpanID() != deliver.SpanContext().SpanID() {
+		t.Errorf("expected the send span to be a child of the deliver span")
+	}
+}
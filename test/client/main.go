@@ -37,6 +37,7 @@ func main() {
 		nodeName   = flag.String("node-name", "", "Name of the node used to subscribe.")
 		numClients = flag.Int("num-clients", 1, "Number of clients to create.")
 		noOutput   = flag.Bool("no-output", false, "When true does not print messages")
+		encoding   = flag.String("encoding", "JSON", "Encoding of the metadata, JSON, PROTOBUF or STRUCT.")
 	)
 
 	logOpts := zap.Options{
@@ -74,6 +75,7 @@ func main() {
 				resource.ReplicaSet: "",
 				resource.Deployment: "",
 			},
+			Encoding: metadata.Encoding(metadata.Encoding_value[*encoding]),
 		})
 
 		if err != nil {
@@ -86,6 +88,8 @@ func main() {
 			if !*noOutput {
 				fmt.Println("[")
 			}
+			// The events exceeding the maximum message size are received in chunks.
+			var chunks metadata.Reassembler
 			for {
 				in, err := stream.Recv()
 				if err == io.EOF {
@@ -101,6 +105,13 @@ func main() {
 					wait.Done()
 					return
 				}
+				if in, err = chunks.Add(in); err != nil {
+					logger.Error(err, "an error occurred while reassembling events")
+					continue
+				}
+				if in == nil {
+					continue
+				}
 
 				data, err := json.MarshalIndent(in, "", "  ")
 				if err != nil {
@@ -23,22 +23,36 @@ import (
 	"sync"
 
 	"github.com/falcosecurity/k8s-metacollector/metadata"
+	"github.com/falcosecurity/k8s-metacollector/pkg/events"
 	"github.com/falcosecurity/k8s-metacollector/pkg/resource"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type message struct {
-	grpcEvents map[string]*metadata.Event
-	rwLock     sync.RWMutex
+	grpcEvents       map[string]*metadata.Event
+	snapshotComplete bool
+	rwLock           sync.RWMutex
 }
 
 func (m *message) Add(item *metadata.Event) {
 	m.rwLock.Lock()
 	defer m.rwLock.Unlock()
+	// The SnapshotComplete event is not related to a resource.
+	if item.Reason == events.SnapshotComplete {
+		m.snapshotComplete = true
+		return
+	}
 	m.grpcEvents[item.Uid] = item
 }
 
+// SnapshotComplete returns true once the client has received all the existing resources.
+func (m *message) SnapshotComplete() bool {
+	m.rwLock.RLock()
+	defer m.rwLock.RUnlock()
+	return m.snapshotComplete
+}
+
 func (m *message) Get(uid string) (*metadata.Event, bool) {
 	m.rwLock.Lock()
 	defer m.rwLock.Unlock()
@@ -115,6 +129,8 @@ func (c *Client) Watch(ctx context.Context) error {
 		return fmt.Errorf("an error occurred while performing the watch procedure")
 	}
 	go func() {
+		// The events exceeding the maximum message size are received in chunks.
+		var chunks metadata.Reassembler
 		for {
 			select {
 			case <-ctx.Done():
@@ -131,7 +147,13 @@ func (c *Client) Watch(ctx context.Context) error {
 					fmt.Printf("an error occurred while receiving events: %s\n", err)
 					return
 				}
-				c.Add(in)
+				if in, err = chunks.Add(in); err != nil {
+					fmt.Printf("an error occurred while reassembling events: %s\n", err)
+					return
+				}
+				if in != nil {
+					c.Add(in)
+				}
 			}
 		}
 	}()
@@ -250,6 +250,8 @@ var _ = Describe("Clients on subscribe", func() {
 		})
 
 		It("Should not receive events at all", func(ctx SpecContext) {
+			// The snapshot of a node without resources is empty.
+			Eventually(client.SnapshotComplete).WithContext(ctx).Should(BeTrue())
 			Consistently(func() bool {
 				n := client.NumMessages()
 				return n == 0